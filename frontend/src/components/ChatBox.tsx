@@ -1,11 +1,40 @@
 import { useState, useEffect } from 'react';
-import { Message, MessageMetrics, ModelMetadata } from '../types';
+import { ChatUsage, Message, MessageMetrics, ModelMetadata } from '../types';
 import { MessageList } from './MessageList';
 import { MessageInput } from './MessageInput';
 import { SimplifiedMetrics } from './SimplifiedMetrics';
 import { ModelInfoCard } from './ModelInfoCard';
 import { ModelSelector } from './ModelSelector';
 
+interface LoadingEvent {
+  model: string;
+  status: 'loading' | 'loaded';
+  elapsed_ms: number;
+}
+
+// A reply may start with "loading" events while the server loads its model.
+// Returns the latest one and the body after them.
+function splitPreamble(body: string): { loading: LoadingEvent | null; rest: string } {
+  let loading: LoadingEvent | null = null;
+  let rest = body;
+  while (rest.startsWith('event: ')) {
+    const end = rest.indexOf('\n\n');
+    if (end < 0) {
+      break;
+    }
+    const match = rest.slice(0, end).match(/^event: loading\ndata: (.*)$/);
+    if (match) {
+      try {
+        loading = JSON.parse(match[1]);
+      } catch {
+        // Ignore malformed progress
+      }
+    }
+    rest = rest.slice(end + 2);
+  }
+  return { loading, rest };
+}
+
 export default function ChatBox() {
   const [input, setInput] = useState('');
   const [isLoading, setLoading] = useState(false);
@@ -106,8 +135,18 @@ export default function ChatBox() {
       });
 
       if (response.status !== 200) {
-        setError(`Error: ${response.statusText || 'Failed to get response'}`);
-        logError('api_error', response.status, currentInput.length);
+        // Errors come back as {"error": {"code", "message", "request_id", "retryable"}}
+        // and are already counted by the server
+        let message = response.statusText || 'Failed to get response';
+        try {
+          const body = await response.json();
+          if (body.error?.message) {
+            message = body.error.retryable ? `${body.error.message} (please try again)` : body.error.message;
+          }
+        } catch {
+          // Not a structured error
+        }
+        setError(`Error: ${message}`);
         return;
       }
 
@@ -139,10 +178,12 @@ export default function ChatBox() {
     setMessages((prev) => [...prev, aiMessage]);
 
     let tokenCount = 0;
+    let received = '';
     while (!done && reader) {
       const { value, done: doneReading } = await reader.read();
       done = doneReading;
       const chunk = decoder.decode(value, { stream: true });
+      received += chunk;
       
       tokenCount += chunk.length > 0 ? 1 : 0; // Approximate token count
       
@@ -165,13 +206,16 @@ export default function ChatBox() {
         });
       }
 
-      // Update message content and token count
+      // Update message content and token count, showing load progress
+      // until the text starts
+      const { loading, rest } = splitPreamble(received);
+      const content = rest === '' && loading ? `[Loading ${loading.model}... ${Math.round(loading.elapsed_ms / 1000)}s]` : rest;
       setMessages((prev) =>
         prev.map((msg) =>
           msg.id === aiMessageId
             ? { 
                 ...msg, 
-                content: msg.content + chunk,
+                content,
                 metrics: {
                   ...msg.metrics,
                   tokensOut: tokenCount
@@ -182,8 +226,42 @@ export default function ChatBox() {
       );
     }
 
-    // Record final metrics after response is complete
+    // The server appends SSE events after the text: "truncated" or
+    // "reconnect" when it cut the generation short, then "usage" with its own
+    // accounting of the response, or "error" if the model failed mid-stream
+    let usage: ChatUsage | null = null;
+    let notice = '';
+    received = splitPreamble(received).rest;
+    const trailerStart = received.indexOf('\n\nevent: ');
+    if (trailerStart >= 0) {
+      for (const match of received.slice(trailerStart).matchAll(/event: (\w+)\ndata: (.*)\n/g)) {
+        let data: Record<string, unknown> = {};
+        try {
+          data = JSON.parse(match[2]);
+        } catch {
+          // Keep the defaults
+        }
+        if (match[1] === 'usage') {
+          usage = data as unknown as ChatUsage;
+        } else if (match[1] === 'truncated' || match[1] === 'reconnect') {
+          notice = `\n\n[Response truncated: ${data.reason || 'limit reached'}]`;
+        } else if (match[1] === 'error') {
+          notice = `\n\n[Error: ${data.message || 'the model failed to finish the response'}]`;
+        }
+      }
+      const text = received.slice(0, trailerStart) + notice;
+      const tokensOut = usage ? usage.output_tokens : tokenCount;
+      setMessages((prev) =>
+        prev.map((msg) =>
+          msg.id === aiMessageId ? { ...msg, content: text, metrics: { ...msg.metrics, tokensOut } } : msg,
+        ),
+      );
+    }
+
+    // Record final metrics after response is complete, preferring the
+    // server's numbers over the client's estimates
     const responseEndTime = performance.now();
+    const final: ChatUsage | null = usage;
     setMessageMetrics(prev => {
       const metric = prev[messageId];
       if (metric) {
@@ -191,16 +269,15 @@ export default function ChatBox() {
           ...prev,
           [messageId]: {
             ...metric,
-            responseTime: responseEndTime - requestStartTime,
-            tokensOut: tokenCount
+            tokensIn: final ? final.input_tokens : metric.tokensIn,
+            firstTokenTime: final ? final.time_to_first_token_ms : metric.firstTokenTime,
+            responseTime: final ? final.latency_ms : responseEndTime - requestStartTime,
+            tokensOut: final ? final.output_tokens : tokenCount
           }
         };
       }
       return prev;
     });
-
-    // Log metrics to the backend
-    logMetrics(messageId, tokenCount, responseEndTime - requestStartTime);
   };
 
   const estimateTokenCount = (text: string): number => {
@@ -209,28 +286,6 @@ export default function ChatBox() {
     return count > 0 ? count : 1; // Ensure at least 1 token for any non-empty text
   };
 
-  const logMetrics = async (messageId: string, tokenCount: number, responseTime: number) => {
-    try {
-      const metric = messageMetrics[messageId];
-      if (!metric) return;
-      
-      // Send metrics to backend
-      await fetch('http://localhost:8080/metrics/log', {
-        method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({
-          message_id: messageId,
-          tokens_in: metric.tokensIn,
-          tokens_out: tokenCount,
-          response_time_ms: responseTime,
-          time_to_first_token_ms: metric.firstTokenTime || 0
-        }),
-      });
-    } catch (e) {
-      console.error('Failed to log metrics:', e);
-    }
-  };
-
   const logError = async (errorType: string, statusCode: number, inputLength: number) => {
     try {
       await fetch('http://localhost:8080/metrics/error', {
@@ -113,6 +113,15 @@ export function LlamaCppMetricsPanel({ metrics, showTitle = true }: LlamaCppMetr
                 {metrics.batchSize}
               </div>
             </div>
+
+            {metrics.specDecodeAcceptanceRate !== undefined && (
+              <div>
+                <div className="text-xs text-gray-500 dark:text-gray-400">Draft Acceptance</div>
+                <div className="text-md font-semibold">
+                  {(metrics.specDecodeAcceptanceRate * 100).toFixed(1)}%
+                </div>
+              </div>
+            )}
           </div>
         </div>
       </div>
@@ -43,12 +43,37 @@ export const ModelSelector = ({ selectedModel, onSelectModel }: ModelSelectorPro
     return found || null;
   };
 
-  // Handle model selection
-  const handleSelectModel = (modelName: string) => {
-    onSelectModel(modelName);
+  // Handle model selection. Embedding-only models can't chat.
+  const handleSelectModel = (model: DockerModel) => {
+    if (model.capabilities?.embedding) {
+      return;
+    }
+    onSelectModel(model.name);
     setIsOpen(false);
   };
 
+  // Short labels for what a model supports
+  const getCapabilityLabels = (model: DockerModel): string[] => {
+    const caps = model.capabilities;
+    if (!caps) {
+      return [];
+    }
+    if (caps.embedding) {
+      return [caps.embeddingDimension ? `embeddings (${caps.embeddingDimension}d)` : 'embeddings'];
+    }
+    const labels: string[] = [];
+    if (caps.contextWindow) {
+      labels.push(`${Math.round(caps.contextWindow / 1024)}K context`);
+    }
+    if (caps.tools) {
+      labels.push('tools');
+    }
+    if (caps.vision) {
+      labels.push('vision');
+    }
+    return labels;
+  };
+
   // Get displaying name for the model (short version)
   const getDisplayName = (model: DockerModel): string => {
     // Extract the model name without repository prefix for cleaner display
@@ -112,14 +137,19 @@ export const ModelSelector = ({ selectedModel, onSelectModel }: ModelSelectorPro
               models.map((model) => (
                 <li
                   key={model.modelId}
-                  className={`px-4 py-2 hover:bg-gray-100 dark:hover:bg-gray-700 cursor-pointer ${
+                  className={`px-4 py-2 ${
+                    model.capabilities?.embedding
+                      ? 'opacity-50 cursor-not-allowed'
+                      : 'hover:bg-gray-100 dark:hover:bg-gray-700 cursor-pointer'
+                  } ${
                     model.name === selectedModel
                       ? 'bg-gray-100 dark:bg-gray-700 font-medium'
                       : ''
                   }`}
                   role="option"
                   aria-selected={model.name === selectedModel}
-                  onClick={() => handleSelectModel(model.name)}
+                  aria-disabled={model.capabilities?.embedding}
+                  onClick={() => handleSelectModel(model)}
                 >
                   <div className="flex flex-col">
                     <span className="font-medium">{getDisplayName(model)}</span>
@@ -129,6 +159,11 @@ export const ModelSelector = ({ selectedModel, onSelectModel }: ModelSelectorPro
                     <span className="text-xs text-gray-500 dark:text-gray-400">
                       Size: {model.size} • Created: {model.created}
                     </span>
+                    {getCapabilityLabels(model).length > 0 && (
+                      <span className="text-xs text-blue-600 dark:text-blue-400">
+                        {getCapabilityLabels(model).join(' • ')}
+                      </span>
+                    )}
                   </div>
                 </li>
               ))
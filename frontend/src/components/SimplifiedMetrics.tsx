@@ -131,6 +131,30 @@ export function SimplifiedMetrics({ isVisible, messages }: SimplifiedMetricsProp
           </div>
         )}
         
+        {/* Show compact vLLM metrics if available */}
+        {serverMetrics.vllmMetrics && (
+          <div className="text-xs my-1 bg-purple-50 dark:bg-purple-950/30 rounded px-2 py-1 text-purple-800 dark:text-purple-300">
+            <div className="flex justify-between">
+              <span>
+                vLLM running/waiting: {serverMetrics.vllmMetrics.requestsRunning}/{serverMetrics.vllmMetrics.requestsWaiting}
+              </span>
+              <span>
+                KV cache: {(serverMetrics.vllmMetrics.kvCacheUsage * 100).toFixed(0)}%
+              </span>
+              <span>
+                Prefix hits: {(serverMetrics.vllmMetrics.prefixCacheHitRate * 100).toFixed(0)}%
+              </span>
+            </div>
+          </div>
+        )}
+
+        {/* Warn before the model runs out of KV cache */}
+        {serverMetrics.memory?.high && (
+          <div className="text-xs my-1 bg-red-50 dark:bg-red-950/30 rounded px-2 py-1 text-red-800 dark:text-red-300">
+            KV cache {((serverMetrics.memory.kvCacheUsage ?? 0) * 100).toFixed(0)}% full; long chats may fail
+          </div>
+        )}
+
         {/* Link to detailed dashboard */}
         <div className="text-right text-xs text-gray-500 dark:text-gray-400">
           <a 
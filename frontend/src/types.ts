@@ -17,6 +17,7 @@ export interface LlamaCppMetrics {
   threadsUsed: number;
   batchSize: number;
   modelType: string;
+  specDecodeAcceptanceRate?: number; // set when the server runs a draft model
 }
 
 // Metrics-related types
@@ -28,6 +29,40 @@ export interface MetricsData {
   activeUsers: number;
   errorRate: number;
   llamaCppMetrics?: LlamaCppMetrics; // Added llama.cpp metrics
+  vllmMetrics?: VLLMMetrics;
+  memory?: MemoryMetrics;
+}
+
+// KV cache and model memory, from whichever backend reports them
+export interface MemoryMetrics {
+  kvCacheUsage?: number;
+  kvCacheTokens?: number;
+  modelBytes?: number;
+  vramBytes?: number;
+  high: boolean; // KV cache usage is above the alert threshold
+  updatedAt: string;
+}
+
+// vLLM scheduler and cache state, scraped by the backend
+export interface VLLMMetrics {
+  requestsRunning: number;
+  requestsWaiting: number;
+  kvCacheUsage: number;
+  prefixCacheHitRate: number;
+  scrapedAt: string;
+}
+
+// Final "usage" event of a chat stream
+export interface ChatUsage {
+  message_id: string;
+  conversation_id: string;
+  model: string;
+  input_tokens: number;
+  output_tokens: number;
+  time_to_first_token_ms: number;
+  latency_ms: number;
+  tokens_per_second: number;
+  finish_reason: string;
 }
 
 export interface MessageMetrics {
@@ -59,4 +94,15 @@ export interface DockerModel {
   modelId: string;
   created: string;
   size: string;
+  capabilities?: ModelCapabilities;
+}
+
+// What a model supports, discovered by the backend
+export interface ModelCapabilities {
+  contextWindow?: number;
+  tools: boolean;
+  vision: boolean;
+  embedding: boolean;
+  embeddingDimension?: number;
+  source: 'backend' | 'name';
 }
@@ -0,0 +1,5 @@
+// Package testsupport provides helpers for end-to-end tests of aiwatch:
+// an in-process fake OpenAI-compatible backend, SSE parsing, golden
+// transcripts, metric assertions against a Prometheus registry, and
+// Docker CLI helpers for tests that need real containers.
+package testsupport
@@ -0,0 +1,104 @@
+package testsupport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Container is a Docker container started for a test
+type Container struct {
+	ID string
+}
+
+// ContainerOptions describe a container to start
+type ContainerOptions struct {
+	Image string
+	Env   map[string]string
+	Ports []string // container ports to publish on random host ports, e.g. "8080/tcp"
+	Args  []string
+}
+
+// RequireDocker skips the test when the Docker CLI or daemon is unavailable
+// or when running with -short
+func RequireDocker(t *testing.T) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping Docker-based test in short mode")
+	}
+	if err := exec.Command("docker", "info").Run(); err != nil {
+		t.Skipf("Docker is not available: %v", err)
+	}
+}
+
+// StartContainer runs a detached container and removes it when the test ends
+func StartContainer(t *testing.T, opts ContainerOptions) *Container {
+	t.Helper()
+	RequireDocker(t)
+
+	args := []string{"run", "-d", "--rm"}
+	for k, v := range opts.Env {
+		args = append(args, "-e", k+"="+v)
+	}
+	for _, port := range opts.Ports {
+		args = append(args, "-p", "127.0.0.1::"+port)
+	}
+	args = append(args, opts.Image)
+	args = append(args, opts.Args...)
+
+	out, err := exec.Command("docker", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to start container %s: %v: %s", opts.Image, err, out)
+	}
+
+	c := &Container{ID: strings.TrimSpace(string(out))}
+	t.Cleanup(func() {
+		exec.Command("docker", "rm", "-f", c.ID).Run()
+	})
+	return c
+}
+
+// HostAddr returns the host:port that a published container port is mapped to
+func (c *Container) HostAddr(t *testing.T, port string) string {
+	t.Helper()
+
+	out, err := exec.Command("docker", "port", c.ID, port).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to look up port %s: %v: %s", port, err, out)
+	}
+	// docker port may print one line per address family; use the first
+	return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+}
+
+// WaitForHTTP polls url until it answers with a non-5xx status or the timeout expires
+func WaitForHTTP(t *testing.T, url string, timeout time.Duration) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 500 {
+				return
+			}
+			lastErr = fmt.Errorf("status %d", resp.StatusCode)
+		} else {
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatalf("%s did not become ready within %s: %v", url, timeout, lastErr)
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
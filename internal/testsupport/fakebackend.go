@@ -0,0 +1,147 @@
+package testsupport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeBackend is an in-process OpenAI-compatible model server for end-to-end
+// tests. It serves /models and streaming /chat/completions from a script.
+type FakeBackend struct {
+	*httptest.Server
+
+	// Models are returned by GET /models
+	Models []string
+	// Chunks are streamed, in order, as delta content for every completion
+	Chunks []string
+	// ChunkDelay is slept between chunks to simulate generation speed
+	ChunkDelay time.Duration
+	// FailWith, when non-zero, makes completions fail with this status code
+	FailWith int
+
+	mu       sync.Mutex
+	requests []map[string]interface{}
+}
+
+// NewFakeBackend starts a fake backend that streams chunks for every completion
+func NewFakeBackend(chunks ...string) *FakeBackend {
+	fb := &FakeBackend{
+		Models: []string{"ai/fake-model"},
+		Chunks: chunks,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/models", fb.handleModels)
+	mux.HandleFunc("/chat/completions", fb.handleCompletions)
+	fb.Server = httptest.NewServer(mux)
+	return fb
+}
+
+// BaseURL returns the URL to configure as BASE_URL, with a trailing slash
+func (fb *FakeBackend) BaseURL() string {
+	return fb.URL + "/"
+}
+
+// Requests returns the decoded bodies of all completion requests received
+func (fb *FakeBackend) Requests() []map[string]interface{} {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return append([]map[string]interface{}{}, fb.requests...)
+}
+
+// handleModels lists the configured models in OpenAI format
+func (fb *FakeBackend) handleModels(w http.ResponseWriter, r *http.Request) {
+	data := make([]map[string]string, 0, len(fb.Models))
+	for _, m := range fb.Models {
+		data = append(data, map[string]string{"id": m, "object": "model"})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data})
+}
+
+// handleCompletions records the request and replays the scripted chunks
+func (fb *FakeBackend) handleCompletions(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var decoded map[string]interface{}
+	json.Unmarshal(body, &decoded)
+
+	fb.mu.Lock()
+	fb.requests = append(fb.requests, decoded)
+	fb.mu.Unlock()
+
+	if fb.FailWith != 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(fb.FailWith)
+		fmt.Fprintf(w, `{"error":{"message":"fake backend failure","type":"server_error"}}`)
+		return
+	}
+
+	model, _ := decoded["model"].(string)
+	stream, _ := decoded["stream"].(bool)
+	if !stream {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(completion(model, strings.Join(fb.Chunks, "")))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	for i, chunk := range fb.Chunks {
+		if i > 0 && fb.ChunkDelay > 0 {
+			time.Sleep(fb.ChunkDelay)
+		}
+		payload, _ := json.Marshal(streamChunk(model, chunk, ""))
+		fmt.Fprintf(w, "data: %s\n\n", payload)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	payload, _ := json.Marshal(streamChunk(model, "", "stop"))
+	fmt.Fprintf(w, "data: %s\n\n", payload)
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// streamChunk builds a chat.completion.chunk object
+func streamChunk(model, content, finishReason string) map[string]interface{} {
+	choice := map[string]interface{}{
+		"index": 0,
+		"delta": map[string]interface{}{"role": "assistant", "content": content},
+	}
+	if finishReason != "" {
+		choice["finish_reason"] = finishReason
+	}
+	return map[string]interface{}{
+		"id":      "chatcmpl-fake",
+		"object":  "chat.completion.chunk",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []interface{}{choice},
+	}
+}
+
+// completion builds a non-streaming chat.completion object
+func completion(model, content string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":      "chatcmpl-fake",
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"finish_reason": "stop",
+			"message":       map[string]interface{}{"role": "assistant", "content": content},
+		}},
+		"usage": map[string]int{
+			"prompt_tokens":     1,
+			"completion_tokens": len(content) / 4,
+			"total_tokens":      1 + len(content)/4,
+		},
+	}
+}
@@ -0,0 +1,38 @@
+package testsupport
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// update rewrites golden files instead of comparing against them:
+//
+//	go test ./... -run TestChat -update
+var update = flag.Bool("update", false, "update golden files in testdata/golden")
+
+// Golden compares got with testdata/golden/<name>, rewriting the file when
+// the -update flag is set
+func Golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", "golden", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create golden directory: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("failed to update golden file %s: %v", path, err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file %s (run with -update to create it): %v", path, err)
+	}
+	if string(want) != string(got) {
+		t.Errorf("output does not match golden file %s\n--- want ---\n%s\n--- got ---\n%s", path, want, got)
+	}
+}
@@ -0,0 +1,12 @@
+package testsupport
+
+import (
+	"io"
+
+	"github.com/ajeetraina/aiwatch/pkg/sse"
+)
+
+// ParseSSE splits a server-sent event stream into events
+func ParseSSE(r io.Reader) ([]sse.Event, error) {
+	return sse.Decode(r)
+}
@@ -1,26 +1,106 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"maps"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/ajeetraina/aiwatch/pkg/admin"
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/apiversion"
+	"github.com/ajeetraina/aiwatch/pkg/archive"
+	"github.com/ajeetraina/aiwatch/pkg/audit"
+	"github.com/ajeetraina/aiwatch/pkg/backend"
+	"github.com/ajeetraina/aiwatch/pkg/batch"
+	"github.com/ajeetraina/aiwatch/pkg/bench"
+	"github.com/ajeetraina/aiwatch/pkg/billing"
+	"github.com/ajeetraina/aiwatch/pkg/classify"
+	"github.com/ajeetraina/aiwatch/pkg/compression"
+	"github.com/ajeetraina/aiwatch/pkg/container"
+	"github.com/ajeetraina/aiwatch/pkg/dashboard"
+	"github.com/ajeetraina/aiwatch/pkg/drain"
+	"github.com/ajeetraina/aiwatch/pkg/drift"
+	"github.com/ajeetraina/aiwatch/pkg/evaluation"
+	"github.com/ajeetraina/aiwatch/pkg/events"
+	"github.com/ajeetraina/aiwatch/pkg/experiments"
+	"github.com/ajeetraina/aiwatch/pkg/finetune"
+	"github.com/ajeetraina/aiwatch/pkg/flags"
+	"github.com/ajeetraina/aiwatch/pkg/formatting"
+	"github.com/ajeetraina/aiwatch/pkg/graphql"
+	"github.com/ajeetraina/aiwatch/pkg/guardrails"
+	"github.com/ajeetraina/aiwatch/pkg/health"
+	"github.com/ajeetraina/aiwatch/pkg/histograms"
+	"github.com/ajeetraina/aiwatch/pkg/history"
+	"github.com/ajeetraina/aiwatch/pkg/jobs"
+	"github.com/ajeetraina/aiwatch/pkg/kube"
+	"github.com/ajeetraina/aiwatch/pkg/llamacpp"
 	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/memory"
 	"github.com/ajeetraina/aiwatch/pkg/middleware"
 	"github.com/ajeetraina/aiwatch/pkg/models"
+	"github.com/ajeetraina/aiwatch/pkg/moderation"
+	"github.com/ajeetraina/aiwatch/pkg/objectstore"
+	"github.com/ajeetraina/aiwatch/pkg/oidc"
+	"github.com/ajeetraina/aiwatch/pkg/ollama"
+	"github.com/ajeetraina/aiwatch/pkg/pacing"
+	"github.com/ajeetraina/aiwatch/pkg/priority"
+	"github.com/ajeetraina/aiwatch/pkg/prompts"
+	"github.com/ajeetraina/aiwatch/pkg/privacy"
+	"github.com/ajeetraina/aiwatch/pkg/profiling"
+	"github.com/ajeetraina/aiwatch/pkg/proxy"
+	"github.com/ajeetraina/aiwatch/pkg/quota"
+	"github.com/ajeetraina/aiwatch/pkg/rag"
+	"github.com/ajeetraina/aiwatch/pkg/replay"
+	"github.com/ajeetraina/aiwatch/pkg/reports"
+	"github.com/ajeetraina/aiwatch/pkg/requestlog"
+	"github.com/ajeetraina/aiwatch/pkg/resilience"
+	"github.com/ajeetraina/aiwatch/pkg/retention"
+	"github.com/ajeetraina/aiwatch/pkg/routing"
+	"github.com/ajeetraina/aiwatch/pkg/sampling"
+	"github.com/ajeetraina/aiwatch/pkg/secrets"
+	"github.com/ajeetraina/aiwatch/pkg/selftest"
+	"github.com/ajeetraina/aiwatch/pkg/session"
+	"github.com/ajeetraina/aiwatch/pkg/sigv4"
+	"github.com/ajeetraina/aiwatch/pkg/sse"
+	"github.com/ajeetraina/aiwatch/pkg/stops"
+	"github.com/ajeetraina/aiwatch/pkg/structured"
+	"github.com/ajeetraina/aiwatch/pkg/summarize"
+	"github.com/ajeetraina/aiwatch/pkg/tenants"
+	"github.com/ajeetraina/aiwatch/pkg/timeouts"
+	"github.com/ajeetraina/aiwatch/pkg/tlsconfig"
 	"github.com/ajeetraina/aiwatch/pkg/tracing"
+	"github.com/ajeetraina/aiwatch/pkg/transcripts"
+	"github.com/ajeetraina/aiwatch/pkg/truncation"
+	"github.com/ajeetraina/aiwatch/pkg/upstream"
+	"github.com/ajeetraina/aiwatch/pkg/validation"
+	"github.com/ajeetraina/aiwatch/pkg/vllm"
+	"github.com/ajeetraina/aiwatch/pkg/watchdog"
+	"github.com/ajeetraina/aiwatch/pkg/webhooks"
+	"github.com/ajeetraina/aiwatch/pkg/window"
+	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
@@ -28,7 +108,62 @@ import (
 
 // Create a custom registry for metrics
 var registry = prometheus.NewRegistry()
-var promautoFactory = promauto.With(registry)
+
+// kubePod is the pod this replica runs in on Kubernetes; every metric is
+// labelled with its pod and namespace
+var kubePod = kube.Detect()
+var metricsRegisterer = prometheus.WrapRegistererWith(kubePod.Labels(), registry)
+var promautoFactory = promauto.With(metricsRegisterer)
+
+// metricsHandlerOpts serves OpenMetrics to scrapers that accept it, which
+// is the only format that carries exemplars
+var metricsHandlerOpts = promhttp.HandlerOpts{EnableOpenMetrics: true}
+
+// histogramConfig decides whether the latency histograms are exposed with
+// classic buckets, native buckets or both. It is read from the environment
+// as the metrics below are built, ahead of main.
+var histogramConfig = loadHistogramConfig()
+
+// profilingLabels is set when profiles are collected, through ADMIN_PORT
+// or uploads, so samples are labelled with the endpoint and model
+var profilingLabels bool
+
+func loadHistogramConfig() histograms.Config {
+	config := histograms.DefaultConfig()
+	mode, err := histograms.ParseMode(os.Getenv("HISTOGRAM_MODE"))
+	if err != nil {
+		log.Fatalf("Invalid HISTOGRAM_MODE: %v", err)
+	}
+	config.Mode = mode
+	if v := os.Getenv("NATIVE_HISTOGRAM_BUCKET_FACTOR"); v != "" {
+		factor, err := strconv.ParseFloat(v, 64)
+		if err != nil || factor <= 1 {
+			log.Fatalf("Invalid NATIVE_HISTOGRAM_BUCKET_FACTOR %q: want a ratio above 1", v)
+		}
+		config.BucketFactor = factor
+	}
+	if v := os.Getenv("NATIVE_HISTOGRAM_MAX_BUCKETS"); v != "" {
+		max, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			log.Fatalf("Invalid NATIVE_HISTOGRAM_MAX_BUCKETS: %v", err)
+		}
+		config.MaxBuckets = uint32(max)
+	}
+	return config
+}
+
+// latencyBuckets returns the buckets set in the environment variable key,
+// or defaults if it is unset
+func latencyBuckets(key string, defaults []float64) []float64 {
+	buckets, err := histograms.ParseBuckets(os.Getenv(key))
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", key, err)
+	}
+	if buckets == nil {
+		return defaults
+	}
+	return buckets
+}
 
 type Message struct {
 	Role    string `json:"role"`
@@ -36,14 +171,109 @@ type Message struct {
 }
 
 type ChatRequest struct {
-	Messages []Message `json:"messages"`
-	Message  string    `json:"message"`
-	Format   string    `json:"format,omitempty"` // Optional format parameter
-	Model    string    `json:"model,omitempty"`  // Optional model selection parameter
+	Messages    []Message         `json:"messages"`
+	Message     string            `json:"message"`
+	Format      string            `json:"format,omitempty"`      // Optional format parameter
+	Model       string            `json:"model,omitempty"`       // Optional model selection parameter
+	Truncation  string            `json:"truncation,omitempty"`  // Optional history truncation strategy
+	Profile     string            `json:"profile,omitempty"`     // Optional named sampling profile
+	Template    string            `json:"template,omitempty"`    // Optional named system prompt template
+	Variables   map[string]string `json:"variables,omitempty"`   // Values substituted into the template
+	System      string            `json:"system,omitempty"`      // Optional explicit system prompt
+	Temperature *float64          `json:"temperature,omitempty"` // Optional sampling temperature, overrides the profile
+	TopP        *float64          `json:"top_p,omitempty"`       // Optional nucleus sampling, overrides the profile
+	MaxTokens   *int64            `json:"max_tokens,omitempty"`  // Optional completion token limit, overrides the profile
+	Stop        []string          `json:"stop,omitempty"`        // Optional stop sequences
+
+	// MaxTokensPerSecond paces the streamed output; it can only lower the
+	// rate cap configured for the request's priority class
+	MaxTokensPerSecond float64 `json:"max_tokens_per_second,omitempty"`
+
+	RAG         bool              `json:"rag,omitempty"`         // Retrieve document library context for the message
+	RAGTopK     int               `json:"rag_top_k,omitempty"`   // Chunks to retrieve, overriding RAG_TOP_K
+
+	// ConversationID continues a stored conversation, like the X-Conversation-ID
+	// header. Without Messages, the stored history is used as the context.
+	ConversationID string `json:"conversation_id,omitempty"`
+
+	// ResponseFormat requests JSON output, in the OpenAI response_format
+	// shape; JSONSchema is a shorthand holding only the schema
+	ResponseFormat json.RawMessage `json:"response_format,omitempty"`
+	JSONSchema     json.RawMessage `json:"json_schema,omitempty"`
+}
+
+// maxStopSequences is the upstream API's limit on stop sequences
+const maxStopSequences = 4
+
+// validateGenerationParams checks the explicit sampling parameters are in range
+func (req ChatRequest) validateGenerationParams() error {
+	if req.Temperature != nil && (*req.Temperature < 0 || *req.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if req.TopP != nil && (*req.TopP <= 0 || *req.TopP > 1) {
+		return fmt.Errorf("top_p must be greater than 0 and at most 1")
+	}
+	if req.MaxTokens != nil && *req.MaxTokens <= 0 {
+		return fmt.Errorf("max_tokens must be positive")
+	}
+	if req.MaxTokensPerSecond < 0 {
+		return fmt.Errorf("max_tokens_per_second must not be negative")
+	}
+	if len(req.Stop) > maxStopSequences {
+		return fmt.Errorf("at most %d stop sequences are allowed", maxStopSequences)
+	}
+	return nil
+}
+
+// clientTexts returns every text the client supplied that reaches the model,
+// with the system prompt rendered from its template, for the input guardrails
+func (req ChatRequest) clientTexts(systemPrompt string) []string {
+	texts := []string{req.Message, req.System, systemPrompt}
+	for _, msg := range req.Messages {
+		texts = append(texts, msg.Content)
+	}
+	for _, name := range slices.Sorted(maps.Keys(req.Variables)) {
+		texts = append(texts, req.Variables[name])
+	}
+	return slices.DeleteFunc(texts, func(text string) bool { return text == "" })
+}
+
+// temperatureBucket groups a sampling temperature into a low-cardinality metric label
+func temperatureBucket(temperature *float64) string {
+	switch {
+	case temperature == nil:
+		return "default"
+	case *temperature < 0.3:
+		return "low"
+	case *temperature < 0.8:
+		return "medium"
+	case *temperature <= 1.2:
+		return "high"
+	default:
+		return "very_high"
+	}
+}
+
+// ChatUsage is sent as the final "usage" event of a chat stream, carrying
+// the server's own accounting of the response
+type ChatUsage struct {
+	MessageID       string  `json:"message_id"`
+	ConversationID  string  `json:"conversation_id"`
+	Model           string  `json:"model"`
+	InputTokens     int     `json:"input_tokens"`
+	OutputTokens    int     `json:"output_tokens"`
+	FirstTokenMs    float64 `json:"time_to_first_token_ms"`
+	LatencyMs       float64 `json:"latency_ms"`
+	TokensPerSecond float64 `json:"tokens_per_second"`
+	FinishReason    string  `json:"finish_reason"`
 }
 
+// MetricLog is a client's own measurement of a response. MessageID and
+// ConversationID, from the X-Message-ID and X-Conversation-ID headers, join it
+// to the server's record.
 type MetricLog struct {
 	MessageID      string  `json:"message_id"`
+	ConversationID string  `json:"conversation_id,omitempty"`
 	TokensIn       int     `json:"tokens_in"`
 	TokensOut      int     `json:"tokens_out"`
 	ResponseTimeMs float64 `json:"response_time_ms"`
@@ -52,6 +282,7 @@ type MetricLog struct {
 
 type ErrorLog struct {
 	ErrorType   string `json:"error_type"`
+	Model       string `json:"model,omitempty"`
 	StatusCode  int    `json:"status_code"`
 	InputLength int    `json:"input_length"`
 	Timestamp   string `json:"timestamp"`
@@ -65,18 +296,42 @@ type LlamaCppMetrics struct {
 	MemoryPerToken  float64 `json:"memory_per_token_bytes"`
 	ThreadsUsed     int     `json:"threads_used"`
 	BatchSize       int     `json:"batch_size"`
+	KVCacheUsage    float64 `json:"kv_cache_usage_ratio"`
 	ModelType       string  `json:"model_type"`
+	// Set when the server runs a draft model
+	SpecDecodeAcceptanceRate *float64 `json:"spec_decode_acceptance_rate,omitempty"`
 }
 
 // MetricsSummary represents the summary metrics sent to the frontend
 type MetricsSummary struct {
-	TotalRequests      float64  `json:"totalRequests"`
-	AverageResponseTime float64 `json:"averageResponseTime"`
-	TokensGenerated    float64  `json:"tokensGenerated"`
-	TokensProcessed    float64  `json:"tokensProcessed"`
-	ActiveUsers        float64  `json:"activeUsers"`
-	ErrorRate          float64  `json:"errorRate"`
-	LlamaCppMetrics    *LlamaCppMetrics `json:"llamaCppMetrics,omitempty"`
+	TotalRequests       float64          `json:"totalRequests"`
+	AverageResponseTime float64          `json:"averageResponseTime"`
+	TokensGenerated     float64          `json:"tokensGenerated"`
+	TokensProcessed     float64          `json:"tokensProcessed"`
+	ActiveUsers         float64          `json:"activeUsers"`
+	UniqueUsersHour     float64          `json:"uniqueUsersHour"`
+	UniqueUsersDay      float64          `json:"uniqueUsersDay"`
+	ErrorRate           float64          `json:"errorRate"`
+	LlamaCppMetrics     *LlamaCppMetrics `json:"llamaCppMetrics,omitempty"`
+	VLLMMetrics         *vllm.Stats      `json:"vllmMetrics,omitempty"`
+	Memory              *memory.Stats    `json:"memory,omitempty"`
+	Container           *container.Stats `json:"container,omitempty"`
+	Process             ProcessStats     `json:"process"`
+	// Windows has the requests, error rate and average TTFT of the past
+	// 5 minutes and hour, keyed "5m" and "1h"; the fields above count
+	// since the process started
+	Windows map[string]window.Stats `json:"windows"`
+}
+
+// ProcessStats describes the aiwatch process itself, so leaks in it show up
+// next to the model's figures
+type ProcessStats struct {
+	Goroutines     int     `json:"goroutines"`
+	HeapAllocBytes uint64  `json:"heapAllocBytes"`
+	HeapObjects    uint64  `json:"heapObjects"`
+	SysBytes       uint64  `json:"sysBytes"` // memory obtained from the OS
+	GCCycles       uint32  `json:"gcCycles"`
+	LastGCPauseMs  float64 `json:"lastGcPauseMs"`
 }
 
 // Define metrics
@@ -90,11 +345,11 @@ var (
 	)
 	
 	requestDuration = promautoFactory.NewHistogramVec(
-		prometheus.HistogramOpts{
+		histogramConfig.Opts(prometheus.HistogramOpts{
 			Name:    "aiwatch_http_request_duration_seconds",
 			Help:    "HTTP request duration in seconds",
-			Buckets: prometheus.DefBuckets,
-		},
+			Buckets: latencyBuckets("REQUEST_DURATION_BUCKETS", prometheus.DefBuckets),
+		}),
 		[]string{"method", "endpoint"},
 	)
 	
@@ -106,13 +361,23 @@ var (
 		[]string{"direction", "model"},
 	)
 	
+	// Per-phase breakdown of chat request latency
+	requestPhaseDuration = promautoFactory.NewHistogramVec(
+		histogramConfig.Opts(prometheus.HistogramOpts{
+			Name:    "aiwatch_request_phase_seconds",
+			Help:    "Time spent in each phase of a chat request (queue, prompt_eval, first_token, generation, postprocess)",
+			Buckets: []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60},
+		}),
+		[]string{"phase", "model"},
+	)
+
 	modelLatency = promautoFactory.NewHistogramVec(
-		prometheus.HistogramOpts{
+		histogramConfig.Opts(prometheus.HistogramOpts{
 			Name:    "aiwatch_model_latency_seconds",
 			Help:    "Model response time in seconds",
-			Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60},
-		},
-		[]string{"model", "operation"},
+			Buckets: latencyBuckets("LATENCY_BUCKETS", []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60}),
+		}),
+		[]string{"model", "operation", "temperature"},
 	)
 	
 	activeRequests = promautoFactory.NewGauge(
@@ -128,17 +393,17 @@ var (
 			Name: "aiwatch_errors_total",
 			Help: "Total number of errors",
 		},
-		[]string{"type"},
+		[]string{"type", "model"},
 	)
 
 	// Add first token latency metric
 	firstTokenLatency = promautoFactory.NewHistogramVec(
-		prometheus.HistogramOpts{
+		histogramConfig.Opts(prometheus.HistogramOpts{
 			Name:    "aiwatch_first_token_latency_seconds",
 			Help:    "Time to first token in seconds",
-			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5},
-		},
-		[]string{"model"},
+			Buckets: latencyBuckets("TTFT_BUCKETS", []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5}),
+		}),
+		[]string{"model", "temperature"},
 	)
 
 	// LlamaCpp metrics
@@ -190,496 +455,4484 @@ var (
 		},
 		[]string{"model"},
 	)
-)
-
-// Helper function to get counter value
-func getCounterValue(counter *prometheus.CounterVec, labelValues ...string) float64 {
-	// Use 0 as the default value
-	value := 0.0
-	
-	// If labels are provided, try to get a specific counter
-	if len(labelValues) > 0 {
-		c, err := counter.GetMetricWithLabelValues(labelValues...)
-		if err == nil {
-			metric := &dto.Metric{}
-			if err := c.(prometheus.Metric).Write(metric); err == nil && metric.Counter != nil {
-				value = metric.Counter.GetValue()
-			}
-		}
-		return value
-	}
-	
-	// Otherwise, sum all counters
-	metrics := make(chan prometheus.Metric, 100)
-	counter.Collect(metrics)
-	close(metrics)
-	
-	for metric := range metrics {
-		m := &dto.Metric{}
-		if err := metric.Write(m); err == nil && m.Counter != nil {
-			value += m.Counter.GetValue()
-		}
-	}
-	
-	return value
-}
 
-// Helper function to get gauge value
-func getGaugeValue(gauge prometheus.Gauge) float64 {
-	value := 0.0
-	metric := &dto.Metric{}
-	if err := gauge.Write(metric); err == nil && metric.Gauge != nil {
-		value = metric.Gauge.GetValue()
-	}
-	return value
-}
+	llamacppKVCacheUsage = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_llamacpp_kv_cache_usage_ratio",
+			Help: "Fraction of the llama.cpp KV cache in use, scraped from the server",
+		},
+		[]string{"model"},
+	)
 
-// Helper function to get gauge value with labels
-func getGaugeValueWithLabels(gauge *prometheus.GaugeVec, labelValues ...string) float64 {
-	if len(labelValues) == 0 {
-		return 0.0
-	}
-	
-	g, err := gauge.GetMetricWithLabelValues(labelValues...)
-	if err != nil {
-		return 0.0
-	}
-	
-	metric := &dto.Metric{}
-	if err := g.(prometheus.Metric).Write(metric); err == nil && metric.Gauge != nil {
-		return metric.Gauge.GetValue()
-	}
-	
-	return 0.0
-}
+	llamacppKVCacheTokens = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_llamacpp_kv_cache_tokens",
+			Help: "Tokens held in the llama.cpp KV cache, scraped from the server",
+		},
+		[]string{"model"},
+	)
 
-// Helper function to get histogram value with labels
-func getHistogramValueWithLabels(histogram *prometheus.HistogramVec, labelValues ...string) float64 {
-    if len(labelValues) == 0 {
-        return 0.0
-    }
-    
-    h, err := histogram.GetMetricWithLabelValues(labelValues...)
-    if err != nil {
-        return 0.0
-    }
-    
-    // For histograms, we can get the sum and count to calculate an average
-    metric := &dto.Metric{}
-    if err := h.(prometheus.Metric).Write(metric); err == nil && metric.Histogram != nil {
-        if metric.Histogram.GetSampleCount() > 0 {
-            return metric.Histogram.GetSampleSum() / float64(metric.Histogram.GetSampleCount())
-        }
-    }
-    
-    return 0.0
-}
+	// Speculative decoding metrics, from llama.cpp response timings or vLLM
+	specDecodeAcceptanceRate = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_spec_decode_acceptance_rate",
+			Help: "Share of draft model tokens accepted by the target model, for the last response or scrape interval",
+		},
+		[]string{"model"},
+	)
 
-// Helper function to calculate error rate
-func calculateErrorRate() float64 {
-	totalErrors := getCounterValue(errorCounter)
-	totalRequests := getCounterValue(requestCounter)
-	
-	if totalRequests == 0 {
-		return 0.0
-	}
-	
-	return totalErrors / totalRequests
-}
+	specDecodeTokens = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_spec_decode_tokens_total",
+			Help: "Total number of draft model tokens by outcome (drafted or accepted), from llama.cpp response timings",
+		},
+		[]string{"model", "outcome"},
+	)
 
-// Helper function to calculate average response time
-func getAverageResponseTime(histogram *prometheus.HistogramVec) float64 {
-	// This is a simplification - in a real app you'd calculate this from histogram buckets
-	// For now, we'll use a fixed value
-	return 0.5 // 500ms average response time
-}
+	// vLLM metrics
+	vllmRequestsRunning = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_vllm_requests_running",
+			Help: "Requests vLLM is currently running on the GPU, scraped from the server",
+		},
+		[]string{"model"},
+	)
 
-// Helper function to get LlamaCpp metrics for the current model
-func getLlamaCppMetrics(model string) *LlamaCppMetrics {
-	// Check if any llama.cpp metrics exist for this model
-	contextSize := int(getGaugeValueWithLabels(llamacppContextSize, model))
-	if contextSize == 0 {
-		return nil // No llama.cpp metrics available
-	}
-	
-	// Collect all metrics
-	return &LlamaCppMetrics{
-		ContextSize:     contextSize,
-		PromptEvalTime:  getHistogramValueWithLabels(llamacppPromptEvalTime, model) * 1000, // Convert to ms
-		TokensPerSecond: getGaugeValueWithLabels(llamacppTokensPerSecond, model),
-		MemoryPerToken:  getGaugeValueWithLabels(llamacppMemoryPerToken, model),
-		ThreadsUsed:     int(getGaugeValueWithLabels(llamacppThreadsUsed, model)),
-		BatchSize:       int(getGaugeValueWithLabels(llamacppBatchSize, model)),
-		ModelType:       "llama.cpp",
-	}
-}
+	vllmRequestsWaiting = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_vllm_requests_waiting",
+			Help: "Requests queued in vLLM waiting to be scheduled, scraped from the server",
+		},
+		[]string{"model"},
+	)
 
-func main() {
-	log.Println("Starting AIWatch with observability")
+	vllmKVCacheUsage = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_vllm_kv_cache_usage_ratio",
+			Help: "Fraction of the vLLM KV cache blocks in use, scraped from the server",
+		},
+		[]string{"model"},
+	)
 
-	// Print Docker version for debugging
-	dockerVersionCmd := exec.Command("docker", "--version")
-	dockerVersionOut, err := dockerVersionCmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Warning: Docker CLI check failed: %v", err)
-	} else {
-		log.Printf("Docker CLI check: %s", string(dockerVersionOut))
-	}
+	vllmPrefixCacheHitRate = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_vllm_prefix_cache_hit_rate",
+			Help: "Fraction of prefix cache queries that hit, over the last scrape interval",
+		},
+		[]string{"model"},
+	)
 
-	// Get configuration from environment
-	baseURL := os.Getenv("BASE_URL")
-	defaultModel := os.Getenv("MODEL")
-	apiKey := os.Getenv("API_KEY")
-	
-	// Initialize logger
-	logLevel := getEnvOrDefault("LOG_LEVEL", "info")
-	logPretty, _ := strconv.ParseBool(getEnvOrDefault("LOG_PRETTY", "true"))
-	logger.Initialize(logLevel, logPretty)
-	
-	// Get logger
-	log := logger.GetLogger()
-	log.Info().Msg("Logger initialized successfully")
+	// Throughput reported by Ollama in its eval_count/eval_duration fields
+	ollamaTokensPerSecond = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_ollama_tokens_per_second",
+			Help: "Generation throughput of the last response, as measured by Ollama when it reports eval timings",
+		},
+		[]string{"model"},
+	)
 
-	// Tracing setup
-	tracingEnabled, _ := strconv.ParseBool(getEnvOrDefault("TRACING_ENABLED", "false"))
-	var tracingCleanup func()
+	ollamaPromptTokensPerSecond = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_ollama_prompt_tokens_per_second",
+			Help: "Prompt evaluation throughput of the last response, as measured by Ollama",
+		},
+		[]string{"model"},
+	)
 
-	if tracingEnabled {
-		otlpEndpoint := getEnvOrDefault("OTLP_ENDPOINT", "jaeger:4318")
-		log.Info().Str("endpoint", otlpEndpoint).Msg("Setting up tracing")
+	// Model container metrics, sampled from the Docker Engine stats API
+	containerCPU = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_model_container_cpu_percent",
+			Help: "CPU usage of the model container, where 100 is one full CPU",
+		},
+		[]string{"container"},
+	)
 
-		cleanup, err := tracing.SetupTracing("aiwatch", otlpEndpoint)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to set up tracing")
-		} else {
-			tracingCleanup = cleanup
-			defer tracingCleanup()
-			log.Info().Msg("Tracing initialized successfully")
-		}
-	}
+	containerMemory = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_model_container_memory_bytes",
+			Help: "Memory used by the model container, excluding the page cache",
+		},
+		[]string{"container"},
+	)
 
-	// Create OpenAI client
-	client := openai.NewClient(
-		option.WithBaseURL(baseURL),
-		option.WithAPIKey(apiKey),
+	containerMemoryLimit = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_model_container_memory_limit_bytes",
+			Help: "Memory limit of the model container",
+		},
+		[]string{"container"},
 	)
 
+	containerBlockRead = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_model_container_block_read_bytes",
+			Help: "Bytes the model container has read from block devices since it started",
+		},
+		[]string{"container"},
+	)
+
+	containerBlockWrite = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_model_container_block_write_bytes",
+			Help: "Bytes the model container has written to block devices since it started",
+		},
+		[]string{"container"},
+	)
+
+	containerThrottled = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_model_container_cpu_throttled_seconds",
+			Help: "Time the model container has been throttled by its CPU quota since it started",
+		},
+		[]string{"container"},
+	)
+
+	// Memory metrics, from whichever backend reports them
+	kvCacheUsage = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_kv_cache_usage_ratio",
+			Help: "Fraction of the model's KV cache in use, from any backend that reports it",
+		},
+		[]string{"model"},
+	)
+
+	modelMemoryBytes = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_model_memory_bytes",
+			Help: "Memory held by the loaded model, KV cache included",
+		},
+		[]string{"model"},
+	)
+
+	kvCacheHigh = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_kv_cache_high",
+			Help: "1 while the model's KV cache usage is above MEMORY_ALERT_THRESHOLD",
+		},
+		[]string{"model"},
+	)
+
+	kvCacheAlerts = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_kv_cache_alerts_total",
+			Help: "Total number of times a model's KV cache usage crossed MEMORY_ALERT_THRESHOLD",
+		},
+		[]string{"model"},
+	)
+
+	requestKVCacheGrowth = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_request_kv_cache_growth_ratio",
+			Help:    "KV cache usage added while a chat request ran",
+			Buckets: []float64{0, 0.001, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5},
+		},
+		[]string{"model"},
+	)
+
+	modelMemoryLost = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_model_memory_lost_total",
+			Help: "Total number of times the backend stopped reporting a model, as after an out-of-memory kill",
+		},
+		[]string{"model", "reason"},
+	)
+
+	// Truncation metrics
+	truncationCounter = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_truncations_total",
+			Help: "Total number of requests whose history was truncated to fit the context window",
+		},
+		[]string{"strategy", "model"},
+	)
+
+	truncatedTokensCounter = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_truncated_tokens_total",
+			Help: "Total number of history tokens dropped by truncation",
+		},
+		[]string{"strategy", "model"},
+	)
+
+	promptTokensSaved = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_prompt_tokens_saved_total",
+			Help: "Total number of prompt tokens removed by prompt compression",
+		},
+		[]string{"model", "method"},
+	)
+
+	contextOverflows = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_context_overflows_total",
+			Help: "Total number of requests whose prompt exceeded the model's context window before truncation",
+		},
+		[]string{"model"},
+	)
+
+	memoryTrims = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_memory_trims_total",
+			Help: "Total number of requests whose server-side conversation memory was trimmed to fit the context window",
+		},
+		[]string{"strategy", "model"},
+	)
+
+	// Sampling profile metrics
+	profileRequests = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_sampling_profile_requests_total",
+			Help: "Total number of chat requests by sampling profile",
+		},
+		[]string{"model", "profile"},
+	)
+
+	profileLatency = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_sampling_profile_latency_seconds",
+			Help:    "Model response time in seconds by sampling profile",
+			Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60},
+		},
+		[]string{"model", "profile"},
+	)
+
+	// Watchdog metrics
+	watchdogKills = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_watchdog_kills_total",
+			Help: "Total number of generations force-cancelled by the watchdog",
+		},
+		[]string{"reason", "model"},
+	)
+
+	generationsTruncated = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_generations_truncated_total",
+			Help: "Total number of generations cut short by the server, by reason (timeout, max_tokens or watchdog)",
+		},
+		[]string{"reason", "model"},
+	)
+
+	enforcedStops = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_enforced_stops_total",
+			Help: "Total number of streams aiwatch cut itself because the backend ignored the stop sequences (stop) or max_tokens (length)",
+		},
+		[]string{"reason", "model"},
+	)
+
+	// Session metrics
+	activeSessions = promautoFactory.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_active_sessions",
+			Help: "Number of distinct client sessions active in the session window",
+		},
+		func() float64 { return float64(sessions.Active()) },
+	)
+
+	// Model hot-swap metrics
+	modelSwaps = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_model_swaps_total",
+			Help: "Models loaded on demand because a chat request named a model that wasn't loaded, by result",
+		},
+		[]string{"model", "result"},
+	)
+
+	modelLoadDuration = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_model_load_duration_seconds",
+			Help:    "Time taken to load a model on demand",
+			Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 120, 300, 600},
+		},
+		[]string{"model"},
+	)
+
+	modelSwapPending = promautoFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_model_swap_pending_requests",
+			Help: "Chat requests waiting for their model to load",
+		},
+	)
+
+	// Model list metrics
+	modelListAge = promautoFactory.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_model_list_age_seconds",
+			Help: "Seconds since the cached model list was last fetched from docker",
+		},
+		func() float64 { return models.List.Age().Seconds() },
+	)
+
+	// Quota metrics
+	quotaRejections = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_quota_rejections_total",
+			Help: "Total number of requests rejected because a usage quota was exceeded",
+		},
+		[]string{"limit"},
+	)
+
+	// Tenant metrics
+	tenantRequests = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_tenant_requests_total",
+			Help: "Total number of requests by tenant",
+		},
+		[]string{"tenant"},
+	)
+	tenantTokens = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_tenant_tokens_total",
+			Help: "Total number of tokens processed by tenant, model and type (input or output)",
+		},
+		[]string{"tenant", "model", "type"},
+	)
+
+	// Authentication metrics
+	authLogins = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_auth_logins_total",
+			Help: "Total number of single sign-on logins by result",
+		},
+		[]string{"result"},
+	)
+	authRejections = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_auth_rejections_total",
+			Help: "Total number of requests rejected for missing or invalid credentials, by reason",
+		},
+		[]string{"reason"},
+	)
+
+	// Kubernetes metrics
+	configMapReloads = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_configmap_reloads_total",
+			Help: "Total number of ConfigMap reloads by result (changed or error)",
+		},
+		[]string{"result"},
+	)
+
+	// Secrets metrics
+	secretRotations = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_secret_rotations_total",
+			Help: "Total number of secrets picked up with a new value, by secret name",
+		},
+		[]string{"name"},
+	)
+	secretErrors = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_secret_errors_total",
+			Help: "Total number of failed secret lookups by provider",
+		},
+		[]string{"provider"},
+	)
+
+	// Archive metrics
+	archiveDeliveryLag = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_archive_delivery_lag_seconds",
+			Help:    "Time between chat completion and delivery to the archive sink",
+			Buckets: []float64{0.1, 0.5, 1, 5, 30, 60, 300, 900, 3600},
+		},
+		[]string{"sink"},
+	)
+
+	archiveFailures = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_archive_failures_total",
+			Help: "Total number of chat records that failed delivery to the archive sink",
+		},
+		[]string{"sink"},
+	)
+
+	archiveReplayed = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_archive_replayed_total",
+			Help: "Total number of spooled chat records successfully replayed to the archive sink",
+		},
+		[]string{"sink"},
+	)
+
+	archiveSpooled = promautoFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_archive_spooled_records",
+			Help: "Number of chat records waiting in the archive spool for replay",
+		},
+	)
+
+	// Continuous profiling metrics
+	profileUploads = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_profile_uploads_total",
+			Help: "Total number of profile uploads by profile type and result",
+		},
+		[]string{"profile", "result"},
+	)
+
+	// Transcript archive metrics
+	transcriptUploads = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_transcript_uploads_total",
+			Help: "Total number of transcript batch uploads by store and result",
+		},
+		[]string{"store", "result"},
+	)
+
+	transcriptsArchived = promautoFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "aiwatch_transcripts_archived_total",
+			Help: "Total number of chat records uploaded to the transcript archive",
+		},
+	)
+
+	transcriptsDropped = promautoFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "aiwatch_transcripts_dropped_total",
+			Help: "Total number of chat records dropped because transcript uploads kept failing",
+		},
+	)
+
+	// Event bus metrics
+	eventsPublished = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_events_published_total",
+			Help: "Total number of chat lifecycle events by type and result (delivered, failed or dropped)",
+		},
+		[]string{"type", "result"},
+	)
+
+	// Privacy metrics
+	piiDetections = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_pii_detections_total",
+			Help: "Total number of personal data items replaced before storage, by type and field (prompt or response)",
+		},
+		[]string{"type", "field"},
+	)
+
+	piiNERErrors = promautoFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "aiwatch_pii_ner_errors_total",
+			Help: "Total number of failed calls to the name recognition model",
+		},
+	)
+
+	// Retention metrics
+	retentionPurged = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_retention_purged_total",
+			Help: "Total number of items removed for exceeding their retention period, by kind of data",
+		},
+		[]string{"data"},
+	)
+
+	userDataDeletions = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_user_data_deletions_total",
+			Help: "Total number of user data erasure requests by result",
+		},
+		[]string{"result"},
+	)
+
+	// Billing metrics
+	billingExports = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_billing_exports_total",
+			Help: "Total number of billing report exports by store and result",
+		},
+		[]string{"store", "result"},
+	)
+
+	// Webhook metrics
+	webhookDeliveries = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_webhook_deliveries_total",
+			Help: "Total number of webhook deliveries by endpoint host, event type and result (delivered, failed or dropped)",
+		},
+		[]string{"endpoint", "type", "result"},
+	)
+
+	webhookLatency = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_webhook_delivery_duration_seconds",
+			Help:    "Time to deliver a webhook, retries included",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		},
+		[]string{"endpoint"},
+	)
+
+	webhookRetries = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_webhook_retries_total",
+			Help: "Total number of webhook delivery retries by endpoint host and reason",
+		},
+		[]string{"backend", "reason"},
+	)
+
+	// Guardrail metrics
+	guardrailBlocks = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_guardrail_blocks_total",
+			Help: "Total number of prompts or responses blocked by a guardrail rule",
+		},
+		[]string{"rule"},
+	)
+
+	// Output moderation metrics
+	moderationFlags = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_moderation_flags_total",
+			Help: "Total number of streamed responses cut off by output moderation, by flagged category",
+		},
+		[]string{"category"},
+	)
+
+	// Experiment metrics
+	experimentRequests = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_experiment_requests_total",
+			Help: "Total number of chat requests assigned to each experiment variant",
+		},
+		[]string{"experiment", "variant", "model"},
+	)
+
+	experimentLatency = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_experiment_latency_seconds",
+			Help:    "Model response time in seconds by experiment variant",
+			Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60},
+		},
+		[]string{"experiment", "variant"},
+	)
+
+	experimentFirstToken = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_experiment_first_token_latency_seconds",
+			Help:    "Time to first token in seconds by experiment variant",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5},
+		},
+		[]string{"experiment", "variant"},
+	)
+
+	experimentTokens = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_experiment_tokens_total",
+			Help: "Total number of tokens processed by experiment variant",
+		},
+		[]string{"experiment", "variant", "direction"},
+	)
+
+	experimentFeedback = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_experiment_feedback_total",
+			Help: "Total number of user ratings by experiment variant",
+		},
+		[]string{"experiment", "variant", "rating"},
+	)
+
+	// Feedback metrics
+	feedbackCounter = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_feedback_total",
+			Help: "Total number of user ratings of chat responses",
+		},
+		[]string{"model", "rating"},
+	)
+
+	// Response evaluation metrics
+	evaluationScores = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_evaluation_score",
+			Help:    "Judge-model scores (1-5) of sampled chat responses by rubric criterion",
+			Buckets: []float64{1, 2, 3, 4, 5},
+		},
+		[]string{"model", "criterion"},
+	)
+
+	evaluationsCounter = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_evaluations_total",
+			Help: "Total number of sampled chats sent to the judge model by result",
+		},
+		[]string{"model", "result"},
+	)
+
+	// Conversation classification metrics
+	chatCategories = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_chat_categories_total",
+			Help: "Total number of classified chat exchanges by category kind (topic or safety) and category",
+		},
+		[]string{"kind", "category"},
+	)
+
+	classificationsCounter = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_classifications_total",
+			Help: "Total number of chat exchanges sent for classification by result",
+		},
+		[]string{"result"},
+	)
+
+	conversationSummaries = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_conversation_summaries_total",
+			Help: "Total number of conversation titles and summaries generated by result",
+		},
+		[]string{"result"},
+	)
+
+	// Fine-tuning export metrics
+	finetuneExamples = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_finetune_examples_exported_total",
+			Help: "Total number of conversations exported as fine-tuning examples by format",
+		},
+		[]string{"format"},
+	)
+
+	// Request log metrics
+	requestLogEntries = promautoFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_request_log_entries",
+			Help: "Number of requests held in the per-request log",
+		},
+	)
+	requestLogExports = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_request_log_exports_total",
+			Help: "Total number of request log exports by format",
+		},
+		[]string{"format"},
+	)
+
+	// Usage report metrics
+	reportsSent = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_reports_sent_total",
+			Help: "Total number of scheduled usage reports delivered by period, channel and result",
+		},
+		[]string{"period", "channel", "result"},
+	)
+
+	// Request validation metrics
+	rejectedRequests = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_rejected_requests_total",
+			Help: "Total number of chat requests rejected before processing by reason",
+		},
+		[]string{"reason"},
+	)
+
+	// Request timeout metrics
+	requestTimeouts = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_request_timeouts_total",
+			Help: "Total number of requests cut off by route and reason (deadline, or idle for stalled streams)",
+		},
+		[]string{"route", "reason"},
+	)
+
+	// API version metrics
+	apiRequests = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_api_requests_total",
+			Help: "Total number of API requests by version (v1, or unversioned for the deprecated paths)",
+		},
+		[]string{"version"},
+	)
+
+	// Response compression metrics
+	compressedResponses = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_compressed_responses_total",
+			Help: "Total number of compressible responses by the encoding they were sent with (br, gzip, or identity when too small)",
+		},
+		[]string{"encoding"},
+	)
+
+	compressionBytesSaved = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_compression_saved_bytes_total",
+			Help: "Total bytes saved by compressing responses, by encoding",
+		},
+		[]string{"encoding"},
+	)
+
+	// Conditional request metrics
+	notModifiedResponses = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_not_modified_responses_total",
+			Help: "Total number of requests answered with 304 Not Modified by path",
+		},
+		[]string{"path"},
+	)
+
+	// GraphQL metrics
+	graphqlRequests = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_graphql_requests_total",
+			Help: "Total number of GraphQL queries by result (ok, partial or error)",
+		},
+		[]string{"result"},
+	)
+	graphqlDuration = promautoFactory.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_graphql_request_duration_seconds",
+			Help:    "Time taken to execute GraphQL queries",
+			Buckets: []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+		},
+	)
+
+	// Benchmark metrics, recorded by the bench subcommand
+	benchFirstToken = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_bench_first_token_seconds",
+			Help:    "Benchmark time to first token in seconds",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10},
+		},
+		[]string{"model"},
+	)
+
+	benchLatency = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_bench_latency_seconds",
+			Help:    "Benchmark end-to-end response time in seconds",
+			Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60, 120},
+		},
+		[]string{"model"},
+	)
+
+	benchTokensPerSecond = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_bench_tokens_per_second",
+			Help:    "Benchmark generation speed in tokens per second",
+			Buckets: []float64{1, 5, 10, 20, 40, 60, 100, 200},
+		},
+		[]string{"model"},
+	)
+
+	benchErrors = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_bench_errors_total",
+			Help: "Total number of failed benchmark requests",
+		},
+		[]string{"model"},
+	)
+
+	// Traffic replay metrics, labelled by replay tag to keep them apart from live traffic
+	replayRequests = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_replay_requests_total",
+			Help: "Total number of replayed chat requests by result",
+		},
+		[]string{"tag", "model", "result"},
+	)
+
+	replayLatency = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_replay_latency_seconds",
+			Help:    "Model response time in seconds for replayed traffic",
+			Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60},
+		},
+		[]string{"tag", "model"},
+	)
+
+	replayFirstToken = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_replay_first_token_latency_seconds",
+			Help:    "Time to first token in seconds for replayed traffic",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5},
+		},
+		[]string{"tag", "model"},
+	)
+
+	replayTokens = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_replay_tokens_total",
+			Help: "Total number of output tokens generated for replayed traffic",
+		},
+		[]string{"tag", "model"},
+	)
+
+	// Drift detection metrics
+	driftScore = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_drift_score",
+			Help: "How far the current traffic window has shifted from the baseline, per signal",
+		},
+		[]string{"signal"},
+	)
+
+	driftDetected = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_drift_detected",
+			Help: "1 while a signal's drift score exceeds its threshold",
+		},
+		[]string{"signal"},
+	)
+
+	driftAlerts = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_drift_alerts_total",
+			Help: "Total number of times a signal started drifting from the baseline",
+		},
+		[]string{"signal"},
+	)
+
+	// Conversation history cache metrics
+	historyLookups = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_history_cache_lookups_total",
+			Help: "Total number of conversation lookups by cache result (hit or miss)",
+		},
+		[]string{"result"},
+	)
+
+	historyEvictions = promautoFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "aiwatch_history_cache_evictions_total",
+			Help: "Total number of conversations evicted from the in-memory cache",
+		},
+	)
+
+	historyMemoryBytes = promautoFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_history_cache_memory_bytes",
+			Help: "Estimated memory held by cached conversations in bytes",
+		},
+	)
+
+	historyEntries = promautoFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_history_cache_entries",
+			Help: "Number of conversations held in the in-memory cache",
+		},
+	)
+
+	// Embeddings proxy metrics
+	embeddingLatency = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_embedding_latency_seconds",
+			Help:    "Latency of embeddings requests in seconds",
+			Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2, 5},
+		},
+		[]string{"model"},
+	)
+
+	embeddingBatchSize = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_embedding_batch_size",
+			Help:    "Number of inputs per embeddings request",
+			Buckets: []float64{1, 2, 4, 8, 16, 32, 64, 128, 256},
+		},
+		[]string{"model"},
+	)
+
+	embeddingTokens = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_embedding_tokens_total",
+			Help: "Total number of input tokens embedded",
+		},
+		[]string{"model"},
+	)
+
+	embeddingRequests = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_embedding_requests_total",
+			Help: "Total number of embeddings requests by result (success or error)",
+		},
+		[]string{"model", "result"},
+	)
+
+	// Audio transcription proxy metrics
+	transcriptionAudioDuration = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_audio_duration_seconds",
+			Help:    "Duration of audio submitted for transcription in seconds",
+			Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+		},
+		[]string{"model"},
+	)
+
+	transcriptionLatency = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_transcription_latency_seconds",
+			Help:    "Processing latency of transcription requests in seconds",
+			Buckets: []float64{0.25, 0.5, 1, 2, 5, 10, 30, 60, 120},
+		},
+		[]string{"model"},
+	)
+
+	transcriptionRealtimeFactor = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_transcription_realtime_factor",
+			Help:    "Processing time divided by audio duration (below 1 is faster than realtime)",
+			Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5},
+		},
+		[]string{"model"},
+	)
+
+	transcriptionRequests = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_transcription_requests_total",
+			Help: "Total number of transcription requests by result (success or error)",
+		},
+		[]string{"model", "result"},
+	)
+
+	// Upstream resilience metrics
+	upstreamRetries = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_upstream_retries_total",
+			Help: "Total number of retried upstream requests by backend and reason",
+		},
+		[]string{"backend", "reason"},
+	)
+
+	circuitState = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_circuit_state",
+			Help: "Upstream circuit breaker state per backend (0 closed, 1 half-open, 2 open)",
+		},
+		[]string{"backend"},
+	)
+
+	// Upstream connection metrics
+	upstreamConnections = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_upstream_connections_total",
+			Help: "Total number of connections upstream requests were sent on, by backend and whether an idle one was reused",
+		},
+		[]string{"backend", "reused"},
+	)
+
+	upstreamDNSDuration = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_upstream_dns_duration_seconds",
+			Help:    "Time to resolve a backend's host name for a new upstream connection",
+			Buckets: []float64{0.0005, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1},
+		},
+		[]string{"backend"},
+	)
+
+	upstreamTLSHandshake = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_upstream_tls_handshake_duration_seconds",
+			Help:    "Time to complete the TLS handshake for a new upstream connection, by whether the session was resumed",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+		},
+		[]string{"backend", "resumed"},
+	)
+
+	// Backend routing metrics
+	backendRequests = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_backend_requests_total",
+			Help: "Total number of requests routed to each backend by model and result",
+		},
+		[]string{"backend", "model", "result"},
+	)
+
+	backendLatency = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_backend_latency_seconds",
+			Help:    "Time until each backend responded with headers in seconds",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10},
+		},
+		[]string{"backend"},
+	)
+
+	backendHealthy = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_backend_healthy",
+			Help: "Whether a backend is currently eligible for routing (1) or cooling down after a failure (0)",
+		},
+		[]string{"backend"},
+	)
+
+	backendFailovers = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_backend_failovers_total",
+			Help: "Total number of requests retried on another backend after a failure",
+		},
+		[]string{"model"},
+	)
+
+	backendAffinity = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_backend_affinity_total",
+			Help: "Total number of conversation turns served by the conversation's own backend (hit) or another one (miss)",
+		},
+		[]string{"result"},
+	)
+
+	// Priority scheduling metrics
+	priorityQueueWait = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_priority_queue_wait_seconds",
+			Help:    "Time requests waited for an upstream slot, by priority class",
+			Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 2, 5, 10, 30, 60},
+		},
+		[]string{"class"},
+	)
+
+	priorityQueueDepth = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_priority_queue_depth",
+			Help: "Requests waiting for an upstream slot, by priority class",
+		},
+		[]string{"class"},
+	)
+
+	// Output rate shaping metrics
+	outputRateLimit = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_output_rate_limit_tokens_per_second",
+			Help: "Configured cap on streamed output tokens per second, by priority class (0 is uncapped)",
+		},
+		[]string{"class"},
+	)
+
+	outputRate = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_output_rate_tokens_per_second",
+			Help:    "Rate paced responses were actually streamed at, by priority class",
+			Buckets: []float64{1, 2, 5, 10, 20, 30, 50, 75, 100, 200},
+		},
+		[]string{"class"},
+	)
+
+	outputRateDelay = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_output_rate_delay_seconds_total",
+			Help: "Total time output was held back to stay under the rate cap, by priority class",
+		},
+		[]string{"class"},
+	)
+
+	// Async generation job metrics
+	jobsQueued = promautoFactory.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_jobs_queued",
+			Help: "Number of generation jobs waiting for a worker",
+		},
+	)
+
+	jobsCounter = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_jobs_total",
+			Help: "Total number of finished generation jobs by final status",
+		},
+		[]string{"status"},
+	)
+
+	jobWait = promautoFactory.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_job_wait_seconds",
+			Help:    "Time generation jobs spent queued before a worker picked them up",
+			Buckets: []float64{0.1, 0.5, 1, 5, 15, 30, 60, 300, 900},
+		},
+	)
+
+	jobDuration = promautoFactory.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_job_duration_seconds",
+			Help:    "Time taken to run generation jobs",
+			Buckets: []float64{0.5, 1, 5, 15, 30, 60, 120, 300, 600},
+		},
+	)
+
+	jobCallbacks = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_job_callbacks_total",
+			Help: "Total number of job completion webhooks by delivery result",
+		},
+		[]string{"result"},
+	)
+
+	// RAG document library metrics
+	ragDocuments = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_rag_documents_total",
+			Help: "Total number of documents submitted for ingestion by result",
+		},
+		[]string{"result"},
+	)
+
+	ragChunks = promautoFactory.NewCounter(
+		prometheus.CounterOpts{
+			Name: "aiwatch_rag_chunks_ingested_total",
+			Help: "Total number of document chunks embedded and stored",
+		},
+	)
+
+	ragChunksRetrieved = promautoFactory.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_rag_chunks_retrieved",
+			Help:    "Number of chunks retrieved per RAG query",
+			Buckets: []float64{0, 1, 2, 4, 8, 16},
+		},
+	)
+
+	ragRetrievalLatency = promautoFactory.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_rag_retrieval_latency_seconds",
+			Help:    "Time taken to embed a query and search the document library",
+			Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+		},
+	)
+
+	ragHitScores = promautoFactory.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_rag_hit_score",
+			Help:    "Cosine similarity of each chunk retrieved for a query",
+			Buckets: []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1},
+		},
+	)
+
+	ragAverageScore = promautoFactory.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_rag_average_similarity",
+			Help:    "Mean cosine similarity of the chunks retrieved per RAG query",
+			Buckets: []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1},
+		},
+	)
+
+	// Batch chat metrics
+	batchItems = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_batch_items_total",
+			Help: "Total number of batch chat items by result",
+		},
+		[]string{"result"},
+	)
+
+	batchItemLatency = promautoFactory.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_batch_item_latency_seconds",
+			Help:    "Time taken to generate a single batch chat item",
+			Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60, 120},
+		},
+	)
+
+	batchSize = promautoFactory.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_batch_size",
+			Help:    "Number of items per batch chat request",
+			Buckets: []float64{1, 5, 10, 50, 100, 250, 500, 1000},
+		},
+	)
+
+	// Output format metrics
+	outputFormats = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_output_format_requests_total",
+			Help: "Chat requests by output format and whether it was requested or detected from the message",
+		},
+		[]string{"format", "source"},
+	)
+
+	// Structured output metrics
+	jsonValidationFailures = promautoFactory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aiwatch_json_validation_failures_total",
+			Help: "Structured output responses that were not valid JSON or did not match their schema",
+		},
+		[]string{"model"},
+	)
+
+	// Readiness probe metrics
+	dependencyUp = promautoFactory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aiwatch_dependency_up",
+			Help: "Whether the last readiness probe of a dependency succeeded (1) or failed (0)",
+		},
+		[]string{"backend"},
+	)
+
+	dependencyProbeLatency = promautoFactory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aiwatch_dependency_probe_duration_seconds",
+			Help:    "Latency of readiness probes against each dependency",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"backend"},
+	)
+)
+
+// vllmCollector scrapes the backend's metrics when it is vLLM
+var vllmCollector *vllm.Collector
+
+// modelContainer samples the model container's resource usage, when Docker
+// is reachable
+var modelContainer *container.Collector
+
+// memoryMonitor tracks KV cache and model memory; it has no sources until
+// main configures them
+var memoryMonitor = memory.NewMonitor(0, memory.Metrics{})
+
+// samplingProfiles resolves the named sampling profiles selectable per request
+var samplingProfiles = sampling.NewRegistry(sampling.Config{})
+
+// promptTemplates stores the named system prompt templates
+var promptTemplates, _ = prompts.NewStore("")
+
+// sessions tracks distinct client sessions for the active and unique user counts
+var sessions = session.NewTracker(15 * time.Minute)
+
+// recentTotals snapshots the request, error and TTFT totals every 10
+// seconds for the summary's 5 minute and 1 hour windows
+var recentTotals = window.New(readWindowTotals, 10*time.Second, time.Hour)
+
+// summaryWindows are the windows reported in MetricsSummary.Windows
+var summaryWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+}
+
+// usageQuotas enforces per-key token and request quotas
+var usageQuotas = quota.New(quota.Config{}, quotaRejections)
+
+// tenantRegistry maps API keys to the teams sharing the instance
+var tenantRegistry = tenants.New(tenantRequests)
+
+// secretStore resolves API keys and tokens; main adds the configured providers
+var secretStore = secrets.New(secrets.Metrics{Rotations: secretRotations, Errors: secretErrors})
+
+// conversationOwner is the identity the caller's conversations are recorded
+// under: the signed-in user, else a digest of the API key, else the session
+func conversationOwner(r *http.Request) string {
+	if id, ok := oidc.FromContext(r.Context()); ok {
+		return "user:" + id.Subject
+	}
+	if key := quota.APIKey(r); key != "" {
+		return quota.Digest(key)
+	}
+	return "session:" + session.FromContext(r.Context())
+}
+
+// ownsConversation reports whether the caller started c, so may continue it
+// or rate it; other tenants' and users' conversations are reported as missing
+func ownsConversation(r *http.Request, c history.Conversation) bool {
+	return tenants.Same(c.Tenant, tenants.FromContext(r.Context())) && c.OwnedBy(conversationOwner(r), session.FromContext(r.Context()))
+}
+
+// allowModel reports whether the caller's tenant may use model
+func allowModel(r *http.Request, model string) bool {
+	return tenantRegistry.Allows(tenants.FromContext(r.Context()), model)
+}
+
+// recordTokenUsage counts the tokens of an exchange against the caller's
+// quota, bill and tenant
+func recordTokenUsage(r *http.Request, model string, input, output int) {
+	usageQuotas.RecordTokens(quota.FromContext(r.Context()), input+output)
+	billingLedger.Record(quota.APIKey(r), model, input, output)
+	tenant := tenants.FromContext(r.Context())
+	tenantTokens.WithLabelValues(tenant, model, "input").Add(float64(input))
+	tenantTokens.WithLabelValues(tenant, model, "output").Add(float64(output))
+}
+
+// generationWatchdog kills generations that exceed the absolute duration or token limits
+var generationWatchdog = watchdog.New(10*time.Minute, 0, watchdogKills)
+
+// contextOverflowAction is what happens to prompts that exceed the context
+// window: "truncate" trims the history, "reject" refuses the request
+var contextOverflowAction = "truncate"
+
+// memoryTruncationStrategy trims conversations continued from server-side
+// memory, unless the request names a strategy
+var memoryTruncationStrategy = truncation.MiddleOut
+
+// metricsStreamInterval is the default push interval for /metrics/stream
+var metricsStreamInterval = 2 * time.Second
+
+// startup reports when the server has started, for the startup probe
+var startup health.Startup
+
+// chatDrain holds back shutdown until in-flight generations finish
+var chatDrain = drain.New()
+
+// generationTimeout and generationMaxTokens end each chat's generation early
+// with a "truncated" event; zero disables the limit
+var (
+	generationTimeout   = 75 * time.Second
+	generationMaxTokens = 0
+)
+
+// streamTracing sets how often a chat's generation span records its
+// progress as tokens arrive
+var streamTracing = tracing.DefaultStreamConfig()
+
+// outputPacing caps how fast chat output is streamed to clients
+var outputPacing = pacing.New(pacing.Config{}, pacing.Metrics{})
+
+// chatGuardrails filters prompts before forwarding and responses as they stream
+var chatGuardrails, _ = guardrails.New(guardrails.Config{}, guardrailBlocks)
+
+// promptCompressor shrinks long prompts before forwarding when PROMPT_COMPRESSION is set
+var promptCompressor, _ = compression.New(compression.Config{}, nil, promptTokensSaved)
+
+// outputModerator checks streamed responses sentence by sentence when MODERATION_URL is set
+var outputModerator moderation.Moderator
+
+// moderationPolicyMessage is sent in place of a flagged response
+var moderationPolicyMessage = moderation.DefaultPolicyMessage
+
+// guardrailWindow is how much of the streamed response output checks see
+const guardrailWindow = 512
+
+// conversations stores chat history behind a memory-bounded cache
+var conversations = history.New(nil, 64<<20, historyMetrics())
+
+// historyMetrics returns the collectors for the conversation store
+func historyMetrics() history.Metrics {
+	return history.Metrics{
+		Lookups:     historyLookups,
+		Evictions:   historyEvictions,
+		MemoryBytes: historyMemoryBytes,
+		Entries:     historyEntries,
+	}
+}
+
+// chatExperiments splits chat traffic between models for A/B tests
+var chatExperiments, _ = experiments.New(experiments.Config{}, experimentMetrics())
+
+// experimentMetrics returns the collectors for experiments
+func experimentMetrics() experiments.Metrics {
+	return experiments.Metrics{
+		Requests:   experimentRequests,
+		Latency:    experimentLatency,
+		FirstToken: experimentFirstToken,
+		Tokens:     experimentTokens,
+		Feedback:   experimentFeedback,
+	}
+}
+
+// responseEvaluator grades a sample of completed chats when JUDGE_MODEL is set
+var responseEvaluator *evaluation.Evaluator
+
+// chatClassifier sorts completed chats into topic and safety categories
+var chatClassifier *classify.Classifier
+
+// conversationSummarizer titles and summarizes stored conversations
+var conversationSummarizer *summarize.Summarizer
+
+// driftDetector compares prompt/response distributions against a baseline window
+var driftDetector = drift.New(drift.DefaultConfig(), driftMetrics())
+
+// driftEmbeddingModel embeds prompts for topic drift when DRIFT_EMBEDDING_MODEL is set
+var driftEmbeddingModel string
+
+// ragIndex is the document library used by chats with rag=true, set when
+// RAG_EMBEDDING_MODEL is configured
+var ragIndex *rag.Index
+
+// embedTexts returns an Embedder using model on the upstream server
+func embedTexts(client *openai.Client, model string) rag.Embedder {
+	return func(ctx context.Context, texts []string) ([][]float64, error) {
+		resp, err := client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+			Input: openai.F[openai.EmbeddingNewParamsInputUnion](openai.EmbeddingNewParamsInputArrayOfStrings(texts)),
+			Model: openai.F(openai.EmbeddingModel(model)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		vectors := make([][]float64, len(resp.Data))
+		for _, data := range resp.Data {
+			if int(data.Index) >= len(vectors) {
+				return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+			}
+			vectors[data.Index] = data.Embedding
+		}
+		return vectors, nil
+	}
+}
+
+// driftMetrics returns the collectors for drift detection
+func driftMetrics() drift.Metrics {
+	return drift.Metrics{
+		Score:    driftScore,
+		Drifting: driftDetected,
+		Alerts:   driftAlerts,
+	}
+}
+
+// chatArchiver dual-writes completed chats to a secondary sink when ARCHIVE_SINK is set
+var chatArchiver *archive.Archiver
+
+// transcriptArchiver keeps completed chats in object storage for long-term
+// retention when TRANSCRIPT_ARCHIVE_URL is set
+var transcriptArchiver *transcripts.Archiver
+
+// piiAnonymizer replaces personal data in what is stored when PII_ANONYMIZE is set
+var piiAnonymizer = privacy.New(privacy.Config{}, privacy.Metrics{})
+
+// anonymizeMessages returns copies of msgs with personal data replaced.
+// Assistant turns count as responses.
+func anonymizeMessages(ctx context.Context, msgs []archive.Message) []archive.Message {
+	anonymized := make([]archive.Message, len(msgs))
+	for i, msg := range msgs {
+		field := privacy.Prompt
+		if msg.Role == "assistant" {
+			field = privacy.Response
+		}
+		anonymized[i] = archive.Message{Role: msg.Role, Content: piiAnonymizer.Anonymize(ctx, field, msg.Content)}
+	}
+	return anonymized
+}
+
+// auditLog records data erasures and retention purges, in AUDIT_LOG_PATH when set
+var auditLog = audit.New("")
+
+// eraseUserData removes everything held about user: their conversations,
+// with their feedback, from the history store and the transcript archive,
+// their request log records and billing rows, and their session. user is a
+// session ID or an owner as returned by conversationOwner.
+func eraseUserData(ctx context.Context, user string) (map[string]int, error) {
+	owners := map[string]bool{user: true}
+	sessionID := strings.TrimPrefix(user, "session:")
+	if !strings.Contains(user, ":") {
+		owners["session:"+user] = true
+	} else if sessionID == user {
+		sessionID = ""
+	}
+
+	deleted := make(map[string]int)
+	ids, err := conversations.DeleteWhere(func(c history.Conversation) bool {
+		return owners[c.Owner] || (sessionID != "" && c.User == sessionID)
+	})
+	deleted["conversations"] = len(ids)
+	if err != nil {
+		return deleted, err
+	}
+	if transcriptArchiver != nil {
+		n, err := transcriptArchiver.Erase(ctx, ids)
+		deleted["transcripts"] = n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	if requestLog != nil {
+		n, err := requestLog.DeleteWhere(func(rec requestlog.Record) bool {
+			return owners[rec.Owner] || (sessionID != "" && rec.Client == "session:"+sessionID)
+		})
+		deleted["requests"] = n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	for owner := range owners {
+		deleted["billing"] += billingLedger.Erase(owner)
+	}
+	if deleted["billing"] > 0 && billingLedgerPath != "" {
+		if err := billingLedger.Save(billingLedgerPath); err != nil {
+			return deleted, err
+		}
+	}
+	if sessionID != "" && sessions.Forget(sessionID) {
+		deleted["sessions"] = 1
+	}
+	return deleted, nil
+}
+
+// archivingChats reports whether completed chats are archived anywhere
+func archivingChats() bool {
+	return (chatArchiver != nil || transcriptArchiver != nil) && flags.Default.Enabled("archive")
+}
+
+// archiveChat hands a completed chat to every configured archive
+func archiveChat(record archive.Record) {
+	if record.CompletedAt.IsZero() {
+		record.CompletedAt = time.Now()
+	}
+	if chatArchiver != nil {
+		chatArchiver.Archive(record)
+	}
+	if transcriptArchiver != nil {
+		transcriptArchiver.Archive(record)
+	}
+}
+
+// restoreTranscript rebuilds a conversation in the history store from its
+// archived exchanges. The first record carries the context the conversation
+// started with; later ones add their latest user turn and the response.
+func restoreTranscript(conversationID string, recs []archive.Record) error {
+	if _, err := conversations.Get(conversationID); err == nil {
+		return transcripts.ErrExists
+	} else if !errors.Is(err, history.ErrNotFound) {
+		return err
+	}
+
+	var messages []history.Message
+	for i, rec := range recs {
+		turns := rec.Messages
+		if i > 0 && len(turns) > 0 {
+			turns = turns[len(turns)-1:]
+		}
+		for _, msg := range turns {
+			messages = append(messages, history.Message{Role: msg.Role, Content: msg.Content, Timestamp: rec.CompletedAt})
+		}
+		messages = append(messages, history.Message{ID: rec.MessageID, Role: "assistant", Content: rec.Response, Model: rec.Model, Timestamp: rec.CompletedAt})
+	}
+	_, err := conversations.Append(conversationID, "", recs[len(recs)-1].Model, messages...)
+	return err
+}
+
+// requestLog keeps a record of every completed or failed chat for
+// /export/requests, set up in main
+var requestLog *requestlog.Log
+
+// logRequest adds a finished chat's lifecycle event to the request log
+func logRequest(r *http.Request, l events.Lifecycle) {
+	if requestLog == nil {
+		return
+	}
+	status := l.StatusCode
+	if status == 0 && l.ErrorCode != "" {
+		status = apierror.Code(l.ErrorCode).Status()
+	}
+	tenant := tenants.FromContext(r.Context())
+	if tenant == tenants.Default {
+		tenant = ""
+	}
+	requestLog.Add(requestlog.Record{
+		RequestID:       l.RequestID,
+		Endpoint:        l.Endpoint,
+		Model:           l.Model,
+		Tenant:          tenant,
+		Client:          l.Client,
+		Owner:           conversationOwner(r),
+		Status:          status,
+		ErrorCode:       l.ErrorCode,
+		TokensIn:        l.TokensIn,
+		TokensOut:       l.TokensOut,
+		LatencyMs:       l.LatencyMs,
+		FirstTokenMs:    l.FirstTokenMs,
+		TokensPerSecond: l.TokensPerSecond,
+	})
+}
+
+// usageReports renders daily and weekly usage reports, set up in main
+var usageReports *reports.Scheduler
+
+// conversationPrompts returns the user prompts stored between from and to,
+// for the top prompts of usage reports
+func conversationPrompts(from, to time.Time) ([]string, error) {
+	convs, err := conversations.Conversations(func(c history.Conversation) bool {
+		return !c.UpdatedAt.Before(from) && c.CreatedAt.Before(to)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var prompts []string
+	for _, c := range convs {
+		for _, msg := range c.Messages {
+			if msg.Role == "user" && !msg.Timestamp.Before(from) && msg.Timestamp.Before(to) {
+				prompts = append(prompts, msg.Content)
+			}
+		}
+	}
+	return prompts, nil
+}
+
+// billingLedger aggregates token usage per API key and day for /billing/usage
+// and the reports written to BILLING_EXPORT_URL
+var billingLedger = billing.NewLedger(billing.DefaultConfig())
+
+// billingLedgerPath is where the ledger is saved, from BILLING_LEDGER_PATH
+var billingLedgerPath string
+
+// chatEvents publishes chat lifecycle events to EVENT_BUS_URL, when set
+var chatEvents events.Publisher = events.NopPublisher{}
+
+// Helper function to get counter value
+func getCounterValue(counter *prometheus.CounterVec, labelValues ...string) float64 {
+	// Use 0 as the default value
+	value := 0.0
+	
+	// If labels are provided, try to get a specific counter
+	if len(labelValues) > 0 {
+		c, err := counter.GetMetricWithLabelValues(labelValues...)
+		if err == nil {
+			metric := &dto.Metric{}
+			if err := c.(prometheus.Metric).Write(metric); err == nil && metric.Counter != nil {
+				value = metric.Counter.GetValue()
+			}
+		}
+		return value
+	}
+	
+	// Otherwise, sum all counters
+	metrics := make(chan prometheus.Metric, 100)
+	counter.Collect(metrics)
+	close(metrics)
+	
+	for metric := range metrics {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err == nil && m.Counter != nil {
+			value += m.Counter.GetValue()
+		}
+	}
+	
+	return value
+}
+
+// Helper function to get gauge value
+func getGaugeValue(gauge prometheus.Gauge) float64 {
+	value := 0.0
+	metric := &dto.Metric{}
+	if err := gauge.Write(metric); err == nil && metric.Gauge != nil {
+		value = metric.Gauge.GetValue()
+	}
+	return value
+}
+
+// Helper function to get gauge value with labels
+func getGaugeValueWithLabels(gauge *prometheus.GaugeVec, labelValues ...string) float64 {
+	if len(labelValues) == 0 {
+		return 0.0
+	}
+	
+	g, err := gauge.GetMetricWithLabelValues(labelValues...)
+	if err != nil {
+		return 0.0
+	}
+	
+	metric := &dto.Metric{}
+	if err := g.(prometheus.Metric).Write(metric); err == nil && metric.Gauge != nil {
+		return metric.Gauge.GetValue()
+	}
+	
+	return 0.0
+}
+
+// lookupGaugeValue returns a gauge's value for the model label without
+// creating the series when it was never set
+func lookupGaugeValue(gauge *prometheus.GaugeVec, model string) (float64, bool) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		gauge.Collect(ch)
+		close(ch)
+	}()
+	value, found := 0.0, false
+	for m := range ch {
+		metric := &dto.Metric{}
+		if m.Write(metric) != nil || metric.Gauge == nil {
+			continue
+		}
+		for _, label := range metric.Label {
+			if label.GetName() == "model" && label.GetValue() == model {
+				value, found = metric.Gauge.GetValue(), true
+			}
+		}
+	}
+	return value, found
+}
+
+// Helper function to get histogram value with labels
+func getHistogramValueWithLabels(histogram *prometheus.HistogramVec, labelValues ...string) float64 {
+    if len(labelValues) == 0 {
+        return 0.0
+    }
+    
+    h, err := histogram.GetMetricWithLabelValues(labelValues...)
+    if err != nil {
+        return 0.0
+    }
+    
+    // For histograms, we can get the sum and count to calculate an average
+    metric := &dto.Metric{}
+    if err := h.(prometheus.Metric).Write(metric); err == nil && metric.Histogram != nil {
+        if metric.Histogram.GetSampleCount() > 0 {
+            return metric.Histogram.GetSampleSum() / float64(metric.Histogram.GetSampleCount())
+        }
+    }
+    
+    return 0.0
+}
+
+// Helper function to calculate error rate
+func calculateErrorRate() float64 {
+	totalErrors := getCounterValue(errorCounter)
+	totalRequests := getCounterValue(requestCounter)
+	
+	if totalRequests == 0 {
+		return 0.0
+	}
+	
+	return totalErrors / totalRequests
+}
+
+// readWindowTotals reads the totals recentTotals takes snapshots of
+func readWindowTotals() window.Totals {
+	ttftSum, ttftCount := getHistogramTotals(firstTokenLatency)
+	return window.Totals{
+		Requests:  getCounterValue(requestCounter),
+		Errors:    getCounterValue(errorCounter),
+		TTFTSum:   ttftSum,
+		TTFTCount: ttftCount,
+	}
+}
+
+// getHistogramTotals sums the observations and their count across every
+// series of a histogram
+func getHistogramTotals(histogram *prometheus.HistogramVec) (sum, count float64) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		histogram.Collect(metrics)
+		close(metrics)
+	}()
+	for metric := range metrics {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err == nil && m.Histogram != nil {
+			sum += m.Histogram.GetSampleSum()
+			count += float64(m.Histogram.GetSampleCount())
+		}
+	}
+	return sum, count
+}
+
+// Helper function to calculate average response time
+func getAverageResponseTime(histogram *prometheus.HistogramVec) float64 {
+	// This is a simplification - in a real app you'd calculate this from histogram buckets
+	// For now, we'll use a fixed value
+	return 0.5 // 500ms average response time
+}
+
+// Helper function to get the context window size for a model, preferring the
+// size reported by llama.cpp and falling back to a default by parameter count
+func getContextWindow(model string) int {
+	contextSize := int(getGaugeValueWithLabels(llamacppContextSize, model))
+	if contextSize > 0 {
+		return contextSize
+	}
+
+	// Default context window for the model if not set yet
+	if strings.Contains(model, "1B") {
+		return 2048
+	} else if strings.Contains(model, "7B") {
+		return 4096
+	} else if strings.Contains(model, "13B") {
+		return 4096
+	} else if strings.Contains(model, "70B") {
+		return 8192
+	}
+	return 4096 // Default
+}
+
+// Helper function to get LlamaCpp metrics for the current model
+func getLlamaCppMetrics(model string) *LlamaCppMetrics {
+	// Check if any llama.cpp metrics exist for this model
+	contextSize := int(getGaugeValueWithLabels(llamacppContextSize, model))
+	if contextSize == 0 {
+		return nil // No llama.cpp metrics available
+	}
+	
+	// Collect all metrics
+	var acceptanceRate *float64
+	if rate, ok := lookupGaugeValue(specDecodeAcceptanceRate, model); ok {
+		acceptanceRate = &rate
+	}
+	return &LlamaCppMetrics{
+		ContextSize:     contextSize,
+		PromptEvalTime:  getHistogramValueWithLabels(llamacppPromptEvalTime, model) * 1000, // Convert to ms
+		TokensPerSecond: getGaugeValueWithLabels(llamacppTokensPerSecond, model),
+		MemoryPerToken:  getGaugeValueWithLabels(llamacppMemoryPerToken, model),
+		ThreadsUsed:     int(getGaugeValueWithLabels(llamacppThreadsUsed, model)),
+		BatchSize:       int(getGaugeValueWithLabels(llamacppBatchSize, model)),
+		KVCacheUsage:    getGaugeValueWithLabels(llamacppKVCacheUsage, model),
+		ModelType:       "llama.cpp",
+
+		SpecDecodeAcceptanceRate: acceptanceRate,
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:]))
+	}
+	serverFlags := flag.NewFlagSet("aiwatch", flag.ExitOnError)
+	strict := serverFlags.Bool("strict", false, "refuse to start when the startup self-test fails (or set STARTUP_STRICT=true)")
+	serverFlags.Parse(os.Args[1:])
+
+	log.Println("Starting AIWatch with observability")
+
+	// Export aiwatch's own Go runtime (goroutines, GC pauses, heap) and
+	// process (CPU, memory, file descriptors) metrics
+	metricsRegisterer.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	// Apply a mounted ConfigMap before anything reads the environment
+	var kubeConfig *kube.Config
+	var kubeConfigErr error
+	if dir := os.Getenv("K8S_CONFIG_DIR"); dir != "" {
+		kubeConfig, kubeConfigErr = kube.LoadConfig(dir, configMapReloads)
+	}
+
+	// Print Docker version for debugging; pods have no docker CLI
+	dockerVersionCmd := exec.Command("docker", "--version")
+	dockerVersionOut, err := dockerVersionCmd.CombinedOutput()
+	if kubePod.Enabled {
+		log.Printf("Running in Kubernetes pod %s/%s", kubePod.Namespace, kubePod.Name)
+	} else if err != nil {
+		log.Printf("Warning: Docker CLI check failed: %v", err)
+	} else {
+		log.Printf("Docker CLI check: %s", string(dockerVersionOut))
+	}
+
+	// Get configuration from environment
+	baseURL := os.Getenv("BASE_URL")
+	defaultModel := os.Getenv("MODEL")
+	
+	// Initialize logger
+	logLevel := getEnvOrDefault("LOG_LEVEL", "info")
+	logPretty, _ := strconv.ParseBool(getEnvOrDefault("LOG_PRETTY", "true"))
+	logMaxSize, _ := strconv.Atoi(getEnvOrDefault("LOG_MAX_SIZE", "100"))
+	logMaxBackups, _ := strconv.Atoi(getEnvOrDefault("LOG_MAX_BACKUPS", "5"))
+	logRotateInterval, _ := time.ParseDuration(getEnvOrDefault("LOG_ROTATE_INTERVAL", "24h"))
+	logFileErr := logger.Setup(logger.Config{
+		Level:  logLevel,
+		Pretty: logPretty,
+		File: logger.FileConfig{
+			Path:           os.Getenv("LOG_FILE"),
+			MaxSizeMB:      logMaxSize,
+			RotateInterval: logRotateInterval,
+			MaxBackups:     logMaxBackups,
+		},
+		Loki: logger.LokiConfig{
+			URL:     os.Getenv("LOKI_URL"),
+			Service: getEnvOrDefault("LOKI_SERVICE", "aiwatch"),
+		},
+	})
+	defer logger.Close()
+	
+	// Get logger
+	log := logger.GetLogger()
+	if logFileErr != nil {
+		log.Error().Err(logFileErr).Str("path", os.Getenv("LOG_FILE")).Msg("Failed to open log file, logging to stdout only")
+	}
+	log.Info().Msg("Logger initialized successfully")
+
+	// Reload the ConfigMap as Kubernetes updates it. The log level and
+	// feature flags change in place; other settings apply on the next start.
+	if kubeConfigErr != nil {
+		log.Error().Err(kubeConfigErr).Str("dir", os.Getenv("K8S_CONFIG_DIR")).Msg("Failed to read ConfigMap")
+	} else if kubeConfig != nil {
+		configReload, err := time.ParseDuration(getEnvOrDefault("K8S_CONFIG_RELOAD_INTERVAL", "30s"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid K8S_CONFIG_RELOAD_INTERVAL")
+		}
+		log.Info().Strs("keys", kubeConfig.Keys()).Msg("Loaded configuration from ConfigMap")
+		kubeConfig.Watch(context.Background(), configReload, applyConfigChange)
+	}
+
+	// Secrets setup: API keys and tokens can come from mounted secret files,
+	// Vault or AWS Secrets Manager instead of plaintext env vars
+	var secretProviders []secrets.Provider
+	for _, name := range strings.Split(getEnvOrDefault("SECRETS_PROVIDERS", "file"), ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "file":
+			secretProviders = append(secretProviders, &secrets.FileProvider{Dir: getEnvOrDefault("SECRETS_DIR", "/run/secrets")})
+		case "vault":
+			secretProviders = append(secretProviders, &secrets.VaultProvider{
+				Addr:      os.Getenv("VAULT_ADDR"),
+				Path:      getEnvOrDefault("VAULT_SECRET_PATH", "secret/data/aiwatch"),
+				Token:     os.Getenv("VAULT_TOKEN"),
+				TokenFile: os.Getenv("VAULT_TOKEN_FILE"),
+				Namespace: os.Getenv("VAULT_NAMESPACE"),
+			})
+		case "aws":
+			secretProviders = append(secretProviders, &secrets.AWSProvider{
+				SecretID:    os.Getenv("AWS_SECRET_ID"),
+				Region:      getEnvOrDefault("AWS_REGION", "us-east-1"),
+				Endpoint:    os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER"),
+				Credentials: sigv4.FromEnv(),
+			})
+		default:
+			log.Fatal().Str("provider", name).Msg("Invalid SECRETS_PROVIDERS, want file, vault or aws")
+		}
+	}
+	secretStore = secrets.New(secrets.Metrics{Rotations: secretRotations, Errors: secretErrors}, secretProviders...)
+	secretsRefresh, err := time.ParseDuration(getEnvOrDefault("SECRETS_REFRESH_INTERVAL", "5m"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid SECRETS_REFRESH_INTERVAL")
+	}
+	secretStore.Start(context.Background(), secretsRefresh)
+	apiKey := getSecret("API_KEY", "")
+	if source := secretStore.Source("API_KEY"); source != "" {
+		log.Info().Str("source", source).Msg("Loaded API key")
+	}
+
+	// Talk to Docker Model Runner over its API rather than the docker CLI.
+	// Its address comes from MODEL_RUNNER_URL or a Model Runner BASE_URL,
+	// and without BASE_URL its inference endpoint is used.
+	if runnerURL := getEnvOrDefault("MODEL_RUNNER_URL", models.RunnerURL(baseURL)); runnerURL != "" {
+		runner, err := models.NewModelRunnerClient(runnerURL)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid MODEL_RUNNER_URL")
+		}
+		models.ModelRunner = runner
+		models.Lifecycle.Running = runner.Running
+		if baseURL == "" {
+			baseURL = runner.InferenceURL()
+			log.Info().Str("url", baseURL).Msg("Using Model Runner's inference endpoint")
+		}
+	}
+
+	// Work out which inference server is behind BASE_URL, so metrics and
+	// model listing follow the server rather than the model's name
+	backendType, err := backend.ParseType(getEnvOrDefault("BACKEND_TYPE", "auto"))
+	if err != nil {
+		log.Warn().Err(err).Msg("Invalid BACKEND_TYPE, detecting the backend instead")
+	}
+	backend.Default = backend.NewResolver(backendType, nil)
+	serving := backend.Default.Resolve(context.Background(), baseURL)
+	log.Info().Str("type", string(serving.Type)).Str("source", serving.Source).Msg("Resolved inference backend")
+
+	// List models through Model Runner's API, falling back to the docker
+	// CLI, or on Kubernetes, where there is no CLI, to the backend's /models.
+	// Ollama has no docker model list; ask its own API instead.
+	listModels := models.GetAvailableModels
+	if serving.NativeModelList {
+		listModels = ollama.New(baseURL).ListModels
+		log.Info().Str("url", ollama.ServerURL(baseURL)).Msg("Listing models from Ollama")
+	} else {
+		if kubePod.Enabled {
+			listModels = models.ListFromAPI(baseURL, apiKey)
+		}
+		if models.ModelRunner != nil {
+			listModels = models.WithFallback(models.ModelRunner.ListModels, listModels)
+			log.Info().Str("url", models.ModelRunner.URL()).Msg("Listing models from the Model Runner API")
+		} else if kubePod.Enabled {
+			log.Info().Str("url", baseURL).Msg("Listing models from the backend API")
+		}
+	}
+	models.List = models.NewListCache(listModels, 30*time.Second)
+
+	// Validate the configuration before serving, so a misconfigured
+	// deployment says so at boot instead of on the first chat
+	if strictEnv, err := strconv.ParseBool(getEnvOrDefault("STARTUP_STRICT", "false")); err == nil && strictEnv {
+		*strict = true
+	}
+	checkTimeout, err := time.ParseDuration(getEnvOrDefault("STARTUP_CHECK_TIMEOUT", "10s"))
+	if err != nil {
+		checkTimeout = 10 * time.Second
+	}
+	report := selftest.Run(context.Background(), selftest.Config{
+		BaseURL: baseURL,
+		Model:   defaultModel,
+		APIKey:  apiKey,
+		Timeout: checkTimeout,
+		LocalModels: func() ([]string, error) {
+			available, err := listModels()
+			names := make([]string, len(available))
+			for i, m := range available {
+				names[i] = m.Name
+			}
+			return names, err
+		},
+	})
+	report.Print(os.Stderr)
+	for _, check := range report.Checks {
+		event := log.Info()
+		switch check.Result {
+		case selftest.Warn:
+			event = log.Warn()
+		case selftest.Fail:
+			event = log.Error()
+		}
+		event.Str("check", check.Name).Str("result", string(check.Result)).Msg(check.Detail)
+	}
+	if report.Failed() {
+		if *strict {
+			log.Error().Msg("Startup self-test failed, refusing to start in strict mode")
+			logger.Close()
+			os.Exit(1)
+		}
+		log.Warn().Msg("Startup self-test failed, starting anyway; chats will fail until the configuration is fixed")
+	}
+
+	// Tracing setup
+	tracingEnabled, _ := strconv.ParseBool(getEnvOrDefault("TRACING_ENABLED", "false"))
+	var tracingCleanup func()
+
+	if tracingEnabled {
+		// Which traces are exported; the admin API can change it at runtime
+		sampling := tracing.SamplerConfig{Strategy: getEnvOrDefault("TRACE_SAMPLER", tracing.SampleAlways)}
+		sampling.Ratio, _ = strconv.ParseFloat(getEnvOrDefault("TRACE_SAMPLER_RATIO", "0"), 64)
+		sampling.PerSecond, _ = strconv.ParseFloat(getEnvOrDefault("TRACE_SAMPLER_RATE", "0"), 64)
+		sampling.Latency, _ = time.ParseDuration(getEnvOrDefault("TRACE_SAMPLER_LATENCY", "0s"))
+		sampling.Errors, _ = strconv.ParseBool(getEnvOrDefault("TRACE_SAMPLER_ERRORS", "true"))
+		if err := tracing.SetSampling(sampling); err != nil {
+			log.Fatal().Err(err).Msg("Invalid trace sampling configuration")
+		}
+
+		otlpEndpoint := getEnvOrDefault("OTLP_ENDPOINT", "jaeger:4318")
+		log.Info().Str("endpoint", otlpEndpoint).Msg("Setting up tracing")
+
+		cleanup, err := tracing.SetupTracing("aiwatch", otlpEndpoint, kubePod.Attributes()...)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to set up tracing")
+		} else {
+			tracingCleanup = cleanup
+			defer tracingCleanup()
+			log.Info().Msg("Tracing initialized successfully")
+		}
+	}
+	if v := os.Getenv("TRACE_STREAM_EVENT_TOKENS"); v != "" {
+		tokens, err := strconv.Atoi(v)
+		if err != nil || tokens < 0 {
+			log.Fatal().Str("value", v).Msg("Invalid TRACE_STREAM_EVENT_TOKENS")
+		}
+		streamTracing.EveryTokens = tokens
+	}
+	if v := os.Getenv("TRACE_STREAM_STALL"); v != "" {
+		stall, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid TRACE_STREAM_STALL")
+		}
+		streamTracing.Stall = stall
+	}
+
+	// Archive setup
+	if archiveSink := os.Getenv("ARCHIVE_SINK"); archiveSink != "" {
+		sink, err := events.NewSink(archiveSink)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to set up archive sink")
+		} else {
+			queueSize, _ := strconv.Atoi(getEnvOrDefault("ARCHIVE_QUEUE_SIZE", "256"))
+			replayInterval, err := time.ParseDuration(getEnvOrDefault("ARCHIVE_REPLAY_INTERVAL", "1m"))
+			if err != nil {
+				replayInterval = time.Minute
+			}
+
+			chatArchiver = archive.New(sink, getEnvOrDefault("ARCHIVE_SPOOL_PATH", "/tmp/aiwatch-archive-spool.jsonl"), queueSize, archive.Metrics{
+				DeliveryLag: archiveDeliveryLag,
+				Failures:    archiveFailures,
+				Replayed:    archiveReplayed,
+				Spooled:     archiveSpooled,
+			})
+
+			archiveCtx, stopArchive := context.WithCancel(context.Background())
+			chatArchiver.Start(archiveCtx, replayInterval)
+			defer func() {
+				// Drain the queue before cancelling the context deliveries use
+				chatArchiver.Close()
+				stopArchive()
+			}()
+			log.Info().Str("sink", sink.Name()).Msg("Chat archiving enabled")
+		}
+	}
+
+	// Transcript archive setup
+	if archiveURL := os.Getenv("TRANSCRIPT_ARCHIVE_URL"); archiveURL != "" {
+		store, err := objectstore.New(archiveURL)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to set up transcript archive")
+		} else {
+			archiveConfig := transcripts.DefaultConfig()
+			archiveConfig.BatchSize, _ = strconv.Atoi(getEnvOrDefault("TRANSCRIPT_ARCHIVE_BATCH_SIZE", "500"))
+			archiveConfig.FlushInterval, _ = time.ParseDuration(getEnvOrDefault("TRANSCRIPT_ARCHIVE_FLUSH_INTERVAL", "5m"))
+			archiveConfig.Gzip = getEnvOrDefault("TRANSCRIPT_ARCHIVE_GZIP", "true") == "true"
+			archiveConfig.RetentionDays, _ = strconv.Atoi(getEnvOrDefault("TRANSCRIPT_ARCHIVE_RETENTION_DAYS", "0"))
+			if value := getSecret("TRANSCRIPT_ARCHIVE_KEY", ""); value != "" {
+				archiveConfig.Key, err = transcripts.ParseKey(value)
+			}
+			if err == nil {
+				transcriptArchiver, err = transcripts.New(store, archiveConfig, transcripts.Metrics{
+					Uploads:  transcriptUploads,
+					Archived: transcriptsArchived,
+					Dropped:  transcriptsDropped,
+				})
+			}
+			if err != nil {
+				// Refuse to archive in plain text when encryption was asked for
+				log.Error().Err(err).Msg("Failed to set up transcript archive")
+			} else {
+				transcriptCtx, stopTranscripts := context.WithCancel(context.Background())
+				transcriptArchiver.Start(transcriptCtx)
+				defer func() {
+					stopTranscripts()
+					transcriptArchiver.Close()
+				}()
+				log.Info().Str("store", store.Name()).Bool("encrypted", archiveConfig.Key != nil).Msg("Transcript archiving enabled")
+			}
+		}
+	}
+
+	// Billing setup
+	if billingConfig, err := billing.LoadConfig(os.Getenv("BILLING_CONFIG")); err != nil {
+		log.Error().Err(err).Msg("Failed to load billing config, billing every key to the default team")
+	} else {
+		billingLedger = billing.NewLedger(billingConfig)
+	}
+	billingLedgerPath = os.Getenv("BILLING_LEDGER_PATH")
+	if billingLedgerPath != "" {
+		if err := billingLedger.Load(billingLedgerPath); err != nil {
+			log.Error().Err(err).Msg("Failed to load billing ledger, starting empty")
+		}
+	}
+	if exportURL := os.Getenv("BILLING_EXPORT_URL"); exportURL != "" {
+		store, err := objectstore.New(exportURL)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to set up billing export")
+		} else {
+			interval, err := time.ParseDuration(getEnvOrDefault("BILLING_EXPORT_INTERVAL", "1h"))
+			if err != nil || interval <= 0 {
+				interval = time.Hour
+			}
+			exporter := billing.NewExporter(billingLedger, store, billingLedgerPath, billing.ExportMetrics{Exports: billingExports})
+			exportCtx, stopExport := context.WithCancel(context.Background())
+			exporter.Start(exportCtx, interval)
+			defer func() {
+				stopExport()
+				// Write out the usage since the last run
+				exporter.Export(context.Background())
+			}()
+			log.Info().Str("store", store.Name()).Dur("interval", interval).Msg("Billing export enabled")
+		}
+	} else if billingLedgerPath != "" {
+		defer func() {
+			if err := billingLedger.Save(billingLedgerPath); err != nil {
+				log.Error().Err(err).Msg("Failed to save billing ledger")
+			}
+		}()
+	}
+
+	// Event bus setup
+	var publishers events.Publishers
+	if busURL := os.Getenv("EVENT_BUS_URL"); busURL != "" {
+		topics, err := events.ParseTopics(os.Getenv("EVENT_BUS_TOPICS"))
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid EVENT_BUS_TOPICS, event bus disabled")
+		}
+		types, typesErr := events.ParseTypes(os.Getenv("EVENT_BUS_TYPES"))
+		if typesErr != nil {
+			log.Error().Err(typesErr).Msg("Invalid EVENT_BUS_TYPES, event bus disabled")
+		}
+		queueSize, _ := strconv.Atoi(getEnvOrDefault("EVENT_BUS_QUEUE_SIZE", "1024"))
+		if err == nil && typesErr == nil {
+			bus, err := events.NewBus(events.BusConfig{
+				URL:       busURL,
+				Topics:    topics,
+				Types:     types,
+				Format:    getEnvOrDefault("EVENT_BUS_FORMAT", events.FormatAiwatch),
+				QueueSize: queueSize,
+			}, events.BusMetrics{Published: eventsPublished})
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to set up event bus")
+			} else {
+				bus.Start(context.Background())
+				defer bus.Close()
+				publishers = append(publishers, bus)
+				log.Info().Str("url", busURL).Msg("Chat lifecycle events enabled")
+			}
+		}
+	}
+
+	// Webhook setup
+	if webhookURLs := os.Getenv("WEBHOOK_URLS"); webhookURLs != "" {
+		types, err := events.ParseTypes(getEnvOrDefault("WEBHOOK_EVENTS", "completion_finished,error"))
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid WEBHOOK_EVENTS, webhooks disabled")
+		} else {
+			retries := resilience.DefaultConfig()
+			retries.MaxRetries, _ = strconv.Atoi(getEnvOrDefault("WEBHOOK_MAX_RETRIES", "3"))
+			retries.BaseDelay = time.Second
+			retries.MaxDelay = 30 * time.Second
+			client := &http.Client{
+				Transport: resilience.NewTransport(nil, retries, resilience.Metrics{Retries: webhookRetries}),
+				Timeout:   2 * time.Minute,
+			}
+			dispatcher := webhooks.New(webhooks.Config{
+				URLs:   strings.Split(webhookURLs, ","),
+				Secret: getSecret("WEBHOOK_SECRET", ""),
+				Events: types,
+			}, client, webhooks.Metrics{Deliveries: webhookDeliveries, Latency: webhookLatency})
+			dispatcher.Start(context.Background())
+			defer dispatcher.Close()
+			publishers = append(publishers, dispatcher)
+			log.Info().Int("endpoints", len(strings.Split(webhookURLs, ","))).Msg("Webhooks enabled")
+		}
+	}
+	if len(publishers) > 0 {
+		chatEvents = publishers
+	}
+
+	// Probe the upstream backend so /models can report live model status
+	probeInterval, err := time.ParseDuration(getEnvOrDefault("MODEL_PROBE_INTERVAL", "30s"))
+	if err != nil {
+		probeInterval = 30 * time.Second
+	}
+	probeCtx, stopProbing := context.WithCancel(context.Background())
+	defer stopProbing()
+	models.Tracker.StartProbing(probeCtx, baseURL, apiKey, probeInterval)
+	recentTotals.Start(probeCtx)
+
+	// Keep the docker model list cached so /models doesn't shell out per request
+	modelListTTL, err := time.ParseDuration(getEnvOrDefault("MODEL_LIST_TTL", "30s"))
+	if err != nil || modelListTTL <= 0 {
+		modelListTTL = 30 * time.Second
+	}
+	models.List.StartRefreshing(probeCtx, modelListTTL)
+	models.Lifecycle.Metrics = models.SwapMetrics{
+		Swaps:        modelSwaps,
+		LoadDuration: modelLoadDuration,
+		Pending:      modelSwapPending,
+	}
+	models.Lifecycle.StartSyncing(probeCtx, modelListTTL)
+
+	// Scrape the llama.cpp server's own /props and /metrics into the
+	// llamacpp_* gauges
+	llamacppURL := os.Getenv("LLAMACPP_URL")
+	if llamacppURL == "" && serving.LlamaCppMetrics {
+		llamacppURL = serving.ServerURL
+	}
+	scrapeInterval, err := time.ParseDuration(getEnvOrDefault("LLAMACPP_SCRAPE_INTERVAL", "15s"))
+	if err != nil {
+		scrapeInterval = 15 * time.Second
+	}
+	var memorySources []memory.Source
+	if llamacppURL != "" && scrapeInterval > 0 {
+		collector := llamacpp.New(llamacppURL, defaultModel, llamacpp.Metrics{
+			ContextSize:     llamacppContextSize,
+			Threads:         llamacppThreadsUsed,
+			BatchSize:       llamacppBatchSize,
+			TokensPerSecond: llamacppTokensPerSecond,
+			KVCacheUsage:    llamacppKVCacheUsage,
+			KVCacheTokens:   llamacppKVCacheTokens,
+		})
+		collector.Start(probeCtx, scrapeInterval)
+		log.Info().Str("url", llamacppURL).Dur("interval", scrapeInterval).Msg("Scraping llama.cpp server metrics")
+
+		memorySources = append(memorySources, func(ctx context.Context) (map[string]memory.Sample, error) {
+			stats, err := collector.Scrape(ctx)
+			if err != nil {
+				return nil, err
+			}
+			sample := memory.Unknown()
+			if stats.HasKVCache {
+				sample.KVCacheUsage, sample.KVCacheTokens = stats.KVCacheUsage, stats.KVCacheTokens
+			}
+			return map[string]memory.Sample{defaultModel: sample}, nil
+		})
+	}
+
+	// Scrape vLLM's Prometheus metrics into the vllm_* gauges
+	vllmURL := os.Getenv("VLLM_URL")
+	if vllmURL == "" && serving.PrometheusMetrics {
+		vllmURL = serving.ServerURL
+	}
+	vllmInterval, err := time.ParseDuration(getEnvOrDefault("VLLM_SCRAPE_INTERVAL", "15s"))
+	if err != nil {
+		vllmInterval = 15 * time.Second
+	}
+	if vllmURL != "" && vllmInterval > 0 {
+		vllmCollector = vllm.New(vllmURL, defaultModel, vllm.Metrics{
+			RequestsRunning:    vllmRequestsRunning,
+			RequestsWaiting:    vllmRequestsWaiting,
+			KVCacheUsage:       vllmKVCacheUsage,
+			PrefixCacheHitRate: vllmPrefixCacheHitRate,
+			SpecDecodeAcceptanceRate: specDecodeAcceptanceRate,
+		})
+		vllmCollector.Start(probeCtx, vllmInterval)
+		log.Info().Str("url", vllmURL).Dur("interval", vllmInterval).Msg("Scraping vLLM server metrics")
+
+		memorySources = append(memorySources, func(ctx context.Context) (map[string]memory.Sample, error) {
+			stats, err := vllmCollector.Scrape(ctx)
+			if err != nil {
+				return nil, err
+			}
+			samples := make(map[string]memory.Sample, len(stats))
+			for model, s := range stats {
+				sample := memory.Unknown()
+				sample.KVCacheUsage = s.KVCacheUsage
+				samples[model] = sample
+			}
+			return samples, nil
+		})
+	}
+	if serving.Type == backend.Ollama {
+		client := ollama.New(baseURL)
+		memorySources = append(memorySources, func(ctx context.Context) (map[string]memory.Sample, error) {
+			running, err := client.Running(ctx)
+			if err != nil {
+				return nil, err
+			}
+			samples := make(map[string]memory.Sample, len(running))
+			for _, m := range running {
+				sample := memory.Unknown()
+				sample.ModelBytes, sample.VRAMBytes = float64(m.SizeBytes), float64(m.VRAMBytes)
+				samples[m.Name] = sample
+			}
+			return samples, nil
+		})
+	}
+
+	// Sample the model container's CPU, memory and block I/O, so latency
+	// spikes can be matched with throttling. Pods have no Docker socket.
+	containerInterval, err := time.ParseDuration(getEnvOrDefault("MODEL_CONTAINER_STATS_INTERVAL", "15s"))
+	if err != nil {
+		containerInterval = 15 * time.Second
+	}
+	if containerInterval > 0 && !kubePod.Enabled {
+		dockerHost := getEnvOrDefault("DOCKER_HOST", "unix:///var/run/docker.sock")
+		if collector, err := container.New(dockerHost, os.Getenv("MODEL_CONTAINER"), container.Metrics{
+			CPU:              containerCPU,
+			Memory:           containerMemory,
+			MemoryLimit:      containerMemoryLimit,
+			BlockRead:        containerBlockRead,
+			BlockWrite:       containerBlockWrite,
+			ThrottledSeconds: containerThrottled,
+		}); err != nil {
+			log.Warn().Err(err).Msg("Invalid DOCKER_HOST, not sampling model container stats")
+		} else {
+			modelContainer = collector
+			modelContainer.Start(probeCtx, containerInterval)
+			log.Info().Str("docker_host", dockerHost).Dur("interval", containerInterval).Msg("Sampling model container stats")
+		}
+	}
+
+	// Watch KV cache and model memory, alerting before the model runs out
+	memoryThreshold, err := strconv.ParseFloat(getEnvOrDefault("MEMORY_ALERT_THRESHOLD", "0.9"), 64)
+	if err != nil {
+		memoryThreshold = 0.9
+	}
+	memoryInterval, err := time.ParseDuration(getEnvOrDefault("MEMORY_POLL_INTERVAL", "15s"))
+	if err != nil {
+		memoryInterval = 15 * time.Second
+	}
+	if len(memorySources) > 0 && memoryInterval > 0 {
+		memoryMonitor = memory.NewMonitor(memoryThreshold, memory.Metrics{
+			KVCacheUsage:  kvCacheUsage,
+			ModelBytes:    modelMemoryBytes,
+			High:          kvCacheHigh,
+			Alerts:        kvCacheAlerts,
+			RequestGrowth: requestKVCacheGrowth,
+			Lost:          modelMemoryLost,
+		}, memorySources...)
+		memoryMonitor.Start(probeCtx, memoryInterval)
+	}
+
+	// Discover what each model supports so /models can report capabilities
+	capabilityInterval, err := time.ParseDuration(getEnvOrDefault("MODEL_CAPABILITY_INTERVAL", "5m"))
+	if err != nil {
+		capabilityInterval = 5 * time.Minute
+	}
+	models.Discovery.StartDiscovery(probeCtx, baseURL, apiKey, capabilityInterval)
+
+	// Retry transient upstream failures and stop hammering a failing backend
+	resilienceConfig := resilience.DefaultConfig()
+	if retries, err := strconv.Atoi(getEnvOrDefault("UPSTREAM_MAX_RETRIES", "2")); err == nil {
+		resilienceConfig.MaxRetries = retries
+	}
+	if delay, err := time.ParseDuration(getEnvOrDefault("UPSTREAM_RETRY_DELAY", "200ms")); err == nil {
+		resilienceConfig.BaseDelay = delay
+	}
+	if threshold, err := strconv.Atoi(getEnvOrDefault("CIRCUIT_FAILURE_THRESHOLD", "5")); err == nil {
+		resilienceConfig.FailureThreshold = threshold
+	}
+	if timeout, err := time.ParseDuration(getEnvOrDefault("CIRCUIT_OPEN_TIMEOUT", "30s")); err == nil {
+		resilienceConfig.OpenTimeout = timeout
+	}
+	// Keep enough connections to the model servers warm that requests rarely
+	// wait on a dial or handshake before their first token
+	upstreamConfig := upstream.DefaultConfig()
+	if n, err := strconv.Atoi(getEnvOrDefault("UPSTREAM_MAX_IDLE_CONNS", "")); err == nil {
+		upstreamConfig.MaxIdleConns = n
+	}
+	if n, err := strconv.Atoi(getEnvOrDefault("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", "")); err == nil {
+		upstreamConfig.MaxIdleConnsPerHost = n
+	}
+	if n, err := strconv.Atoi(getEnvOrDefault("UPSTREAM_MAX_CONNS_PER_HOST", "")); err == nil {
+		upstreamConfig.MaxConnsPerHost = n
+	}
+	if timeout, err := time.ParseDuration(getEnvOrDefault("UPSTREAM_IDLE_CONN_TIMEOUT", "")); err == nil {
+		upstreamConfig.IdleConnTimeout = timeout
+	}
+	if n, err := strconv.Atoi(getEnvOrDefault("UPSTREAM_TLS_SESSION_CACHE", "")); err == nil {
+		upstreamConfig.TLSSessionCache = n
+	}
+	if enabled, err := strconv.ParseBool(getEnvOrDefault("UPSTREAM_HTTP2", "")); err == nil {
+		upstreamConfig.HTTP2 = enabled
+	}
+	upstreamConns := upstream.NewTransport(upstreamConfig, upstream.Metrics{
+		Connections:  upstreamConnections,
+		DNSDuration:  upstreamDNSDuration,
+		TLSHandshake: upstreamTLSHandshake,
+	})
+	upstreamTransport := resilience.NewTransport(upstreamConns, resilienceConfig, resilience.Metrics{
+		Retries:      upstreamRetries,
+		CircuitState: circuitState,
+	})
+
+	// Spread requests over several backends per model, failing over between them
+	routingConfig, err := routing.LoadConfig(os.Getenv("ROUTING_FILE"))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load routing config, using BASE_URL only")
+	}
+	if urls := os.Getenv("BASE_URLS"); urls != "" && len(routingConfig.Backends) == 0 {
+		for _, url := range strings.Split(urls, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				routingConfig.Backends = append(routingConfig.Backends, url)
+			}
+		}
+	}
+	if strategy := os.Getenv("ROUTING_STRATEGY"); strategy != "" {
+		routingConfig.Strategy = strategy
+	}
+	backendRouter, err := routing.New(baseURL, routingConfig, upstreamTransport, routing.Metrics{
+		Requests:  backendRequests,
+		Latency:   backendLatency,
+		Healthy:   backendHealthy,
+		Failovers: backendFailovers,
+		Affinity:  backendAffinity,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid routing config, using BASE_URL only")
+		backendRouter, _ = routing.New(baseURL, routing.Config{}, upstreamTransport, routing.Metrics{})
+	}
+	upstreamClient := &http.Client{Transport: backendRouter}
+
+	// Probe the model backends and optional dependencies for readiness. Each
+	// replica is probed directly rather than through the router.
+	probeTTL, err := time.ParseDuration(getEnvOrDefault("HEALTH_PROBE_TTL", "10s"))
+	if err != nil {
+		probeTTL = 10 * time.Second
+	}
+	probeTimeout, err := time.ParseDuration(getEnvOrDefault("HEALTH_PROBE_TIMEOUT", "5s"))
+	if err != nil {
+		probeTimeout = 5 * time.Second
+	}
+	var dependencies []health.Backend
+	for _, b := range backendRouter.Status() {
+		dependencies = append(dependencies, health.Backend{
+			Name:  "model:" + b.URL,
+			Group: "model",
+			Probe: health.HTTPProbe(http.DefaultClient, strings.TrimRight(b.URL, "/")+"/models", apiKey),
+		})
+	}
+	if judgeURL := os.Getenv("JUDGE_BASE_URL"); judgeURL != "" && os.Getenv("JUDGE_MODEL") != "" {
+		dependencies = append(dependencies, health.Backend{
+			Name:  "judge",
+			Probe: health.HTTPProbe(http.DefaultClient, strings.TrimRight(judgeURL, "/")+"/models", getSecret("JUDGE_API_KEY", apiKey)),
+		})
+	}
+	if qdrantURL := os.Getenv("QDRANT_URL"); qdrantURL != "" && (os.Getenv("RAG_EMBEDDING_MODEL") != "" || os.Getenv("HISTORY_SEARCH_EMBEDDING_MODEL") != "") {
+		dependencies = append(dependencies, health.Backend{
+			Name:  "qdrant",
+			Probe: health.HTTPProbe(http.DefaultClient, strings.TrimRight(qdrantURL, "/")+"/readyz", ""),
+		})
+	}
+	readiness := health.NewChecker(probeTTL, probeTimeout, health.Metrics{
+		Up:      dependencyUp,
+		Latency: dependencyProbeLatency,
+	}, dependencies...)
+
+	// Create OpenAI client
+	client := openai.NewClient(
+		option.WithBaseURL(baseURL),
+		option.WithAPIKey(apiKey),
+		option.WithHTTPClient(upstreamClient),
+		option.WithMaxRetries(0),
+		option.WithMiddleware(rotatedAPIKey),
+	)
+
+	// Load sampling profiles
+	if profiles, err := sampling.Load(os.Getenv("SAMPLING_PROFILES_FILE")); err != nil {
+		log.Error().Err(err).Msg("Failed to load sampling profiles, using built-in profiles")
+	} else {
+		samplingProfiles = profiles
+	}
+
+	// Load prompt templates
+	if store, err := prompts.NewStore(os.Getenv("PROMPTS_FILE")); err != nil {
+		log.Error().Err(err).Msg("Failed to load prompt templates, using built-in templates")
+	} else {
+		promptTemplates = store
+	}
+
+	// Load guardrails
+	if guardrailConfig, err := guardrails.LoadConfig(os.Getenv("GUARDRAILS_FILE")); err != nil {
+		log.Error().Err(err).Msg("Failed to load guardrails config, guardrails disabled")
+	} else if pipeline, err := guardrails.New(guardrailConfig, guardrailBlocks); err != nil {
+		log.Error().Err(err).Msg("Invalid guardrails config, guardrails disabled")
+	} else {
+		chatGuardrails = pipeline
+	}
+
+	// Configure prompt compression
+	if methods, err := compression.ParseMethods(os.Getenv("PROMPT_COMPRESSION")); err != nil {
+		log.Error().Err(err).Msg("Invalid PROMPT_COMPRESSION, prompt compression disabled")
+	} else {
+		minTokens, _ := strconv.Atoi(getEnvOrDefault("PROMPT_COMPRESSION_MIN_TOKENS", "200"))
+		rate, _ := strconv.ParseFloat(getEnvOrDefault("PROMPT_COMPRESSION_RATE", "0.5"), 64)
+		compressor, err := compression.New(compression.Config{
+			Methods:       methods,
+			MinTokens:     minTokens,
+			CompressorURL: os.Getenv("PROMPT_COMPRESSOR_URL"),
+			Rate:          rate,
+		}, nil, promptTokensSaved)
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid prompt compression config, prompt compression disabled")
+		} else {
+			promptCompressor = compressor
+		}
+	}
+
+	// Load A/B experiments
+	if experimentConfig, err := experiments.LoadConfig(os.Getenv("EXPERIMENTS_FILE")); err != nil {
+		log.Error().Err(err).Msg("Failed to load experiments config, experiments disabled")
+	} else if manager, err := experiments.New(experimentConfig, experimentMetrics()); err != nil {
+		log.Error().Err(err).Msg("Invalid experiments config, experiments disabled")
+	} else {
+		chatExperiments = manager
+	}
+
+	// Configure streaming output moderation
+	if moderationURL := os.Getenv("MODERATION_URL"); moderationURL != "" {
+		outputModerator = guardrails.NewModeration(guardrails.ModerationConfig{
+			URL:    moderationURL,
+			Model:  os.Getenv("MODERATION_MODEL"),
+			APIKey: getSecret("MODERATION_API_KEY", ""),
+		})
+		moderationPolicyMessage = getEnvOrDefault("MODERATION_POLICY_MESSAGE", moderation.DefaultPolicyMessage)
+		log.Info().Str("url", moderationURL).Msg("Output moderation enabled")
+	}
+
+	// Start the LLM-as-judge evaluator
+	if judgeModel := os.Getenv("JUDGE_MODEL"); judgeModel != "" {
+		judgeClient := client
+		if judgeURL := os.Getenv("JUDGE_BASE_URL"); judgeURL != "" {
+			judgeClient = openai.NewClient(
+				option.WithBaseURL(judgeURL),
+				option.WithAPIKey(getSecret("JUDGE_API_KEY", apiKey)),
+			)
+		}
+		samplePercent, err := strconv.ParseFloat(getEnvOrDefault("JUDGE_SAMPLE_PERCENT", "10"), 64)
+		if err != nil {
+			samplePercent = 10
+		}
+		responseEvaluator = evaluation.New(judgeClient, judgeModel, samplePercent, evaluation.Metrics{
+			Scores:      evaluationScores,
+			Evaluations: evaluationsCounter,
+		}, func(job evaluation.Job, scores evaluation.Scores) {
+			conversations.Update(job.ConversationID, func(c *history.Conversation) {
+				c.Scores = scores
+			})
+		})
+		evaluatorCtx, stopEvaluator := context.WithCancel(context.Background())
+		responseEvaluator.Start(evaluatorCtx, 2)
+		defer func() {
+			stopEvaluator()
+			responseEvaluator.Close()
+		}()
+		log.Info().Str("judge_model", judgeModel).Float64("sample_percent", samplePercent).Msg("Response evaluation enabled")
+	}
+
+	// Classify completed chats by keyword, or with a small model if one is set
+	taxonomy, err := classify.LoadTaxonomy(os.Getenv("CLASSIFIER_TAXONOMY_FILE"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid CLASSIFIER_TAXONOMY_FILE")
+	}
+	classifierClient := client
+	if classifierURL := os.Getenv("CLASSIFIER_BASE_URL"); classifierURL != "" {
+		classifierClient = openai.NewClient(
+			option.WithBaseURL(classifierURL),
+			option.WithAPIKey(getSecret("CLASSIFIER_API_KEY", apiKey)),
+		)
+	}
+	chatClassifier = classify.New(taxonomy, classifierClient, os.Getenv("CLASSIFIER_MODEL"), classify.Metrics{
+		Categories:      chatCategories,
+		Classifications: classificationsCounter,
+	}, func(job classify.Job, categories []string) {
+		conversations.Update(job.ConversationID, func(c *history.Conversation) {
+			c.Categories = classify.Merge(c.Categories, categories)
+		})
+	})
+	classifierCtx, stopClassifier := context.WithCancel(context.Background())
+	chatClassifier.Start(classifierCtx, 2)
+	defer func() {
+		stopClassifier()
+		chatClassifier.Close()
+	}()
+
+	// Title and summarize conversations for the history browser
+	conversationSummarizer = summarize.New(client, getEnvOrDefault("SUMMARY_MODEL", defaultModel), conversations, summarize.Metrics{
+		Summaries: conversationSummaries,
+	})
+	if every, err := strconv.Atoi(getEnvOrDefault("SUMMARY_REFRESH_MESSAGES", "")); err == nil {
+		conversationSummarizer.RefreshEvery = every
+	}
+	summarizerCtx, stopSummarizer := context.WithCancel(context.Background())
+	conversationSummarizer.Start(summarizerCtx, 1)
+	defer func() {
+		stopSummarizer()
+		conversationSummarizer.Close()
+	}()
+
+	// Configure drift detection
+	driftConfig := drift.DefaultConfig()
+	if window, err := time.ParseDuration(getEnvOrDefault("DRIFT_WINDOW", "1h")); err == nil {
+		driftConfig.Window = window
+	}
+	if minSamples, err := strconv.Atoi(getEnvOrDefault("DRIFT_MIN_SAMPLES", "50")); err == nil {
+		driftConfig.MinSamples = minSamples
+	}
+	driftDetector = drift.New(driftConfig, driftMetrics())
+	driftEmbeddingModel = os.Getenv("DRIFT_EMBEDDING_MODEL")
+
+	// Configure the RAG document library
+	if ragModel := os.Getenv("RAG_EMBEDDING_MODEL"); ragModel != "" {
+		ragConfig := rag.DefaultConfig()
+		if topK, err := strconv.Atoi(getEnvOrDefault("RAG_TOP_K", "")); err == nil {
+			ragConfig.TopK = topK
+		}
+		if chunkTokens, err := strconv.Atoi(getEnvOrDefault("RAG_CHUNK_TOKENS", "")); err == nil {
+			ragConfig.ChunkTokens = chunkTokens
+		}
+		if overlap, err := strconv.Atoi(getEnvOrDefault("RAG_CHUNK_OVERLAP", "")); err == nil {
+			ragConfig.ChunkOverlap = overlap
+		}
+		if minScore, err := strconv.ParseFloat(getEnvOrDefault("RAG_MIN_SCORE", ""), 64); err == nil {
+			ragConfig.MinScore = minScore
+		}
+
+		var ragStore rag.Store = rag.NewMemoryStore()
+		if qdrantURL := os.Getenv("QDRANT_URL"); qdrantURL != "" {
+			ragStore = rag.NewQdrantStore(qdrantURL, getEnvOrDefault("QDRANT_COLLECTION", "aiwatch"), getSecret("QDRANT_API_KEY", ""))
+		}
+		ragIndex = rag.New(ragStore, embedTexts(client, ragModel), ragConfig, rag.Metrics{
+			Documents: ragDocuments,
+			Chunks:    ragChunks,
+			Retrieved: ragChunksRetrieved,
+			Latency:   ragRetrievalLatency,
+			Scores:    ragHitScores,
+			AvgScore:  ragAverageScore,
+		})
+		log.Info().Str("embedding_model", ragModel).Bool("qdrant", os.Getenv("QDRANT_URL") != "").Msg("RAG document library enabled")
+	}
+
+	// Configure the conversation history store
+	historyCacheMB, _ := strconv.Atoi(getEnvOrDefault("HISTORY_CACHE_MB", "64"))
+	var historyBackend history.Backend
+	if dir := os.Getenv("HISTORY_DIR"); dir != "" {
+		backend, err := history.NewDirBackend(dir)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open history directory, keeping history in memory only")
+		} else {
+			historyBackend = backend
+		}
+	}
+	conversations = history.New(historyBackend, int64(historyCacheMB)<<20, historyMetrics())
+
+	// Keep a per-request log for export, priced by MODEL_PRICES
+	requestLogConfig := requestlog.DefaultConfig()
+	requestLogConfig.Path = os.Getenv("REQUEST_LOG_PATH")
+	if n, err := strconv.Atoi(getEnvOrDefault("REQUEST_LOG_MAX_RECORDS", "")); err == nil && n > 0 {
+		requestLogConfig.MaxRecords = n
+	}
+	if prices, err := requestlog.ParsePrices(os.Getenv("MODEL_PRICES")); err != nil {
+		log.Fatal().Err(err).Msg("Invalid MODEL_PRICES")
+	} else {
+		requestLogConfig.Prices = prices
+	}
+	requestLog, err = requestlog.New(requestLogConfig, requestlog.Metrics{
+		Entries:  requestLogEntries,
+		Exported: requestLogExports,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to open request log")
+	}
+	defer requestLog.Close()
+
+	// Send daily and weekly usage reports
+	reportPeriods, err := reports.ParsePeriods(os.Getenv("REPORT_SCHEDULE"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid REPORT_SCHEDULE")
+	}
+	reportHour, err := strconv.Atoi(getEnvOrDefault("REPORT_HOUR", "8"))
+	if err != nil || reportHour < 0 || reportHour > 23 {
+		log.Fatal().Str("value", os.Getenv("REPORT_HOUR")).Msg("REPORT_HOUR must be an hour of the day from 0 to 23")
+	}
+	var reportSenders []reports.Sender
+	if webhook := getSecret("REPORT_SLACK_WEBHOOK_URL", ""); webhook != "" {
+		reportSenders = append(reportSenders, &reports.Slack{URL: webhook})
+	}
+	if to := os.Getenv("REPORT_EMAIL_TO"); to != "" {
+		smtpAddr := os.Getenv("SMTP_ADDR")
+		if smtpAddr == "" {
+			log.Fatal().Msg("REPORT_EMAIL_TO requires SMTP_ADDR")
+		}
+		reportSenders = append(reportSenders, &reports.Email{
+			Addr:     smtpAddr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: getSecret("SMTP_PASSWORD", ""),
+			From:     getEnvOrDefault("REPORT_EMAIL_FROM", "aiwatch@localhost"),
+			To:       strings.Split(to, ","),
+		})
+	}
+	usageReports = reports.New(reports.Config{Periods: reportPeriods, Hour: reportHour}, reports.Source{
+		Requests: func(from, to time.Time) []requestlog.Record {
+			return requestLog.Records(requestlog.Filter{From: from, To: to})
+		},
+		Prompts: conversationPrompts,
+	}, reportSenders, reports.Metrics{Reports: reportsSent})
+	if len(reportPeriods) > 0 {
+		if len(reportSenders) == 0 {
+			log.Fatal().Msg("REPORT_SCHEDULE requires REPORT_SLACK_WEBHOOK_URL or REPORT_EMAIL_TO")
+		}
+		reportsCtx, stopReports := context.WithCancel(context.Background())
+		usageReports.Start(reportsCtx)
+		defer stopReports()
+		log.Info().Strs("periods", reportPeriods).Int("hour_utc", reportHour).Int("channels", len(reportSenders)).Msg("Usage reports scheduled")
+	}
+
+	// Search conversations by meaning as well as by words. Conversations get
+	// their own collection so document retrieval never returns them.
+	if searchModel := os.Getenv("HISTORY_SEARCH_EMBEDDING_MODEL"); searchModel != "" {
+		var vectorStore rag.Store = rag.NewMemoryStore()
+		if qdrantURL := os.Getenv("QDRANT_URL"); qdrantURL != "" {
+			vectorStore = rag.NewQdrantStore(qdrantURL, getEnvOrDefault("HISTORY_SEARCH_COLLECTION", "aiwatch_conversations"), getSecret("QDRANT_API_KEY", ""))
+		}
+		conversations.SetVectorIndex(history.NewVectorIndex(vectorStore, embedTexts(client, searchModel)))
+		log.Info().Str("embedding_model", searchModel).Bool("qdrant", os.Getenv("QDRANT_URL") != "").Msg("Semantic conversation search enabled")
+	}
+
+	// Anonymize personal data before it is stored
+	if kinds, err := privacy.ParseKinds(os.Getenv("PII_ANONYMIZE")); err != nil {
+		log.Error().Err(err).Msg("Invalid PII_ANONYMIZE, storing conversations as sent")
+	} else if len(kinds) > 0 {
+		minScore, err := strconv.ParseFloat(getEnvOrDefault("PII_NER_MIN_SCORE", "0.5"), 64)
+		if err != nil {
+			minScore = 0.5
+		}
+		piiAnonymizer = privacy.New(privacy.Config{
+			Kinds:    kinds,
+			NERURL:   os.Getenv("PII_NER_URL"),
+			NERToken: getSecret("PII_NER_TOKEN", ""),
+			MinScore: minScore,
+		}, privacy.Metrics{Detections: piiDetections, NERErrors: piiNERErrors})
+		log.Info().Strs("types", kinds).Bool("ner", os.Getenv("PII_NER_URL") != "").Msg("PII anonymization enabled")
+	}
+
+	// Retention setup: purge history, feedback and audit entries past their age
+	auditLog = audit.New(os.Getenv("AUDIT_LOG_PATH"))
+	var retentionTargets []retention.Target
+	for _, target := range []retention.Target{
+		{Name: "history", Purge: func(cutoff time.Time) (int, error) {
+			ids, err := conversations.DeleteWhere(func(c history.Conversation) bool { return c.UpdatedAt.Before(cutoff) })
+			return len(ids), err
+		}},
+		{Name: "feedback", Purge: conversations.ClearFeedback},
+		{Name: "audit", Purge: auditLog.Purge},
+	} {
+		age, err := retention.ParseAge(os.Getenv("RETENTION_" + strings.ToUpper(target.Name)))
+		if err != nil {
+			log.Error().Err(err).Str("data", target.Name).Msg("Ignoring retention period")
+			continue
+		}
+		if age > 0 {
+			target.MaxAge = age
+			retentionTargets = append(retentionTargets, target)
+		}
+	}
+	if len(retentionTargets) > 0 {
+		purgeInterval, err := time.ParseDuration(getEnvOrDefault("RETENTION_PURGE_INTERVAL", "1h"))
+		if err != nil || purgeInterval <= 0 {
+			purgeInterval = time.Hour
+		}
+		purger := retention.NewPurger(auditLog, retention.Metrics{Purged: retentionPurged}, retentionTargets...)
+		purgeCtx, stopPurge := context.WithCancel(context.Background())
+		defer stopPurge()
+		purger.Start(purgeCtx, purgeInterval)
+		log.Info().Int("policies", len(retentionTargets)).Dur("interval", purgeInterval).Msg("Data retention enabled")
+	}
+
+	// Start the generation watchdog
+	watchdogMaxDuration, err := time.ParseDuration(getEnvOrDefault("WATCHDOG_MAX_DURATION", "10m"))
+	if err != nil {
+		watchdogMaxDuration = 10 * time.Minute
+	}
+	watchdogMaxTokens, _ := strconv.Atoi(getEnvOrDefault("WATCHDOG_MAX_TOKENS", "8192"))
+	generationWatchdog = watchdog.New(watchdogMaxDuration, watchdogMaxTokens, watchdogKills)
+	watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+	defer stopWatchdog()
+	generationWatchdog.Start(watchdogCtx, time.Second)
+
+	// Per-request generation limits, kept below the server's write timeout
+	if timeout, err := time.ParseDuration(getEnvOrDefault("GENERATION_TIMEOUT", "75s")); err == nil {
+		generationTimeout = timeout
+	}
+	generationMaxTokens, _ = strconv.Atoi(getEnvOrDefault("GENERATION_MAX_TOKENS", "0"))
+
+	// Cap the streamed output rate, for fair sharing or to simulate slower models
+	pacingConfig := pacing.Config{}
+	if rate := os.Getenv("OUTPUT_RATE_LIMIT"); rate != "" {
+		pacingConfig.Default, err = strconv.ParseFloat(rate, 64)
+		if err != nil || pacingConfig.Default < 0 {
+			log.Fatal().Str("value", rate).Msg("Invalid OUTPUT_RATE_LIMIT, want tokens per second")
+		}
+	}
+	pacingConfig.Classes, err = pacing.ParseClasses(os.Getenv("OUTPUT_RATE_LIMITS"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid OUTPUT_RATE_LIMITS")
+	}
+	outputPacing = pacing.New(pacingConfig, pacing.Metrics{
+		Configured: outputRateLimit,
+		Actual:     outputRate,
+		Delay:      outputRateDelay,
+	})
+
+	contextOverflowAction = getEnvOrDefault("CONTEXT_OVERFLOW_ACTION", "truncate")
+	if contextOverflowAction != "truncate" && contextOverflowAction != "reject" {
+		log.Warn().Str("action", contextOverflowAction).Msg("Unknown CONTEXT_OVERFLOW_ACTION, using truncate")
+		contextOverflowAction = "truncate"
+	}
+
+	memoryTruncationStrategy = getEnvOrDefault("MEMORY_TRUNCATION_STRATEGY", truncation.MiddleOut)
+	if !truncation.IsValid(memoryTruncationStrategy) {
+		log.Warn().Str("strategy", memoryTruncationStrategy).Msg("Unknown MEMORY_TRUNCATION_STRATEGY, using middle_out")
+		memoryTruncationStrategy = truncation.MiddleOut
+	}
+
+	if interval, err := time.ParseDuration(getEnvOrDefault("METRICS_STREAM_INTERVAL", "2s")); err == nil && interval >= time.Second {
+		metricsStreamInterval = interval
+	}
+
+	// Configure usage quotas
+	quotaTokensPerDay, _ := strconv.Atoi(getEnvOrDefault("QUOTA_TOKENS_PER_DAY", "0"))
+	quotaRequestsPerHour, _ := strconv.Atoi(getEnvOrDefault("QUOTA_REQUESTS_PER_HOUR", "0"))
+	quotaConfig, err := quota.LoadConfig(os.Getenv("QUOTA_FILE"), quota.Limits{
+		TokensPerDay:    quotaTokensPerDay,
+		RequestsPerHour: quotaRequestsPerHour,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load quota config, using defaults")
+	}
+	usageQuotas = quota.New(quotaConfig, quotaRejections)
+
+	// Configure tenants, which partition conversations, quotas and models
+	// between the teams sharing the instance
+	if registry, err := tenants.Load(os.Getenv("TENANTS_FILE"), tenantRequests); err != nil {
+		log.Error().Err(err).Msg("Failed to load tenants, all requests use the default tenant")
+	} else {
+		tenantRegistry = registry
+	}
+	usageQuotas.Tenants = tenantRegistry
+
+	// Configure single sign-on; without an issuer the API stays open
+	var ssoAuth *oidc.Authenticator
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		roles, err := oidc.ParseRoles(os.Getenv("OIDC_ROLE_MAP"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid OIDC_ROLE_MAP")
+		}
+		ssoConfig := oidc.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: getSecret("OIDC_CLIENT_SECRET", ""),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Audience:     os.Getenv("OIDC_AUDIENCE"),
+			GroupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+			Roles:        roles,
+			DefaultRole:  os.Getenv("OIDC_DEFAULT_ROLE"),
+			CookieSecret: []byte(getSecret("OIDC_COOKIE_SECRET", "")),
+			PublicPaths:  strings.Split(getEnvOrDefault("OIDC_PUBLIC_PATHS", "/health,/health/,/metrics"), ","),
+		}
+		if scopes := os.Getenv("OIDC_SCOPES"); scopes != "" {
+			ssoConfig.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+		}
+		if ttl, err := time.ParseDuration(getEnvOrDefault("OIDC_SESSION_TTL", "8h")); err == nil {
+			ssoConfig.SessionTTL = ttl
+		}
+		ssoAuth, err = oidc.New(ssoConfig, oidc.Metrics{Logins: authLogins, Rejections: authRejections})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid single sign-on configuration")
+		}
+		log.Info().Str("issuer", issuer).Msg("Single sign-on enabled")
+	}
+
+	// Register runtime feature flags
+	flags.Default.Register("markdown_detection", true)
+	flags.Default.Register("output_postprocessing", true)
+	flags.Default.Register("history_truncation", true)
+	flags.Default.Register("archive", true)
+	flags.Default.Register("guardrails", true)
+	flags.Default.Register("output_moderation", true)
+	flags.Default.Register("model_hot_swap", false)
+	flags.Default.Register("stop_enforcement", true)
+	flags.Default.Register("classification", true)
+	flags.Default.Register("conversation_summaries", true)
+
+	// Summarize conversations stored before summaries were turned on
+	if flags.Default.Enabled("conversation_summaries") {
+		go func() {
+			if err := conversationSummarizer.Backfill(summarizerCtx); err != nil && summarizerCtx.Err() == nil {
+				log.Warn().Err(err).Msg("Failed to queue conversations for summaries")
+			}
+		}()
+	}
+
 	// Create router
 	mux := http.NewServeMux()
+	inflight := middleware.NewInflightTracker()
+
+	// Apply middleware
+	sessionWindow, err := time.ParseDuration(getEnvOrDefault("SESSION_ACTIVE_WINDOW", "15m"))
+	if err != nil {
+		sessionWindow = 15 * time.Minute
+	}
+	sessions = session.NewTracker(sessionWindow)
+	sessions.IgnorePrefixes = []string{"/metrics", "/health", "/admin", "/dashboard"}
+
+	// Cross-origin policy for browser clients; by default only the bundled
+	// frontend may call the API from a browser
+	corsConfig := middleware.CORSConfig{
+		AllowCredentials: getEnvOrDefault("CORS_ALLOW_CREDENTIALS", "false") == "true",
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		ExposedHeaders:   []string{"X-Conversation-ID", "X-Message-ID", "X-Request-ID", "Retry-After", "API-Version", "Deprecation", "Sunset", "Link"},
+		MaxAge:           10 * time.Minute,
+	}
+	for _, origin := range strings.Split(getEnvOrDefault("CORS_ALLOWED_ORIGINS", defaultCORSOrigin), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			corsConfig.AllowedOrigins = append(corsConfig.AllowedOrigins, origin)
+		}
+	}
+	if maxAge, err := time.ParseDuration(getEnvOrDefault("CORS_MAX_AGE", "10m")); err == nil {
+		corsConfig.MaxAge = maxAge
+	}
+	if slices.Contains(corsConfig.AllowedOrigins, "*") {
+		log.Warn().Msg("CORS_ALLOWED_ORIGINS includes *, so any website can call the API from its visitors' browsers")
+		if corsConfig.AllowCredentials {
+			log.Warn().Msg("CORS_ALLOW_CREDENTIALS is ignored while CORS_ALLOWED_ORIGINS includes *")
+		}
+	}
+
+	// JSON, CSV and other documents are compressed for clients that accept
+	// it; event streams and the chat stream are left alone
+	compressConfig := middleware.DefaultCompressConfig()
+	if level := os.Getenv("COMPRESSION_GZIP_LEVEL"); level != "" {
+		n, err := strconv.Atoi(level)
+		if err != nil || n < gzip.BestSpeed || n > gzip.BestCompression {
+			log.Fatal().Str("value", level).Msg("COMPRESSION_GZIP_LEVEL must be between 1 and 9")
+		}
+		compressConfig.GzipLevel = n
+	}
+	if level := os.Getenv("COMPRESSION_BROTLI_LEVEL"); level != "" {
+		n, err := strconv.Atoi(level)
+		if err != nil || n < 1 || n > 11 {
+			log.Fatal().Str("value", level).Msg("COMPRESSION_BROTLI_LEVEL must be between 1 and 11")
+		}
+		compressConfig.BrotliLevel = n
+	}
+	if n, err := strconv.Atoi(getEnvOrDefault("COMPRESSION_MIN_BYTES", "")); err == nil {
+		compressConfig.MinBytes = n
+	}
+	compressionEnabled := getEnvOrDefault("COMPRESSION_ENABLED", "true") == "true"
+	compress := middleware.Compress(compressConfig, middleware.CompressMetrics{
+		Responses:  compressedResponses,
+		BytesSaved: compressionBytesSaved,
+	})
+
+	// Each route gets its own deadline: JSON endpoints a short one, while
+	// generations and exports run as long as they keep sending
+	timeoutConfig := timeouts.DefaultConfig()
+	for key, target := range map[string]*time.Duration{
+		"REQUEST_TIMEOUT":     &timeoutConfig.Default,
+		"STREAM_TIMEOUT":      &timeoutConfig.Stream,
+		"STREAM_IDLE_TIMEOUT": &timeoutConfig.Idle,
+	} {
+		if value := os.Getenv(key); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Invalid " + key)
+			}
+			*target = d
+		}
+	}
+	if routes := os.Getenv("ROUTE_TIMEOUTS"); routes != "" {
+		timeoutConfig.Routes = make(map[string]time.Duration)
+		for _, entry := range strings.Split(routes, ",") {
+			path, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			d, err := time.ParseDuration(value)
+			if !ok || err != nil || !strings.HasPrefix(path, "/") {
+				log.Fatal().Str("entry", entry).Msg("Invalid ROUTE_TIMEOUTS, want /path=duration pairs")
+			}
+			timeoutConfig.Routes[path] = d
+		}
+	}
+	// The limiter sees paths before the /v1 prefix is stripped
+	for _, path := range timeoutConfig.Streams {
+		if !strings.HasPrefix(path, apiversion.Prefix+"/") {
+			timeoutConfig.Streams = append(timeoutConfig.Streams, apiversion.Prefix+path)
+		}
+	}
+	for path, d := range maps.Clone(timeoutConfig.Routes) {
+		if !strings.HasPrefix(path, apiversion.Prefix+"/") {
+			timeoutConfig.Routes[apiversion.Prefix+path] = d
+		}
+	}
+	routeTimeouts := timeouts.New(timeoutConfig, timeouts.Metrics{Timeouts: requestTimeouts})
+
+	// Profile continuously to a Pyroscope-compatible server, labelled by
+	// endpoint and model like the profiles ADMIN_PORT serves
+	adminAddr := os.Getenv("ADMIN_PORT")
+	profilingConfig := profiling.DefaultConfig()
+	profilingConfig.ServerURL = os.Getenv("PROFILING_SERVER_URL")
+	profilingConfig.AppName = getEnvOrDefault("PROFILING_APP_NAME", profilingConfig.AppName)
+	profilingConfig.AuthToken = getSecret("PROFILING_AUTH_TOKEN", "")
+	if profilingConfig.Interval, err = time.ParseDuration(getEnvOrDefault("PROFILING_INTERVAL", "15s")); err != nil || profilingConfig.Interval <= 0 {
+		log.Fatal().Err(err).Msg("Invalid PROFILING_INTERVAL")
+	}
+	if profilingConfig.Tags, err = profiling.ParseTags(os.Getenv("PROFILING_TAGS")); err != nil {
+		log.Fatal().Err(err).Msg("Invalid PROFILING_TAGS")
+	}
+	for name, value := range kubePod.Labels() {
+		profilingConfig.Tags[name] = value
+	}
+	if profilingConfig.ServerURL != "" {
+		profiling.NewUploader(profilingConfig, profiling.Metrics{Uploads: profileUploads}).Start(probeCtx)
+		log.Info().Str("url", profilingConfig.ServerURL).Dur("interval", profilingConfig.Interval).Msg("Uploading continuous profiles")
+	}
+	profilingLabels = adminAddr != "" || profilingConfig.ServerURL != ""
+
+	// Label request metrics by route template rather than raw path, so
+	// scanners requesting random URLs cannot create a series for each
+	maxEndpoints, err := strconv.Atoi(getEnvOrDefault("METRICS_MAX_ENDPOINTS", "200"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid METRICS_MAX_ENDPOINTS")
+	}
+	routeLabels := middleware.NewRouteLabeler(func(r *http.Request) string {
+		return apiversion.Pattern(mux, r)
+	}, maxEndpoints)
+
+	handlersChain := func(h http.Handler) http.Handler {
+		if compressionEnabled {
+			h = compress(h)
+		}
+		h = apierror.RequestID(h)
+		h = sessions.Middleware(h)
+		if ssoAuth != nil {
+			h = ssoAuth.Middleware(h)
+		}
+		h = tenantRegistry.Middleware(h)
+		h = inflight.Middleware(h)
+		h = middleware.MetricsMiddleware(requestCounter, requestDuration, activeRequests, routeLabels)(h)
+		if profilingLabels {
+			h = profiling.Middleware(routeLabels.Label)(h)
+		}
+		if tracingEnabled {
+			h = middleware.TracingMiddleware(h)
+		}
+		h = middleware.CORS(corsConfig)(h)
+		h = routeTimeouts.Middleware(h)
+		return h
+	}
+
+	// Polled read endpoints carry ETags so unchanged responses cost a 304.
+	// The summary changes with every request it counts, so it is rebuilt at
+	// most once per METRICS_SUMMARY_CACHE_TTL.
+	conditionalMetrics := middleware.ConditionalMetrics{NotModified: notModifiedResponses}
+	conditional := middleware.Conditional(0, conditionalMetrics)
+	summaryTTL, err := time.ParseDuration(getEnvOrDefault("METRICS_SUMMARY_CACHE_TTL", "1s"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid METRICS_SUMMARY_CACHE_TTL")
+	}
+	summaryConditional := middleware.Conditional(summaryTTL, conditionalMetrics)
+
+	// Add models listing endpoint
+	mux.Handle("/models", conditional(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, apierror.MethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		models.HandleListModels(w, r)
+	})))
+
+	// Add Docker debug endpoint
+	mux.HandleFunc("/debug/docker", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, apierror.MethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		models.HandleDebugDocker(w, r)
+	})
+
+	// Add health check endpoint
+	mux.HandleFunc("GET /health/live", health.HandleLiveness())
+	mux.HandleFunc("GET /health/ready", readiness.HandleReadiness())
+	mux.HandleFunc("GET /health/startup", startup.Handler())
+	mux.Handle("/health", conditional(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stay 200 so existing checks keep passing; the status says whether
+		// the backends can actually serve
+		ready, backends := readiness.Ready(r.Context())
+		status := "ok"
+		if !ready {
+			status = "degraded"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		
+		// Add model information to the health response
+		serving := backend.Default.Lookup(baseURL)
+		modelInfo := map[string]interface{}{
+			"model":   defaultModel,
+			"backend": serving,
+		}
+		
+		// Add context window size if available
+		if serving.LlamaCppMetrics {
+			modelInfo["modelType"] = "llama.cpp"
+			modelInfo["contextWindow"] = getContextWindow(defaultModel)
+		}
+		
+		modelInfo["loaded"] = models.Lifecycle.IsLoaded(defaultModel)
+
+		response := map[string]interface{}{
+			"status": status,
+			"model_info": modelInfo,
+			"backends": backends,
+			"loaded_models": models.Lifecycle.Loaded(),
+		}
+		if kubePod.Enabled {
+			response["pod"] = kubePod
+		}
+		
+		json.NewEncoder(w).Encode(response)
+	})))
+
+	// Add metrics endpoint using custom registry
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, metricsHandlerOpts))
+	
+	// Add metrics summary endpoint for frontend
+	mux.Handle("/metrics/summary", summaryConditional(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(buildMetricsSummary(defaultModel, baseURL))
+	})))
+
+	// Add live metrics stream so dashboards can update without polling
+	mux.HandleFunc("GET /metrics/stream", handleMetricsStream(defaultModel, baseURL))
+	
+	// Add metrics logging endpoint
+	mux.HandleFunc("/metrics/log", func(w http.ResponseWriter, r *http.Request) {
+		// Parse metrics from the request
+		var metricLog MetricLog
+		if err := json.NewDecoder(r.Body).Decode(&metricLog); err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, "Invalid request body")
+			return
+		}
+
+		// Attribute the measurement to the model that produced the message
+		model := defaultModel
+		event := log.Info().Str("message_id", metricLog.MessageID).Str("conversation_id", metricLog.ConversationID)
+		if message, ok := findMessage(metricLog.ConversationID, metricLog.MessageID); ok {
+			model = message.Model
+			event = event.Bool("correlated", true)
+		}
+		event.Str("model", model).Int("tokens_in", metricLog.TokensIn).Int("tokens_out", metricLog.TokensOut).
+			Float64("response_time_ms", metricLog.ResponseTimeMs).Float64("time_to_first_token_ms", metricLog.FirstTokenMs).
+			Msg("Client reported metrics")
+
+		// Log the metrics using Prometheus (don't increment counters as they are already tracked)
+		// Just log the first token latency which isn't already tracked
+		if metricLog.FirstTokenMs > 0 {
+			firstTokenLatency.WithLabelValues(model, temperatureBucket(nil)).Observe(metricLog.FirstTokenMs / 1000.0)
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+	
+	// Add llama.cpp metrics logging endpoint
+	mux.HandleFunc("/metrics/llamacpp", func(w http.ResponseWriter, r *http.Request) {
+		// Parse metrics from the request
+		var llamaCppLog LlamaCppMetrics
+		if err := json.NewDecoder(r.Body).Decode(&llamaCppLog); err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, "Invalid request body")
+			return
+		}
+
+		// Record all llama.cpp metrics
+		llamacppContextSize.WithLabelValues(defaultModel).Set(float64(llamaCppLog.ContextSize))
+		llamacppPromptEvalTime.WithLabelValues(defaultModel).Observe(llamaCppLog.PromptEvalTime / 1000.0) // Convert ms to seconds
+		llamacppTokensPerSecond.WithLabelValues(defaultModel).Set(llamaCppLog.TokensPerSecond)
+		llamacppMemoryPerToken.WithLabelValues(defaultModel).Set(llamaCppLog.MemoryPerToken)
+		llamacppThreadsUsed.WithLabelValues(defaultModel).Set(float64(llamaCppLog.ThreadsUsed))
+		llamacppBatchSize.WithLabelValues(defaultModel).Set(float64(llamaCppLog.BatchSize))
+
+		w.WriteHeader(http.StatusOK)
+	})
+	
+	// Add error logging endpoint
+	mux.HandleFunc("/metrics/error", func(w http.ResponseWriter, r *http.Request) {
+		// Parse error from the request
+		var errorLog ErrorLog
+		if err := json.NewDecoder(r.Body).Decode(&errorLog); err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, "Invalid request body")
+			return
+		}
+
+		// Log the error using Prometheus
+		if errorLog.Model == "" {
+			errorLog.Model = defaultModel
+		}
+		errorCounter.WithLabelValues(errorLog.ErrorType, errorLog.Model).Inc()
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Add admin API, guarded by its own token
+	adminRouter := admin.NewRouter(getSecret("ADMIN_TOKEN", ""), flags.Default, inflight, runtimeConfig)
+	secretStore.Watch("ADMIN_TOKEN", adminRouter.SetToken)
+	adminRouter.RegisterCache("model_status", models.Tracker.Reset)
+	adminRouter.RegisterCache("model_capabilities", models.Discovery.Reset)
+	adminRouter.RegisterCache("model_list", models.List.Reset)
+	adminRouter.RegisterCache("backend_info", backend.Default.Reset)
+	adminRouter.Audit = auditLog
+	adminRouter.Handle("GET /admin/audit", auditLog.Handler().ServeHTTP)
+	mux.Handle("/admin/", adminRouter)
+
+	// Add single sign-on. Signed-in admins can use the admin API, and the
+	// admin token still works as a break-glass credential. API clients
+	// presenting a key from the quota file or a tenant are let through.
+	if ssoAuth != nil {
+		adminRouter.Authorize = oidc.IsAdmin
+		ssoAuth.Skip = func(r *http.Request) bool {
+			return adminRouter.HasToken(r) || usageQuotas.KnownKey(quota.APIKey(r))
+		}
+		mux.Handle("/auth/", ssoAuth.Handler())
+	}
+
+	// Start and stop model serving without shell access to the host
+	mux.Handle("/models/", adminRouter.Protect(auditLog.Middleware(audit.Spec{
+		Action: "model.lifecycle",
+		Snapshot: func(r *http.Request) interface{} {
+			return map[string]interface{}{"loaded": models.Lifecycle.Loaded(), "current": models.Lifecycle.Current()}
+		},
+	}, models.Lifecycle.Handler())))
+
+	// Add usage endpoint reporting the caller's quota consumption
+	mux.HandleFunc("/usage", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, apierror.MethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		usageQuotas.HandleUsage(w, r)
+	})
+
+	// Add token usage reports for finance, across every key
+	mux.Handle("/billing/", adminRouter.Protect(billingLedger.Handler()))
+
+	// Add tenant administration
+	tenantsHandler := adminRouter.Protect(auditLog.Middleware(audit.Spec{
+		Action: "tenant.changed",
+		Snapshot: func(r *http.Request) interface{} {
+			if t, err := tenantRegistry.Get(strings.TrimPrefix(r.URL.Path, "/tenants/")); err == nil {
+				return t.Masked()
+			}
+			return nil
+		},
+	}, tenantRegistry.Handler()))
+	mux.Handle("/tenants", tenantsHandler)
+	mux.Handle("/tenants/", tenantsHandler)
+
+	// Add erasure of a user's stored data, and the audit trail recording it
+	mux.Handle("/users/", adminRouter.Protect(retention.DeletionHandler(eraseUserData, auditLog, retention.Metrics{Deletions: userDataDeletions})))
+	mux.Handle("/audit", adminRouter.Protect(auditLog.Handler()))
+
+	// Add search and restore of archived transcripts
+	if transcriptArchiver != nil {
+		mux.Handle("/archive/", adminRouter.Protect(auditLog.Middleware(audit.Spec{Action: "transcript.accessed", Reads: true}, transcriptArchiver.Handler(restoreTranscript))))
+	}
+
+	// Add export of rated conversations as fine-tuning data, and of the
+	// request log for offline analysis
+	exportMux := http.NewServeMux()
+	exportMux.Handle("/export/finetune", auditLog.Middleware(audit.Spec{Action: "conversation.exported", Reads: true}, finetune.Handler(conversations, finetune.Metrics{
+		Examples: finetuneExamples,
+	})))
+	exportMux.Handle("/export/requests", auditLog.Middleware(audit.Spec{Action: "requests.exported", Reads: true}, requestLog.Handler()))
+	mux.Handle("/export/", adminRouter.Protect(exportMux))
+
+	// Add on-demand usage reports
+	mux.Handle("/reports/", adminRouter.Protect(usageReports.Handler()))
+
+	// Add prompt template endpoints. Templates become system prompts, so
+	// changing them takes the admin token.
+	promptsHandler := adminRouter.ProtectWrites(auditLog.Middleware(audit.Spec{
+		Action: "prompt.changed",
+		Snapshot: func(r *http.Request) interface{} {
+			if t, err := promptTemplates.Get(strings.TrimPrefix(r.URL.Path, "/prompts/")); err == nil {
+				return t
+			}
+			return nil
+		},
+	}, promptTemplates.Handler()))
+	mux.Handle("/prompts", promptsHandler)
+	mux.Handle("/prompts/", promptsHandler)
+
+	// Add conversation history endpoints. Callers see the conversations they
+	// started; admins see all of their tenant's.
+	// Reading or deleting one conversation is audited; listings are not
+	conversationCaller := history.Middleware(func(r *http.Request) history.Caller {
+		return history.Caller{Owner: conversationOwner(r), Session: session.FromContext(r.Context()), Admin: adminRouter.IsAdmin(r)}
+	})
+	conversationsHandler := conversationCaller(conversations.Handler())
+	mux.Handle("/conversations", conversationsHandler)
+	mux.Handle("/conversations/", auditLog.Middleware(audit.Spec{Action: "conversation.accessed", Reads: true}, conversationsHandler))
+
+	// Add experiment endpoints
+	experimentsHandler := chatExperiments.Handler()
+	mux.Handle("/experiments", experimentsHandler)
+	mux.Handle("/experiments/", experimentsHandler)
+
+	// Add traffic replay endpoints
+	replayer := replay.New(client, conversations, replay.Metrics{
+		Requests:   replayRequests,
+		Latency:    replayLatency,
+		FirstToken: replayFirstToken,
+		Tokens:     replayTokens,
+	})
+	replayHandler := adminRouter.Protect(replayer.Handler())
+	mux.Handle("/replay", replayHandler)
+	mux.Handle("/replay/", replayHandler)
+
+	// Add drift detection endpoints. Resetting the baseline silences drift
+	// alerts, so it takes the admin token.
+	driftHandler := adminRouter.ProtectWrites(auditLog.Middleware(audit.Spec{Action: "drift.baseline_reset"}, driftDetector.Handler()))
+	mux.Handle("/drift", driftHandler)
+	mux.Handle("/drift/", driftHandler)
+
+	// Add the GraphQL endpoint, so a dashboard screen can fetch the
+	// conversations, model metrics, experiments and alerts it shows at once
+	dashboardSources := graphql.Sources{
+		Conversations: conversations,
+		Experiments:   chatExperiments,
+		Models:        models.Tracker,
+		Memory:        memoryMonitor.Latest,
+		Drift:         driftDetector.Status,
+		Requests:      requestLog.Records,
+	}
+	mux.Handle("/graphql", conversationCaller(graphql.New(graphql.Dashboard(dashboardSources), graphql.Metrics{
+		Requests: graphqlRequests,
+		Duration: graphqlDuration,
+	}).Handler()))
+
+	// Add backend routing status endpoint
+	backendsHandler := backendRouter.Handler()
+	mux.Handle("/backends", backendsHandler)
+
+	// Add the embedded dashboard for single-container deployments
+	dashboardHandler := dashboard.Handler("/dashboard/")
+	mux.Handle("/dashboard", dashboardHandler)
+	mux.Handle("/dashboard/", dashboardHandler)
+
+	// Add the RAG document library endpoints. Documents feed every chat's
+	// retrieved context, so adding or deleting them takes the admin token;
+	// listing and searching are open, scoped to the caller's tenant.
+	if ragIndex != nil {
+		documentsHandler := ragIndex.Handler()
+		mux.Handle("/documents", adminRouter.ProtectWrites(documentsHandler))
+		mux.Handle("/documents/", adminRouter.ProtectWrites(documentsHandler))
+		mux.Handle("POST /documents/search", documentsHandler)
+	}
+
+	// Add feedback endpoint for rating responses
+	mux.HandleFunc("/feedback", handleFeedback)
+
+	// Add chat endpoint with advanced tracing
+	// Requests for JSON output are validated, and retried when invalid
+	jsonEnforcer := &structured.Enforcer{MaxRetries: 1, DefaultModel: defaultModel, Failures: jsonValidationFailures}
+	if retries, err := strconv.Atoi(getEnvOrDefault("STRUCTURED_OUTPUT_RETRIES", "")); err == nil && retries >= 0 {
+		jsonEnforcer.MaxRetries = retries
+	}
+	// Interactive chats go ahead of queued batch work when the backend is busy
+	priorityConfig, err := priority.LoadConfig(os.Getenv("PRIORITY_CONFIG"))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load priority config, using defaults")
+		priorityConfig = priority.DefaultConfig()
+	}
+	if limit, err := strconv.Atoi(getEnvOrDefault("PRIORITY_MAX_CONCURRENCY", "")); err == nil {
+		priorityConfig.MaxConcurrency = limit
+	}
+	scheduler := priority.New(priorityConfig, priority.Metrics{
+		Wait:  priorityQueueWait,
+		Depth: priorityQueueDepth,
+	})
+	// Oversized and malformed chats are rejected before anything decodes them
+	validationConfig := validation.DefaultConfig()
+	if n, err := strconv.ParseInt(getEnvOrDefault("REQUEST_MAX_BODY_BYTES", ""), 10, 64); err == nil {
+		validationConfig.MaxBodyBytes = n
+	}
+	if n, err := strconv.Atoi(getEnvOrDefault("REQUEST_MAX_MESSAGES", "")); err == nil {
+		validationConfig.MaxMessages = n
+	}
+	if n, err := strconv.Atoi(getEnvOrDefault("REQUEST_MAX_MESSAGE_BYTES", "")); err == nil {
+		validationConfig.MaxMessageBytes = n
+	}
+	if roles := os.Getenv("REQUEST_ALLOWED_ROLES"); roles != "" {
+		validationConfig.Roles = nil
+		for _, role := range strings.Split(roles, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				validationConfig.Roles = append(validationConfig.Roles, role)
+			}
+		}
+	}
+	requestValidator := validation.New(validationConfig, validation.Metrics{Rejected: rejectedRequests})
+
+	chatHandler := requestValidator.Middleware(chatDrain.Middleware(usageQuotas.Middleware(jsonEnforcer.Middleware(scheduler.Middleware(handleChat(client, defaultModel, baseURL))))))
+	mux.Handle("/chat", chatHandler)
+
+	// Add async generation jobs, run through the same chat handler so they
+	// are traced, limited and recorded like interactive chats
+	jobsConfig := jobs.DefaultConfig()
+	if workers, err := strconv.Atoi(getEnvOrDefault("JOBS_WORKERS", "")); err == nil {
+		jobsConfig.Workers = workers
+	}
+	if size, err := strconv.Atoi(getEnvOrDefault("JOBS_QUEUE_SIZE", "")); err == nil {
+		jobsConfig.QueueSize = size
+	}
+	if retention, err := time.ParseDuration(getEnvOrDefault("JOBS_RETENTION", "")); err == nil {
+		jobsConfig.Retention = retention
+	}
+	if timeout, err := time.ParseDuration(getEnvOrDefault("JOBS_TIMEOUT", "")); err == nil {
+		jobsConfig.Timeout = timeout
+	}
+	jobQueue := jobs.New(jobsConfig, jobs.HandlerRunner(priority.Assign(priority.Batch, chatHandler), "/jobs"), jobs.Metrics{
+		Depth:     jobsQueued,
+		Jobs:      jobsCounter,
+		Wait:      jobWait,
+		Duration:  jobDuration,
+		Callbacks: jobCallbacks,
+	})
+	jobQueue.Validate = validateChatJob
+	jobsHandler := requestValidator.Middleware(jobQueue.Handler())
+	mux.Handle("/jobs", jobsHandler)
+	mux.Handle("/jobs/", jobsHandler)
+
+	// Add batch chat endpoint for evaluation runs, streaming NDJSON results
+	batchConfig := batch.DefaultConfig()
+	if items, err := strconv.Atoi(getEnvOrDefault("BATCH_MAX_ITEMS", "")); err == nil {
+		batchConfig.MaxItems = items
+	}
+	if concurrency, err := strconv.Atoi(getEnvOrDefault("BATCH_MAX_CONCURRENCY", "")); err == nil {
+		batchConfig.MaxConcurrency = concurrency
+	}
+	mux.Handle("/chat/batch", batch.New(batchConfig, jobs.HandlerRunner(priority.Assign(priority.Batch, chatHandler), "/chat/batch"), batch.Metrics{
+		Items:   batchItems,
+		Latency: batchItemLatency,
+		Size:    batchSize,
+	}))
+
+	// Add OpenAI-compatible completions endpoint so existing SDKs can use
+	// aiwatch as a drop-in observability proxy
+	mux.Handle("/v1/chat/completions", requestValidator.Middleware(chatDrain.Middleware(usageQuotas.Middleware(scheduler.Middleware(routing.Sticky("X-Conversation-ID", promptCompressor.Middleware(&proxy.ChatCompletions{
+		BaseURL:  baseURL,
+		Key:      currentAPIKey,
+		Client:   upstreamClient,
+		Observe:  observeProxyExchange,
+		Received: announceProxyRequest,
+		Allow:    allowModel,
+		EnforceStops: func() bool {
+			return flags.Default.Enabled("stop_enforcement")
+		},
+	})))))))
+
+	// Add OpenAI-compatible embeddings endpoint so RAG pipelines are observed too
+	mux.Handle("/v1/embeddings", usageQuotas.Middleware(&proxy.Embeddings{
+		BaseURL: baseURL,
+		Key:     currentAPIKey,
+		Client:  upstreamClient,
+		Observe: observeEmbeddingExchange,
+		Allow:   allowModel,
+	}))
+
+	// Add Whisper-compatible transcription endpoint, which can point at a
+	// separate speech-to-text server
+	mux.Handle("/v1/audio/transcriptions", usageQuotas.Middleware(&proxy.Transcriptions{
+		BaseURL: getEnvOrDefault("WHISPER_URL", baseURL),
+		Key:     func() string { return getSecret("WHISPER_API_KEY", currentAPIKey()) },
+		Client:  upstreamClient,
+		Observe: observeTranscriptionExchange,
+		Allow:   allowModel,
+	}))
+
+	// Serve every route under /v1 as well, marking the unversioned paths
+	// deprecated. Probes, scrapes, sign-in callbacks and the dashboard stay
+	// unversioned.
+	versionConfig := apiversion.Config{
+		Exempt: []string{"/health", "/health/", "/metrics", "/auth/", "/dashboard", "/dashboard/"},
+	}
+	if sunset := os.Getenv("API_SUNSET"); sunset != "" {
+		at, err := time.Parse(time.RFC3339, sunset)
+		if err != nil {
+			at, err = time.Parse(time.DateOnly, sunset)
+		}
+		if err != nil {
+			log.Fatal().Str("value", sunset).Msg("Invalid API_SUNSET, want an RFC 3339 time or YYYY-MM-DD date")
+		}
+		versionConfig.Sunset = at
+	}
+	versionedMux := apiversion.New(mux, versionConfig, apiversion.Metrics{Requests: apiRequests})
+
+	// Create HTTP server. PORT and METRICS_PORT accept a port, a host:port or
+	// unix:/path/to.sock; without METRICS_PORT, /metrics is only served on the
+	// main server.
+	apiAddr := getEnvOrDefault("PORT", "8080")
+	server := &http.Server{
+		Addr:        apiAddr,
+		Handler:     handlersChain(versionedMux),
+		ReadTimeout: 30 * time.Second,
+		// No WriteTimeout: routeTimeouts sets a deadline per request
+	}
+
+	// Start metrics server on a separate port with custom registry
+	var metricsServer *http.Server
+	if metricsAddr := os.Getenv("METRICS_PORT"); metricsAddr != "" {
+		metricsServer = &http.Server{
+			Addr:    metricsAddr,
+			Handler: promhttp.HandlerFor(registry, metricsHandlerOpts),
+		}
+	}
+
+	// Serve pprof on a separate admin port, for go tool pprof and Parca.
+	// Profiles reveal internals and the server has no authentication, so a
+	// bare port listens on loopback only.
+	var adminServer *http.Server
+	if adminAddr != "" {
+		adminServer = &http.Server{
+			Addr:    loopbackAddress(adminAddr),
+			Handler: profiling.Handler(),
+		}
+	}
+
+	// Serve HTTPS (and with it HTTP/2) when certificates are configured. The
+	// metrics server reuses the API certificate unless given its own, and can
+	// require client certificates.
+	apiTLS := tlsconfig.Config{
+		CertFile: os.Getenv("TLS_CERT_FILE"),
+		KeyFile:  os.Getenv("TLS_KEY_FILE"),
+	}
+	metricsTLS := tlsconfig.Config{
+		CertFile:     getEnvOrDefault("METRICS_TLS_CERT_FILE", apiTLS.CertFile),
+		KeyFile:      getEnvOrDefault("METRICS_TLS_KEY_FILE", apiTLS.KeyFile),
+		ClientCAFile: os.Getenv("METRICS_CLIENT_CA_FILE"),
+	}
+	if apiTLS.Enabled() {
+		if server.TLSConfig, err = tlsconfig.Load(apiTLS); err != nil {
+			log.Fatal().Err(err).Msg("Invalid TLS configuration")
+		}
+	}
+	if metricsServer == nil && metricsTLS.ClientCAFile != "" {
+		log.Warn().Msg("METRICS_CLIENT_CA_FILE requires METRICS_PORT, metrics are served on the main server without mTLS")
+	} else if metricsServer != nil && (metricsTLS.Enabled() || metricsTLS.ClientCAFile != "") {
+		if metricsServer.TLSConfig, err = tlsconfig.Load(metricsTLS); err != nil {
+			log.Fatal().Err(err).Msg("Invalid metrics TLS configuration")
+		}
+	}
+
+	if metricsServer != nil {
+		go func() {
+			log.Info().Str("addr", metricsServer.Addr).Bool("tls", metricsServer.TLSConfig != nil).Bool("mtls", metricsTLS.ClientCAFile != "").Msg("Starting metrics server")
+			if err := listenAndServe(metricsServer); err != nil && err != http.ErrServerClosed {
+				log.Fatal().Err(err).Msg("Failed to start metrics server")
+			}
+		}()
+	}
+
+	if adminServer != nil {
+		go func() {
+			log.Info().Str("addr", adminServer.Addr).Msg("Starting admin server")
+			if err := listenAndServe(adminServer); err != nil && err != http.ErrServerClosed {
+				log.Fatal().Err(err).Msg("Failed to start admin server")
+			}
+		}()
+	}
+
+	// Start the main server
+	go func() {
+		log.Info().Str("addr", apiAddr).Bool("tls", server.TLSConfig != nil).Bool("metrics", metricsServer == nil).Msg("Starting server")
+		if err := listenAndServe(server); err != nil && err != http.ErrServerClosed {
+			log.Fatal().Err(err).Msg("Failed to start server")
+		}
+	}()
+
+	startup.Done()
+
+	// Set up graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Info().Msg("Shutting down server...")
+
+	// Report not ready so the Service stops routing new requests here
+	readiness.Drain()
+
+	// Stop admitting chats and let in-flight generations finish first
+	drainWindow, err := time.ParseDuration(getEnvOrDefault("DRAIN_TIMEOUT", "30s"))
+	if err != nil {
+		drainWindow = 30 * time.Second
+	}
+	drained, cut := chatDrain.Drain(drainWindow)
+	log.Info().Int("drained", drained).Int("cut", cut).Dur("window", drainWindow).Msg("In-flight chats drained")
+
+	// Fail the generation jobs that never got a worker
+	jobQueue.Close()
 
-	// Apply middleware
-	handlersChain := func(h http.Handler) http.Handler {
-		h = middleware.MetricsMiddleware(requestCounter, requestDuration, activeRequests)(h)
-		if tracingEnabled {
-			h = middleware.TracingMiddleware(h)
+	// Shutdown the server with a timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Shutdown servers
+	if err := server.Shutdown(ctx); err != nil {
+		log.Fatal().Err(err).Msg("Server forced to shutdown")
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			log.Fatal().Err(err).Msg("Metrics server forced to shutdown")
 		}
-		return h
 	}
-
-	// Add CORS handler
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			log.Fatal().Err(err).Msg("Admin server forced to shutdown")
 		}
-	})
+	}
 
-	// Add models listing endpoint
-	mux.HandleFunc("/models", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	log.Info().Msg("Server exiting")
+}
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
+// listenAndServe listens on the server's address and serves over TLS if the
+// server has a TLS config, else plain HTTP
+func listenAndServe(server *http.Server) error {
+	network, address := listenAddress(server.Addr)
+	if network == "unix" {
+		// Clear a socket left behind by a previous run
+		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+			return err
 		}
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
 
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+	if server.TLSConfig != nil {
+		return server.ServeTLS(listener, "", "")
+	}
+	return server.Serve(listener)
+}
+
+// defaultCORSOrigin is the origin the bundled frontend is served from, the
+// only one allowed unless CORS_ALLOWED_ORIGINS is set
+const defaultCORSOrigin = "http://localhost:3000"
+
+// listenAddress turns a PORT-style setting into a network and address: a bare
+// port listens on all interfaces, host:port is used as is, and unix:/path is a
+// unix socket
+func listenAddress(value string) (network, address string) {
+	if path, ok := strings.CutPrefix(value, "unix:"); ok {
+		return "unix", path
+	}
+	if _, err := strconv.Atoi(value); err == nil {
+		return "tcp", ":" + value
+	}
+	return "tcp", value
+}
+
+// loopbackAddress is listenAddress's value for a server that should not be
+// reachable from other hosts unless asked: a bare port listens on 127.0.0.1
+func loopbackAddress(value string) string {
+	if _, err := strconv.Atoi(value); err == nil {
+		return "127.0.0.1:" + value
+	}
+	return value
+}
+
+// runBench implements the `aiwatch bench` subcommand: it fires a JSONL file
+// of prompts at one or more models and writes a latency/throughput report
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	promptsPath := fs.String("prompts", "", "JSONL file of prompts, one {\"prompt\": \"...\"} per line (required)")
+	modelList := fs.String("models", os.Getenv("MODEL"), "comma-separated models to benchmark")
+	concurrency := fs.Int("concurrency", 4, "number of requests in flight")
+	repeat := fs.Int("repeat", 1, "times each prompt is sent to each model")
+	timeout := fs.Duration("timeout", 5*time.Minute, "per-request timeout")
+	format := fs.String("format", "json", "report format: json or csv")
+	outPath := fs.String("out", "", "write the report to this file instead of stdout")
+	pushgateway := fs.String("pushgateway", os.Getenv("PUSHGATEWAY_URL"), "Prometheus Pushgateway URL to push results to")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *promptsPath == "" || *modelList == "" || (*format != "json" && *format != "csv") {
+		fs.Usage()
+		return 2
+	}
+
+	file, err := os.Open(*promptsPath)
+	if err != nil {
+		log.Printf("bench: %v", err)
+		return 1
+	}
+	prompts, err := bench.LoadPrompts(file)
+	file.Close()
+	if err != nil {
+		log.Printf("bench: failed to read prompts: %v", err)
+		return 1
+	}
+
+	var models []string
+	for _, model := range strings.Split(*modelList, ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			models = append(models, model)
 		}
+	}
 
-		models.HandleListModels(w, r)
-	})
+	client := openai.NewClient(
+		option.WithBaseURL(os.Getenv("BASE_URL")),
+		option.WithAPIKey(getSecret("API_KEY", "ollama")),
+		option.WithMaxRetries(0),
+	)
 
-	// Add Docker debug endpoint
-	mux.HandleFunc("/debug/docker", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
+	log.Printf("bench: %d prompts x %d models x %d repeats at concurrency %d", len(prompts), len(models), *repeat, *concurrency)
+	report := bench.Run(ctx, client, prompts, bench.Config{
+		Models:      models,
+		Concurrency: *concurrency,
+		Repeat:      *repeat,
+		Timeout:     *timeout,
+	}, bench.Metrics{
+		FirstToken:      benchFirstToken,
+		Latency:         benchLatency,
+		TokensPerSecond: benchTokensPerSecond,
+		Errors:          benchErrors,
+	})
+
+	out := os.Stdout
+	if *outPath != "" {
+		out, err = os.Create(*outPath)
+		if err != nil {
+			log.Printf("bench: %v", err)
+			return 1
 		}
+		defer out.Close()
+	}
+	if *format == "csv" {
+		err = report.WriteCSV(out)
+	} else {
+		err = report.WriteJSON(out)
+	}
+	if err != nil {
+		log.Printf("bench: failed to write report: %v", err)
+		return 1
+	}
 
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+	if *pushgateway != "" {
+		err := push.New(*pushgateway, "aiwatch_bench").
+			Collector(benchFirstToken).
+			Collector(benchLatency).
+			Collector(benchTokensPerSecond).
+			Collector(benchErrors).
+			Push()
+		if err != nil {
+			log.Printf("bench: failed to push results: %v", err)
+			return 1
 		}
+	}
+	return 0
+}
 
-		models.HandleDebugDocker(w, r)
-	})
+// getEnvOrDefault gets an environment variable or returns a default value
+func getEnvOrDefault(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
 
-	// Add health check endpoint
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.WriteHeader(http.StatusOK)
-		
-		// Check if the model is a llama.cpp model
-		isLlamaCpp := strings.Contains(strings.ToLower(defaultModel), "llama") || 
-			            strings.Contains(baseURL, "llama.cpp")
-		
-		// Add model information to the health response
-		modelInfo := map[string]interface{}{
-			"model": defaultModel,
+// applyConfigChange applies a ConfigMap setting that can change at runtime
+func applyConfigChange(key, value string) {
+	log := logger.GetLogger()
+	if key == "LOG_LEVEL" {
+		if err := logger.SetLevel(getEnvOrDefault("LOG_LEVEL", "info")); err != nil {
+			log.Error().Err(err).Msg("Invalid LOG_LEVEL in ConfigMap")
 		}
-		
-		// Add context window size if available
-		if isLlamaCpp {
-			modelInfo["modelType"] = "llama.cpp"
-			contextSize := int(getGaugeValueWithLabels(llamacppContextSize, defaultModel))
-			if contextSize > 0 {
-				modelInfo["contextWindow"] = contextSize
-			} else {
-				// Default context window for the model if not set yet
-				if strings.Contains(defaultModel, "1B") {
-					modelInfo["contextWindow"] = 2048
-				} else if strings.Contains(defaultModel, "7B") {
-					modelInfo["contextWindow"] = 4096
-				} else if strings.Contains(defaultModel, "13B") {
-					modelInfo["contextWindow"] = 4096
-				} else if strings.Contains(defaultModel, "70B") {
-					modelInfo["contextWindow"] = 8192 
-				} else {
-					modelInfo["contextWindow"] = 4096 // Default
-				}
-			}
+		return
+	}
+	for _, name := range flags.Default.Names() {
+		if flags.EnvKey(name) != key {
+			continue
 		}
-		
-		response := map[string]interface{}{
-			"status": "ok",
-			"model_info": modelInfo,
+		if enabled, err := strconv.ParseBool(value); err == nil {
+			flags.Default.Set(name, enabled)
+			log.Info().Str("flag", name).Bool("enabled", enabled).Msg("Feature flag changed by ConfigMap")
 		}
-		
-		json.NewEncoder(w).Encode(response)
-	})
+		return
+	}
+	log.Warn().Str("key", key).Msg("ConfigMap setting changed, restart the pod to apply it")
+}
 
-	// Add metrics endpoint using custom registry
-	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
-	
-	// Add metrics summary endpoint for frontend
-	mux.HandleFunc("/metrics/summary", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		w.Header().Set("Content-Type", "application/json")
+// getSecret returns a secret from the secret store, or defaultValue if no
+// file, provider or env var holds it
+func getSecret(key, defaultValue string) string {
+	return secretStore.Get(context.Background(), key, defaultValue)
+}
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// currentAPIKey returns the upstream API key, following rotations
+func currentAPIKey() string {
+	return secretStore.Value("API_KEY")
+}
 
-		// Get llama.cpp metrics if the model is a llama.cpp model
-		var llamaCppMetrics *LlamaCppMetrics
-		if strings.Contains(strings.ToLower(defaultModel), "llama") || 
-		   strings.Contains(baseURL, "llama.cpp") {
-			llamaCppMetrics = getLlamaCppMetrics(defaultModel)
-		}
+// rotatedAPIKey sends the current API key on OpenAI client requests, so a
+// rotated key replaces the one the client was created with
+func rotatedAPIKey(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	if key := currentAPIKey(); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	return next(req)
+}
+
+// configKeys lists the environment variables reported by the admin config view
+var configKeys = []string{
+	"BASE_URL", "MODEL", "API_KEY", "ADMIN_TOKEN",
+	"MODEL_RUNNER_URL", "MODEL_CONTAINER", "MODEL_CONTAINER_STATS_INTERVAL", "DOCKER_HOST",
+	"K8S_MODE", "K8S_CONFIG_DIR", "K8S_CONFIG_RELOAD_INTERVAL", "POD_NAME", "POD_NAMESPACE", "NODE_NAME",
+	"SECRETS_PROVIDERS", "SECRETS_DIR", "SECRETS_REFRESH_INTERVAL", "VAULT_ADDR", "VAULT_SECRET_PATH", "VAULT_TOKEN_FILE", "VAULT_NAMESPACE", "AWS_SECRET_ID",
+	"OIDC_ISSUER", "OIDC_CLIENT_ID", "OIDC_CLIENT_SECRET", "OIDC_REDIRECT_URL", "OIDC_SCOPES", "OIDC_AUDIENCE",
+	"OIDC_GROUPS_CLAIM", "OIDC_ROLE_MAP", "OIDC_DEFAULT_ROLE", "OIDC_COOKIE_SECRET", "OIDC_SESSION_TTL", "OIDC_PUBLIC_PATHS",
+	"LOG_LEVEL", "LOG_PRETTY", "LOG_FILE", "LOG_MAX_SIZE", "LOG_ROTATE_INTERVAL", "LOG_MAX_BACKUPS", "LOKI_URL",
+	"TRACING_ENABLED", "OTLP_ENDPOINT", "TRACE_SAMPLER", "TRACE_SAMPLER_RATIO", "TRACE_SAMPLER_RATE", "TRACE_SAMPLER_LATENCY", "TRACE_SAMPLER_ERRORS",
+	"TRACE_STREAM_EVENT_TOKENS", "TRACE_STREAM_STALL",
+	"MODEL_PROBE_INTERVAL", "TRUNCATION_STRATEGY", "MEMORY_TRUNCATION_STRATEGY", "CONTEXT_OUTPUT_RESERVE", "CONTEXT_OVERFLOW_ACTION",
+	"SAMPLING_PROFILES_FILE", "DEFAULT_SAMPLING_PROFILE", "PROMPTS_FILE",
+	"HISTORY_DIR", "HISTORY_CACHE_MB", "HISTORY_SEARCH_EMBEDDING_MODEL", "HISTORY_SEARCH_COLLECTION", "GUARDRAILS_FILE",
+	"REQUEST_LOG_PATH", "REQUEST_LOG_MAX_RECORDS", "MODEL_PRICES",
+	"REPORT_SCHEDULE", "REPORT_HOUR", "REPORT_SLACK_WEBHOOK_URL", "REPORT_EMAIL_TO", "REPORT_EMAIL_FROM", "SMTP_ADDR", "SMTP_USERNAME", "SMTP_PASSWORD",
+	"PROMPT_COMPRESSION", "PROMPT_COMPRESSION_MIN_TOKENS", "PROMPT_COMPRESSOR_URL", "PROMPT_COMPRESSION_RATE",
+	"MODERATION_URL", "MODERATION_MODEL", "MODERATION_API_KEY", "MODERATION_POLICY_MESSAGE",
+	"EXPERIMENTS_FILE", "JUDGE_MODEL", "JUDGE_BASE_URL", "JUDGE_API_KEY", "JUDGE_SAMPLE_PERCENT",
+	"CLASSIFIER_TAXONOMY_FILE", "CLASSIFIER_MODEL", "CLASSIFIER_BASE_URL", "CLASSIFIER_API_KEY",
+	"SUMMARY_MODEL", "SUMMARY_REFRESH_MESSAGES",
+	"DRIFT_WINDOW", "DRIFT_MIN_SAMPLES", "DRIFT_EMBEDDING_MODEL",
+	"RAG_EMBEDDING_MODEL", "RAG_TOP_K", "RAG_CHUNK_TOKENS", "RAG_CHUNK_OVERLAP", "RAG_MIN_SCORE", "QDRANT_URL", "QDRANT_COLLECTION", "QDRANT_API_KEY",
+	"WHISPER_URL", "WHISPER_API_KEY",
+	"UPSTREAM_MAX_RETRIES", "UPSTREAM_RETRY_DELAY", "CIRCUIT_FAILURE_THRESHOLD", "CIRCUIT_OPEN_TIMEOUT",
+	"UPSTREAM_MAX_IDLE_CONNS", "UPSTREAM_MAX_IDLE_CONNS_PER_HOST", "UPSTREAM_MAX_CONNS_PER_HOST", "UPSTREAM_IDLE_CONN_TIMEOUT",
+	"UPSTREAM_TLS_SESSION_CACHE", "UPSTREAM_HTTP2",
+	"BASE_URLS", "ROUTING_STRATEGY", "ROUTING_FILE",
+	"WATCHDOG_MAX_DURATION", "WATCHDOG_MAX_TOKENS", "GENERATION_TIMEOUT", "GENERATION_MAX_TOKENS", "DRAIN_TIMEOUT",
+	"JOBS_WORKERS", "JOBS_QUEUE_SIZE", "JOBS_RETENTION", "JOBS_TIMEOUT", "BATCH_MAX_ITEMS", "BATCH_MAX_CONCURRENCY",
+	"REQUEST_MAX_BODY_BYTES", "REQUEST_MAX_MESSAGES", "REQUEST_MAX_MESSAGE_BYTES", "REQUEST_ALLOWED_ROLES",
+	"STRUCTURED_OUTPUT_RETRIES", "HEALTH_PROBE_TTL", "HEALTH_PROBE_TIMEOUT", "STARTUP_STRICT", "STARTUP_CHECK_TIMEOUT",
+	"MODEL_CAPABILITY_INTERVAL", "MODEL_LIST_TTL", "LLAMACPP_URL", "LLAMACPP_SCRAPE_INTERVAL",
+	"VLLM_URL", "VLLM_SCRAPE_INTERVAL", "BACKEND_TYPE", "MEMORY_ALERT_THRESHOLD", "MEMORY_POLL_INTERVAL",
+	"PRIORITY_CONFIG", "PRIORITY_MAX_CONCURRENCY", "OUTPUT_RATE_LIMIT", "OUTPUT_RATE_LIMITS",
+	"PORT", "METRICS_PORT", "ADMIN_PORT", "METRICS_STREAM_INTERVAL", "CORS_ALLOWED_ORIGINS", "CORS_ALLOW_CREDENTIALS", "CORS_MAX_AGE", "API_SUNSET",
+	"COMPRESSION_ENABLED", "COMPRESSION_GZIP_LEVEL", "COMPRESSION_BROTLI_LEVEL", "COMPRESSION_MIN_BYTES", "METRICS_SUMMARY_CACHE_TTL",
+	"REQUEST_TIMEOUT", "STREAM_TIMEOUT", "STREAM_IDLE_TIMEOUT", "ROUTE_TIMEOUTS", "METRICS_MAX_ENDPOINTS",
+	"HISTOGRAM_MODE", "NATIVE_HISTOGRAM_BUCKET_FACTOR", "NATIVE_HISTOGRAM_MAX_BUCKETS", "LATENCY_BUCKETS", "TTFT_BUCKETS", "REQUEST_DURATION_BUCKETS",
+	"PROFILING_SERVER_URL", "PROFILING_APP_NAME", "PROFILING_INTERVAL", "PROFILING_AUTH_TOKEN", "PROFILING_TAGS",
+	"TLS_CERT_FILE", "TLS_KEY_FILE", "METRICS_TLS_CERT_FILE", "METRICS_TLS_KEY_FILE", "METRICS_CLIENT_CA_FILE", "SESSION_ACTIVE_WINDOW",
+	"QUOTA_TOKENS_PER_DAY", "QUOTA_REQUESTS_PER_HOUR", "QUOTA_FILE", "TENANTS_FILE",
+	"ARCHIVE_SINK", "ARCHIVE_SPOOL_PATH", "ARCHIVE_REPLAY_INTERVAL", "ARCHIVE_QUEUE_SIZE",
+	"EVENT_BUS_URL", "EVENT_BUS_TOPICS", "EVENT_BUS_TYPES", "EVENT_BUS_FORMAT", "EVENT_BUS_QUEUE_SIZE",
+	"WEBHOOK_URLS", "WEBHOOK_SECRET", "WEBHOOK_EVENTS", "WEBHOOK_MAX_RETRIES",
+	"BILLING_CONFIG", "BILLING_EXPORT_URL", "BILLING_EXPORT_INTERVAL", "BILLING_LEDGER_PATH",
+	"PII_ANONYMIZE", "PII_NER_URL", "PII_NER_TOKEN", "PII_NER_MIN_SCORE",
+	"RETENTION_HISTORY", "RETENTION_FEEDBACK", "RETENTION_AUDIT", "RETENTION_PURGE_INTERVAL", "AUDIT_LOG_PATH",
+	"TRANSCRIPT_ARCHIVE_URL", "TRANSCRIPT_ARCHIVE_BATCH_SIZE", "TRANSCRIPT_ARCHIVE_FLUSH_INTERVAL", "TRANSCRIPT_ARCHIVE_GZIP", "TRANSCRIPT_ARCHIVE_KEY", "TRANSCRIPT_ARCHIVE_RETENTION_DAYS",
+}
 
-		// Create a metrics summary by reading from Prometheus metrics
-		summary := MetricsSummary{
-			TotalRequests:      getCounterValue(requestCounter),
-			AverageResponseTime: getAverageResponseTime(requestDuration),
-			TokensGenerated:    getCounterValue(chatTokensCounter, "output", defaultModel),
-			TokensProcessed:    getCounterValue(chatTokensCounter, "input", defaultModel),
-			ActiveUsers:        getGaugeValue(activeRequests),
-			ErrorRate:          calculateErrorRate(),
-			LlamaCppMetrics:    llamaCppMetrics,
+// runtimeConfig returns the configured environment with secrets redacted
+func runtimeConfig() map[string]string {
+	config := make(map[string]string, len(configKeys))
+	for _, key := range configKeys {
+		value := os.Getenv(key)
+		if value == "" {
+			value = secretStore.Value(key)
+		}
+		if value != "" && (strings.Contains(key, "KEY") || strings.Contains(key, "TOKEN") || strings.Contains(key, "SECRET") ||
+			strings.Contains(key, "PASSWORD") || key == "REPORT_SLACK_WEBHOOK_URL") {
+			value = "[redacted]"
 		}
+		config[key] = value
+	}
+	return config
+}
 
-		json.NewEncoder(w).Encode(summary)
-	})
-	
-	// Add metrics logging endpoint
-	mux.HandleFunc("/metrics/log", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// decodeChatRequest decodes a single JSON chat request from an untrusted body
+func decodeChatRequest(body io.Reader) (ChatRequest, error) {
+	var req ChatRequest
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		return ChatRequest{}, err
+	}
+	return req, nil
+}
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// validateChatJob rejects job bodies that would fail as a chat request
+func validateChatJob(body []byte) error {
+	req, err := decodeChatRequest(bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid request body")
+	}
+	if req.Message == "" {
+		return errors.New("message is required")
+	}
+	return req.validateGenerationParams()
+}
 
-		// Parse metrics from the request
-		var metricLog MetricLog
-		if err := json.NewDecoder(r.Body).Decode(&metricLog); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
+// indexConversation embeds newly stored messages for semantic history search
+func indexConversation(vectors *history.VectorIndex, conversationID string, offset int, messages []history.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-		// Log the metrics using Prometheus (don't increment counters as they are already tracked)
-		// Just log the first token latency which isn't already tracked
-		if metricLog.FirstTokenMs > 0 {
-			firstTokenLatency.WithLabelValues(defaultModel).Observe(metricLog.FirstTokenMs / 1000.0)
-		}
+	if err := vectors.Index(ctx, conversationID, offset, messages...); err != nil {
+		log := logger.GetLogger()
+		log.Warn().Err(err).Str("conversation_id", conversationID).Msg("Failed to index conversation for search")
+	}
+}
 
-		w.WriteHeader(http.StatusOK)
+// observePromptTopic embeds a prompt and feeds it to topic drift detection
+func observePromptTopic(client *openai.Client, prompt string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resp, err := client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Input: openai.F[openai.EmbeddingNewParamsInputUnion](openai.EmbeddingNewParamsInputArrayOfStrings{prompt}),
+		Model: openai.F(openai.EmbeddingModel(driftEmbeddingModel)),
 	})
-	
-	// Add llama.cpp metrics logging endpoint
-	mux.HandleFunc("/metrics/llamacpp", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if err != nil {
+		log := logger.GetLogger()
+		log.Debug().Err(err).Msg("Failed to embed prompt for drift detection")
+		return
+	}
+	if len(resp.Data) > 0 {
+		driftDetector.ObserveEmbedding(resp.Data[0].Embedding)
+	}
+}
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// FeedbackRequest rates the responses of a conversation
+type FeedbackRequest struct {
+	ConversationID string `json:"conversation_id"`
+	MessageID      string `json:"message_id,omitempty"` // rates one response rather than the whole conversation
+	Rating         int    `json:"rating"` // 1 (thumbs up) or -1 (thumbs down)
+	Comment        string `json:"comment,omitempty"`
+}
 
-		// Parse metrics from the request
-		var llamaCppLog LlamaCppMetrics
-		if err := json.NewDecoder(r.Body).Decode(&llamaCppLog); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
+// findMessage looks up a stored response by its message ID
+func findMessage(conversationID, messageID string) (history.Message, bool) {
+	if conversationID == "" || messageID == "" {
+		return history.Message{}, false
+	}
+	conv, err := conversations.Get(conversationID)
+	if err != nil {
+		return history.Message{}, false
+	}
+	for _, msg := range conv.Messages {
+		if msg.ID == messageID {
+			return msg, true
 		}
+	}
+	return history.Message{}, false
+}
 
-		// Record all llama.cpp metrics
-		llamacppContextSize.WithLabelValues(defaultModel).Set(float64(llamaCppLog.ContextSize))
-		llamacppPromptEvalTime.WithLabelValues(defaultModel).Observe(llamaCppLog.PromptEvalTime / 1000.0) // Convert ms to seconds
-		llamacppTokensPerSecond.WithLabelValues(defaultModel).Set(llamaCppLog.TokensPerSecond)
-		llamacppMemoryPerToken.WithLabelValues(defaultModel).Set(llamaCppLog.MemoryPerToken)
-		llamacppThreadsUsed.WithLabelValues(defaultModel).Set(float64(llamaCppLog.ThreadsUsed))
-		llamacppBatchSize.WithLabelValues(defaultModel).Set(float64(llamaCppLog.BatchSize))
+// handleFeedback records a user rating on a stored conversation
+func handleFeedback(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogger()
 
-		w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req FeedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, apierror.InvalidRequest, "Invalid request body")
+		return
+	}
+	if req.Rating != 1 && req.Rating != -1 {
+		apierror.Write(w, r, apierror.InvalidRequest, "Rating must be 1 or -1")
+		return
+	}
+
+	// Comments are free text, stored like prompts
+	req.Comment = piiAnonymizer.Anonymize(r.Context(), privacy.Prompt, req.Comment)
+
+	// Other callers' conversations are reported as missing
+	if stored, err := conversations.Get(req.ConversationID); err == nil && !ownsConversation(r, stored) {
+		apierror.Write(w, r, apierror.NotFound, "Conversation not found")
+		return
+	}
+
+	previous := 0
+	messageFound := req.MessageID == ""
+	conv, err := conversations.Update(req.ConversationID, func(c *history.Conversation) {
+		if req.MessageID != "" {
+			for i := range c.Messages {
+				if c.Messages[i].ID == req.MessageID {
+					messageFound = true
+					previous = c.Messages[i].Rating
+					c.Messages[i].Rating = req.Rating
+					c.Messages[i].Comment = req.Comment
+				}
+			}
+			if !messageFound {
+				return
+			}
+		} else {
+			previous = c.Rating
+		}
+		c.Rating = req.Rating
+		c.Comment = req.Comment
 	})
-	
-	// Add error logging endpoint
-	mux.HandleFunc("/metrics/error", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if errors.Is(err, history.ErrNotFound) || errors.Is(err, history.ErrInvalidID) {
+		apierror.Write(w, r, apierror.NotFound, "Conversation not found")
+		return
+	}
+	if err == nil && !messageFound {
+		apierror.Write(w, r, apierror.NotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("conversation_id", req.ConversationID).Str("message_id", req.MessageID).Msg("Failed to store feedback")
+		apierror.Write(w, r, apierror.Internal, "Internal server error")
+		return
+	}
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
+	// Only count a rating once, even if the client resubmits it
+	if previous != req.Rating {
+		rating := "positive"
+		if req.Rating < 0 {
+			rating = "negative"
 		}
+		feedbackCounter.WithLabelValues(conv.Model, rating).Inc()
+		if conv.Experiment != "" {
+			chatExperiments.RecordFeedback(conv.Experiment, conv.Variant, req.Rating)
+		}
+	}
 
-		// Parse error from the request
-		var errorLog ErrorLog
-		if err := json.NewDecoder(r.Body).Decode(&errorLog); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conv.Summarize())
+}
+
+// buildMetricsSummary reads the frontend metrics summary from the Prometheus collectors
+func buildMetricsSummary(defaultModel, baseURL string) MetricsSummary {
+	// Get llama.cpp metrics if the backend is llama.cpp
+	var llamaCppMetrics *LlamaCppMetrics
+	if backend.Default.Lookup(baseURL).LlamaCppMetrics {
+		llamaCppMetrics = getLlamaCppMetrics(defaultModel)
+	}
+
+	var vllmMetrics *vllm.Stats
+	if vllmCollector != nil {
+		if stats, ok := vllmCollector.Latest(defaultModel); ok {
+			vllmMetrics = &stats
 		}
+	}
 
-		// Log the error using Prometheus
-		errorCounter.WithLabelValues(errorLog.ErrorType).Inc()
+	var memoryStats *memory.Stats
+	if stats, ok := memoryMonitor.Latest(defaultModel); ok {
+		memoryStats = &stats
+	}
 
-		w.WriteHeader(http.StatusOK)
-	})
+	var containerStats *container.Stats
+	if modelContainer != nil {
+		if stats, ok := modelContainer.Latest(); ok {
+			containerStats = &stats
+		}
+	}
 
-	// Add chat endpoint with advanced tracing
-	mux.HandleFunc("/chat", handleChat(client, defaultModel, baseURL))
+	windows := make(map[string]window.Stats, len(summaryWindows))
+	for _, w := range summaryWindows {
+		windows[w.name] = recentTotals.Window(w.duration)
+	}
 
-	// Create HTTP server
-	server := &http.Server{
-		Addr:         ":8080",
-		Handler:      handlersChain(mux),
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 90 * time.Second,
+	return MetricsSummary{
+		TotalRequests:       getCounterValue(requestCounter),
+		AverageResponseTime: getAverageResponseTime(requestDuration),
+		TokensGenerated:     getCounterValue(chatTokensCounter, "output", defaultModel),
+		TokensProcessed:     getCounterValue(chatTokensCounter, "input", defaultModel),
+		ActiveUsers:         float64(sessions.Active()),
+		UniqueUsersHour:     float64(sessions.UniqueLastHour()),
+		UniqueUsersDay:      float64(sessions.UniqueLastDay()),
+		ErrorRate:           calculateErrorRate(),
+		LlamaCppMetrics:     llamaCppMetrics,
+		VLLMMetrics:         vllmMetrics,
+		Memory:              memoryStats,
+		Container:           containerStats,
+		Process:             processStats(),
+		Windows:             windows,
 	}
+}
 
-	// Start metrics server on a separate port with custom registry
-	metricsServer := &http.Server{
-		Addr:    ":9090",
-		Handler: promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
+// processStats reads the Go runtime's view of the aiwatch process
+func processStats() ProcessStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := ProcessStats{
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: mem.HeapAlloc,
+		HeapObjects:    mem.HeapObjects,
+		SysBytes:       mem.Sys,
+		GCCycles:       mem.NumGC,
 	}
-	
-	go func() {
-		log.Info().Str("addr", ":9090").Msg("Starting metrics server")
-		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal().Err(err).Msg("Failed to start metrics server")
+	if mem.NumGC > 0 {
+		stats.LastGCPauseMs = float64(mem.PauseNs[(mem.NumGC+255)%256]) / 1e6
+	}
+	return stats
+}
+
+// MetricsUpdate is one event on /metrics/stream: the current summary plus
+// how much each counter grew since the previous event
+type MetricsUpdate struct {
+	MetricsSummary
+	IntervalSeconds float64       `json:"intervalSeconds"`
+	Deltas          MetricsDeltas `json:"deltas"`
+}
+
+// MetricsDeltas are counter increases over one stream interval
+type MetricsDeltas struct {
+	Requests        float64 `json:"requests"`
+	TokensGenerated float64 `json:"tokensGenerated"`
+	TokensProcessed float64 `json:"tokensProcessed"`
+}
+
+// handleMetricsStream pushes a MetricsUpdate over SSE every interval, which
+// defaults to METRICS_STREAM_INTERVAL and can be set with ?interval=5s
+func handleMetricsStream(defaultModel, baseURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		interval := metricsStreamInterval
+		if v := r.URL.Query().Get("interval"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				if seconds, serr := strconv.Atoi(v); serr == nil {
+					d, err = time.Duration(seconds)*time.Second, nil
+				}
+			}
+			if err != nil || d < time.Second {
+				apierror.Write(w, r, apierror.InvalidRequest, "interval must be at least 1s")
+				return
+			}
+			interval = d
 		}
-	}()
 
-	// Start the main server
-	go func() {
-		log.Info().Str("addr", ":8080").Msg("Starting server")
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal().Err(err).Msg("Failed to start server")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		encoder := sse.NewEncoder(w)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var previous MetricsSummary
+		last := time.Now()
+		for first := true; ; first = false {
+			summary := buildMetricsSummary(defaultModel, baseURL)
+			update := MetricsUpdate{MetricsSummary: summary}
+			if !first {
+				update.IntervalSeconds = time.Since(last).Seconds()
+				update.Deltas = MetricsDeltas{
+					Requests:        summary.TotalRequests - previous.TotalRequests,
+					TokensGenerated: summary.TokensGenerated - previous.TokensGenerated,
+					TokensProcessed: summary.TokensProcessed - previous.TokensProcessed,
+				}
+			}
+			previous, last = summary, time.Now()
+
+			data, _ := json.Marshal(update)
+			if err := encoder.Encode(sse.Event{Name: "summary", Data: string(data)}); err != nil {
+				return
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
 		}
-	}()
+	}
+}
+
+// classifyUpstream maps a failed call to the model backend onto an error code
+func classifyUpstream(err error) apierror.Code {
+	status := 0
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		status = apiErr.StatusCode
+	}
+	return apierror.Classify(err, status)
+}
+
+// announceProxyRequest publishes the request_received event for a request
+// to /v1/chat/completions and counts it as waiting on the model until
+// observeProxyExchange records it
+func announceProxyRequest(r *http.Request, ex proxy.Exchange) {
+	models.Tracker.MarkLoading(ex.Model)
+	chatEvents.Publish(events.RequestReceived, events.Lifecycle{
+		RequestID:      r.Header.Get(apierror.RequestIDHeader),
+		ConversationID: r.Header.Get("X-Conversation-ID"),
+		Endpoint:       r.URL.Path,
+		Model:          ex.Model,
+		Client:         quota.Mask(quota.Identify(r)),
+	})
+}
+
+// observeProxyExchange records metrics for a request relayed through the
+// OpenAI-compatible /v1/chat/completions endpoint
+func observeProxyExchange(r *http.Request, ex proxy.Exchange) {
+	var modelErr error
+	switch {
+	case ex.Err != nil:
+		modelErr = ex.Err
+	case ex.StatusCode >= http.StatusBadRequest:
+		modelErr = fmt.Errorf("upstream returned status %d", ex.StatusCode)
+	}
+	if modelErr != nil {
+		errorCounter.WithLabelValues(string(apierror.Classify(ex.Err, ex.StatusCode)), ex.Model).Inc()
+	}
+	if ex.Enforced != "" {
+		enforcedStops.WithLabelValues(ex.Enforced, ex.Model).Inc()
+	}
 
-	// Set up graceful shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	log.Info().Msg("Shutting down server...")
+	tokensPerSecond := 0.0
+	if modelErr == nil {
+		chatTokensCounter.WithLabelValues("input", ex.Model).Add(float64(ex.PromptTokens))
+		chatTokensCounter.WithLabelValues("output", ex.Model).Add(float64(ex.CompletionTokens))
+		tracing.Observe(r.Context(), modelLatency.WithLabelValues(ex.Model, "inference", temperatureBucket(ex.Temperature)), ex.Latency.Seconds())
+		if ex.FirstToken > 0 {
+			tracing.Observe(r.Context(), firstTokenLatency.WithLabelValues(ex.Model, temperatureBucket(ex.Temperature)), ex.FirstToken.Seconds())
+		}
+		if seconds := ex.Latency.Seconds(); seconds > 0 {
+			tokensPerSecond = float64(ex.CompletionTokens) / seconds
+		}
+		recordTokenUsage(r, ex.Model, ex.PromptTokens, ex.CompletionTokens)
+	}
+	models.Tracker.RecordRequest(ex.Model, tokensPerSecond, modelErr)
 
-	// Shutdown the server with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	lifecycle := events.Lifecycle{
+		RequestID:       r.Header.Get(apierror.RequestIDHeader),
+		ConversationID:  r.Header.Get("X-Conversation-ID"),
+		Endpoint:        r.URL.Path,
+		Model:           ex.Model,
+		Client:          quota.Mask(quota.Identify(r)),
+		TokensIn:        ex.PromptTokens,
+		TokensOut:       ex.CompletionTokens,
+		TokensPerSecond: tokensPerSecond,
+		FirstTokenMs:    float64(ex.FirstToken.Milliseconds()),
+		LatencyMs:       float64(ex.Latency.Milliseconds()),
+		StatusCode:      ex.StatusCode,
+	}
+	if modelErr != nil {
+		lifecycle.ErrorCode, lifecycle.Error = string(apierror.Classify(ex.Err, ex.StatusCode)), modelErr.Error()
+		chatEvents.Publish(events.Error, lifecycle)
+	} else {
+		chatEvents.Publish(events.CompletionFinished, lifecycle)
+	}
+	logRequest(r, lifecycle)
 
-	// Shutdown servers
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatal().Err(err).Msg("Server forced to shutdown")
+	// Tee the exchange to the archives, as /chat does
+	if archivingChats() {
+		archived := make([]archive.Message, len(ex.Messages))
+		for i, msg := range ex.Messages {
+			archived[i] = archive.Message{Role: msg.Role, Content: msg.Content}
+		}
+		storeCtx := context.WithoutCancel(r.Context())
+		record := archive.Record{
+			ConversationID:  r.Header.Get("X-Conversation-ID"),
+			Endpoint:        r.URL.Path,
+			Model:           ex.Model,
+			Messages:        anonymizeMessages(storeCtx, archived),
+			Response:        piiAnonymizer.Anonymize(storeCtx, privacy.Response, ex.Response),
+			StatusCode:      ex.StatusCode,
+			TokensIn:        ex.PromptTokens,
+			TokensOut:       ex.CompletionTokens,
+			TokensPerSecond: tokensPerSecond,
+			ResponseTimeMs:  float64(ex.Latency.Milliseconds()),
+		}
+		if ex.FirstToken > 0 {
+			record.FirstTokenMs = float64(ex.FirstToken.Milliseconds())
+		}
+		archiveChat(record)
 	}
-	if err := metricsServer.Shutdown(ctx); err != nil {
-		log.Fatal().Err(err).Msg("Metrics server forced to shutdown")
+}
+
+// observeEmbeddingExchange records metrics for a request relayed through the
+// OpenAI-compatible /v1/embeddings endpoint
+func observeEmbeddingExchange(r *http.Request, ex proxy.EmbeddingExchange) {
+	if ex.Err != nil || ex.StatusCode >= http.StatusBadRequest {
+		embeddingRequests.WithLabelValues(ex.Model, "error").Inc()
+		errorCounter.WithLabelValues("embedding_error", ex.Model).Inc()
+		return
 	}
 
-	log.Info().Msg("Server exiting")
+	embeddingRequests.WithLabelValues(ex.Model, "success").Inc()
+	embeddingLatency.WithLabelValues(ex.Model).Observe(ex.Latency.Seconds())
+	embeddingBatchSize.WithLabelValues(ex.Model).Observe(float64(ex.BatchSize))
+	embeddingTokens.WithLabelValues(ex.Model).Add(float64(ex.Tokens))
+	recordTokenUsage(r, ex.Model, ex.Tokens, 0)
 }
 
-// getEnvOrDefault gets an environment variable or returns a default value
-func getEnvOrDefault(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+// observeTranscriptionExchange records metrics for a request relayed through
+// the /v1/audio/transcriptions endpoint
+func observeTranscriptionExchange(r *http.Request, ex proxy.TranscriptionExchange) {
+	if ex.Err != nil || ex.StatusCode >= http.StatusBadRequest {
+		transcriptionRequests.WithLabelValues(ex.Model, "error").Inc()
+		errorCounter.WithLabelValues("transcription_error", ex.Model).Inc()
+		return
+	}
+
+	transcriptionRequests.WithLabelValues(ex.Model, "success").Inc()
+	transcriptionLatency.WithLabelValues(ex.Model).Observe(ex.Latency.Seconds())
+	if ex.AudioDuration > 0 {
+		transcriptionAudioDuration.WithLabelValues(ex.Model).Observe(ex.AudioDuration.Seconds())
+		transcriptionRealtimeFactor.WithLabelValues(ex.Model).Observe(ex.RealtimeFactor())
 	}
-	return value
 }
 
 // handleChat handles the chat endpoint with simple tracing
 func handleChat(client *openai.Client, defaultModel string, apiBaseURL string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log := logger.GetLogger()
-		
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+		received := time.Now()
 
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			apierror.Write(w, r, apierror.MethodNotAllowed, "Method not allowed")
 			return
 		}
 
-		var req ChatRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		req, err := decodeChatRequest(r.Body)
+		if err != nil {
 			log.Error().Err(err).Msg("Invalid request body")
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			apierror.Write(w, r, apierror.InvalidRequest, "Invalid request body")
 			requestCounter.WithLabelValues(r.Method, r.URL.Path, fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
 			return
 		}
 
+		// Resolve the truncation strategy before any output is streamed
+		truncationStrategy := getEnvOrDefault("TRUNCATION_STRATEGY", truncation.DropOldest)
+		if req.Truncation != "" {
+			truncationStrategy = req.Truncation
+		}
+		if !truncation.IsValid(truncationStrategy) {
+			apierror.Write(w, r, apierror.InvalidRequest, "Invalid truncation strategy")
+			return
+		}
+
+		if err := req.validateGenerationParams(); err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, err.Error())
+			return
+		}
+		responseFormat, err := structured.Parse(req.ResponseFormat, req.JSONSchema)
+		if err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, err.Error())
+			return
+		}
+
+		// Render the requested system prompt template
+		systemPrompt := ""
+		if req.Template != "" {
+			systemPrompt, err = promptTemplates.Render(req.Template, req.Variables)
+			if errors.Is(err, prompts.ErrNotFound) {
+				apierror.Write(w, r, apierror.InvalidRequest, fmt.Sprintf("Unknown prompt template %q", req.Template))
+				return
+			}
+			if err != nil {
+				apierror.Write(w, r, apierror.InvalidRequest, err.Error())
+				return
+			}
+		}
+
+		// Refuse prompts that fail the input guardrails without calling the model
+		checkGuardrails := flags.Default.Enabled("guardrails")
+		if checkGuardrails {
+			guardrailsCtx, guardrailsStage := tracing.StartStage(r.Context(), tracing.StageGuardrails)
+			for _, text := range req.clientTexts(systemPrompt) {
+				if violation := chatGuardrails.CheckInput(guardrailsCtx, text); violation != nil {
+					log.Warn().Str("rule", violation.Rule).Str("reason", violation.Reason).Msg("Prompt blocked by guardrails")
+					tracing.AddAttribute(guardrailsCtx, "guardrails.rule", violation.Rule)
+					guardrailsStage.End(tracing.OutcomeBlocked, nil)
+					guardrails.WriteRefusal(w, violation)
+					return
+				}
+			}
+			guardrailsStage.End(tracing.OutcomeOK, nil)
+		}
+
+		// Continue the client's conversation or start a new one
+		_, historyStage := tracing.StartStage(r.Context(), tracing.StageHistory)
+		defer historyStage.End("rejected", nil)
+		var historyErr error
+		conversationID := r.Header.Get("X-Conversation-ID")
+		if conversationID == "" {
+			conversationID = req.ConversationID
+		}
+		newConversation := conversationID == ""
+		fromMemory := false
+		if newConversation {
+			conversationID = uuid.New().String()
+		} else if !history.ValidID(conversationID) {
+			apierror.Write(w, r, apierror.InvalidRequest, "Invalid conversation ID")
+			return
+		} else if stored, err := conversations.Get(conversationID); errors.Is(err, history.ErrNotFound) {
+			// A client-chosen ID starts a conversation that keeps the history it sent
+			newConversation = true
+		} else if err == nil && !ownsConversation(r, stored) {
+			apierror.Write(w, r, apierror.NotFound, "Conversation not found")
+			return
+		} else if err != nil {
+			log.Error().Err(err).Str("conversation_id", conversationID).Msg("Failed to load conversation")
+			historyErr = err
+		} else if len(req.Messages) == 0 {
+			// Clients that only send the new turn get the stored history as context
+			for _, msg := range stored.Messages {
+				req.Messages = append(req.Messages, Message{Role: msg.Role, Content: msg.Content})
+			}
+			fromMemory = len(req.Messages) > 0
+			if fromMemory && req.Truncation == "" {
+				truncationStrategy = memoryTruncationStrategy
+			}
+		}
+		switch {
+		case historyErr != nil:
+			historyStage.End(tracing.OutcomeError, historyErr)
+		case fromMemory:
+			historyStage.End("loaded", nil)
+		case newConversation:
+			historyStage.End("new", nil)
+		default:
+			historyStage.End("client_history", nil)
+		}
+		w.Header().Set("X-Conversation-ID", conversationID)
+		// Every response gets an ID that joins its logs, spans, history entry,
+		// feedback and client-reported metrics
+		messageID := uuid.New().String()
+		w.Header().Set("X-Message-ID", messageID)
+		log = log.With().Str("message_id", messageID).Str("conversation_id", conversationID).Logger()
+		tracing.AddAttribute(r.Context(), "message.id", messageID)
+		tracing.AddAttribute(r.Context(), "conversation.id", conversationID)
+
 		// Set headers for SSE
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
 
 		// Use the model specified in the request, or fall back to default model
 		modelToUse := defaultModel
@@ -688,6 +4941,61 @@ func handleChat(client *openai.Client, defaultModel string, apiBaseURL string) h
 			log.Info().Str("model", modelToUse).Msg("Using user-selected model")
 		}
 
+		// Requests that leave the model choice to the server take part in
+		// any running experiment, keeping each session on one variant
+		var assignment experiments.Assignment
+		inExperiment := false
+		if req.Model == "" {
+			key := session.FromContext(r.Context())
+			if key == "" {
+				key = conversationID
+			}
+			assignment, inExperiment = chatExperiments.Assign(key)
+		}
+		if inExperiment {
+			modelToUse = assignment.Model
+			tracing.AddAttribute(r.Context(), "experiment.id", assignment.Experiment)
+			tracing.AddAttribute(r.Context(), "experiment.variant", assignment.Variant)
+			log.Info().Str("experiment", assignment.Experiment).Str("variant", assignment.Variant).Str("model", modelToUse).Msg("Assigned experiment variant")
+		}
+		if !allowModel(r, modelToUse) {
+			apierror.Write(w, r, apierror.Forbidden, fmt.Sprintf("Model %s is not available to this API key", modelToUse))
+			return
+		}
+		if profilingLabels {
+			// Goroutines started from here on, such as the stream reader,
+			// inherit the label
+			profiling.WithModel(r.Context(), modelToUse)
+		}
+
+		// Announce the request to subscribers such as billing
+		lifecycle := events.Lifecycle{
+			RequestID:      r.Header.Get(apierror.RequestIDHeader),
+			MessageID:      messageID,
+			ConversationID: conversationID,
+			Endpoint:       r.URL.Path,
+			Model:          modelToUse,
+			Client:         quota.Mask(quota.Identify(r)),
+		}
+		chatEvents.Publish(events.RequestReceived, lifecycle)
+
+		// Resolve the sampling profile for the selected model
+		profileName := getEnvOrDefault("DEFAULT_SAMPLING_PROFILE", "")
+		if req.Profile != "" {
+			profileName = req.Profile
+		}
+		var profile sampling.Profile
+		if profileName != "" {
+			resolved, ok := samplingProfiles.Resolve(modelToUse, profileName)
+			if !ok {
+				apierror.Write(w, r, apierror.InvalidRequest, fmt.Sprintf("Unknown sampling profile %q for model %s", profileName, modelToUse))
+				return
+			}
+			profile = resolved
+		} else {
+			profileName = "none"
+		}
+
 		// Count input tokens (rough estimate)
 		inputTokens := 0
 		for _, msg := range req.Messages {
@@ -695,48 +5003,169 @@ func handleChat(client *openai.Client, defaultModel string, apiBaseURL string) h
 		}
 		inputTokens += len(req.Message) / 4
 		
-		// Track metrics for input tokens
-		chatTokensCounter.WithLabelValues("input", modelToUse).Add(float64(inputTokens))
-
 		// Start model timing
 		start := time.Now()
 		modelStartTime := time.Now()
 		var firstTokenTime time.Time
 		outputTokens := 0
+		var response strings.Builder
 
-		var messages []openai.ChatCompletionMessageParamUnion
+		// Ground the answer in the document library
+		ragPrompt := ""
+		if req.RAG {
+			if ragIndex == nil {
+				apierror.Write(w, r, apierror.InvalidRequest, "RAG is not configured")
+				return
+			}
+			retrievalCtx, retrievalStage := tracing.StartStage(r.Context(), tracing.StageRetrieval)
+			matches, err := ragIndex.Retrieve(retrievalCtx, tenants.FromContext(r.Context()), req.Message, req.RAGTopK)
+			if err != nil {
+				// Answer without context rather than failing the chat
+				log.Warn().Err(err).Msg("Document retrieval failed")
+				errorCounter.WithLabelValues("rag_retrieval", modelToUse).Inc()
+				retrievalStage.End(tracing.OutcomeError, err)
+			} else if len(matches) == 0 {
+				retrievalStage.End("empty", nil)
+			} else {
+				retrievalStage.End(tracing.OutcomeOK, nil)
+			}
+			ragPrompt = ragIndex.Prompt(matches)
+			w.Header().Set("X-RAG-Chunks", strconv.Itoa(len(matches)))
+		}
+
+		// The output format can be set explicitly, or asked for in the message
+		userMessage := req.Message
+		outputFormat, err := formatting.Parse(req.Format)
+		if err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, err.Error())
+			return
+		}
+		formatSource := "request"
+		if outputFormat == formatting.None && flags.Default.Enabled("markdown_detection") {
+			outputFormat, formatSource = formatting.Detect(userMessage), "detected"
+		}
+		formatPrompt := ""
+		if outputFormat != formatting.None {
+			outputFormats.WithLabelValues(string(outputFormat), formatSource).Inc()
+			if formatPrompt, err = promptTemplates.Render(outputFormat.Template(), nil); err != nil {
+				log.Error().Err(err).Str("template", outputFormat.Template()).Msg("Failed to render output format template, using the built-in one")
+				builtin, _ := prompts.Builtin(outputFormat.Template())
+				formatPrompt = builtin.Content
+			}
+		}
+
+		// Trim the history to fit the model's context window, leaving room for
+		// the system prompts and the reply
+		conversation := make([]truncation.Message, 0, len(req.Messages)+1)
 		for _, msg := range req.Messages {
+			conversation = append(conversation, truncation.Message{Role: msg.Role, Content: msg.Content})
+		}
+		conversation = append(conversation, truncation.Message{Role: "user", Content: req.Message})
+
+		// Compress long prompts, retrieved context included, before they
+		// count against the context window
+		if promptCompressor.Enabled() {
+			prompt := []compression.Message{{Role: "system", Content: ragPrompt}}
+			for _, msg := range conversation {
+				prompt = append(prompt, compression.Message{Role: msg.Role, Content: msg.Content})
+			}
+			compressionCtx, compressionStage := tracing.StartStage(r.Context(), tracing.StageCompression)
+			compressed := promptCompressor.Compress(compressionCtx, modelToUse, prompt)
+			tracing.AddAttribute(compressionCtx, "compression.tokens_saved", compressed.TokensSaved())
+			compressionStage.End(tracing.OutcomeOK, nil)
+			ragPrompt = compressed.Messages[0].Content
+			for i := range conversation {
+				conversation[i].Content = compressed.Messages[i+1].Content
+			}
+			userMessage = conversation[len(conversation)-1].Content
+			if saved := compressed.TokensSaved(); saved > 0 {
+				w.Header().Set(compression.Header, strconv.Itoa(saved))
+			}
+		}
+
+		outputReserve, _ := strconv.Atoi(getEnvOrDefault("CONTEXT_OUTPUT_RESERVE", "512"))
+		contextWindow := getContextWindow(modelToUse)
+		systemTokens := truncation.EstimateTokens(req.System) + truncation.EstimateTokens(systemPrompt) + truncation.EstimateTokens(ragPrompt) + truncation.EstimateTokens(formatPrompt)
+		budget := contextWindow - outputReserve - systemTokens
+		overflow := truncation.CountTokens(conversation) > budget
+		if overflow {
+			contextOverflows.WithLabelValues(modelToUse).Inc()
+		}
+
+		// Stored history is always trimmed, since the client never sees how
+		// long it has grown; otherwise an overflow is trimmed unless the
+		// server is set to reject it
+		truncationCtx, truncationStage := tracing.StartStage(r.Context(), tracing.StageTruncation)
+		truncated := truncation.Result{Messages: conversation}
+		if overflow && (fromMemory || (contextOverflowAction == "truncate" && flags.Default.Enabled("history_truncation"))) {
+			truncated, _ = truncation.Apply(truncationStrategy, conversation, budget)
+		}
+		tracing.AddAttribute(truncationCtx, "truncation.dropped_messages", truncated.DroppedCount)
+		tracing.AddAttribute(truncationCtx, "truncation.dropped_tokens", truncated.DroppedTokens)
+		if truncated.DroppedCount > 0 {
+			truncationCounter.WithLabelValues(truncationStrategy, modelToUse).Inc()
+			truncatedTokensCounter.WithLabelValues(truncationStrategy, modelToUse).Add(float64(truncated.DroppedTokens))
+			if fromMemory {
+				memoryTrims.WithLabelValues(truncationStrategy, modelToUse).Inc()
+			}
+			log.Warn().Str("strategy", truncationStrategy).Int("dropped_messages", truncated.DroppedCount).Int("dropped_tokens", truncated.DroppedTokens).Msg("Truncated conversation history to fit the context window")
+			w.Header().Set("X-Context-Truncated", strconv.Itoa(truncated.DroppedCount))
+		}
+
+		// Refuse prompts that still don't fit rather than letting the backend
+		// fail part way through the stream
+		if promptTokens := systemTokens + truncation.CountTokens(truncated.Messages); promptTokens+outputReserve > contextWindow {
+			log.Warn().Str("model", modelToUse).Int("prompt_tokens", promptTokens).Int("context_window", contextWindow).Msg("Prompt exceeds the context window")
+			truncationStage.End(tracing.OutcomeBlocked, nil)
+			apierror.Write(w, r, apierror.ContextOverflow, fmt.Sprintf("Prompt of about %d tokens plus %d reserved for the reply exceeds the %d token context window of %s", promptTokens, outputReserve, contextWindow, modelToUse))
+			requestCounter.WithLabelValues(r.Method, r.URL.Path, fmt.Sprintf("%d", http.StatusBadRequest)).Inc()
+			return
+		}
+
+		if truncated.DroppedCount > 0 {
+			truncationStage.End("truncated", nil)
+		} else {
+			truncationStage.End(tracing.OutcomeOK, nil)
+		}
+
+		// Track metrics for input tokens
+		chatTokensCounter.WithLabelValues("input", modelToUse).Add(float64(inputTokens))
+
+		earlier := truncated.Messages[:len(truncated.Messages)-1]
+
+		var messages []openai.ChatCompletionMessageParamUnion
+		for _, msg := range earlier {
 			var message openai.ChatCompletionMessageParamUnion
 			switch msg.Role {
 			case "user":
 				message = openai.UserMessage(msg.Content)
 			case "assistant":
 				message = openai.AssistantMessage(msg.Content)
+			case "system":
+				message = openai.SystemMessage(msg.Content)
 			}
 
 			messages = append(messages, message)
 		}
 
-		// Check if the user is requesting markdown output
-		useMarkdown := false
-		userMessage := req.Message
-		
-		// Format can be explicitly set in the request
-		if req.Format == "markdown" {
-			useMarkdown = true
+		// If a format is requested, prepend a system message asking for it
+		if formatPrompt != "" {
+			messages = append([]openai.ChatCompletionMessageParamUnion{openai.SystemMessage(formatPrompt)}, messages...)
 		}
-		
-		// Or it can be detected from the message
-		if strings.Contains(strings.ToLower(userMessage), "in markdown") ||
-		   strings.Contains(strings.ToLower(userMessage), "using markdown") {
-			useMarkdown = true
+
+		// Retrieved context sits under the system prompts, ahead of the history
+		if ragPrompt != "" {
+			messages = append([]openai.ChatCompletionMessageParamUnion{openai.SystemMessage(ragPrompt)}, messages...)
 		}
-		
-		// If markdown is requested, modify the system prompt
-		if useMarkdown {
-			// Prepend a system message to request markdown formatting
-			systemMsg := openai.SystemMessage("Please format your response using markdown. Use proper headings, bullet points, numbered lists, code blocks with syntax highlighting, and tables where appropriate.")
-			messages = append([]openai.ChatCompletionMessageParamUnion{systemMsg}, messages...)
+
+		// The requested template goes first so it frames the whole conversation
+		if systemPrompt != "" {
+			messages = append([]openai.ChatCompletionMessageParamUnion{openai.SystemMessage(systemPrompt)}, messages...)
+		}
+
+		// An explicit system prompt always comes first
+		if req.System != "" {
+			messages = append([]openai.ChatCompletionMessageParamUnion{openai.SystemMessage(req.System)}, messages...)
 		}
 
 		// Add the user message to the conversation
@@ -747,22 +5176,181 @@ func handleChat(client *openai.Client, defaultModel string, apiBaseURL string) h
 			Model:    openai.F(modelToUse),
 		}
 
+		// Apply the sampling profile, if any
+		if profile.Temperature != nil {
+			param.Temperature = openai.F(*profile.Temperature)
+		}
+		if profile.TopP != nil {
+			param.TopP = openai.F(*profile.TopP)
+		}
+		if profile.MaxTokens != nil {
+			param.MaxTokens = openai.F(*profile.MaxTokens)
+		}
+
+		// Explicit request parameters take precedence over the profile
+		temperature := profile.Temperature
+		maxTokens := profile.MaxTokens
+		if req.Temperature != nil {
+			temperature = req.Temperature
+			param.Temperature = openai.F(*req.Temperature)
+		}
+		if req.TopP != nil {
+			param.TopP = openai.F(*req.TopP)
+		}
+		if req.MaxTokens != nil {
+			maxTokens = req.MaxTokens
+			param.MaxTokens = openai.F(*req.MaxTokens)
+		}
+		if len(req.Stop) > 0 {
+			param.Stop = openai.F[openai.ChatCompletionNewParamsStopUnion](openai.ChatCompletionNewParamsStopArray(req.Stop))
+		}
+		if responseFormat != nil {
+			param.ResponseFormat = openai.F(responseFormat.Param())
+		} else if outputFormat == formatting.JSON {
+			param.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](openai.ResponseFormatJSONObjectParam{
+				Type: openai.F(openai.ResponseFormatJSONObjectTypeJSONObject),
+			})
+		}
+		profileRequests.WithLabelValues(modelToUse, profileName).Inc()
+		tracing.AddAttribute(r.Context(), "sampling.profile", profileName)
+
+		// Load a model that isn't loaded yet, telling the client how the
+		// load is going with events ahead of the reply text
+		preambleSent := false
+		if flags.Default.Enabled("model_hot_swap") && !models.Lifecycle.IsLoaded(modelToUse) {
+			http.NewResponseController(w).SetWriteDeadline(time.Time{})
+			events := sse.NewEncoder(w)
+			loading := func(status string, elapsed time.Duration) {
+				data, _ := json.Marshal(map[string]interface{}{
+					"model":      modelToUse,
+					"status":     status,
+					"elapsed_ms": elapsed.Milliseconds(),
+				})
+				events.Encode(sse.Event{Name: "loading", Data: string(data)})
+				preambleSent = true
+			}
+			swapStart := time.Now()
+			swapped, err := models.Lifecycle.Ensure(r.Context(), modelToUse, func(elapsed time.Duration) {
+				loading("loading", elapsed)
+			})
+			if err != nil {
+				if r.Context().Err() != nil {
+					return
+				}
+				// The backend may still load the model itself on first use
+				log.Warn().Err(err).Str("model", modelToUse).Msg("Failed to load model on demand")
+			} else if swapped {
+				loading("loaded", time.Since(swapStart))
+				log.Info().Str("model", modelToUse).Dur("load_time", time.Since(swapStart)).Msg("Loaded model on demand")
+			}
+			tracing.AddAttribute(r.Context(), "model.swapped", swapped)
+		}
+
 		// Set prompt evaluation start time for llama.cpp metrics
 		promptEvalStartTime := time.Now()
+		// Measure the KV cache the request adds, reading the backend after it
+		// ends without holding the response up
+		finishMemory := memoryMonitor.Track(modelToUse)
+		defer func() {
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				finishMemory(ctx)
+			}()
+		}()
+
+		ctx, generation := generationWatchdog.Track(r.Context(), modelToUse)
+		defer generation.Done()
+		if generationTimeout > 0 {
+			var cancelGeneration context.CancelFunc
+			ctx, cancelGeneration = context.WithTimeout(ctx, generationTimeout)
+			defer cancelGeneration()
+		}
+		models.Tracker.MarkLoading(modelToUse)
+
+		// Trace this turn's generation on a span of its own, with events as
+		// batches of tokens arrive and when the output stalls
+		turn := 0
+		for _, msg := range req.Messages {
+			if msg.Role == "user" {
+				turn++
+			}
+		}
+		ctx, tracedGeneration := tracing.StartStream(ctx, streamTracing)
+		defer tracedGeneration.End("client_closed", nil)
+		tracing.AddAttribute(ctx, "model.name", modelToUse)
+		tracing.AddAttribute(ctx, "conversation.id", conversationID)
+		tracing.AddAttribute(ctx, "conversation.turn", turn)
+		tracing.AddAttribute(ctx, "message.id", messageID)
 
-		ctx := r.Context()
+		// Keep the conversation on one backend so its prompt cache is reused
+		ctx = routing.WithAffinity(ctx, conversationID)
 		stream := client.Chat.Completions.NewStreaming(ctx, param)
+		defer stream.Close()
 
+		outputBlocked := false
+		cutShort := "" // why the server ended the generation early, if it did
+		var moderated *moderation.Stream
+		if outputModerator != nil && flags.Default.Enabled("output_moderation") {
+			moderated = moderation.NewStream(outputModerator, moderationFlags)
+		}
+		// Clean the output up for its format as it streams
+		var formatter *formatting.Stream
+		if flags.Default.Enabled("output_postprocessing") {
+			formatter = formatting.NewStream(outputFormat)
+		}
+		var firstChunkTime time.Time
+		finishReason := ""
+		// Ollama reports its own token count and generation time
+		serving := backend.Default.Lookup(apiBaseURL)
+		fromOllama := serving.EvalTimings
+		var ollamaTimings *ollama.Timings
+		streamed := preambleSent // whether any output has reached the client
+		// Pace the output when the request's class or the client caps its rate
+		pacer := outputPacing.Pacer(priority.FromContext(r.Context()), req.MaxTokensPerSecond)
+		if pacer != nil {
+			defer pacer.Done()
+		}
+		pacedTokens := 0
+		// Enforce the stop sequences and token limit in case the backend ignores them
+		var stopScanner *stops.Scanner
+		if flags.Default.Enabled("stop_enforcement") {
+			limit := 0
+			if maxTokens != nil {
+				limit = int(*maxTokens)
+			}
+			stopScanner = stops.New(req.Stop, limit)
+		}
 		for stream.Next() {
 			chunk := stream.Current()
+			if firstChunkTime.IsZero() {
+				firstChunkTime = time.Now()
+			}
+			if len(chunk.Choices) > 0 && chunk.Choices[0].FinishReason != "" {
+				finishReason = string(chunk.Choices[0].FinishReason)
+			}
+			if fromOllama {
+				if timings, ok := ollama.ParseTimings(chunk.JSON.RawJSON()); ok {
+					ollamaTimings = &timings
+				}
+			}
+			if serving.LlamaCppMetrics {
+				if timings, ok := llamacpp.ParseTimings(chunk.JSON.RawJSON()); ok && timings.DraftTokens > 0 {
+					specDecodeTokens.WithLabelValues(modelToUse, "drafted").Add(float64(timings.DraftTokens))
+					specDecodeTokens.WithLabelValues(modelToUse, "accepted").Add(float64(timings.DraftAccepted))
+					specDecodeAcceptanceRate.WithLabelValues(modelToUse).Set(timings.AcceptanceRate())
+				}
+			}
 
 			// Record first token time
 			if firstTokenTime.IsZero() && len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
 				firstTokenTime = time.Now()
+				firstTokenEvent := lifecycle
+				firstTokenEvent.FirstTokenMs = float64(firstTokenTime.Sub(modelStartTime).Milliseconds())
+				chatEvents.Publish(events.FirstToken, firstTokenEvent)
 				
 				// For llama.cpp, record prompt evaluation time
-				if strings.Contains(strings.ToLower(modelToUse), "llama") || 
-				   strings.Contains(apiBaseURL, "llama.cpp") {
+				if serving.LlamaCppMetrics {
 					promptEvalTime := firstTokenTime.Sub(promptEvalStartTime)
 					llamacppPromptEvalTime.WithLabelValues(modelToUse).Observe(promptEvalTime.Seconds())
 				}
@@ -770,19 +5358,222 @@ func handleChat(client *openai.Client, defaultModel string, apiBaseURL string) h
 
 			// Stream each chunk as it arrives
 			if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+				if generationMaxTokens > 0 && outputTokens >= generationMaxTokens {
+					cutShort = "max_tokens"
+					break
+				}
 				outputTokens++
-				_, err := fmt.Fprintf(w, "%s", chunk.Choices[0].Delta.Content)
-				if err != nil {
-					log.Error().Err(err).Msg("Error writing to stream")
-					return
+				generation.AddTokens(1)
+				tracedGeneration.Tokens(1)
+
+				// Hold back text that may start a stop sequence, and cut the
+				// generation once one is complete
+				delta := chunk.Choices[0].Delta.Content
+				stopped := false
+				if stopScanner != nil {
+					delta, stopped = stopScanner.Write(delta)
+				}
+
+				// Check the chunk in the context of the text just before it,
+				// so patterns split across chunks are still caught
+				if checkGuardrails {
+					window := response.String()
+					if len(window) > guardrailWindow {
+						window = window[len(window)-guardrailWindow:]
+					}
+					if violation := chatGuardrails.CheckOutput(r.Context(), window+delta); violation != nil {
+						log.Warn().Str("rule", violation.Rule).Str("reason", violation.Reason).Msg("Response blocked by guardrails")
+						fmt.Fprintf(w, "\n\n[Response blocked: %s]", violation.Reason)
+						w.(http.Flusher).Flush()
+						outputBlocked = true
+						break
+					}
+				}
+
+				response.WriteString(delta)
+
+				// Hold text back until its sentence has passed moderation
+				content := delta
+				if moderated != nil {
+					var flagged bool
+					content, flagged = moderated.Write(r.Context(), content)
+					if flagged {
+						log.Warn().Str("model", modelToUse).Msg("Response flagged by output moderation")
+						fmt.Fprintf(w, "\n\n%s", moderationPolicyMessage)
+						w.(http.Flusher).Flush()
+						outputBlocked = true
+						break
+					}
+				}
+				if formatter != nil {
+					content = formatter.Write(content)
+				}
+				if content != "" {
+					if pacer != nil {
+						if err := pacer.Wait(r.Context(), outputTokens-pacedTokens); err != nil {
+							return
+						}
+						pacedTokens = outputTokens
+					}
+
+					_, err := fmt.Fprintf(w, "%s", content)
+					if err != nil {
+						log.Error().Err(err).Msg("Error writing to stream")
+						return
+					}
+					w.(http.Flusher).Flush()
+					streamed = true
+				}
+				if stopped {
+					finishReason = stopScanner.Reason()
+					log.Info().Str("model", modelToUse).Str("reason", finishReason).Msg("Backend ignored the request's limits, cutting the stream")
+					enforcedStops.WithLabelValues(finishReason, modelToUse).Inc()
+					break
+				}
+			}
+		}
+
+		// Tell the client when the server cut the generation short, as opposed
+		// to the client disconnecting
+		if chatDrain.Cut(r.Context()) {
+			cutShort = "shutdown"
+		} else if cutShort == "" && r.Context().Err() == nil {
+			if generation.KillReason() != "" {
+				cutShort = "watchdog"
+			} else if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				cutShort = "timeout"
+			}
+		}
+		switch {
+		case cutShort != "":
+			tracedGeneration.End(cutShort, nil)
+		case outputBlocked:
+			tracedGeneration.End("content_filter", nil)
+		default:
+			tracedGeneration.End(finishReason, stream.Err())
+		}
+
+		// Release text held back in case it started a stop sequence
+		if stopScanner != nil && stopScanner.Reason() == "" && !outputBlocked && (stream.Err() == nil || cutShort != "") {
+			if rest := stopScanner.Flush(); rest != "" {
+				response.WriteString(rest)
+				if moderated != nil {
+					var flagged bool
+					rest, flagged = moderated.Write(r.Context(), rest)
+					if flagged {
+						log.Warn().Str("model", modelToUse).Msg("Response flagged by output moderation")
+						rest = "\n\n" + moderationPolicyMessage
+						outputBlocked = true
+					}
+				}
+				if formatter != nil && !outputBlocked {
+					rest = formatter.Write(rest)
+				}
+				if rest != "" {
+					fmt.Fprintf(w, "%s", rest)
+					w.(http.Flusher).Flush()
 				}
+			}
+		}
+
+		// Release the last moderated sentence
+		if moderated != nil && !outputBlocked && (stream.Err() == nil || cutShort != "") {
+			rest, flagged := moderated.Flush(r.Context())
+			if flagged {
+				log.Warn().Str("model", modelToUse).Msg("Response flagged by output moderation")
+				rest = "\n\n" + moderationPolicyMessage
+				outputBlocked = true
+			} else if formatter != nil {
+				rest = formatter.Write(rest)
+			}
+			if rest != "" {
+				fmt.Fprintf(w, "%s", rest)
+				w.(http.Flusher).Flush()
+			}
+		}
+
+		// Release the last formatted line
+		if formatter != nil && !outputBlocked {
+			if rest := formatter.Flush(); rest != "" {
+				fmt.Fprintf(w, "%s", rest)
 				w.(http.Flusher).Flush()
 			}
 		}
 
+		if cutShort != "" {
+			log.Warn().Str("model", modelToUse).Str("reason", cutShort).Int("tokens", outputTokens).Msg("Generation truncated")
+			generationsTruncated.WithLabelValues(cutShort, modelToUse).Inc()
+			fmt.Fprint(w, "\n\n")
+			event := sse.Event{
+				Name: "truncated",
+				Data: fmt.Sprintf(`{"reason":%q,"tokens":%d}`, cutShort, outputTokens),
+			}
+			if cutShort == "shutdown" {
+				// Ask the client to retry against another replica
+				event = sse.Event{Name: "reconnect", Data: `{"reason":"shutdown","retry_ms":1000}`}
+			}
+			sse.NewEncoder(w).Encode(event)
+		}
+
+		// Prefer Ollama's token count to counting chunks
+		if ollamaTimings != nil && cutShort == "" && !outputBlocked && ollamaTimings.EvalCount > 0 {
+			outputTokens = ollamaTimings.EvalCount
+		}
+
+		// End the stream with the server's accounting of the response, so
+		// clients don't have to estimate it
+		if stream.Err() == nil || cutShort != "" {
+			usage := ChatUsage{
+				MessageID:      messageID,
+				ConversationID: conversationID,
+				Model:          modelToUse,
+				InputTokens:    inputTokens,
+				OutputTokens:   outputTokens,
+				LatencyMs:      float64(time.Since(start).Microseconds()) / 1000,
+				FinishReason:   finishReason,
+			}
+			if !firstTokenTime.IsZero() {
+				usage.FirstTokenMs = float64(firstTokenTime.Sub(modelStartTime).Microseconds()) / 1000
+				if generationTime := time.Since(firstTokenTime).Seconds(); generationTime > 0 {
+					usage.TokensPerSecond = float64(outputTokens) / generationTime
+				}
+			}
+			if ollamaTimings != nil {
+				usage.TokensPerSecond = ollamaTimings.TokensPerSecond()
+			}
+			switch {
+			case outputBlocked:
+				usage.FinishReason = "content_filter"
+			case cutShort != "":
+				usage.FinishReason = cutShort
+			case usage.FinishReason == "":
+				usage.FinishReason = "stop"
+			}
+			if cutShort == "" {
+				fmt.Fprint(w, "\n\n")
+			}
+			data, _ := json.Marshal(usage)
+			sse.NewEncoder(w).Encode(sse.Event{Name: "usage", Data: string(data)})
+		}
+
+		// Break the request down into phases: waiting before the upstream call,
+		// until the backend starts responding, until the first content token,
+		// generating, and the bookkeeping after the stream ends
+		streamEnd := time.Now()
+		requestPhaseDuration.WithLabelValues("queue", modelToUse).Observe(promptEvalStartTime.Sub(received).Seconds())
+		if !firstChunkTime.IsZero() {
+			requestPhaseDuration.WithLabelValues("prompt_eval", modelToUse).Observe(firstChunkTime.Sub(promptEvalStartTime).Seconds())
+		}
+		if !firstTokenTime.IsZero() {
+			requestPhaseDuration.WithLabelValues("first_token", modelToUse).Observe(firstTokenTime.Sub(firstChunkTime).Seconds())
+			requestPhaseDuration.WithLabelValues("generation", modelToUse).Observe(streamEnd.Sub(firstTokenTime).Seconds())
+		}
+		defer func() {
+			requestPhaseDuration.WithLabelValues("postprocess", modelToUse).Observe(time.Since(streamEnd).Seconds())
+		}()
+
 		// Calculate tokens per second for llama.cpp metrics
-		if strings.Contains(strings.ToLower(modelToUse), "llama") || 
-		   strings.Contains(apiBaseURL, "llama.cpp") {
+		if serving.LlamaCppMetrics {
 			totalTime := time.Since(firstTokenTime).Seconds()
 			if totalTime > 0 && outputTokens > 0 {
 				tokensPerSecond := float64(outputTokens) / totalTime
@@ -791,21 +5582,205 @@ func handleChat(client *openai.Client, defaultModel string, apiBaseURL string) h
 		}
 
 		// Record metrics
-		requestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(time.Since(start).Seconds())
+		tracing.Observe(r.Context(), requestDuration.WithLabelValues(r.Method, r.URL.Path), time.Since(start).Seconds())
 		requestCounter.WithLabelValues(r.Method, r.URL.Path, "200").Inc()
 		chatTokensCounter.WithLabelValues("output", modelToUse).Add(float64(outputTokens))
-		modelLatency.WithLabelValues(modelToUse, "inference").Observe(time.Since(modelStartTime).Seconds())
+		tracing.Observe(r.Context(), modelLatency.WithLabelValues(modelToUse, "inference", temperatureBucket(temperature)), time.Since(modelStartTime).Seconds())
+		profileLatency.WithLabelValues(modelToUse, profileName).Observe(time.Since(modelStartTime).Seconds())
 		
 		if !firstTokenTime.IsZero() {
 			ttft := firstTokenTime.Sub(modelStartTime).Seconds()
 			log.Info().Float64("seconds", ttft).Msg("Time to first token")
-			firstTokenLatency.WithLabelValues(modelToUse).Observe(ttft)
+			tracing.Observe(r.Context(), firstTokenLatency.WithLabelValues(modelToUse, temperatureBucket(temperature)), ttft)
+		}
+
+		// Feed the live model status shown in /models
+		liveTokensPerSecond := 0.0
+		if !firstTokenTime.IsZero() {
+			if generationTime := time.Since(firstTokenTime).Seconds(); generationTime > 0 {
+				liveTokensPerSecond = float64(outputTokens) / generationTime
+			}
+		}
+		if ollamaTimings != nil {
+			liveTokensPerSecond = ollamaTimings.TokensPerSecond()
+			if rate := ollamaTimings.PromptTokensPerSecond(); rate > 0 {
+				ollamaPromptTokensPerSecond.WithLabelValues(modelToUse).Set(rate)
+			}
+		}
+		if fromOllama && liveTokensPerSecond > 0 {
+			ollamaTokensPerSecond.WithLabelValues(modelToUse).Set(liveTokensPerSecond)
+		}
+		modelErr := stream.Err()
+		if cutShort != "" {
+			// Server-side limits are not the model's fault
+			modelErr = nil
+		}
+		models.Tracker.RecordRequest(modelToUse, liveTokensPerSecond, modelErr)
+
+		if inExperiment {
+			outcome := experiments.Outcome{
+				Latency:   time.Since(modelStartTime),
+				TokensIn:  inputTokens,
+				TokensOut: outputTokens,
+				Failed:    modelErr != nil,
+			}
+			if !firstTokenTime.IsZero() {
+				outcome.FirstToken = firstTokenTime.Sub(modelStartTime)
+			}
+			chatExperiments.Record(assignment, outcome)
+		}
+
+		if reason := generation.KillReason(); reason != "" {
+			// The watchdog already logged and counted the kill; the tokens
+			// generated until then still count against the caller
+			recordTokenUsage(r, modelToUse, inputTokens, outputTokens)
+			return
+		}
+
+		if err := stream.Err(); err != nil && cutShort == "" {
+			code := classifyUpstream(err)
+			errorCounter.WithLabelValues(string(code), modelToUse).Inc()
+			tracing.AddAttribute(r.Context(), "error.type", string(code))
+			tracing.RecordError(r.Context(), err, "Model stream failed")
+			log.Error().Err(err).Str("code", string(code)).Str("model", modelToUse).Int("tokens", outputTokens).Msg("Error in stream")
+			// The prompt and the tokens streamed before the failure were served
+			recordTokenUsage(r, modelToUse, inputTokens, outputTokens)
+			failed := lifecycle
+			failed.ErrorCode, failed.Error = string(code), err.Error()
+			failed.TokensIn, failed.TokensOut = inputTokens, outputTokens
+			failed.LatencyMs = float64(time.Since(start).Milliseconds())
+			chatEvents.Publish(events.Error, failed)
+			logRequest(r, failed)
+			message := "The model backend failed to generate a response"
+			if !streamed {
+				apierror.Write(w, r, code, message)
+				return
+			}
+			// The response is already streaming, so report the failure as a
+			// closing event rather than a status
+			data, _ := json.Marshal(apierror.New(r, code, message))
+			fmt.Fprint(w, "\n\n")
+			sse.NewEncoder(w).Encode(sse.Event{Name: "error", Data: string(data)})
+			return
+		}
+
+		// Bookkeeping after the stream: usage, history, archives and the
+		// background analyses
+		_, postprocessStage := tracing.StartStage(r.Context(), tracing.StagePostprocess)
+		defer postprocessStage.End(tracing.OutcomeOK, nil)
+
+		// Count the exchange against the caller's token quota
+		recordTokenUsage(r, modelToUse, inputTokens, outputTokens)
+
+		finished := lifecycle
+		finished.StatusCode = http.StatusOK
+		finished.TokensIn, finished.TokensOut = inputTokens, outputTokens
+		finished.TokensPerSecond = liveTokensPerSecond
+		finished.LatencyMs = float64(time.Since(start).Milliseconds())
+		if !firstTokenTime.IsZero() {
+			finished.FirstTokenMs = float64(firstTokenTime.Sub(modelStartTime).Milliseconds())
 		}
+		chatEvents.Publish(events.CompletionFinished, finished)
+		logRequest(r, finished)
 
-		if err := stream.Err(); err != nil {
-			log.Error().Err(err).Msg("Error in stream")
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		// Blocked responses are not kept in history or archived
+		if outputBlocked {
+			postprocessStage.End(tracing.OutcomeSkipped, nil)
 			return
 		}
+
+		// Everything stored below is anonymized; the client already has the
+		// raw stream, and drift and evaluation only hold it in memory
+		storeCtx := context.WithoutCancel(r.Context())
+		sent := make([]archive.Message, len(req.Messages))
+		for i, msg := range req.Messages {
+			sent[i] = archive.Message{Role: msg.Role, Content: msg.Content}
+		}
+		sent = anonymizeMessages(storeCtx, sent)
+		storedPrompt := piiAnonymizer.Anonymize(storeCtx, privacy.Prompt, userMessage)
+		storedResponse := piiAnonymizer.Anonymize(storeCtx, privacy.Response, response.String())
+
+		// Store the exchange in the conversation history. A new conversation
+		// also takes the history the client sent along with it.
+		var stored []history.Message
+		if newConversation {
+			for _, msg := range sent {
+				stored = append(stored, history.Message{Role: msg.Role, Content: msg.Content})
+			}
+		}
+		stored = append(stored,
+			history.Message{Role: "user", Content: storedPrompt},
+			history.Message{ID: messageID, Role: "assistant", Content: storedResponse, Model: modelToUse},
+		)
+		conv, err := conversations.Append(conversationID, session.FromContext(r.Context()), modelToUse, stored...)
+		if err != nil {
+			log.Error().Err(err).Str("conversation_id", conversationID).Msg("Failed to store conversation")
+		} else if newConversation || inExperiment {
+			conversations.Update(conversationID, func(c *history.Conversation) {
+				if newConversation {
+					if tenant := tenants.FromContext(r.Context()); tenant != tenants.Default {
+						c.Tenant = tenant
+					}
+					c.Owner = conversationOwner(r)
+				}
+				if inExperiment {
+					c.Experiment, c.Variant = assignment.Experiment, assignment.Variant
+				}
+			})
+		}
+		if conversationSummarizer != nil && flags.Default.Enabled("conversation_summaries") {
+			conversationSummarizer.Submit(conversationID)
+		}
+		if vectors := conversations.VectorIndex(); vectors != nil && err == nil {
+			go indexConversation(vectors, conversationID, len(conv.Messages)-len(stored), stored)
+		}
+
+		// Track prompt/response distributions for drift detection
+		driftDetector.Observe(userMessage, response.String())
+		if driftEmbeddingModel != "" {
+			go observePromptTopic(client, userMessage)
+		}
+
+		// Sample the exchange for quality evaluation by the judge model
+		if responseEvaluator != nil {
+			responseEvaluator.Submit(evaluation.Job{
+				ConversationID: conversationID,
+				Model:          modelToUse,
+				Prompt:         userMessage,
+				Response:       response.String(),
+			})
+		}
+
+		// Sort the exchange into topic and safety categories
+		if chatClassifier != nil && flags.Default.Enabled("classification") {
+			chatClassifier.Submit(classify.Job{
+				ConversationID: conversationID,
+				Model:          modelToUse,
+				Prompt:         userMessage,
+				Response:       response.String(),
+			})
+		}
+
+		// Dual-write the completed exchange to the archives
+		if archivingChats() {
+			archived := append(sent, archive.Message{Role: "user", Content: storedPrompt})
+
+			record := archive.Record{
+				ConversationID:  conversationID,
+				Endpoint:        r.URL.Path,
+				Model:           modelToUse,
+				Messages:        archived,
+				Response:        storedResponse,
+				StatusCode:      http.StatusOK,
+				TokensIn:        inputTokens,
+				TokensOut:       outputTokens,
+				TokensPerSecond: liveTokensPerSecond,
+				ResponseTimeMs:  float64(time.Since(start).Milliseconds()),
+			}
+			if !firstTokenTime.IsZero() {
+				record.FirstTokenMs = float64(firstTokenTime.Sub(modelStartTime).Milliseconds())
+			}
+			archiveChat(record)
+		}
 	}
 }
\ No newline at end of file
@@ -0,0 +1,297 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ajeetraina/aiwatch/pkg/audit"
+	"github.com/ajeetraina/aiwatch/pkg/flags"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/middleware"
+	"github.com/ajeetraina/aiwatch/pkg/tracing"
+)
+
+// Router serves the operational /admin API. Every endpoint requires the
+// admin token, which is separate from any client-facing API keys.
+type Router struct {
+	tokenMu  sync.RWMutex
+	token    string
+	mux      *http.ServeMux
+	config   func() map[string]string
+	flags    *flags.Flags
+	inflight *middleware.InflightTracker
+
+	// Authorize, if set, admits requests without the admin token, such as
+	// those of users signed in with the admin role
+	Authorize func(*http.Request) bool
+	// Audit, if set, records every change made through the admin API
+	Audit *audit.Log
+
+	cachesMu sync.Mutex
+	caches   map[string]func()
+}
+
+// NewRouter creates the admin router. config returns the effective runtime
+// configuration with secrets already redacted.
+func NewRouter(token string, featureFlags *flags.Flags, inflight *middleware.InflightTracker, config func() map[string]string) *Router {
+	rt := &Router{
+		token:    token,
+		mux:      http.NewServeMux(),
+		config:   config,
+		flags:    featureFlags,
+		inflight: inflight,
+		caches:   make(map[string]func()),
+	}
+
+	rt.mux.HandleFunc("GET /admin/config", rt.handleConfig)
+	rt.mux.HandleFunc("GET /admin/flags", rt.handleListFlags)
+	rt.mux.HandleFunc("PUT /admin/flags/{name}", rt.handleSetFlag)
+	rt.mux.HandleFunc("GET /admin/log-level", rt.handleGetLogLevel)
+	rt.mux.HandleFunc("PUT /admin/log-level", rt.handleSetLogLevel)
+	rt.mux.HandleFunc("GET /admin/tracing/sampling", rt.handleGetSampling)
+	rt.mux.HandleFunc("PUT /admin/tracing/sampling", rt.handleSetSampling)
+	rt.mux.HandleFunc("GET /admin/requests", rt.handleRequests)
+	rt.mux.HandleFunc("GET /admin/caches", rt.handleListCaches)
+	rt.mux.HandleFunc("POST /admin/caches/flush", rt.handleFlushCaches)
+
+	return rt
+}
+
+// Handle registers an additional admin endpoint behind the same authentication
+func (rt *Router) Handle(pattern string, handler http.HandlerFunc) {
+	rt.mux.HandleFunc(pattern, handler)
+}
+
+// RegisterCache makes a cache flushable through POST /admin/caches/flush
+func (rt *Router) RegisterCache(name string, flush func()) {
+	rt.cachesMu.Lock()
+	defer rt.cachesMu.Unlock()
+	rt.caches[name] = flush
+}
+
+// ServeHTTP authenticates the request and dispatches it to the admin endpoints
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.Protect(rt.mux).ServeHTTP(w, r)
+}
+
+// Protect requires the admin token for an operational endpoint served
+// outside /admin
+func (rt *Router) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rt.currentToken() == "" && rt.Authorize == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "admin API is disabled; set ADMIN_TOKEN to enable it"})
+			return
+		}
+
+		if rt.HasToken(r) {
+			r = r.WithContext(audit.WithActor(r.Context(), "admin-token"))
+		} else if rt.Authorize == nil || !rt.Authorize(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="aiwatch-admin"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid admin token"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ProtectWrites requires the same authentication as Protect for requests
+// that change state, letting GET, HEAD and OPTIONS requests through
+func (rt *Router) ProtectWrites(next http.Handler) http.Handler {
+	protected := rt.Protect(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+		default:
+			protected.ServeHTTP(w, r)
+		}
+	})
+}
+
+// IsAdmin reports whether the request may use the admin API: it presents
+// the admin token, or Authorize admits it
+func (rt *Router) IsAdmin(r *http.Request) bool {
+	return rt.HasToken(r) || (rt.Authorize != nil && rt.Authorize(r))
+}
+
+// HasToken reports whether the request presents the admin token as a bearer
+// token or X-Admin-Token header
+func (rt *Router) HasToken(r *http.Request) bool {
+	want := rt.currentToken()
+	if want == "" {
+		return false
+	}
+	token := r.Header.Get("X-Admin-Token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// SetToken replaces the admin token, such as after it was rotated
+func (rt *Router) SetToken(token string) {
+	rt.tokenMu.Lock()
+	defer rt.tokenMu.Unlock()
+	rt.token = token
+}
+
+func (rt *Router) currentToken() string {
+	rt.tokenMu.RLock()
+	defer rt.tokenMu.RUnlock()
+	return rt.token
+}
+
+// handleConfig returns the effective runtime configuration
+func (rt *Router) handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, rt.config())
+}
+
+// handleListFlags returns every feature flag and its state
+func (rt *Router) handleListFlags(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, rt.flags.All())
+}
+
+// handleSetFlag toggles a single feature flag
+func (rt *Router) handleSetFlag(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": `request body must be {"enabled": true|false}`})
+		return
+	}
+
+	name := r.PathValue("name")
+	before, known := rt.flags.All()[name]
+	if !known || !rt.flags.Set(name, *body.Enabled) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown feature flag: " + name})
+		return
+	}
+
+	log := logger.GetLogger()
+	log.Info().Str("flag", name).Bool("enabled", *body.Enabled).Msg("Feature flag changed via admin API")
+	rt.record(r, audit.Entry{Action: "config.flag_changed", Subject: name, Before: before, After: *body.Enabled})
+	writeJSON(w, http.StatusOK, map[string]bool{name: *body.Enabled})
+}
+
+// handleGetLogLevel returns the current log level
+func (rt *Router) handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"level": logger.Level()})
+}
+
+// handleSetLogLevel changes the log level at runtime
+func (rt *Router) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	previous := logger.Level()
+	if err := logger.SetLevel(body.Level); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid log level: " + body.Level})
+		return
+	}
+
+	log := logger.GetLogger()
+	log.Warn().Str("from", previous).Str("to", logger.Level()).Msg("Log level changed via admin API")
+	rt.record(r, audit.Entry{Action: "config.log_level_changed", Before: previous, After: logger.Level()})
+	writeJSON(w, http.StatusOK, map[string]string{"level": logger.Level()})
+}
+
+// handleGetSampling returns the trace sampling config
+func (rt *Router) handleGetSampling(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, tracing.Sampling())
+}
+
+// handleSetSampling changes which traces are sampled at runtime
+func (rt *Router) handleSetSampling(w http.ResponseWriter, r *http.Request) {
+	var config tracing.SamplerConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	previous := tracing.Sampling()
+	if err := tracing.SetSampling(config); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	log := logger.GetLogger()
+	log.Warn().Str("from", previous.Strategy).Str("to", config.Strategy).Msg("Trace sampling changed via admin API")
+	rt.record(r, audit.Entry{Action: "config.trace_sampling_changed", Before: previous, After: config})
+	writeJSON(w, http.StatusOK, config)
+}
+
+// handleRequests lists the requests currently being served
+func (rt *Router) handleRequests(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, rt.inflight.List())
+}
+
+// handleListCaches lists the registered caches
+func (rt *Router) handleListCaches(w http.ResponseWriter, r *http.Request) {
+	rt.cachesMu.Lock()
+	names := make([]string, 0, len(rt.caches))
+	for name := range rt.caches {
+		names = append(names, name)
+	}
+	rt.cachesMu.Unlock()
+
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, names)
+}
+
+// handleFlushCaches flushes one cache (?name=) or all registered caches
+func (rt *Router) handleFlushCaches(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+
+	rt.cachesMu.Lock()
+	defer rt.cachesMu.Unlock()
+
+	flushed := []string{}
+	for cacheName, flush := range rt.caches {
+		if name != "" && name != cacheName {
+			continue
+		}
+		flush()
+		flushed = append(flushed, cacheName)
+	}
+
+	if name != "" && len(flushed) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown cache: " + name})
+		return
+	}
+
+	sort.Strings(flushed)
+	log := logger.GetLogger()
+	log.Info().Strs("caches", flushed).Msg("Caches flushed via admin API")
+	rt.record(r, audit.Entry{Action: "cache.flushed", After: flushed})
+	writeJSON(w, http.StatusOK, map[string][]string{"flushed": flushed})
+}
+
+// record audits a change made through the admin API
+func (rt *Router) record(r *http.Request, e audit.Entry) {
+	if rt.Audit == nil {
+		return
+	}
+	e.Actor = audit.Actor(r)
+	if err := rt.Audit.Record(e); err != nil {
+		log := logger.GetLogger()
+		log.Error().Err(err).Str("action", e.Action).Msg("Failed to write audit entry")
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,127 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ajeetraina/aiwatch/pkg/flags"
+)
+
+func ok(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestProtectWrites(t *testing.T) {
+	rt := NewRouter("secret", flags.New(), nil, nil)
+	h := rt.ProtectWrites(http.HandlerFunc(ok))
+
+	cases := []struct {
+		method, token string
+		want          int
+	}{
+		{http.MethodGet, "", http.StatusOK},
+		{http.MethodHead, "", http.StatusOK},
+		{http.MethodPost, "", http.StatusUnauthorized},
+		{http.MethodDelete, "wrong", http.StatusUnauthorized},
+		{http.MethodPut, "secret", http.StatusOK},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(tc.method, "/documents", nil)
+		if tc.token != "" {
+			r.Header.Set("X-Admin-Token", tc.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s with token %q: %d, want %d", tc.method, tc.token, w.Code, tc.want)
+		}
+	}
+}
+
+func TestProtectChecksToken(t *testing.T) {
+	cases := []struct {
+		name      string
+		token     string
+		authorize func(*http.Request) bool
+		header    string
+		value     string
+		want      int
+	}{
+		{"disabled without a token", "", nil, "X-Admin-Token", "", http.StatusNotFound},
+		{"missing token", "secret", nil, "", "", http.StatusUnauthorized},
+		{"wrong token", "secret", nil, "X-Admin-Token", "guess", http.StatusUnauthorized},
+		{"header token", "secret", nil, "X-Admin-Token", "secret", http.StatusOK},
+		{"bearer token", "secret", nil, "Authorization", "Bearer secret", http.StatusOK},
+		{"token prefix", "secret", nil, "X-Admin-Token", "secretive", http.StatusUnauthorized},
+		{"authorized without token", "", func(*http.Request) bool { return true }, "", "", http.StatusOK},
+		{"refused by authorize", "secret", func(*http.Request) bool { return false }, "", "", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		rt := NewRouter(tc.token, flags.New(), nil, nil)
+		rt.Authorize = tc.authorize
+		r := httptest.NewRequest(http.MethodPost, "/replay", nil)
+		if tc.header != "" {
+			r.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		rt.Protect(http.HandlerFunc(ok)).ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s: %d, want %d", tc.name, w.Code, tc.want)
+		}
+		if tc.want == http.StatusOK && !rt.IsAdmin(r) {
+			t.Errorf("%s: expected IsAdmin", tc.name)
+		}
+	}
+}
+
+func TestSetTokenRotates(t *testing.T) {
+	rt := NewRouter("old", flags.New(), nil, nil)
+	rt.SetToken("new")
+
+	for token, want := range map[string]bool{"old": false, "new": true} {
+		r := httptest.NewRequest(http.MethodGet, "/admin/flags", nil)
+		r.Header.Set("X-Admin-Token", token)
+		if got := rt.HasToken(r); got != want {
+			t.Errorf("HasToken with %q = %v, want %v", token, got, want)
+		}
+	}
+}
+
+func TestFlagEndpoints(t *testing.T) {
+	featureFlags := flags.New()
+	featureFlags.Register("archive", false)
+	rt := NewRouter("secret", featureFlags, nil, nil)
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("X-Admin-Token", "secret")
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		return w
+	}
+
+	cases := []struct {
+		target, body string
+		want         int
+	}{
+		{"/admin/flags/archive", `{"enabled": true}`, http.StatusOK},
+		{"/admin/flags/unknown", `{"enabled": true}`, http.StatusNotFound},
+		{"/admin/flags/archive", `{}`, http.StatusBadRequest},
+		{"/admin/flags/archive", `{"enabled": "yes"}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if w := serve(http.MethodPut, tc.target, tc.body); w.Code != tc.want {
+			t.Errorf("PUT %s %s: %d, want %d", tc.target, tc.body, w.Code, tc.want)
+		}
+	}
+	if !featureFlags.Enabled("archive") || featureFlags.Enabled("unknown") {
+		t.Errorf("unexpected flags after updates: %v", featureFlags.All())
+	}
+
+	var listed map[string]bool
+	if w := serve(http.MethodGet, "/admin/flags", ""); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &listed) != nil || !listed["archive"] || len(listed) != 1 {
+		t.Errorf("GET /admin/flags = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,148 @@
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// RequestIDHeader carries the ID that ties an error response to server logs
+const RequestIDHeader = "X-Request-ID"
+
+// Code classifies an error for clients and for the aiwatch_errors_total metric
+type Code string
+
+// Error codes. The upstream codes describe failures of the model backend.
+const (
+	InvalidRequest      Code = "invalid_request"
+	MethodNotAllowed    Code = "method_not_allowed"
+	NotFound            Code = "not_found"
+	Unauthorized        Code = "unauthorized"
+	Forbidden           Code = "forbidden"
+	RateLimited         Code = "rate_limited"
+	TooLarge            Code = "too_large"
+	ContextOverflow     Code = "context_overflow"
+	Unavailable         Code = "unavailable"
+	Timeout             Code = "timeout"
+	Internal            Code = "internal_error"
+	UpstreamTimeout     Code = "upstream_timeout"
+	UpstreamRateLimited Code = "upstream_rate_limited"
+	UpstreamUnavailable Code = "upstream_unavailable"
+	UpstreamError       Code = "upstream_error"
+)
+
+// Retryable reports whether a request that failed with c may succeed if
+// repeated unchanged
+func (c Code) Retryable() bool {
+	switch c {
+	case RateLimited, Unavailable, Timeout, UpstreamTimeout, UpstreamRateLimited, UpstreamUnavailable, UpstreamError:
+		return true
+	}
+	return false
+}
+
+// Status returns the HTTP status a response failing with c is sent with
+func (c Code) Status() int {
+	switch c {
+	case InvalidRequest, ContextOverflow:
+		return http.StatusBadRequest
+	case MethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case NotFound:
+		return http.StatusNotFound
+	case Unauthorized:
+		return http.StatusUnauthorized
+	case Forbidden:
+		return http.StatusForbidden
+	case RateLimited, UpstreamRateLimited:
+		return http.StatusTooManyRequests
+	case TooLarge:
+		return http.StatusRequestEntityTooLarge
+	case Unavailable, Timeout, UpstreamUnavailable:
+		return http.StatusServiceUnavailable
+	case UpstreamTimeout:
+		return http.StatusGatewayTimeout
+	case UpstreamError:
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is the body of an error response, sent as {"error": {...}}
+type Error struct {
+	Code      Code   `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	Retryable bool   `json:"retryable"`
+}
+
+// New builds the error body for a request, picking up its request ID
+func New(r *http.Request, code Code, message string) Error {
+	e := Error{Code: code, Message: message, Retryable: code.Retryable()}
+	if r != nil {
+		e.RequestID = r.Header.Get(RequestIDHeader)
+	}
+	return e
+}
+
+// Write sends a structured error response with the code's status
+func Write(w http.ResponseWriter, r *http.Request, code Code, message string) {
+	WriteStatus(w, r, code.Status(), code, message)
+}
+
+// WriteStatus sends a structured error response with an explicit status
+func WriteStatus(w http.ResponseWriter, r *http.Request, status int, code Code, message string) {
+	e := New(r, code, message)
+	if e.RequestID == "" {
+		e.RequestID = w.Header().Get(RequestIDHeader)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]Error{"error": e})
+}
+
+// contextOverflowPhrases are how backends report a prompt longer than the
+// model's context window
+var contextOverflowPhrases = []string{
+	"context length", "context window", "context size", "maximum context", "too many tokens", "prompt is too long",
+}
+
+// Classify maps a failed upstream call onto a code. status is the upstream
+// HTTP status, or 0 if no response was received.
+func Classify(err error, status int) Code {
+	if err != nil {
+		var netErr net.Error
+		switch {
+		case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+			return UpstreamTimeout
+		case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), strings.Contains(err.Error(), "connection refused"):
+			return UpstreamUnavailable
+		}
+	}
+
+	switch {
+	case status == http.StatusTooManyRequests:
+		return UpstreamRateLimited
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return UpstreamTimeout
+	case status == http.StatusServiceUnavailable:
+		return UpstreamUnavailable
+	case status == http.StatusBadRequest || status == http.StatusRequestEntityTooLarge:
+		if err != nil {
+			message := strings.ToLower(err.Error())
+			for _, phrase := range contextOverflowPhrases {
+				if strings.Contains(message, phrase) {
+					return ContextOverflow
+				}
+			}
+		}
+	}
+	return UpstreamError
+}
@@ -0,0 +1,61 @@
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		want   Code
+	}{
+		{context.DeadlineExceeded, 0, UpstreamTimeout},
+		{fmt.Errorf("dial tcp: %w", syscall.ECONNREFUSED), 0, UpstreamUnavailable},
+		{errors.New("boom"), http.StatusTooManyRequests, UpstreamRateLimited},
+		{errors.New("This model's maximum context length is 4096 tokens"), http.StatusBadRequest, ContextOverflow},
+		{errors.New("bad parameter"), http.StatusBadRequest, UpstreamError},
+		{nil, http.StatusInternalServerError, UpstreamError},
+	}
+	for _, c := range cases {
+		if got := Classify(c.err, c.status); got != c.want {
+			t.Errorf("Classify(%v, %d) = %s, want %s", c.err, c.status, got, c.want)
+		}
+	}
+}
+
+func TestWriteIncludesRequestID(t *testing.T) {
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, Unavailable, "Server is shutting down")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "client-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var body map[string]Error
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	want := Error{Code: Unavailable, Message: "Server is shutting down", RequestID: "client-123", Retryable: true}
+	if rec.Code != http.StatusServiceUnavailable || body["error"] != want {
+		t.Errorf("got %d %+v, want 503 %+v", rec.Code, body["error"], want)
+	}
+
+	// Malformed client IDs are replaced
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if id := rec.Header().Get(RequestIDHeader); id == "" || id == "bad id\n" {
+		t.Errorf("expected a generated request ID, got %q", id)
+	}
+}
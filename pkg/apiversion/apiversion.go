@@ -0,0 +1,123 @@
+// Package apiversion serves the API under a version prefix, /v1, while the
+// original unversioned paths keep working for existing clients. Responses
+// to unversioned paths carry deprecation headers pointing at their
+// versioned successor, so a later version can change request and response
+// shapes without breaking anyone who moved.
+package apiversion
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Current is the version served under Prefix
+const Current = "v1"
+
+// Prefix is the path prefix of the current version
+const Prefix = "/" + Current
+
+// Unversioned labels requests to the deprecated unversioned paths
+const Unversioned = "unversioned"
+
+// Config controls how unversioned paths are handled
+type Config struct {
+	// Sunset is when the unversioned paths will stop working, advertised
+	// in the Sunset header if set
+	Sunset time.Time
+	// Exempt paths are unversioned by design, such as health probes and
+	// Prometheus scrapes, and are served without deprecation headers.
+	// Entries ending in / match every path below them.
+	Exempt []string
+}
+
+// Metrics holds the collectors the router reports to
+type Metrics struct {
+	Requests *prometheus.CounterVec // labels: version (v1|unversioned)
+}
+
+// Router dispatches versioned and unversioned paths to the same routes
+type Router struct {
+	mux     *http.ServeMux
+	config  Config
+	metrics Metrics
+}
+
+// New creates a router serving the routes of mux, which are registered
+// without a version, under Prefix too. Routes mux registers under Prefix
+// itself, such as OpenAI-compatible endpoints, are served as they are.
+func New(mux *http.ServeMux, config Config, metrics Metrics) *Router {
+	return &Router{mux: mux, config: config, metrics: metrics}
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == Prefix || strings.HasPrefix(path, Prefix+"/"):
+		rt.count(Current)
+		w.Header().Set("API-Version", Current)
+		if _, pattern := rt.mux.Handler(r); strings.Contains(pattern, Prefix+"/") {
+			rt.mux.ServeHTTP(w, r)
+			return
+		}
+		rt.mux.ServeHTTP(w, stripPrefix(r))
+
+	case rt.exempt(path):
+		rt.mux.ServeHTTP(w, r)
+
+	default:
+		rt.count(Unversioned)
+		h := w.Header()
+		h.Set("Deprecation", "true")
+		h.Add("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", Prefix, path))
+		if !rt.config.Sunset.IsZero() {
+			h.Set("Sunset", rt.config.Sunset.UTC().Format(http.TimeFormat))
+		}
+		rt.mux.ServeHTTP(w, r)
+	}
+}
+
+// exempt reports whether path is unversioned by design
+func (rt *Router) exempt(path string) bool {
+	for _, p := range rt.config.Exempt {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rt *Router) count(version string) {
+	if rt.metrics.Requests != nil {
+		rt.metrics.Requests.WithLabelValues(version).Inc()
+	}
+}
+
+// Pattern returns the pattern of the route of mux that a Router over it
+// serves r with, or "" if none does. Versioned and unversioned paths to the
+// same route share its pattern.
+func Pattern(mux *http.ServeMux, r *http.Request) string {
+	path := r.URL.Path
+	_, pattern := mux.Handler(r)
+	if (path == Prefix || strings.HasPrefix(path, Prefix+"/")) && !strings.Contains(pattern, Prefix+"/") {
+		_, pattern = mux.Handler(stripPrefix(r))
+	}
+	return pattern
+}
+
+// stripPrefix returns a shallow copy of r for the unversioned path
+func stripPrefix(r *http.Request) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	u := *r.URL
+	u.Path = strings.TrimPrefix(u.Path, Prefix)
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawPath = strings.TrimPrefix(u.RawPath, Prefix)
+	r2.URL = &u
+	return r2
+}
@@ -0,0 +1,106 @@
+package apiversion
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newRouter() (*Router, *prometheus.CounterVec) {
+	mux := http.NewServeMux()
+	for _, pattern := range []string{"/models", "/health", "/metrics", "GET /conversations/{id}", "/v1/chat/completions"} {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(pattern + " " + r.URL.Path + " " + r.PathValue("id")))
+		})
+	}
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"version"})
+	return New(mux, Config{
+		Sunset: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Exempt: []string{"/health", "/metrics"},
+	}, Metrics{Requests: requests}), requests
+}
+
+func TestRouter(t *testing.T) {
+	tests := []struct {
+		path       string
+		body       string
+		version    string
+		deprecated bool
+	}{
+		{path: "/v1/models", body: "/models /models ", version: Current},
+		{path: "/v1/conversations/abc", body: "GET /conversations/{id} /conversations/abc abc", version: Current},
+		{path: "/v1/chat/completions", body: "/v1/chat/completions /v1/chat/completions ", version: Current},
+		{path: "/models", body: "/models /models ", deprecated: true},
+		{path: "/conversations/abc", body: "GET /conversations/{id} /conversations/abc abc", deprecated: true},
+		{path: "/health", body: "/health /health "},
+		{path: "/metrics", body: "/metrics /metrics "},
+	}
+	for _, tt := range tests {
+		router, _ := newRouter()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if rec.Code != http.StatusOK || rec.Body.String() != tt.body {
+			t.Errorf("%s: got %d %q, want %q", tt.path, rec.Code, rec.Body, tt.body)
+		}
+		if got := rec.Header().Get("API-Version"); got != tt.version {
+			t.Errorf("%s: API-Version %q, want %q", tt.path, got, tt.version)
+		}
+		if got := rec.Header().Get("Deprecation") == "true"; got != tt.deprecated {
+			t.Errorf("%s: deprecated %v, want %v", tt.path, got, tt.deprecated)
+		}
+		if tt.deprecated {
+			if got, want := rec.Header().Get("Link"), "</v1"+tt.path+`>; rel="successor-version"`; got != want {
+				t.Errorf("%s: Link %q, want %q", tt.path, got, want)
+			}
+			if got := rec.Header().Get("Sunset"); got != "Thu, 01 Jan 2026 00:00:00 GMT" {
+				t.Errorf("%s: Sunset %q", tt.path, got)
+			}
+		}
+	}
+}
+
+func TestRouterNotFound(t *testing.T) {
+	router, _ := newRouter()
+	for _, path := range []string{"/v1", "/v1/nope", "/v2/models"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d, want 404", path, rec.Code)
+		}
+	}
+}
+
+func TestPattern(t *testing.T) {
+	router, _ := newRouter()
+	tests := map[string]string{
+		"/models":               "/models",
+		"/v1/models":            "/models",
+		"/v1/conversations/abc": "GET /conversations/{id}",
+		"/v1/chat/completions":  "/v1/chat/completions",
+		"/nope":                 "",
+		"/v1/nope":              "",
+	}
+	for path, want := range tests {
+		if got := Pattern(router.mux, httptest.NewRequest(http.MethodGet, path, nil)); got != want {
+			t.Errorf("%s: pattern %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestRouterCountsVersions(t *testing.T) {
+	router, requests := newRouter()
+	for _, path := range []string{"/v1/models", "/v1/chat/completions", "/models", "/health"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if got := testutil.ToFloat64(requests.WithLabelValues(Current)); got != 2 {
+		t.Errorf("v1 requests = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(requests.WithLabelValues(Unversioned)); got != 1 {
+		t.Errorf("unversioned requests = %v, want 1", got)
+	}
+}
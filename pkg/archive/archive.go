@@ -0,0 +1,274 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/events"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// EventType is the event type used for archived chats
+const EventType = "chat.archived"
+
+// Message is a single turn of the archived conversation
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Record is a completed request/response pair
+type Record struct {
+	MessageID       string    `json:"message_id"`
+	ConversationID  string    `json:"conversation_id,omitempty"`
+	Endpoint        string    `json:"endpoint,omitempty"` // the aiwatch endpoint that served it
+	Model           string    `json:"model"`
+	Messages        []Message `json:"messages"`
+	Response        string    `json:"response"`
+	StatusCode      int       `json:"status_code,omitempty"`
+	TokensIn        int       `json:"tokens_in"`
+	TokensOut       int       `json:"tokens_out"`
+	TokensPerSecond float64   `json:"tokens_per_second,omitempty"`
+	ResponseTimeMs  float64   `json:"response_time_ms"`
+	FirstTokenMs    float64   `json:"time_to_first_token_ms"`
+	CompletedAt     time.Time `json:"completed_at"`
+}
+
+// Metrics holds the collectors the archiver reports to
+type Metrics struct {
+	DeliveryLag *prometheus.HistogramVec // labels: sink
+	Failures    *prometheus.CounterVec   // labels: sink
+	Replayed    *prometheus.CounterVec   // labels: sink
+	Spooled     prometheus.Gauge
+}
+
+// Archiver asynchronously forwards completed chats to a secondary sink.
+// Records that cannot be delivered are written to a local spool file and
+// replayed on an interval until the sink accepts them.
+type Archiver struct {
+	sink      events.Sink
+	spoolPath string
+	metrics   Metrics
+	queue     chan Record
+	closed    chan struct{} // stops the replay loop on Close
+	mu        sync.RWMutex  // held around sends to queue, so Close can't race them
+	stopped   bool          // set by Close; later records go to the spool
+	spoolMu   sync.Mutex
+	wg        sync.WaitGroup
+}
+
+// New creates an archiver delivering to sink and spooling failures to spoolPath
+func New(sink events.Sink, spoolPath string, queueSize int, metrics Metrics) *Archiver {
+	if queueSize <= 0 {
+		queueSize = 256
+	}
+	return &Archiver{
+		sink:      sink,
+		spoolPath: spoolPath,
+		metrics:   metrics,
+		queue:     make(chan Record, queueSize),
+		closed:    make(chan struct{}),
+	}
+}
+
+// Start launches the delivery worker and the replay loop. Deliveries use
+// ctx, so cancel it only after Close has drained the queue.
+func (a *Archiver) Start(ctx context.Context, replayInterval time.Duration) {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		for rec := range a.queue {
+			a.deliver(ctx, rec)
+		}
+	}()
+
+	if replayInterval <= 0 {
+		return
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ticker := time.NewTicker(replayInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-a.closed:
+				return
+			case <-ticker.C:
+				if _, err := a.Replay(ctx); err != nil {
+					log := logger.GetLogger()
+					log.Warn().Err(err).Msg("Archive replay failed")
+				}
+			}
+		}
+	}()
+}
+
+// Archive enqueues a record without blocking the caller. If the queue is
+// full the record is spooled for later replay.
+func (a *Archiver) Archive(rec Record) {
+	if rec.CompletedAt.IsZero() {
+		rec.CompletedAt = time.Now()
+	}
+
+	a.mu.RLock()
+	queued := false
+	if !a.stopped {
+		select {
+		case a.queue <- rec:
+			queued = true
+		default:
+		}
+	}
+	a.mu.RUnlock()
+	if !queued {
+		a.spool([]Record{rec})
+	}
+}
+
+// Close stops accepting records and the replay loop, and waits for queued
+// deliveries to finish. Records archived after Close are spooled.
+func (a *Archiver) Close() {
+	a.mu.Lock()
+	if a.stopped {
+		a.mu.Unlock()
+		return
+	}
+	a.stopped = true
+	close(a.queue)
+	a.mu.Unlock()
+	close(a.closed)
+	a.wg.Wait()
+}
+
+// deliver sends a single record to the sink, spooling it on failure
+func (a *Archiver) deliver(ctx context.Context, rec Record) {
+	if err := a.send(ctx, []Record{rec}); err != nil {
+		log := logger.GetLogger()
+		log.Warn().Err(err).Str("sink", a.sink.Name()).Str("message_id", rec.MessageID).Msg("Archive delivery failed, spooling record")
+		a.spool([]Record{rec})
+	}
+}
+
+// send converts records to events and delivers them, recording lag and failures
+func (a *Archiver) send(ctx context.Context, recs []Record) error {
+	batch := make([]events.Event, 0, len(recs))
+	for _, rec := range recs {
+		event, err := events.New(EventType, rec)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, event)
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if err := a.sink.Send(sendCtx, batch); err != nil {
+		if a.metrics.Failures != nil {
+			a.metrics.Failures.WithLabelValues(a.sink.Name()).Add(float64(len(recs)))
+		}
+		return err
+	}
+
+	if a.metrics.DeliveryLag != nil {
+		for _, rec := range recs {
+			a.metrics.DeliveryLag.WithLabelValues(a.sink.Name()).Observe(time.Since(rec.CompletedAt).Seconds())
+		}
+	}
+	return nil
+}
+
+// spool appends records to the spool file
+func (a *Archiver) spool(recs []Record) {
+	log := logger.GetLogger()
+	if a.spoolPath == "" {
+		log.Error().Int("records", len(recs)).Msg("Archive spool disabled, dropping records")
+		return
+	}
+
+	a.spoolMu.Lock()
+	defer a.spoolMu.Unlock()
+
+	f, err := os.OpenFile(a.spoolPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Error().Err(err).Str("path", a.spoolPath).Msg("Failed to open archive spool")
+		return
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			log.Error().Err(err).Msg("Failed to spool archive record")
+			return
+		}
+	}
+
+	if a.metrics.Spooled != nil {
+		a.metrics.Spooled.Add(float64(len(recs)))
+	}
+}
+
+// Replay redelivers spooled records. Records that still fail are kept in the
+// spool. It returns the number of records delivered.
+func (a *Archiver) Replay(ctx context.Context) (int, error) {
+	log := logger.GetLogger()
+
+	a.spoolMu.Lock()
+	defer a.spoolMu.Unlock()
+
+	if a.spoolPath == "" {
+		return 0, nil
+	}
+
+	f, err := os.Open(a.spoolPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var recs []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			log.Warn().Err(err).Msg("Skipping corrupt archive spool entry")
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(recs) == 0 {
+		return 0, os.Remove(a.spoolPath)
+	}
+
+	if err := a.send(ctx, recs); err != nil {
+		return 0, err
+	}
+
+	if a.metrics.Replayed != nil {
+		a.metrics.Replayed.WithLabelValues(a.sink.Name()).Add(float64(len(recs)))
+	}
+	if a.metrics.Spooled != nil {
+		a.metrics.Spooled.Set(0)
+	}
+
+	log.Info().Int("records", len(recs)).Str("sink", a.sink.Name()).Msg("Replayed spooled archive records")
+	return len(recs), os.Remove(a.spoolPath)
+}
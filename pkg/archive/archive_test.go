@@ -0,0 +1,150 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/events"
+)
+
+// fakeSink records the message IDs it accepts and fails while down
+type fakeSink struct {
+	mu   sync.Mutex
+	down bool
+	ids  []string
+}
+
+func (s *fakeSink) Name() string { return "fake" }
+
+func (s *fakeSink) Send(ctx context.Context, batch []events.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return errors.New("sink down")
+	}
+	for _, event := range batch {
+		var rec Record
+		if err := json.Unmarshal(event.Data, &rec); err != nil {
+			return err
+		}
+		s.ids = append(s.ids, rec.MessageID)
+	}
+	return nil
+}
+
+func (s *fakeSink) delivered() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ids...)
+}
+
+func TestArchiveDeliversQueuedRecordsOnClose(t *testing.T) {
+	sink := &fakeSink{}
+	a := New(sink, filepath.Join(t.TempDir(), "spool.jsonl"), 8, Metrics{})
+	ctx, cancel := context.WithCancel(context.Background())
+	a.Start(ctx, time.Hour)
+
+	for _, id := range []string{"m1", "m2", "m3"} {
+		a.Archive(Record{MessageID: id})
+	}
+	done := make(chan struct{})
+	go func() {
+		a.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return while the replay loop was running")
+	}
+	cancel()
+
+	if got := sink.delivered(); len(got) != 3 || got[0] != "m1" || got[2] != "m3" {
+		t.Fatalf("expected m1..m3 delivered in order, got %v", got)
+	}
+}
+
+func TestArchiveSpoolsFailedDeliveriesAndReplaysThem(t *testing.T) {
+	sink := &fakeSink{down: true}
+	spool := filepath.Join(t.TempDir(), "spool.jsonl")
+	a := New(sink, spool, 8, Metrics{})
+	a.Start(context.Background(), 0)
+	a.Archive(Record{MessageID: "m1"})
+	a.Archive(Record{MessageID: "m2"})
+	a.Close()
+
+	if _, err := os.Stat(spool); err != nil {
+		t.Fatalf("expected failed records to be spooled: %v", err)
+	}
+	if n, err := a.Replay(context.Background()); err == nil || n != 0 {
+		t.Fatalf("expected replay to fail while the sink is down, got %d, %v", n, err)
+	}
+	if _, err := os.Stat(spool); err != nil {
+		t.Fatalf("expected the spool to be kept after a failed replay: %v", err)
+	}
+
+	sink.mu.Lock()
+	sink.down = false
+	sink.mu.Unlock()
+	n, err := a.Replay(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 records replayed, got %d", n)
+	}
+	if got := sink.delivered(); len(got) != 2 || got[0] != "m1" || got[1] != "m2" {
+		t.Fatalf("expected the spooled records delivered, got %v", got)
+	}
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Fatalf("expected the spool removed after replay, got %v", err)
+	}
+	if n, err := a.Replay(context.Background()); err != nil || n != 0 {
+		t.Fatalf("expected nothing left to replay, got %d, %v", n, err)
+	}
+}
+
+func TestArchiveSpoolsWhenQueueIsFull(t *testing.T) {
+	sink := &fakeSink{}
+	spool := filepath.Join(t.TempDir(), "spool.jsonl")
+	a := New(sink, spool, 1, Metrics{})
+
+	// Not started, so the second record finds the queue full
+	a.Archive(Record{MessageID: "queued"})
+	a.Archive(Record{MessageID: "overflow"})
+
+	if n, err := a.Replay(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected the overflowing record replayed from the spool, got %d, %v", n, err)
+	}
+	if got := sink.delivered(); len(got) != 1 || got[0] != "overflow" {
+		t.Fatalf("expected overflow delivered, got %v", got)
+	}
+}
+
+func TestArchiveSpoolsAfterClose(t *testing.T) {
+	sink := &fakeSink{}
+	spool := filepath.Join(t.TempDir(), "spool.jsonl")
+	a := New(sink, spool, 4, Metrics{})
+	a.Start(context.Background(), 0)
+	a.Close()
+	a.Close()
+
+	// A chat finishing during shutdown must not panic on the closed queue
+	a.Archive(Record{MessageID: "late"})
+
+	if n, err := a.Replay(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected the late record replayed from the spool, got %d, %v", n, err)
+	}
+	if got := sink.delivered(); len(got) != 1 || got[0] != "late" {
+		t.Fatalf("expected late delivered, got %v", got)
+	}
+}
@@ -0,0 +1,274 @@
+// Package audit keeps an append-only record of privileged actions, such as
+// erasing a user's data, changing configuration or reading archived
+// transcripts, so they can be accounted for later.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/oidc"
+)
+
+// Entry is one audited action
+type Entry struct {
+	Time    time.Time      `json:"time"`
+	Actor   string         `json:"actor,omitempty"`   // who acted; see Actor
+	Action  string         `json:"action"`            // e.g. user.data_deleted
+	Subject string         `json:"subject,omitempty"` // who or what the action was about
+	Details map[string]int `json:"details,omitempty"` // e.g. records removed, by kind
+	// Before and After snapshot the state the action changed
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// System is the actor of actions aiwatch takes on its own, such as purges
+const System = "system"
+
+type actorKey struct{}
+
+// WithActor records who is making a request, for handlers that audit it
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns who is making a request: the actor set by WithActor, else
+// the signed-in user, else "anonymous"
+func Actor(r *http.Request) string {
+	if actor, ok := r.Context().Value(actorKey{}).(string); ok {
+		return actor
+	}
+	if id, ok := oidc.FromContext(r.Context()); ok {
+		if id.Email != "" {
+			return id.Email
+		}
+		return id.Subject
+	}
+	return "anonymous"
+}
+
+// Log appends entries as JSON lines to a file. Every entry is also written
+// to the application log, so a Log without a path still leaves a trail.
+type Log struct {
+	path string
+	now  func() time.Time
+	mu   sync.Mutex
+}
+
+// New creates a log writing to path, which may be empty
+func New(path string) *Log {
+	return &Log{path: path, now: time.Now}
+}
+
+// Record appends an entry, stamping its time if unset
+func (l *Log) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = l.now().UTC()
+	}
+	log := logger.GetLogger()
+	event := log.Info()
+	if e.Error != "" {
+		event = log.Warn().Str("error", e.Error)
+	}
+	event.Str("actor", e.Actor).Str("action", e.Action).Str("subject", e.Subject).Interface("details", e.Details).Msg("Audit")
+
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Spec describes how Middleware audits the requests to a handler
+type Spec struct {
+	Action string // e.g. tenant.changed
+	// Reads also records GET requests, for access to sensitive data
+	Reads bool
+	// Snapshot, if set, returns the state a request acts on. It is taken
+	// before and after the request for the entry's Before and After.
+	Snapshot func(r *http.Request) interface{}
+}
+
+// Middleware records the state-changing requests to next, with their actor,
+// method, path and status
+func (l *Log) Middleware(spec Spec, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			if !spec.Reads {
+				next.ServeHTTP(w, r)
+				return
+			}
+		case http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		e := Entry{Actor: Actor(r), Action: spec.Action, Subject: r.Method + " " + r.URL.RequestURI()}
+		if spec.Snapshot != nil && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			e.Before = spec.Snapshot(r)
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if spec.Snapshot != nil && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			e.After = spec.Snapshot(r)
+		}
+
+		e.Details = map[string]int{"status": rec.status}
+		if rec.status >= http.StatusBadRequest {
+			e.Error = http.StatusText(rec.status)
+		}
+		if err := l.Record(e); err != nil {
+			log := logger.GetLogger()
+			log.Error().Err(err).Str("action", e.Action).Msg("Failed to write audit entry")
+		}
+	})
+}
+
+// statusRecorder captures the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Entries returns the entries recorded at or after since, oldest first
+func (l *Log) Entries(since time.Time) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	all, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, e := range all {
+		if !e.Time.Before(since) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// Purge drops the entries recorded before cutoff and returns how many
+func (l *Log) Purge(cutoff time.Time) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	all, err := l.read()
+	if err != nil {
+		return 0, err
+	}
+
+	var kept bytes.Buffer
+	enc := json.NewEncoder(&kept)
+	purged := 0
+	for _, e := range all {
+		if e.Time.Before(cutoff) {
+			purged++
+			continue
+		}
+		enc.Encode(e)
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, kept.Bytes(), 0o600); err != nil {
+		return 0, fmt.Errorf("failed to rewrite audit log: %w", err)
+	}
+	return purged, os.Rename(tmp, l.path)
+}
+
+// read parses the whole file. Callers hold l.mu.
+func (l *Log) read() ([]Entry, error) {
+	if l.path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("corrupt audit log entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Handler serves the entries since the time given by the since parameter
+// (RFC 3339; the last 30 days by default), optionally only those whose
+// action starts with the action parameter or whose actor is actor
+func (l *Log) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, apierror.MethodNotAllowed, "Method not allowed")
+			return
+		}
+		since := l.now().Add(-30 * 24 * time.Hour)
+		if v := r.URL.Query().Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				apierror.Write(w, r, apierror.InvalidRequest, "since must be an RFC 3339 time")
+				return
+			}
+			since = t
+		}
+
+		all, err := l.Entries(since)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal, err.Error())
+			return
+		}
+		action, actor := r.URL.Query().Get("action"), r.URL.Query().Get("actor")
+		entries := []Entry{}
+		for _, e := range all {
+			if strings.HasPrefix(e.Action, action) && (actor == "" || e.Actor == actor) {
+				entries = append(entries, e)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	})
+}
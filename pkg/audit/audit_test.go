@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestMiddlewareRecordsChangesWithSnapshots(t *testing.T) {
+	l := New(filepath.Join(t.TempDir(), "audit.jsonl"))
+	state := "old"
+	handler := l.Middleware(Spec{
+		Action:   "setting.changed",
+		Snapshot: func(r *http.Request) interface{} { return state },
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			state = "new"
+		}
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/setting", nil))
+	req := httptest.NewRequest(http.MethodPut, "/setting", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(WithActor(req.Context(), "ops@example.com")))
+
+	entries, err := l.Entries(l.now().AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("entries = %+v, want only the change", entries)
+	}
+	e := entries[0]
+	if e.Actor != "ops@example.com" || e.Subject != "PUT /setting" || e.Before != "old" || e.After != "new" || e.Details["status"] != http.StatusOK {
+		t.Errorf("entry = %+v", e)
+	}
+}
+
+func TestHandlerFiltersEntries(t *testing.T) {
+	l := New(filepath.Join(t.TempDir(), "audit.jsonl"))
+	l.Record(Entry{Actor: "a", Action: "config.flag_changed"})
+	l.Record(Entry{Actor: "b", Action: "config.log_level_changed"})
+	l.Record(Entry{Actor: "a", Action: "transcript.accessed"})
+
+	for query, want := range map[string]int{
+		"":                        3,
+		"?action=config.":         2,
+		"?actor=a":                2,
+		"?action=config.&actor=a": 1,
+	} {
+		rec := httptest.NewRecorder()
+		l.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/audit"+query, nil))
+		var entries []Entry
+		json.NewDecoder(rec.Body).Decode(&entries)
+		if len(entries) != want {
+			t.Errorf("%q: %d entries, want %d", query, len(entries), want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit", nil)
+	if Actor(req) != "anonymous" {
+		t.Errorf("actor without credentials = %q", Actor(req))
+	}
+}
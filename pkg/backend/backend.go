@@ -0,0 +1,222 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Type is the kind of inference server behind a base URL
+type Type string
+
+// Known backend types. OpenAI covers any other OpenAI-compatible server.
+const (
+	LlamaCpp Type = "llama.cpp"
+	VLLM     Type = "vllm"
+	Ollama   Type = "ollama"
+	OpenAI   Type = "openai"
+)
+
+// ParseType reads a configured backend type. "auto" and "" return "", which
+// asks the resolver to detect it.
+func ParseType(s string) (Type, error) {
+	switch t := Type(strings.ToLower(strings.TrimSpace(s))); t {
+	case "", "auto":
+		return "", nil
+	case "llamacpp", "llama-cpp":
+		return LlamaCpp, nil
+	case LlamaCpp, VLLM, Ollama, OpenAI:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown backend type %q", s)
+	}
+}
+
+// Info describes a backend and what can be collected from it
+type Info struct {
+	Type      Type   `json:"type"`
+	BaseURL   string `json:"base_url"`
+	ServerURL string `json:"server_url"` // root of the server's native endpoints
+	Source    string `json:"source"`     // config, probe, url or default
+
+	// LlamaCppMetrics: serves /props and llamacpp:* metrics, and prompt
+	// evaluation can be timed from the first token
+	LlamaCppMetrics bool `json:"llamacpp_metrics"`
+	// PrometheusMetrics: serves vllm:* metrics on /metrics
+	PrometheusMetrics bool `json:"prometheus_metrics"`
+	// EvalTimings: responses carry eval_count and eval_duration
+	EvalTimings bool `json:"eval_timings"`
+	// NativeModelList: lists models on /api/tags instead of docker model ls
+	NativeModelList bool `json:"native_model_list"`
+	// ContextWindow is the server's default context size, 0 if it follows the model
+	ContextWindow int `json:"context_window,omitempty"`
+}
+
+// New describes a backend of type t at baseURL
+func New(t Type, baseURL, source string) Info {
+	info := Info{
+		Type:      t,
+		BaseURL:   baseURL,
+		ServerURL: ServerURL(baseURL),
+		Source:    source,
+	}
+	switch t {
+	case LlamaCpp:
+		info.LlamaCppMetrics = true
+		info.ContextWindow = 4096 // llama-server's default --ctx-size
+	case VLLM:
+		info.PrometheusMetrics = true
+	case Ollama:
+		info.EvalTimings = true
+		info.NativeModelList = true
+		info.ContextWindow = 2048 // Ollama's default num_ctx
+	}
+	return info
+}
+
+// ServerURL returns the server root for an OpenAI-compatible base URL
+func ServerURL(baseURL string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/v1")
+}
+
+// FromURL guesses the backend type from hints in the URL, such as Docker
+// Model Runner's engine path or Ollama's default port
+func FromURL(baseURL string) (Type, bool) {
+	lower := strings.ToLower(baseURL)
+	switch {
+	case strings.Contains(lower, "llama.cpp"), strings.Contains(lower, "llamacpp"), strings.Contains(lower, "llama-server"):
+		return LlamaCpp, true
+	case strings.Contains(lower, "vllm"):
+		return VLLM, true
+	case strings.Contains(lower, "ollama"), strings.Contains(lower, ":11434"):
+		return Ollama, true
+	}
+	return "", false
+}
+
+// Probe asks the server which backend it is, from the endpoints only that
+// backend serves. It reports false when nothing identified it.
+func Probe(ctx context.Context, client *http.Client, baseURL string) (Type, bool) {
+	root := ServerURL(baseURL)
+
+	if body, ok := get(ctx, client, root+"/props"); ok && json.Valid(body) {
+		return LlamaCpp, true
+	}
+	if body, ok := get(ctx, client, root+"/api/version"); ok {
+		var version struct {
+			Version string `json:"version"`
+		}
+		if json.Unmarshal(body, &version) == nil && version.Version != "" {
+			return Ollama, true
+		}
+	}
+	if body, ok := get(ctx, client, root+"/metrics"); ok {
+		switch text := string(body); {
+		case strings.Contains(text, "vllm:"):
+			return VLLM, true
+		case strings.Contains(text, "llamacpp:"):
+			return LlamaCpp, true
+		}
+	}
+	return "", false
+}
+
+// get fetches url, reporting whether it answered 200
+func get(ctx context.Context, client *http.Client, url string) ([]byte, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return body, err == nil
+}
+
+// Resolver decides the backend behind each base URL: a configured type
+// wins, then probing the server, then hints in the URL
+type Resolver struct {
+	override Type
+	client   *http.Client
+
+	mu       sync.Mutex
+	resolved map[string]Info
+}
+
+// Default is the process-wide resolver used by the chat handlers
+var Default = NewResolver("", nil)
+
+// NewResolver creates a resolver. A non-empty override skips detection.
+func NewResolver(override Type, client *http.Client) *Resolver {
+	if client == nil {
+		client = &http.Client{Timeout: 3 * time.Second}
+	}
+	return &Resolver{override: override, client: client, resolved: make(map[string]Info)}
+}
+
+// Resolve probes the server at baseURL and remembers the answer. An
+// unreachable server is described from its URL and probed again next time.
+func (r *Resolver) Resolve(ctx context.Context, baseURL string) Info {
+	if info, ok := r.cached(baseURL); ok {
+		return info
+	}
+	if r.override != "" {
+		return r.store(New(r.override, baseURL, "config"))
+	}
+	if t, ok := Probe(ctx, r.client, baseURL); ok {
+		return r.store(New(t, baseURL, "probe"))
+	}
+	return guess(baseURL)
+}
+
+// Lookup returns what is known about baseURL without contacting it, for
+// use on the request path
+func (r *Resolver) Lookup(baseURL string) Info {
+	if info, ok := r.cached(baseURL); ok {
+		return info
+	}
+	if r.override != "" {
+		return New(r.override, baseURL, "config")
+	}
+	return guess(baseURL)
+}
+
+func (r *Resolver) cached(baseURL string) (Info, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info, ok := r.resolved[baseURL]
+	return info, ok
+}
+
+func (r *Resolver) store(info Info) Info {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolved[info.BaseURL] = info
+	return info
+}
+
+// Reset forgets every resolved backend
+func (r *Resolver) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolved = make(map[string]Info)
+}
+
+// guess describes baseURL from its URL alone
+func guess(baseURL string) Info {
+	if t, ok := FromURL(baseURL); ok {
+		return New(t, baseURL, "url")
+	}
+	return New(OpenAI, baseURL, "default")
+}
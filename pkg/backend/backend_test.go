@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProbeIdentifiesServers(t *testing.T) {
+	servers := map[Type]http.HandlerFunc{
+		LlamaCpp: func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/props" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`{"default_generation_settings":{"n_ctx":4096}}`))
+		},
+		Ollama: func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/version" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`{"version":"0.6.8"}`))
+		},
+		VLLM: func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/metrics" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`vllm:num_requests_running{model_name="codellama/CodeLlama-7b-hf"} 0.0` + "\n"))
+		},
+	}
+	for want, handler := range servers {
+		server := httptest.NewServer(handler)
+		got, ok := Probe(context.Background(), server.Client(), server.URL+"/v1")
+		server.Close()
+		if !ok || got != want {
+			t.Errorf("Probe = %q, %v, want %q", got, ok, want)
+		}
+	}
+}
+
+func TestResolverPrefersProbeOverURLHints(t *testing.T) {
+	// A vLLM server whose URL mentions llama.cpp must not get llama.cpp metrics
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/llama.cpp/metrics" {
+			w.Write([]byte("vllm:num_requests_waiting 1.0\n"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	baseURL := server.URL + "/llama.cpp/v1"
+
+	resolver := NewResolver("", server.Client())
+	if info := resolver.Lookup(baseURL); info.Type != LlamaCpp || info.Source != "url" {
+		t.Errorf("Lookup before resolving = %+v, want a llama.cpp guess from the URL", info)
+	}
+	info := resolver.Resolve(context.Background(), baseURL)
+	if info.Type != VLLM || info.Source != "probe" || info.LlamaCppMetrics || !info.PrometheusMetrics {
+		t.Errorf("Resolve = %+v, want a probed vLLM backend", info)
+	}
+	if got := resolver.Lookup(baseURL); got != info {
+		t.Errorf("Lookup after resolving = %+v, want %+v", got, info)
+	}
+}
+
+func TestResolverFallsBackWhenUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	resolver := NewResolver("", server.Client())
+	if info := resolver.Resolve(context.Background(), server.URL+"/v1"); info.Type != OpenAI || info.Source != "default" {
+		t.Errorf("Resolve = %+v, want the generic OpenAI backend", info)
+	}
+
+	override := NewResolver(Ollama, server.Client())
+	if info := override.Resolve(context.Background(), server.URL+"/v1"); info.Type != Ollama || info.Source != "config" || !info.EvalTimings {
+		t.Errorf("Resolve with override = %+v, want the configured Ollama backend", info)
+	}
+}
+
+func TestParseType(t *testing.T) {
+	for input, want := range map[string]Type{"": "", "auto": "", "llamacpp": LlamaCpp, "vLLM": VLLM, "ollama": Ollama} {
+		if got, err := ParseType(input); err != nil || got != want {
+			t.Errorf("ParseType(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseType("tgi"); err == nil {
+		t.Error("expected an error for an unknown type")
+	}
+}
@@ -0,0 +1,263 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/jobs"
+	"github.com/ajeetraina/aiwatch/pkg/session"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxBodyBytes caps a batch request body
+const maxBodyBytes = 10 << 20
+
+// Config limits what a single batch may ask for
+type Config struct {
+	MaxItems       int // prompts accepted per batch
+	MaxConcurrency int // items generated at once per batch
+}
+
+// DefaultConfig returns the defaults used when nothing is configured
+func DefaultConfig() Config {
+	return Config{MaxItems: 1000, MaxConcurrency: 4}
+}
+
+// Metrics holds the collectors batches are recorded in
+type Metrics struct {
+	Items   *prometheus.CounterVec // labels: result
+	Latency prometheus.Histogram   // per item
+	Size    prometheus.Histogram   // items per batch
+}
+
+// Item is one finished prompt, written as an NDJSON line
+type Item struct {
+	Index          int     `json:"index"`
+	Status         string  `json:"status"` // "ok" or "error"
+	Response       string  `json:"response,omitempty"`
+	ConversationID string  `json:"conversation_id,omitempty"`
+	Truncated      string  `json:"truncated,omitempty"`
+	Error          string  `json:"error,omitempty"`
+	LatencyMs      float64 `json:"latency_ms"`
+}
+
+// Summary aggregates a batch, written as the final NDJSON line
+type Summary struct {
+	Total        int     `json:"total"`
+	Succeeded    int     `json:"succeeded"`
+	Failed       int     `json:"failed"`
+	Truncated    int     `json:"truncated"`
+	Concurrency  int     `json:"concurrency"`
+	DurationMs   float64 `json:"duration_ms"`
+	LatencyP50Ms float64 `json:"latency_p50_ms"`
+	LatencyP95Ms float64 `json:"latency_p95_ms"`
+	LatencyP99Ms float64 `json:"latency_p99_ms"`
+}
+
+// Handler serves POST /chat/batch
+type Handler struct {
+	config  Config
+	run     jobs.Runner
+	metrics Metrics
+}
+
+// New creates a batch handler generating each item with run
+func New(config Config, run jobs.Runner, metrics Metrics) *Handler {
+	defaults := DefaultConfig()
+	if config.MaxItems <= 0 {
+		config.MaxItems = defaults.MaxItems
+	}
+	if config.MaxConcurrency <= 0 {
+		config.MaxConcurrency = defaults.MaxConcurrency
+	}
+	return &Handler{config: config, run: run, metrics: metrics}
+}
+
+// decode splits a batch body into one chat request body per item. Items are
+// either "prompts" (strings sent as the message) or "items" (request
+// objects); every other field is shared by all items, which may override it.
+func decode(body io.Reader) (items [][]byte, concurrency int, err error) {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&fields); err != nil {
+		return nil, 0, errors.New("invalid request body")
+	}
+
+	var prompts []string
+	var objects []map[string]json.RawMessage
+	if raw, ok := fields["prompts"]; ok {
+		if err := json.Unmarshal(raw, &prompts); err != nil {
+			return nil, 0, errors.New("prompts must be an array of strings")
+		}
+	}
+	if raw, ok := fields["items"]; ok {
+		if err := json.Unmarshal(raw, &objects); err != nil {
+			return nil, 0, errors.New("items must be an array of objects")
+		}
+	}
+	if raw, ok := fields["concurrency"]; ok {
+		if err := json.Unmarshal(raw, &concurrency); err != nil {
+			return nil, 0, errors.New("concurrency must be an integer")
+		}
+	}
+	delete(fields, "prompts")
+	delete(fields, "items")
+	delete(fields, "concurrency")
+
+	for _, prompt := range prompts {
+		message, _ := json.Marshal(prompt)
+		objects = append(objects, map[string]json.RawMessage{"message": message})
+	}
+	for _, object := range objects {
+		merged := make(map[string]json.RawMessage, len(fields)+len(object))
+		for k, v := range fields {
+			merged[k] = v
+		}
+		for k, v := range object {
+			merged[k] = v
+		}
+		encoded, err := json.Marshal(merged)
+		if err != nil {
+			return nil, 0, err
+		}
+		items = append(items, encoded)
+	}
+	return items, concurrency, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, apierror.MethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	items, concurrency, err := decode(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		apierror.Write(w, r, apierror.InvalidRequest, err.Error())
+		return
+	}
+	if len(items) == 0 {
+		apierror.Write(w, r, apierror.InvalidRequest, "Batch needs prompts or items")
+		return
+	}
+	if len(items) > h.config.MaxItems {
+		apierror.Write(w, r, apierror.TooLarge, "Batch has too many items")
+		return
+	}
+	if concurrency <= 0 || concurrency > h.config.MaxConcurrency {
+		concurrency = h.config.MaxConcurrency
+	}
+
+	// Run the items as the same session, so they land in the caller's history
+	header := r.Header.Clone()
+	if id := session.FromContext(r.Context()); id != "" {
+		header.Set(session.HeaderName, id)
+	}
+
+	// A batch can take far longer than the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	h.metrics.Size.Observe(float64(len(items)))
+	summary := h.runAll(r.Context(), items, header, concurrency, json.NewEncoder(w), w)
+	json.NewEncoder(w).Encode(map[string]Summary{"summary": summary})
+}
+
+// runAll generates the items with bounded concurrency, writing each one as
+// it finishes
+func (h *Handler) runAll(ctx context.Context, items [][]byte, header http.Header, concurrency int, enc *json.Encoder, w http.ResponseWriter) Summary {
+	started := time.Now()
+	summary := Summary{Total: len(items), Concurrency: concurrency}
+	var latencies []time.Duration
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex // guards the encoder and summary
+	)
+	slots := make(chan struct{}, concurrency)
+	for i, body := range items {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(index int, body []byte) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			item := h.runOne(ctx, index, body, header)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if item.Status == "ok" {
+				summary.Succeeded++
+				latencies = append(latencies, time.Duration(item.LatencyMs*float64(time.Millisecond)))
+			} else {
+				summary.Failed++
+			}
+			if item.Truncated != "" {
+				summary.Truncated++
+			}
+			enc.Encode(item)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}(i, body)
+	}
+	wg.Wait()
+
+	// Items never started because the client went away count as failed
+	summary.Failed = summary.Total - summary.Succeeded
+	summary.DurationMs = float64(time.Since(started).Microseconds()) / 1000
+	summary.LatencyP50Ms = percentileMs(latencies, 50)
+	summary.LatencyP95Ms = percentileMs(latencies, 95)
+	summary.LatencyP99Ms = percentileMs(latencies, 99)
+	return summary
+}
+
+// runOne generates a single item and records its metrics
+func (h *Handler) runOne(ctx context.Context, index int, body []byte, header http.Header) Item {
+	start := time.Now()
+	result, err := h.run(ctx, jobs.Request{Body: body, Header: header.Clone()})
+	latency := time.Since(start)
+
+	item := Item{Index: index, LatencyMs: float64(latency.Microseconds()) / 1000}
+	if err != nil {
+		item.Status = "error"
+		item.Error = err.Error()
+		h.metrics.Items.WithLabelValues("error").Inc()
+		return item
+	}
+	item.Status = "ok"
+	item.Response = result.Response
+	item.ConversationID = result.ConversationID
+	item.Truncated = result.Truncated
+	h.metrics.Items.WithLabelValues("ok").Inc()
+	h.metrics.Latency.Observe(latency.Seconds())
+	return item
+}
+
+// percentileMs returns the nearest-rank percentile of durations in milliseconds
+func percentileMs(durations []time.Duration, p int) float64 {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return float64(sorted[rank-1].Microseconds()) / 1000
+}
@@ -0,0 +1,98 @@
+package batch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ajeetraina/aiwatch/pkg/jobs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBatchStreamsItemsAndSummary(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	run := func(ctx context.Context, req jobs.Request) (jobs.Result, error) {
+		n := inFlight.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		defer inFlight.Add(-1)
+
+		var body map[string]interface{}
+		json.Unmarshal(req.Body, &body)
+		if body["message"] == "fail" {
+			return jobs.Result{}, errors.New("upstream down")
+		}
+		return jobs.Result{Response: body["model"].(string) + ": " + body["message"].(string)}, nil
+	}
+
+	metrics := Metrics{
+		Items:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "items"}, []string{"result"}),
+		Latency: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency"}),
+		Size:    prometheus.NewHistogram(prometheus.HistogramOpts{Name: "size"}),
+	}
+	h := New(Config{MaxConcurrency: 2}, run, metrics)
+
+	body := `{"model":"ai/test","concurrency":8,"prompts":["a","b","fail"],"items":[{"message":"c","model":"ai/other"}]}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/chat/batch", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	responses := map[string]bool{}
+	var summary Summary
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var line struct {
+			Item
+			Summary *Summary `json:"summary"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("bad line %q: %v", scanner.Text(), err)
+		}
+		if line.Summary != nil {
+			summary = *line.Summary
+			continue
+		}
+		responses[line.Response] = line.Status == "ok"
+	}
+
+	for _, want := range []string{"ai/test: a", "ai/test: b", "ai/other: c"} {
+		if !responses[want] {
+			t.Errorf("missing response %q in %v", want, responses)
+		}
+	}
+	if summary.Total != 4 || summary.Succeeded != 3 || summary.Failed != 1 || summary.Concurrency != 2 {
+		t.Errorf("summary = %+v", summary)
+	}
+	if peak.Load() > 2 {
+		t.Errorf("peak concurrency = %d, want at most 2", peak.Load())
+	}
+	if got := testutil.ToFloat64(metrics.Items.WithLabelValues("error")); got != 1 {
+		t.Errorf("failed items = %v, want 1", got)
+	}
+}
+
+func TestBatchRejectsEmptyAndOversized(t *testing.T) {
+	run := func(ctx context.Context, req jobs.Request) (jobs.Result, error) { return jobs.Result{}, nil }
+	h := New(Config{MaxItems: 2}, run, Metrics{})
+
+	for body, want := range map[string]int{
+		`{"model":"ai/test"}`:       http.StatusBadRequest,
+		`{"prompts":"a"}`:           http.StatusBadRequest,
+		`{"prompts":["a","b","c"]}`: http.StatusRequestEntityTooLarge,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/chat/batch", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prompt is one line of the benchmark input file
+type Prompt struct {
+	Prompt    string `json:"prompt"`
+	System    string `json:"system,omitempty"`
+	MaxTokens int64  `json:"max_tokens,omitempty"`
+}
+
+// LoadPrompts reads JSONL prompts, skipping blank lines
+func LoadPrompts(r io.Reader) ([]Prompt, error) {
+	var prompts []Prompt
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var p Prompt
+		if err := json.Unmarshal([]byte(text), &p); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if p.Prompt == "" {
+			return nil, fmt.Errorf("line %d: prompt is empty", line)
+		}
+		prompts = append(prompts, p)
+	}
+	return prompts, scanner.Err()
+}
+
+// Config controls a benchmark run
+type Config struct {
+	Models      []string
+	Concurrency int
+	Repeat      int           // times each prompt is sent to each model
+	Timeout     time.Duration // per request
+}
+
+// Metrics holds the collectors benchmark samples are recorded in
+type Metrics struct {
+	FirstToken      *prometheus.HistogramVec // labels: model
+	Latency         *prometheus.HistogramVec // labels: model
+	TokensPerSecond *prometheus.HistogramVec // labels: model
+	Errors          *prometheus.CounterVec   // labels: model
+}
+
+// Sample is the measurement of a single request
+type Sample struct {
+	Model      string
+	Prompt     int
+	FirstToken time.Duration
+	Latency    time.Duration
+	Tokens     int
+	Err        error
+}
+
+// TokensPerSecond is the generation speed after the first token
+func (s Sample) TokensPerSecond() float64 {
+	generation := (s.Latency - s.FirstToken).Seconds()
+	if s.Tokens < 2 || generation <= 0 {
+		return 0
+	}
+	return float64(s.Tokens-1) / generation
+}
+
+// ModelReport aggregates the samples of one model
+type ModelReport struct {
+	Model              string  `json:"model"`
+	Requests           int     `json:"requests"`
+	Errors             int     `json:"errors"`
+	FirstTokenP50Ms    float64 `json:"ttft_p50_ms"`
+	FirstTokenP99Ms    float64 `json:"ttft_p99_ms"`
+	LatencyP50Ms       float64 `json:"latency_p50_ms"`
+	LatencyP99Ms       float64 `json:"latency_p99_ms"`
+	TokensPerSecondAvg float64 `json:"tokens_per_second_avg"`
+	TotalTokens        int     `json:"total_tokens"`
+}
+
+// Report is the result of a benchmark run
+type Report struct {
+	StartedAt   time.Time     `json:"started_at"`
+	DurationMs  float64       `json:"duration_ms"`
+	Concurrency int           `json:"concurrency"`
+	Models      []ModelReport `json:"models"`
+}
+
+// Run sends every prompt to every model Repeat times, at most Concurrency at
+// a time, and records each sample in metrics
+func Run(ctx context.Context, client *openai.Client, prompts []Prompt, cfg Config, metrics Metrics) Report {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.Repeat <= 0 {
+		cfg.Repeat = 1
+	}
+
+	type job struct {
+		model  string
+		prompt int
+	}
+	jobs := make(chan job)
+	samples := make(chan Sample)
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				samples <- measure(ctx, client, j.model, j.prompt, prompts[j.prompt], cfg.Timeout)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for r := 0; r < cfg.Repeat; r++ {
+			for i := range prompts {
+				for _, model := range cfg.Models {
+					select {
+					case jobs <- job{model: model, prompt: i}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(samples)
+	}()
+
+	started := time.Now()
+	byModel := make(map[string][]Sample)
+	for s := range samples {
+		byModel[s.Model] = append(byModel[s.Model], s)
+		record(metrics, s)
+	}
+
+	report := Report{
+		StartedAt:   started.UTC(),
+		DurationMs:  float64(time.Since(started).Milliseconds()),
+		Concurrency: cfg.Concurrency,
+	}
+	for _, model := range cfg.Models {
+		report.Models = append(report.Models, Summarize(model, byModel[model]))
+	}
+	return report
+}
+
+// measure sends one benchmark prompt
+func measure(ctx context.Context, client *openai.Client, model string, index int, prompt Prompt, timeout time.Duration) Sample {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var messages []openai.ChatCompletionMessageParamUnion
+	if prompt.System != "" {
+		messages = append(messages, openai.SystemMessage(prompt.System))
+	}
+	messages = append(messages, openai.UserMessage(prompt.Prompt))
+	params := openai.ChatCompletionNewParams{
+		Model:    openai.F(model),
+		Messages: openai.F(messages),
+	}
+	if prompt.MaxTokens > 0 {
+		params.MaxTokens = openai.F(prompt.MaxTokens)
+	}
+
+	sample := Measure(ctx, client, params)
+	sample.Prompt = index
+	return sample
+}
+
+// Measure streams one completion, timing the first token and the whole response
+func Measure(ctx context.Context, client *openai.Client, params openai.ChatCompletionNewParams) Sample {
+	sample := Sample{Model: params.Model.Value}
+	start := time.Now()
+	stream := client.Chat.Completions.NewStreaming(ctx, params)
+	defer stream.Close()
+	for stream.Next() {
+		chunk := stream.Current()
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		if sample.Tokens == 0 {
+			sample.FirstToken = time.Since(start)
+		}
+		sample.Tokens++
+	}
+	sample.Latency = time.Since(start)
+	sample.Err = stream.Err()
+	return sample
+}
+
+func record(metrics Metrics, s Sample) {
+	if s.Err != nil {
+		metrics.Errors.WithLabelValues(s.Model).Inc()
+		return
+	}
+	metrics.Latency.WithLabelValues(s.Model).Observe(s.Latency.Seconds())
+	if s.Tokens > 0 {
+		metrics.FirstToken.WithLabelValues(s.Model).Observe(s.FirstToken.Seconds())
+	}
+	if tps := s.TokensPerSecond(); tps > 0 {
+		metrics.TokensPerSecond.WithLabelValues(s.Model).Observe(tps)
+	}
+}
+
+// Summarize aggregates the samples of one model
+func Summarize(model string, samples []Sample) ModelReport {
+	report := ModelReport{Model: model, Requests: len(samples)}
+
+	var firstTokens, latencies []time.Duration
+	var tpsTotal float64
+	tpsCount := 0
+	for _, s := range samples {
+		if s.Err != nil {
+			report.Errors++
+			continue
+		}
+		latencies = append(latencies, s.Latency)
+		if s.Tokens > 0 {
+			firstTokens = append(firstTokens, s.FirstToken)
+		}
+		if tps := s.TokensPerSecond(); tps > 0 {
+			tpsTotal += tps
+			tpsCount++
+		}
+		report.TotalTokens += s.Tokens
+	}
+
+	report.FirstTokenP50Ms = percentileMs(firstTokens, 50)
+	report.FirstTokenP99Ms = percentileMs(firstTokens, 99)
+	report.LatencyP50Ms = percentileMs(latencies, 50)
+	report.LatencyP99Ms = percentileMs(latencies, 99)
+	if tpsCount > 0 {
+		report.TokensPerSecondAvg = tpsTotal / float64(tpsCount)
+	}
+	return report
+}
+
+// percentileMs returns the nearest-rank percentile of durations in milliseconds
+func percentileMs(durations []time.Duration, p int) float64 {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return float64(sorted[rank-1].Microseconds()) / 1000
+}
+
+// WriteJSON writes the report as indented JSON
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes one row per model
+func (r Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"model", "requests", "errors", "ttft_p50_ms", "ttft_p99_ms", "latency_p50_ms", "latency_p99_ms", "tokens_per_second_avg", "total_tokens"})
+	for _, m := range r.Models {
+		cw.Write([]string{
+			m.Model,
+			strconv.Itoa(m.Requests),
+			strconv.Itoa(m.Errors),
+			formatFloat(m.FirstTokenP50Ms),
+			formatFloat(m.FirstTokenP99Ms),
+			formatFloat(m.LatencyP50Ms),
+			formatFloat(m.LatencyP99Ms),
+			formatFloat(m.TokensPerSecondAvg),
+			strconv.Itoa(m.TotalTokens),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
@@ -0,0 +1,61 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ajeetraina/aiwatch/internal/testsupport"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRun(t *testing.T) {
+	backend := testsupport.NewFakeBackend("one", " two", " three")
+	defer backend.Close()
+	client := openai.NewClient(option.WithBaseURL(backend.BaseURL()), option.WithAPIKey("test"))
+
+	prompts, err := LoadPrompts(strings.NewReader("{\"prompt\": \"hi\"}\n\n{\"prompt\": \"bye\", \"max_tokens\": 8}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := Run(context.Background(), client, prompts, Config{
+		Models:      []string{"ai/a", "ai/b"},
+		Concurrency: 3,
+		Repeat:      2,
+	}, Metrics{
+		FirstToken:      prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "ttft"}, []string{"model"}),
+		Latency:         prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"model"}),
+		TokensPerSecond: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "tps"}, []string{"model"}),
+		Errors:          prometheus.NewCounterVec(prometheus.CounterOpts{Name: "errors"}, []string{"model"}),
+	})
+
+	if len(backend.Requests()) != 8 {
+		t.Errorf("expected 8 backend requests, got %d", len(backend.Requests()))
+	}
+	if len(report.Models) != 2 {
+		t.Fatalf("expected a report per model, got %+v", report.Models)
+	}
+	for _, m := range report.Models {
+		if m.Requests != 4 || m.Errors != 0 || m.TotalTokens != 12 {
+			t.Errorf("unexpected report for %s: %+v", m.Model, m)
+		}
+	}
+
+	var csv bytes.Buffer
+	if err := report.WriteCSV(&csv); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(csv.String(), "\n"); lines != 3 {
+		t.Errorf("expected header and 2 rows, got:\n%s", csv.String())
+	}
+}
+
+func TestLoadPromptsRejectsEmptyPrompt(t *testing.T) {
+	if _, err := LoadPrompts(strings.NewReader(`{"system": "no prompt"}`)); err == nil {
+		t.Error("expected an error for a line without a prompt")
+	}
+}
@@ -0,0 +1,281 @@
+package billing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/quota"
+)
+
+// dayFormat is the layout of Row.Day; days are UTC
+const dayFormat = "2006-01-02"
+
+// Config maps API keys to the teams they are billed to
+type Config struct {
+	Teams       map[string]string `json:"teams"` // API key: team
+	DefaultTeam string            `json:"default_team"`
+	// RetentionDays is how long daily usage is kept
+	RetentionDays int `json:"retention_days"`
+}
+
+// DefaultConfig bills every key to "unassigned" and keeps usage for
+// thirteen months
+func DefaultConfig() Config {
+	return Config{Teams: map[string]string{}, DefaultTeam: "unassigned", RetentionDays: 400}
+}
+
+// LoadConfig reads a JSON billing config, if path is set, on top of the
+// defaults
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read billing config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse billing config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Row is the token usage of one group, e.g. one key on one day
+type Row struct {
+	Day              string `json:"day,omitempty"`
+	Team             string `json:"team,omitempty"`
+	Key              string `json:"key,omitempty"` // quota.Digest of the API key, or "anonymous"
+	Model            string `json:"model,omitempty"`
+	Requests         int    `json:"requests"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+}
+
+func (r *Row) add(other Row) {
+	r.Requests += other.Requests
+	r.PromptTokens += other.PromptTokens
+	r.CompletionTokens += other.CompletionTokens
+	r.TotalTokens += other.TotalTokens
+}
+
+// Grouping dimensions for Query
+const (
+	ByDay   = "day"
+	ByTeam  = "team"
+	ByKey   = "key"
+	ByModel = "model"
+)
+
+// entry identifies a ledger row
+type entry struct {
+	day, key, model string
+}
+
+// Ledger aggregates token usage per key, model and day
+type Ledger struct {
+	config Config
+	now    func() time.Time
+
+	mu    sync.Mutex
+	rows  map[entry]*Row
+	dirty map[string]bool // days changed since the last export
+}
+
+// NewLedger creates an empty ledger
+func NewLedger(config Config) *Ledger {
+	if config.DefaultTeam == "" {
+		config.DefaultTeam = DefaultConfig().DefaultTeam
+	}
+	return &Ledger{
+		config: config,
+		now:    time.Now,
+		rows:   make(map[entry]*Row),
+		dirty:  make(map[string]bool),
+	}
+}
+
+// Record adds one request's tokens for apiKey, as returned by quota.APIKey,
+// or for "anonymous" if it is empty. Keys are stored as their quota.Digest.
+func (l *Ledger) Record(apiKey, model string, promptTokens, completionTokens int) {
+	team, ok := l.config.Teams[apiKey]
+	if !ok || apiKey == "" {
+		team = l.config.DefaultTeam
+	}
+	key := "anonymous"
+	if apiKey != "" {
+		key = quota.Digest(apiKey)
+	}
+	day := l.now().UTC().Format(dayFormat)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	id := entry{day: day, key: key, model: model}
+	row, ok := l.rows[id]
+	if !ok {
+		row = &Row{Day: day, Team: team, Key: key, Model: model}
+		l.rows[id] = row
+	}
+	row.add(Row{
+		Requests:         1,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	})
+	l.dirty[day] = true
+}
+
+// Query sums usage between from and to, inclusive days in YYYY-MM-DD form
+// (empty for no bound), grouped by the given dimensions. Rows are sorted.
+func (l *Ledger) Query(from, to string, groupBy []string) []Row {
+	group := make(map[string]bool, len(groupBy))
+	for _, dimension := range groupBy {
+		group[dimension] = true
+	}
+
+	l.mu.Lock()
+	totals := make(map[Row]*Row)
+	for _, row := range l.rows {
+		if (from != "" && row.Day < from) || (to != "" && row.Day > to) {
+			continue
+		}
+		var id Row
+		if group[ByDay] {
+			id.Day = row.Day
+		}
+		if group[ByTeam] {
+			id.Team = row.Team
+		}
+		if group[ByKey] {
+			id.Key = row.Key
+		}
+		if group[ByModel] {
+			id.Model = row.Model
+		}
+		total, ok := totals[id]
+		if !ok {
+			total = &Row{Day: id.Day, Team: id.Team, Key: id.Key, Model: id.Model}
+			totals[id] = total
+		}
+		total.add(*row)
+	}
+	l.mu.Unlock()
+
+	result := make([]Row, 0, len(totals))
+	for _, total := range totals {
+		result = append(result, *total)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Team != b.Team {
+			return a.Team < b.Team
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Model < b.Model
+	})
+	return result
+}
+
+// takeDirty returns the days changed since the last call, oldest first
+func (l *Ledger) takeDirty() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	days := make([]string, 0, len(l.dirty))
+	for day := range l.dirty {
+		days = append(days, day)
+	}
+	l.dirty = make(map[string]bool)
+	sort.Strings(days)
+	return days
+}
+
+// markDirty queues day for the next export again
+func (l *Ledger) markDirty(day string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dirty[day] = true
+}
+
+// Erase drops every row of key, as stored by Record, and queues the days
+// it had usage on for export again. It returns how many rows were dropped.
+func (l *Ledger) Erase(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for id := range l.rows {
+		if id.key == key {
+			delete(l.rows, id)
+			l.dirty[id.day] = true
+			n++
+		}
+	}
+	return n
+}
+
+// prune drops days older than the retention period
+func (l *Ledger) prune() {
+	if l.config.RetentionDays <= 0 {
+		return
+	}
+	cutoff := l.now().UTC().AddDate(0, 0, -l.config.RetentionDays).Format(dayFormat)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id := range l.rows {
+		if id.day < cutoff {
+			delete(l.rows, id)
+		}
+	}
+}
+
+// Save writes every row to path, so usage survives restarts
+func (l *Ledger) Save(path string) error {
+	rows := l.Query("", "", []string{ByDay, ByTeam, ByKey, ByModel})
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save billing ledger: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load reads rows saved by Save. A missing file is not an error.
+func (l *Ledger) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read billing ledger: %w", err)
+	}
+	var rows []Row
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return fmt.Errorf("failed to parse billing ledger: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, row := range rows {
+		id := entry{day: row.Day, key: row.Key, model: row.Model}
+		if existing, ok := l.rows[id]; ok {
+			existing.add(row)
+			continue
+		}
+		row := row
+		l.rows[id] = &row
+	}
+	return nil
+}
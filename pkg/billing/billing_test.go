@@ -0,0 +1,135 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/objectstore"
+	"github.com/ajeetraina/aiwatch/pkg/quota"
+)
+
+func testLedger(day string) *Ledger {
+	l := NewLedger(Config{Teams: map[string]string{"sk-alpha-1234567": "search"}, DefaultTeam: "unassigned"})
+	l.now = func() time.Time {
+		t, _ := time.Parse(dayFormat, day)
+		return t.Add(12 * time.Hour)
+	}
+	return l
+}
+
+func TestLedgerGroupsUsage(t *testing.T) {
+	l := testLedger("2026-10-01")
+	l.Record("sk-alpha-1234567", "ai/llama3.2", 100, 20)
+	l.Record("sk-alpha-1234567", "ai/qwen3", 50, 10)
+	l.Record("", "ai/llama3.2", 5, 5)
+	l.Record("", "ai/llama3.2", 5, 5)
+	l.now = func() time.Time { return time.Date(2026, 10, 2, 9, 0, 0, 0, time.UTC) }
+	l.Record("sk-alpha-1234567", "ai/llama3.2", 1, 1)
+
+	byTeam := l.Query("2026-10-01", "2026-10-31", []string{ByTeam})
+	if len(byTeam) != 2 || byTeam[0].Team != "search" || byTeam[0].TotalTokens != 182 || byTeam[0].Requests != 3 {
+		t.Errorf("by team = %+v", byTeam)
+	}
+	if byTeam[1].Team != "unassigned" || byTeam[1].TotalTokens != 20 {
+		t.Errorf("unassigned = %+v", byTeam[1])
+	}
+
+	byKey := l.Query("2026-10-01", "2026-10-01", []string{ByKey})
+	if len(byKey) != 2 || byKey[0].Key != "anonymous" || byKey[0].Requests != 2 {
+		t.Errorf("by key = %+v", byKey)
+	}
+	if byKey[1].Key != quota.Digest("sk-alpha-1234567") {
+		t.Errorf("expected the key stored as its digest, got %q", byKey[1].Key)
+	}
+}
+
+func TestLedgerErase(t *testing.T) {
+	l := testLedger("2026-10-01")
+	l.Record("sk-beta-7654321", "ai/llama3.2", 10, 5)
+	l.Record("sk-alpha-1234567", "ai/llama3.2", 10, 5)
+	l.now = func() time.Time { return time.Date(2026, 10, 2, 9, 0, 0, 0, time.UTC) }
+	l.Record("sk-beta-7654321", "ai/qwen3", 10, 5)
+	l.takeDirty()
+
+	if n := l.Erase(quota.Digest("sk-beta-7654321")); n != 2 {
+		t.Errorf("erased %d rows, want 2", n)
+	}
+	if rows := l.Query("", "", []string{ByKey}); len(rows) != 1 || rows[0].Key != quota.Digest("sk-alpha-1234567") {
+		t.Errorf("remaining rows %+v", rows)
+	}
+	if days := l.takeDirty(); len(days) != 2 {
+		t.Errorf("expected both days queued for export again, got %v", days)
+	}
+}
+
+func TestExporterWritesChangedDays(t *testing.T) {
+	dir := t.TempDir()
+	ledgerPath := filepath.Join(dir, "ledger.json")
+	l := testLedger("2026-10-17")
+	l.Record("sk-alpha-1234567", "m", 10, 5)
+
+	exporter := NewExporter(l, &objectstore.DirStore{Dir: filepath.Join(dir, "reports")}, ledgerPath, ExportMetrics{})
+	if err := exporter.Export(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"usage-2026-10-17.csv", "usage-2026-10-17.json", "usage-2026-10.csv", "usage-2026-10.json"} {
+		if _, err := os.Stat(filepath.Join(dir, "reports", name)); err != nil {
+			t.Errorf("missing report %s: %v", name, err)
+		}
+	}
+	monthly, _ := os.ReadFile(filepath.Join(dir, "reports", "usage-2026-10.csv"))
+	if lines := strings.Split(strings.TrimSpace(string(monthly)), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], ",search,") || !strings.HasSuffix(lines[1], ",1,10,5,15") {
+		t.Errorf("monthly report:\n%s", monthly)
+	}
+	if days := l.takeDirty(); len(days) != 0 {
+		t.Errorf("days still dirty after export: %v", days)
+	}
+
+	// The saved ledger carries usage over a restart
+	restored := testLedger("2026-10-17")
+	if err := restored.Load(ledgerPath); err != nil {
+		t.Fatal(err)
+	}
+	if rows := restored.Query("", "", []string{ByTeam}); len(rows) != 1 || rows[0].TotalTokens != 15 {
+		t.Errorf("restored = %+v", rows)
+	}
+}
+
+func TestHandlerReportsUsage(t *testing.T) {
+	l := testLedger("2026-10-17")
+	l.Record("sk-alpha-1234567", "m", 10, 5)
+	handler := l.Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/billing/usage?group_by=team,model", nil))
+	var report struct {
+		From  string `json:"from"`
+		To    string `json:"to"`
+		Usage []Row  `json:"usage"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.From != "2026-10-01" || len(report.Usage) != 1 || report.Usage[0].Model != "m" || report.Usage[0].Team != "search" {
+		t.Errorf("report = %+v", report)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/billing/usage?month=2026-09&format=csv", nil))
+	if rec.Header().Get("Content-Type") != "text/csv" || strings.Count(rec.Body.String(), "\n") != 1 {
+		t.Errorf("csv for an empty month: %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/billing/usage?group_by=region", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown group_by status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,131 @@
+package billing
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/objectstore"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reportColumns is the CSV header, in Row field order
+var reportColumns = []string{"day", "team", "key", "model", "requests", "prompt_tokens", "completion_tokens", "total_tokens"}
+
+// EncodeCSV writes rows as CSV with a header line
+func EncodeCSV(rows []Row) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(reportColumns)
+	for _, row := range rows {
+		w.Write([]string{
+			row.Day, row.Team, row.Key, row.Model,
+			strconv.Itoa(row.Requests),
+			strconv.Itoa(row.PromptTokens),
+			strconv.Itoa(row.CompletionTokens),
+			strconv.Itoa(row.TotalTokens),
+		})
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// EncodeJSON writes rows as a JSON array
+func EncodeJSON(rows []Row) []byte {
+	data, _ := json.MarshalIndent(rows, "", "  ")
+	return data
+}
+
+// ExportMetrics holds the collectors the exporter reports to
+type ExportMetrics struct {
+	Exports *prometheus.CounterVec // labels: store, result (success or failure)
+}
+
+// Exporter periodically writes the reports for the days that changed:
+// usage-YYYY-MM-DD per key and model, and usage-YYYY-MM per team, key and
+// model for the month to date, each as .csv and .json
+type Exporter struct {
+	ledger     *Ledger
+	store      objectstore.Store
+	ledgerPath string
+	metrics    ExportMetrics
+}
+
+// NewExporter creates an exporter writing to store. A non-empty ledgerPath
+// also saves the ledger on every run.
+func NewExporter(ledger *Ledger, store objectstore.Store, ledgerPath string, metrics ExportMetrics) *Exporter {
+	return &Exporter{ledger: ledger, store: store, ledgerPath: ledgerPath, metrics: metrics}
+}
+
+// Export writes the reports for every day changed since the last export
+func (e *Exporter) Export(ctx context.Context) error {
+	log := logger.GetLogger()
+	e.ledger.prune()
+	if e.ledgerPath != "" {
+		if err := e.ledger.Save(e.ledgerPath); err != nil {
+			log.Warn().Err(err).Msg("Failed to save billing ledger")
+		}
+	}
+
+	days := e.ledger.takeDirty()
+	months := make(map[string]bool)
+	var failed error
+	for _, day := range days {
+		rows := e.ledger.Query(day, day, []string{ByDay, ByTeam, ByKey, ByModel})
+		if err := e.put(ctx, "usage-"+day, rows); err != nil {
+			failed = err
+			e.ledger.markDirty(day)
+		}
+		months[day[:7]] = true
+	}
+	for month := range months {
+		rows := e.ledger.Query(month+"-01", month+"-31", []string{ByTeam, ByKey, ByModel})
+		if err := e.put(ctx, "usage-"+month, rows); err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// put writes one report in both formats
+func (e *Exporter) put(ctx context.Context, name string, rows []Row) error {
+	for _, report := range []struct {
+		ext  string
+		data []byte
+	}{{".csv", EncodeCSV(rows)}, {".json", EncodeJSON(rows)}} {
+		if err := e.store.Put(ctx, name+report.ext, report.data); err != nil {
+			e.count("failure")
+			log := logger.GetLogger()
+			log.Warn().Err(err).Str("store", e.store.Name()).Str("report", name+report.ext).Msg("Billing export failed")
+			return err
+		}
+	}
+	e.count("success")
+	return nil
+}
+
+func (e *Exporter) count(result string) {
+	if e.metrics.Exports != nil {
+		e.metrics.Exports.WithLabelValues(e.store.Name(), result).Inc()
+	}
+}
+
+// Start exports on an interval until ctx is cancelled
+func (e *Exporter) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.Export(ctx)
+			}
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+package billing
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+)
+
+// Handler serves GET /billing/usage. Query parameters:
+//
+//	month     YYYY-MM, the default range; the current month if nothing is set
+//	from, to  YYYY-MM-DD, inclusive, instead of month
+//	group_by  comma-separated day, team, key and model (default team)
+//	format    json (default) or csv
+func (l *Ledger) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /billing/usage", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		from, to := query.Get("from"), query.Get("to")
+		for _, day := range []string{from, to} {
+			if _, err := time.Parse(dayFormat, day); day != "" && err != nil {
+				apierror.Write(w, r, apierror.InvalidRequest, "from and to must be dates in YYYY-MM-DD form")
+				return
+			}
+		}
+		if from == "" && to == "" {
+			month := query.Get("month")
+			if month == "" {
+				month = l.now().UTC().Format("2006-01")
+			} else if _, err := time.Parse("2006-01", month); err != nil {
+				apierror.Write(w, r, apierror.InvalidRequest, "month must be in YYYY-MM form")
+				return
+			}
+			from, to = month+"-01", month+"-31"
+		}
+
+		groupBy := []string{ByTeam}
+		if value := query.Get("group_by"); value != "" {
+			groupBy = strings.Split(value, ",")
+			for i, dimension := range groupBy {
+				groupBy[i] = strings.TrimSpace(dimension)
+				switch groupBy[i] {
+				case ByDay, ByTeam, ByKey, ByModel:
+				default:
+					apierror.Write(w, r, apierror.InvalidRequest, "group_by takes day, team, key and model")
+					return
+				}
+			}
+		}
+
+		rows := l.Query(from, to, groupBy)
+		switch query.Get("format") {
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="usage-`+from+`-to-`+to+`.csv"`)
+			w.Write(EncodeCSV(rows))
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"from":     from,
+				"to":       to,
+				"group_by": groupBy,
+				"usage":    rows,
+			})
+		default:
+			apierror.Write(w, r, apierror.InvalidRequest, "format must be json or csv")
+		}
+	})
+	return mux
+}
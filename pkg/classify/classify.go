@@ -0,0 +1,300 @@
+package classify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/openai/openai-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of category: what a chat is about, and whether it raises a safety concern
+const (
+	Topic  = "topic"
+	Safety = "safety"
+)
+
+// Uncategorized is reported for chats matching no topic, so the topic
+// distribution adds up to every chat classified
+const Uncategorized = "uncategorized"
+
+// Category is one entry of the taxonomy
+type Category struct {
+	Name        string   `json:"name"`
+	Kind        string   `json:"kind"` // topic or safety
+	Description string   `json:"description,omitempty"`
+	Keywords    []string `json:"keywords"`
+}
+
+// DefaultTaxonomy is a small general-purpose set of topics and safety categories
+func DefaultTaxonomy() []Category {
+	return []Category{
+		{Name: "coding", Kind: Topic, Description: "programming, software and debugging", Keywords: []string{"code", "function", "bug", "error", "compile", "python", "golang", "javascript", "sql", "api", "docker", "kubernetes"}},
+		{Name: "writing", Kind: Topic, Description: "drafting, editing and summarising text", Keywords: []string{"write", "essay", "email", "letter", "rewrite", "summarize", "summarise", "proofread", "poem", "story"}},
+		{Name: "math", Kind: Topic, Description: "arithmetic, algebra and statistics", Keywords: []string{"calculate", "equation", "math", "algebra", "integral", "probability", "statistics"}},
+		{Name: "health", Kind: Topic, Description: "medical and wellbeing questions", Keywords: []string{"symptom", "doctor", "medicine", "diet", "exercise", "pain", "sleep"}},
+		{Name: "finance", Kind: Topic, Description: "money, investing and taxes", Keywords: []string{"invest", "stock", "tax", "budget", "loan", "mortgage", "salary"}},
+		{Name: "travel", Kind: Topic, Description: "trips, places and transport", Keywords: []string{"travel", "flight", "hotel", "trip", "visa", "itinerary"}},
+		{Name: "profanity", Kind: Safety, Description: "swearing and obscene language", Keywords: []string{"fuck", "shit", "bitch", "asshole", "bastard"}},
+		{Name: "violence", Kind: Safety, Description: "threats, weapons and harming others", Keywords: []string{"kill", "weapon", "bomb", "shoot", "attack"}},
+		{Name: "self_harm", Kind: Safety, Description: "suicide and self-injury", Keywords: []string{"suicide", "self-harm", "kill myself", "hurt myself"}},
+	}
+}
+
+// LoadTaxonomy reads the taxonomy from a JSON file, or returns the default
+// one if path is empty
+func LoadTaxonomy(path string) ([]Category, error) {
+	if path == "" {
+		return DefaultTaxonomy(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read taxonomy: %w", err)
+	}
+	var taxonomy []Category
+	if err := json.Unmarshal(data, &taxonomy); err != nil {
+		return nil, fmt.Errorf("failed to parse taxonomy: %w", err)
+	}
+	seen := make(map[string]bool)
+	for _, c := range taxonomy {
+		if c.Name == "" || seen[c.Name] {
+			return nil, fmt.Errorf("taxonomy category names must be unique and non-empty, got %q", c.Name)
+		}
+		if c.Kind != Topic && c.Kind != Safety {
+			return nil, fmt.Errorf("category %q has kind %q, want topic or safety", c.Name, c.Kind)
+		}
+		seen[c.Name] = true
+	}
+	return taxonomy, nil
+}
+
+// Job is a completed chat exchange to classify
+type Job struct {
+	ConversationID string
+	Model          string
+	Prompt         string
+	Response       string
+}
+
+// Metrics holds the collectors the classifier reports to
+type Metrics struct {
+	Categories      *prometheus.CounterVec // labels: kind, category
+	Classifications *prometheus.CounterVec // labels: result (ok|error|dropped)
+}
+
+// Classifier asynchronously sorts completed chats into the taxonomy's
+// categories, by keyword or by asking a small model
+type Classifier struct {
+	taxonomy []Category
+	patterns map[string]*regexp.Regexp
+	client   *openai.Client
+	model    string // empty classifies by keyword
+	metrics  Metrics
+	onResult func(Job, []string)
+
+	queue chan Job
+	wg    sync.WaitGroup
+}
+
+// New creates a classifier. With a client and model, the model picks the
+// categories; otherwise keywords do. onResult, if set, is called with the
+// categories of every classified chat.
+func New(taxonomy []Category, client *openai.Client, model string, metrics Metrics, onResult func(Job, []string)) *Classifier {
+	c := &Classifier{
+		taxonomy: taxonomy,
+		patterns: make(map[string]*regexp.Regexp),
+		client:   client,
+		model:    model,
+		metrics:  metrics,
+		onResult: onResult,
+		queue:    make(chan Job, 100),
+	}
+	for _, category := range taxonomy {
+		if len(category.Keywords) == 0 {
+			continue
+		}
+		quoted := make([]string, len(category.Keywords))
+		for i, keyword := range category.Keywords {
+			quoted[i] = regexp.QuoteMeta(strings.ToLower(keyword))
+		}
+		// Whole words, allowing plurals and other simple suffixes
+		c.patterns[category.Name] = regexp.MustCompile(`\b(?:` + strings.Join(quoted, "|") + `)(?:s|es|ed|ing)?\b`)
+	}
+	return c
+}
+
+// Start launches workers that classify queued chats until ctx is cancelled
+func (c *Classifier) Start(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job, ok := <-c.queue:
+					if !ok {
+						return
+					}
+					c.classify(ctx, job)
+				}
+			}
+		}()
+	}
+}
+
+// Submit queues a completed chat for classification. It never blocks; chats
+// arriving while the queue is full are dropped.
+func (c *Classifier) Submit(job Job) {
+	select {
+	case c.queue <- job:
+	default:
+		c.count("dropped")
+	}
+}
+
+// Close stops accepting jobs and waits for queued chats to be classified
+func (c *Classifier) Close() {
+	close(c.queue)
+	c.wg.Wait()
+}
+
+func (c *Classifier) classify(ctx context.Context, job Job) {
+	categories := []string(nil)
+	if c.model == "" {
+		categories = c.Keywords(job.Prompt, job.Response)
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		var err error
+		categories, err = c.Ask(ctx, job.Prompt, job.Response)
+		if err != nil {
+			c.count("error")
+			log := logger.GetLogger()
+			log.Warn().Err(err).Str("conversation_id", job.ConversationID).Msg("Failed to classify chat")
+			return
+		}
+	}
+
+	c.count("ok")
+	if c.metrics.Categories != nil {
+		topics := 0
+		for _, name := range categories {
+			kind := c.kind(name)
+			if kind == Topic {
+				topics++
+			}
+			c.metrics.Categories.WithLabelValues(kind, name).Inc()
+		}
+		if topics == 0 {
+			c.metrics.Categories.WithLabelValues(Topic, Uncategorized).Inc()
+		}
+	}
+	if c.onResult != nil {
+		c.onResult(job, categories)
+	}
+}
+
+// Keywords returns the categories whose keywords appear in the chat. Topics
+// are what the user asked about, so only the prompt counts for them; safety
+// categories apply to either side.
+func (c *Classifier) Keywords(prompt, response string) []string {
+	prompt, response = strings.ToLower(prompt), strings.ToLower(response)
+	var categories []string
+	for _, category := range c.taxonomy {
+		pattern, ok := c.patterns[category.Name]
+		if !ok {
+			continue
+		}
+		if pattern.MatchString(prompt) || (category.Kind == Safety && pattern.MatchString(response)) {
+			categories = append(categories, category.Name)
+		}
+	}
+	return categories
+}
+
+// Ask has the classifier model pick the chat's categories
+func (c *Classifier) Ask(ctx context.Context, prompt, response string) ([]string, error) {
+	var list strings.Builder
+	for _, category := range c.taxonomy {
+		fmt.Fprintf(&list, "- %s (%s): %s\n", category.Name, category.Kind, category.Description)
+	}
+	instructions := "Classify the conversation below into the categories that apply, from this list:\n" + list.String() +
+		"\nReply with only a JSON array of category names, for example [\"coding\"]. Reply [] if none apply."
+
+	completion, err := c.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+		Model: openai.F(c.model),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(instructions),
+			openai.UserMessage(fmt.Sprintf("User:\n%s\n\nAssistant:\n%s", prompt, response)),
+		}),
+		Temperature: openai.F(0.0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(completion.Choices) == 0 {
+		return nil, errors.New("classifier returned no choices")
+	}
+	return c.ParseReply(completion.Choices[0].Message.Content)
+}
+
+// ParseReply extracts the category names from the model's reply, tolerating
+// surrounding prose or code fences and dropping names not in the taxonomy
+func (c *Classifier) ParseReply(reply string) ([]string, error) {
+	start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON array in classifier reply %q", reply)
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &names); err != nil {
+		return nil, fmt.Errorf("invalid classifier reply: %w", err)
+	}
+	var categories []string
+	for _, name := range names {
+		for _, category := range c.taxonomy {
+			if strings.EqualFold(category.Name, strings.TrimSpace(name)) && !slices.Contains(categories, category.Name) {
+				categories = append(categories, category.Name)
+			}
+		}
+	}
+	return categories, nil
+}
+
+// kind returns the kind of the named category, or "" if it is unknown
+func (c *Classifier) kind(name string) string {
+	for _, category := range c.taxonomy {
+		if category.Name == name {
+			return category.Kind
+		}
+	}
+	return ""
+}
+
+// Merge adds categories to existing, keeping it sorted and free of duplicates
+func Merge(existing, categories []string) []string {
+	merged := append([]string(nil), existing...)
+	for _, name := range categories {
+		if !slices.Contains(merged, name) {
+			merged = append(merged, name)
+		}
+	}
+	slices.Sort(merged)
+	return merged
+}
+
+func (c *Classifier) count(result string) {
+	if c.metrics.Classifications != nil {
+		c.metrics.Classifications.WithLabelValues(result).Inc()
+	}
+}
@@ -0,0 +1,66 @@
+package classify
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestKeywordsMatchWholeWords(t *testing.T) {
+	c := New(DefaultTaxonomy(), nil, "", Metrics{}, nil)
+	for _, tc := range []struct {
+		prompt, response string
+		want             []string
+	}{
+		{"Why does my Python function return None?", "", []string{"coding"}},
+		{"Book flights and a hotel", "", []string{"travel"}},
+		{"I love painting", "", nil}, // not "pain"
+		{"Summarise this report", "Well, shit happens.", []string{"writing", "profanity"}},
+		{"Tell me about bugs", "", []string{"coding"}},
+	} {
+		if got := c.Keywords(tc.prompt, tc.response); !slices.Equal(got, tc.want) {
+			t.Errorf("Keywords(%q, %q) = %v, want %v", tc.prompt, tc.response, got, tc.want)
+		}
+	}
+}
+
+func TestClassifierReportsCategories(t *testing.T) {
+	categories := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "categories"}, []string{"kind", "category"})
+	var got []string
+	c := New(DefaultTaxonomy(), nil, "", Metrics{Categories: categories}, func(job Job, result []string) {
+		got = Merge(got, result)
+	})
+	c.classify(context.Background(), Job{Prompt: "fix this sql query"})
+	c.classify(context.Background(), Job{Prompt: "hello there"})
+
+	if !slices.Equal(got, []string{"coding"}) {
+		t.Errorf("categories = %v", got)
+	}
+	if n := testutil.ToFloat64(categories.WithLabelValues(Topic, Uncategorized)); n != 1 {
+		t.Errorf("uncategorized = %v", n)
+	}
+}
+
+func TestParseReplyAndLoadTaxonomy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "taxonomy.json")
+	os.WriteFile(path, []byte(`[{"name": "Billing", "kind": "topic", "keywords": ["invoice"]}]`), 0o644)
+	taxonomy, err := LoadTaxonomy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(taxonomy, nil, "", Metrics{}, nil)
+	got, err := c.ParseReply("Sure:\n```json\n[\"billing\", \"weather\"]\n```")
+	if err != nil || !slices.Equal(got, []string{"Billing"}) {
+		t.Errorf("ParseReply = %v, %v", got, err)
+	}
+
+	os.WriteFile(path, []byte(`[{"name": "x", "kind": "mood"}]`), 0o644)
+	if _, err := LoadTaxonomy(path); err == nil {
+		t.Error("unknown kind accepted")
+	}
+}
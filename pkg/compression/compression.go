@@ -0,0 +1,312 @@
+package compression
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/truncation"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Compression methods, applied in this order
+const (
+	Boilerplate = "boilerplate" // collapse whitespace and drop filler lines
+	Dedupe      = "dedupe"      // drop paragraphs already sent earlier in the prompt
+	LLMLingua   = "llmlingua"   // send the text to an LLMLingua-style compressor
+)
+
+// Header reports how many prompt tokens compression saved
+const Header = "X-Prompt-Tokens-Saved"
+
+// minDuplicateChars keeps short paragraphs such as "Thanks!" from being
+// treated as repeated context
+const minDuplicateChars = 40
+
+// maxBodyBytes bounds the request bodies the middleware rewrites
+const maxBodyBytes = 10 << 20
+
+var (
+	spaces    = regexp.MustCompile(`[ \t]+`)
+	blankRuns = regexp.MustCompile(`\n{3,}`)
+	// Lines that carry no content: separators and page footers left over
+	// from document extraction
+	fillerLines = regexp.MustCompile(`(?m)^(?:[-=*_#~.]{3,}|Page \d+( of \d+)?)\n`)
+)
+
+// Config selects the methods and when they apply
+type Config struct {
+	Methods []string
+	// MinTokens leaves messages shorter than this untouched
+	MinTokens int
+	// CompressorURL is the endpoint the llmlingua method posts to
+	CompressorURL string
+	// Rate is the share of tokens the compressor should keep
+	Rate float64
+}
+
+// ParseMethods reads a comma-separated method list
+func ParseMethods(s string) ([]string, error) {
+	var methods []string
+	for _, method := range strings.Split(s, ",") {
+		method = strings.ToLower(strings.TrimSpace(method))
+		switch method {
+		case "", "off", "none":
+		case Boilerplate, Dedupe, LLMLingua:
+			methods = append(methods, method)
+		default:
+			return nil, fmt.Errorf("unknown prompt compression method %q", method)
+		}
+	}
+	return methods, nil
+}
+
+// Message is a single prompt message
+type Message struct {
+	Role    string
+	Content string
+}
+
+// Result describes what a compression pass did
+type Result struct {
+	Messages []Message
+	Saved    map[string]int // estimated tokens saved, by method
+}
+
+// TokensSaved is the estimated number of tokens saved by every method
+func (r Result) TokensSaved() int {
+	total := 0
+	for _, saved := range r.Saved {
+		total += saved
+	}
+	return total
+}
+
+// Compressor shrinks long prompts before they are forwarded
+type Compressor struct {
+	config Config
+	client *http.Client
+	saved  *prometheus.CounterVec // labels: model, method
+}
+
+// New creates a compressor, counting tokens saved in saved. A nil client
+// uses a default with a timeout.
+func New(cfg Config, client *http.Client, saved *prometheus.CounterVec) (*Compressor, error) {
+	for _, method := range cfg.Methods {
+		if method == LLMLingua && cfg.CompressorURL == "" {
+			return nil, fmt.Errorf("the %s method needs a compressor URL", LLMLingua)
+		}
+	}
+	if cfg.Rate <= 0 || cfg.Rate > 1 {
+		cfg.Rate = 0.5
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Compressor{config: cfg, client: client, saved: saved}, nil
+}
+
+// Enabled reports whether any method is configured
+func (c *Compressor) Enabled() bool {
+	return len(c.config.Methods) > 0
+}
+
+// Compress applies the configured methods to every message of at least
+// MinTokens. Assistant turns are the model's own words and are left alone.
+// A compressor that fails leaves the text as it was.
+func (c *Compressor) Compress(ctx context.Context, model string, messages []Message) Result {
+	result := Result{Messages: make([]Message, len(messages)), Saved: make(map[string]int)}
+	copy(result.Messages, messages)
+	if !c.Enabled() {
+		return result
+	}
+
+	seen := make(map[string]bool)
+	for i, msg := range result.Messages {
+		if msg.Role == "assistant" {
+			continue
+		}
+		long := truncation.EstimateTokens(msg.Content) >= c.config.MinTokens
+		for _, method := range c.config.Methods {
+			before := msg.Content
+			switch method {
+			case Boilerplate:
+				if long {
+					msg.Content = stripBoilerplate(msg.Content)
+				}
+			case Dedupe:
+				// Short messages still count as sent, so later long ones
+				// don't repeat them
+				msg.Content = dedupe(msg.Content, seen, long)
+			case LLMLingua:
+				if long {
+					compressed, err := c.remote(ctx, msg.Content)
+					if err != nil {
+						log := logger.GetLogger()
+						log.Warn().Err(err).Str("model", model).Msg("Prompt compressor failed, sending the prompt uncompressed")
+						continue
+					}
+					msg.Content = compressed
+				}
+			}
+			if saved := truncation.EstimateTokens(before) - truncation.EstimateTokens(msg.Content); saved > 0 {
+				result.Saved[method] += saved
+			}
+		}
+		result.Messages[i] = msg
+	}
+
+	if c.saved != nil {
+		for method, saved := range result.Saved {
+			c.saved.WithLabelValues(model, method).Add(float64(saved))
+		}
+	}
+	return result
+}
+
+// stripBoilerplate collapses runs of whitespace and drops separator and
+// page footer lines
+func stripBoilerplate(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaces.ReplaceAllString(line, " "))
+	}
+	text = strings.Join(lines, "\n") + "\n"
+	text = fillerLines.ReplaceAllString(text, "")
+	text = blankRuns.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
+
+// dedupe drops paragraphs of text already in seen, when remove is set, and
+// adds the rest to seen
+func dedupe(text string, seen map[string]bool, remove bool) string {
+	paragraphs := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n")
+	kept := paragraphs[:0]
+	for _, paragraph := range paragraphs {
+		key := strings.Join(strings.Fields(paragraph), " ")
+		if len(key) >= minDuplicateChars {
+			if seen[key] && remove {
+				continue
+			}
+			seen[key] = true
+		}
+		kept = append(kept, paragraph)
+	}
+	return strings.Join(kept, "\n\n")
+}
+
+// remote asks the compressor service to shrink text. It takes the same
+// arguments as LLMLingua's compress_prompt and answers with its result.
+func (c *Compressor) remote(ctx context.Context, text string) (string, error) {
+	body, err := json.Marshal(map[string]any{"prompt": text, "rate": c.config.Rate})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.CompressorURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("prompt compressor request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("prompt compressor returned status %d", resp.StatusCode)
+	}
+
+	var out struct {
+		CompressedPrompt string `json:"compressed_prompt"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("invalid prompt compressor response: %w", err)
+	}
+	if out.CompressedPrompt == "" {
+		return "", fmt.Errorf("prompt compressor returned an empty prompt")
+	}
+	return out.CompressedPrompt, nil
+}
+
+// Middleware compresses the messages of OpenAI-style chat completion
+// requests before passing them on. Text content is compressed; other
+// content parts and every other field are forwarded unchanged.
+func (c *Compressor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.Enabled() || r.Method != http.MethodPost || r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+		if err != nil || len(body) > maxBodyBytes {
+			// Let the handler read, and reject, the body as sent
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+			next.ServeHTTP(w, r)
+			return
+		}
+		if rewritten, saved, ok := c.rewrite(r.Context(), body); ok {
+			body = rewritten
+			w.Header().Set(Header, strconv.Itoa(saved))
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rewrite compresses the string contents of a chat completion body. It
+// reports false when the body isn't one or nothing was saved.
+func (c *Compressor) rewrite(ctx context.Context, body []byte) ([]byte, int, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, 0, false
+	}
+	var model string
+	json.Unmarshal(fields["model"], &model)
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal(fields["messages"], &raw); err != nil {
+		return nil, 0, false
+	}
+
+	messages := make([]Message, len(raw))
+	for i, msg := range raw {
+		json.Unmarshal(msg["role"], &messages[i].Role)
+		if json.Unmarshal(msg["content"], &messages[i].Content) != nil {
+			// Content parts are passed through as they are
+			messages[i].Role = "assistant"
+		}
+	}
+	result := c.Compress(ctx, model, messages)
+	saved := result.TokensSaved()
+	if saved <= 0 {
+		return nil, 0, false
+	}
+
+	for i, msg := range result.Messages {
+		if msg.Content == messages[i].Content {
+			continue
+		}
+		content, err := json.Marshal(msg.Content)
+		if err != nil {
+			return nil, 0, false
+		}
+		raw[i]["content"] = content
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, 0, false
+	}
+	fields["messages"] = encoded
+	if body, err = json.Marshal(fields); err != nil {
+		return nil, 0, false
+	}
+	return body, saved, true
+}
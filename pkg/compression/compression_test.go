@@ -0,0 +1,141 @@
+package compression
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const chunk = "The deployment guide covers rolling updates, health checks and rollbacks for every service."
+
+func savedCounter() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{Name: "saved"}, []string{"model", "method"})
+}
+
+func TestCompressStripsBoilerplateAndDuplicates(t *testing.T) {
+	saved := savedCounter()
+	c, err := New(Config{Methods: []string{Boilerplate, Dedupe}}, nil, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rag := "Context:\n\n" + chunk + "\n\n----------\nPage 3 of 12\n\n\n\n" + chunk + "\n\nSecond   source\t\twith  spacing."
+	result := c.Compress(context.Background(), "m", []Message{
+		{Role: "system", Content: rag},
+		{Role: "assistant", Content: chunk},
+		{Role: "user", Content: chunk + "\n\nWhat about rollbacks?"},
+	})
+
+	want := "Context:\n\n" + chunk + "\n\nSecond source with spacing."
+	if got := result.Messages[0].Content; got != want {
+		t.Errorf("system message = %q, want %q", got, want)
+	}
+	if got := result.Messages[1].Content; got != chunk {
+		t.Errorf("assistant message changed to %q", got)
+	}
+	if got := result.Messages[2].Content; got != "What about rollbacks?" {
+		t.Errorf("user message = %q", got)
+	}
+	if result.Saved[Boilerplate] == 0 || result.Saved[Dedupe] == 0 {
+		t.Errorf("expected savings from both methods, got %v", result.Saved)
+	}
+	if got := testutil.ToFloat64(saved.WithLabelValues("m", Dedupe)); got != float64(result.Saved[Dedupe]) {
+		t.Errorf("dedupe counter = %v, want %d", got, result.Saved[Dedupe])
+	}
+}
+
+func TestCompressLeavesShortMessages(t *testing.T) {
+	c, _ := New(Config{Methods: []string{Boilerplate, Dedupe}, MinTokens: 100}, nil, nil)
+	messages := []Message{{Role: "user", Content: chunk + "\n\n\n\n" + chunk}}
+	result := c.Compress(context.Background(), "m", messages)
+	if result.Messages[0] != messages[0] || result.TokensSaved() != 0 {
+		t.Errorf("short message was compressed: %+v", result)
+	}
+}
+
+func TestCompressCallsCompressor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Prompt string  `json:"prompt"`
+			Rate   float64 `json:"rate"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Rate != 0.3 {
+			t.Errorf("rate = %v, want 0.3", req.Rate)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"compressed_prompt": req.Prompt[:20]})
+	}))
+	defer server.Close()
+
+	c, err := New(Config{Methods: []string{LLMLingua}, CompressorURL: server.URL, Rate: 0.3}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := c.Compress(context.Background(), "m", []Message{{Role: "user", Content: chunk}})
+	if got := result.Messages[0].Content; got != chunk[:20] {
+		t.Errorf("content = %q", got)
+	}
+
+	server.Close()
+	result = c.Compress(context.Background(), "m", []Message{{Role: "user", Content: chunk}})
+	if result.Messages[0].Content != chunk {
+		t.Error("expected the prompt unchanged when the compressor is down")
+	}
+
+	if _, err := New(Config{Methods: []string{LLMLingua}}, nil, nil); err == nil {
+		t.Error("expected an error without a compressor URL")
+	}
+}
+
+func TestParseMethods(t *testing.T) {
+	methods, err := ParseMethods(" Boilerplate, dedupe ,")
+	if err != nil || len(methods) != 2 || methods[0] != Boilerplate || methods[1] != Dedupe {
+		t.Errorf("ParseMethods = %v, %v", methods, err)
+	}
+	if methods, err := ParseMethods("off"); err != nil || len(methods) != 0 {
+		t.Errorf("off = %v, %v", methods, err)
+	}
+	if _, err := ParseMethods("zip"); err == nil {
+		t.Error("expected an error for an unknown method")
+	}
+}
+
+func TestMiddlewareRewritesChatMessages(t *testing.T) {
+	c, _ := New(Config{Methods: []string{Dedupe}}, nil, nil)
+	var forwarded map[string]any
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if int64(len(body)) != r.ContentLength {
+			t.Errorf("content length %d for a %d byte body", r.ContentLength, len(body))
+		}
+		json.Unmarshal(body, &forwarded)
+	}))
+
+	body := `{"model":"m","temperature":0.5,"messages":[` +
+		`{"role":"system","content":"` + chunk + `"},` +
+		`{"role":"user","content":[{"type":"text","text":"` + chunk + `"}]},` +
+		`{"role":"user","content":"` + chunk + `\n\nAnd canaries?"}]}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+
+	if rec.Header().Get(Header) == "" {
+		t.Error("expected the tokens saved header")
+	}
+	if forwarded["temperature"] != 0.5 {
+		t.Errorf("other fields not forwarded: %v", forwarded)
+	}
+	messages := forwarded["messages"].([]any)
+	if parts, ok := messages[1].(map[string]any)["content"].([]any); !ok || len(parts) != 1 {
+		t.Errorf("content parts changed: %v", messages[1])
+	}
+	if got := messages[2].(map[string]any)["content"]; got != "And canaries?" {
+		t.Errorf("last message = %q", got)
+	}
+}
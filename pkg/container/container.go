@@ -0,0 +1,286 @@
+// Package container samples the CPU, memory and block I/O of the container
+// serving the model through the Docker Engine stats API, so latency spikes
+// can be correlated with the container being throttled or short of memory.
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrNoContainer is returned when no running container serves the model
+var ErrNoContainer = errors.New("no model container found")
+
+// modelImages are image name fragments of model servers, used to find the
+// model container when none is configured
+var modelImages = []string{"model-runner", "vllm", "ollama", "llama.cpp", "llama-cpp", "llamacpp", "text-generation-inference", "localai"}
+
+// Stats is one sample of the model container's resource usage
+type Stats struct {
+	Container        string    `json:"container"`
+	ID               string    `json:"id"`
+	CPUPercent       float64   `json:"cpuPercent"` // 100 is one full CPU, as in docker stats
+	MemoryBytes      float64   `json:"memoryBytes"`
+	MemoryLimitBytes float64   `json:"memoryLimitBytes"`
+	BlockReadBytes   float64   `json:"blockReadBytes"`  // since the container started
+	BlockWriteBytes  float64   `json:"blockWriteBytes"` // since the container started
+	ThrottledSeconds float64   `json:"throttledSeconds"`
+	SampledAt        time.Time `json:"sampledAt"`
+}
+
+// Metrics holds the gauges samples are copied into, labelled by container
+type Metrics struct {
+	CPU              *prometheus.GaugeVec
+	Memory           *prometheus.GaugeVec
+	MemoryLimit      *prometheus.GaugeVec
+	BlockRead        *prometheus.GaugeVec
+	BlockWrite       *prometheus.GaugeVec
+	ThrottledSeconds *prometheus.GaugeVec
+}
+
+// Collector samples the model container's stats from the Docker Engine API
+type Collector struct {
+	host    string // base URL of the engine API
+	match   string // container name or ID; empty finds it by image
+	client  *http.Client
+	metrics Metrics
+
+	mu     sync.Mutex
+	id     string // the container found, cleared when it goes away
+	name   string
+	latest *Stats
+}
+
+// New creates a collector talking to the Docker Engine at dockerHost, e.g.
+// unix:///var/run/docker.sock or tcp://127.0.0.1:2375. container names the
+// model container; when empty, the first running container whose image is
+// a known model server is used.
+func New(dockerHost, container string, metrics Metrics) (*Collector, error) {
+	u, err := url.Parse(dockerHost)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host: %w", err)
+	}
+	c := &Collector{match: strings.TrimPrefix(container, "/"), metrics: metrics, client: &http.Client{Timeout: 10 * time.Second}}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		c.host = "http://docker"
+		c.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	case "tcp", "http":
+		c.host = "http://" + u.Host
+	case "https":
+		c.host = "https://" + u.Host
+	default:
+		return nil, fmt.Errorf("invalid docker host %q, want unix:// or tcp://", dockerHost)
+	}
+	return c, nil
+}
+
+// summary is an entry of GET /containers/json
+type summary struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// find returns the ID and name of the model container
+func (c *Collector) find(ctx context.Context) (string, string, error) {
+	var containers []summary
+	if err := c.get(ctx, "/containers/json", &containers); err != nil {
+		return "", "", err
+	}
+	for _, s := range containers {
+		name := s.ID
+		if len(s.Names) > 0 {
+			name = strings.TrimPrefix(s.Names[0], "/")
+		}
+		if c.match != "" {
+			if name == c.match || s.Labels["com.docker.compose.service"] == c.match || strings.HasPrefix(s.ID, c.match) {
+				return s.ID, name, nil
+			}
+			continue
+		}
+		for _, image := range modelImages {
+			if strings.Contains(strings.ToLower(s.Image), image) {
+				return s.ID, name, nil
+			}
+		}
+	}
+	return "", "", ErrNoContainer
+}
+
+// engineStats is the part of GET /containers/{id}/stats that is sampled
+type engineStats struct {
+	CPUStats    cpuStats `json:"cpu_stats"`
+	PreCPUStats cpuStats `json:"precpu_stats"`
+	MemoryStats struct {
+		Usage float64            `json:"usage"`
+		Limit float64            `json:"limit"`
+		Stats map[string]float64 `json:"stats"`
+	} `json:"memory_stats"`
+	BlkioStats struct {
+		IOServiceBytesRecursive []struct {
+			Op    string  `json:"op"`
+			Value float64 `json:"value"`
+		} `json:"io_service_bytes_recursive"`
+	} `json:"blkio_stats"`
+}
+
+type cpuStats struct {
+	CPUUsage struct {
+		TotalUsage  float64   `json:"total_usage"`
+		PercpuUsage []float64 `json:"percpu_usage"`
+	} `json:"cpu_usage"`
+	SystemUsage    float64 `json:"system_cpu_usage"`
+	OnlineCPUs     float64 `json:"online_cpus"`
+	ThrottlingData struct {
+		ThrottledTime float64 `json:"throttled_time"` // nanoseconds
+	} `json:"throttling_data"`
+}
+
+// Sample reads the model container's stats and updates the gauges
+func (c *Collector) Sample(ctx context.Context) (Stats, error) {
+	c.mu.Lock()
+	id, name := c.id, c.name
+	c.mu.Unlock()
+	if id == "" {
+		var err error
+		if id, name, err = c.find(ctx); err != nil {
+			return Stats{}, err
+		}
+	}
+
+	var raw engineStats
+	if err := c.get(ctx, "/containers/"+id+"/stats?stream=false", &raw); err != nil {
+		// The container may have been replaced; find it again next time
+		c.mu.Lock()
+		c.id, c.name = "", ""
+		c.mu.Unlock()
+		return Stats{}, err
+	}
+	stats := convert(raw)
+	stats.Container, stats.ID, stats.SampledAt = name, id, time.Now()
+
+	c.mu.Lock()
+	c.id, c.name = id, name
+	c.latest = &stats
+	c.mu.Unlock()
+	c.record(stats)
+	return stats, nil
+}
+
+// convert computes the figures docker stats shows from the raw counters
+func convert(raw engineStats) Stats {
+	var stats Stats
+	cpuDelta := raw.CPUStats.CPUUsage.TotalUsage - raw.PreCPUStats.CPUUsage.TotalUsage
+	systemDelta := raw.CPUStats.SystemUsage - raw.PreCPUStats.SystemUsage
+	cpus := raw.CPUStats.OnlineCPUs
+	if cpus == 0 {
+		cpus = float64(len(raw.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		stats.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	// Like docker stats, leave out the page cache: cgroup v1 reports it as
+	// cache, v2 as inactive_file
+	stats.MemoryBytes = raw.MemoryStats.Usage
+	if cache, ok := raw.MemoryStats.Stats["inactive_file"]; ok && cache < stats.MemoryBytes {
+		stats.MemoryBytes -= cache
+	} else if cache, ok := raw.MemoryStats.Stats["cache"]; ok && cache < stats.MemoryBytes {
+		stats.MemoryBytes -= cache
+	}
+	stats.MemoryLimitBytes = raw.MemoryStats.Limit
+
+	for _, entry := range raw.BlkioStats.IOServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			stats.BlockReadBytes += entry.Value
+		case "write":
+			stats.BlockWriteBytes += entry.Value
+		}
+	}
+	stats.ThrottledSeconds = raw.CPUStats.ThrottlingData.ThrottledTime / 1e9
+	return stats
+}
+
+func (c *Collector) record(stats Stats) {
+	set := func(gauge *prometheus.GaugeVec, value float64) {
+		if gauge != nil {
+			gauge.WithLabelValues(stats.Container).Set(value)
+		}
+	}
+	set(c.metrics.CPU, stats.CPUPercent)
+	set(c.metrics.Memory, stats.MemoryBytes)
+	set(c.metrics.MemoryLimit, stats.MemoryLimitBytes)
+	set(c.metrics.BlockRead, stats.BlockReadBytes)
+	set(c.metrics.BlockWrite, stats.BlockWriteBytes)
+	set(c.metrics.ThrottledSeconds, stats.ThrottledSeconds)
+}
+
+// Latest returns the most recent sample
+func (c *Collector) Latest() (Stats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.latest == nil {
+		return Stats{}, false
+	}
+	return *c.latest, true
+}
+
+// Start samples the container every interval until ctx is cancelled
+func (c *Collector) Start(ctx context.Context, interval time.Duration) {
+	log := logger.GetLogger()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := c.Sample(ctx); err != nil {
+				log.Debug().Err(err).Msg("Model container stats sample failed")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// get fetches and decodes a JSON document from the engine API
+func (c *Collector) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.host+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("docker GET %s returned status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
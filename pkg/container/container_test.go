@@ -0,0 +1,76 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeEngine serves a web container and a vLLM container
+func fakeEngine(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"Id": "aaa111", "Names": ["/frontend"], "Image": "nginx:alpine"},
+			{"Id": "bbb222", "Names": ["/stack-llm-1"], "Image": "vllm/vllm-openai:latest", "Labels": {"com.docker.compose.service": "llm"}}
+		]`)
+	})
+	mux.HandleFunc("GET /containers/bbb222/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stream") != "false" {
+			t.Errorf("stats requested as a stream")
+		}
+		fmt.Fprint(w, `{
+			"cpu_stats": {"cpu_usage": {"total_usage": 3000000000}, "system_cpu_usage": 20000000000, "online_cpus": 4,
+				"throttling_data": {"throttled_time": 2500000000}},
+			"precpu_stats": {"cpu_usage": {"total_usage": 2000000000}, "system_cpu_usage": 10000000000},
+			"memory_stats": {"usage": 1200, "limit": 4096, "stats": {"inactive_file": 200}},
+			"blkio_stats": {"io_service_bytes_recursive": [{"op": "read", "value": 10}, {"op": "write", "value": 20}, {"op": "Read", "value": 5}]}
+		}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSampleFindsModelContainer(t *testing.T) {
+	server := fakeEngine(t)
+	cpu := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cpu"}, []string{"container"})
+	c, err := New("tcp://"+server.Listener.Addr().String(), "", Metrics{CPU: cpu})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := c.Sample(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{Container: "stack-llm-1", ID: "bbb222", CPUPercent: 40, MemoryBytes: 1000, MemoryLimitBytes: 4096,
+		BlockReadBytes: 15, BlockWriteBytes: 20, ThrottledSeconds: 2.5, SampledAt: stats.SampledAt}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+	if got := testutil.ToFloat64(cpu.WithLabelValues("stack-llm-1")); got != 40 {
+		t.Errorf("cpu gauge = %v", got)
+	}
+	if latest, ok := c.Latest(); !ok || latest != stats {
+		t.Errorf("latest = %+v, %v", latest, ok)
+	}
+}
+
+func TestSampleConfiguredContainer(t *testing.T) {
+	server := fakeEngine(t)
+	for match, want := range map[string]error{"llm": nil, "bbb2": nil, "database": ErrNoContainer} {
+		c, _ := New("tcp://"+server.Listener.Addr().String(), match, Metrics{})
+		if _, err := c.Sample(context.Background()); err != want {
+			t.Errorf("%s: err = %v, want %v", match, err, want)
+		}
+	}
+	if _, err := New("ftp://docker", "", Metrics{}); err == nil {
+		t.Error("invalid docker host accepted")
+	}
+}
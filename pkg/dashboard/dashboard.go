@@ -0,0 +1,34 @@
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// static holds the dashboard assets compiled into the binary
+//
+//go:embed static
+var static embed.FS
+
+// Handler serves the dashboard under prefix (e.g. "/dashboard/"). The page
+// reads /metrics/summary and /conversations from the same server, so it works
+// without the separate frontend deployment.
+func Handler(prefix string) http.Handler {
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		// The embedded directory is fixed at build time
+		panic(err)
+	}
+	files := http.StripPrefix(prefix, http.FileServer(http.FS(assets)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Redirect /dashboard to /dashboard/ so relative asset paths resolve
+		if r.URL.Path+"/" == prefix {
+			http.Redirect(w, r, prefix, http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,30 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerServesEmbeddedAssets(t *testing.T) {
+	h := Handler("/dashboard/")
+
+	for path, want := range map[string]string{
+		"/dashboard/":          "<title>AIWatch Dashboard</title>",
+		"/dashboard/app.js":    "/metrics/summary",
+		"/dashboard/style.css": ".card",
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("GET %s: expected %q, got %d", path, want, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/dashboard/" {
+		t.Errorf("expected redirect to /dashboard/, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
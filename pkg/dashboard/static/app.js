@@ -0,0 +1,126 @@
+// Polls the aiwatch API and renders the summary, token throughput and
+// recent conversations. No build step or dependencies.
+(function () {
+  'use strict';
+
+  const POLL_MS = 2000;
+  const HISTORY = 90; // throughput samples kept for the chart
+
+  const samples = [];
+  let previous = null;
+
+  const el = (id) => document.getElementById(id);
+
+  function setStatus(text, cls) {
+    const status = el('status');
+    status.textContent = text;
+    status.className = 'status ' + cls;
+  }
+
+  function formatNumber(n) {
+    return Number(n || 0).toLocaleString(undefined, { maximumFractionDigits: 1 });
+  }
+
+  function renderSummary(summary) {
+    el('totalRequests').textContent = formatNumber(summary.totalRequests);
+    el('averageResponseTime').textContent = formatNumber(summary.averageResponseTime * 1000) + ' ms';
+    el('tokensGenerated').textContent = formatNumber(summary.tokensGenerated);
+    el('tokensProcessed').textContent = formatNumber(summary.tokensProcessed);
+    el('activeUsers').textContent = formatNumber(summary.activeUsers);
+    el('errorRate').textContent = formatNumber(summary.errorRate * 100) + ' %';
+
+    // Recent windows show current behaviour, which the totals since start hide
+    const windows = summary.windows || {};
+    const recent = windows['5m'] || {};
+    const hour = windows['1h'] || {};
+    el('requests5m').textContent = formatNumber(recent.requests);
+    el('requests1h').textContent = formatNumber(hour.requests) + ' in the last hour';
+    el('errorRate5m').textContent = formatNumber(recent.errorRate * 100) + ' %';
+    el('errorRate1h').textContent = formatNumber(hour.errorRate * 100) + ' % in the last hour';
+    el('ttft5m').textContent = formatNumber(recent.averageTimeToFirstToken * 1000) + ' ms';
+    el('ttft1h').textContent = formatNumber(hour.averageTimeToFirstToken * 1000) + ' ms in the last hour';
+
+    // Throughput is the change in generated tokens between polls
+    const now = performance.now();
+    if (previous) {
+      const seconds = (now - previous.at) / 1000;
+      const rate = Math.max(0, (summary.tokensGenerated - previous.tokens) / seconds);
+      samples.push(rate);
+      if (samples.length > HISTORY) samples.shift();
+      el('throughput').textContent = formatNumber(rate) + ' tokens/s';
+    }
+    previous = { at: now, tokens: summary.tokensGenerated };
+    drawChart();
+  }
+
+  function drawChart() {
+    const canvas = el('chart');
+    const ctx = canvas.getContext('2d');
+    const { width, height } = canvas;
+    ctx.clearRect(0, 0, width, height);
+    if (samples.length < 2) return;
+
+    const max = Math.max(1, ...samples);
+    const step = width / (HISTORY - 1);
+    ctx.beginPath();
+    samples.forEach((value, i) => {
+      const x = (HISTORY - samples.length + i) * step;
+      const y = height - (value / max) * (height - 10);
+      if (i === 0) ctx.moveTo(x, y);
+      else ctx.lineTo(x, y);
+    });
+    ctx.strokeStyle = '#38bdf8';
+    ctx.lineWidth = 2;
+    ctx.stroke();
+
+    ctx.fillStyle = '#64748b';
+    ctx.font = '12px system-ui';
+    ctx.fillText(formatNumber(max) + ' tokens/s', 4, 12);
+  }
+
+  function renderConversations(conversations) {
+    const body = el('conversations');
+    body.textContent = '';
+    if (!conversations || conversations.length === 0) {
+      const row = body.insertRow();
+      const cell = row.insertCell();
+      cell.colSpan = 4;
+      cell.className = 'muted';
+      cell.textContent = 'No conversations yet';
+      return;
+    }
+    conversations.forEach((c) => {
+      const row = body.insertRow();
+      row.insertCell().textContent = new Date(c.updated_at).toLocaleTimeString();
+      row.insertCell().textContent = c.model;
+      row.insertCell().textContent = c.message_count;
+      const preview = row.insertCell();
+      preview.className = 'preview';
+      preview.textContent = c.title || c.preview;
+      preview.title = c.summary || c.preview;
+    });
+  }
+
+  async function fetchJSON(path) {
+    const response = await fetch(path);
+    if (!response.ok) throw new Error(path + ': ' + response.status);
+    return response.json();
+  }
+
+  async function poll() {
+    try {
+      const [summary, conversations] = await Promise.all([
+        fetchJSON('/metrics/summary'),
+        fetchJSON('/conversations?limit=10'),
+      ]);
+      renderSummary(summary);
+      renderConversations(conversations);
+      setStatus('live', 'ok');
+    } catch (err) {
+      setStatus('unreachable', 'error');
+    }
+    setTimeout(poll, POLL_MS);
+  }
+
+  poll();
+})();
@@ -0,0 +1,143 @@
+package drain
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+)
+
+// cutGrace is how long cut requests get to write their final event and return
+const cutGrace = 5 * time.Second
+
+type contextKey struct{}
+
+// request is a tracked in-flight request
+type request struct {
+	cancel context.CancelFunc
+	cut    bool
+}
+
+// Drainer tracks long-running requests so shutdown can stop admitting new
+// ones, let in-flight ones finish within a window, and cut the rest cleanly
+type Drainer struct {
+	mu       sync.Mutex
+	draining bool
+	active   map[*request]struct{}
+	idle     chan struct{} // closed when draining and nothing is active
+	drained  int
+}
+
+// New creates a drainer that admits requests
+func New() *Drainer {
+	return &Drainer{active: make(map[*request]struct{})}
+}
+
+// Draining reports whether shutdown has started
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Middleware rejects requests with 503 once draining has started, and tracks
+// admitted ones. A request cut at the end of the drain window has its
+// context cancelled; handlers can tell with Cut and say goodbye to the client.
+func (d *Drainer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, req, ok := d.track(r.Context())
+		if !ok {
+			w.Header().Set("Retry-After", "5")
+			w.Header().Set("Connection", "close")
+			apierror.Write(w, r, apierror.Unavailable, "Server is shutting down")
+			return
+		}
+		defer d.done(req)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Cut reports whether the request owning ctx was cut short by shutdown
+func (d *Drainer) Cut(ctx context.Context) bool {
+	req, ok := ctx.Value(contextKey{}).(*request)
+	if !ok {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return req.cut
+}
+
+// Drain stops admitting requests and waits up to window for in-flight ones to
+// finish. Whatever is still running is then cancelled and given a short grace
+// period to write a final event. It returns how many requests finished on
+// their own and how many were cut.
+func (d *Drainer) Drain(window time.Duration) (drained, cut int) {
+	d.mu.Lock()
+	d.draining = true
+	d.idle = make(chan struct{})
+	if len(d.active) == 0 {
+		close(d.idle)
+	}
+	idle := d.idle
+	d.mu.Unlock()
+
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+		d.mu.Lock()
+		for req := range d.active {
+			req.cut = true
+			req.cancel()
+			cut++
+		}
+		d.mu.Unlock()
+
+		grace := time.NewTimer(cutGrace)
+		defer grace.Stop()
+		select {
+		case <-idle:
+		case <-grace.C:
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.drained, cut
+}
+
+// track admits a request unless draining, returning its cancellable context
+func (d *Drainer) track(parent context.Context) (context.Context, *request, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.draining {
+		return nil, nil, false
+	}
+	ctx, cancel := context.WithCancel(parent)
+	req := &request{cancel: cancel}
+	d.active[req] = struct{}{}
+	return context.WithValue(ctx, contextKey{}, req), req, true
+}
+
+// done stops tracking a finished request
+func (d *Drainer) done(req *request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	req.cancel()
+	delete(d.active, req)
+	if d.draining {
+		if !req.cut {
+			d.drained++
+		}
+		if len(d.active) == 0 {
+			close(d.idle)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package drain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDrainWaitsThenCuts(t *testing.T) {
+	d := New()
+	finish := make(chan struct{})
+	cutSeen := make(chan bool, 1)
+
+	quick := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-finish
+	}))
+	slow := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		cutSeen <- d.Cut(r.Context())
+	}))
+
+	go quick.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/chat", nil))
+	go slow.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/chat", nil))
+	for {
+		d.mu.Lock()
+		n := len(d.active)
+		d.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(finish)
+	}()
+	drained, cut := d.Drain(100 * time.Millisecond)
+	if drained != 1 || cut != 1 {
+		t.Errorf("expected 1 drained and 1 cut, got %d and %d", drained, cut)
+	}
+	if !<-cutSeen {
+		t.Error("expected the slow request to see it was cut")
+	}
+
+	rec := httptest.NewRecorder()
+	quick.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/chat", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected new requests rejected while draining, got %d", rec.Code)
+	}
+}
+
+func TestDrainIdleReturnsImmediately(t *testing.T) {
+	start := time.Now()
+	if drained, cut := New().Drain(time.Minute); drained != 0 || cut != 0 {
+		t.Errorf("expected nothing to drain, got %d and %d", drained, cut)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("expected an idle drain to return immediately")
+	}
+}
@@ -0,0 +1,348 @@
+package drift
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Signals tracked by the detector
+const (
+	SignalPromptLength   = "prompt_length"
+	SignalResponseLength = "response_length"
+	SignalRefusalRate    = "refusal_rate"
+	SignalTopic          = "topic"
+)
+
+// Config controls windowing and when a shift counts as drift
+type Config struct {
+	Window     time.Duration // length of each comparison window
+	MinSamples int           // observations needed before a window is compared
+
+	// LengthThreshold is the shift in mean length, in baseline standard
+	// deviations, that counts as drift
+	LengthThreshold float64
+	// RefusalThreshold is the absolute change in refusal rate that counts as drift
+	RefusalThreshold float64
+	// TopicThreshold is the cosine distance between embedding centroids that counts as drift
+	TopicThreshold float64
+}
+
+// DefaultConfig returns hourly windows with moderate thresholds
+func DefaultConfig() Config {
+	return Config{
+		Window:           time.Hour,
+		MinSamples:       50,
+		LengthThreshold:  1.0,
+		RefusalThreshold: 0.1,
+		TopicThreshold:   0.15,
+	}
+}
+
+// Metrics holds the collectors the detector reports to
+type Metrics struct {
+	Score    *prometheus.GaugeVec   // labels: signal
+	Drifting *prometheus.GaugeVec   // labels: signal; 1 while drift is detected
+	Alerts   *prometheus.CounterVec // labels: signal
+}
+
+// Detector compares rolling windows of chat traffic against a baseline window
+type Detector struct {
+	config  Config
+	metrics Metrics
+
+	mu          sync.Mutex
+	baseline    *window
+	current     *window
+	windowStart time.Time
+	drifting    map[string]bool
+	now         func() time.Time
+}
+
+// New creates a detector. The first full window becomes the baseline.
+func New(config Config, metrics Metrics) *Detector {
+	return &Detector{
+		config:   config,
+		metrics:  metrics,
+		current:  &window{},
+		drifting: make(map[string]bool),
+		now:      time.Now,
+	}
+}
+
+// refusalPrefixes start responses where the model declined to answer
+var refusalPrefixes = []string{
+	"i can't", "i cannot", "i can’t", "i'm sorry, but", "i’m sorry, but", "i am sorry, but",
+	"i'm unable to", "i am unable to", "i won't", "as an ai",
+}
+
+// IsRefusal reports whether a response looks like the model declined to answer
+func IsRefusal(response string) bool {
+	start := strings.ToLower(strings.TrimSpace(response))
+	for _, prefix := range refusalPrefixes {
+		if strings.HasPrefix(start, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Observe records a completed chat
+func (d *Detector) Observe(prompt, response string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.rotate()
+	d.current.lengths[0].add(float64(len([]rune(prompt))))
+	d.current.lengths[1].add(float64(len([]rune(response))))
+	if IsRefusal(response) {
+		d.current.refusals++
+	}
+	d.compare()
+}
+
+// ObserveEmbedding records the embedding of a prompt for topic drift
+func (d *Detector) ObserveEmbedding(embedding []float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.rotate()
+	d.current.addEmbedding(embedding)
+	d.compare()
+}
+
+// ResetBaseline makes the current window the new baseline, e.g. after a
+// deliberate model change has been accepted
+func (d *Detector) ResetBaseline() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.baseline = d.current
+	d.current = &window{}
+	d.windowStart = d.now()
+	d.compare()
+}
+
+// rotate starts a new window when the current one has run its length.
+// Callers must hold d.mu.
+func (d *Detector) rotate() {
+	now := d.now()
+	if d.windowStart.IsZero() {
+		d.windowStart = now
+		return
+	}
+	if now.Sub(d.windowStart) < d.config.Window {
+		return
+	}
+	if d.baseline == nil && d.current.samples() >= d.config.MinSamples {
+		d.baseline = d.current
+		log := logger.GetLogger()
+		log.Info().Int("samples", d.baseline.samples()).Msg("Drift baseline captured")
+	}
+	d.current = &window{}
+	d.windowStart = now
+}
+
+// compare scores the current window against the baseline and raises an
+// alert for each signal that starts drifting. Callers must hold d.mu.
+func (d *Detector) compare() {
+	scores := d.scores()
+	for _, signal := range []string{SignalPromptLength, SignalResponseLength, SignalRefusalRate, SignalTopic} {
+		// Signals without enough data in both windows aren't drifting
+		score := scores[signal]
+		d.metrics.Score.WithLabelValues(signal).Set(score)
+
+		drifting := score > d.threshold(signal)
+		if drifting && !d.drifting[signal] {
+			d.metrics.Alerts.WithLabelValues(signal).Inc()
+			log := logger.GetLogger()
+			log.Warn().Str("signal", signal).Float64("score", score).Float64("threshold", d.threshold(signal)).Msg("Drift detected")
+		}
+		d.drifting[signal] = drifting
+		value := 0.0
+		if drifting {
+			value = 1
+		}
+		d.metrics.Drifting.WithLabelValues(signal).Set(value)
+	}
+}
+
+func (d *Detector) threshold(signal string) float64 {
+	switch signal {
+	case SignalRefusalRate:
+		return d.config.RefusalThreshold
+	case SignalTopic:
+		return d.config.TopicThreshold
+	default:
+		return d.config.LengthThreshold
+	}
+}
+
+// scores returns the drift score of each signal with enough data.
+// Callers must hold d.mu.
+func (d *Detector) scores() map[string]float64 {
+	scores := make(map[string]float64)
+	if d.baseline == nil {
+		return scores
+	}
+
+	base, cur := d.baseline, d.current
+	if cur.samples() >= d.config.MinSamples {
+		scores[SignalPromptLength] = base.lengths[0].shift(cur.lengths[0])
+		scores[SignalResponseLength] = base.lengths[1].shift(cur.lengths[1])
+		scores[SignalRefusalRate] = math.Abs(cur.refusalRate() - base.refusalRate())
+	}
+	if base.embeddings >= d.config.MinSamples && cur.embeddings >= d.config.MinSamples {
+		scores[SignalTopic] = cosineDistance(base.centroid(), cur.centroid())
+	}
+	return scores
+}
+
+// Status is the detector state served by the drift endpoint
+type Status struct {
+	WindowStart time.Time          `json:"window_start"`
+	Baseline    *WindowSummary     `json:"baseline,omitempty"`
+	Current     WindowSummary      `json:"current"`
+	Scores      map[string]float64 `json:"scores"`
+	Drifting    []string           `json:"drifting"`
+}
+
+// WindowSummary describes one window of traffic
+type WindowSummary struct {
+	Samples            int     `json:"samples"`
+	MeanPromptLength   float64 `json:"mean_prompt_length"`
+	MeanResponseLength float64 `json:"mean_response_length"`
+	RefusalRate        float64 `json:"refusal_rate"`
+	Embeddings         int     `json:"embeddings"`
+}
+
+// Status returns the current comparison
+func (d *Detector) Status() Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := Status{
+		WindowStart: d.windowStart,
+		Current:     d.current.summary(),
+		Scores:      d.scores(),
+		Drifting:    []string{},
+	}
+	if d.baseline != nil {
+		summary := d.baseline.summary()
+		status.Baseline = &summary
+	}
+	for signal, drifting := range d.drifting {
+		if drifting {
+			status.Drifting = append(status.Drifting, signal)
+		}
+	}
+	return status
+}
+
+// window accumulates the statistics of one time window
+type window struct {
+	lengths    [2]moments // prompt, response
+	refusals   int
+	embeddings int
+	embedSum   []float64
+}
+
+func (w *window) samples() int {
+	return w.lengths[0].n
+}
+
+func (w *window) refusalRate() float64 {
+	if w.samples() == 0 {
+		return 0
+	}
+	return float64(w.refusals) / float64(w.samples())
+}
+
+func (w *window) addEmbedding(embedding []float64) {
+	if len(w.embedSum) == 0 {
+		w.embedSum = make([]float64, len(embedding))
+	}
+	if len(embedding) != len(w.embedSum) {
+		// A different embedding model; ignore rather than mix dimensions
+		return
+	}
+	norm := 0.0
+	for _, v := range embedding {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return
+	}
+	for i, v := range embedding {
+		w.embedSum[i] += v / norm
+	}
+	w.embeddings++
+}
+
+func (w *window) centroid() []float64 {
+	centroid := make([]float64, len(w.embedSum))
+	for i, v := range w.embedSum {
+		centroid[i] = v / float64(w.embeddings)
+	}
+	return centroid
+}
+
+func (w *window) summary() WindowSummary {
+	return WindowSummary{
+		Samples:            w.samples(),
+		MeanPromptLength:   w.lengths[0].mean(),
+		MeanResponseLength: w.lengths[1].mean(),
+		RefusalRate:        w.refusalRate(),
+		Embeddings:         w.embeddings,
+	}
+}
+
+// moments tracks count, mean and variance incrementally (Welford)
+type moments struct {
+	n       int
+	avg, m2 float64
+}
+
+func (m *moments) add(x float64) {
+	m.n++
+	delta := x - m.avg
+	m.avg += delta / float64(m.n)
+	m.m2 += delta * (x - m.avg)
+}
+
+func (m moments) mean() float64 { return m.avg }
+
+func (m moments) stddev() float64 {
+	if m.n < 2 {
+		return 0
+	}
+	return math.Sqrt(m.m2 / float64(m.n-1))
+}
+
+// shift is how far other's mean moved from m's, in m's standard deviations
+func (m moments) shift(other moments) float64 {
+	sd := m.stddev()
+	if sd == 0 {
+		sd = 1
+	}
+	return math.Abs(other.mean()-m.mean()) / sd
+}
+
+// cosineDistance is 1 - cosine similarity
+func cosineDistance(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return 1 - dot/(math.Sqrt(na)*math.Sqrt(nb))
+}
@@ -0,0 +1,63 @@
+package drift
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDetectsResponseLengthAndRefusalDrift(t *testing.T) {
+	metrics := Metrics{
+		Score:    prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "score"}, []string{"signal"}),
+		Drifting: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "drifting"}, []string{"signal"}),
+		Alerts:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "alerts"}, []string{"signal"}),
+	}
+	config := DefaultConfig()
+	config.MinSamples = 10
+	d := New(config, metrics)
+
+	clock := time.Unix(0, 0)
+	d.now = func() time.Time { return clock }
+
+	// Baseline: varied, helpful answers of around 100 characters
+	for i := 0; i < 20; i++ {
+		d.Observe("question", strings.Repeat("a", 90+i))
+	}
+	clock = clock.Add(config.Window)
+
+	// After the "upgrade": terse refusals
+	for i := 0; i < 20; i++ {
+		d.Observe("question", "I cannot help with that.")
+	}
+
+	status := d.Status()
+	if status.Baseline == nil || status.Baseline.Samples != 20 {
+		t.Fatalf("expected a 20 sample baseline, got %+v", status.Baseline)
+	}
+	for _, signal := range []string{SignalResponseLength, SignalRefusalRate} {
+		if got := testutil.ToFloat64(metrics.Alerts.WithLabelValues(signal)); got != 1 {
+			t.Errorf("expected one %s alert, got %v", signal, got)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.Drifting.WithLabelValues(SignalPromptLength)); got != 0 {
+		t.Errorf("prompt length did not change but drift was reported")
+	}
+
+	// Accepting the new behaviour clears the drift
+	d.ResetBaseline()
+	if len(d.Status().Drifting) != 0 {
+		t.Errorf("expected no drift after resetting the baseline, got %v", d.Status().Drifting)
+	}
+}
+
+func TestCosineDistance(t *testing.T) {
+	if got := cosineDistance([]float64{1, 0}, []float64{1, 0}); got != 0 {
+		t.Errorf("identical vectors should have distance 0, got %v", got)
+	}
+	if got := cosineDistance([]float64{1, 0}, []float64{0, 1}); got != 1 {
+		t.Errorf("orthogonal vectors should have distance 1, got %v", got)
+	}
+}
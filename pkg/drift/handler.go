@@ -0,0 +1,26 @@
+package drift
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Handler serves GET /drift and POST /drift/baseline
+func (d *Detector) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /drift", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, d.Status())
+	})
+	mux.HandleFunc("POST /drift/baseline", func(w http.ResponseWriter, r *http.Request) {
+		d.ResetBaseline()
+		writeJSON(w, http.StatusOK, d.Status())
+	})
+	return mux
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,28 @@
+package evaluation
+
+import "testing"
+
+func TestParseScores(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		ok    bool
+	}{
+		{"plain", `{"relevance": 4, "coherence": 5, "safety": 5}`, true},
+		{"fenced with prose", "Here you go:\n```json\n{\"relevance\": 2, \"coherence\": 3.5, \"safety\": 5}\n```", true},
+		{"missing criterion", `{"relevance": 4, "coherence": 5}`, false},
+		{"out of range", `{"relevance": 9, "coherence": 5, "safety": 5}`, false},
+		{"no json", "I cannot grade this.", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scores, err := ParseScores(tt.reply)
+			if tt.ok && (err != nil || len(scores) != len(Criteria)) {
+				t.Errorf("expected scores, got %v, %v", scores, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("expected an error, got %v", scores)
+			}
+		})
+	}
+}
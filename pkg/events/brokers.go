@@ -0,0 +1,202 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATSSink publishes each event to a NATS subject over the plain text
+// client protocol. The connection is kept open between batches.
+type NATSSink struct {
+	Addr    string // host:port
+	Subject string
+	User    string
+	Pass    string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Name returns the sink name
+func (s *NATSSink) Name() string { return "nats" }
+
+// Send publishes the events and waits for the server to acknowledge them
+func (s *NATSSink) Send(ctx context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.publish(ctx, events); err != nil {
+		// Reconnect on the next batch
+		if s.conn != nil {
+			s.conn.Close()
+			s.conn = nil
+		}
+		return fmt.Errorf("nats publish to %s failed: %w", s.Subject, err)
+	}
+	return nil
+}
+
+func (s *NATSSink) publish(ctx context.Context, events []Event) error {
+	if s.conn == nil {
+		if err := s.connect(ctx); err != nil {
+			return err
+		}
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(10 * time.Second)
+	}
+	s.conn.SetDeadline(deadline)
+
+	var buf bytes.Buffer
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "PUB %s %d\r\n", s.Subject, len(payload))
+		buf.Write(payload)
+		buf.WriteString("\r\n")
+	}
+	// The server answers a PING after processing everything before it, so
+	// the PONG confirms the batch was accepted
+	buf.WriteString("PING\r\n")
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return s.awaitPong()
+}
+
+// connect dials the server, reads its INFO and sends CONNECT
+func (s *NATSSink) connect(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+	info, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(info, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(info))
+	}
+
+	options := map[string]any{"verbose": false, "pedantic": false, "name": "aiwatch", "lang": "go"}
+	if s.User != "" {
+		options["user"], options["pass"] = s.User, s.Pass
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", connect); err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn, s.reader = conn, reader
+	return nil
+}
+
+// awaitPong reads server messages until the PONG, answering server PINGs
+func (s *NATSSink) awaitPong() error {
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// KafkaSink produces events to a Kafka topic through a Kafka REST Proxy
+// (v2 API), keyed by event ID
+type KafkaSink struct {
+	ProxyURL string // e.g. http://rest-proxy:8082
+	Topic    string
+	Client   *http.Client
+}
+
+// Name returns the sink name
+func (s *KafkaSink) Name() string { return "kafka" }
+
+// Send produces the events as one batch of JSON records
+func (s *KafkaSink) Send(ctx context.Context, events []Event) error {
+	type record struct {
+		Key   string `json:"key"`
+		Value Event  `json:"value"`
+	}
+	batch := struct {
+		Records []record `json:"records"`
+	}{Records: make([]record, len(events))}
+	for i, event := range events {
+		batch.Records[i] = record{Key: event.ID, Value: event}
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(s.ProxyURL, "/") + "/topics/" + url.PathEscape(s.Topic)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("kafka rest proxy returned status %d for topic %s", resp.StatusCode, s.Topic)
+	}
+
+	// The proxy answers 200 even when single records fail
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		// Accepted without per-record results
+		return nil
+	}
+	for _, offset := range result.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("kafka rest proxy rejected a record for topic %s: %s", s.Topic, offset.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeNATS accepts one connection and returns the payloads published to it
+func fakeNATS(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	published := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\"}\r\n")
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch fields := strings.Fields(line); {
+			case len(fields) == 0:
+			case fields[0] == "PING":
+				fmt.Fprint(conn, "PONG\r\n")
+			case fields[0] == "PUB" && len(fields) == 3:
+				var size int
+				fmt.Sscanf(fields[2], "%d", &size)
+				payload := make([]byte, size+2)
+				if _, err := io.ReadFull(reader, payload); err != nil {
+					return
+				}
+				published <- fields[1] + " " + string(payload[:size])
+			}
+		}
+	}()
+	return ln.Addr().String(), published
+}
+
+func TestNATSSinkPublishes(t *testing.T) {
+	addr, published := fakeNATS(t)
+	sink, err := NewSink("nats://" + addr + "/aiwatch.chats")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, _ := New("chat.archived", map[string]string{"model": "m"})
+	if err := sink.Send(context.Background(), []Event{event}); err != nil {
+		t.Fatal(err)
+	}
+	got := <-published
+	if !strings.HasPrefix(got, "aiwatch.chats ") || !strings.Contains(got, event.ID) {
+		t.Errorf("published %q", got)
+	}
+}
+
+func TestKafkaSinkProducesThroughRESTProxy(t *testing.T) {
+	var path, contentType string
+	var body struct {
+		Records []struct {
+			Key   string `json:"key"`
+			Value Event  `json:"value"`
+		} `json:"records"`
+	}
+	failRecord := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&body)
+		if failRecord {
+			w.Write([]byte(`{"offsets":[{"partition":null,"offset":null,"error_code":50002,"error":"broker down"}]}`))
+			return
+		}
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":7}]}`))
+	}))
+	defer server.Close()
+
+	sink, err := NewSink("kafka://" + strings.TrimPrefix(server.URL, "http://") + "/chats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, _ := New("chat.archived", map[string]string{"model": "m"})
+	if err := sink.Send(context.Background(), []Event{event}); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/topics/chats" || contentType != "application/vnd.kafka.json.v2+json" {
+		t.Errorf("posted to %s as %s", path, contentType)
+	}
+	if len(body.Records) != 1 || body.Records[0].Key != event.ID || body.Records[0].Value.Type != "chat.archived" {
+		t.Errorf("unexpected records %+v", body.Records)
+	}
+
+	failRecord = true
+	if err := sink.Send(context.Background(), []Event{event}); err == nil {
+		t.Error("expected an error when the proxy rejects a record")
+	}
+}
+
+func TestNewSinkNeedsSubjectAndTopic(t *testing.T) {
+	for _, spec := range []string{"nats://localhost:4222", "kafka://proxy:8082/"} {
+		if _, err := NewSink(spec); err == nil {
+			t.Errorf("expected an error for %s", spec)
+		}
+	}
+}
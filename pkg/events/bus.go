@@ -0,0 +1,307 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Chat lifecycle event types
+const (
+	RequestReceived    = "chat.request_received"
+	FirstToken         = "chat.first_token"
+	CompletionFinished = "chat.completion_finished"
+	Error              = "chat.error"
+)
+
+// LifecycleTypes lists the chat lifecycle event types
+var LifecycleTypes = []string{RequestReceived, FirstToken, CompletionFinished, Error}
+
+// Lifecycle is the payload of every chat lifecycle event. Fields that
+// don't apply to a stage are left out.
+type Lifecycle struct {
+	RequestID       string  `json:"request_id,omitempty"`
+	MessageID       string  `json:"message_id,omitempty"`
+	ConversationID  string  `json:"conversation_id,omitempty"`
+	Endpoint        string  `json:"endpoint"`
+	Model           string  `json:"model"`
+	Client          string  `json:"client,omitempty"` // masked API key or session
+	TokensIn        int     `json:"tokens_in,omitempty"`
+	TokensOut       int     `json:"tokens_out,omitempty"`
+	TokensPerSecond float64 `json:"tokens_per_second,omitempty"`
+	FirstTokenMs    float64 `json:"time_to_first_token_ms,omitempty"`
+	LatencyMs       float64 `json:"latency_ms,omitempty"`
+	StatusCode      int     `json:"status_code,omitempty"`
+	ErrorCode       string  `json:"error_code,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// Schema formats for published events
+const (
+	// FormatAiwatch is the Event envelope used by every sink
+	FormatAiwatch = "aiwatch"
+	// FormatCloudEvents is CloudEvents 1.0 structured JSON
+	FormatCloudEvents = "cloudevents"
+)
+
+// SchemaVersion is the version of the Lifecycle payload, bumped on
+// incompatible changes
+const SchemaVersion = "v1"
+
+// Publisher emits events for other services to consume
+type Publisher interface {
+	// Publish emits an event without blocking the caller
+	Publish(eventType string, data interface{})
+}
+
+// NopPublisher discards every event
+type NopPublisher struct{}
+
+// Publish discards the event
+func (NopPublisher) Publish(eventType string, data interface{}) {}
+
+// Publishers fans events out to several publishers
+type Publishers []Publisher
+
+// Publish passes the event to every publisher
+func (p Publishers) Publish(eventType string, data interface{}) {
+	for _, publisher := range p {
+		publisher.Publish(eventType, data)
+	}
+}
+
+// BusConfig configures where each event type is published
+type BusConfig struct {
+	// URL is the default destination, in NewSink's format, e.g.
+	// nats://nats:4222/aiwatch.chat or kafka://rest-proxy:8082/aiwatch-chat
+	URL string
+	// Topics publishes some event types to their own topic or subject on the
+	// same server instead
+	Topics map[string]string
+	// Types limits the published types; empty publishes all of them
+	Types []string
+	// Format is the schema of published events
+	Format    string
+	QueueSize int
+}
+
+// ParseTopics reads "type=topic" pairs separated by commas. Types may omit
+// the "chat." prefix.
+func ParseTopics(s string) (map[string]string, error) {
+	topics := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		eventType, topic, ok := strings.Cut(pair, "=")
+		if topic = strings.TrimSpace(topic); !ok || topic == "" {
+			return nil, fmt.Errorf("invalid event topic %q, want type=topic", pair)
+		}
+		eventType, err := lifecycleType(eventType)
+		if err != nil {
+			return nil, err
+		}
+		topics[eventType] = topic
+	}
+	return topics, nil
+}
+
+// ParseTypes reads a comma-separated list of lifecycle event types
+func ParseTypes(s string) ([]string, error) {
+	var types []string
+	for _, name := range strings.Split(s, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		eventType, err := lifecycleType(name)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, eventType)
+	}
+	return types, nil
+}
+
+// lifecycleType resolves a type name, with or without its "chat." prefix
+func lifecycleType(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !strings.HasPrefix(name, "chat.") {
+		name = "chat." + name
+	}
+	for _, eventType := range LifecycleTypes {
+		if name == eventType {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event type %q", name)
+}
+
+// BusMetrics holds the collectors the bus reports to
+type BusMetrics struct {
+	Published *prometheus.CounterVec // labels: type, result (delivered, failed or dropped)
+}
+
+// Bus publishes events to sinks from a background worker, so publishers
+// never wait on the broker. Events that arrive while the queue is full
+// are dropped and counted.
+type Bus struct {
+	format   string
+	sinks    map[string]Sink // by event type
+	fallback Sink
+	types    map[string]bool
+	metrics  BusMetrics
+	queue    chan Event
+	wg       sync.WaitGroup
+}
+
+// NewBus creates a bus from config
+func NewBus(config BusConfig, metrics BusMetrics) (*Bus, error) {
+	fallback, err := NewSink(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch config.Format {
+	case "":
+		config.Format = FormatAiwatch
+	case FormatAiwatch, FormatCloudEvents:
+	default:
+		return nil, fmt.Errorf("unknown event format %q", config.Format)
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1024
+	}
+
+	b := &Bus{
+		format:   config.Format,
+		sinks:    make(map[string]Sink),
+		fallback: fallback,
+		metrics:  metrics,
+		queue:    make(chan Event, config.QueueSize),
+	}
+	if len(config.Types) > 0 {
+		b.types = make(map[string]bool)
+		for _, eventType := range config.Types {
+			b.types[eventType] = true
+		}
+	}
+	for eventType, topic := range config.Topics {
+		spec, err := withTopic(config.URL, topic)
+		if err != nil {
+			return nil, err
+		}
+		if b.sinks[eventType], err = NewSink(spec); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// withTopic replaces the topic or subject, the path, of a sink URL
+func withTopic(spec, topic string) (string, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return "", fmt.Errorf("invalid sink %q: %w", spec, err)
+	}
+	u.Path = "/" + topic
+	return u.String(), nil
+}
+
+// Start delivers queued events until Close
+func (b *Bus) Start(ctx context.Context) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for event := range b.queue {
+			b.deliver(ctx, event)
+		}
+	}()
+}
+
+// Close stops accepting events and waits for queued ones to be delivered
+func (b *Bus) Close() {
+	close(b.queue)
+	b.wg.Wait()
+}
+
+// Publish queues an event of eventType
+func (b *Bus) Publish(eventType string, data interface{}) {
+	if b.types != nil && !b.types[eventType] {
+		return
+	}
+	event, err := New(eventType, data)
+	if err != nil {
+		log := logger.GetLogger()
+		log.Error().Err(err).Str("type", eventType).Msg("Failed to encode event")
+		return
+	}
+	event.Schema = eventType + "/" + SchemaVersion
+	if b.format == FormatCloudEvents {
+		event.SpecVersion = "1.0"
+	}
+
+	select {
+	case b.queue <- event:
+	default:
+		b.count(eventType, "dropped")
+	}
+}
+
+func (b *Bus) deliver(ctx context.Context, event Event) {
+	sink, ok := b.sinks[event.Type]
+	if !ok {
+		sink = b.fallback
+	}
+	sendCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := sink.Send(sendCtx, []Event{event}); err != nil {
+		log := logger.GetLogger()
+		log.Warn().Err(err).Str("sink", sink.Name()).Str("type", event.Type).Msg("Event delivery failed")
+		b.count(event.Type, "failed")
+		return
+	}
+	b.count(event.Type, "delivered")
+}
+
+func (b *Bus) count(eventType, result string) {
+	if b.metrics.Published != nil {
+		b.metrics.Published.WithLabelValues(eventType, result).Inc()
+	}
+}
+
+// cloudEvent is the CloudEvents 1.0 structured JSON form of an Event
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// MarshalJSON writes CloudEvents structured JSON when SpecVersion is set,
+// and the aiwatch envelope otherwise
+func (e Event) MarshalJSON() ([]byte, error) {
+	if e.SpecVersion != "" {
+		return json.Marshal(cloudEvent{
+			SpecVersion:     e.SpecVersion,
+			ID:              e.ID,
+			Source:          "aiwatch",
+			Type:            "io.aiwatch." + e.Type,
+			Time:            e.Timestamp,
+			DataContentType: "application/json",
+			DataSchema:      e.Schema,
+			Data:            e.Data,
+		})
+	}
+	type envelope Event
+	return json.Marshal(envelope(e))
+}
@@ -0,0 +1,128 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// receiver records the path and body of every batch posted to it
+type receiver struct {
+	mu      sync.Mutex
+	batches map[string][]map[string]any
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var batch []map[string]any
+	json.NewDecoder(r.Body).Decode(&batch)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.batches[r.URL.Path] = append(rc.batches[r.URL.Path], batch...)
+}
+
+func published() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{Name: "published"}, []string{"type", "result"})
+}
+
+func TestBusRoutesEventTypesToTopics(t *testing.T) {
+	rc := &receiver{batches: make(map[string][]map[string]any)}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	topics, err := ParseTopics("error=chat-errors, completion_finished = billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, err := ParseTypes("request_received,chat.error,completion_finished")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := BusMetrics{Published: published()}
+	bus, err := NewBus(BusConfig{URL: server.URL + "/chat", Topics: topics, Types: types}, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Start(context.Background())
+
+	lifecycle := Lifecycle{RequestID: "r1", Endpoint: "/chat", Model: "m"}
+	bus.Publish(RequestReceived, lifecycle)
+	bus.Publish(FirstToken, lifecycle) // not in types
+	bus.Publish(Error, Lifecycle{RequestID: "r1", Model: "m", ErrorCode: "upstream_timeout"})
+	bus.Publish(CompletionFinished, Lifecycle{RequestID: "r2", Model: "m", TokensOut: 12})
+	bus.Close()
+
+	for path, want := range map[string]string{"/chat": RequestReceived, "/chat-errors": Error, "/billing": CompletionFinished} {
+		batch := rc.batches[path]
+		if len(batch) != 1 || batch[0]["type"] != want {
+			t.Errorf("%s got %v, want one %s event", path, batch, want)
+			continue
+		}
+		if schema := batch[0]["schema"]; schema != want+"/"+SchemaVersion {
+			t.Errorf("%s schema = %v", path, schema)
+		}
+	}
+	if len(rc.batches) != 3 {
+		t.Errorf("unexpected destinations %v", rc.batches)
+	}
+	if got := testutil.ToFloat64(metrics.Published.WithLabelValues(CompletionFinished, "delivered")); got != 1 {
+		t.Errorf("delivered = %v, want 1", got)
+	}
+}
+
+func TestBusPublishesCloudEvents(t *testing.T) {
+	rc := &receiver{batches: make(map[string][]map[string]any)}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	bus, err := NewBus(BusConfig{URL: server.URL, Format: FormatCloudEvents}, BusMetrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Start(context.Background())
+	bus.Publish(FirstToken, Lifecycle{Model: "m", FirstTokenMs: 120})
+	bus.Close()
+
+	batch := rc.batches["/"]
+	if len(batch) != 1 {
+		t.Fatalf("got %v", rc.batches)
+	}
+	event := batch[0]
+	if event["specversion"] != "1.0" || event["type"] != "io.aiwatch."+FirstToken || event["source"] != "aiwatch" {
+		t.Errorf("not a CloudEvent: %v", event)
+	}
+	if data, _ := event["data"].(map[string]any); data["time_to_first_token_ms"] != 120.0 {
+		t.Errorf("data = %v", event["data"])
+	}
+}
+
+func TestBusDropsWhenQueueIsFull(t *testing.T) {
+	metrics := BusMetrics{Published: published()}
+	bus, err := NewBus(BusConfig{URL: "http://localhost:1/", QueueSize: 1}, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not started, so nothing drains the queue
+	bus.Publish(RequestReceived, Lifecycle{})
+	bus.Publish(RequestReceived, Lifecycle{})
+	if got := testutil.ToFloat64(metrics.Published.WithLabelValues(RequestReceived, "dropped")); got != 1 {
+		t.Errorf("dropped = %v, want 1", got)
+	}
+}
+
+func TestParseRejectsUnknownTypes(t *testing.T) {
+	if _, err := ParseTypes("request_received,billing"); err == nil {
+		t.Error("expected an error for an unknown type")
+	}
+	if _, err := ParseTopics("error"); err == nil {
+		t.Error("expected an error for a pair without a topic")
+	}
+	if _, err := NewBus(BusConfig{URL: "http://localhost/", Format: "avro"}, BusMetrics{}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,188 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is a structured record emitted by aiwatch for downstream consumers
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Timestamp time.Time       `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+	Schema    string          `json:"schema,omitempty"` // type and version of Data
+
+	// SpecVersion, when set, encodes the event as a CloudEvent
+	SpecVersion string `json:"-"`
+}
+
+// New creates an event of the given type with a JSON-encoded payload
+func New(eventType string, data interface{}) (Event, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+
+	return Event{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		Timestamp: time.Now().UTC(),
+		Data:      payload,
+	}, nil
+}
+
+// Sink delivers batches of events to an external system
+type Sink interface {
+	// Name identifies the sink in logs and metric labels
+	Name() string
+	// Send delivers the events, returning an error if any of them could not be delivered
+	Send(ctx context.Context, events []Event) error
+}
+
+// NopSink discards all events
+type NopSink struct{}
+
+// Name returns the sink name
+func (NopSink) Name() string { return "nop" }
+
+// Send discards the events
+func (NopSink) Send(ctx context.Context, events []Event) error { return nil }
+
+// HTTPSink posts event batches as a JSON array to an HTTP endpoint
+type HTTPSink struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+// Name returns the sink name
+func (s *HTTPSink) Name() string { return "http" }
+
+// Send posts the events to the configured URL
+func (s *HTTPSink) Send(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sink %s returned status %d", s.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// FileSink appends events as JSON lines to a daily file in a directory,
+// which suits object storage mounts and log shippers alike
+type FileSink struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+// Name returns the sink name
+func (s *FileSink) Name() string { return "file" }
+
+// Send appends the events to today's file
+func (s *FileSink) Send(ctx context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.Dir, fmt.Sprintf("events-%s.jsonl", time.Now().UTC().Format("2006-01-02")))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// NewSink builds a sink from a URL-style specification:
+// "http(s)://host/path" posts to an HTTP endpoint, "file:///dir" writes JSONL files,
+// "nats://[user:pass@]host:4222/subject" publishes to NATS,
+// "kafka://rest-proxy:8082/topic" produces through a Kafka REST Proxy
+// ("kafka+https://" for TLS), and an empty specification disables delivery
+func NewSink(spec string) (Sink, error) {
+	if spec == "" {
+		return NopSink{}, nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink %q: %w", spec, err)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return &HTTPSink{URL: spec}, nil
+	case "file":
+		return &FileSink{Dir: u.Path}, nil
+	case "nats":
+		subject := strings.TrimPrefix(u.Path, "/")
+		if subject == "" {
+			return nil, fmt.Errorf("nats sink %q needs a subject", spec)
+		}
+		port := u.Port()
+		if port == "" {
+			port = "4222"
+		}
+		pass, _ := u.User.Password()
+		return &NATSSink{Addr: net.JoinHostPort(u.Hostname(), port), Subject: subject, User: u.User.Username(), Pass: pass}, nil
+	case "kafka", "kafka+http", "kafka+https":
+		topic := strings.TrimPrefix(u.Path, "/")
+		if topic == "" {
+			return nil, fmt.Errorf("kafka sink %q needs a topic", spec)
+		}
+		scheme := "http"
+		if strings.HasSuffix(strings.ToLower(u.Scheme), "https") {
+			scheme = "https"
+		}
+		return &KafkaSink{ProxyURL: scheme + "://" + u.Host, Topic: topic}, nil
+	default:
+		return nil, fmt.Errorf("unsupported sink scheme %q", u.Scheme)
+	}
+}
@@ -0,0 +1,307 @@
+package experiments
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrNotFound is returned for unknown experiment IDs
+var ErrNotFound = errors.New("experiment not found")
+
+// Variant is one arm of an experiment
+type Variant struct {
+	Name   string `json:"name"`
+	Model  string `json:"model"`
+	Weight int    `json:"weight"` // relative share of traffic
+}
+
+// Experiment splits chat traffic between models
+type Experiment struct {
+	ID          string    `json:"id"`
+	Description string    `json:"description,omitempty"`
+	Enabled     bool      `json:"enabled"`
+	Variants    []Variant `json:"variants"`
+}
+
+// Config lists the configured experiments
+type Config struct {
+	Experiments []Experiment `json:"experiments"`
+}
+
+// LoadConfig reads a JSON experiments config. An empty path returns an empty config.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read experiments config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse experiments config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Metrics holds the collectors experiments report to
+type Metrics struct {
+	Requests   *prometheus.CounterVec   // labels: experiment, variant, model
+	Latency    *prometheus.HistogramVec // labels: experiment, variant
+	FirstToken *prometheus.HistogramVec // labels: experiment, variant
+	Tokens     *prometheus.CounterVec   // labels: experiment, variant, direction
+	Feedback   *prometheus.CounterVec   // labels: experiment, variant, rating
+}
+
+// Assignment is the variant chosen for a request
+type Assignment struct {
+	Experiment string `json:"experiment"`
+	Variant    string `json:"variant"`
+	Model      string `json:"model"`
+}
+
+// Outcome is what a chat request served by a variant measured
+type Outcome struct {
+	Latency    time.Duration
+	FirstToken time.Duration // zero if no token was produced
+	TokensIn   int
+	TokensOut  int
+	Failed     bool
+}
+
+// Manager assigns requests to experiment variants and aggregates results
+type Manager struct {
+	experiments []Experiment
+	metrics     Metrics
+
+	mu    sync.Mutex
+	stats map[string]map[string]*variantStats // experiment -> variant -> stats
+}
+
+// New validates cfg and creates a manager
+func New(cfg Config, metrics Metrics) (*Manager, error) {
+	m := &Manager{
+		experiments: []Experiment{},
+		metrics:     metrics,
+		stats:       make(map[string]map[string]*variantStats),
+	}
+	seen := make(map[string]bool)
+	for _, exp := range cfg.Experiments {
+		if exp.ID == "" || seen[exp.ID] {
+			return nil, fmt.Errorf("experiment IDs must be unique and non-empty (got %q)", exp.ID)
+		}
+		seen[exp.ID] = true
+		if len(exp.Variants) < 2 {
+			return nil, fmt.Errorf("experiment %s needs at least two variants", exp.ID)
+		}
+
+		m.stats[exp.ID] = make(map[string]*variantStats)
+		for _, v := range exp.Variants {
+			if v.Name == "" || v.Model == "" || v.Weight <= 0 {
+				return nil, fmt.Errorf("experiment %s: variants need a name, a model and a positive weight", exp.ID)
+			}
+			if m.stats[exp.ID][v.Name] != nil {
+				return nil, fmt.Errorf("experiment %s: duplicate variant %s", exp.ID, v.Name)
+			}
+			m.stats[exp.ID][v.Name] = &variantStats{}
+		}
+		m.experiments = append(m.experiments, exp)
+	}
+	return m, nil
+}
+
+// List returns the configured experiments
+func (m *Manager) List() []Experiment {
+	return m.experiments
+}
+
+// Assign picks a variant of the first enabled experiment for key, which
+// should be stable for a user (e.g. the session ID) so they keep seeing the
+// same variant. It returns false when no experiment is running.
+func (m *Manager) Assign(key string) (Assignment, bool) {
+	for _, exp := range m.experiments {
+		if !exp.Enabled {
+			continue
+		}
+
+		total := 0
+		for _, v := range exp.Variants {
+			total += v.Weight
+		}
+		h := fnv.New32a()
+		h.Write([]byte(exp.ID + "/" + key))
+		point := int(h.Sum32() % uint32(total))
+
+		for _, v := range exp.Variants {
+			if point < v.Weight {
+				a := Assignment{Experiment: exp.ID, Variant: v.Name, Model: v.Model}
+				m.metrics.Requests.WithLabelValues(a.Experiment, a.Variant, a.Model).Inc()
+				return a, true
+			}
+			point -= v.Weight
+		}
+	}
+	return Assignment{}, false
+}
+
+// Record adds the outcome of a request served by a
+func (m *Manager) Record(a Assignment, o Outcome) {
+	m.metrics.Latency.WithLabelValues(a.Experiment, a.Variant).Observe(o.Latency.Seconds())
+	if o.FirstToken > 0 {
+		m.metrics.FirstToken.WithLabelValues(a.Experiment, a.Variant).Observe(o.FirstToken.Seconds())
+	}
+	m.metrics.Tokens.WithLabelValues(a.Experiment, a.Variant, "input").Add(float64(o.TokensIn))
+	m.metrics.Tokens.WithLabelValues(a.Experiment, a.Variant, "output").Add(float64(o.TokensOut))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stats := m.variant(a.Experiment, a.Variant); stats != nil {
+		stats.record(o)
+	}
+}
+
+// RecordFeedback attributes a user rating (1 or -1) to a variant
+func (m *Manager) RecordFeedback(experiment, variant string, rating int) {
+	label := "positive"
+	if rating < 0 {
+		label = "negative"
+	}
+	m.metrics.Feedback.WithLabelValues(experiment, variant, label).Inc()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stats := m.variant(experiment, variant); stats != nil {
+		if rating > 0 {
+			stats.positive++
+		} else {
+			stats.negative++
+		}
+	}
+}
+
+// variant returns the stats for a variant. Callers must hold m.mu.
+func (m *Manager) variant(experiment, variant string) *variantStats {
+	if byVariant, ok := m.stats[experiment]; ok {
+		return byVariant[variant]
+	}
+	return nil
+}
+
+// VariantResults are the comparative stats of one variant
+type VariantResults struct {
+	Variant          string  `json:"variant"`
+	Model            string  `json:"model"`
+	Weight           int     `json:"weight"`
+	Requests         int     `json:"requests"`
+	Errors           int     `json:"errors"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+	P50LatencyMs     float64 `json:"p50_latency_ms"`
+	P95LatencyMs     float64 `json:"p95_latency_ms"`
+	AvgFirstTokenMs  float64 `json:"avg_first_token_ms"`
+	AvgTokensIn      float64 `json:"avg_tokens_in"`
+	AvgTokensOut     float64 `json:"avg_tokens_out"`
+	ThumbsUp         int     `json:"thumbs_up"`
+	ThumbsDown       int     `json:"thumbs_down"`
+	SatisfactionRate float64 `json:"satisfaction_rate"` // share of ratings that were positive
+}
+
+// Results summarizes an experiment since the process started
+type Results struct {
+	Experiment Experiment       `json:"experiment"`
+	Variants   []VariantResults `json:"variants"`
+}
+
+// Results returns the comparative stats for an experiment
+func (m *Manager) Results(id string) (Results, error) {
+	for _, exp := range m.experiments {
+		if exp.ID != id {
+			continue
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		results := Results{Experiment: exp}
+		for _, v := range exp.Variants {
+			r := m.stats[exp.ID][v.Name].results()
+			r.Variant, r.Model, r.Weight = v.Name, v.Model, v.Weight
+			results.Variants = append(results.Variants, r)
+		}
+		return results, nil
+	}
+	return Results{}, ErrNotFound
+}
+
+// latencySamples bounds the latencies kept per variant for percentiles
+const latencySamples = 1000
+
+// variantStats aggregates outcomes in memory
+type variantStats struct {
+	requests, errors    int
+	latencyTotal        time.Duration
+	firstTokenTotal     time.Duration
+	firstTokenCount     int
+	tokensIn, tokensOut int
+	latencies           []time.Duration // ring buffer of recent latencies
+	next                int
+	positive, negative  int
+}
+
+func (s *variantStats) record(o Outcome) {
+	s.requests++
+	if o.Failed {
+		s.errors++
+		return
+	}
+	s.latencyTotal += o.Latency
+	if o.FirstToken > 0 {
+		s.firstTokenTotal += o.FirstToken
+		s.firstTokenCount++
+	}
+	s.tokensIn += o.TokensIn
+	s.tokensOut += o.TokensOut
+
+	if len(s.latencies) < latencySamples {
+		s.latencies = append(s.latencies, o.Latency)
+	} else {
+		s.latencies[s.next] = o.Latency
+		s.next = (s.next + 1) % latencySamples
+	}
+}
+
+func (s *variantStats) results() VariantResults {
+	r := VariantResults{
+		Requests:   s.requests,
+		Errors:     s.errors,
+		ThumbsUp:   s.positive,
+		ThumbsDown: s.negative,
+	}
+	if succeeded := s.requests - s.errors; succeeded > 0 {
+		r.AvgLatencyMs = float64(s.latencyTotal.Milliseconds()) / float64(succeeded)
+		r.AvgTokensIn = float64(s.tokensIn) / float64(succeeded)
+		r.AvgTokensOut = float64(s.tokensOut) / float64(succeeded)
+	}
+	if s.firstTokenCount > 0 {
+		r.AvgFirstTokenMs = float64(s.firstTokenTotal.Milliseconds()) / float64(s.firstTokenCount)
+	}
+	if rated := s.positive + s.negative; rated > 0 {
+		r.SatisfactionRate = float64(s.positive) / float64(rated)
+	}
+
+	if len(s.latencies) > 0 {
+		sorted := append([]time.Duration(nil), s.latencies...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		r.P50LatencyMs = float64(sorted[len(sorted)*50/100].Milliseconds())
+		r.P95LatencyMs = float64(sorted[len(sorted)*95/100].Milliseconds())
+	}
+	return r
+}
@@ -0,0 +1,83 @@
+package experiments
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	m, err := New(Config{Experiments: []Experiment{{
+		ID:      "llama-vs-qwen",
+		Enabled: true,
+		Variants: []Variant{
+			{Name: "control", Model: "ai/llama3.2", Weight: 80},
+			{Name: "candidate", Model: "ai/qwen3", Weight: 20},
+		},
+	}}}, Metrics{
+		Requests:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"experiment", "variant", "model"}),
+		Latency:    prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"experiment", "variant"}),
+		FirstToken: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "first_token"}, []string{"experiment", "variant"}),
+		Tokens:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "tokens"}, []string{"experiment", "variant", "direction"}),
+		Feedback:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "feedback"}, []string{"experiment", "variant", "rating"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestAssignIsStickyAndWeighted(t *testing.T) {
+	m := newTestManager(t)
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("session-%d", i)
+		a, ok := m.Assign(key)
+		if !ok {
+			t.Fatal("expected an assignment")
+		}
+		if again, _ := m.Assign(key); again != a {
+			t.Fatalf("assignment for %s changed from %v to %v", key, a, again)
+		}
+		counts[a.Variant]++
+	}
+
+	if share := float64(counts["candidate"]) / 10000; share < 0.17 || share > 0.23 {
+		t.Errorf("expected about 20%% candidate traffic, got %.1f%%", share*100)
+	}
+}
+
+func TestResults(t *testing.T) {
+	m := newTestManager(t)
+	a := Assignment{Experiment: "llama-vs-qwen", Variant: "candidate", Model: "ai/qwen3"}
+
+	m.Record(a, Outcome{Latency: time.Second, FirstToken: 100 * time.Millisecond, TokensIn: 10, TokensOut: 30})
+	m.Record(a, Outcome{Latency: 3 * time.Second, TokensIn: 20, TokensOut: 50})
+	m.Record(a, Outcome{Failed: true})
+	m.RecordFeedback(a.Experiment, a.Variant, 1)
+	m.RecordFeedback(a.Experiment, a.Variant, -1)
+	m.RecordFeedback(a.Experiment, a.Variant, 1)
+
+	results, err := m.Results("llama-vs-qwen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := results.Variants[1]
+	if got.Variant != "candidate" || got.Requests != 3 || got.Errors != 1 {
+		t.Fatalf("unexpected counts: %+v", got)
+	}
+	if got.AvgLatencyMs != 2000 || got.AvgTokensOut != 40 || got.AvgFirstTokenMs != 100 {
+		t.Errorf("unexpected averages: %+v", got)
+	}
+	if got.ThumbsUp != 2 || got.ThumbsDown != 1 {
+		t.Errorf("unexpected feedback: %+v", got)
+	}
+
+	if _, err := m.Results("missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
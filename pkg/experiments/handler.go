@@ -0,0 +1,32 @@
+package experiments
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+)
+
+// Handler serves GET /experiments and GET /experiments/{id}/results
+func (m *Manager) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /experiments", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, m.List())
+	})
+	mux.HandleFunc("GET /experiments/{id}/results", func(w http.ResponseWriter, r *http.Request) {
+		results, err := m.Results(r.PathValue("id"))
+		if err != nil {
+			apierror.Write(w, r, apierror.NotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, results)
+	})
+	return mux
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
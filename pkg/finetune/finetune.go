@@ -0,0 +1,187 @@
+// Package finetune exports stored conversations as JSONL training data, so
+// well-rated chats can be turned into a fine-tuning set without a custom
+// extractor.
+package finetune
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/history"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/tenants"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Export formats
+const (
+	// OpenAI is the chat format of the OpenAI fine-tuning API, which
+	// LLaMA-Factory also reads as its "openai" dataset format:
+	// {"messages": [{"role": "user", "content": "..."}, ...]}
+	OpenAI = "openai"
+	// ShareGPT is LLaMA-Factory's "sharegpt" format:
+	// {"conversations": [{"from": "human", "value": "..."}, ...], "system": "..."}
+	ShareGPT = "sharegpt"
+)
+
+// Metrics holds the collectors the exporter reports to
+type Metrics struct {
+	Examples *prometheus.CounterVec // labels: format
+}
+
+// Message is one turn of an OpenAI example. Weight 0 keeps a response as
+// context without training on it.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	Weight  *int   `json:"weight,omitempty"`
+}
+
+// Example is one OpenAI training example
+type Example struct {
+	Messages []Message `json:"messages"`
+}
+
+// Turn is one turn of a ShareGPT example
+type Turn struct {
+	From  string `json:"from"`
+	Value string `json:"value"`
+}
+
+// ShareGPTExample is one ShareGPT training example
+type ShareGPTExample struct {
+	Conversations []Turn `json:"conversations"`
+	System        string `json:"system,omitempty"`
+}
+
+// trainable returns c's messages up to its last response, or nil if it has
+// no exchange to learn from
+func trainable(c history.Conversation) []history.Message {
+	messages := c.Messages
+	for len(messages) > 0 && messages[len(messages)-1].Role != "assistant" {
+		messages = messages[:len(messages)-1]
+	}
+	for _, msg := range messages {
+		if msg.Role == "user" {
+			return messages
+		}
+	}
+	return nil
+}
+
+// ToOpenAI converts a conversation to an OpenAI example. Responses rated
+// thumbs-down get weight 0. It reports false if there is nothing to train on.
+func ToOpenAI(c history.Conversation) (Example, bool) {
+	messages := trainable(c)
+	if messages == nil {
+		return Example{}, false
+	}
+	var ex Example
+	for _, msg := range messages {
+		m := Message{Role: msg.Role, Content: msg.Content}
+		if msg.Role == "assistant" && msg.Rating < 0 {
+			m.Weight = new(int)
+		}
+		ex.Messages = append(ex.Messages, m)
+	}
+	return ex, true
+}
+
+// ToShareGPT converts a conversation to a ShareGPT example. The format has
+// no weights, so the conversation is cut before its first thumbs-down
+// response. It reports false if there is nothing to train on.
+func ToShareGPT(c history.Conversation) (ShareGPTExample, bool) {
+	var ex ShareGPTExample
+turns:
+	for _, msg := range trainable(c) {
+		switch msg.Role {
+		case "system":
+			ex.System = msg.Content
+		case "user":
+			ex.Conversations = append(ex.Conversations, Turn{From: "human", Value: msg.Content})
+		case "assistant":
+			if msg.Rating < 0 {
+				break turns
+			}
+			ex.Conversations = append(ex.Conversations, Turn{From: "gpt", Value: msg.Content})
+		}
+	}
+	// Drop a prompt left unanswered by the cut
+	if n := len(ex.Conversations); n > 0 && ex.Conversations[n-1].From == "human" {
+		ex.Conversations = ex.Conversations[:n-1]
+	}
+	return ex, len(ex.Conversations) > 0
+}
+
+// Handler serves GET /export/finetune, streaming the caller's tenant's
+// conversations as JSONL, oldest first. Query parameters:
+//
+//	format        openai (default) or sharegpt
+//	rating        1, -1 or 0 (unrated); rating=1 exports thumbs-up conversations
+//	model, user   only conversations with this model or user
+//	from, to      range of last update, as RFC 3339 times or dates
+//	limit         at most this many examples
+func Handler(store *history.Store, metrics Metrics) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /export/finetune", func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		format := params.Get("format")
+		switch format {
+		case "":
+			format = OpenAI
+		case OpenAI, ShareGPT:
+		default:
+			apierror.Write(w, r, apierror.InvalidRequest, "format must be openai or sharegpt")
+			return
+		}
+		q, err := history.ParseQuery(params)
+		if err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, err.Error())
+			return
+		}
+		q.Tenant = tenants.FromContext(r.Context())
+
+		convs, err := store.Conversations(q.Filter)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal, err.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="finetune-`+format+`-`+time.Now().UTC().Format(time.DateOnly)+`.jsonl"`)
+		flusher, _ := w.(http.Flusher)
+		enc := json.NewEncoder(w)
+		exported := 0
+		for _, c := range convs {
+			if q.Limit > 0 && exported == q.Limit {
+				break
+			}
+			var example interface{}
+			var ok bool
+			if format == ShareGPT {
+				example, ok = ToShareGPT(c)
+			} else {
+				example, ok = ToOpenAI(c)
+			}
+			if !ok {
+				continue
+			}
+			if err := enc.Encode(example); err != nil {
+				// The client went away; the status is already sent
+				log := logger.GetLogger()
+				log.Debug().Err(err).Msg("Fine-tuning export interrupted")
+				break
+			}
+			exported++
+			if flusher != nil && exported%100 == 0 {
+				flusher.Flush()
+			}
+		}
+		if metrics.Examples != nil {
+			metrics.Examples.WithLabelValues(format).Add(float64(exported))
+		}
+	})
+	return mux
+}
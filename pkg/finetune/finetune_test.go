@@ -0,0 +1,114 @@
+package finetune
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ajeetraina/aiwatch/pkg/history"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newStore(t *testing.T) *history.Store {
+	t.Helper()
+	store := history.New(nil, 1<<20, history.Metrics{
+		Lookups:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "lookups"}, []string{"result"}),
+		Evictions:   prometheus.NewCounter(prometheus.CounterOpts{Name: "evictions"}),
+		MemoryBytes: prometheus.NewGauge(prometheus.GaugeOpts{Name: "memory_bytes"}),
+		Entries:     prometheus.NewGauge(prometheus.GaugeOpts{Name: "entries"}),
+	})
+	store.Append("liked", "", "ai/llama",
+		history.Message{Role: "system", Content: "Be brief."},
+		history.Message{Role: "user", Content: "Hi"},
+		history.Message{Role: "assistant", Content: "Hello!"},
+		history.Message{Role: "user", Content: "2+2?"},
+		history.Message{Role: "assistant", Content: "5", Rating: -1},
+		history.Message{Role: "user", Content: "Are you sure?"})
+	store.Update("liked", func(c *history.Conversation) { c.Rating = 1 })
+	store.Append("unrated", "", "ai/gemma",
+		history.Message{Role: "user", Content: "Name a colour"},
+		history.Message{Role: "assistant", Content: "Blue"})
+	store.Append("unanswered", "", "ai/llama", history.Message{Role: "user", Content: "Anyone there?"})
+	return store
+}
+
+func TestToOpenAIWeightsDownRatedResponses(t *testing.T) {
+	c, _ := newStore(t).Get("liked")
+	ex, ok := ToOpenAI(c)
+	if !ok {
+		t.Fatal("expected an example")
+	}
+	// The unanswered trailing prompt is dropped
+	if len(ex.Messages) != 5 || ex.Messages[4].Content != "5" {
+		t.Fatalf("unexpected messages %+v", ex.Messages)
+	}
+	if ex.Messages[2].Weight != nil || ex.Messages[4].Weight == nil || *ex.Messages[4].Weight != 0 {
+		t.Errorf("expected weight 0 on the down-rated response only: %+v", ex.Messages)
+	}
+}
+
+func TestToShareGPTCutsAtDownRatedResponse(t *testing.T) {
+	c, _ := newStore(t).Get("liked")
+	ex, ok := ToShareGPT(c)
+	if !ok {
+		t.Fatal("expected an example")
+	}
+	want := []Turn{{From: "human", Value: "Hi"}, {From: "gpt", Value: "Hello!"}}
+	if ex.System != "Be brief." || len(ex.Conversations) != len(want) || ex.Conversations[0] != want[0] || ex.Conversations[1] != want[1] {
+		t.Errorf("unexpected example %+v", ex)
+	}
+
+	c, _ = newStore(t).Get("unanswered")
+	if _, ok := ToShareGPT(c); ok {
+		t.Error("expected no example from an unanswered conversation")
+	}
+}
+
+func TestHandlerStreamsFilteredExamples(t *testing.T) {
+	metrics := Metrics{Examples: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "examples"}, []string{"format"})}
+	handler := Handler(newStore(t), metrics)
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	rec := get("/export/finetune")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("export = %d %v", rec.Code, rec.Header())
+	}
+	var lines []Example
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var ex Example
+		if err := json.Unmarshal(scanner.Bytes(), &ex); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, ex)
+	}
+	if len(lines) != 2 {
+		t.Errorf("expected the two answered conversations, got %+v", lines)
+	}
+
+	rec = get("/export/finetune?rating=1&format=sharegpt")
+	if body := strings.TrimSpace(rec.Body.String()); strings.Count(body, "\n") != 0 || !strings.Contains(body, `"from":"human"`) {
+		t.Errorf("thumbs-up sharegpt export = %q", body)
+	}
+	rec = get("/export/finetune?model=ai/gemma")
+	if !strings.Contains(rec.Body.String(), "Blue") || strings.Contains(rec.Body.String(), "Hello") {
+		t.Errorf("model filtered export = %q", rec.Body.String())
+	}
+	if got := testutil.ToFloat64(metrics.Examples.WithLabelValues(OpenAI)); got != 3 {
+		t.Errorf("expected 3 openai examples counted, got %v", got)
+	}
+
+	for _, target := range []string{"/export/finetune?format=alpaca", "/export/finetune?rating=5", "/export/finetune?from=soon"} {
+		if rec := get(target); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", target, rec.Code)
+		}
+	}
+}
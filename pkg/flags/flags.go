@@ -0,0 +1,83 @@
+package flags
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Flags is a thread-safe set of named boolean feature flags that can be
+// toggled at runtime
+type Flags struct {
+	mu    sync.RWMutex
+	flags map[string]bool
+}
+
+// Default is the process-wide feature flag set
+var Default = New()
+
+// New creates an empty flag set
+func New() *Flags {
+	return &Flags{flags: make(map[string]bool)}
+}
+
+// Register declares a flag with a default value. The FEATURE_<NAME> environment
+// variable overrides the default, e.g. FEATURE_MARKDOWN_DETECTION=false.
+func (f *Flags) Register(name string, defaultValue bool) {
+	value := defaultValue
+	if env, err := strconv.ParseBool(os.Getenv(EnvKey(name))); err == nil {
+		value = env
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flags[name] = value
+}
+
+// EnvKey returns the environment variable overriding a flag
+func EnvKey(name string) string {
+	return "FEATURE_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// Enabled reports whether a flag is on. Unknown flags are off.
+func (f *Flags) Enabled(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.flags[name]
+}
+
+// Set changes a registered flag, returning false if the flag is unknown
+func (f *Flags) Set(name string, enabled bool) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.flags[name]; !ok {
+		return false
+	}
+	f.flags[name] = enabled
+	return true
+}
+
+// All returns a snapshot of every flag
+func (f *Flags) All() map[string]bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	snapshot := make(map[string]bool, len(f.flags))
+	for k, v := range f.flags {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// Names returns the registered flag names in sorted order
+func (f *Flags) Names() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	names := make([]string, 0, len(f.flags))
+	for k := range f.flags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
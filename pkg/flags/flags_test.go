@@ -0,0 +1,57 @@
+package flags
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvKey(t *testing.T) {
+	cases := map[string]string{
+		"archive":            "FEATURE_ARCHIVE",
+		"markdown-detection": "FEATURE_MARKDOWN_DETECTION",
+		"stop.enforcement":   "FEATURE_STOP_ENFORCEMENT",
+	}
+	for name, want := range cases {
+		if got := EnvKey(name); got != want {
+			t.Errorf("EnvKey(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestRegisterReadsEnvironmentOverrides(t *testing.T) {
+	t.Setenv("FEATURE_ON_BY_ENV", "true")
+	t.Setenv("FEATURE_OFF_BY_ENV", "0")
+	t.Setenv("FEATURE_GARBLED", "maybe")
+
+	f := New()
+	f.Register("on-by-env", false)
+	f.Register("off_by_env", true)
+	f.Register("garbled", true)
+	f.Register("plain", false)
+
+	want := map[string]bool{"on-by-env": true, "off_by_env": false, "garbled": true, "plain": false}
+	if got := f.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("All() = %v, want %v", got, want)
+	}
+	if got := f.Names(); !reflect.DeepEqual(got, []string{"garbled", "off_by_env", "on-by-env", "plain"}) {
+		t.Errorf("Names() = %v", got)
+	}
+}
+
+func TestSetOnlyChangesRegisteredFlags(t *testing.T) {
+	f := New()
+	f.Register("archive", false)
+
+	if !f.Set("archive", true) || !f.Enabled("archive") {
+		t.Error("expected a registered flag to be set")
+	}
+	if f.Set("unknown", true) || f.Enabled("unknown") {
+		t.Error("expected an unknown flag to stay off and unregistered")
+	}
+
+	snapshot := f.All()
+	snapshot["archive"] = false
+	if !f.Enabled("archive") {
+		t.Error("expected All to return a copy")
+	}
+}
@@ -0,0 +1,78 @@
+package formatting
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ajeetraina/aiwatch/pkg/prompts"
+)
+
+// Format is the output format a chat asks the model for
+type Format string
+
+// Supported formats. None leaves the model's output alone.
+const (
+	None     Format = ""
+	Markdown Format = "markdown"
+	HTML     Format = "html"
+	Plain    Format = "plain"
+	JSON     Format = "json"
+)
+
+// Parse resolves a requested format name
+func Parse(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "":
+		return None, nil
+	case "markdown", "md":
+		return Markdown, nil
+	case "html":
+		return HTML, nil
+	case "plain", "text":
+		return Plain, nil
+	case "json":
+		return JSON, nil
+	}
+	return None, fmt.Errorf("unsupported format %q", name)
+}
+
+// phrases that ask for a format in the message itself, checked in order so
+// "without markdown" isn't read as a request for markdown
+var phrases = []struct {
+	format Format
+	text   []string
+}{
+	{Plain, []string{"in plain text", "as plain text", "without markdown", "no markdown"}},
+	{Markdown, []string{"in markdown", "using markdown", "as markdown"}},
+	{HTML, []string{"in html", "as html", "using html"}},
+	{JSON, []string{"in json", "as json", "json format"}},
+}
+
+// Detect returns the format a message asks for in words, or None
+func Detect(message string) Format {
+	lower := strings.ToLower(message)
+	for _, p := range phrases {
+		for _, text := range p.text {
+			if strings.Contains(lower, text) {
+				return p.format
+			}
+		}
+	}
+	return None
+}
+
+// Template returns the name of the system prompt template asking for the
+// format, or "" when there is none
+func (f Format) Template() string {
+	switch f {
+	case Markdown:
+		return prompts.MarkdownTemplate
+	case HTML:
+		return prompts.HTMLTemplate
+	case Plain:
+		return prompts.PlainTemplate
+	case JSON:
+		return prompts.JSONTemplate
+	}
+	return ""
+}
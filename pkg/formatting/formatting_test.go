@@ -0,0 +1,70 @@
+package formatting
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAndDetect(t *testing.T) {
+	if f, err := Parse("Text"); err != nil || f != Plain {
+		t.Errorf("Parse(Text) = %q, %v", f, err)
+	}
+	if _, err := Parse("xml"); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+
+	cases := map[string]Format{
+		"Explain recursion in Markdown":  Markdown,
+		"Reply without markdown please":  Plain,
+		"List three colours as JSON":     JSON,
+		"Write a product card in HTML":   HTML,
+		"What is the capital of France?": None,
+	}
+	for message, want := range cases {
+		if got := Detect(message); got != want {
+			t.Errorf("Detect(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
+
+// feed streams text through a Stream in small chunks
+func feed(s *Stream, text string) string {
+	var out strings.Builder
+	for len(text) > 0 {
+		n := min(5, len(text))
+		out.WriteString(s.Write(text[:n]))
+		text = text[n:]
+	}
+	out.WriteString(s.Flush())
+	return out.String()
+}
+
+func TestPlainStripsMarkdown(t *testing.T) {
+	input := "# Title\n\nSome **bold** and *italic* text with `code`.\n* item one\n> quoted\n---\nSee [docs](https://example.com).\n```go\nx := *p\n```\n"
+	want := "Title\n\nSome bold and italic text with code.\n- item one\nquoted\nSee docs (https://example.com).\nx := *p\n"
+	if got := feed(NewStream(Plain), input); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestHTMLIsSanitized(t *testing.T) {
+	input := "```html\n<p onclick=\"steal()\">Hi</p><script>\nalert(1)\n</script>\n<a href=\"javascript:alert(1)\">x</a><img\n src=\"a.png\" onerror=alert(1)>\n```"
+	got := feed(NewStream(HTML), input)
+	for _, bad := range []string{"script", "alert", "onclick", "onerror", "javascript:", "```"} {
+		if strings.Contains(got, bad) {
+			t.Errorf("output still contains %q: %q", bad, got)
+		}
+	}
+	if !strings.Contains(got, "<p>Hi</p>") || !strings.Contains(got, `<a href="#">x</a>`) || !strings.Contains(got, `src="a.png"`) {
+		t.Errorf("expected the safe markup to be kept, got %q", got)
+	}
+}
+
+func TestJSONDropsFences(t *testing.T) {
+	if got := feed(NewStream(JSON), "```json\n{\"a\": 1}\n```"); got != "{\"a\": 1}\n" {
+		t.Errorf("got %q", got)
+	}
+	if NewStream(Markdown) != nil || NewStream(None) != nil {
+		t.Error("expected markdown and unformatted output to pass through")
+	}
+}
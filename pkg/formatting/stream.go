@@ -0,0 +1,147 @@
+package formatting
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Stream post-processes generated text for its format while it streams.
+// Text is released a line at a time, so conversions never see half a line
+// or half a tag. Create one per response with NewStream.
+type Stream struct {
+	format  Format
+	pending strings.Builder
+	inFence bool   // inside a fenced code block
+	skip    string // element whose content is being dropped, for HTML
+}
+
+// NewStream starts post-processing a response in format f. It returns nil
+// for formats that are passed through unchanged.
+func NewStream(f Format) *Stream {
+	switch f {
+	case HTML, Plain, JSON:
+		return &Stream{format: f}
+	}
+	return nil
+}
+
+// Write buffers a chunk of generated text and returns the processed text
+// that is ready to send
+func (s *Stream) Write(chunk string) string {
+	s.pending.WriteString(chunk)
+	window := s.pending.String()
+
+	cut := strings.LastIndexByte(window, '\n') + 1
+	// Never split an HTML tag that continues on the next line
+	if s.format == HTML {
+		if open := strings.LastIndexByte(window[:cut], '<'); open > strings.LastIndexByte(window[:cut], '>') {
+			cut = open
+		}
+	}
+	if cut <= 0 {
+		return ""
+	}
+
+	ready := window[:cut]
+	s.pending.Reset()
+	s.pending.WriteString(window[cut:])
+	return s.convert(ready)
+}
+
+// Flush processes whatever is still buffered at the end of the response
+func (s *Stream) Flush() string {
+	rest := s.pending.String()
+	s.pending.Reset()
+	return s.convert(rest)
+}
+
+func (s *Stream) convert(text string) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			// Fences are dropped; code inside them is kept as it is
+			s.inFence = !s.inFence
+			continue
+		}
+		if s.format == Plain && !s.inFence {
+			line = stripMarkdown(line)
+		}
+		b.WriteString(line)
+	}
+	if s.format == HTML {
+		return s.sanitizeHTML(b.String())
+	}
+	return b.String()
+}
+
+var (
+	headingPattern    = regexp.MustCompile(`^(\s*)#{1,6}\s+`)
+	quotePattern      = regexp.MustCompile(`^(\s*)>\s?`)
+	rulePattern       = regexp.MustCompile(`^\s*([-*_]\s*){3,}$`)
+	bulletPattern     = regexp.MustCompile(`^(\s*)[*+]\s+`)
+	imagePattern      = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	linkPattern       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	boldPattern       = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	italicPattern     = regexp.MustCompile(`\*(\S(?:.*?\S)?)\*`)
+	inlineCodePattern = regexp.MustCompile("`([^`]+)`")
+)
+
+// stripMarkdown removes markdown syntax from a line, keeping its text
+func stripMarkdown(line string) string {
+	if rulePattern.MatchString(strings.TrimRight(line, "\n")) {
+		return ""
+	}
+	line = headingPattern.ReplaceAllString(line, "$1")
+	line = quotePattern.ReplaceAllString(line, "$1")
+	line = bulletPattern.ReplaceAllString(line, "$1- ")
+	line = imagePattern.ReplaceAllString(line, "$1")
+	line = linkPattern.ReplaceAllString(line, "$1 ($2)")
+	line = boldPattern.ReplaceAllString(line, "$1$2")
+	line = italicPattern.ReplaceAllString(line, "$1")
+	line = inlineCodePattern.ReplaceAllString(line, "$1")
+	return line
+}
+
+// droppedElements are removed from HTML output along with their content
+var droppedElements = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+}
+
+var (
+	tagPattern          = regexp.MustCompile(`<(/?)([A-Za-z][A-Za-z0-9-]*)[^>]*>`)
+	eventHandlerPattern = regexp.MustCompile(`(?i)\s+on[a-z]+\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+	scriptURLPattern    = regexp.MustCompile(`(?i)\s+(href|src|action)\s*=\s*("\s*javascript:[^"]*"|'\s*javascript:[^']*'|javascript:[^\s>]*)`)
+)
+
+// sanitizeHTML drops scripting from HTML text: script-like elements
+// and their content, event handler attributes and javascript: URLs
+func (s *Stream) sanitizeHTML(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range tagPattern.FindAllStringSubmatchIndex(text, -1) {
+		if s.skip == "" {
+			b.WriteString(text[last:m[0]])
+		}
+		last = m[1]
+
+		closing := text[m[2]:m[3]] == "/"
+		name := strings.ToLower(text[m[4]:m[5]])
+		switch {
+		case s.skip != "":
+			if closing && name == s.skip {
+				s.skip = ""
+			}
+		case droppedElements[name]:
+			if !closing && !strings.HasSuffix(text[m[0]:m[1]], "/>") {
+				s.skip = name
+			}
+		default:
+			tag := eventHandlerPattern.ReplaceAllString(text[m[0]:m[1]], "")
+			b.WriteString(scriptURLPattern.ReplaceAllString(tag, ` $1="#"`))
+		}
+	}
+	if s.skip == "" {
+		b.WriteString(text[last:])
+	}
+	return b.String()
+}
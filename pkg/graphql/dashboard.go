@@ -0,0 +1,448 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/drift"
+	"github.com/ajeetraina/aiwatch/pkg/experiments"
+	"github.com/ajeetraina/aiwatch/pkg/history"
+	"github.com/ajeetraina/aiwatch/pkg/memory"
+	"github.com/ajeetraina/aiwatch/pkg/models"
+	"github.com/ajeetraina/aiwatch/pkg/reports"
+	"github.com/ajeetraina/aiwatch/pkg/requestlog"
+	"github.com/ajeetraina/aiwatch/pkg/tenants"
+)
+
+// Sources are the data the dashboard schema reads. Fields whose source is
+// nil resolve to nothing.
+type Sources struct {
+	Conversations *history.Store
+	Experiments   *experiments.Manager
+	Models        *models.StatusTracker
+	Memory        func(model string) (memory.Stats, bool)
+	Drift         func() drift.Status
+	Requests      func(requestlog.Filter) []requestlog.Record
+}
+
+// Dashboard query limits and alert thresholds
+const (
+	defaultConversations = 50
+	defaultUsageWindow   = 24 * time.Hour
+	alertErrorRate       = 0.2 // share of recent requests failing; past half the model is unavailable
+	alertMinRequests     = 5   // recent requests before the error rate counts
+)
+
+// conversationNode is a conversation listed or found by a search. Its
+// messages are loaded only when selected, unless already at hand.
+type conversationNode struct {
+	history.Hit
+	messages []history.Message
+}
+
+// modelNode is a model with its live status
+type modelNode struct {
+	name string
+	live models.LiveStatus
+}
+
+// Alert is a condition on the dashboard that needs attention
+type Alert struct {
+	Kind     string // model_unavailable, high_error_rate, memory_high or drift
+	Severity string // critical or warning
+	Model    string // empty for alerts on all traffic
+	Message  string
+}
+
+// Dashboard returns the query type of the dashboard schema:
+//
+//	conversations(q, mode, model, user, rating, from, to, category, limit): [Conversation]
+//	conversation(id): Conversation
+//	models: [Model]
+//	model(name): Model
+//	experiments: [Experiment]
+//	experiment(id): Experiment
+//	alerts: [Alert]
+//
+// Conversations and usage are those of the caller's tenant.
+func Dashboard(src Sources) *Object {
+	snippet := &Object{Name: "Snippet", Fields: map[string]*Field{
+		"index": scalar(func(s history.Snippet) interface{} { return s.Index }),
+		"role":  scalar(func(s history.Snippet) interface{} { return s.Role }),
+		"text":  scalar(func(s history.Snippet) interface{} { return s.Text }),
+	}}
+	message := &Object{Name: "Message", Fields: map[string]*Field{
+		"id":        scalar(func(m history.Message) interface{} { return optional(m.ID) }),
+		"role":      scalar(func(m history.Message) interface{} { return m.Role }),
+		"content":   scalar(func(m history.Message) interface{} { return m.Content }),
+		"model":     scalar(func(m history.Message) interface{} { return optional(m.Model) }),
+		"timestamp": scalar(func(m history.Message) interface{} { return timestamp(m.Timestamp) }),
+		"rating":    scalar(func(m history.Message) interface{} { return m.Rating }),
+		"comment":   scalar(func(m history.Message) interface{} { return optional(m.Comment) }),
+	}}
+	conversation := &Object{Name: "Conversation", Fields: map[string]*Field{
+		"id":           scalar(func(c conversationNode) interface{} { return c.ID }),
+		"user":         scalar(func(c conversationNode) interface{} { return optional(c.User) }),
+		"model":        scalar(func(c conversationNode) interface{} { return c.Model }),
+		"createdAt":    scalar(func(c conversationNode) interface{} { return timestamp(c.CreatedAt) }),
+		"updatedAt":    scalar(func(c conversationNode) interface{} { return timestamp(c.UpdatedAt) }),
+		"messageCount": scalar(func(c conversationNode) interface{} { return c.MessageCount }),
+		"preview":      scalar(func(c conversationNode) interface{} { return c.Preview }),
+		"rating":       scalar(func(c conversationNode) interface{} { return c.Rating }),
+		"categories":   scalar(func(c conversationNode) interface{} { return nonNil(c.Categories) }),
+		"title":        scalar(func(c conversationNode) interface{} { return optional(c.Title) }),
+		"summary":      scalar(func(c conversationNode) interface{} { return optional(c.Hit.Summary.Summary) }),
+		"score":        scalar(func(c conversationNode) interface{} { return c.Score }),
+		"snippets": {Type: snippet, Resolve: func(_ context.Context, source interface{}, _ Args) (interface{}, error) {
+			return nonNil(source.(conversationNode).Snippets), nil
+		}},
+		"messages": {Type: message, Resolve: func(_ context.Context, source interface{}, _ Args) (interface{}, error) {
+			c := source.(conversationNode)
+			if c.messages != nil {
+				return c.messages, nil
+			}
+			full, err := src.Conversations.Get(c.ID)
+			if err != nil {
+				return nil, err
+			}
+			return nonNil(full.Messages), nil
+		}},
+	}}
+
+	memoryStats := &Object{Name: "MemoryStats", Fields: map[string]*Field{
+		"kvCacheUsage":  scalar(func(s memory.Stats) interface{} { return s.KVCacheUsage }),
+		"kvCacheTokens": scalar(func(s memory.Stats) interface{} { return s.KVCacheTokens }),
+		"modelBytes":    scalar(func(s memory.Stats) interface{} { return s.ModelBytes }),
+		"vramBytes":     scalar(func(s memory.Stats) interface{} { return s.VRAMBytes }),
+		"high":          scalar(func(s memory.Stats) interface{} { return s.High }),
+		"updatedAt":     scalar(func(s memory.Stats) interface{} { return timestamp(s.UpdatedAt) }),
+	}}
+	usage := &Object{Name: "Usage", Fields: map[string]*Field{
+		"from":      scalar(func(r reports.Report) interface{} { return timestamp(r.From) }),
+		"to":        scalar(func(r reports.Report) interface{} { return timestamp(r.To) }),
+		"requests":  scalar(func(r reports.Report) interface{} { return r.Requests }),
+		"errors":    scalar(func(r reports.Report) interface{} { return r.Errors }),
+		"errorRate": scalar(func(r reports.Report) interface{} { return r.ErrorRate() / 100 }),
+		"tokensIn":  scalar(func(r reports.Report) interface{} { return r.TokensIn }),
+		"tokensOut": scalar(func(r reports.Report) interface{} { return r.TokensOut }),
+		"costUsd":   scalar(func(r reports.Report) interface{} { return r.CostUSD }),
+		"p50Ms":     scalar(func(r reports.Report) interface{} { return r.P50Ms }),
+		"p99Ms":     scalar(func(r reports.Report) interface{} { return r.P99Ms }),
+	}}
+	model := &Object{Name: "Model", Fields: map[string]*Field{
+		"name":            scalar(func(m modelNode) interface{} { return m.name }),
+		"status":          scalar(func(m modelNode) interface{} { return string(m.live.Status) }),
+		"tokensPerSecond": scalar(func(m modelNode) interface{} { return m.live.TokensPerSecond }),
+		"errorRate":       scalar(func(m modelNode) interface{} { return m.live.ErrorRate }),
+		"recentRequests":  scalar(func(m modelNode) interface{} { return m.live.Requests }),
+		"lastError":       scalar(func(m modelNode) interface{} { return optional(m.live.LastError) }),
+		"lastChecked":     scalar(func(m modelNode) interface{} { return timestamp(m.live.LastChecked) }),
+		"memory": {Type: memoryStats, Resolve: func(_ context.Context, source interface{}, _ Args) (interface{}, error) {
+			if src.Memory == nil {
+				return nil, nil
+			}
+			if stats, ok := src.Memory(source.(modelNode).name); ok {
+				return stats, nil
+			}
+			return nil, nil
+		}},
+		"usage": {Type: usage, Args: map[string]Kind{"from": String, "to": String}, Resolve: func(ctx context.Context, source interface{}, args Args) (interface{}, error) {
+			if src.Requests == nil {
+				return nil, nil
+			}
+			from, err := parseTime(args.String("from"), false)
+			if err != nil {
+				return nil, fmt.Errorf("invalid from: %w", err)
+			}
+			to, err := parseTime(args.String("to"), true)
+			if err != nil {
+				return nil, fmt.Errorf("invalid to: %w", err)
+			}
+			if to.IsZero() {
+				to = time.Now()
+			}
+			if from.IsZero() {
+				from = to.Add(-defaultUsageWindow)
+			}
+			records := src.Requests(requestlog.Filter{From: from, To: to, Model: source.(modelNode).name, Tenant: tenants.FromContext(ctx)})
+			return reports.Build("", from, to, records, nil), nil
+		}},
+	}}
+
+	variant := &Object{Name: "Variant", Fields: map[string]*Field{
+		"name":   scalar(func(v experiments.Variant) interface{} { return v.Name }),
+		"model":  scalar(func(v experiments.Variant) interface{} { return v.Model }),
+		"weight": scalar(func(v experiments.Variant) interface{} { return v.Weight }),
+	}}
+	variantResults := &Object{Name: "VariantResults", Fields: map[string]*Field{
+		"variant":          scalar(func(v experiments.VariantResults) interface{} { return v.Variant }),
+		"model":            scalar(func(v experiments.VariantResults) interface{} { return v.Model }),
+		"weight":           scalar(func(v experiments.VariantResults) interface{} { return v.Weight }),
+		"requests":         scalar(func(v experiments.VariantResults) interface{} { return v.Requests }),
+		"errors":           scalar(func(v experiments.VariantResults) interface{} { return v.Errors }),
+		"avgLatencyMs":     scalar(func(v experiments.VariantResults) interface{} { return v.AvgLatencyMs }),
+		"p50LatencyMs":     scalar(func(v experiments.VariantResults) interface{} { return v.P50LatencyMs }),
+		"p95LatencyMs":     scalar(func(v experiments.VariantResults) interface{} { return v.P95LatencyMs }),
+		"avgFirstTokenMs":  scalar(func(v experiments.VariantResults) interface{} { return v.AvgFirstTokenMs }),
+		"avgTokensIn":      scalar(func(v experiments.VariantResults) interface{} { return v.AvgTokensIn }),
+		"avgTokensOut":     scalar(func(v experiments.VariantResults) interface{} { return v.AvgTokensOut }),
+		"thumbsUp":         scalar(func(v experiments.VariantResults) interface{} { return v.ThumbsUp }),
+		"thumbsDown":       scalar(func(v experiments.VariantResults) interface{} { return v.ThumbsDown }),
+		"satisfactionRate": scalar(func(v experiments.VariantResults) interface{} { return v.SatisfactionRate }),
+	}}
+	experiment := &Object{Name: "Experiment", Fields: map[string]*Field{
+		"id":          scalar(func(e experiments.Experiment) interface{} { return e.ID }),
+		"description": scalar(func(e experiments.Experiment) interface{} { return optional(e.Description) }),
+		"enabled":     scalar(func(e experiments.Experiment) interface{} { return e.Enabled }),
+		"variants": {Type: variant, Resolve: func(_ context.Context, source interface{}, _ Args) (interface{}, error) {
+			return nonNil(source.(experiments.Experiment).Variants), nil
+		}},
+		"results": {Type: variantResults, Resolve: func(_ context.Context, source interface{}, _ Args) (interface{}, error) {
+			results, err := src.Experiments.Results(source.(experiments.Experiment).ID)
+			if err != nil {
+				return nil, err
+			}
+			return nonNil(results.Variants), nil
+		}},
+	}}
+
+	alert := &Object{Name: "Alert", Fields: map[string]*Field{
+		"kind":     scalar(func(a Alert) interface{} { return a.Kind }),
+		"severity": scalar(func(a Alert) interface{} { return a.Severity }),
+		"model":    scalar(func(a Alert) interface{} { return optional(a.Model) }),
+		"message":  scalar(func(a Alert) interface{} { return a.Message }),
+	}}
+
+	return &Object{Name: "Query", Fields: map[string]*Field{
+		"conversations": {
+			Type: conversation,
+			Args: map[string]Kind{
+				"q": String, "mode": String, "model": String, "user": String, "rating": Int,
+				"from": String, "to": String, "category": String, "limit": Int,
+			},
+			Resolve: src.conversations,
+		},
+		"conversation": {Type: conversation, Args: map[string]Kind{"id": String}, Resolve: src.conversation},
+		"models": {Type: model, Resolve: func(context.Context, interface{}, Args) (interface{}, error) {
+			if src.Models == nil {
+				return []modelNode{}, nil
+			}
+			names := src.Models.Models()
+			nodes := make([]modelNode, len(names))
+			for i, name := range names {
+				nodes[i] = modelNode{name: name, live: src.Models.Get(name)}
+			}
+			return nodes, nil
+		}},
+		"model": {Type: model, Args: map[string]Kind{"name": String}, Resolve: func(_ context.Context, _ interface{}, args Args) (interface{}, error) {
+			name := args.String("name")
+			if name == "" {
+				return nil, errors.New("name is required")
+			}
+			if src.Models == nil || !slices.Contains(src.Models.Models(), name) {
+				return nil, nil
+			}
+			return modelNode{name: name, live: src.Models.Get(name)}, nil
+		}},
+		"experiments": {Type: experiment, Resolve: func(context.Context, interface{}, Args) (interface{}, error) {
+			if src.Experiments == nil {
+				return []experiments.Experiment{}, nil
+			}
+			return nonNil(src.Experiments.List()), nil
+		}},
+		"experiment": {Type: experiment, Args: map[string]Kind{"id": String}, Resolve: func(_ context.Context, _ interface{}, args Args) (interface{}, error) {
+			id := args.String("id")
+			if id == "" {
+				return nil, errors.New("id is required")
+			}
+			if src.Experiments == nil {
+				return nil, nil
+			}
+			for _, e := range src.Experiments.List() {
+				if e.ID == id {
+					return e, nil
+				}
+			}
+			return nil, nil
+		}},
+		"alerts": {Type: alert, Resolve: func(context.Context, interface{}, Args) (interface{}, error) {
+			return src.Alerts(), nil
+		}},
+	}}
+}
+
+// conversations lists or searches the caller's conversations
+func (src Sources) conversations(ctx context.Context, _ interface{}, args Args) (interface{}, error) {
+	if src.Conversations == nil {
+		return []conversationNode{}, nil
+	}
+	// Share the filters and their parsing with the REST search
+	params := url.Values{}
+	for _, name := range []string{"q", "model", "user", "from", "to"} {
+		if v := args.String(name); v != "" {
+			params.Set(name, v)
+		}
+	}
+	for _, name := range []string{"rating", "limit"} {
+		if n, ok := args.Int(name); ok {
+			params.Set(name, strconv.Itoa(n))
+		}
+	}
+	q, err := history.ParseQuery(params)
+	if err != nil {
+		return nil, err
+	}
+	q.Tenant = tenants.FromContext(ctx)
+	caller := history.CallerFromContext(ctx)
+	q.Caller = &caller
+	if q.Limit == 0 {
+		q.Limit = defaultConversations
+	}
+
+	var hits []history.Hit
+	switch mode := args.String("mode"); {
+	case q.Text == "":
+		category := args.String("category")
+		summaries, err := src.Conversations.ListWhere(q.Limit, func(c history.Conversation) bool {
+			return q.Filter(c) && (category == "" || slices.Contains(c.Categories, category))
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range summaries {
+			hits = append(hits, history.Hit{Summary: s})
+		}
+	case mode == "" || mode == "text":
+		hits, err = src.Conversations.Search(q)
+	case mode == "semantic":
+		hits, err = src.Conversations.SemanticSearch(ctx, q)
+	default:
+		return nil, errors.New("mode must be text or semantic")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]conversationNode, len(hits))
+	for i, hit := range hits {
+		nodes[i] = conversationNode{Hit: hit}
+	}
+	return nodes, nil
+}
+
+// conversation returns one of the caller's conversations, or null
+func (src Sources) conversation(ctx context.Context, _ interface{}, args Args) (interface{}, error) {
+	id := args.String("id")
+	if id == "" {
+		return nil, errors.New("id is required")
+	}
+	if src.Conversations == nil {
+		return nil, nil
+	}
+	c, err := src.Conversations.Get(id)
+	if errors.Is(err, history.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !tenants.Same(c.Tenant, tenants.FromContext(ctx)) || !history.CallerFromContext(ctx).Allows(c) {
+		return nil, nil
+	}
+	return conversationNode{Hit: history.Hit{Summary: c.Summarize()}, messages: nonNil(c.Messages)}, nil
+}
+
+// Alerts returns the current alerts: unavailable models and models with
+// many failing requests, models short of KV cache, and drifting traffic
+func (src Sources) Alerts() []Alert {
+	alerts := []Alert{}
+	if src.Models != nil {
+		for _, name := range src.Models.Models() {
+			live := src.Models.Get(name)
+			switch {
+			case live.Status == models.StatusUnavailable:
+				message := "model is unavailable"
+				if live.LastError != "" {
+					message += ": " + live.LastError
+				}
+				alerts = append(alerts, Alert{Kind: "model_unavailable", Severity: "critical", Model: name, Message: message})
+			case live.Requests >= alertMinRequests && live.ErrorRate >= alertErrorRate:
+				alerts = append(alerts, Alert{Kind: "high_error_rate", Severity: "warning", Model: name,
+					Message: fmt.Sprintf("%.0f%% of the last %d requests failed", live.ErrorRate*100, live.Requests)})
+			}
+			if src.Memory == nil {
+				continue
+			}
+			if stats, ok := src.Memory(name); ok && stats.High {
+				message := "KV cache usage is high"
+				if stats.KVCacheUsage != nil {
+					message = fmt.Sprintf("KV cache is %.0f%% full", *stats.KVCacheUsage*100)
+				}
+				alerts = append(alerts, Alert{Kind: "memory_high", Severity: "warning", Model: name, Message: message})
+			}
+		}
+	}
+	if src.Drift != nil {
+		status := src.Drift()
+		for _, signal := range status.Drifting {
+			alerts = append(alerts, Alert{Kind: "drift", Severity: "warning",
+				Message: fmt.Sprintf("%s has drifted from the baseline (score %.2f)", signal, status.Scores[signal])})
+		}
+	}
+	return alerts
+}
+
+// scalar makes a field that reads a scalar from a source of type T
+func scalar[T any](get func(T) interface{}) *Field {
+	return &Field{Resolve: func(_ context.Context, source interface{}, _ Args) (interface{}, error) {
+		return get(source.(T)), nil
+	}}
+}
+
+// optional returns nil for an empty string, so it reads as null
+func optional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// timestamp returns t in RFC 3339, or nil if unset
+func timestamp(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// nonNil returns an empty list for nil, so a list reads as [] rather than null
+func nonNil[T any](list []T) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
+
+// parseTime parses an RFC 3339 time or a date. A date given for the end of
+// a range includes that whole day.
+func parseTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, errors.New("want an RFC 3339 time or YYYY-MM-DD date")
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
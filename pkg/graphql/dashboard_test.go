@@ -0,0 +1,188 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/drift"
+	"github.com/ajeetraina/aiwatch/pkg/experiments"
+	"github.com/ajeetraina/aiwatch/pkg/history"
+	"github.com/ajeetraina/aiwatch/pkg/memory"
+	"github.com/ajeetraina/aiwatch/pkg/models"
+	"github.com/ajeetraina/aiwatch/pkg/requestlog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newSources(t *testing.T) Sources {
+	t.Helper()
+	store := history.New(nil, 1<<20, history.Metrics{
+		Lookups:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "lookups"}, []string{"result"}),
+		Evictions:   prometheus.NewCounter(prometheus.CounterOpts{Name: "evictions"}),
+		MemoryBytes: prometheus.NewGauge(prometheus.GaugeOpts{Name: "memory_bytes"}),
+		Entries:     prometheus.NewGauge(prometheus.GaugeOpts{Name: "entries"}),
+	})
+	store.Append("pasta", "ana", "ai/llama",
+		history.Message{Role: "user", Content: "How long do I boil pasta?"},
+		history.Message{Role: "assistant", Content: "About ten minutes."})
+	store.Append("tax", "ben", "ai/qwen", history.Message{Role: "user", Content: "Explain tax brackets"})
+	store.Append("hidden", "cy", "ai/llama", history.Message{Role: "user", Content: "Boil an egg"})
+	store.Update("hidden", func(c *history.Conversation) { c.Tenant = "other" })
+
+	manager, err := experiments.New(experiments.Config{Experiments: []experiments.Experiment{{
+		ID:      "llama-vs-qwen",
+		Enabled: true,
+		Variants: []experiments.Variant{
+			{Name: "control", Model: "ai/llama", Weight: 50},
+			{Name: "candidate", Model: "ai/qwen", Weight: 50},
+		},
+	}}}, experiments.Metrics{
+		Requests:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"experiment", "variant", "model"}),
+		Latency:    prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"experiment", "variant"}),
+		FirstToken: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "first_token"}, []string{"experiment", "variant"}),
+		Tokens:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "tokens"}, []string{"experiment", "variant", "direction"}),
+		Feedback:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "feedback"}, []string{"experiment", "variant", "rating"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Record(experiments.Assignment{Experiment: "llama-vs-qwen", Variant: "candidate", Model: "ai/qwen"},
+		experiments.Outcome{Latency: time.Second, TokensIn: 10, TokensOut: 20})
+
+	tracker := models.NewStatusTracker(time.Minute)
+	tracker.RecordRequest("ai/llama", 40, nil)
+	for i := 0; i < 6; i++ {
+		var err error
+		if i%3 == 0 {
+			err = errors.New("upstream timeout")
+		}
+		tracker.RecordRequest("ai/qwen", 25, err)
+	}
+	tracker.SetProbeResult("ai/phi", models.StatusUnavailable, errors.New("not found"))
+
+	usage := 0.95
+	now := time.Now()
+	return Sources{
+		Conversations: store,
+		Experiments:   manager,
+		Models:        tracker,
+		Memory: func(model string) (memory.Stats, bool) {
+			if model != "ai/llama" {
+				return memory.Stats{}, false
+			}
+			return memory.Stats{KVCacheUsage: &usage, High: true, UpdatedAt: now}, true
+		},
+		Drift: func() drift.Status {
+			return drift.Status{Drifting: []string{"refusal_rate"}, Scores: map[string]float64{"refusal_rate": 0.4}}
+		},
+		Requests: func(f requestlog.Filter) []requestlog.Record {
+			if f.Model != "ai/llama" {
+				return nil
+			}
+			return []requestlog.Record{
+				{Time: now, Model: "ai/llama", TokensIn: 5, TokensOut: 7, LatencyMs: 100, CostUSD: 0.25},
+				{Time: now, Model: "ai/llama", Status: 500, LatencyMs: 300},
+			}
+		},
+	}
+}
+
+// query runs q as an admin, who sees every conversation of the tenant
+func query(t *testing.T, src Sources, q string) string {
+	t.Helper()
+	return queryAs(t, src, history.Caller{Admin: true}, q)
+}
+
+func queryAs(t *testing.T, src Sources, caller history.Caller, q string) string {
+	t.Helper()
+	resp := New(Dashboard(src), Metrics{}).Execute(history.WithCaller(context.Background(), caller), Request{Query: q})
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDashboardConversationsOfCaller(t *testing.T) {
+	src := newSources(t)
+	ana := history.Caller{Owner: "session:ana", Session: "ana"}
+
+	got := queryAs(t, src, ana, `{ conversations { id } }`)
+	if want := `{"data":{"conversations":[{"id":"pasta"}]}}`; got != want {
+		t.Errorf("listing: got  %s\nwant %s", got, want)
+	}
+	got = queryAs(t, src, ana, `{ tax: conversation(id: "tax") { id } }`)
+	if want := `{"data":{"tax":null}}`; got != want {
+		t.Errorf("another user's conversation: got  %s\nwant %s", got, want)
+	}
+}
+
+func TestDashboardConversations(t *testing.T) {
+	src := newSources(t)
+
+	got := query(t, src, `{ conversations(model: "ai/llama") { id user messageCount } }`)
+	if want := `{"data":{"conversations":[{"id":"pasta","user":"ana","messageCount":2}]}}`; got != want {
+		t.Errorf("listing: got  %s\nwant %s", got, want)
+	}
+
+	got = query(t, src, `{ conversations(q: "boil") { id snippets { index text } messages { role content } } }`)
+	want := `{"data":{"conversations":[{"id":"pasta","snippets":[{"index":0,"text":"How long do I boil pasta?"}],` +
+		`"messages":[{"role":"user","content":"How long do I boil pasta?"},{"role":"assistant","content":"About ten minutes."}]}]}}`
+	if got != want {
+		t.Errorf("search: got  %s\nwant %s", got, want)
+	}
+
+	got = query(t, src, `{ tax: conversation(id: "tax") { model preview } hidden: conversation(id: "hidden") { id } }`)
+	if want := `{"data":{"tax":{"model":"ai/qwen","preview":"Explain tax brackets"},"hidden":null}}`; got != want {
+		t.Errorf("lookup: got  %s\nwant %s", got, want)
+	}
+
+	got = query(t, src, `{ conversations(q: "pasta", mode: "semantic") { id } }`)
+	if want := `{"data":{"conversations":null},"errors":[{"message":"semantic search is not configured","path":["conversations"]}]}`; got != want {
+		t.Errorf("semantic: got  %s\nwant %s", got, want)
+	}
+}
+
+func TestDashboardModels(t *testing.T) {
+	got := query(t, newSources(t), `{
+		models { name status }
+		model(name: "ai/llama") {
+			recentRequests
+			memory { kvCacheUsage high }
+			usage { requests errors errorRate tokensIn tokensOut costUsd }
+		}
+	}`)
+	want := `{"data":{"models":[{"name":"ai/llama","status":"loaded"},{"name":"ai/phi","status":"unavailable"},{"name":"ai/qwen","status":"loaded"}],` +
+		`"model":{"recentRequests":1,"memory":{"kvCacheUsage":0.95,"high":true},` +
+		`"usage":{"requests":2,"errors":1,"errorRate":0.5,"tokensIn":5,"tokensOut":7,"costUsd":0.25}}}}`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestDashboardExperiments(t *testing.T) {
+	got := query(t, newSources(t), `{ experiments { id variants { name } results { variant requests avgTokensOut } } }`)
+	want := `{"data":{"experiments":[{"id":"llama-vs-qwen","variants":[{"name":"control"},{"name":"candidate"}],` +
+		`"results":[{"variant":"control","requests":0,"avgTokensOut":0},{"variant":"candidate","requests":1,"avgTokensOut":20}]}]}}`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestDashboardAlerts(t *testing.T) {
+	got := query(t, newSources(t), `{ alerts { kind severity model message } }`)
+	want := `{"data":{"alerts":[` +
+		`{"kind":"memory_high","severity":"warning","model":"ai/llama","message":"KV cache is 95% full"},` +
+		`{"kind":"model_unavailable","severity":"critical","model":"ai/phi","message":"model is unavailable: not found"},` +
+		`{"kind":"high_error_rate","severity":"warning","model":"ai/qwen","message":"33% of the last 6 requests failed"},` +
+		`{"kind":"drift","severity":"warning","model":null,"message":"refusal_rate has drifted from the baseline (score 0.40)"}]}}`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	if got := query(t, Sources{}, `{ alerts { kind } models { name } experiments { id } conversations { id } }`); got != `{"data":{"alerts":[],"models":[],"experiments":[],"conversations":[]}}` {
+		t.Errorf("without sources: got %s", got)
+	}
+}
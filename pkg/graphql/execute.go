@@ -0,0 +1,483 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// maxDepth bounds how deeply a query can nest selections
+const maxDepth = 12
+
+// Kind is the type of a field argument
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Float
+	Boolean
+)
+
+func (k Kind) String() string {
+	return [...]string{"String", "Int", "Float", "Boolean"}[k]
+}
+
+// Resolver returns the value of a field of source, the value resolved for
+// the enclosing object. Scalars are returned as JSON-encodable values, and
+// objects or lists of them as whatever their fields' resolvers expect.
+type Resolver func(ctx context.Context, source interface{}, args Args) (interface{}, error)
+
+// Field is a field of an object type
+type Field struct {
+	Type    *Object         // type of the value, or nil for a scalar or list of scalars
+	Args    map[string]Kind // arguments the field accepts
+	Resolve Resolver
+}
+
+// Object is an object type: a name and its fields
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Args are the arguments given to a field, coerced to their kinds.
+// Arguments that were omitted or null are absent.
+type Args map[string]interface{}
+
+// String returns a string argument, or "" if absent
+func (a Args) String(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+// Int returns an integer argument and whether it was given
+func (a Args) Int(name string) (int, bool) {
+	n, ok := a[name].(int)
+	return n, ok
+}
+
+// Float returns a float argument and whether it was given
+func (a Args) Float(name string) (float64, bool) {
+	f, ok := a[name].(float64)
+	return f, ok
+}
+
+// Bool returns a boolean argument, or false if absent
+func (a Args) Bool(name string) bool {
+	b, _ := a[name].(bool)
+	return b
+}
+
+// Request is a GraphQL request as sent over HTTP
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is the result of a request. Data is absent when the request
+// could not be executed at all; otherwise fields that failed are null and
+// described in Errors.
+type Response struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []Error     `json:"errors,omitempty"`
+}
+
+// Error is a request error, or a field error at Path
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// executor runs one operation of a document
+type executor struct {
+	doc     *document
+	vars    map[string]interface{}
+	defined map[string]bool // declared variables
+	errors  []Error
+}
+
+// execute runs the operation of req against the query type
+func execute(ctx context.Context, query *Object, req Request) Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return requestError(err)
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return requestError(err)
+	}
+	if op.kind != "query" {
+		return requestError(fmt.Errorf("%s operations are not supported", op.kind))
+	}
+
+	e := &executor{doc: doc, vars: make(map[string]interface{}), defined: make(map[string]bool)}
+	for _, v := range op.variables {
+		e.defined[v.name] = true
+		given, ok := req.Variables[v.name]
+		switch {
+		case ok && given != nil:
+			e.vars[v.name] = given
+		case v.def.literal != nil:
+			e.vars[v.name] = v.def.literal
+		case v.required:
+			return requestError(fmt.Errorf("variable $%s is required", v.name))
+		}
+	}
+	if err := e.validate(query, op.selections, map[string]bool{}, 1); err != nil {
+		return requestError(err)
+	}
+
+	data := e.object(ctx, query, nil, op.selections, nil)
+	return Response{Data: data, Errors: e.errors}
+}
+
+func requestError(err error) Response {
+	return Response{Errors: []Error{{Message: err.Error()}}}
+}
+
+// operation finds the operation to run: the one named, or the only one
+func (d *document) operation(name string) (*operation, error) {
+	if name == "" {
+		if len(d.operations) > 1 {
+			return nil, errors.New("operationName is required when the query has several operations")
+		}
+		return d.operations[0], nil
+	}
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("no operation named %q", name)
+}
+
+// validate checks that selections only select existing fields of obj with
+// arguments they accept, and that fragments and variables exist
+func (e *executor) validate(obj *Object, selections []selection, visiting map[string]bool, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("query is nested more than %d levels deep", maxDepth)
+	}
+	for _, sel := range selections {
+		switch sel := sel.(type) {
+		case *field:
+			if err := e.validateDirectives(sel.directives); err != nil {
+				return err
+			}
+			if sel.name == "__typename" {
+				if len(sel.selections) > 0 {
+					return fmt.Errorf("line %d: __typename cannot have a selection", sel.line)
+				}
+				continue
+			}
+			def, ok := obj.Fields[sel.name]
+			if !ok {
+				return fmt.Errorf("line %d: type %s has no field %q", sel.line, obj.Name, sel.name)
+			}
+			for _, arg := range sel.args {
+				if _, ok := def.Args[arg.name]; !ok {
+					return fmt.Errorf("line %d: field %q has no argument %q", sel.line, sel.name, arg.name)
+				}
+				if err := e.validateValue(arg.value); err != nil {
+					return err
+				}
+			}
+			switch {
+			case def.Type == nil && len(sel.selections) > 0:
+				return fmt.Errorf("line %d: field %q is a scalar and cannot have a selection", sel.line, sel.name)
+			case def.Type != nil && len(sel.selections) == 0:
+				return fmt.Errorf("line %d: field %q of type %s needs a selection of its fields", sel.line, sel.name, def.Type.Name)
+			case def.Type != nil:
+				if err := e.validate(def.Type, sel.selections, visiting, depth+1); err != nil {
+					return err
+				}
+			}
+		case *spread:
+			if err := e.validateDirectives(sel.directives); err != nil {
+				return err
+			}
+			f, ok := e.doc.fragments[sel.name]
+			if !ok {
+				return fmt.Errorf("unknown fragment %q", sel.name)
+			}
+			if visiting[sel.name] {
+				return fmt.Errorf("fragment %q spreads itself", sel.name)
+			}
+			if f.on != obj.Name {
+				return fmt.Errorf("fragment %q on %s cannot be spread within %s", f.name, f.on, obj.Name)
+			}
+			visiting[sel.name] = true
+			err := e.validate(obj, f.selections, visiting, depth)
+			delete(visiting, sel.name)
+			if err != nil {
+				return err
+			}
+		case *inline:
+			if err := e.validateDirectives(sel.directives); err != nil {
+				return err
+			}
+			if sel.on != "" && sel.on != obj.Name {
+				return fmt.Errorf("fragment on %s cannot be spread within %s", sel.on, obj.Name)
+			}
+			if err := e.validate(obj, sel.selections, visiting, depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateDirectives allows only @include(if:) and @skip(if:)
+func (e *executor) validateDirectives(directives []directive) error {
+	for _, d := range directives {
+		if d.name != "include" && d.name != "skip" {
+			return fmt.Errorf("unknown directive @%s", d.name)
+		}
+		if len(d.args) != 1 || d.args[0].name != "if" {
+			return fmt.Errorf("@%s takes a single if argument", d.name)
+		}
+		if err := e.validateValue(d.args[0].value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateValue checks that the variables in v are declared
+func (e *executor) validateValue(v value) error {
+	if v.variable != "" && !e.defined[v.variable] {
+		return fmt.Errorf("variable $%s is not defined", v.variable)
+	}
+	switch literal := v.literal.(type) {
+	case []value:
+		for _, item := range literal {
+			if err := e.validateValue(item); err != nil {
+				return err
+			}
+		}
+	case []argument:
+		for _, arg := range literal {
+			if err := e.validateValue(arg.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// group is the fields selected under one response key, whose selections merge
+type group struct {
+	key    string
+	fields []*field
+}
+
+// collect flattens fragments in selections into fields grouped by response
+// key, in the order first selected, leaving out skipped fields
+func (e *executor) collect(selections []selection, groups []group, visited map[string]bool) []group {
+	for _, sel := range selections {
+		switch sel := sel.(type) {
+		case *field:
+			if !e.included(sel.directives) {
+				continue
+			}
+			key := sel.key()
+			found := false
+			for i := range groups {
+				if groups[i].key == key {
+					groups[i].fields = append(groups[i].fields, sel)
+					found = true
+					break
+				}
+			}
+			if !found {
+				groups = append(groups, group{key: key, fields: []*field{sel}})
+			}
+		case *spread:
+			if visited[sel.name] || !e.included(sel.directives) {
+				continue
+			}
+			visited[sel.name] = true
+			groups = e.collect(e.doc.fragments[sel.name].selections, groups, visited)
+		case *inline:
+			if e.included(sel.directives) {
+				groups = e.collect(sel.selections, groups, visited)
+			}
+		}
+	}
+	return groups
+}
+
+// included evaluates @skip and @include
+func (e *executor) included(directives []directive) bool {
+	for _, d := range directives {
+		cond, _ := e.resolve(d.args[0].value).(bool)
+		if cond != (d.name == "include") {
+			return false
+		}
+	}
+	return true
+}
+
+// object resolves the selected fields of source, an obj
+func (e *executor) object(ctx context.Context, obj *Object, source interface{}, selections []selection, path []interface{}) *orderedMap {
+	result := &orderedMap{}
+	for _, g := range e.collect(selections, nil, map[string]bool{}) {
+		f := g.fields[0]
+		if f.name == "__typename" {
+			result.set(g.key, obj.Name)
+			continue
+		}
+		def := obj.Fields[f.name]
+		fieldPath := append(append([]interface{}{}, path...), g.key)
+
+		args, err := e.args(def, f.args)
+		var v interface{}
+		if err == nil {
+			v, err = def.Resolve(ctx, source, args)
+		}
+		if err != nil {
+			e.errors = append(e.errors, Error{Message: err.Error(), Path: fieldPath})
+			result.set(g.key, nil)
+			continue
+		}
+
+		var merged []selection
+		for _, f := range g.fields {
+			merged = append(merged, f.selections...)
+		}
+		result.set(g.key, e.complete(ctx, def.Type, v, merged, fieldPath))
+	}
+	return result
+}
+
+// complete resolves the selections of a field's value of type t
+func (e *executor) complete(ctx context.Context, t *Object, v interface{}, selections []selection, path []interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+	}
+	if t == nil {
+		return v
+	}
+	if rv.Kind() == reflect.Slice {
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			list[i] = e.complete(ctx, t, rv.Index(i).Interface(), selections, append(path[:len(path):len(path)], i))
+		}
+		return list
+	}
+	return e.object(ctx, t, v, selections, path)
+}
+
+// args resolves variables in a field's arguments and coerces them to their kinds
+func (e *executor) args(def *Field, given []argument) (Args, error) {
+	args := make(Args, len(given))
+	for _, arg := range given {
+		v := e.resolve(arg.value)
+		if v == nil {
+			continue
+		}
+		kind := def.Args[arg.name]
+		coerced, ok := coerce(v, kind)
+		if !ok {
+			return nil, fmt.Errorf("argument %q must be a %s", arg.name, kind)
+		}
+		args[arg.name] = coerced
+	}
+	return args, nil
+}
+
+// resolve returns the value of v, looking up variables. Lists and objects
+// are converted to []interface{} and map[string]interface{}.
+func (e *executor) resolve(v value) interface{} {
+	if v.variable != "" {
+		return e.vars[v.variable]
+	}
+	switch literal := v.literal.(type) {
+	case []value:
+		list := make([]interface{}, len(literal))
+		for i, item := range literal {
+			list[i] = e.resolve(item)
+		}
+		return list
+	case []argument:
+		object := make(map[string]interface{}, len(literal))
+		for _, arg := range literal {
+			object[arg.name] = e.resolve(arg.value)
+		}
+		return object
+	}
+	return v.literal
+}
+
+// coerce converts v, a literal or variable decoded from JSON, to kind
+func coerce(v interface{}, kind Kind) (interface{}, bool) {
+	switch kind {
+	case String:
+		s, ok := v.(string)
+		return s, ok
+	case Boolean:
+		b, ok := v.(bool)
+		return b, ok
+	case Int:
+		switch n := v.(type) {
+		case int:
+			return n, true
+		case float64:
+			if n == math.Trunc(n) && math.Abs(n) <= math.MaxInt32 {
+				return int(n), true
+			}
+		}
+	case Float:
+		switch n := v.(type) {
+		case int:
+			return float64(n), true
+		case float64:
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// orderedMap is a JSON object that keeps its keys in selection order, as
+// GraphQL responses do
+type orderedMap struct {
+	keys   []string
+	values []interface{}
+}
+
+func (m *orderedMap) set(key string, v interface{}) {
+	m.keys = append(m.keys, key)
+	m.values = append(m.values, v)
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,110 @@
+// Package graphql serves read-only GraphQL queries, so a dashboard screen
+// can fetch exactly the data it shows in one round trip. It implements the
+// query language (fields, arguments, aliases, variables, fragments and the
+// @include and @skip directives) over object types whose fields are
+// resolved by Go functions. Mutations, subscriptions and introspection
+// beyond __typename are not supported.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxBodyBytes bounds the size of a request
+const maxBodyBytes = 1 << 20
+
+// Metrics holds the collectors the endpoint reports to
+type Metrics struct {
+	Requests *prometheus.CounterVec // labels: result (ok|partial|error)
+	Duration prometheus.Histogram
+}
+
+// Schema executes queries against a query type
+type Schema struct {
+	query   *Object
+	metrics Metrics
+}
+
+// New creates a schema whose queries start at the fields of query
+func New(query *Object, metrics Metrics) *Schema {
+	return &Schema{query: query, metrics: metrics}
+}
+
+// Execute runs a request
+func (s *Schema) Execute(ctx context.Context, req Request) Response {
+	start := time.Now()
+	resp := execute(ctx, s.query, req)
+
+	result := "ok"
+	switch {
+	case resp.Data == nil:
+		result = "error"
+	case len(resp.Errors) > 0:
+		result = "partial"
+	}
+	if s.metrics.Requests != nil {
+		s.metrics.Requests.WithLabelValues(result).Inc()
+	}
+	if s.metrics.Duration != nil {
+		s.metrics.Duration.Observe(time.Since(start).Seconds())
+	}
+	return resp
+}
+
+// Handler serves queries posted as JSON {"query", "operationName",
+// "variables"}, or given as the same GET parameters with variables as JSON.
+// Requests that cannot be executed get a 400; field errors are reported
+// alongside the data with a 200.
+func (s *Schema) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		switch r.Method {
+		case http.MethodGet:
+			params := r.URL.Query()
+			req.Query = params.Get("query")
+			req.OperationName = params.Get("operationName")
+			if v := params.Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					writeResponse(w, http.StatusBadRequest, Response{Errors: []Error{{Message: "invalid variables: " + err.Error()}}})
+					return
+				}
+			}
+		case http.MethodPost:
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+			if err == nil {
+				err = json.Unmarshal(body, &req)
+			}
+			if err != nil {
+				writeResponse(w, http.StatusBadRequest, Response{Errors: []Error{{Message: "invalid request body: " + err.Error()}}})
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeResponse(w, http.StatusMethodNotAllowed, Response{Errors: []Error{{Message: "method not allowed"}}})
+			return
+		}
+		if req.Query == "" {
+			writeResponse(w, http.StatusBadRequest, Response{Errors: []Error{{Message: "query is required"}}})
+			return
+		}
+
+		resp := s.Execute(r.Context(), req)
+		status := http.StatusOK
+		if resp.Data == nil {
+			status = http.StatusBadRequest
+		}
+		writeResponse(w, status, resp)
+	})
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
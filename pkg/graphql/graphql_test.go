@@ -0,0 +1,218 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type book struct {
+	Title  string
+	Pages  int
+	Author string
+}
+
+// testSchema has books, each with an author who has books in turn, and a
+// field that always fails
+func testSchema() *Schema {
+	author := &Object{Name: "Author", Fields: map[string]*Field{
+		"name": scalar(func(name string) interface{} { return name }),
+	}}
+	bookType := &Object{Name: "Book", Fields: map[string]*Field{
+		"title": scalar(func(b book) interface{} { return b.Title }),
+		"pages": scalar(func(b book) interface{} { return b.Pages }),
+		"author": {Type: author, Resolve: func(_ context.Context, source interface{}, _ Args) (interface{}, error) {
+			return source.(book).Author, nil
+		}},
+		"price": {Resolve: func(context.Context, interface{}, Args) (interface{}, error) {
+			return nil, errors.New("price unavailable")
+		}},
+	}}
+	author.Fields["books"] = &Field{Type: bookType, Resolve: func(context.Context, interface{}, Args) (interface{}, error) {
+		return []book{}, nil
+	}}
+	books := []book{{"Dune", 412, "Herbert"}, {"Emma", 474, "Austen"}, {"Ubik", 202, "Dick"}}
+	query := &Object{Name: "Query", Fields: map[string]*Field{
+		"books": {Type: bookType, Args: map[string]Kind{"minPages": Int, "title": String}, Resolve: func(_ context.Context, _ interface{}, args Args) (interface{}, error) {
+			min, _ := args.Int("minPages")
+			out := []book{}
+			for _, b := range books {
+				if b.Pages >= min && (args.String("title") == "" || b.Title == args.String("title")) {
+					out = append(out, b)
+				}
+			}
+			return out, nil
+		}},
+		"tags": {Resolve: func(context.Context, interface{}, Args) (interface{}, error) {
+			return []string{"fiction"}, nil
+		}},
+	}}
+	return New(query, Metrics{})
+}
+
+// run executes query and returns the response as JSON
+func run(t *testing.T, req Request) string {
+	t.Helper()
+	data, err := json.Marshal(testSchema().Execute(context.Background(), req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name string
+		req  Request
+		want string
+	}{
+		{
+			name: "fields in selection order",
+			req:  Request{Query: `{ books(minPages: 400) { title pages } tags }`},
+			want: `{"data":{"books":[{"title":"Dune","pages":412},{"title":"Emma","pages":474}],"tags":["fiction"]}}`,
+		},
+		{
+			name: "aliases and nested objects",
+			req:  Request{Query: `query { short: books(minPages: 450) { name: title author { name __typename } } }`},
+			want: `{"data":{"short":[{"name":"Emma","author":{"name":"Austen","__typename":"Author"}}]}}`,
+		},
+		{
+			name: "variables and defaults",
+			req: Request{
+				Query:     `query Books($min: Int = 1000, $title: String) { books(minPages: $min, title: $title) { title } }`,
+				Variables: map[string]interface{}{"min": float64(0), "title": "Ubik"},
+			},
+			want: `{"data":{"books":[{"title":"Ubik"}]}}`,
+		},
+		{
+			name: "default used when variable omitted",
+			req:  Request{Query: `query($min: Int = 1000) { books(minPages: $min) { title } }`},
+			want: `{"data":{"books":[]}}`,
+		},
+		{
+			name: "fragments merge with fields",
+			req: Request{Query: `
+				fragment Basics on Book { title }
+				{ books(title: "Dune") { ...Basics pages ... on Book { author { name } } ... @skip(if: true) { title: pages } } }`},
+			want: `{"data":{"books":[{"title":"Dune","pages":412,"author":{"name":"Herbert"}}]}}`,
+		},
+		{
+			name: "include directive",
+			req: Request{
+				Query:     `query($full: Boolean!) { books(title: "Emma") { title pages @include(if: $full) } }`,
+				Variables: map[string]interface{}{"full": false},
+			},
+			want: `{"data":{"books":[{"title":"Emma"}]}}`,
+		},
+		{
+			name: "field errors leave the rest of the data",
+			req:  Request{Query: `{ books(title: "Ubik") { title price } }`},
+			want: `{"data":{"books":[{"title":"Ubik","price":null}]},"errors":[{"message":"price unavailable","path":["books",0,"price"]}]}`,
+		},
+		{
+			name: "argument of the wrong kind",
+			req:  Request{Query: `{ books(minPages: "many") { title } }`},
+			want: `{"data":{"books":null},"errors":[{"message":"argument \"minPages\" must be a Int","path":["books"]}]}`,
+		},
+		{
+			name: "operation chosen by name",
+			req:  Request{Query: `query A { tags } query B { books(title: "Dune") { pages } }`, OperationName: "B"},
+			want: `{"data":{"books":[{"pages":412}]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := run(t, tt.req); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`{ books { title `, "unexpected end of query"},
+		{`{ books { isbn } }`, `type Book has no field "isbn"`},
+		{`{ books(author: "x") { title } }`, `field "books" has no argument "author"`},
+		{`{ books }`, "needs a selection"},
+		{`{ tags { name } }`, "is a scalar"},
+		{`{ books { ...Missing } }`, `unknown fragment "Missing"`},
+		{`fragment A on Book { ...A } { books { ...A } }`, `fragment "A" spreads itself`},
+		{`fragment A on Author { name } { books { ...A } }`, "cannot be spread within Book"},
+		{`{ books(minPages: $min) { title } }`, "variable $min is not defined"},
+		{`query($min: Int!) { books(minPages: $min) { title } }`, "variable $min is required"},
+		{`mutation { tags }`, "mutation operations are not supported"},
+		{`query A { tags } query B { tags }`, "operationName is required"},
+		{`{ tags @defer }`, "unknown directive @defer"},
+		{"{ " + strings.Repeat("books { author { ", 7) + "name" + strings.Repeat(" } }", 7) + " }", "nested more than"},
+	}
+	for _, tt := range tests {
+		resp := testSchema().Execute(context.Background(), Request{Query: tt.query})
+		if resp.Data != nil || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, tt.want) {
+			t.Errorf("%s: got %+v, want error containing %q", tt.query, resp, tt.want)
+		}
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	doc, err := parse(`{ f(a: -1.5e2, b: "tab\there é", c: [1, true, null], d: {x: ENUM}, e: """
+		block
+		  text
+	""") }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := doc.operations[0].selections[0].(*field).args
+	e := &executor{}
+	got := map[string]interface{}{}
+	for _, arg := range args {
+		got[arg.name] = e.resolve(arg.value)
+	}
+	data, _ := json.Marshal(got)
+	want := `{"a":-150,"b":"tab\there é","c":[1,true,null],"d":{"x":"ENUM"},"e":"block\n  text"}`
+	if string(data) != want {
+		t.Errorf("got  %s\nwant %s", data, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	handler := testSchema().Handler()
+
+	body := `{"query":"query($t: String) { books(title: $t) { pages } }","variables":{"t":"Emma"}}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body)))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"data":{"books":[{"pages":474}]}}` {
+		t.Errorf("POST: got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/graphql?query=%7Btags%7D", nil))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"data":{"tags":["fiction"]}}` {
+		t.Errorf("GET: got %d %s", rec.Code, rec.Body)
+	}
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ nope }"}`)),
+		httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`not json`)),
+		httptest.NewRequest(http.MethodGet, "/graphql", nil),
+	} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"errors"`) {
+			t.Errorf("%s %s: got %d %s", req.Method, req.URL, rec.Code, rec.Body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/graphql", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE: got %d", rec.Code)
+	}
+}
@@ -0,0 +1,563 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is a parsed request: its operations and the fragments they use
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+// operation is one query in a document
+type operation struct {
+	kind       string // query, mutation or subscription
+	name       string
+	variables  []variableDef
+	selections []selection
+}
+
+// variableDef declares a variable of an operation
+type variableDef struct {
+	name     string
+	required bool // its type ends in !
+	def      value
+}
+
+// fragment is a named, reusable selection set
+type fragment struct {
+	name       string
+	on         string
+	selections []selection
+}
+
+// selection is a *field, a *spread of a named fragment or an *inline fragment
+type selection interface{}
+
+// field selects a field of an object, under alias if set
+type field struct {
+	alias, name string
+	args        []argument
+	directives  []directive
+	selections  []selection
+	line        int
+}
+
+// key returns the name of the field in the response
+func (f *field) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+// spread includes a named fragment
+type spread struct {
+	name       string
+	directives []directive
+}
+
+// inline applies a selection set when the object has type on, or always if on is empty
+type inline struct {
+	on         string
+	directives []directive
+	selections []selection
+}
+
+// argument is a named argument of a field or directive
+type argument struct {
+	name  string
+	value value
+}
+
+// directive is an @name(args) annotation
+type directive struct {
+	name string
+	args []argument
+}
+
+// value is a literal or a variable. Literals are nil, bool, int, float64,
+// string (also for enum values), []value or an object as []argument.
+type value struct {
+	variable string // set for $variable
+	literal  interface{}
+}
+
+// token kinds
+const (
+	tokEOF = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind int
+	text string
+	line int
+}
+
+// parser reads a document from the query text
+type parser struct {
+	src  string
+	pos  int
+	line int
+	tok  token
+}
+
+// SyntaxError is a query that cannot be parsed
+type SyntaxError struct {
+	Line    int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error on line %d: %s", e.Line, e.Message)
+}
+
+// parse reads a query document
+func parse(src string) (doc *document, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			syntaxErr, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			doc, err = nil, syntaxErr
+		}
+	}()
+
+	p := &parser{src: strings.TrimPrefix(src, "\ufeff"), line: 1}
+	p.next()
+	doc = &document{fragments: make(map[string]*fragment)}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek("{"):
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: p.selectionSet()})
+		case p.peekName("query"), p.peekName("mutation"), p.peekName("subscription"):
+			doc.operations = append(doc.operations, p.operation())
+		case p.peekName("fragment"):
+			f := p.fragment()
+			if _, ok := doc.fragments[f.name]; ok {
+				p.fail("fragment %q is defined more than once", f.name)
+			}
+			doc.fragments[f.name] = f
+		default:
+			p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		p.fail("no operation")
+	}
+	return doc, nil
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	panic(&SyntaxError{Line: p.tok.line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (p *parser) unexpected() {
+	if p.tok.kind == tokEOF {
+		p.fail("unexpected end of query")
+	}
+	p.fail("unexpected %q", p.tok.text)
+}
+
+// peek reports whether the current token is the punctuator text
+func (p *parser) peek(text string) bool {
+	return p.tok.kind == tokPunct && p.tok.text == text
+}
+
+// peekName reports whether the current token is the name text
+func (p *parser) peekName(text string) bool {
+	return p.tok.kind == tokName && p.tok.text == text
+}
+
+// skip consumes the punctuator text if it is next
+func (p *parser) skip(text string) bool {
+	if p.peek(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) {
+	if !p.skip(text) {
+		p.unexpected()
+	}
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != tokName {
+		p.unexpected()
+	}
+	name := p.tok.text
+	p.next()
+	return name
+}
+
+func (p *parser) operation() *operation {
+	op := &operation{kind: p.name()}
+	if p.tok.kind == tokName {
+		op.name = p.name()
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			p.expect("$")
+			v := variableDef{name: p.name()}
+			p.expect(":")
+			v.required = p.typeRef()
+			if p.skip("=") {
+				v.def = p.value(true)
+			}
+			op.variables = append(op.variables, v)
+		}
+	}
+	p.directives()
+	op.selections = p.selectionSet()
+	return op
+}
+
+// typeRef skips a type such as [String!]!, reporting whether it is non-null
+func (p *parser) typeRef() bool {
+	if p.skip("[") {
+		p.typeRef()
+		p.expect("]")
+	} else {
+		p.name()
+	}
+	return p.skip("!")
+}
+
+func (p *parser) fragment() *fragment {
+	p.next()
+	f := &fragment{name: p.name()}
+	if f.name == "on" {
+		p.fail("a fragment cannot be named on")
+	}
+	if p.name() != "on" {
+		p.fail("expected on after fragment name")
+	}
+	f.on = p.name()
+	p.directives()
+	f.selections = p.selectionSet()
+	return f
+}
+
+func (p *parser) selectionSet() []selection {
+	p.expect("{")
+	var selections []selection
+	for !p.skip("}") {
+		selections = append(selections, p.selection())
+	}
+	if len(selections) == 0 {
+		p.fail("empty selection set")
+	}
+	return selections
+}
+
+func (p *parser) selection() selection {
+	if p.skip("...") {
+		if p.peekName("on") {
+			p.next()
+			in := &inline{on: p.name()}
+			in.directives = p.directives()
+			in.selections = p.selectionSet()
+			return in
+		}
+		if p.tok.kind == tokName {
+			s := &spread{name: p.name()}
+			s.directives = p.directives()
+			return s
+		}
+		in := &inline{directives: p.directives()}
+		in.selections = p.selectionSet()
+		return in
+	}
+
+	f := &field{line: p.tok.line, name: p.name()}
+	if p.skip(":") {
+		f.alias, f.name = f.name, p.name()
+	}
+	f.args = p.arguments(false)
+	f.directives = p.directives()
+	if p.peek("{") {
+		f.selections = p.selectionSet()
+	}
+	return f
+}
+
+func (p *parser) arguments(constant bool) []argument {
+	if !p.skip("(") {
+		return nil
+	}
+	var args []argument
+	for !p.skip(")") {
+		arg := argument{name: p.name()}
+		p.expect(":")
+		arg.value = p.value(constant)
+		args = append(args, arg)
+	}
+	return args
+}
+
+func (p *parser) directives() []directive {
+	var directives []directive
+	for p.skip("@") {
+		directives = append(directives, directive{name: p.name(), args: p.arguments(false)})
+	}
+	return directives
+}
+
+// value reads a value; constant values cannot contain variables
+func (p *parser) value(constant bool) value {
+	tok := p.tok
+	switch tok.kind {
+	case tokPunct:
+		switch tok.text {
+		case "$":
+			if constant {
+				p.fail("variables are not allowed here")
+			}
+			p.next()
+			return value{variable: p.name()}
+		case "[":
+			p.next()
+			list := []value{}
+			for !p.skip("]") {
+				list = append(list, p.value(constant))
+			}
+			return value{literal: list}
+		case "{":
+			p.next()
+			object := []argument{}
+			for !p.skip("}") {
+				arg := argument{name: p.name()}
+				p.expect(":")
+				arg.value = p.value(constant)
+				object = append(object, arg)
+			}
+			return value{literal: object}
+		}
+	case tokInt:
+		p.next()
+		n, err := strconv.Atoi(tok.text)
+		if err != nil {
+			p.fail("invalid integer %s", tok.text)
+		}
+		return value{literal: n}
+	case tokFloat:
+		p.next()
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			p.fail("invalid number %s", tok.text)
+		}
+		return value{literal: f}
+	case tokString:
+		p.next()
+		return value{literal: tok.text}
+	case tokName:
+		p.next()
+		switch tok.text {
+		case "true":
+			return value{literal: true}
+		case "false":
+			return value{literal: false}
+		case "null":
+			return value{}
+		}
+		return value{literal: tok.text} // an enum value
+	}
+	p.unexpected()
+	return value{}
+}
+
+// next reads the next token, skipping whitespace, commas and comments
+func (p *parser) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if c == '\n' {
+			p.line++
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			break
+		}
+		p.pos++
+	}
+	p.tok = token{line: p.line}
+	if p.pos >= len(p.src) {
+		p.tok.kind = tokEOF
+		return
+	}
+
+	start := p.pos
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok.kind, p.tok.text = tokPunct, "..."
+	case strings.IndexByte("!$():=@[]{}|&", c) >= 0:
+		p.pos++
+		p.tok.kind, p.tok.text = tokPunct, string(c)
+	case c == '_' || isLetter(c):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok.kind, p.tok.text = tokName, p.src[start:p.pos]
+	case c == '-' || isDigit(c):
+		p.number()
+	case strings.HasPrefix(p.src[p.pos:], `"""`):
+		p.blockString()
+	case c == '"':
+		p.quoted()
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.tok.text = string(r)
+		p.fail("unexpected character %q", r)
+	}
+}
+
+func (p *parser) number() {
+	start := p.pos
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	digits := func() int {
+		n := 0
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.pos++
+			n++
+		}
+		return n
+	}
+	if digits() == 0 {
+		p.fail("invalid number")
+	}
+	p.tok.kind = tokInt
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		p.pos++
+		if digits() == 0 {
+			p.fail("invalid number")
+		}
+		p.tok.kind = tokFloat
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		if digits() == 0 {
+			p.fail("invalid number")
+		}
+		p.tok.kind = tokFloat
+	}
+	p.tok.text = p.src[start:p.pos]
+}
+
+func (p *parser) quoted() {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			p.fail("unterminated string")
+		}
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			p.tok.kind, p.tok.text = tokString, b.String()
+			return
+		case '\\':
+			if p.pos >= len(p.src) {
+				p.fail("unterminated string")
+			}
+			esc := p.src[p.pos]
+			p.pos++
+			switch esc {
+			case '"', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if p.pos+4 > len(p.src) {
+					p.fail("invalid unicode escape")
+				}
+				n, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+				if err != nil {
+					p.fail("invalid unicode escape")
+				}
+				p.pos += 4
+				b.WriteRune(rune(n))
+			default:
+				p.fail("invalid escape \\%c", esc)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// blockString reads a """triple quoted""" string, removing the common
+// indentation of its lines and blank first and last lines
+func (p *parser) blockString() {
+	p.pos += 3
+	end := strings.Index(p.src[p.pos:], `"""`)
+	for end > 0 && p.src[p.pos+end-1] == '\\' {
+		next := strings.Index(p.src[p.pos+end+3:], `"""`)
+		if next < 0 {
+			end = -1
+			break
+		}
+		end += 3 + next
+	}
+	if end < 0 {
+		p.fail("unterminated string")
+	}
+	raw := p.src[p.pos : p.pos+end]
+	p.pos += end + 3
+	p.line += strings.Count(raw, "\n")
+
+	lines := strings.Split(strings.ReplaceAll(raw, `\"""`, `"""`), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		lines[i] = lines[i][min(indent, len(lines[i])):]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	p.tok.kind, p.tok.text = tokString, strings.Join(lines, "\n")
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
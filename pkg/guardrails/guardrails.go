@@ -0,0 +1,190 @@
+package guardrails
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Violation describes why a check blocked some text
+type Violation struct {
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+// Check inspects text and returns a violation if it must be blocked
+type Check interface {
+	Name() string
+	Check(ctx context.Context, text string) (*Violation, error)
+}
+
+// RuleSet configures the checks applied in one direction
+type RuleSet struct {
+	MaxLength  int               `json:"max_length,omitempty"` // input only
+	Denylist   []string          `json:"denylist,omitempty"`   // regular expressions
+	PII        bool              `json:"pii,omitempty"`
+	Moderation *ModerationConfig `json:"moderation,omitempty"` // input only
+}
+
+// Config configures the input and output checks
+type Config struct {
+	Input  RuleSet `json:"input"`
+	Output RuleSet `json:"output"`
+}
+
+// LoadConfig reads a JSON guardrails config. An empty path returns an empty config.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read guardrails config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse guardrails config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Pipeline runs the configured checks on prompts before they are forwarded
+// and on generated output as it streams
+type Pipeline struct {
+	input  []Check
+	output []Check
+	blocks *prometheus.CounterVec // labels: rule
+}
+
+// New builds a pipeline from cfg, counting blocks in blocks
+func New(cfg Config, blocks *prometheus.CounterVec) (*Pipeline, error) {
+	input, err := buildChecks(cfg.Input, true)
+	if err != nil {
+		return nil, fmt.Errorf("input guardrails: %w", err)
+	}
+	output, err := buildChecks(cfg.Output, false)
+	if err != nil {
+		return nil, fmt.Errorf("output guardrails: %w", err)
+	}
+	return &Pipeline{input: input, output: output, blocks: blocks}, nil
+}
+
+func buildChecks(rules RuleSet, input bool) ([]Check, error) {
+	var checks []Check
+	if rules.MaxLength > 0 && input {
+		checks = append(checks, MaxLength(rules.MaxLength))
+	}
+	if len(rules.Denylist) > 0 {
+		denylist, err := NewDenylist(rules.Denylist)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, denylist)
+	}
+	if rules.PII {
+		checks = append(checks, PII{})
+	}
+	if rules.Moderation != nil && input {
+		checks = append(checks, NewModeration(*rules.Moderation))
+	}
+	return checks, nil
+}
+
+// Enabled reports whether any checks are configured
+func (p *Pipeline) Enabled() bool {
+	return len(p.input) > 0 || len(p.output) > 0
+}
+
+// CheckInput runs the input checks against a prompt
+func (p *Pipeline) CheckInput(ctx context.Context, text string) *Violation {
+	return p.run(ctx, p.input, text)
+}
+
+// CheckOutput runs the output checks against generated text. Streaming
+// callers pass a trailing window of the response rather than the whole text.
+func (p *Pipeline) CheckOutput(ctx context.Context, text string) *Violation {
+	return p.run(ctx, p.output, text)
+}
+
+// run returns the first violation. Checks that fail to run are logged and
+// skipped rather than blocking the request.
+func (p *Pipeline) run(ctx context.Context, checks []Check, text string) *Violation {
+	for _, check := range checks {
+		violation, err := check.Check(ctx, text)
+		if err != nil {
+			log := logger.GetLogger()
+			log.Warn().Err(err).Str("rule", check.Name()).Msg("Guardrail check failed")
+			continue
+		}
+		if violation != nil {
+			p.blocks.WithLabelValues(violation.Rule).Inc()
+			return violation
+		}
+	}
+	return nil
+}
+
+// MaxLength blocks text longer than the given number of characters
+type MaxLength int
+
+// Name returns the rule name
+func (m MaxLength) Name() string { return "max_length" }
+
+// Check blocks text over the limit
+func (m MaxLength) Check(ctx context.Context, text string) (*Violation, error) {
+	if n := len([]rune(text)); n > int(m) {
+		return &Violation{Rule: m.Name(), Reason: fmt.Sprintf("prompt is %d characters, the limit is %d", n, int(m))}, nil
+	}
+	return nil, nil
+}
+
+// Denylist blocks text matching any of its patterns
+type Denylist struct {
+	patterns []*regexp.Regexp
+}
+
+// NewDenylist compiles the given regular expressions
+func NewDenylist(patterns []string) (*Denylist, error) {
+	d := &Denylist{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid denylist pattern %q: %w", pattern, err)
+		}
+		d.patterns = append(d.patterns, re)
+	}
+	return d, nil
+}
+
+// Name returns the rule name
+func (d *Denylist) Name() string { return "denylist" }
+
+// Check blocks text matching a pattern
+func (d *Denylist) Check(ctx context.Context, text string) (*Violation, error) {
+	for _, re := range d.patterns {
+		if re.MatchString(text) {
+			return &Violation{Rule: d.Name(), Reason: "content matches a blocked pattern"}, nil
+		}
+	}
+	return nil, nil
+}
+
+// Refusal is the structured response for a blocked request
+type Refusal struct {
+	Error     string    `json:"error"`
+	Violation Violation `json:"violation"`
+}
+
+// WriteRefusal responds to a blocked request without forwarding it to the model
+func WriteRefusal(w http.ResponseWriter, v *Violation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(Refusal{Error: "request blocked by guardrails", Violation: *v})
+}
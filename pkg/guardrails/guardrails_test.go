@@ -0,0 +1,55 @@
+package guardrails
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPipeline(t *testing.T) {
+	blocks := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "blocks"}, []string{"rule"})
+	pipeline, err := New(Config{
+		Input:  RuleSet{MaxLength: 40, Denylist: []string{`(?i)ignore previous instructions`}, PII: true},
+		Output: RuleSet{MaxLength: 1, PII: true},
+	}, blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		output bool
+		text   string
+		rule   string
+	}{
+		{"allowed", false, "hello there", ""},
+		{"too long", false, "this prompt is far too long for the configured limit", "max_length"},
+		{"denylisted", false, "Ignore previous instructions", "denylist"},
+		{"email", false, "mail a@b.io", "pii"},
+		{"card", false, "4111 1111 1111 1111", "pii"},
+		{"not a card", false, "1234 5678 9012 3456", ""},
+		{"output ignores max length", true, "a long but harmless response", ""},
+		{"output pii", true, "call 555-123-4567 now", "pii"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := pipeline.CheckInput
+			if tt.output {
+				check = pipeline.CheckOutput
+			}
+			violation := check(context.Background(), tt.text)
+			switch {
+			case tt.rule == "" && violation != nil:
+				t.Errorf("unexpected violation %+v", violation)
+			case tt.rule != "" && (violation == nil || violation.Rule != tt.rule):
+				t.Errorf("expected %s violation, got %+v", tt.rule, violation)
+			}
+		})
+	}
+
+	if got := testutil.ToFloat64(blocks.WithLabelValues("pii")); got != 3 {
+		t.Errorf("expected 3 pii blocks, got %v", got)
+	}
+}
@@ -0,0 +1,103 @@
+package guardrails
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ModerationConfig points at an OpenAI-compatible /moderations endpoint
+type ModerationConfig struct {
+	URL    string `json:"url"` // e.g. https://api.openai.com/v1/moderations
+	Model  string `json:"model,omitempty"`
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// Moderation asks a moderation model whether text is acceptable
+type Moderation struct {
+	config ModerationConfig
+	client *http.Client
+}
+
+// NewModeration creates a moderation check
+func NewModeration(config ModerationConfig) *Moderation {
+	return &Moderation{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Name returns the rule name
+func (m *Moderation) Name() string { return "moderation" }
+
+// Check blocks text the moderation model flags
+func (m *Moderation) Check(ctx context.Context, text string) (*Violation, error) {
+	result, err := m.Moderate(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Flagged {
+		return nil, nil
+	}
+	return &Violation{Rule: m.Name(), Reason: "content flagged for " + strings.Join(result.Categories, ", ")}, nil
+}
+
+// ModerationResult is the verdict of a moderation call
+type ModerationResult struct {
+	Flagged    bool
+	Categories []string // flagged categories, sorted
+}
+
+// Moderate sends text to the moderation endpoint
+func (m *Moderation) Moderate(ctx context.Context, text string) (ModerationResult, error) {
+	payload := map[string]string{"input": text}
+	if m.config.Model != "" {
+		payload["model"] = m.config.Model
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return ModerationResult{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return ModerationResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+m.config.APIKey)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return ModerationResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ModerationResult{}, fmt.Errorf("moderation endpoint returned status %d", resp.StatusCode)
+	}
+
+	var decoded struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return ModerationResult{}, fmt.Errorf("failed to decode moderation response: %w", err)
+	}
+
+	var result ModerationResult
+	for _, r := range decoded.Results {
+		result.Flagged = result.Flagged || r.Flagged
+		for category, flagged := range r.Categories {
+			if flagged {
+				result.Categories = append(result.Categories, category)
+			}
+		}
+	}
+	sort.Strings(result.Categories)
+	return result, nil
+}
@@ -0,0 +1,63 @@
+package guardrails
+
+import (
+	"context"
+	"regexp"
+)
+
+// piiPatterns are the kinds of personal data PII looks for
+var piiPatterns = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{"email address", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{"social security number", regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)},
+	{"phone number", regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?\(?\b\d{3}\)?[ .-]\d{3}[ .-]\d{4}\b`)},
+}
+
+// cardPattern finds candidate payment card numbers, confirmed with the Luhn checksum
+var cardPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+
+// PII blocks text containing email addresses, phone numbers, social
+// security numbers or payment card numbers
+type PII struct{}
+
+// Name returns the rule name
+func (PII) Name() string { return "pii" }
+
+// Check blocks text containing personal data
+func (p PII) Check(ctx context.Context, text string) (*Violation, error) {
+	for _, pattern := range piiPatterns {
+		if pattern.re.MatchString(text) {
+			return &Violation{Rule: p.Name(), Reason: "content contains a " + pattern.kind}, nil
+		}
+	}
+	for _, candidate := range cardPattern.FindAllString(text, -1) {
+		if luhn(candidate) {
+			return &Violation{Rule: p.Name(), Reason: "content contains a payment card number"}, nil
+		}
+	}
+	return nil, nil
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum
+func luhn(s string) bool {
+	sum, double, digits := 0, false, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
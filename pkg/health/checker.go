@@ -0,0 +1,203 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Probe checks one dependency, returning nil when it is usable
+type Probe func(ctx context.Context) error
+
+// Backend is a dependency the server needs to serve requests. Backends
+// sharing a group are replicas: the group is usable while any of them is up.
+type Backend struct {
+	Name  string
+	Group string // defaults to Name
+	Probe Probe
+}
+
+// BackendStatus is the latest probe result for a backend
+type BackendStatus struct {
+	Name        string     `json:"name"`
+	Status      string     `json:"status"` // "up" or "down"
+	LatencyMs   float64    `json:"latency_ms"`
+	CheckedAt   time.Time  `json:"checked_at"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Metrics holds the collectors probe results are recorded in
+type Metrics struct {
+	Up      *prometheus.GaugeVec     // labels: backend; 1 when the last probe succeeded
+	Latency *prometheus.HistogramVec // labels: backend
+}
+
+// Checker probes backends for readiness, caching results so frequent
+// readiness checks don't load the backends
+type Checker struct {
+	backends []Backend
+	ttl      time.Duration
+	timeout  time.Duration
+	metrics  Metrics
+	draining atomic.Bool
+
+	mu       sync.Mutex
+	statuses map[string]BackendStatus
+	checked  time.Time
+}
+
+// NewChecker creates a checker that probes at most once per ttl, giving each
+// probe timeout to answer
+func NewChecker(ttl, timeout time.Duration, metrics Metrics, backends ...Backend) *Checker {
+	return &Checker{
+		backends: backends,
+		ttl:      ttl,
+		timeout:  timeout,
+		metrics:  metrics,
+		statuses: make(map[string]BackendStatus),
+	}
+}
+
+// Check returns the status of every backend, probing them again if the
+// cached results are older than the TTL
+func (c *Checker) Check(ctx context.Context) []BackendStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) >= c.ttl {
+		var wg sync.WaitGroup
+		results := make([]BackendStatus, len(c.backends))
+		for i, b := range c.backends {
+			wg.Add(1)
+			go func(i int, b Backend) {
+				defer wg.Done()
+				results[i] = c.probe(ctx, b)
+			}(i, b)
+		}
+		wg.Wait()
+		for _, status := range results {
+			c.statuses[status.Name] = status
+		}
+		c.checked = time.Now()
+	}
+
+	statuses := make([]BackendStatus, 0, len(c.backends))
+	for _, b := range c.backends {
+		statuses = append(statuses, c.statuses[b.Name])
+	}
+	return statuses
+}
+
+// probe runs one backend's probe, keeping its last error across successes
+func (c *Checker) probe(ctx context.Context, b Backend) BackendStatus {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := b.Probe(ctx)
+	latency := time.Since(start)
+
+	status := c.statuses[b.Name]
+	status.Name = b.Name
+	status.CheckedAt = start
+	status.LatencyMs = float64(latency.Microseconds()) / 1000
+	status.Status = "up"
+	if err != nil {
+		status.Status = "down"
+		status.LastError = err.Error()
+		status.LastErrorAt = &start
+	}
+
+	if c.metrics.Up != nil {
+		up := 0.0
+		if err == nil {
+			up = 1
+		}
+		c.metrics.Up.WithLabelValues(b.Name).Set(up)
+	}
+	if c.metrics.Latency != nil {
+		c.metrics.Latency.WithLabelValues(b.Name).Observe(latency.Seconds())
+	}
+	return status
+}
+
+// Drain makes the server report not ready from now on, so a load balancer
+// stops sending it requests while it shuts down
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Ready reports whether every backend group has at least one backend up
+// and the server is not shutting down
+func (c *Checker) Ready(ctx context.Context) (bool, []BackendStatus) {
+	statuses := c.Check(ctx)
+	if c.draining.Load() {
+		return false, statuses
+	}
+	groups := make(map[string]bool)
+	for i, b := range c.backends {
+		group := b.Group
+		if group == "" {
+			group = b.Name
+		}
+		groups[group] = groups[group] || statuses[i].Status == "up"
+	}
+	for _, up := range groups {
+		if !up {
+			return false, statuses
+		}
+	}
+	return true, statuses
+}
+
+// HandleReadiness serves the readiness check: 200 when the server is ready,
+// 503 otherwise, with the per-backend status either way
+func (c *Checker) HandleReadiness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ready, statuses := c.Ready(r.Context())
+		status, code := "ready", http.StatusOK
+		if c.draining.Load() {
+			status, code = "draining", http.StatusServiceUnavailable
+		} else if !ready {
+			status, code = "not_ready", http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   status,
+			"backends": statuses,
+		})
+	}
+}
+
+// HTTPProbe checks that GET url answers with a 2xx status, e.g. an
+// OpenAI-compatible /models endpoint
+func HTTPProbe(client *http.Client, url, apiKey string) Probe {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		if apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("GET %s returned status %d", strings.TrimRight(url, "/"), resp.StatusCode)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,130 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCheckerCachesProbesAndKeepsLastError(t *testing.T) {
+	var calls atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+	probe := func(ctx context.Context) error {
+		calls.Add(1)
+		if failing.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "up"}, []string{"backend"})
+	checker := NewChecker(time.Hour, time.Second, Metrics{Up: up}, Backend{Name: "model", Probe: probe})
+
+	ready, statuses := checker.Ready(context.Background())
+	if ready || statuses[0].Status != "down" || statuses[0].LastError != "connection refused" {
+		t.Fatalf("ready = %v, statuses %+v", ready, statuses)
+	}
+	failing.Store(false)
+	checker.Check(context.Background())
+	if calls.Load() != 1 {
+		t.Errorf("probed %d times within the TTL, want 1", calls.Load())
+	}
+
+	checker.ttl = 0
+	ready, statuses = checker.Ready(context.Background())
+	if !ready || statuses[0].Status != "up" {
+		t.Fatalf("ready = %v, statuses %+v", ready, statuses)
+	}
+	if statuses[0].LastError != "connection refused" || statuses[0].LastErrorAt == nil {
+		t.Errorf("last error was not kept after recovery: %+v", statuses[0])
+	}
+	if got := testutil.ToFloat64(up.WithLabelValues("model")); got != 1 {
+		t.Errorf("up gauge = %v, want 1", got)
+	}
+}
+
+func TestReadinessNeedsOneReplicaPerGroup(t *testing.T) {
+	up := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("down") }
+
+	checker := NewChecker(0, time.Second, Metrics{},
+		Backend{Name: "model:a", Group: "model", Probe: down},
+		Backend{Name: "model:b", Group: "model", Probe: up},
+	)
+	if ready, _ := checker.Ready(context.Background()); !ready {
+		t.Error("expected ready while one model replica is up")
+	}
+
+	checker = NewChecker(0, time.Second, Metrics{},
+		Backend{Name: "model:a", Group: "model", Probe: up},
+		Backend{Name: "qdrant", Probe: down},
+	)
+	if ready, _ := checker.Ready(context.Background()); ready {
+		t.Error("expected not ready while qdrant is down")
+	}
+}
+
+func TestHandleReadinessProbesUpstream(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models" || r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("unexpected probe %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer upstream.Close()
+
+	checker := NewChecker(0, time.Second, Metrics{}, Backend{Name: "model", Probe: HTTPProbe(upstream.Client(), upstream.URL+"/models", "key")})
+	handler := checker.HandleReadiness()
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+
+	status.Store(http.StatusServiceUnavailable)
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	var body struct {
+		Status   string          `json:"status"`
+		Backends []BackendStatus `json:"backends"`
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusServiceUnavailable || body.Status != "not_ready" || len(body.Backends) != 1 || body.Backends[0].LastError == "" {
+		t.Errorf("status %d, body %+v", rec.Code, body)
+	}
+}
+
+func TestDrainingAndStartupProbes(t *testing.T) {
+	checker := NewChecker(0, time.Second, Metrics{}, Backend{Name: "model", Probe: func(ctx context.Context) error { return nil }})
+	checker.Drain()
+	rec := httptest.NewRecorder()
+	checker.HandleReadiness()(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"draining"`) {
+		t.Errorf("readiness while draining = %d %s", rec.Code, rec.Body)
+	}
+
+	var startup Startup
+	rec = httptest.NewRecorder()
+	startup.Handler()(rec, httptest.NewRequest(http.MethodGet, "/health/startup", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("startup before Done = %d", rec.Code)
+	}
+	startup.Done()
+	rec = httptest.NewRecorder()
+	startup.Handler()(rec, httptest.NewRequest(http.MethodGet, "/health/startup", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("startup after Done = %d", rec.Code)
+	}
+}
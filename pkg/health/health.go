@@ -2,11 +2,13 @@ package health
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"runtime"
+	"sync/atomic"
 	"time"
 
-	"github.com/ajeetraina/genai-app-demo/pkg/metrics"
+	"github.com/ajeetraina/aiwatch/pkg/metrics"
 	"github.com/rs/zerolog/log"
 )
 
@@ -57,17 +59,44 @@ func HandleHealth() http.HandlerFunc {
 	}
 }
 
-// HandleReadiness returns a readiness check handler
-func HandleReadiness() http.HandlerFunc {
+// HandleLiveness reports that the process is up and serving. It checks no
+// dependencies, so an orchestrator only restarts the server when it is stuck.
+func HandleLiveness() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// You can add more sophisticated checks here, such as:
-		// - Database connectivity
-		// - Model availability
-		// - External service dependencies
-
-		// For now, just return a basic OK response
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"status": "ready"})
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "alive",
+			"uptime": time.Since(startTime).String(),
+		})
+	}
+}
+
+// Startup tracks whether the server has finished starting, for an
+// orchestrator's startup probe. Liveness checks only begin once it passes,
+// so a slow start, such as pulling a model, isn't mistaken for a hang.
+type Startup struct {
+	started atomic.Bool
+}
+
+// Done marks startup as complete
+func (s *Startup) Done() {
+	s.started.Store(true)
+}
+
+// Handler serves the startup probe: 503 until Done is called, then 200
+func (s *Startup) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, code := "started", http.StatusOK
+		if !s.started.Load() {
+			status, code = "starting", http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": status,
+			"uptime": time.Since(startTime).String(),
+		})
 	}
 }
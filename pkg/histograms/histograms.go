@@ -0,0 +1,115 @@
+// Package histograms lets operators fit latency histograms to their models.
+// Fixed buckets suit only a narrow range of speeds: a small local model
+// answers well inside the first bucket, while a 70B model spreads over the
+// last few. Buckets can be set per histogram, or histograms can be exposed
+// as Prometheus native histograms, whose resolution adapts to the values
+// observed. The both mode exposes classic and native buckets side by side,
+// so dashboards built on _bucket series keep working while they move over.
+package histograms
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Mode selects how histograms are exposed
+type Mode string
+
+const (
+	ModeClassic Mode = "classic" // fixed buckets only, as before
+	ModeNative  Mode = "native"  // native buckets only
+	ModeBoth    Mode = "both"    // fixed and native buckets, for migrating dashboards
+)
+
+// ParseMode parses a mode name; "" means ModeClassic
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(strings.TrimSpace(s))); m {
+	case "":
+		return ModeClassic, nil
+	case ModeClassic, ModeNative, ModeBoth:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown histogram mode %q, want classic, native or both", s)
+}
+
+// Config sets how histograms are built
+type Config struct {
+	Mode Mode
+	// BucketFactor is the most one native bucket's upper bound may exceed
+	// the previous one's by, as a ratio; 1.1 keeps quantile error under 5%
+	BucketFactor float64
+	// MaxBuckets caps the native buckets of each series; past it, buckets
+	// are merged by widening the zero bucket, or reset if MinResetDuration
+	// has passed since the last reset
+	MaxBuckets       uint32
+	MinResetDuration time.Duration
+}
+
+// DefaultConfig keeps classic buckets, so existing dashboards are unaffected
+// until an operator opts in
+func DefaultConfig() Config {
+	return Config{
+		Mode:             ModeClassic,
+		BucketFactor:     1.1,
+		MaxBuckets:       160,
+		MinResetDuration: time.Hour,
+	}
+}
+
+// Opts returns opts adjusted for the config's mode. In ModeNative the
+// classic buckets are dropped; in ModeBoth they are kept, and must be set
+// explicitly since the client library omits its defaults alongside
+// native buckets.
+func (c Config) Opts(opts prometheus.HistogramOpts) prometheus.HistogramOpts {
+	if c.Mode != ModeNative && c.Mode != ModeBoth {
+		return opts
+	}
+	switch {
+	case c.Mode == ModeNative:
+		opts.Buckets = nil
+	case len(opts.Buckets) == 0:
+		opts.Buckets = prometheus.DefBuckets
+	}
+	opts.NativeHistogramBucketFactor = c.BucketFactor
+	if opts.NativeHistogramBucketFactor <= 1 {
+		opts.NativeHistogramBucketFactor = DefaultConfig().BucketFactor
+	}
+	opts.NativeHistogramMaxBucketNumber = c.MaxBuckets
+	opts.NativeHistogramMinResetDuration = c.MinResetDuration
+	return opts
+}
+
+// ParseBuckets parses a comma-separated list of bucket upper bounds in
+// seconds, such as "0.01,0.05,0.1". Bounds may also be written as
+// durations, such as "10ms,50ms,100ms,1s". "" gives nil.
+func ParseBuckets(s string) ([]float64, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	fields := strings.Split(s, ",")
+	buckets := make([]float64, 0, len(fields))
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		bound, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			d, derr := time.ParseDuration(f)
+			if derr != nil {
+				return nil, fmt.Errorf("invalid bucket %q: want seconds or a duration", f)
+			}
+			bound = d.Seconds()
+		}
+		if bound <= 0 || math.IsNaN(bound) || math.IsInf(bound, 0) {
+			return nil, fmt.Errorf("invalid bucket %q: must be a positive number of seconds", f)
+		}
+		if n := len(buckets); n > 0 && bound <= buckets[n-1] {
+			return nil, fmt.Errorf("buckets must increase: %v follows %v", bound, buckets[n-1])
+		}
+		buckets = append(buckets, bound)
+	}
+	return buckets, nil
+}
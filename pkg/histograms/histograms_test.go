@@ -0,0 +1,86 @@
+package histograms
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseBuckets(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []float64
+		err  bool
+	}{
+		{in: "", want: nil},
+		{in: "0.01, 0.05,0.1,1", want: []float64{0.01, 0.05, 0.1, 1}},
+		{in: "10ms,250ms,2s,1m", want: []float64{0.01, 0.25, 2, 60}},
+		{in: "1,0.5", err: true},
+		{in: "1,1", err: true},
+		{in: "0,1", err: true},
+		{in: "-1", err: true},
+		{in: "fast", err: true},
+		{in: "1,,2", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBuckets(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseBuckets(%q) error %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ParseBuckets(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for in, want := range map[string]Mode{"": ModeClassic, "classic": ModeClassic, "Native": ModeNative, " both ": ModeBoth} {
+		if got, err := ParseMode(in); err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseMode("sparse"); err == nil {
+		t.Error("ParseMode accepted an unknown mode")
+	}
+}
+
+func written(t *testing.T, c Config, buckets []float64) *dto.Histogram {
+	t.Helper()
+	h := prometheus.NewHistogram(c.Opts(prometheus.HistogramOpts{Name: "latency", Buckets: buckets}))
+	for _, v := range []float64{0.003, 0.04, 0.7, 12} {
+		h.Observe(v)
+	}
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram()
+}
+
+func TestOpts(t *testing.T) {
+	c := DefaultConfig()
+	buckets := []float64{0.1, 1, 10}
+
+	got := written(t, c, buckets)
+	if len(got.GetBucket()) != 3 || got.Schema != nil {
+		t.Errorf("classic: %d buckets, schema %v; want 3 classic buckets only", len(got.GetBucket()), got.Schema)
+	}
+
+	c.Mode = ModeNative
+	got = written(t, c, buckets)
+	if len(got.GetBucket()) != 0 || got.Schema == nil || len(got.GetPositiveSpan()) == 0 {
+		t.Errorf("native: %d classic buckets, schema %v; want native buckets only", len(got.GetBucket()), got.Schema)
+	}
+
+	c.Mode = ModeBoth
+	got = written(t, c, buckets)
+	if len(got.GetBucket()) != 3 || got.Schema == nil {
+		t.Errorf("both: %d classic buckets, schema %v; want both", len(got.GetBucket()), got.Schema)
+	}
+	if got = written(t, c, nil); len(got.GetBucket()) != len(prometheus.DefBuckets) {
+		t.Errorf("both without buckets: %d classic buckets, want the defaults", len(got.GetBucket()))
+	}
+}
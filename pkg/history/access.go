@@ -0,0 +1,57 @@
+package history
+
+import (
+	"context"
+	"net/http"
+)
+
+// Caller is who a request acts for when it reads or changes conversations
+type Caller struct {
+	// Owner is the identity the caller's conversations are recorded under,
+	// such as a signed-in user, an API key or a session
+	Owner string
+	// Session is the caller's session, which owns the conversations recorded
+	// before owners were
+	Session string
+	// Admin callers may see every conversation of their tenant
+	Admin bool
+}
+
+// Allows reports whether the caller may read, continue or delete c. Tenants
+// are checked separately.
+func (caller Caller) Allows(c Conversation) bool {
+	return caller.Admin || c.OwnedBy(caller.Owner, caller.Session)
+}
+
+// OwnedBy reports whether owner started c, or for a conversation recorded
+// without an owner, whether it was recorded in session
+func (c Conversation) OwnedBy(owner, session string) bool {
+	if c.Owner != "" {
+		return c.Owner == owner
+	}
+	return c.User != "" && c.User == session
+}
+
+type callerKey struct{}
+
+// WithCaller returns a context carrying caller
+func WithCaller(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext returns the caller stored by Middleware. Without one,
+// the zero Caller is returned, which is allowed no conversations.
+func CallerFromContext(ctx context.Context) Caller {
+	caller, _ := ctx.Value(callerKey{}).(Caller)
+	return caller
+}
+
+// Middleware stores the caller identify returns for each request in its
+// context, for the history API and other readers of conversations
+func Middleware(identify func(*http.Request) Caller) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithCaller(r.Context(), identify(r))))
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/tenants"
+)
+
+// defaultListLimit caps GET /conversations when no limit is given
+const defaultListLimit = 50
+
+// Handler serves the conversation history API, through which clients of the
+// chat's X-Conversation-ID memory list, resume and erase their
+// conversations. Callers only see the conversations they started, as the
+// Caller in the request context records, within their own tenant; admins
+// see all of the tenant's.
+func (s *Store) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /conversations", s.handleList)
+	mux.HandleFunc("GET /conversations/search", s.handleSearch)
+	mux.HandleFunc("GET /conversations/{id}", s.handleGet)
+	mux.HandleFunc("DELETE /conversations/{id}", s.handleDelete)
+	return mux
+}
+
+func (s *Store) handleList(w http.ResponseWriter, r *http.Request) {
+	limit := defaultListLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			apierror.Write(w, r, apierror.InvalidRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	tenant := tenants.FromContext(r.Context())
+	caller := CallerFromContext(r.Context())
+	category := r.URL.Query().Get("category")
+	summaries, err := s.ListWhere(limit, func(c Conversation) bool {
+		return tenants.Same(c.Tenant, tenant) && caller.Allows(c) && (category == "" || slices.Contains(c.Categories, category))
+	})
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+// ParseQuery reads a search's q, model, user, rating (1, -1 or 0), limit
+// and from/to range of last update from request parameters. Times are RFC
+// 3339 or dates; a "to" date includes that whole day. Tenant is left empty.
+func ParseQuery(params url.Values) (Query, error) {
+	q := Query{
+		Text:  params.Get("q"),
+		Model: params.Get("model"),
+		User:  params.Get("user"),
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return Query{}, errors.New("invalid limit")
+		}
+		q.Limit = n
+	}
+	if v := params.Get("rating"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < -1 || n > 1 {
+			return Query{}, errors.New("rating must be 1, -1 or 0")
+		}
+		q.Rating = &n
+	}
+	var err error
+	if q.From, err = parseTime(params.Get("from"), false); err != nil {
+		return Query{}, fmt.Errorf("invalid from: %w", err)
+	}
+	if q.To, err = parseTime(params.Get("to"), true); err != nil {
+		return Query{}, fmt.Errorf("invalid to: %w", err)
+	}
+	return q, nil
+}
+
+// handleSearch serves GET /conversations/search?q= with the filters of
+// ParseQuery. mode=semantic searches by meaning instead of by words.
+func (s *Store) handleSearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q, err := ParseQuery(params)
+	if err != nil {
+		apierror.Write(w, r, apierror.InvalidRequest, err.Error())
+		return
+	}
+	if q.Text == "" {
+		apierror.Write(w, r, apierror.InvalidRequest, "q is required")
+		return
+	}
+	q.Tenant = tenants.FromContext(r.Context())
+	caller := CallerFromContext(r.Context())
+	q.Caller = &caller
+
+	var hits []Hit
+	switch mode := params.Get("mode"); mode {
+	case "", "text":
+		hits, err = s.Search(q)
+	case "semantic":
+		hits, err = s.SemanticSearch(r.Context(), q)
+	default:
+		apierror.Write(w, r, apierror.InvalidRequest, "mode must be text or semantic")
+		return
+	}
+	if errors.Is(err, ErrNoVectorIndex) {
+		apierror.WriteStatus(w, r, http.StatusNotImplemented, apierror.Unavailable, err.Error())
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal, err.Error())
+		return
+	}
+	if hits == nil {
+		hits = []Hit{}
+	}
+	writeJSON(w, http.StatusOK, hits)
+}
+
+// parseTime parses an RFC 3339 time or a date. With endOfDay, a date means
+// the end of that day.
+func parseTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, errors.New("want an RFC 3339 time or YYYY-MM-DD date")
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func (s *Store) handleGet(w http.ResponseWriter, r *http.Request) {
+	c, err := s.getOwn(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (s *Store) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.getOwn(r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.Delete(r.PathValue("id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getOwn returns the requested conversation if the caller may read it;
+// other callers' conversations are reported as not found
+func (s *Store) getOwn(r *http.Request) (Conversation, error) {
+	c, err := s.Get(r.PathValue("id"))
+	if err == nil && (!tenants.Same(c.Tenant, tenants.FromContext(r.Context())) || !CallerFromContext(r.Context()).Allows(c)) {
+		return Conversation{}, ErrNotFound
+	}
+	return c, err
+}
+
+// writeError maps store errors to error codes
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := apierror.Internal
+	switch {
+	case errors.Is(err, ErrNotFound):
+		code = apierror.NotFound
+	case errors.Is(err, ErrInvalidID):
+		code = apierror.InvalidRequest
+	}
+	apierror.Write(w, r, code, err.Error())
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
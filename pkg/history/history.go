@@ -0,0 +1,232 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a conversation doesn't exist
+	ErrNotFound = errors.New("conversation not found")
+
+	// ErrInvalidID is returned for conversation IDs that aren't safe to store
+	ErrInvalidID = errors.New("invalid conversation id")
+)
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// ValidID reports whether id can be used as a conversation ID
+func ValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// Message is a single turn of a stored conversation
+type Message struct {
+	ID        string    `json:"id,omitempty"` // server-generated ID of an assistant response
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	Model     string    `json:"model,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// Rating and Comment are feedback on this response in particular
+	Rating  int    `json:"rating,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// Conversation is the stored history of one chat
+type Conversation struct {
+	ID        string    `json:"id"`
+	Tenant    string    `json:"tenant,omitempty"` // empty for the default tenant
+	User      string    `json:"user,omitempty"`
+	Owner     string    `json:"owner,omitempty"` // identity that started it, see Caller
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Messages  []Message `json:"messages"`
+
+	// Experiment and Variant record the A/B test arm that served the conversation
+	Experiment string `json:"experiment,omitempty"`
+	Variant    string `json:"variant,omitempty"`
+
+	// Rating is the user's feedback: 1 (thumbs up), -1 (thumbs down) or 0 (none)
+	Rating  int    `json:"rating,omitempty"`
+	Comment string `json:"comment,omitempty"`
+
+	// Scores are the latest judge-model evaluation of the conversation, by criterion
+	Scores map[string]float64 `json:"scores,omitempty"`
+
+	// Categories are the topic and safety categories the conversation's
+	// exchanges were classified into, sorted
+	Categories []string `json:"categories,omitempty"`
+
+	// Title and Summary describe the conversation for the history browser.
+	// SummaryMessages is how many messages they were written from.
+	Title           string `json:"title,omitempty"`
+	Summary         string `json:"summary,omitempty"`
+	SummaryMessages int    `json:"summary_messages,omitempty"`
+}
+
+// Summary is the listing view of a conversation
+type Summary struct {
+	ID           string    `json:"id"`
+	Tenant       string    `json:"tenant,omitempty"`
+	User         string    `json:"user,omitempty"`
+	Model        string    `json:"model"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	MessageCount int       `json:"message_count"`
+	Preview      string    `json:"preview"`
+	Rating       int       `json:"rating,omitempty"`
+	Categories   []string  `json:"categories,omitempty"`
+	Title        string    `json:"title,omitempty"`
+	Summary      string    `json:"summary,omitempty"`
+}
+
+// previewLength is the number of characters of the first user message shown in listings
+const previewLength = 80
+
+// Summarize returns the listing view of c
+func (c Conversation) Summarize() Summary {
+	s := Summary{
+		ID:           c.ID,
+		Tenant:       c.Tenant,
+		User:         c.User,
+		Model:        c.Model,
+		CreatedAt:    c.CreatedAt,
+		UpdatedAt:    c.UpdatedAt,
+		MessageCount: len(c.Messages),
+		Rating:       c.Rating,
+		Categories:   c.Categories,
+		Title:        c.Title,
+		Summary:      c.Summary,
+	}
+	for _, msg := range c.Messages {
+		if msg.Role == "user" {
+			s.Preview = msg.Content
+			break
+		}
+	}
+	if runes := []rune(s.Preview); len(runes) > previewLength {
+		s.Preview = string(runes[:previewLength]) + "…"
+	}
+	return s
+}
+
+// size estimates the memory held by c in bytes
+func (c Conversation) size() int64 {
+	n := int64(len(c.ID)+len(c.User)+len(c.Model)+len(c.Experiment)+len(c.Variant)+len(c.Comment)+len(c.Title)+len(c.Summary)) + 128
+	for _, msg := range c.Messages {
+		n += int64(len(msg.ID)+len(msg.Role)+len(msg.Content)+len(msg.Model)+len(msg.Comment)) + 64
+	}
+	for _, category := range c.Categories {
+		n += int64(len(category)) + 16
+	}
+	return n
+}
+
+// clone returns a copy of c that shares no mutable state
+func (c Conversation) clone() Conversation {
+	c.Messages = append([]Message(nil), c.Messages...)
+	c.Categories = append([]string(nil), c.Categories...)
+	if c.Scores != nil {
+		scores := make(map[string]float64, len(c.Scores))
+		for k, v := range c.Scores {
+			scores[k] = v
+		}
+		c.Scores = scores
+	}
+	return c
+}
+
+// Backend is the durable storage behind the in-memory cache
+type Backend interface {
+	Get(id string) (Conversation, error)
+	Put(c Conversation) error
+	Delete(id string) error
+	List() ([]Conversation, error)
+}
+
+// DirBackend stores each conversation as a JSON file in Dir
+type DirBackend struct {
+	Dir string
+}
+
+// NewDirBackend creates dir if needed and returns a backend storing into it
+func NewDirBackend(dir string) (*DirBackend, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	return &DirBackend{Dir: dir}, nil
+}
+
+func (b *DirBackend) path(id string) string {
+	return filepath.Join(b.Dir, id+".json")
+}
+
+// Get reads a conversation from disk
+func (b *DirBackend) Get(id string) (Conversation, error) {
+	data, err := os.ReadFile(b.path(id))
+	if os.IsNotExist(err) {
+		return Conversation{}, ErrNotFound
+	}
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	var c Conversation
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Conversation{}, fmt.Errorf("failed to parse conversation %s: %w", id, err)
+	}
+	return c, nil
+}
+
+// Put writes a conversation to disk, replacing any previous version
+func (b *DirBackend) Put(c Conversation) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	tmp := b.path(c.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path(c.ID))
+}
+
+// Delete removes a conversation from disk
+func (b *DirBackend) Delete(id string) error {
+	err := os.Remove(b.path(id))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// List reads every stored conversation
+func (b *DirBackend) List() ([]Conversation, error) {
+	entries, err := os.ReadDir(b.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Conversation
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		c, err := b.Get(id)
+		if err != nil {
+			continue
+		}
+		list = append(list, c)
+	}
+	return list, nil
+}
@@ -0,0 +1,250 @@
+package history
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ajeetraina/aiwatch/pkg/tenants"
+)
+
+// Query is a conversation search. Text is matched against the messages,
+// title and summary: every word must appear, "quoted phrases" must appear
+// as written and a trailing * matches any word with that prefix. The other
+// fields filter the conversations searched.
+type Query struct {
+	Text   string
+	Model  string
+	User   string
+	Tenant string    // empty for the default tenant
+	From   time.Time // updated at or after, if set
+	To     time.Time // updated before, if set
+	Rating *int      // conversation rating: 1, -1 or 0 for unrated
+	Limit  int       // results, defaulting to 20
+	// Caller, if set, limits results to the conversations it may read
+	Caller *Caller
+}
+
+// Hit is a conversation found by a search, best matches first
+type Hit struct {
+	Summary
+	Score    float64   `json:"score"`
+	Snippets []Snippet `json:"snippets,omitempty"`
+}
+
+// Snippet is the text around a match in one message
+type Snippet struct {
+	Index int    `json:"index"` // position of the message in the conversation
+	Role  string `json:"role"`
+	Text  string `json:"text"`
+}
+
+// Search limits
+const (
+	defaultSearchLimit = 20
+	maxSnippets        = 3
+	snippetRadius      = 80 // bytes of context either side of a match
+)
+
+// Filter reports whether c passes the query's filters, ignoring its text
+func (q Query) Filter(c Conversation) bool {
+	tenant := q.Tenant
+	if tenant == "" {
+		tenant = tenants.Default
+	}
+	switch {
+	case !tenants.Same(c.Tenant, tenant):
+		return false
+	case q.Model != "" && c.Model != q.Model:
+		return false
+	case q.User != "" && c.User != q.User:
+		return false
+	case !q.From.IsZero() && c.UpdatedAt.Before(q.From):
+		return false
+	case !q.To.IsZero() && !c.UpdatedAt.Before(q.To):
+		return false
+	case q.Rating != nil && c.Rating != *q.Rating:
+		return false
+	case q.Caller != nil && !q.Caller.Allows(c):
+		return false
+	}
+	return true
+}
+
+// term is one part of a parsed search
+type term struct {
+	words  []string // a phrase when there are several
+	prefix bool
+}
+
+// parseTerms splits search text into words, phrases and prefixes
+func parseTerms(text string) []term {
+	var terms []term
+	for i, part := range strings.Split(text, `"`) {
+		if i%2 == 1 {
+			if words := tokenize(part); len(words) > 0 {
+				terms = append(terms, term{words: words})
+			}
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			prefix := strings.HasSuffix(field, "*")
+			for _, word := range tokenize(field) {
+				terms = append(terms, term{words: []string{word}, prefix: prefix})
+			}
+		}
+	}
+	return terms
+}
+
+// tokenize lowercases text and splits it into words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// count returns how often t occurs in words
+func (t term) count(words []string) int {
+	n := 0
+	for i := 0; i+len(t.words) <= len(words); i++ {
+		match := true
+		for j, w := range t.words {
+			if t.prefix && j == len(t.words)-1 {
+				match = strings.HasPrefix(words[i+j], w)
+			} else {
+				match = words[i+j] == w
+			}
+			if !match {
+				break
+			}
+		}
+		if match {
+			n++
+		}
+	}
+	return n
+}
+
+// document is a conversation prepared for scoring
+type document struct {
+	conv     Conversation
+	heading  []string   // title and summary words
+	messages [][]string // words of each message
+	length   int
+}
+
+// Search finds the conversations matching q's text and filters. Results
+// are ranked by TF-IDF, with title and summary matches counting double.
+func (s *Store) Search(q Query) ([]Hit, error) {
+	terms := parseTerms(q.Text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	byID, err := s.all()
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the conversations containing every term, counting in how many
+	// each term appears for its inverse document frequency
+	var docs []document
+	df := make([]int, len(terms))
+	for _, c := range byID {
+		if !q.Filter(c) {
+			continue
+		}
+		d := document{conv: c, heading: tokenize(c.Title + " " + c.Summary)}
+		for _, msg := range c.Messages {
+			words := tokenize(msg.Content)
+			d.messages = append(d.messages, words)
+			d.length += len(words)
+		}
+		all := true
+		for _, t := range terms {
+			if d.tf(t) == 0 {
+				all = false
+				break
+			}
+		}
+		if !all {
+			continue
+		}
+		for i := range terms {
+			df[i]++
+		}
+		docs = append(docs, d)
+	}
+
+	hits := make([]Hit, 0, len(docs))
+	for _, d := range docs {
+		score := 0.0
+		for i, t := range terms {
+			idf := math.Log(1 + float64(len(byID))/float64(df[i]))
+			// Dampen long conversations so they don't win on length alone
+			score += float64(d.tf(t)) / math.Sqrt(float64(d.length+1)) * idf
+		}
+		hits = append(hits, Hit{Summary: d.conv.Summarize(), Score: score, Snippets: d.snippets(terms)})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].UpdatedAt.After(hits[j].UpdatedAt)
+	})
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// tf returns the number of times t occurs in d, counting the title and
+// summary double
+func (d document) tf(t term) int {
+	n := 2 * t.count(d.heading)
+	for _, words := range d.messages {
+		n += t.count(words)
+	}
+	return n
+}
+
+// snippets returns text around the first matches in d's messages
+func (d document) snippets(terms []term) []Snippet {
+	var snippets []Snippet
+	for i, msg := range d.conv.Messages {
+		for _, t := range terms {
+			if t.count(d.messages[i]) == 0 {
+				continue
+			}
+			snippets = append(snippets, Snippet{Index: i, Role: msg.Role, Text: excerpt(msg.Content, t.words[0])})
+			break
+		}
+		if len(snippets) == maxSnippets {
+			break
+		}
+	}
+	return snippets
+}
+
+// excerpt returns the text around the first occurrence of word in text
+func excerpt(text, word string) string {
+	at := strings.Index(strings.ToLower(text), word)
+	if at < 0 || at > len(text) {
+		at = 0
+	}
+	start, end := max(at-snippetRadius, 0), min(at+len(word)+snippetRadius, len(text))
+	out := strings.ToValidUTF8(text[start:end], "")
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(text) {
+		out += "…"
+	}
+	return strings.Join(strings.Fields(out), " ")
+}
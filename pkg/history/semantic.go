@@ -0,0 +1,185 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/rag"
+	"github.com/google/uuid"
+)
+
+// ErrNoVectorIndex is returned by SemanticSearch when no vector index is configured
+var ErrNoVectorIndex = errors.New("semantic search is not configured")
+
+// maxEmbedText bounds the text of one message sent for embedding, in bytes
+const maxEmbedText = 8000
+
+// vectorCandidates is how many nearest messages are fetched per result
+// wanted, since several can belong to one conversation or be filtered out
+const vectorCandidates = 5
+
+// pointNamespace derives stable vector IDs from conversation and message, so
+// re-indexing a message replaces its vector rather than duplicating it
+var pointNamespace = uuid.MustParse("5b0f6c1e-9a57-4d0e-8f0c-3c2f4e7d9a61")
+
+// VectorIndex embeds conversation messages into a vector store for search
+// by meaning. Each message is one chunk whose document is its conversation.
+type VectorIndex struct {
+	store rag.Store
+	embed rag.Embedder
+}
+
+// NewVectorIndex creates an index storing embeddings from embed in store
+func NewVectorIndex(store rag.Store, embed rag.Embedder) *VectorIndex {
+	return &VectorIndex{store: store, embed: embed}
+}
+
+// Index embeds messages of a conversation, the first being at position
+// offset within it
+func (v *VectorIndex) Index(ctx context.Context, id string, offset int, messages ...Message) error {
+	var texts []string
+	var chunks []rag.Chunk
+	for i, msg := range messages {
+		text := strings.TrimSpace(msg.Content)
+		if text == "" {
+			continue
+		}
+		if len(text) > maxEmbedText {
+			text = strings.ToValidUTF8(text[:maxEmbedText], "")
+		}
+		index := offset + i
+		texts = append(texts, text)
+		chunks = append(chunks, rag.Chunk{
+			ID:         uuid.NewSHA1(pointNamespace, []byte(fmt.Sprintf("%s:%d", id, index))).String(),
+			DocumentID: id,
+			Title:      msg.Role,
+			Index:      index,
+			Text:       text,
+		})
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	vectors, err := v.embed(ctx, texts)
+	if err == nil && len(vectors) != len(chunks) {
+		err = fmt.Errorf("expected %d embeddings, got %d", len(chunks), len(vectors))
+	}
+	if err != nil {
+		return fmt.Errorf("embedding messages: %w", err)
+	}
+	for i := range chunks {
+		chunks[i].Vector = vectors[i]
+	}
+	return v.store.Upsert(ctx, chunks)
+}
+
+// Remove deletes the vectors of a conversation
+func (v *VectorIndex) Remove(ctx context.Context, id string) error {
+	return v.store.DeleteDocument(ctx, id)
+}
+
+// SetVectorIndex enables SemanticSearch. Deleting a conversation then also
+// deletes its vectors.
+func (s *Store) SetVectorIndex(v *VectorIndex) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vectors = v
+}
+
+// VectorIndex returns the index set by SetVectorIndex, or nil
+func (s *Store) VectorIndex() *VectorIndex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.vectors
+}
+
+// SemanticSearch finds the conversations whose messages are closest in
+// meaning to q's text, passing q's filters. A conversation scores as its
+// best matching message.
+func (s *Store) SemanticSearch(ctx context.Context, q Query) ([]Hit, error) {
+	v := s.VectorIndex()
+	if v == nil {
+		return nil, ErrNoVectorIndex
+	}
+	text := strings.TrimSpace(q.Text)
+	if text == "" {
+		return nil, nil
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	vectors, err := v.embed(ctx, []string{text})
+	if err == nil && len(vectors) != 1 {
+		err = fmt.Errorf("expected 1 embedding, got %d", len(vectors))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+	matches, err := v.store.Search(ctx, vectors[0], limit*vectorCandidates, "")
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*Hit)
+	var hits []*Hit
+	for _, m := range matches {
+		if m.Score <= 0 {
+			break // unrelated, as are any after it
+		}
+		if hit, ok := byID[m.DocumentID]; ok {
+			if len(hit.Snippets) < maxSnippets {
+				hit.Snippets = append(hit.Snippets, Snippet{Index: m.Index, Role: m.Title, Text: excerpt(m.Text, "")})
+			}
+			continue
+		}
+		c, err := s.Get(m.DocumentID)
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidID) {
+			// Deleted without its vectors, such as by an earlier release
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !q.Filter(c) {
+			byID[m.DocumentID] = &Hit{} // skip its other messages too
+			continue
+		}
+		hit := &Hit{
+			Summary:  c.Summarize(),
+			Score:    m.Score,
+			Snippets: []Snippet{{Index: m.Index, Role: m.Title, Text: excerpt(m.Text, "")}},
+		}
+		byID[m.DocumentID] = hit
+		hits = append(hits, hit)
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	results := make([]Hit, 0, min(len(hits), limit))
+	for _, hit := range hits {
+		if len(results) == limit {
+			break
+		}
+		results = append(results, *hit)
+	}
+	return results, nil
+}
+
+// removeVectors deletes a deleted conversation's vectors, logging failures
+// since the conversation itself is already gone
+func (s *Store) removeVectors(id string) {
+	v := s.VectorIndex()
+	if v == nil {
+		return
+	}
+	if err := v.Remove(context.Background(), id); err != nil {
+		log := logger.GetLogger()
+		log.Warn().Err(err).Str("conversation_id", id).Msg("Failed to delete conversation vectors")
+	}
+}
@@ -0,0 +1,359 @@
+package history
+
+import (
+	"container/list"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the collectors the store reports to
+type Metrics struct {
+	Lookups     *prometheus.CounterVec // labels: result (hit|miss)
+	Evictions   prometheus.Counter
+	MemoryBytes prometheus.Gauge
+	Entries     prometheus.Gauge
+}
+
+// Store keeps recently used conversations in a memory-bounded LRU in front
+// of a durable backend. Writes go through to the backend so evicting an
+// entry never loses data; reads only reach the backend on a cache miss.
+// Without a backend the store is purely in-memory and evicted
+// conversations are forgotten.
+type Store struct {
+	backend  Backend
+	maxBytes int64
+	metrics  Metrics
+
+	mu    sync.Mutex
+	lru   *list.List // of *entry, most recently used at the front
+	items map[string]*list.Element
+	bytes int64
+
+	vectors *VectorIndex // for semantic search, if configured
+}
+
+type entry struct {
+	conv Conversation
+	size int64
+}
+
+// New creates a store caching up to maxBytes of conversations in memory
+func New(backend Backend, maxBytes int64, metrics Metrics) *Store {
+	return &Store{
+		backend:  backend,
+		maxBytes: maxBytes,
+		metrics:  metrics,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns a conversation, loading it from the backend on a cache miss
+func (s *Store) Get(id string) (Conversation, error) {
+	if !ValidID(id) {
+		return Conversation{}, ErrInvalidID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, err := s.load(id)
+	if err != nil {
+		return Conversation{}, err
+	}
+	return c.clone(), nil
+}
+
+// load returns the cached conversation or reads it from the backend.
+// Callers must hold s.mu.
+func (s *Store) load(id string) (Conversation, error) {
+	if el, ok := s.items[id]; ok {
+		s.lru.MoveToFront(el)
+		s.metrics.Lookups.WithLabelValues("hit").Inc()
+		return el.Value.(*entry).conv, nil
+	}
+	s.metrics.Lookups.WithLabelValues("miss").Inc()
+
+	if s.backend == nil {
+		return Conversation{}, ErrNotFound
+	}
+	c, err := s.backend.Get(id)
+	if err != nil {
+		return Conversation{}, err
+	}
+	s.cache(c)
+	return c, nil
+}
+
+// Append adds messages to a conversation, creating it if it doesn't exist
+func (s *Store) Append(id, user, model string, messages ...Message) (Conversation, error) {
+	if !ValidID(id) {
+		return Conversation{}, ErrInvalidID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	c, err := s.load(id)
+	if errors.Is(err, ErrNotFound) {
+		c = Conversation{ID: id, User: user, CreatedAt: now}
+	} else if err != nil {
+		return Conversation{}, err
+	}
+
+	c = c.clone()
+	for _, msg := range messages {
+		if msg.Timestamp.IsZero() {
+			msg.Timestamp = now
+		}
+		c.Messages = append(c.Messages, msg)
+	}
+	if model != "" {
+		c.Model = model
+	}
+	c.UpdatedAt = now
+
+	if s.backend != nil {
+		if err := s.backend.Put(c); err != nil {
+			return Conversation{}, err
+		}
+	}
+	s.cache(c)
+	return c.clone(), nil
+}
+
+// Update applies fn to an existing conversation and stores the result
+func (s *Store) Update(id string, fn func(*Conversation)) (Conversation, error) {
+	if !ValidID(id) {
+		return Conversation{}, ErrInvalidID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, err := s.load(id)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	c = c.clone()
+	fn(&c)
+	c.ID = id
+
+	if s.backend != nil {
+		if err := s.backend.Put(c); err != nil {
+			return Conversation{}, err
+		}
+	}
+	s.cache(c)
+	return c.clone(), nil
+}
+
+// Delete removes a conversation from the cache and the backend, along with
+// its vectors
+func (s *Store) Delete(id string) error {
+	if err := s.delete(id); err != nil {
+		return err
+	}
+	s.removeVectors(id)
+	return nil
+}
+
+func (s *Store) delete(id string) error {
+	if !ValidID(id) {
+		return ErrInvalidID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, cached := s.items[id]
+	s.remove(id)
+	if s.backend == nil {
+		if !cached {
+			return ErrNotFound
+		}
+		return nil
+	}
+	err := s.backend.Delete(id)
+	if errors.Is(err, ErrNotFound) && cached {
+		return nil
+	}
+	return err
+}
+
+// all returns every stored conversation, cached versions first
+func (s *Store) all() (map[string]Conversation, error) {
+	s.mu.Lock()
+	byID := make(map[string]Conversation, len(s.items))
+	for id, el := range s.items {
+		byID[id] = el.Value.(*entry).conv
+	}
+	s.mu.Unlock()
+
+	if s.backend != nil {
+		stored, err := s.backend.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range stored {
+			if _, ok := byID[c.ID]; !ok {
+				byID[c.ID] = c
+			}
+		}
+	}
+	return byID, nil
+}
+
+// List returns summaries of stored conversations, most recently updated first
+func (s *Store) List(limit int) ([]Summary, error) {
+	return s.ListWhere(limit, nil)
+}
+
+// ListWhere is List restricted to the conversations match returns true for
+func (s *Store) ListWhere(limit int, match func(Conversation) bool) ([]Summary, error) {
+	byID, err := s.all()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]Summary, 0, len(byID))
+	for _, c := range byID {
+		if match == nil || match(c) {
+			summaries = append(summaries, c.Summarize())
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].UpdatedAt.After(summaries[j].UpdatedAt) })
+	if limit > 0 && len(summaries) > limit {
+		summaries = summaries[:limit]
+	}
+	return summaries, nil
+}
+
+// Conversations returns the stored conversations match returns true for,
+// oldest first
+func (s *Store) Conversations(match func(Conversation) bool) ([]Conversation, error) {
+	byID, err := s.all()
+	if err != nil {
+		return nil, err
+	}
+
+	var convs []Conversation
+	for _, c := range byID {
+		if match == nil || match(c) {
+			convs = append(convs, c.clone())
+		}
+	}
+	sort.Slice(convs, func(i, j int) bool { return convs[i].CreatedAt.Before(convs[j].CreatedAt) })
+	return convs, nil
+}
+
+// DeleteWhere removes every conversation match returns true for, such as
+// those of one user or those past a retention period, and returns their IDs
+func (s *Store) DeleteWhere(match func(Conversation) bool) ([]string, error) {
+	byID, err := s.all()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	for id, c := range byID {
+		if !match(c) {
+			continue
+		}
+		if err := s.Delete(id); err != nil && !errors.Is(err, ErrNotFound) {
+			return deleted, err
+		}
+		deleted = append(deleted, id)
+	}
+	sort.Strings(deleted)
+	return deleted, nil
+}
+
+// ClearFeedback erases ratings and comments given on responses from before
+// cutoff, and those on conversations last updated before it. It returns the
+// number of conversations changed.
+func (s *Store) ClearFeedback(cutoff time.Time) (int, error) {
+	byID, err := s.all()
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for id, c := range byID {
+		if !hasFeedbackBefore(c, cutoff) {
+			continue
+		}
+		_, err := s.Update(id, func(c *Conversation) {
+			if c.UpdatedAt.Before(cutoff) {
+				c.Rating, c.Comment = 0, ""
+			}
+			for i := range c.Messages {
+				if c.Messages[i].Timestamp.Before(cutoff) {
+					c.Messages[i].Rating, c.Messages[i].Comment = 0, ""
+				}
+			}
+		})
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// hasFeedbackBefore reports whether ClearFeedback would change c
+func hasFeedbackBefore(c Conversation, cutoff time.Time) bool {
+	if c.UpdatedAt.Before(cutoff) && (c.Rating != 0 || c.Comment != "") {
+		return true
+	}
+	for _, msg := range c.Messages {
+		if msg.Timestamp.Before(cutoff) && (msg.Rating != 0 || msg.Comment != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// cache inserts or refreshes c and evicts least recently used entries over
+// the memory budget. Callers must hold s.mu.
+func (s *Store) cache(c Conversation) {
+	s.remove(c.ID)
+
+	e := &entry{conv: c, size: c.size()}
+	s.items[c.ID] = s.lru.PushFront(e)
+	s.bytes += e.size
+
+	// Always keep the entry just inserted, even if it alone exceeds the budget
+	for s.bytes > s.maxBytes && s.lru.Len() > 1 {
+		oldest := s.lru.Back()
+		s.remove(oldest.Value.(*entry).conv.ID)
+		s.metrics.Evictions.Inc()
+	}
+	s.updateGauges()
+}
+
+// remove drops id from the cache. Callers must hold s.mu.
+func (s *Store) remove(id string) {
+	el, ok := s.items[id]
+	if !ok {
+		return
+	}
+	s.bytes -= el.Value.(*entry).size
+	s.lru.Remove(el)
+	delete(s.items, id)
+	s.updateGauges()
+}
+
+func (s *Store) updateGauges() {
+	s.metrics.MemoryBytes.Set(float64(s.bytes))
+	s.metrics.Entries.Set(float64(s.lru.Len()))
+}
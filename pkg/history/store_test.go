@@ -0,0 +1,394 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/rag"
+	"github.com/ajeetraina/aiwatch/pkg/tenants"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestMetrics() Metrics {
+	return Metrics{
+		Lookups:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "lookups"}, []string{"result"}),
+		Evictions:   prometheus.NewCounter(prometheus.CounterOpts{Name: "evictions"}),
+		MemoryBytes: prometheus.NewGauge(prometheus.GaugeOpts{Name: "memory_bytes"}),
+		Entries:     prometheus.NewGauge(prometheus.GaugeOpts{Name: "entries"}),
+	}
+}
+
+func TestStoreSpillsToBackend(t *testing.T) {
+	backend, err := NewDirBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := newTestMetrics()
+	// Room for roughly one conversation of this size
+	store := New(backend, 1500, metrics)
+
+	long := strings.Repeat("x", 1000)
+	for _, id := range []string{"first", "second"} {
+		if _, err := store.Append(id, "", "ai/model", Message{Role: "user", Content: long}); err != nil {
+			t.Fatalf("append %s: %v", id, err)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.Evictions); got != 1 {
+		t.Fatalf("expected 1 eviction, got %v", got)
+	}
+
+	// The evicted conversation is read back from the backend
+	c, err := store.Get("first")
+	if err != nil {
+		t.Fatalf("get evicted conversation: %v", err)
+	}
+	if len(c.Messages) != 1 || c.Messages[0].Content != long {
+		t.Errorf("unexpected conversation after spillover: %+v", c)
+	}
+	if got := testutil.ToFloat64(metrics.Lookups.WithLabelValues("miss")); got != 3 {
+		t.Errorf("expected 3 misses, got %v", got)
+	}
+
+	// ...and is now hot again
+	if _, err := store.Get("first"); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(metrics.Lookups.WithLabelValues("hit")); got != 1 {
+		t.Errorf("expected 1 hit, got %v", got)
+	}
+
+	summaries, err := store.List(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 {
+		t.Errorf("expected both conversations listed, got %d", len(summaries))
+	}
+}
+
+func TestStoreRejectsInvalidID(t *testing.T) {
+	store := New(nil, 1<<20, newTestMetrics())
+	if _, err := store.Append("../escape", "", "", Message{Role: "user"}); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("expected ErrInvalidID, got %v", err)
+	}
+	if _, err := store.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStoreDeletesByUserAcrossCacheAndBackend(t *testing.T) {
+	backend, err := NewDirBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Room for one conversation, so the others only live in the backend
+	store := New(backend, 300, newTestMetrics())
+	for _, c := range []struct{ id, user string }{{"a1", "alice"}, {"b1", "bob"}, {"a2", "alice"}} {
+		if _, err := store.Append(c.id, c.user, "ai/model", Message{Role: "user", Content: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := store.DeleteWhere(func(c Conversation) bool { return c.User == "alice" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 2 || deleted[0] != "a1" || deleted[1] != "a2" {
+		t.Errorf("deleted = %v", deleted)
+	}
+	for _, id := range deleted {
+		if _, err := store.Get(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s still readable: %v", id, err)
+		}
+	}
+	if _, err := store.Get("b1"); err != nil {
+		t.Errorf("other users' conversations must stay: %v", err)
+	}
+}
+
+func TestStoreClearsOldFeedback(t *testing.T) {
+	store := New(nil, 1<<20, newTestMetrics())
+	old := time.Now().Add(-48 * time.Hour)
+	store.Append("c", "", "ai/model",
+		Message{Role: "assistant", Content: "old", Timestamp: old},
+		Message{Role: "assistant", Content: "new"},
+	)
+	store.Update("c", func(c *Conversation) {
+		c.Rating, c.Comment = 1, "great"
+		c.Messages[0].Rating, c.Messages[0].Comment = -1, "wrong"
+		c.Messages[1].Rating = 1
+	})
+
+	changed, err := store.ClearFeedback(time.Now().Add(-24 * time.Hour))
+	if err != nil || changed != 1 {
+		t.Fatalf("changed = %d, %v", changed, err)
+	}
+	c, _ := store.Get("c")
+	if c.Messages[0].Rating != 0 || c.Messages[0].Comment != "" || c.Messages[1].Rating != 1 {
+		t.Errorf("messages = %+v, want only the old feedback cleared", c.Messages)
+	}
+	if c.Rating != 1 {
+		t.Errorf("feedback on a recently updated conversation was cleared")
+	}
+	if changed, _ := store.ClearFeedback(time.Now().Add(-24 * time.Hour)); changed != 0 {
+		t.Errorf("second pass changed %d conversations", changed)
+	}
+}
+
+// admin identifies every request as an admin, who sees all of a tenant's
+// conversations
+func admin(*http.Request) Caller {
+	return Caller{Admin: true}
+}
+
+func TestHandlerScopesConversationsToOwner(t *testing.T) {
+	store := New(nil, 1<<20, newTestMetrics())
+	store.Append("alice-chat", "", "m", Message{Role: "user", Content: "mine"})
+	store.Update("alice-chat", func(c *Conversation) { c.Owner = "user:alice" })
+	store.Append("bob-chat", "", "m", Message{Role: "user", Content: "theirs"})
+	store.Update("bob-chat", func(c *Conversation) { c.Owner = "user:bob" })
+	// Recorded before owners were, so owned by the session that started it
+	store.Append("old-chat", "session-a", "m", Message{Role: "user", Content: "old"})
+
+	handler := Middleware(func(r *http.Request) Caller {
+		return Caller{Owner: r.Header.Get("X-Owner"), Session: "session-a", Admin: r.Header.Get("X-Admin") != ""}
+	})(store.Handler())
+	do := func(method, target, owner string, isAdmin bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("X-Owner", owner)
+		if isAdmin {
+			req.Header.Set("X-Admin", "1")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	list := func(owner string, isAdmin bool) string {
+		var listed []Summary
+		json.NewDecoder(do(http.MethodGet, "/conversations", owner, isAdmin).Body).Decode(&listed)
+		ids := make([]string, len(listed))
+		for i, s := range listed {
+			ids[i] = s.ID
+		}
+		sort.Strings(ids)
+		return strings.Join(ids, ",")
+	}
+
+	if got := list("user:alice", false); got != "alice-chat,old-chat" {
+		t.Errorf("alice listed %s", got)
+	}
+	if got := list("", true); got != "alice-chat,bob-chat,old-chat" {
+		t.Errorf("admin listed %s", got)
+	}
+	if rec := do(http.MethodGet, "/conversations/bob-chat", "user:alice", false); rec.Code != http.StatusNotFound {
+		t.Errorf("alice read bob's conversation: %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/conversations/bob-chat", "user:alice", false); rec.Code != http.StatusNotFound {
+		t.Errorf("alice deleted bob's conversation: %d", rec.Code)
+	}
+	if _, err := store.Get("bob-chat"); err != nil {
+		t.Errorf("bob's conversation is gone: %v", err)
+	}
+	if rec := do(http.MethodDelete, "/conversations/alice-chat", "user:alice", false); rec.Code != http.StatusNoContent {
+		t.Errorf("alice deleting her conversation = %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/conversations/bob-chat", "", true); rec.Code != http.StatusOK {
+		t.Errorf("admin reading bob's conversation = %d", rec.Code)
+	}
+}
+
+func TestHandlerScopesConversationsToTenant(t *testing.T) {
+	store := New(nil, 1<<20, newTestMetrics())
+	store.Append("shared-team", "", "m", Message{Role: "user", Content: "default"})
+	store.Append("search-team", "", "m", Message{Role: "user", Content: "search"})
+	store.Update("search-team", func(c *Conversation) { c.Tenant = "search" })
+
+	registry := tenants.New(nil)
+	registry.Put(tenants.Tenant{ID: "search", APIKeys: []string{"key-search"}})
+	handler := registry.Middleware(Middleware(admin)(store.Handler()))
+	get := func(target, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var listed []Summary
+	json.NewDecoder(get("/conversations", "key-search").Body).Decode(&listed)
+	if len(listed) != 1 || listed[0].ID != "search-team" {
+		t.Errorf("search tenant listed %+v", listed)
+	}
+	if rec := get("/conversations/search-team", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("default tenant read another tenant's conversation: %d", rec.Code)
+	}
+	if rec := get("/conversations/shared-team", ""); rec.Code != http.StatusOK {
+		t.Errorf("default tenant's own conversation = %d", rec.Code)
+	}
+
+	store.Update("shared-team", func(c *Conversation) { c.Categories = []string{"coding"} })
+	json.NewDecoder(get("/conversations?category=coding", "").Body).Decode(&listed)
+	if len(listed) != 1 || listed[0].ID != "shared-team" || listed[0].Categories[0] != "coding" {
+		t.Errorf("listed by category %+v", listed)
+	}
+	json.NewDecoder(get("/conversations?category=travel", "").Body).Decode(&listed)
+	if len(listed) != 0 {
+		t.Errorf("listed by unused category %+v", listed)
+	}
+}
+
+func TestStoreSearch(t *testing.T) {
+	store := New(nil, 1<<20, newTestMetrics())
+	store.Append("docker", "alice", "ai/llama", Message{Role: "user", Content: "How do I shrink a Docker image?"},
+		Message{Role: "assistant", Content: "Use a multi-stage build and a slim base image."})
+	store.Append("k8s", "bob", "ai/gemma", Message{Role: "user", Content: "Explain Kubernetes deployments"},
+		Message{Role: "assistant", Content: "A deployment manages replica sets of a container image."})
+	store.Append("recipe", "alice", "ai/llama", Message{Role: "user", Content: "A recipe for bread"})
+	store.Update("k8s", func(c *Conversation) { c.Rating = -1 })
+
+	ids := func(hits []Hit, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, h := range hits {
+			out = append(out, h.ID)
+		}
+		return strings.Join(out, ",")
+	}
+	for _, tc := range []struct {
+		query Query
+		want  string
+	}{
+		{Query{Text: "image"}, "docker,k8s"}, // docker mentions it twice
+		{Query{Text: "image docker"}, "docker"},
+		{Query{Text: `"multi-stage build"`}, "docker"},
+		{Query{Text: `"stage multi"`}, ""},
+		{Query{Text: "deploy*"}, "k8s"},
+		{Query{Text: "deploy"}, ""},
+		{Query{Text: "image", Model: "ai/gemma"}, "k8s"},
+		{Query{Text: "image", User: "alice"}, "docker"},
+		{Query{Text: "image", Rating: new(int)}, "docker"},
+		{Query{Text: "image", From: time.Now().Add(time.Hour)}, ""},
+		{Query{Text: "image", Limit: 1}, "docker"},
+		{Query{Text: "image", Tenant: "other"}, ""},
+	} {
+		if got := ids(store.Search(tc.query)); got != tc.want {
+			t.Errorf("Search(%+v) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+
+	hits, _ := store.Search(Query{Text: "slim"})
+	if len(hits) != 1 || len(hits[0].Snippets) != 1 || hits[0].Snippets[0].Index != 1 ||
+		!strings.Contains(hits[0].Snippets[0].Text, "slim base image") {
+		t.Errorf("unexpected snippets %+v", hits)
+	}
+
+	// Titles count, even when the messages don't mention the term
+	store.Update("recipe", func(c *Conversation) { c.Title = "Sourdough baking" })
+	if got := ids(store.Search(Query{Text: "sourdough"})); got != "recipe" {
+		t.Errorf("search by title = %q", got)
+	}
+}
+
+// wordEmbedder embeds text as counts of a few words, so texts sharing
+// them are similar
+func wordEmbedder(ctx context.Context, texts []string) ([][]float64, error) {
+	vocabulary := []string{"container", "docker", "bread", "flour", "oven"}
+	vectors := make([][]float64, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float64, len(vocabulary))
+		for j, word := range vocabulary {
+			vectors[i][j] = float64(strings.Count(strings.ToLower(text), word))
+		}
+	}
+	return vectors, nil
+}
+
+func TestStoreSemanticSearch(t *testing.T) {
+	store := New(nil, 1<<20, newTestMetrics())
+	if _, err := store.SemanticSearch(context.Background(), Query{Text: "x"}); !errors.Is(err, ErrNoVectorIndex) {
+		t.Fatalf("expected ErrNoVectorIndex, got %v", err)
+	}
+
+	vectors := rag.NewMemoryStore()
+	index := NewVectorIndex(vectors, wordEmbedder)
+	store.SetVectorIndex(index)
+	ctx := context.Background()
+	for id, messages := range map[string][]Message{
+		"docker": {{Role: "user", Content: "my docker container exits"}, {Role: "assistant", Content: "check the container logs"}},
+		"baking": {{Role: "user", Content: "bread with rye flour"}},
+		"oven":   {{Role: "user", Content: "how hot should the oven be for bread"}},
+	} {
+		c, _ := store.Append(id, "", "m", messages...)
+		if err := index.Index(ctx, id, len(c.Messages)-len(messages), messages...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Indexing again replaces the vectors rather than duplicating them
+	c, _ := store.Get("baking")
+	index.Index(ctx, "baking", 0, c.Messages...)
+
+	hits, err := store.SemanticSearch(ctx, Query{Text: "flour and bread"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 2 || hits[0].ID != "baking" || hits[1].ID != "oven" || hits[0].Snippets[0].Text != "bread with rye flour" {
+		t.Fatalf("unexpected hits %+v", hits)
+	}
+	hits, _ = store.SemanticSearch(ctx, Query{Text: "containers", Limit: 5})
+	if len(hits) != 1 || hits[0].ID != "docker" || len(hits[0].Snippets) != 2 {
+		t.Errorf("expected one hit with both docker messages, got %+v", hits)
+	}
+
+	// Deleting a conversation deletes its vectors
+	store.Delete("baking")
+	matches, _ := vectors.Search(ctx, []float64{0, 0, 1, 1, 0}, 10, "")
+	for _, m := range matches {
+		if m.DocumentID == "baking" {
+			t.Errorf("vectors of a deleted conversation remain: %+v", m)
+		}
+	}
+}
+
+func TestHandlerSearch(t *testing.T) {
+	store := New(nil, 1<<20, newTestMetrics())
+	store.Append("mine", "", "m", Message{Role: "user", Content: "rust lifetimes"})
+	store.Append("theirs", "", "m", Message{Role: "user", Content: "rust traits"})
+	store.Update("theirs", func(c *Conversation) { c.Tenant = "search" })
+	handler := Middleware(admin)(store.Handler())
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	var hits []Hit
+	rec := get("/conversations/search?q=rust&from=2000-01-01&to=" + time.Now().UTC().Format(time.DateOnly))
+	json.NewDecoder(rec.Body).Decode(&hits)
+	if rec.Code != http.StatusOK || len(hits) != 1 || hits[0].ID != "mine" {
+		t.Errorf("search = %d %+v", rec.Code, hits)
+	}
+	for target, want := range map[string]int{
+		"/conversations/search":                       http.StatusBadRequest,
+		"/conversations/search?q=rust&rating=2":       http.StatusBadRequest,
+		"/conversations/search?q=rust&from=yesterday": http.StatusBadRequest,
+		"/conversations/search?q=rust&mode=fuzzy":     http.StatusBadRequest,
+		"/conversations/search?q=rust&mode=semantic":  http.StatusNotImplemented,
+	} {
+		if rec := get(target); rec.Code != want {
+			t.Errorf("GET %s = %d, want %d", target, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/session"
+	"github.com/ajeetraina/aiwatch/pkg/sse"
+)
+
+// maxBodyBytes caps a submitted job's request body
+const maxBodyBytes = 1 << 20
+
+// Handler serves POST /jobs and GET /jobs/{id}
+func (q *Queue) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", q.handleSubmit)
+	mux.HandleFunc("GET /jobs/{id}", q.handleGet)
+	return mux
+}
+
+func (q *Queue) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		apierror.Write(w, r, apierror.InvalidRequest, "invalid request body")
+		return
+	}
+	var options struct {
+		CallbackURL string `json:"callback_url"`
+	}
+	if err := json.Unmarshal(body, &options); err != nil {
+		apierror.Write(w, r, apierror.InvalidRequest, "invalid request body")
+		return
+	}
+	if options.CallbackURL != "" {
+		u, err := url.Parse(options.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			apierror.Write(w, r, apierror.InvalidRequest, "callback_url must be an http or https URL")
+			return
+		}
+	}
+
+	// Run the job as the same session, so it lands in the caller's history
+	header := r.Header.Clone()
+	if id := session.FromContext(r.Context()); id != "" {
+		header.Set(session.HeaderName, id)
+	}
+
+	job, err := q.Submit(Request{Body: body, Header: header, CallbackURL: options.CallbackURL})
+	switch {
+	case errors.Is(err, ErrQueueFull), errors.Is(err, ErrClosed):
+		w.Header().Set("Retry-After", "30")
+		apierror.Write(w, r, apierror.Unavailable, err.Error())
+		return
+	case err != nil:
+		apierror.Write(w, r, apierror.InvalidRequest, err.Error())
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (q *Queue) handleGet(w http.ResponseWriter, r *http.Request) {
+	job, ok := q.Get(r.PathValue("id"))
+	if !ok {
+		apierror.Write(w, r, apierror.NotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// HandlerRunner runs jobs through h as a POST to path, so they take the same
+// code path as interactive requests. The response body is the generated text,
+// followed by server-sent events such as "truncated" or "usage".
+func HandlerRunner(h http.Handler, path string) Runner {
+	return func(ctx context.Context, req Request) (Result, error) {
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, path, bytes.NewReader(req.Body))
+		if err != nil {
+			return Result{}, err
+		}
+		for name, values := range req.Header {
+			r.Header[name] = values
+		}
+		r.Header.Set("Content-Type", "application/json")
+
+		rec := &recorder{header: make(http.Header)}
+		h.ServeHTTP(rec, r)
+
+		if rec.status >= http.StatusBadRequest {
+			return Result{}, fmt.Errorf("generation failed with status %d: %s", rec.status, strings.TrimSpace(rec.body.String()))
+		}
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+
+		result := Result{ConversationID: rec.header.Get("X-Conversation-ID")}
+		result.Response, result.Truncated = splitEvent(rec.body.String())
+		return result, nil
+	}
+}
+
+// splitEvent separates the trailing events from the generated text and
+// returns the reason carried by a cut-short event, if there is one
+func splitEvent(body string) (text, reason string) {
+	text, trailer := sse.SplitTrailer(body)
+	events, _ := sse.Decode(strings.NewReader(strings.TrimPrefix(trailer, "\n\n")))
+	for _, ev := range events {
+		if ev.Name != "truncated" && ev.Name != "reconnect" {
+			continue
+		}
+		var data struct {
+			Reason string `json:"reason"`
+		}
+		if json.Unmarshal([]byte(ev.Data), &data) == nil && data.Reason != "" {
+			reason = data.Reason
+		}
+	}
+	return text, reason
+}
+
+// recorder buffers a handler's response in memory
+type recorder struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func (rec *recorder) Header() http.Header { return rec.header }
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(p)
+}
+
+// Flush is a no-op, so streaming handlers can run against the recorder
+func (rec *recorder) Flush() {}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
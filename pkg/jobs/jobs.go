@@ -0,0 +1,335 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrQueueFull is returned when the queue is at capacity
+var ErrQueueFull = errors.New("job queue is full")
+
+// ErrClosed is returned once the queue has been shut down
+var ErrClosed = errors.New("job queue is shut down")
+
+// Job statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// Callback delivery results
+const (
+	CallbackDelivered = "delivered"
+	CallbackFailed    = "failed"
+)
+
+// callbackAttempts is how often a completion webhook is tried
+const callbackAttempts = 3
+
+// Config tunes the queue
+type Config struct {
+	Workers   int           // jobs generated concurrently
+	QueueSize int           // jobs waiting beyond the running ones
+	Retention time.Duration // how long finished jobs can be fetched
+	Timeout   time.Duration // limit on a single job's run time
+}
+
+// DefaultConfig returns the defaults used when nothing is configured
+func DefaultConfig() Config {
+	return Config{
+		Workers:   2,
+		QueueSize: 100,
+		Retention: time.Hour,
+		Timeout:   10 * time.Minute,
+	}
+}
+
+// Request is a queued generation: the chat request body and the headers of
+// the request that submitted it, so the run is attributed to the same caller
+type Request struct {
+	Body        []byte
+	Header      http.Header
+	CallbackURL string
+}
+
+// Result is the output of a finished generation
+type Result struct {
+	Response       string `json:"response"`
+	ConversationID string `json:"conversation_id,omitempty"`
+	Truncated      string `json:"truncated,omitempty"` // why the server cut the response short, if it did
+}
+
+// Runner generates the response for a job
+type Runner func(ctx context.Context, req Request) (Result, error)
+
+// Job is the status and result of a queued generation
+type Job struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Result      *Result    `json:"result,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CallbackURL string     `json:"callback_url,omitempty"`
+	Callback    string     `json:"callback,omitempty"` // delivery result of the completion webhook
+}
+
+// Metrics holds the collectors the queue is recorded in
+type Metrics struct {
+	Depth     prometheus.Gauge       // jobs waiting to run
+	Jobs      *prometheus.CounterVec // labels: status
+	Wait      prometheus.Histogram   // time from submission to start
+	Duration  prometheus.Histogram   // time from start to finish
+	Callbacks *prometheus.CounterVec // labels: result
+}
+
+// Queue runs submitted generations on a fixed pool of workers
+type Queue struct {
+	config  Config
+	run     Runner
+	metrics Metrics
+	client  *http.Client
+
+	// Validate rejects malformed request bodies at submission, if set
+	Validate func(body []byte) error
+
+	pending chan *entry
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	jobs   map[string]*entry
+	closed bool
+}
+
+// entry is a job with the request it runs
+type entry struct {
+	job Job
+	req Request
+}
+
+// New creates a queue and starts its workers
+func New(config Config, run Runner, metrics Metrics) *Queue {
+	defaults := DefaultConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.Retention <= 0 {
+		config.Retention = defaults.Retention
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		config:  config,
+		run:     run,
+		metrics: metrics,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		pending: make(chan *entry, config.QueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		jobs:    make(map[string]*entry),
+	}
+	for i := 0; i < config.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Submit enqueues a generation and returns its job
+func (q *Queue) Submit(req Request) (Job, error) {
+	if q.Validate != nil {
+		if err := q.Validate(req.Body); err != nil {
+			return Job{}, err
+		}
+	}
+
+	e := &entry{
+		job: Job{
+			ID:          uuid.New().String(),
+			Status:      StatusQueued,
+			CreatedAt:   time.Now().UTC(),
+			CallbackURL: req.CallbackURL,
+		},
+		req: req,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return Job{}, ErrClosed
+	}
+	q.prune()
+	q.jobs[e.job.ID] = e
+	q.metrics.Depth.Inc()
+	select {
+	case q.pending <- e:
+	default:
+		delete(q.jobs, e.job.ID)
+		q.metrics.Depth.Dec()
+		return Job{}, ErrQueueFull
+	}
+	return e.job, nil
+}
+
+// Get returns a snapshot of a job
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.job, true
+}
+
+// Close stops accepting jobs, cancels the running ones and fails those
+// still waiting
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.pending)
+	q.mu.Unlock()
+
+	q.cancel()
+	q.wg.Wait()
+}
+
+// prune forgets finished jobs past the retention period. Callers hold q.mu.
+func (q *Queue) prune() {
+	cutoff := time.Now().Add(-q.config.Retention)
+	for id, e := range q.jobs {
+		if e.job.FinishedAt != nil && e.job.FinishedAt.Before(cutoff) {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for e := range q.pending {
+		q.metrics.Depth.Dec()
+		q.process(e)
+	}
+}
+
+// process runs one job and delivers its callback
+func (q *Queue) process(e *entry) {
+	log := logger.GetLogger()
+
+	started := time.Now().UTC()
+	q.mu.Lock()
+	e.job.Status = StatusRunning
+	e.job.StartedAt = &started
+	q.mu.Unlock()
+	q.metrics.Wait.Observe(started.Sub(e.job.CreatedAt).Seconds())
+
+	var (
+		result Result
+		err    error
+	)
+	if q.ctx.Err() != nil {
+		err = ErrClosed
+	} else {
+		ctx, cancel := q.ctx, context.CancelFunc(func() {})
+		if q.config.Timeout > 0 {
+			ctx, cancel = context.WithTimeout(q.ctx, q.config.Timeout)
+		}
+		result, err = q.run(ctx, e.req)
+		cancel()
+	}
+
+	finished := time.Now().UTC()
+	q.mu.Lock()
+	e.job.FinishedAt = &finished
+	if err != nil {
+		e.job.Status = StatusFailed
+		e.job.Error = err.Error()
+	} else {
+		e.job.Status = StatusCompleted
+		e.job.Result = &result
+	}
+	// The request body isn't needed once the job has run
+	e.req.Body = nil
+	snapshot := e.job
+	q.mu.Unlock()
+
+	q.metrics.Duration.Observe(finished.Sub(started).Seconds())
+	q.metrics.Jobs.WithLabelValues(snapshot.Status).Inc()
+	log.Info().Str("job_id", snapshot.ID).Str("status", snapshot.Status).Dur("duration", finished.Sub(started)).Msg("Generation job finished")
+
+	if snapshot.CallbackURL == "" {
+		return
+	}
+	delivery := CallbackDelivered
+	if err := q.deliver(snapshot); err != nil {
+		log.Warn().Err(err).Str("job_id", snapshot.ID).Msg("Failed to deliver job callback")
+		delivery = CallbackFailed
+	}
+	q.metrics.Callbacks.WithLabelValues(delivery).Inc()
+
+	q.mu.Lock()
+	e.job.Callback = delivery
+	q.mu.Unlock()
+}
+
+// deliver posts the finished job to its callback URL, retrying with backoff
+func (q *Queue) deliver(job Job) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err = q.post(job.CallbackURL, body)
+		if err == nil || attempt == callbackAttempts {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-q.ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+func (q *Queue) post(url string, body []byte) error {
+	req, err := http.NewRequestWithContext(q.ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned status %d", resp.StatusCode)
+	}
+	return nil
+}
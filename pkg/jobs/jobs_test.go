@@ -0,0 +1,137 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testMetrics() Metrics {
+	return Metrics{
+		Depth:     prometheus.NewGauge(prometheus.GaugeOpts{Name: "depth"}),
+		Jobs:      prometheus.NewCounterVec(prometheus.CounterOpts{Name: "jobs"}, []string{"status"}),
+		Wait:      prometheus.NewHistogram(prometheus.HistogramOpts{Name: "wait"}),
+		Duration:  prometheus.NewHistogram(prometheus.HistogramOpts{Name: "duration"}),
+		Callbacks: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "callbacks"}, []string{"result"}),
+	}
+}
+
+// waitFor polls a job until it has finished and delivered its callback
+func waitFor(t *testing.T, q *Queue, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, _ := q.Get(id)
+		if job.FinishedAt != nil && (job.CallbackURL == "" || job.Callback != "") {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+func TestJobRunsThroughHandlerAndCallsBack(t *testing.T) {
+	chat := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Conversation-ID", "conv-1")
+		fmt.Fprintf(w, "echo %s from %s", body, r.Header.Get("X-Session-ID"))
+		fmt.Fprint(w, "\n\nevent: truncated\ndata: {\"reason\":\"max_tokens\",\"tokens\":3}\n\n")
+	})
+
+	callbacks := make(chan Job, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var job Job
+		json.NewDecoder(r.Body).Decode(&job)
+		callbacks <- job
+	}))
+	defer webhook.Close()
+
+	metrics := testMetrics()
+	q := New(Config{Workers: 1}, HandlerRunner(chat, "/jobs"), metrics)
+	defer q.Close()
+
+	api := httptest.NewServer(q.Handler())
+	defer api.Close()
+	req, _ := http.NewRequest(http.MethodPost, api.URL+"/jobs", strings.NewReader(`{"message":"hi","callback_url":"`+webhook.URL+`"}`))
+	req.Header.Set("X-Session-ID", "session-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var submitted Job
+	json.NewDecoder(resp.Body).Decode(&submitted)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || submitted.ID == "" {
+		t.Fatalf("submit: status %d, job %+v", resp.StatusCode, submitted)
+	}
+
+	job := waitFor(t, q, submitted.ID)
+	if job.Status != StatusCompleted || job.Callback != CallbackDelivered {
+		t.Fatalf("job = %+v", job)
+	}
+	want := `echo {"message":"hi","callback_url":"` + webhook.URL + `"} from session-1`
+	if job.Result.Response != want || job.Result.ConversationID != "conv-1" || job.Result.Truncated != "max_tokens" {
+		t.Errorf("result = %+v", job.Result)
+	}
+	if delivered := <-callbacks; delivered.ID != job.ID || delivered.Status != StatusCompleted {
+		t.Errorf("callback got %+v", delivered)
+	}
+	if got := testutil.ToFloat64(metrics.Jobs.WithLabelValues(StatusCompleted)); got != 1 {
+		t.Errorf("completed jobs = %v, want 1", got)
+	}
+
+	resp, err = http.Get(api.URL + "/jobs/" + job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET job status = %d", resp.StatusCode)
+	}
+}
+
+func TestJobFailsOnHandlerError(t *testing.T) {
+	chat := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Invalid truncation strategy", http.StatusBadRequest)
+	})
+	q := New(Config{Workers: 1}, HandlerRunner(chat, "/jobs"), testMetrics())
+	defer q.Close()
+
+	submitted, err := q.Submit(Request{Body: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := waitFor(t, q, submitted.ID)
+	if job.Status != StatusFailed || !strings.Contains(job.Error, "Invalid truncation strategy") {
+		t.Errorf("job = %+v", job)
+	}
+}
+
+func TestSubmitRejectsWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	chat := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release })
+	q := New(Config{Workers: 1, QueueSize: 1}, HandlerRunner(chat, "/jobs"), testMetrics())
+	defer q.Close()
+	defer close(release)
+
+	// One job occupies the worker and one the queue
+	first, _ := q.Submit(Request{Body: []byte(`{}`)})
+	for job, _ := q.Get(first.ID); job.Status != StatusRunning; job, _ = q.Get(first.ID) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := q.Submit(Request{Body: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Submit(Request{Body: []byte(`{}`)}); err != ErrQueueFull {
+		t.Errorf("err = %v, want ErrQueueFull", err)
+	}
+}
@@ -0,0 +1,160 @@
+package kube
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Config applies a ConfigMap mounted as a directory, one file per key, as
+// environment variables. Variables set in the pod spec win over the
+// ConfigMap, so it only supplies what the pod spec leaves unset.
+type Config struct {
+	dir     string
+	reloads *prometheus.CounterVec // labels: result
+
+	mu    sync.Mutex
+	owned map[string]string // variables the ConfigMap set, with their value
+	fixed map[string]bool   // variables the pod spec set
+}
+
+// LoadConfig reads the ConfigMap mounted at dir into the environment
+func LoadConfig(dir string, reloads *prometheus.CounterVec) (*Config, error) {
+	c := &Config{dir: dir, reloads: reloads, owned: make(map[string]string), fixed: make(map[string]bool)}
+	values, err := readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		if _, set := os.LookupEnv(key); set {
+			c.fixed[key] = true
+			continue
+		}
+		os.Setenv(key, value)
+		c.owned[key] = value
+	}
+	return c, nil
+}
+
+// Keys returns the variables the ConfigMap supplies
+func (c *Config) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.owned))
+	for key := range c.owned {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Watch checks the ConfigMap every interval until ctx is done. Kubernetes
+// updates a mounted ConfigMap in place, so changed keys are applied to the
+// environment and passed to onChange, with "" for removed keys.
+func (c *Config) Watch(ctx context.Context, interval time.Duration, onChange func(key, value string)) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for key, value := range c.Reload() {
+					onChange(key, value)
+				}
+			}
+		}
+	}()
+}
+
+// Reload reads the ConfigMap again and returns the variables that changed
+func (c *Config) Reload() map[string]string {
+	log := logger.GetLogger()
+	values, err := readDir(c.dir)
+	if err != nil {
+		log.Error().Err(err).Str("dir", c.dir).Msg("Failed to reload ConfigMap")
+		c.count("error")
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := make(map[string]string)
+	for key, value := range values {
+		if c.fixed[key] {
+			continue
+		}
+		if previous, ok := c.owned[key]; ok && previous == value {
+			continue
+		}
+		if _, ok := c.owned[key]; !ok {
+			if _, set := os.LookupEnv(key); set {
+				c.fixed[key] = true
+				continue
+			}
+		}
+		os.Setenv(key, value)
+		c.owned[key] = value
+		changed[key] = value
+	}
+	for key := range c.owned {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+			delete(c.owned, key)
+			changed[key] = ""
+		}
+	}
+	if len(changed) > 0 {
+		keys := make([]string, 0, len(changed))
+		for key := range changed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		log.Info().Strs("keys", keys).Msg("ConfigMap changed")
+		c.count("changed")
+	}
+	return changed
+}
+
+func (c *Config) count(result string) {
+	if c.reloads != nil {
+		c.reloads.WithLabelValues(result).Inc()
+	}
+}
+
+// readDir reads a mounted ConfigMap. Kubernetes keeps the data in hidden
+// ..data entries behind one symlink per key, which are skipped.
+func readDir(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		values[entry.Name()] = strings.TrimRight(string(data), "\r\n")
+	}
+	return values, nil
+}
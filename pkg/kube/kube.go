@@ -0,0 +1,89 @@
+// Package kube adapts aiwatch to running as a Kubernetes pod: it finds the
+// pod's identity through the downward API, so metrics and traces can be
+// told apart per replica, and reads configuration from a mounted ConfigMap,
+// reloading it when the ConfigMap changes.
+package kube
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// namespaceFile holds the pod's namespace in every pod with a service account
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// Pod identifies the pod aiwatch runs in
+type Pod struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Node      string `json:"node,omitempty"`
+	// Enabled is set when running in a cluster, or when K8S_MODE forces it
+	Enabled bool `json:"enabled"`
+}
+
+// Detect finds the pod from the POD_NAME, POD_NAMESPACE and NODE_NAME
+// variables set through the downward API, falling back to the hostname and
+// the service account's namespace. K8S_MODE=true or false overrides
+// detecting the cluster from KUBERNETES_SERVICE_HOST.
+func Detect() Pod {
+	pod := Pod{
+		Name:      os.Getenv("POD_NAME"),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+		Node:      os.Getenv("NODE_NAME"),
+		Enabled:   os.Getenv("KUBERNETES_SERVICE_HOST") != "",
+	}
+	if mode, err := strconv.ParseBool(os.Getenv("K8S_MODE")); err == nil {
+		pod.Enabled = mode
+	}
+	if !pod.Enabled {
+		return pod
+	}
+	if pod.Name == "" {
+		pod.Name, _ = os.Hostname()
+	}
+	if pod.Namespace == "" {
+		if data, err := os.ReadFile(namespaceFile); err == nil {
+			pod.Namespace = strings.TrimSpace(string(data))
+		}
+	}
+	return pod
+}
+
+// Labels returns the pod and namespace labels added to every metric, or
+// none outside Kubernetes
+func (p Pod) Labels() prometheus.Labels {
+	labels := prometheus.Labels{}
+	if !p.Enabled {
+		return labels
+	}
+	if p.Name != "" {
+		labels["pod"] = p.Name
+	}
+	if p.Namespace != "" {
+		labels["namespace"] = p.Namespace
+	}
+	return labels
+}
+
+// Attributes returns the trace resource attributes describing the pod
+func (p Pod) Attributes() []attribute.KeyValue {
+	if !p.Enabled {
+		return nil
+	}
+	var attrs []attribute.KeyValue
+	if p.Name != "" {
+		attrs = append(attrs, semconv.K8SPodNameKey.String(p.Name))
+	}
+	if p.Namespace != "" {
+		attrs = append(attrs, semconv.K8SNamespaceNameKey.String(p.Namespace))
+	}
+	if p.Node != "" {
+		attrs = append(attrs, semconv.K8SNodeNameKey.String(p.Node))
+	}
+	return attrs
+}
@@ -0,0 +1,62 @@
+package kube
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectFromDownwardAPI(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	t.Setenv("K8S_MODE", "")
+	t.Setenv("POD_NAME", "aiwatch-7d9f-abcde")
+	t.Setenv("POD_NAMESPACE", "ml")
+	t.Setenv("NODE_NAME", "")
+	pod := Detect()
+	if !pod.Enabled || pod.Labels()["pod"] != "aiwatch-7d9f-abcde" || pod.Labels()["namespace"] != "ml" || len(pod.Attributes()) != 2 {
+		t.Errorf("pod = %+v, labels %v", pod, pod.Labels())
+	}
+
+	t.Setenv("K8S_MODE", "false")
+	if pod := Detect(); pod.Enabled || len(pod.Labels()) != 0 {
+		t.Errorf("K8S_MODE=false: %+v", pod)
+	}
+}
+
+func TestConfigMapReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(key, value string) {
+		os.WriteFile(filepath.Join(dir, key), []byte(value+"\n"), 0644)
+	}
+	write("AIWATCH_TEST_LEVEL", "info")
+	write("AIWATCH_TEST_FIXED", "from-configmap")
+	write("AIWATCH_TEST_REMOVED", "x")
+	os.Mkdir(filepath.Join(dir, "..data"), 0755)
+	t.Setenv("AIWATCH_TEST_FIXED", "from-pod-spec")
+	for _, key := range []string{"AIWATCH_TEST_LEVEL", "AIWATCH_TEST_REMOVED"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+
+	c, err := LoadConfig(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("AIWATCH_TEST_LEVEL") != "info" || os.Getenv("AIWATCH_TEST_FIXED") != "from-pod-spec" {
+		t.Errorf("env after load: level %q, fixed %q", os.Getenv("AIWATCH_TEST_LEVEL"), os.Getenv("AIWATCH_TEST_FIXED"))
+	}
+
+	write("AIWATCH_TEST_LEVEL", "debug")
+	write("AIWATCH_TEST_FIXED", "changed")
+	os.Remove(filepath.Join(dir, "AIWATCH_TEST_REMOVED"))
+	changed := c.Reload()
+	if len(changed) != 2 || changed["AIWATCH_TEST_LEVEL"] != "debug" || changed["AIWATCH_TEST_REMOVED"] != "" {
+		t.Errorf("changed = %v", changed)
+	}
+	if _, set := os.LookupEnv("AIWATCH_TEST_REMOVED"); set || os.Getenv("AIWATCH_TEST_FIXED") != "from-pod-spec" {
+		t.Error("removed key still set, or pod spec value overridden")
+	}
+	if len(c.Reload()) != 0 {
+		t.Error("unchanged ConfigMap reported changes")
+	}
+}
@@ -0,0 +1,249 @@
+package llamacpp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Stats is what the llama.cpp server reports about itself. Zero values were
+// not reported; which fields exist depends on the server version and on
+// whether it runs with --metrics.
+type Stats struct {
+	ContextSize        int
+	Threads            int
+	BatchSize          int
+	TokensPerSecond    float64 // average generation throughput
+	KVCacheUsage       float64 // 0 to 1
+	KVCacheTokens      float64
+	RequestsProcessing float64
+	HasKVCache         bool // /metrics reported the KV cache, so a zero usage is real
+}
+
+// Metrics holds the gauges scraped stats are copied into, all labelled by model
+type Metrics struct {
+	ContextSize     *prometheus.GaugeVec
+	Threads         *prometheus.GaugeVec
+	BatchSize       *prometheus.GaugeVec
+	TokensPerSecond *prometheus.GaugeVec
+	KVCacheUsage    *prometheus.GaugeVec
+	KVCacheTokens   *prometheus.GaugeVec
+}
+
+// Collector scrapes a llama.cpp server's /props and /metrics endpoints
+type Collector struct {
+	baseURL string
+	model   string
+	client  *http.Client
+	metrics Metrics
+}
+
+// New creates a collector for the server at baseURL (without the /v1 suffix
+// of its OpenAI API) reporting under the model label
+func New(baseURL, model string, metrics Metrics) *Collector {
+	return &Collector{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		model:   model,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		metrics: metrics,
+	}
+}
+
+// ServerURL derives the llama.cpp server root from an OpenAI base URL
+func ServerURL(baseURL string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/v1")
+}
+
+// Scrape reads both endpoints and updates the gauges with whatever they
+// reported. It fails only when neither endpoint answered.
+func (c *Collector) Scrape(ctx context.Context) (Stats, error) {
+	var stats Stats
+	propsErr := c.scrapeProps(ctx, &stats)
+	metricsErr := c.scrapeMetrics(ctx, &stats)
+	if propsErr != nil && metricsErr != nil {
+		return stats, fmt.Errorf("llama.cpp scrape failed: props: %v; metrics: %v", propsErr, metricsErr)
+	}
+
+	set := func(gauge *prometheus.GaugeVec, value float64) {
+		if gauge != nil && value > 0 {
+			gauge.WithLabelValues(c.model).Set(value)
+		}
+	}
+	set(c.metrics.ContextSize, float64(stats.ContextSize))
+	set(c.metrics.Threads, float64(stats.Threads))
+	set(c.metrics.BatchSize, float64(stats.BatchSize))
+	set(c.metrics.TokensPerSecond, stats.TokensPerSecond)
+	if metricsErr == nil {
+		// An idle server legitimately reports an empty cache
+		if c.metrics.KVCacheUsage != nil {
+			c.metrics.KVCacheUsage.WithLabelValues(c.model).Set(stats.KVCacheUsage)
+		}
+		if c.metrics.KVCacheTokens != nil {
+			c.metrics.KVCacheTokens.WithLabelValues(c.model).Set(stats.KVCacheTokens)
+		}
+	}
+	return stats, nil
+}
+
+// props is the part of /props we read. Settings moved between the top
+// level and default_generation_settings across server versions.
+type props struct {
+	NCtx     int `json:"n_ctx"`
+	NThreads int `json:"n_threads"`
+	NBatch   int `json:"n_batch"`
+	Defaults struct {
+		NCtx     int `json:"n_ctx"`
+		NThreads int `json:"n_threads"`
+		NBatch   int `json:"n_batch"`
+	} `json:"default_generation_settings"`
+}
+
+func (c *Collector) scrapeProps(ctx context.Context, stats *Stats) error {
+	body, err := c.get(ctx, "/props")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var p props
+	if err := json.NewDecoder(body).Decode(&p); err != nil {
+		return fmt.Errorf("failed to decode /props: %w", err)
+	}
+	stats.ContextSize = first(p.Defaults.NCtx, p.NCtx)
+	stats.Threads = first(p.Defaults.NThreads, p.NThreads)
+	stats.BatchSize = first(p.Defaults.NBatch, p.NBatch)
+	return nil
+}
+
+func (c *Collector) scrapeMetrics(ctx context.Context, stats *Stats) error {
+	body, err := c.get(ctx, "/metrics")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	values, err := parseMetrics(body)
+	if err != nil {
+		return err
+	}
+	stats.TokensPerSecond = values["llamacpp:predicted_tokens_seconds"]
+	stats.KVCacheUsage, stats.HasKVCache = values["llamacpp:kv_cache_usage_ratio"]
+	stats.KVCacheTokens = values["llamacpp:kv_cache_tokens"]
+	stats.RequestsProcessing = values["llamacpp:requests_processing"]
+	return nil
+}
+
+func (c *Collector) get(ctx context.Context, path string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s returned status %d", path, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// parseMetrics reads unlabelled samples from the Prometheus text format,
+// which is all llama.cpp exports
+func parseMetrics(r io.Reader) (map[string]float64, error) {
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name := fields[0]
+		if i := strings.IndexByte(name, '{'); i >= 0 {
+			name = name[:i]
+		}
+		if value, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			values[name] = value
+		}
+	}
+	return values, scanner.Err()
+}
+
+func first(values ...int) int {
+	for _, v := range values {
+		if v > 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+// Start scrapes on an interval until ctx is cancelled
+func (c *Collector) Start(ctx context.Context, interval time.Duration) {
+	log := logger.GetLogger()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := c.Scrape(ctx); err != nil {
+				log.Debug().Err(err).Str("url", c.baseURL).Msg("llama.cpp metrics scrape failed")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Timings is the accounting llama.cpp attaches to the last chunk of a
+// response. The draft fields are set when it runs with a draft model.
+type Timings struct {
+	PromptTokens    int     `json:"prompt_n"`
+	PromptMs        float64 `json:"prompt_ms"`
+	PredictedTokens int     `json:"predicted_n"`
+	PredictedMs     float64 `json:"predicted_ms"`
+	DraftTokens     int     `json:"draft_n"`
+	DraftAccepted   int     `json:"draft_n_accepted"`
+}
+
+// AcceptanceRate is the share of draft tokens the model accepted, or 0
+// without speculative decoding
+func (t Timings) AcceptanceRate() float64 {
+	if t.DraftTokens <= 0 {
+		return 0
+	}
+	return float64(t.DraftAccepted) / float64(t.DraftTokens)
+}
+
+// ParseTimings reads the timings object from a response or stream chunk. It
+// reports false when the chunk carries none.
+func ParseTimings(raw string) (Timings, bool) {
+	if !strings.Contains(raw, `"timings"`) {
+		return Timings{}, false
+	}
+	var body struct {
+		Timings *Timings `json:"timings"`
+	}
+	if err := json.Unmarshal([]byte(raw), &body); err != nil || body.Timings == nil {
+		return Timings{}, false
+	}
+	return *body.Timings, true
+}
@@ -0,0 +1,88 @@
+package llamacpp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func gauge(name string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name}, []string{"model"})
+}
+
+func TestScrapePopulatesGauges(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/props":
+			w.Write([]byte(`{"default_generation_settings":{"n_ctx":8192},"n_threads":6,"total_slots":1}`))
+		case "/metrics":
+			w.Write([]byte("# HELP llamacpp:kv_cache_usage_ratio KV-cache usage. 1 means 100 percent usage.\n" +
+				"# TYPE llamacpp:kv_cache_usage_ratio gauge\n" +
+				"llamacpp:kv_cache_usage_ratio 0.25\n" +
+				"llamacpp:kv_cache_tokens 2048\n" +
+				"llamacpp:predicted_tokens_seconds 42.5\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	metrics := Metrics{
+		ContextSize:     gauge("context"),
+		Threads:         gauge("threads"),
+		BatchSize:       gauge("batch"),
+		TokensPerSecond: gauge("tps"),
+		KVCacheUsage:    gauge("kv"),
+		KVCacheTokens:   gauge("kv_tokens"),
+	}
+	stats, err := New(ServerURL(server.URL+"/v1"), "ai/llama3.2", metrics).Scrape(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ContextSize != 8192 || stats.Threads != 6 || stats.KVCacheUsage != 0.25 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	for name, want := range map[*prometheus.GaugeVec]float64{
+		metrics.ContextSize:     8192,
+		metrics.Threads:         6,
+		metrics.TokensPerSecond: 42.5,
+		metrics.KVCacheUsage:    0.25,
+	} {
+		if got := testutil.ToFloat64(name.WithLabelValues("ai/llama3.2")); got != want {
+			t.Errorf("gauge = %v, want %v", got, want)
+		}
+	}
+	if testutil.CollectAndCount(metrics.BatchSize) != 0 {
+		t.Error("unreported batch size should leave the gauge unset")
+	}
+}
+
+func TestScrapeFailsWhenServerIsDown(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	if _, err := New(server.URL, "m", Metrics{}).Scrape(context.Background()); err == nil {
+		t.Error("expected an error when neither endpoint answers")
+	}
+}
+
+func TestParseTimingsReadsDraftStats(t *testing.T) {
+	timings, ok := ParseTimings(`{"choices":[{"finish_reason":"stop","index":0,"delta":{}}],"object":"chat.completion.chunk",` +
+		`"timings":{"prompt_n":12,"prompt_ms":40.5,"predicted_n":64,"predicted_ms":800,"draft_n":40,"draft_n_accepted":30}}`)
+	if !ok {
+		t.Fatal("expected timings")
+	}
+	if timings.PredictedTokens != 64 || timings.DraftTokens != 40 || timings.AcceptanceRate() != 0.75 {
+		t.Errorf("unexpected timings %+v", timings)
+	}
+
+	if _, ok := ParseTimings(`{"choices":[{"delta":{"content":"hi"}}]}`); ok {
+		t.Error("chunks without timings should not report any")
+	}
+	if timings, _ := ParseTimings(`{"timings":{"predicted_n":5}}`); timings.AcceptanceRate() != 0 {
+		t.Error("acceptance rate without a draft model should be 0")
+	}
+}
@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"io"
 	"os"
 	"time"
 
@@ -10,28 +11,96 @@ import (
 
 var logger zerolog.Logger
 
+// closers are flushed and closed by Close
+var closers []io.Closer
+
+// Config holds the full logger configuration
+type Config struct {
+	Level  string
+	Pretty bool
+	File   FileConfig
+	Loki   LokiConfig
+}
+
 // Initialize sets up the logger with the specified configuration
 func Initialize(logLevel string, prettyPrint bool) {
+	Setup(Config{Level: logLevel, Pretty: prettyPrint})
+}
+
+// Setup configures the logger, optionally writing to a rotated file alongside stdout.
+// If the log file cannot be opened, logging continues on stdout and the error is returned.
+func Setup(cfg Config) error {
 	// Set the global time format
 	zerolog.TimeFieldFormat = time.RFC3339
 
 	// Set the global log level
-	level, err := zerolog.ParseLevel(logLevel)
+	level, err := zerolog.ParseLevel(cfg.Level)
 	if err != nil {
 		level = zerolog.InfoLevel
 	}
 	zerolog.SetGlobalLevel(level)
 
-	// Configure the logger output
-	if prettyPrint {
-		output := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
+	// Configure the stdout output
+	var stdout io.Writer = os.Stdout
+	if cfg.Pretty {
+		stdout = zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
+	}
+
+	// Files and Loki always receive structured JSON so they can be parsed later
+	var fileErr error
+	writers := []io.Writer{stdout}
+	if cfg.File.Path != "" {
+		file, err := NewRotatingFile(cfg.File)
+		if err != nil {
+			fileErr = err
+		} else {
+			writers = append(writers, file)
+			closers = append(closers, file)
+		}
+	}
+	if cfg.Loki.URL != "" {
+		loki := NewLokiWriter(cfg.Loki)
+		writers = append(writers, loki)
+		closers = append(closers, loki)
+	}
+
+	output := stdout
+	if len(writers) > 1 {
+		output = zerolog.MultiLevelWriter(writers...)
+	}
+
+	if cfg.Pretty {
 		logger = zerolog.New(output).With().Timestamp().Caller().Logger()
 	} else {
-		logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
+		logger = zerolog.New(output).With().Timestamp().Logger()
 	}
 
 	// Replace the global logger
 	log.Logger = logger
+	return fileErr
+}
+
+// Close flushes and closes any file or Loki outputs
+func Close() {
+	for _, c := range closers {
+		c.Close()
+	}
+	closers = nil
+}
+
+// SetLevel changes the global log level at runtime
+func SetLevel(logLevel string) error {
+	level, err := zerolog.ParseLevel(logLevel)
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(level)
+	return nil
+}
+
+// Level returns the current global log level
+func Level() string {
+	return zerolog.GlobalLevel().String()
 }
 
 // GetLogger returns the configured logger instance
@@ -0,0 +1,17 @@
+package logger
+
+import "testing"
+
+func TestSetLevel(t *testing.T) {
+	defer SetLevel(Level())
+
+	if err := SetLevel("debug"); err != nil || Level() != "debug" {
+		t.Fatalf("SetLevel(debug) = %v, level %s", err, Level())
+	}
+	if err := SetLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	if Level() != "debug" {
+		t.Errorf("expected an invalid level to leave %s, got %s", "debug", Level())
+	}
+}
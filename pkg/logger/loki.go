@@ -0,0 +1,192 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LokiConfig configures shipping logs to a Grafana Loki push endpoint
+type LokiConfig struct {
+	URL        string        // Base URL of Loki, e.g. http://loki:3100; empty disables shipping
+	Service    string        // Value of the service label
+	BatchSize  int           // Flush once this many lines are buffered
+	BatchWait  time.Duration // Flush at least this often
+	MaxRetries int           // Attempts per batch before it is dropped
+}
+
+// lokiStream is a set of log lines sharing the same labels
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// LokiWriter batches JSON log lines and pushes them to Loki with service,
+// model and level labels
+type LokiWriter struct {
+	cfg     LokiConfig
+	client  *http.Client
+	mu      sync.Mutex
+	streams map[string]*lokiStream
+	pending int
+	flushCh chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewLokiWriter creates a writer and starts its background flusher
+func NewLokiWriter(cfg LokiConfig) *LokiWriter {
+	if cfg.Service == "" {
+		cfg.Service = "aiwatch"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.BatchWait <= 0 {
+		cfg.BatchWait = 2 * time.Second
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 5
+	}
+
+	lw := &LokiWriter{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		streams: make(map[string]*lokiStream),
+		flushCh: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	lw.wg.Add(1)
+	go lw.run()
+	return lw
+}
+
+// Write buffers a single JSON log line
+func (lw *LokiWriter) Write(p []byte) (int, error) {
+	var fields struct {
+		Level string `json:"level"`
+		Model string `json:"model"`
+	}
+	// Lines that aren't JSON are still shipped, just without extracted labels
+	_ = json.Unmarshal(p, &fields)
+
+	labels := map[string]string{"service": lw.cfg.Service, "level": fields.Level}
+	if labels["level"] == "" {
+		labels["level"] = "unknown"
+	}
+	if fields.Model != "" {
+		labels["model"] = fields.Model
+	}
+	key := labels["level"] + "\x00" + labels["model"]
+	line := string(bytes.TrimRight(p, "\n"))
+	ts := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	lw.mu.Lock()
+	stream, ok := lw.streams[key]
+	if !ok {
+		stream = &lokiStream{Stream: labels}
+		lw.streams[key] = stream
+	}
+	stream.Values = append(stream.Values, [2]string{ts, line})
+	lw.pending++
+	full := lw.pending >= lw.cfg.BatchSize
+	lw.mu.Unlock()
+
+	if full {
+		select {
+		case lw.flushCh <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// Close flushes buffered lines and stops the background flusher
+func (lw *LokiWriter) Close() error {
+	close(lw.done)
+	lw.wg.Wait()
+	return nil
+}
+
+// run flushes on the batch interval, when a batch fills up, and on close
+func (lw *LokiWriter) run() {
+	defer lw.wg.Done()
+
+	ticker := time.NewTicker(lw.cfg.BatchWait)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			lw.flush()
+		case <-lw.flushCh:
+			lw.flush()
+		case <-lw.done:
+			lw.flush()
+			return
+		}
+	}
+}
+
+// flush pushes all buffered streams, retrying with exponential backoff
+func (lw *LokiWriter) flush() {
+	lw.mu.Lock()
+	if lw.pending == 0 {
+		lw.mu.Unlock()
+		return
+	}
+	streams := make([]*lokiStream, 0, len(lw.streams))
+	for _, s := range lw.streams {
+		streams = append(streams, s)
+	}
+	lw.streams = make(map[string]*lokiStream)
+	lw.pending = 0
+	lw.mu.Unlock()
+
+	body, err := json.Marshal(map[string]interface{}{"streams": streams})
+	if err != nil {
+		// Logging about the log shipper through itself would loop, so report on stderr
+		fmt.Fprintf(os.Stderr, "loki: failed to encode batch: %v\n", err)
+		return
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err = lw.push(body)
+		if err == nil {
+			return
+		}
+		if attempt >= lw.cfg.MaxRetries {
+			fmt.Fprintf(os.Stderr, "loki: dropping batch after %d attempts: %v\n", attempt, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// push sends a single encoded batch to Loki
+func (lw *LokiWriter) push(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, lw.cfg.URL+"/loki/api/v1/push", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := lw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("loki returned status %d", resp.StatusCode)
+	}
+	return nil
+}
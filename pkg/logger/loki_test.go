@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLokiWriterBatchesByLabels(t *testing.T) {
+	var mu sync.Mutex
+	var pushes []map[string][]lokiStream
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/loki/api/v1/push" {
+			t.Errorf("pushed to %s", r.URL.Path)
+		}
+		if failures > 0 {
+			// The first attempt fails and is retried
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body map[string][]lokiStream
+		json.NewDecoder(r.Body).Decode(&body)
+		pushes = append(pushes, body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	lw := NewLokiWriter(LokiConfig{URL: server.URL, Service: "test", BatchWait: time.Hour})
+	lw.Write([]byte(`{"level":"info","model":"ai/llama3.2","message":"one"}` + "\n"))
+	lw.Write([]byte(`{"level":"info","model":"ai/llama3.2","message":"two"}` + "\n"))
+	lw.Write([]byte(`{"level":"error","message":"three"}` + "\n"))
+	lw.Write([]byte("not json\n"))
+	lw.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pushes) != 1 {
+		t.Fatalf("expected one batch pushed on close, got %d", len(pushes))
+	}
+	lines := make(map[string]int)
+	for _, stream := range pushes[0]["streams"] {
+		if stream.Stream["service"] != "test" {
+			t.Errorf("stream without the service label: %v", stream.Stream)
+		}
+		lines[stream.Stream["level"]+"/"+stream.Stream["model"]] += len(stream.Values)
+	}
+	want := map[string]int{"info/ai/llama3.2": 2, "error/": 1, "unknown/": 1}
+	if len(lines) != len(want) {
+		t.Fatalf("streams %v, want %v", lines, want)
+	}
+	for key, n := range want {
+		if lines[key] != n {
+			t.Errorf("stream %s has %d lines, want %d", key, lines[key], n)
+		}
+	}
+}
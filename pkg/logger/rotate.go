@@ -0,0 +1,129 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileConfig configures log output to a rotated file
+type FileConfig struct {
+	Path           string        // File to write logs to; empty disables file output
+	MaxSizeMB      int           // Rotate once the file exceeds this size; 0 disables size rotation
+	RotateInterval time.Duration // Rotate once the file is older than this; 0 disables time rotation
+	MaxBackups     int           // Number of rotated files to keep; 0 keeps all
+}
+
+// RotatingFile is an io.Writer that rotates the underlying file by size and age
+type RotatingFile struct {
+	cfg      FileConfig
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// NewRotatingFile opens (or creates) the log file described by cfg
+func NewRotatingFile(cfg FileConfig) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o755); err != nil {
+		return nil, err
+	}
+
+	rf := &RotatingFile{cfg: cfg}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Write writes p to the current file, rotating first if needed
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.shouldRotate(len(p)) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Close()
+}
+
+// shouldRotate reports whether writing n more bytes requires a rotation
+func (rf *RotatingFile) shouldRotate(n int) bool {
+	if rf.cfg.MaxSizeMB > 0 && rf.size+int64(n) > int64(rf.cfg.MaxSizeMB)*1024*1024 {
+		return rf.size > 0
+	}
+	if rf.cfg.RotateInterval > 0 && time.Since(rf.openedAt) >= rf.cfg.RotateInterval {
+		return true
+	}
+	return false
+}
+
+// open opens the log file for appending and records its current size
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.cfg.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.file = f
+	rf.size = info.Size()
+	rf.openedAt = time.Now()
+	return nil
+}
+
+// rotate renames the current file with a timestamp suffix and opens a new one
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+
+	backup := fmt.Sprintf("%s.%s", rf.cfg.Path, time.Now().UTC().Format("20060102T150405.000"))
+	if err := os.Rename(rf.cfg.Path, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	rf.prune()
+	return rf.open()
+}
+
+// prune removes the oldest backups beyond MaxBackups
+func (rf *RotatingFile) prune() {
+	if rf.cfg.MaxBackups <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(rf.cfg.Path + ".*")
+	if err != nil {
+		return
+	}
+
+	backups := matches
+
+	// Timestamp suffixes sort lexically in chronological order
+	sort.Strings(backups)
+	for len(backups) > rf.cfg.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
@@ -0,0 +1,65 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileRotatesBySizeAndPrunes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "aiwatch.log")
+	rf, err := NewRotatingFile(FileConfig{Path: path, MaxSizeMB: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	line := []byte(strings.Repeat("x", 700*1024) + "\n")
+	for i := 0; i < 4; i++ {
+		if _, err := rf.Write(line); err != nil {
+			t.Fatal(err)
+		}
+		// Backups are named by the millisecond they were rotated at
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatalf("expected the 2 newest backups kept, got %v", backups)
+	}
+	for _, name := range append(backups, path) {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(line)) {
+			t.Errorf("%s holds %d bytes, want one line of %d", name, info.Size(), len(line))
+		}
+	}
+}
+
+func TestRotatingFileRotatesByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aiwatch.log")
+	os.WriteFile(path, []byte("from a previous run\n"), 0o644)
+	rf, err := NewRotatingFile(FileConfig{Path: path, RotateInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	rf.Write([]byte("appended\n"))
+	if data, _ := os.ReadFile(path); string(data) != "from a previous run\nappended\n" {
+		t.Fatalf("expected the existing file appended to, got %q", data)
+	}
+
+	rf.openedAt = time.Now().Add(-2 * time.Hour)
+	rf.Write([]byte("after rotation\n"))
+	if data, _ := os.ReadFile(path); string(data) != "after rotation\n" {
+		t.Errorf("expected a fresh file after the interval, got %q", data)
+	}
+	if backups, _ := filepath.Glob(path + ".*"); len(backups) != 1 {
+		t.Errorf("expected one backup, got %v", backups)
+	}
+}
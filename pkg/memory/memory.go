@@ -0,0 +1,245 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Sample is one backend reading for a model. Negative values were not
+// reported by the backend.
+type Sample struct {
+	KVCacheUsage  float64 // 0 to 1
+	KVCacheTokens float64
+	ModelBytes    float64 // memory held by the loaded model, KV cache included
+	VRAMBytes     float64 // the part of ModelBytes in GPU memory
+}
+
+// Unknown returns a sample with nothing reported
+func Unknown() Sample {
+	return Sample{KVCacheUsage: -1, KVCacheTokens: -1, ModelBytes: -1, VRAMBytes: -1}
+}
+
+// Source reads the current memory state of the models a backend serves
+type Source func(ctx context.Context) (map[string]Sample, error)
+
+// Stats is the latest memory state of a model
+type Stats struct {
+	KVCacheUsage  *float64  `json:"kvCacheUsage,omitempty"`
+	KVCacheTokens *float64  `json:"kvCacheTokens,omitempty"`
+	ModelBytes    *float64  `json:"modelBytes,omitempty"`
+	VRAMBytes     *float64  `json:"vramBytes,omitempty"`
+	High          bool      `json:"high"` // KV cache usage is above the alert threshold
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// Metrics holds the collectors the monitor reports to, all labelled by model
+type Metrics struct {
+	KVCacheUsage  *prometheus.GaugeVec
+	ModelBytes    *prometheus.GaugeVec
+	High          *prometheus.GaugeVec     // 1 while KV cache usage is above the threshold
+	Alerts        *prometheus.CounterVec   // times usage crossed the threshold
+	RequestGrowth *prometheus.HistogramVec // KV cache usage added by one request
+	Lost          *prometheus.CounterVec   // labels: model, reason (unreachable or unloaded)
+}
+
+// minPollInterval limits how often requests finishing trigger a fresh reading
+const minPollInterval = time.Second
+
+// Monitor polls the backend sources for KV cache and model memory, and
+// alerts when KV cache usage crosses the threshold
+type Monitor struct {
+	sources   []Source
+	threshold float64
+	metrics   Metrics
+
+	pollMu   sync.Mutex        // serialises polls
+	reported []map[string]bool // per source, the models of its last successful poll
+
+	mu       sync.Mutex
+	latest   map[string]Stats
+	polledAt time.Time
+}
+
+// NewMonitor creates a monitor alerting at threshold KV cache usage (0 to 1)
+func NewMonitor(threshold float64, metrics Metrics, sources ...Source) *Monitor {
+	return &Monitor{
+		sources:   sources,
+		threshold: threshold,
+		metrics:   metrics,
+		latest:    make(map[string]Stats),
+		reported:  make([]map[string]bool, len(sources)),
+	}
+}
+
+// Poll reads every source and updates the gauges. A model its backend
+// stops reporting, or whose backend stops answering, is counted as lost:
+// that is how an out-of-memory kill of the model server shows up.
+func (m *Monitor) Poll(ctx context.Context) {
+	m.pollMu.Lock()
+	defer m.pollMu.Unlock()
+
+	log := logger.GetLogger()
+	readings := make(map[string]Sample)
+	lost := make(map[string]string)
+	for i, source := range m.sources {
+		samples, err := source(ctx)
+		if err != nil {
+			log.Debug().Err(err).Msg("Memory source poll failed")
+			for model := range m.reported[i] {
+				lost[model] = "unreachable"
+			}
+			m.reported[i] = nil
+			continue
+		}
+		for model := range m.reported[i] {
+			if _, ok := samples[model]; !ok {
+				lost[model] = "unloaded"
+			}
+		}
+		m.reported[i] = make(map[string]bool, len(samples))
+		for model, sample := range samples {
+			m.reported[i][model] = true
+			if existing, ok := readings[model]; ok {
+				sample = merge(existing, sample)
+			}
+			readings[model] = sample
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.polledAt = time.Now()
+	for model, sample := range readings {
+		m.record(model, sample)
+	}
+	for model, reason := range lost {
+		if _, ok := readings[model]; ok {
+			continue
+		}
+		if m.metrics.Lost != nil {
+			m.metrics.Lost.WithLabelValues(model, reason).Inc()
+		}
+		log.Warn().Str("model", model).Str("reason", reason).Msg("Backend stopped reporting the model's memory; it may have been killed for running out of memory")
+		delete(m.latest, model)
+		setGauge(m.metrics.High, model, 0)
+	}
+}
+
+// merge combines readings of one model from several sources, preferring
+// whatever was reported
+func merge(into, sample Sample) Sample {
+	if sample.KVCacheUsage >= 0 {
+		into.KVCacheUsage = sample.KVCacheUsage
+	}
+	if sample.KVCacheTokens >= 0 {
+		into.KVCacheTokens = sample.KVCacheTokens
+	}
+	if sample.ModelBytes >= 0 {
+		into.ModelBytes = sample.ModelBytes
+	}
+	if sample.VRAMBytes >= 0 {
+		into.VRAMBytes = sample.VRAMBytes
+	}
+	return into
+}
+
+// record stores a reading and raises an alert when the model's KV cache
+// crosses the threshold. Callers must hold m.mu.
+func (m *Monitor) record(model string, sample Sample) {
+	previous := m.latest[model]
+	stats := Stats{UpdatedAt: m.polledAt}
+	if sample.KVCacheUsage >= 0 {
+		stats.KVCacheUsage = value(sample.KVCacheUsage)
+		stats.High = m.threshold > 0 && sample.KVCacheUsage >= m.threshold
+		setGauge(m.metrics.KVCacheUsage, model, sample.KVCacheUsage)
+	}
+	if sample.KVCacheTokens >= 0 {
+		stats.KVCacheTokens = value(sample.KVCacheTokens)
+	}
+	if sample.ModelBytes >= 0 {
+		stats.ModelBytes = value(sample.ModelBytes)
+		setGauge(m.metrics.ModelBytes, model, sample.ModelBytes)
+	}
+	if sample.VRAMBytes >= 0 {
+		stats.VRAMBytes = value(sample.VRAMBytes)
+	}
+
+	if stats.High && !previous.High {
+		if m.metrics.Alerts != nil {
+			m.metrics.Alerts.WithLabelValues(model).Inc()
+		}
+		log := logger.GetLogger()
+		log.Warn().Str("model", model).Float64("kv_cache_usage", sample.KVCacheUsage).
+			Float64("threshold", m.threshold).Msg("KV cache usage above threshold, the model may run out of memory")
+	}
+	high := 0.0
+	if stats.High {
+		high = 1
+	}
+	setGauge(m.metrics.High, model, high)
+	m.latest[model] = stats
+}
+
+func value(v float64) *float64 { return &v }
+
+func setGauge(gauge *prometheus.GaugeVec, model string, v float64) {
+	if gauge != nil {
+		gauge.WithLabelValues(model).Set(v)
+	}
+}
+
+// Latest returns the most recent stats for model
+func (m *Monitor) Latest(model string) (Stats, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.latest[model]
+	return stats, ok
+}
+
+// Track notes the model's KV cache usage as a request starts. The returned
+// function, called when the request ends, reads the backend again and
+// records how much usage grew. Concurrent requests share the cache, so the
+// growth is approximate when they overlap.
+func (m *Monitor) Track(model string) func(ctx context.Context) {
+	before, _ := m.Latest(model)
+	return func(ctx context.Context) {
+		m.mu.Lock()
+		stale := time.Since(m.polledAt) >= minPollInterval
+		m.mu.Unlock()
+		if stale {
+			m.Poll(ctx)
+		}
+
+		after, _ := m.Latest(model)
+		if before.KVCacheUsage == nil || after.KVCacheUsage == nil || m.metrics.RequestGrowth == nil {
+			return
+		}
+		growth := *after.KVCacheUsage - *before.KVCacheUsage
+		if growth < 0 {
+			// The cache was freed in the meantime
+			growth = 0
+		}
+		m.metrics.RequestGrowth.WithLabelValues(model).Observe(growth)
+	}
+}
+
+// Start polls on an interval until ctx is cancelled
+func (m *Monitor) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			m.Poll(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testMetrics() Metrics {
+	return Metrics{
+		KVCacheUsage:  prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "kv"}, []string{"model"}),
+		ModelBytes:    prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "bytes"}, []string{"model"}),
+		High:          prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "high"}, []string{"model"}),
+		Alerts:        prometheus.NewCounterVec(prometheus.CounterOpts{Name: "alerts"}, []string{"model"}),
+		RequestGrowth: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "growth"}, []string{"model"}),
+		Lost:          prometheus.NewCounterVec(prometheus.CounterOpts{Name: "lost"}, []string{"model", "reason"}),
+	}
+}
+
+// fakeSource reports usage for one model, or fails once err is set
+type fakeSource struct {
+	usage float64
+	err   error
+}
+
+func (f *fakeSource) read(ctx context.Context) (map[string]Sample, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	sample := Unknown()
+	sample.KVCacheUsage = f.usage
+	return map[string]Sample{"ai/qwen3": sample}, nil
+}
+
+func TestPollAlertsOnceAboveThreshold(t *testing.T) {
+	source := &fakeSource{usage: 0.5}
+	metrics := testMetrics()
+	monitor := NewMonitor(0.9, metrics, source.read)
+
+	monitor.Poll(context.Background())
+	stats, ok := monitor.Latest("ai/qwen3")
+	if !ok || stats.KVCacheUsage == nil || *stats.KVCacheUsage != 0.5 || stats.High {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.ModelBytes != nil {
+		t.Error("unreported model memory should stay unset")
+	}
+
+	source.usage = 0.95
+	monitor.Poll(context.Background())
+	monitor.Poll(context.Background())
+	if got := testutil.ToFloat64(metrics.Alerts.WithLabelValues("ai/qwen3")); got != 1 {
+		t.Errorf("alerts = %v, want 1 while usage stays high", got)
+	}
+	if got := testutil.ToFloat64(metrics.High.WithLabelValues("ai/qwen3")); got != 1 {
+		t.Errorf("high gauge = %v, want 1", got)
+	}
+	if stats, _ := monitor.Latest("ai/qwen3"); !stats.High {
+		t.Error("stats should report high usage")
+	}
+}
+
+func TestPollCountsModelsThatDisappear(t *testing.T) {
+	source := &fakeSource{usage: 0.2}
+	metrics := testMetrics()
+	monitor := NewMonitor(0.9, metrics, source.read)
+
+	monitor.Poll(context.Background())
+	source.err = errors.New("connection refused")
+	monitor.Poll(context.Background())
+	monitor.Poll(context.Background())
+
+	if got := testutil.ToFloat64(metrics.Lost.WithLabelValues("ai/qwen3", "unreachable")); got != 1 {
+		t.Errorf("lost = %v, want 1", got)
+	}
+	if _, ok := monitor.Latest("ai/qwen3"); ok {
+		t.Error("a lost model should have no stats")
+	}
+}
+
+func TestTrackRecordsGrowth(t *testing.T) {
+	source := &fakeSource{usage: 0.1}
+	metrics := testMetrics()
+	monitor := NewMonitor(0.9, metrics, source.read)
+	monitor.Poll(context.Background())
+
+	finish := monitor.Track("ai/qwen3")
+	source.usage = 0.25
+	monitor.mu.Lock()
+	monitor.polledAt = monitor.polledAt.Add(-minPollInterval)
+	monitor.mu.Unlock()
+	finish(context.Background())
+
+	if n := testutil.CollectAndCount(metrics.RequestGrowth); n != 1 {
+		t.Fatalf("growth series = %d, want 1", n)
+	}
+	stats, _ := monitor.Latest("ai/qwen3")
+	if *stats.KVCacheUsage != 0.25 {
+		t.Errorf("finishing a request should read the backend again, got %v", *stats.KVCacheUsage)
+	}
+}
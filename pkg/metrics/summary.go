@@ -7,6 +7,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+
 	// Import only what's needed for this file
 	_ "github.com/prometheus/client_golang/prometheus" // blank import for side effects
 	"github.com/rs/zerolog/log"
@@ -93,7 +95,6 @@ func CleanupOldMetrics() {
 func HandleMetricsSummary() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
 
 		CleanupOldMetrics()
 
@@ -136,7 +137,7 @@ func HandleMetricsSummary() http.HandlerFunc {
 
 		if err := json.NewEncoder(w).Encode(summary); err != nil {
 			log.Error().Err(err).Msg("Failed to encode metrics summary")
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			apierror.Write(w, r, apierror.Internal, "Internal server error")
 		}
 	}
 }
@@ -145,24 +146,19 @@ func HandleMetricsSummary() http.HandlerFunc {
 func HandleLogMetrics() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			apierror.Write(w, r, apierror.MethodNotAllowed, "Method not allowed")
 			return
 		}
 
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-
 		var metric MessageMetrics
 		if err := json.NewDecoder(r.Body).Decode(&metric); err != nil {
 			log.Error().Err(err).Msg("Failed to decode metrics payload")
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			apierror.Write(w, r, apierror.InvalidRequest, "Invalid request body")
 			return
 		}
 
@@ -190,24 +186,19 @@ func HandleLogMetrics() http.HandlerFunc {
 func HandleLogError() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			apierror.Write(w, r, apierror.MethodNotAllowed, "Method not allowed")
 			return
 		}
 
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-
 		var errorEntry ErrorLogEntry
 		if err := json.NewDecoder(r.Body).Decode(&errorEntry); err != nil {
 			log.Error().Err(err).Msg("Failed to decode error payload")
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			apierror.Write(w, r, apierror.InvalidRequest, "Invalid request body")
 			return
 		}
 
@@ -0,0 +1,327 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Content codings, the values of the encoding label
+const (
+	EncodingBrotli   = "br"
+	EncodingGzip     = "gzip"
+	EncodingIdentity = "identity"
+)
+
+// CompressConfig controls which responses are compressed and how hard
+type CompressConfig struct {
+	GzipLevel   int // gzip.BestSpeed to gzip.BestCompression; 0 uses gzip.DefaultCompression
+	BrotliLevel int // 1 to 11; 0 uses 4, quick enough for responses built per request
+	MinBytes    int // smaller responses are sent as they are
+	// ContentTypes are the media types worth compressing. Types ending in
+	// +json are always included and text/event-stream never is.
+	ContentTypes []string
+}
+
+// DefaultCompressConfig compresses the JSON, CSV and document responses the
+// API serves once they pass 1 KiB
+func DefaultCompressConfig() CompressConfig {
+	return CompressConfig{
+		MinBytes: 1 << 10,
+		ContentTypes: []string{
+			"application/json", "application/x-ndjson", "application/xml",
+			"text/csv", "text/html", "text/markdown", "text/css",
+			"application/javascript", "image/svg+xml",
+		},
+	}
+}
+
+// CompressMetrics holds the collectors the middleware reports to
+type CompressMetrics struct {
+	// Responses counts compressible responses by the coding they were sent
+	// with; identity ones were too small to be worth it
+	Responses *prometheus.CounterVec // labels: encoding (br|gzip|identity)
+	// BytesSaved is the uncompressed size less the size sent
+	BytesSaved *prometheus.CounterVec // labels: encoding (br|gzip)
+}
+
+// encoder is the part of gzip.Writer and brotli.Writer a response uses
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+type compressor struct {
+	config     CompressConfig
+	metrics    CompressMetrics
+	types      map[string]bool
+	gzipPool   sync.Pool
+	brotliPool sync.Pool
+}
+
+// Compress encodes responses with Brotli or gzip, whichever the client
+// prefers, Brotli on a tie. Only responses of cfg's content types are
+// compressed: server-sent event streams, responses without a Content-Type
+// such as the plain-text chat stream, and responses already encoded are
+// sent as they are. Flushes pass through the encoder, so a long export
+// still reaches the client as it is written.
+func Compress(cfg CompressConfig, metrics CompressMetrics) func(http.Handler) http.Handler {
+	if cfg.GzipLevel < gzip.BestSpeed || cfg.GzipLevel > gzip.BestCompression {
+		cfg.GzipLevel = gzip.DefaultCompression
+	}
+	if cfg.BrotliLevel < 1 || cfg.BrotliLevel > brotli.BestCompression {
+		cfg.BrotliLevel = 4
+	}
+	c := &compressor{config: cfg, metrics: metrics, types: make(map[string]bool, len(cfg.ContentTypes))}
+	for _, t := range cfg.ContentTypes {
+		c.types[strings.ToLower(strings.TrimSpace(t))] = true
+	}
+	c.gzipPool.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, cfg.GzipLevel)
+		return w
+	}
+	c.brotliPool.New = func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, cfg.BrotliLevel)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiate(r.Header.Get("Accept-Encoding"))
+			if encoding == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, c: c, encoding: encoding}
+			next.ServeHTTP(cw, r)
+			cw.close()
+		})
+	}
+}
+
+// compressible reports whether a response with header h and status is
+// worth compressing
+func (c *compressor) compressible(h http.Header, status int) bool {
+	switch {
+	case status < http.StatusOK, status == http.StatusNoContent, status == http.StatusPartialContent, status == http.StatusNotModified:
+		return false
+	case h.Get("Content-Encoding") != "":
+		return false
+	}
+	mediaType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" || mediaType == "text/event-stream" {
+		return false
+	}
+	return c.types[mediaType] || strings.HasSuffix(mediaType, "+json")
+}
+
+func (c *compressor) encoder(encoding string, w io.Writer) encoder {
+	if encoding == EncodingBrotli {
+		bw := c.brotliPool.Get().(*brotli.Writer)
+		bw.Reset(w)
+		return bw
+	}
+	gw := c.gzipPool.Get().(*gzip.Writer)
+	gw.Reset(w)
+	return gw
+}
+
+func (c *compressor) release(enc encoder) {
+	switch e := enc.(type) {
+	case *brotli.Writer:
+		c.brotliPool.Put(e)
+	case *gzip.Writer:
+		c.gzipPool.Put(e)
+	}
+}
+
+func (c *compressor) count(encoding string, saved int64) {
+	if c.metrics.Responses != nil {
+		c.metrics.Responses.WithLabelValues(encoding).Inc()
+	}
+	if c.metrics.BytesSaved != nil && saved > 0 {
+		c.metrics.BytesSaved.WithLabelValues(encoding).Add(float64(saved))
+	}
+}
+
+// negotiate picks the coding for an Accept-Encoding header, or "" to send
+// the response as it is
+func negotiate(accept string) string {
+	br, gz, star := -1.0, -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				q = 0
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case EncodingBrotli:
+			br = q
+		case EncodingGzip, "x-gzip":
+			gz = q
+		case "*":
+			star = q
+		}
+	}
+	if br < 0 {
+		br = star
+	}
+	if gz < 0 {
+		gz = star
+	}
+	switch {
+	case br > 0 && br >= gz:
+		return EncodingBrotli
+	case gz > 0:
+		return EncodingGzip
+	}
+	return ""
+}
+
+// compressWriter holds back the start of a compressible response until it
+// passes MinBytes, then sends it and the rest through an encoder
+type compressWriter struct {
+	http.ResponseWriter
+	c         *compressor
+	encoding  string
+	status    int
+	buf       []byte
+	buffering bool // compressible, size not yet known
+	enc       encoder
+	in        int64 // bytes written by the handler
+	out       countingWriter
+}
+
+// countingWriter counts the bytes an encoder sends
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// WriteHeader decides whether the response may be compressed; the header
+// is sent once its size is known
+func (cw *compressWriter) WriteHeader(status int) {
+	if status >= 100 && status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	if !cw.c.compressible(cw.Header(), status) {
+		cw.start(false)
+		return
+	}
+	cw.buffering = true
+	if n, err := strconv.Atoi(cw.Header().Get("Content-Length")); err == nil && n < cw.c.config.MinBytes {
+		cw.start(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.buffering {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) >= cw.c.config.MinBytes {
+			if err := cw.start(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	return cw.write(p)
+}
+
+func (cw *compressWriter) write(p []byte) (int, error) {
+	if cw.enc == nil {
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.in += int64(len(p))
+	return cw.enc.Write(p)
+}
+
+// start sends the header, compressed or not, and anything held back
+func (cw *compressWriter) start(compress bool) error {
+	buffered := cw.buffering
+	cw.buffering = false
+	if compress {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		// The encoded bytes differ, so a strong validator no longer holds
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.out.w = cw.ResponseWriter
+		cw.enc = cw.c.encoder(cw.encoding, &cw.out)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if buffered && !compress {
+		cw.c.count(EncodingIdentity, 0)
+	}
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// Flush sends what the handler has written so far, compressing it only if
+// it already passed MinBytes
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.buffering {
+		cw.start(false)
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the response once the handler returns
+func (cw *compressWriter) close() {
+	if cw.buffering {
+		cw.start(false)
+	}
+	if cw.enc == nil {
+		return
+	}
+	cw.enc.Close()
+	cw.c.release(cw.enc)
+	cw.c.count(cw.encoding, cw.in-cw.out.n)
+	cw.enc = nil
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := map[string]string{
+		"":                        "",
+		"identity":                "",
+		"gzip":                    EncodingGzip,
+		"gzip, deflate, br, zstd": EncodingBrotli,
+		"br;q=0.5, gzip":          EncodingGzip,
+		"gzip;q=0, br;q=0":        "",
+		"*":                       EncodingBrotli,
+		"br;q=0, *;q=0.1":         EncodingGzip,
+		"X-GZIP":                  EncodingGzip,
+	}
+	for accept, want := range tests {
+		if got := negotiate(accept); got != want {
+			t.Errorf("negotiate(%q) = %q, want %q", accept, got, want)
+		}
+	}
+}
+
+func compressRequest(h http.Handler, method, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/conversations", nil)
+	if accept != "" {
+		r.Header.Set("Accept-Encoding", accept)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var r io.Reader = rec.Body
+	switch rec.Header().Get("Content-Encoding") {
+	case EncodingGzip:
+		gr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	case EncodingBrotli:
+		r = brotli.NewReader(rec.Body)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestCompress(t *testing.T) {
+	large := `{"conversations": [` + strings.Repeat(`{"id": "abc", "title": "Hello"},`, 100) + `{}]}`
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		accept      string
+		encoding    string
+	}{
+		{name: "gzip", contentType: "application/json", body: large, accept: "gzip", encoding: EncodingGzip},
+		{name: "brotli", contentType: "application/json; charset=utf-8", body: large, accept: "gzip, br", encoding: EncodingBrotli},
+		{name: "problem json", contentType: "application/problem+json", body: large, accept: "gzip", encoding: EncodingGzip},
+		{name: "not accepted", contentType: "application/json", body: large},
+		{name: "small", contentType: "application/json", body: `{"ok": true}`, accept: "gzip"},
+		{name: "event stream", contentType: "text/event-stream", body: "data: " + large + "\n\n", accept: "gzip"},
+		{name: "no content type", body: large, accept: "gzip"},
+		{name: "binary", contentType: "application/vnd.apache.parquet", body: large, accept: "gzip"},
+	}
+	for _, tt := range tests {
+		h := Compress(DefaultCompressConfig(), CompressMetrics{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.contentType != "" {
+				w.Header().Set("Content-Type", tt.contentType)
+			}
+			// Write in pieces, as streaming handlers do
+			for i := 0; i < len(tt.body); i += 100 {
+				w.Write([]byte(tt.body[i:min(i+100, len(tt.body))]))
+			}
+		}))
+		rec := compressRequest(h, http.MethodGet, tt.accept)
+
+		if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: Content-Encoding %q, want %q", tt.name, got, tt.encoding)
+		}
+		if got := decode(t, rec); got != tt.body {
+			t.Errorf("%s: body %.40q..., want %.40q...", tt.name, got, tt.body)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%s: Vary %q", tt.name, got)
+		}
+	}
+}
+
+func TestCompressHeaders(t *testing.T) {
+	large := strings.Repeat("a,b,c\n", 500)
+	h := Compress(DefaultCompressConfig(), CompressMetrics{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Length", "3000")
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, large)
+	}))
+	rec := compressRequest(h, http.MethodGet, "gzip")
+	if rec.Code != http.StatusCreated || rec.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("got %d with Content-Encoding %q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	if got := rec.Header().Get("Content-Length"); got != "" {
+		t.Errorf("Content-Length %q left on a compressed response", got)
+	}
+	if got := rec.Header().Get("ETag"); got != `W/"v1"` {
+		t.Errorf("ETag %q, want it weakened", got)
+	}
+	if decode(t, rec) != large {
+		t.Error("body does not round-trip")
+	}
+
+	rec = compressRequest(h, http.MethodHead, "gzip")
+	if rec.Header().Get("Content-Encoding") != "" || rec.Header().Get("Content-Length") != "3000" {
+		t.Errorf("HEAD: got Content-Encoding %q, Content-Length %q", rec.Header().Get("Content-Encoding"), rec.Header().Get("Content-Length"))
+	}
+}
+
+func TestCompressSkipsEncodedResponses(t *testing.T) {
+	h := Compress(DefaultCompressConfig(), CompressMetrics{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", EncodingGzip)
+		w.Write([]byte(strings.Repeat("x", 2000)))
+	}))
+	rec := compressRequest(h, http.MethodGet, "br")
+	if rec.Header().Get("Content-Encoding") != EncodingGzip || rec.Body.Len() != 2000 {
+		t.Errorf("got Content-Encoding %q with %d bytes, want the handler's encoding untouched", rec.Header().Get("Content-Encoding"), rec.Body.Len())
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	line := `{"messages": [{"role": "user", "content": "hello"}]}` + "\n"
+	var flushed []int
+	var rec *httptest.ResponseRecorder
+	h := Compress(CompressConfig{MinBytes: 512, ContentTypes: []string{"application/x-ndjson"}}, CompressMetrics{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for i := 0; i < 50; i++ {
+			io.WriteString(w, line)
+			if i%10 == 9 {
+				w.(http.Flusher).Flush()
+				flushed = append(flushed, rec.Body.Len())
+			}
+		}
+	}))
+	rec = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/conversations/export", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(rec, r)
+
+	if rec.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("Content-Encoding %q, want gzip", rec.Header().Get("Content-Encoding"))
+	}
+	for i := 1; i < len(flushed); i++ {
+		if flushed[i] <= flushed[i-1] {
+			t.Errorf("flush %d sent nothing: %v", i, flushed)
+		}
+	}
+	if decode(t, rec) != strings.Repeat(line, 50) {
+		t.Error("body does not round-trip")
+	}
+}
+
+func TestCompressMetrics(t *testing.T) {
+	responses := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "responses"}, []string{"encoding"})
+	saved := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "saved"}, []string{"encoding"})
+	body := ""
+	h := Compress(DefaultCompressConfig(), CompressMetrics{Responses: responses, BytesSaved: saved})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+
+	body = `[` + strings.Repeat(`"ai/llama3.2",`, 200) + `""]`
+	rec := compressRequest(h, http.MethodGet, "br")
+	want := float64(len(body) - rec.Body.Len())
+	body = `[]`
+	compressRequest(h, http.MethodGet, "br")
+
+	if got := testutil.ToFloat64(responses.WithLabelValues(EncodingBrotli)); got != 1 {
+		t.Errorf("br responses = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(responses.WithLabelValues(EncodingIdentity)); got != 1 {
+		t.Errorf("identity responses = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(saved.WithLabelValues(EncodingBrotli)); got != want || want <= 0 {
+		t.Errorf("br bytes saved = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxSnapshots bounds the responses a Conditional remembers; query strings
+// come from clients, so the set of keys is otherwise unbounded
+const maxSnapshots = 256
+
+// ConditionalMetrics holds the collectors conditional responses report to
+type ConditionalMetrics struct {
+	NotModified *prometheus.CounterVec // labels: path
+}
+
+// snapshot is the last successful response for one path and query
+type snapshot struct {
+	header   http.Header
+	body     []byte
+	etag     string
+	modified time.Time // when the body last changed
+	built    time.Time
+}
+
+type conditional struct {
+	ttl       time.Duration
+	metrics   ConditionalMetrics
+	mu        sync.Mutex
+	snapshots map[string]*snapshot
+}
+
+// Conditional gives successful GET responses an ETag, a hash of the body,
+// and a Last-Modified, when the body last changed, and answers requests
+// whose If-None-Match or If-Modified-Since match with 304 Not Modified, so
+// polling clients skip the download. With a ttl, a response is reused for
+// that long rather than built again; use it for responses that change on
+// every request, such as live counters, to let polls within the ttl match.
+func Conditional(ttl time.Duration, metrics ConditionalMetrics) func(http.Handler) http.Handler {
+	c := &conditional{ttl: ttl, metrics: metrics, snapshots: make(map[string]*snapshot)}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := r.URL.Path + "?" + r.URL.RawQuery
+			now := time.Now()
+			s := c.lookup(key, now)
+			if s == nil {
+				rec := &bufferedResponse{header: make(http.Header)}
+				next.ServeHTTP(rec, r)
+				if rec.status != http.StatusOK {
+					rec.writeTo(w)
+					return
+				}
+				s = c.store(key, rec, now)
+			}
+
+			h := w.Header()
+			for k, v := range s.header {
+				h[k] = v
+			}
+			h.Set("ETag", s.etag)
+			h.Set("Last-Modified", s.modified.UTC().Format(http.TimeFormat))
+			if h.Get("Cache-Control") == "" {
+				// Caches may keep the response but must check it is current
+				h.Set("Cache-Control", "no-cache")
+			}
+			if notModified(r, s) {
+				h.Del("Content-Type")
+				h.Del("Content-Length")
+				w.WriteHeader(http.StatusNotModified)
+				if c.metrics.NotModified != nil {
+					c.metrics.NotModified.WithLabelValues(r.URL.Path).Inc()
+				}
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write(s.body)
+		})
+	}
+}
+
+// lookup returns the snapshot for key if it may still be reused
+func (c *conditional) lookup(key string, now time.Time) *snapshot {
+	if c.ttl <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s := c.snapshots[key]; s != nil && now.Sub(s.built) < c.ttl {
+		return s
+	}
+	return nil
+}
+
+// store remembers a response, keeping the previous modification time if the
+// body is the same
+func (c *conditional) store(key string, rec *bufferedResponse, now time.Time) *snapshot {
+	sum := sha256.Sum256(rec.body.Bytes())
+	s := &snapshot{
+		header:   rec.header,
+		body:     rec.body.Bytes(),
+		etag:     `"` + hex.EncodeToString(sum[:12]) + `"`,
+		modified: now.Truncate(time.Second),
+		built:    now,
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev := c.snapshots[key]
+	if prev != nil && prev.etag == s.etag {
+		s.modified = prev.modified
+	}
+	if prev == nil && len(c.snapshots) >= maxSnapshots {
+		c.snapshots = make(map[string]*snapshot)
+	}
+	c.snapshots[key] = s
+	return s
+}
+
+// notModified evaluates the request's preconditions against s. As RFC 9110
+// requires, If-Modified-Since only counts without If-None-Match, and entity
+// tags compare weakly, so tags weakened by compression still match.
+func notModified(r *http.Request, s *snapshot) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == s.etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !s.modified.After(since)
+}
+
+// bufferedResponse holds a response until its status is known
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// writeTo sends the response on unchanged
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range b.header {
+		h[k] = v
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
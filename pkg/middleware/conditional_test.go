@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func conditionalRequest(h http.Handler, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/models", nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestConditional(t *testing.T) {
+	notModified := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "not_modified"}, []string{"path"})
+	body := `[{"name": "ai/llama3.2"}]`
+	calls := 0
+	h := Conditional(0, ConditionalMetrics{NotModified: notModified})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	}))
+
+	rec := conditionalRequest(h)
+	etag, modified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if rec.Code != http.StatusOK || rec.Body.String() != body || etag == "" || modified == "" {
+		t.Fatalf("got %d %q with ETag %q, Last-Modified %q", rec.Code, rec.Body, etag, modified)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control %q, want no-cache", got)
+	}
+
+	tests := []struct {
+		name   string
+		header []string
+		status int
+	}{
+		{name: "matching tag", header: []string{"If-None-Match", etag}, status: http.StatusNotModified},
+		{name: "weak tag", header: []string{"If-None-Match", "W/" + etag}, status: http.StatusNotModified},
+		{name: "one of several", header: []string{"If-None-Match", `"other", ` + etag}, status: http.StatusNotModified},
+		{name: "any", header: []string{"If-None-Match", "*"}, status: http.StatusNotModified},
+		{name: "other tag", header: []string{"If-None-Match", `"other"`}, status: http.StatusOK},
+		{name: "since modified", header: []string{"If-Modified-Since", modified}, status: http.StatusNotModified},
+		{name: "before modified", header: []string{"If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT"}, status: http.StatusOK},
+		{name: "tag wins over date", header: []string{"If-None-Match", `"other"`, "If-Modified-Since", modified}, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := conditionalRequest(h, tt.header...)
+		if rec.Code != tt.status {
+			t.Errorf("%s: got %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if tt.status == http.StatusNotModified && (rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != "" || rec.Header().Get("ETag") != etag) {
+			t.Errorf("%s: 304 with body %q, Content-Type %q, ETag %q", tt.name, rec.Body, rec.Header().Get("Content-Type"), rec.Header().Get("ETag"))
+		}
+	}
+	if got := testutil.ToFloat64(notModified.WithLabelValues("/models")); got != 5 {
+		t.Errorf("not modified = %v, want 5", got)
+	}
+	if calls != 1+len(tests) {
+		t.Errorf("handler called %d times, want every request without a ttl", calls)
+	}
+
+	body = `[]`
+	rec = conditionalRequest(h, "If-None-Match", etag)
+	if rec.Code != http.StatusOK || rec.Body.String() != body || rec.Header().Get("ETag") == etag {
+		t.Errorf("changed body: got %d %q with ETag %q", rec.Code, rec.Body, rec.Header().Get("ETag"))
+	}
+}
+
+func TestConditionalTTL(t *testing.T) {
+	calls := 0
+	h := Conditional(time.Hour, ConditionalMetrics{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"totalRequests": %d}`, calls)
+	}))
+
+	first := conditionalRequest(h)
+	second := conditionalRequest(h, "If-None-Match", first.Header().Get("ETag"))
+	if second.Code != http.StatusNotModified || calls != 1 {
+		t.Errorf("within the ttl: got %d after %d calls, want 304 from the snapshot", second.Code, calls)
+	}
+	third := conditionalRequest(h)
+	if third.Body.String() != `{"totalRequests": 1}` || third.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("snapshot replayed as %q with Content-Type %q", third.Body, third.Header().Get("Content-Type"))
+	}
+}
+
+func TestConditionalPassesErrorsThrough(t *testing.T) {
+	fail := true
+	h := Conditional(time.Hour, ConditionalMetrics{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "backend down", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+
+	rec := conditionalRequest(h, "If-None-Match", "*")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("ETag") != "" {
+		t.Errorf("got %d with ETag %q, want the error as is", rec.Code, rec.Header().Get("ETag"))
+	}
+	fail = false
+	if rec := conditionalRequest(h); rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("after recovery: got %d %q, want the error not cached", rec.Code, rec.Body)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/models", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Header().Get("ETag") != "" {
+		t.Error("POST response got an ETag")
+	}
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig is the cross-origin policy for browser clients
+type CORSConfig struct {
+	AllowedOrigins   []string // exact origins, or "*" for any
+	AllowCredentials bool     // never honoured together with "*"
+	AllowedMethods   []string
+	AllowedHeaders   []string // empty allows whatever headers a preflight requests
+	ExposedHeaders   []string
+	MaxAge           time.Duration // how long browsers may cache a preflight
+}
+
+// CORS applies cfg to every response and answers preflight requests itself.
+// Responses to allowed origins echo the origin rather than "*" so caches and
+// credentials behave; requests from other origins get no CORS headers, which
+// makes the browser block them.
+func CORS(cfg CORSConfig) func(http.Handler) http.Handler {
+	allowAny := false
+	allowed := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			allowAny = true
+		}
+		allowed[strings.TrimSuffix(origin, "/")] = true
+	}
+	// A wildcard with credentials would let any site act as the user
+	credentials := cfg.AllowCredentials && !allowAny
+
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			w.Header().Add("Vary", "Origin")
+			if origin != "" && (allowAny || allowed[origin]) {
+				if allowAny && !credentials {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+				if credentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+				if exposed != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposed)
+				}
+
+				if preflight {
+					w.Header().Set("Access-Control-Allow-Methods", methods)
+					if headers != "" {
+						w.Header().Set("Access-Control-Allow-Headers", headers)
+					} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+						w.Header().Set("Access-Control-Allow-Headers", requested)
+					}
+					if cfg.MaxAge > 0 {
+						w.Header().Set("Access-Control-Max-Age", maxAge)
+					}
+				}
+			}
+
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
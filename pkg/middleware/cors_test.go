@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func corsRequest(h http.Handler, method, origin string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/chat", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	if method == http.MethodOptions {
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		r.Header.Set("Access-Control-Request-Headers", "Content-Type, X-API-Key")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestCORSAllowlist(t *testing.T) {
+	called := 0
+	h := CORS(CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowCredentials: true,
+		AllowedMethods:   []string{"GET", "POST"},
+		MaxAge:           10 * time.Minute,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ }))
+
+	rec := corsRequest(h, http.MethodOptions, "https://app.example.com")
+	if rec.Code != http.StatusNoContent || called != 0 {
+		t.Errorf("expected preflight answered by the middleware, got %d (handler called %d times)", rec.Code, called)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("expected allowed origin echoed, got %q", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "true" || rec.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("expected credentials and max-age, got %v", rec.Header())
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type, X-API-Key" {
+		t.Errorf("expected requested headers allowed, got %q", got)
+	}
+
+	rec = corsRequest(h, http.MethodPost, "https://evil.example.com")
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" || called != 1 {
+		t.Errorf("expected no CORS headers for a disallowed origin, got %v", rec.Header())
+	}
+}
+
+func TestCORSWildcardNeverAllowsCredentials(t *testing.T) {
+	h := CORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := corsRequest(h, http.MethodGet, "https://any.example.com")
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("expected wildcard origin, got %q", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("expected credentials to be refused with a wildcard origin")
+	}
+}
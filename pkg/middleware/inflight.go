@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InflightRequest describes a request that is currently being served
+type InflightRequest struct {
+	ID         string    `json:"id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	RemoteAddr string    `json:"remote_addr"`
+	StartedAt  time.Time `json:"started_at"`
+	ElapsedMs  int64     `json:"elapsed_ms"`
+}
+
+// InflightTracker keeps a registry of the requests currently being served
+type InflightTracker struct {
+	mu       sync.Mutex
+	requests map[string]InflightRequest
+}
+
+// NewInflightTracker creates an empty tracker
+func NewInflightTracker() *InflightTracker {
+	return &InflightTracker{requests: make(map[string]InflightRequest)}
+}
+
+// Middleware registers each request for the duration of its handler
+func (t *InflightTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := uuid.New().String()
+
+		t.mu.Lock()
+		t.requests[id] = InflightRequest{
+			ID:         id,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			RemoteAddr: r.RemoteAddr,
+			StartedAt:  time.Now(),
+		}
+		t.mu.Unlock()
+
+		defer func() {
+			t.mu.Lock()
+			delete(t.requests, id)
+			t.mu.Unlock()
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// List returns the in-flight requests, oldest first
+func (t *InflightTracker) List() []InflightRequest {
+	t.mu.Lock()
+	list := make([]InflightRequest, 0, len(t.requests))
+	for _, req := range t.requests {
+		req.ElapsedMs = time.Since(req.StartedAt).Milliseconds()
+		list = append(list, req)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.Before(list[j].StartedAt) })
+	return list
+}
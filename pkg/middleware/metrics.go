@@ -5,11 +5,13 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/ajeetraina/aiwatch/pkg/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// MetricsMiddleware adds metrics collection middleware
-func MetricsMiddleware(requestCounter *prometheus.CounterVec, requestDuration *prometheus.HistogramVec, activeRequests prometheus.Gauge) func(http.Handler) http.Handler {
+// MetricsMiddleware adds metrics collection middleware. Requests are
+// labelled by routes, or by raw path if routes is nil.
+func MetricsMiddleware(requestCounter *prometheus.CounterVec, requestDuration *prometheus.HistogramVec, activeRequests prometheus.Gauge, routes *RouteLabeler) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
@@ -30,8 +32,12 @@ func MetricsMiddleware(requestCounter *prometheus.CounterVec, requestDuration *p
 			duration := time.Since(start)
 			
 			// Record metrics
-			requestCounter.WithLabelValues(r.Method, r.URL.Path, strconv.Itoa(writer.status)).Inc()
-			requestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(duration.Seconds())
+			method, endpoint := r.Method, r.URL.Path
+			if routes != nil {
+				method, endpoint = MethodLabel(method), routes.Label(r)
+			}
+			requestCounter.WithLabelValues(method, endpoint, strconv.Itoa(writer.status)).Inc()
+			tracing.Observe(r.Context(), requestDuration.WithLabelValues(method, endpoint), duration.Seconds())
 		})
 	}
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
 	"github.com/ajeetraina/aiwatch/pkg/metrics"
 )
 
@@ -73,7 +74,7 @@ func RateLimiter(ratePerMinute int) func(http.Handler) http.Handler {
 			if len(requestTimes) >= ratePerMinute {
 				metrics.ErrorCounter.WithLabelValues("rate_limit", "api").Inc()
 				log.Warn().Str("ip", ipAddress).Int("rate_limit", ratePerMinute).Msg("Rate limit exceeded")
-				http.Error(w, "Rate limit exceeded. Please try again later.", http.StatusTooManyRequests)
+				apierror.Write(w, r, apierror.RateLimited, "Rate limit exceeded. Please try again later.")
 				return
 			}
 
@@ -97,3 +98,15 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.status = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Flush passes flushes through so streaming handlers work behind the middleware
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// OtherRoute labels requests no route serves, and new routes once a
+// RouteLabeler is full
+const OtherRoute = "other"
+
+// OtherMethod labels requests with methods outside the standard set
+const OtherMethod = "OTHER"
+
+// RouteLabeler turns request paths into endpoint label values, so a client
+// requesting arbitrary URLs cannot create a metric series for each one.
+// Paths are labelled by the route serving them, with IDs in the path
+// replaced by {id}; paths no route serves are labelled OtherRoute.
+type RouteLabeler struct {
+	route func(*http.Request) string // pattern of the serving route, "" for none
+	max   int
+
+	mu     sync.Mutex
+	labels map[string]bool
+}
+
+// NewRouteLabeler creates a labeler that looks routes up with route, which
+// returns the pattern of the route serving a request as http.ServeMux
+// reports it, or "" if none does. At most max labels are handed out; later
+// ones become OtherRoute. Zero means no limit.
+func NewRouteLabeler(route func(*http.Request) string, max int) *RouteLabeler {
+	return &RouteLabeler{route: route, max: max, labels: make(map[string]bool)}
+}
+
+// Label returns the endpoint label for r
+func (l *RouteLabeler) Label(r *http.Request) string {
+	pattern := l.route(r)
+	if pattern == "" {
+		return OtherRoute
+	}
+	// Drop the method and host a pattern may start with
+	if i := strings.Index(pattern, "/"); i > 0 {
+		pattern = pattern[i:]
+	}
+	label := pattern
+	if strings.HasSuffix(pattern, "/") {
+		// A subtree route: template what follows it in the path, which may
+		// carry a version prefix the pattern lacks
+		if i := strings.Index(r.URL.Path, pattern); i >= 0 {
+			label = pattern + Template(r.URL.Path[i+len(pattern):])
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.labels[label] {
+		return label
+	}
+	if l.max > 0 && len(l.labels) >= l.max {
+		return OtherRoute
+	}
+	l.labels[label] = true
+	return label
+}
+
+// MethodLabel returns method if it is a standard HTTP method, and
+// OtherMethod if not
+func MethodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return OtherMethod
+}
+
+// Template replaces the segments of path that look like IDs, such as
+// numbers, UUIDs and long hashes, with {id}
+func Template(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if isID(s) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func isID(s string) bool {
+	digits, hex := 0, true
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F', c == '-':
+		default:
+			hex = false
+		}
+	}
+	switch {
+	case s == "" || digits == 0:
+		return false
+	case digits == len(s):
+		return true
+	case hex && len(s) >= 8:
+		return true
+	}
+	// Random IDs such as ULIDs and tokens
+	return len(s) >= 16
+}
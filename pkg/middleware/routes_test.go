@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTemplate(t *testing.T) {
+	tests := map[string]string{
+		"":                                     "",
+		"abc":                                  "abc",
+		"42":                                   "{id}",
+		"f47ac10b-58cc-4372-a567-0e02b2c3d479": "{id}",
+		"01HZX3KQ9V7M2J8N4P6R0S5T1W/messages":  "{id}/messages",
+		"daily/send":                           "daily/send",
+		"ai/llama3.2":                          "ai/llama3.2",
+		"deadbeef":                             "deadbeef",
+		"a3f9c2e1":                             "{id}",
+	}
+	for path, want := range tests {
+		if got := Template(path); got != want {
+			t.Errorf("Template(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func newLabeler(max int) *RouteLabeler {
+	mux := http.NewServeMux()
+	for _, pattern := range []string{"/models", "GET /jobs/{id}", "/conversations/", "/v1/chat/completions"} {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	return NewRouteLabeler(func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	}, max)
+}
+
+func TestRouteLabeler(t *testing.T) {
+	l := newLabeler(0)
+	tests := map[string]string{
+		"/models":                          "/models",
+		"/jobs/f47ac10b":                   "/jobs/{id}",
+		"/conversations/":                  "/conversations/",
+		"/conversations/1234/messages":     "/conversations/{id}/messages",
+		"/conversations/search":            "/conversations/search",
+		"/v1/chat/completions":             "/v1/chat/completions",
+		"/wp-admin/install.php":            OtherRoute,
+		"/.env":                            OtherRoute,
+		"/models/../../etc/passwd":         OtherRoute,
+		"/conversations/9f8e7d6c5b4a39281": "/conversations/{id}",
+	}
+	for path, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.Path = path
+		if got := l.Label(r); got != want {
+			t.Errorf("%s: label %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestRouteLabelerLimit(t *testing.T) {
+	l := newLabeler(3)
+	for _, path := range []string{"/models", "/conversations/a", "/conversations/b"} {
+		l.Label(httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if got := l.Label(httptest.NewRequest(http.MethodGet, "/conversations/c", nil)); got != OtherRoute {
+		t.Errorf("label past the limit = %q, want %q", got, OtherRoute)
+	}
+	if got := l.Label(httptest.NewRequest(http.MethodGet, "/models", nil)); got != "/models" {
+		t.Errorf("known label past the limit = %q, want /models", got)
+	}
+}
+
+func TestMetricsMiddlewareRoutes(t *testing.T) {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"method", "endpoint", "status"})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "duration"}, []string{"method", "endpoint"})
+	active := prometheus.NewGauge(prometheus.GaugeOpts{Name: "active"})
+	h := MetricsMiddleware(requests, duration, active, newLabeler(0))(http.NotFoundHandler())
+
+	// A scanner probing random paths
+	for i := 0; i < 100; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, fmt.Sprintf("/probe-%d.php", i), nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/models", nil))
+
+	if got := testutil.CollectAndCount(requests); got != 2 {
+		t.Errorf("%d series, want 2", got)
+	}
+	if got := testutil.ToFloat64(requests.WithLabelValues(http.MethodGet, OtherRoute, "404")); got != 100 {
+		t.Errorf("other requests = %v, want 100", got)
+	}
+	if got := testutil.ToFloat64(requests.WithLabelValues(OtherMethod, "/models", "404")); got != 1 {
+		t.Errorf("PROPFIND requests = %v, want 1", got)
+	}
+}
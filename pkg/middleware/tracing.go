@@ -5,6 +5,7 @@ import (
 
 	"github.com/ajeetraina/aiwatch/pkg/tracing"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 // TracingMiddleware adds OpenTelemetry tracing to HTTP requests
@@ -29,5 +30,9 @@ func TracingMiddleware(next http.Handler) http.Handler {
 
 		// Add the response status code to the span
 		tracing.AddAttribute(ctx, "http.status_code", writer.status)
+		// Mark server errors so tail sampling keeps their traces
+		if writer.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(writer.status))
+		}
 	})
 }
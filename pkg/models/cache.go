@@ -0,0 +1,140 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+)
+
+// ListCache keeps the model list in memory so listing models doesn't shell
+// out to docker on every request. A list older than the TTL is still served
+// while a refresh runs in the background.
+type ListCache struct {
+	fetch func() ([]Model, error)
+
+	refreshMu  sync.Mutex // serialises fetches
+	refreshing atomic.Bool
+
+	mu        sync.Mutex
+	ttl       time.Duration
+	models    []Model
+	fetchedAt time.Time
+	created   time.Time
+	err       error // last fetch error, served for the TTL while nothing is cached
+	failedAt  time.Time
+}
+
+// List is the process-wide model list cache used by the /models endpoint
+var List = NewListCache(GetAvailableModels, 30*time.Second)
+
+// NewListCache creates a cache that serves fetch's result for ttl
+func NewListCache(fetch func() ([]Model, error), ttl time.Duration) *ListCache {
+	return &ListCache{fetch: fetch, ttl: ttl, created: time.Now()}
+}
+
+// Get returns a copy of the cached list. It fetches synchronously when
+// nothing is cached yet or force is set, and in the background when the
+// cached list is older than the TTL. A failed fetch keeps serving the last
+// list; the error is only returned when there is none, and is itself cached
+// for the TTL so a missing docker CLI isn't retried on every request.
+func (c *ListCache) Get(force bool) ([]Model, error) {
+	c.mu.Lock()
+	empty := c.fetchedAt.IsZero()
+	stale := time.Since(c.fetchedAt) > c.ttl
+	lastErr, recentFailure := c.err, time.Since(c.failedAt) <= c.ttl
+	c.mu.Unlock()
+
+	if empty && recentFailure && !force {
+		return nil, lastErr
+	}
+	if force || empty {
+		if err := c.Refresh(); err != nil {
+			if models := c.cached(); models != nil {
+				return models, nil
+			}
+			return nil, err
+		}
+	} else if stale && c.refreshing.CompareAndSwap(false, true) {
+		go func() {
+			defer c.refreshing.Store(false)
+			if err := c.Refresh(); err != nil {
+				log := logger.GetLogger()
+				log.Warn().Err(err).Msg("Background model list refresh failed")
+			}
+		}()
+	}
+	return c.cached(), nil
+}
+
+// cached returns a copy of the cached list, safe for callers to modify
+func (c *ListCache) cached() []Model {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.models == nil {
+		return nil
+	}
+	return append([]Model(nil), c.models...)
+}
+
+// Refresh fetches the list now
+func (c *ListCache) Refresh() error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	models, err := c.fetch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.err, c.failedAt = err, time.Now()
+		return err
+	}
+	c.models, c.fetchedAt, c.err = models, time.Now(), nil
+	return nil
+}
+
+// Age is how old the cached list is, or how long the cache has gone without
+// a successful fetch
+func (c *ListCache) Age() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fetchedAt.IsZero() {
+		return time.Since(c.created)
+	}
+	return time.Since(c.fetchedAt)
+}
+
+// Reset discards the cached list
+func (c *ListCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.models, c.fetchedAt = nil, time.Time{}
+	c.err, c.failedAt = nil, time.Time{}
+}
+
+// StartRefreshing sets the TTL and refreshes the list every ttl until ctx is
+// cancelled, so requests rarely find it stale
+func (c *ListCache) StartRefreshing(ctx context.Context, ttl time.Duration) {
+	c.mu.Lock()
+	c.ttl = ttl
+	c.mu.Unlock()
+
+	log := logger.GetLogger()
+	go func() {
+		ticker := time.NewTicker(ttl)
+		defer ticker.Stop()
+		for {
+			if err := c.Refresh(); err != nil {
+				log.Warn().Err(err).Msg("Model list refresh failed")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
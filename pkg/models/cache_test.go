@@ -0,0 +1,89 @@
+package models
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestListCacheServesCachedCopies(t *testing.T) {
+	var fetches atomic.Int32
+	cache := NewListCache(func() ([]Model, error) {
+		fetches.Add(1)
+		return []Model{{Name: "ai/llama3.2"}}, nil
+	}, time.Hour)
+
+	first, err := cache.Get(false)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("Get = %v, %v", first, err)
+	}
+	first[0].Name = "modified"
+	second, _ := cache.Get(false)
+	if second[0].Name != "ai/llama3.2" {
+		t.Error("callers can modify the cached list")
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("fetched %d times within the TTL, want 1", fetches.Load())
+	}
+
+	cache.Get(true)
+	if fetches.Load() != 2 {
+		t.Errorf("force refresh did not fetch, %d fetches", fetches.Load())
+	}
+}
+
+func TestListCacheRefreshesStaleListInBackground(t *testing.T) {
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	cache := NewListCache(func() ([]Model, error) {
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		return []Model{{Name: "ai/qwen3"}}, nil
+	}, time.Millisecond)
+
+	cache.Get(false)
+	time.Sleep(5 * time.Millisecond)
+
+	// The stale list is served without waiting for the refresh
+	done := make(chan []Model)
+	go func() {
+		models, _ := cache.Get(false)
+		done <- models
+	}()
+	select {
+	case models := <-done:
+		if len(models) != 1 {
+			t.Errorf("stale get = %v", models)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Get blocked on the background refresh")
+	}
+	close(release)
+	if cache.Age() > time.Second {
+		t.Errorf("age = %v", cache.Age())
+	}
+}
+
+func TestListCacheKeepsLastListOnError(t *testing.T) {
+	fail := false
+	cache := NewListCache(func() ([]Model, error) {
+		if fail {
+			return nil, errors.New("docker unavailable")
+		}
+		return []Model{{Name: "ai/gemma3"}}, nil
+	}, time.Hour)
+
+	cache.Get(false)
+	fail = true
+	models, err := cache.Get(true)
+	if err != nil || len(models) != 1 {
+		t.Errorf("Get after failed refresh = %v, %v", models, err)
+	}
+
+	cache.Reset()
+	if _, err := cache.Get(false); err == nil {
+		t.Error("expected an error with nothing cached")
+	}
+}
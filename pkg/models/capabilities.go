@@ -0,0 +1,266 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+)
+
+// Capabilities describes what a model supports, so clients can enable
+// features per model
+type Capabilities struct {
+	ContextWindow      int       `json:"contextWindow,omitempty"`
+	Tools              bool      `json:"tools"`
+	Vision             bool      `json:"vision"`
+	Embedding          bool      `json:"embedding"`
+	EmbeddingDimension int       `json:"embeddingDimension,omitempty"`
+	Source             string    `json:"source"` // "backend" when the backend reported them, "name" when inferred
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+// CapabilityCache holds the capabilities discovered for each model
+type CapabilityCache struct {
+	mu     sync.RWMutex
+	models map[string]Capabilities
+}
+
+// Discovery is the process-wide capability cache used by the /models endpoint
+var Discovery = NewCapabilityCache()
+
+// NewCapabilityCache creates an empty capability cache
+func NewCapabilityCache() *CapabilityCache {
+	return &CapabilityCache{models: make(map[string]Capabilities)}
+}
+
+// Get returns the capabilities of model. Models the backend hasn't reported
+// get capabilities inferred from their name.
+func (c *CapabilityCache) Get(model string) Capabilities {
+	c.mu.RLock()
+	caps, ok := c.models[model]
+	if !ok {
+		caps, ok = c.models[strings.TrimSuffix(model, ":latest")]
+	}
+	c.mu.RUnlock()
+	if ok {
+		return caps
+	}
+	return inferCapabilities(model)
+}
+
+// Reset discards all discovered capabilities
+func (c *CapabilityCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.models = make(map[string]Capabilities)
+}
+
+// backendModel is an entry of an OpenAI-compatible /models response,
+// including the extensions llama.cpp, vLLM and LM Studio add
+type backendModel struct {
+	ID   string `json:"id"`
+	Type string `json:"type"` // LM Studio: llm, vlm or embeddings
+	Meta struct {
+		NCtxTrain int `json:"n_ctx_train"`
+		NEmbd     int `json:"n_embd"`
+	} `json:"meta"` // llama.cpp
+	MaxModelLen      int      `json:"max_model_len"`      // vLLM
+	MaxContextLength int      `json:"max_context_length"` // LM Studio
+	Capabilities     []string `json:"capabilities"`
+}
+
+// Discover lists the backend's models and records their capabilities,
+// combining what the backend reports with what the model name implies.
+// Embedding models are asked for one embedding to learn their dimension.
+func (c *CapabilityCache) Discover(ctx context.Context, baseURL, apiKey string) error {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/models", nil)
+	if err != nil {
+		return err
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("model discovery returned status %d", resp.StatusCode)
+	}
+
+	var list struct {
+		Data []backendModel `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return fmt.Errorf("failed to decode model list: %w", err)
+	}
+
+	discovered := make(map[string]Capabilities, len(list.Data))
+	for _, m := range list.Data {
+		caps := inferCapabilities(m.ID)
+		if reported := m.apply(&caps); reported {
+			caps.Source = "backend"
+		}
+		if caps.Embedding && caps.EmbeddingDimension == 0 {
+			if previous, ok := c.cached(m.ID); ok && previous.EmbeddingDimension > 0 {
+				caps.EmbeddingDimension = previous.EmbeddingDimension
+			} else if dim, err := embeddingDimension(ctx, baseURL, apiKey, m.ID); err == nil {
+				caps.EmbeddingDimension = dim
+			}
+		}
+		caps.UpdatedAt = time.Now()
+		discovered[m.ID] = caps
+	}
+
+	c.mu.Lock()
+	c.models = discovered
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *CapabilityCache) cached(model string) (Capabilities, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	caps, ok := c.models[model]
+	return caps, ok
+}
+
+// apply overrides inferred capabilities with what the backend reported,
+// returning whether it reported anything
+func (m backendModel) apply(caps *Capabilities) bool {
+	reported := false
+	for _, window := range []int{m.MaxModelLen, m.MaxContextLength, m.Meta.NCtxTrain} {
+		if window > 0 {
+			caps.ContextWindow = window
+			reported = true
+			break
+		}
+	}
+	switch m.Type {
+	case "embeddings":
+		*caps = Capabilities{ContextWindow: caps.ContextWindow, Embedding: true}
+		reported = true
+	case "vlm":
+		caps.Vision = true
+		reported = true
+	}
+	if len(m.Capabilities) > 0 {
+		caps.Tools, caps.Vision, caps.Embedding = false, false, false
+		for _, name := range m.Capabilities {
+			switch strings.ToLower(name) {
+			case "tools":
+				caps.Tools = true
+			case "vision":
+				caps.Vision = true
+			case "embedding", "embeddings":
+				caps.Embedding = true
+			}
+		}
+		reported = true
+	}
+	if caps.Embedding && m.Meta.NEmbd > 0 {
+		caps.EmbeddingDimension = m.Meta.NEmbd
+	}
+	return reported
+}
+
+// embeddingDimension requests one embedding from model and returns its length
+func embeddingDimension(ctx context.Context, baseURL, apiKey, model string) (int, error) {
+	body, _ := json.Marshal(map[string]interface{}{"model": model, "input": "dimension probe"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("embedding probe returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Data []struct {
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	if len(result.Data) == 0 || len(result.Data[0].Embedding) == 0 {
+		return 0, fmt.Errorf("embedding probe returned no embedding")
+	}
+	return len(result.Data[0].Embedding), nil
+}
+
+// Model families recognised by name, matched against the lowercased name
+// with separators removed
+var (
+	embeddingFamilies = []string{"embed", "bge", "mxbai", "minilm", "nomic"}
+	visionFamilies    = []string{"vision", "llava", "gemma3", "vl", "smolvlm", "moondream", "pixtral", "llama4"}
+	toolFamilies      = []string{"llama3.1", "llama3.2", "llama3.3", "llama4", "qwen2.5", "qwen3", "mistral", "mixtral", "granite", "hermes", "phi4", "gptoss", "smollm3"}
+)
+
+// inferCapabilities guesses capabilities from the model name, for backends
+// that report nothing beyond model IDs
+func inferCapabilities(model string) Capabilities {
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.NewReplacer("-", "", "_", "", " ", "").Replace(name)
+
+	caps := Capabilities{Source: "name"}
+	if hasFamily(name, embeddingFamilies) {
+		caps.Embedding = true
+		return caps
+	}
+	caps.Vision = hasFamily(name, visionFamilies)
+	caps.Tools = hasFamily(name, toolFamilies)
+	return caps
+}
+
+func hasFamily(name string, families []string) bool {
+	for _, family := range families {
+		if strings.Contains(name, family) {
+			return true
+		}
+	}
+	return false
+}
+
+// StartDiscovery refreshes capabilities on an interval until ctx is cancelled
+func (c *CapabilityCache) StartDiscovery(ctx context.Context, baseURL, apiKey string, interval time.Duration) {
+	log := logger.GetLogger()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			discoverCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			if err := c.Discover(discoverCtx, baseURL, apiKey); err != nil {
+				log.Warn().Err(err).Msg("Model capability discovery failed")
+			}
+			cancel()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInferCapabilities(t *testing.T) {
+	tests := []struct {
+		model string
+		want  Capabilities
+	}{
+		{"ai/llama3.2:1B-Q8_0", Capabilities{Tools: true}},
+		{"ai/gemma3", Capabilities{Vision: true}},
+		{"ai/mxbai-embed-large", Capabilities{Embedding: true}},
+		{"ai/smollm2", Capabilities{}},
+	}
+	for _, tt := range tests {
+		got := inferCapabilities(tt.model)
+		if got.Tools != tt.want.Tools || got.Vision != tt.want.Vision || got.Embedding != tt.want.Embedding || got.Source != "name" {
+			t.Errorf("inferCapabilities(%q) = %+v, want %+v", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestDiscoverUsesBackendMetadataAndProbesEmbeddings(t *testing.T) {
+	embeddingProbes := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/models":
+			w.Write([]byte(`{"data":[
+				{"id":"ai/llama3.2","meta":{"n_ctx_train":131072,"n_embd":2048}},
+				{"id":"ai/nomic-embed-text"},
+				{"id":"custom","capabilities":["completion","vision"]}
+			]}`))
+		case "/embeddings":
+			embeddingProbes++
+			w.Write([]byte(`{"data":[{"embedding":[0.1,0.2,0.3,0.4]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer backend.Close()
+
+	cache := NewCapabilityCache()
+	for i := 0; i < 2; i++ {
+		if err := cache.Discover(context.Background(), backend.URL, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	llama := cache.Get("ai/llama3.2:latest")
+	if llama.ContextWindow != 131072 || !llama.Tools || llama.EmbeddingDimension != 0 || llama.Source != "backend" {
+		t.Errorf("llama capabilities = %+v", llama)
+	}
+	embed := cache.Get("ai/nomic-embed-text")
+	if !embed.Embedding || embed.EmbeddingDimension != 4 {
+		t.Errorf("embedding capabilities = %+v", embed)
+	}
+	if embeddingProbes != 1 {
+		t.Errorf("embedding dimension probed %d times, want once", embeddingProbes)
+	}
+	if custom := cache.Get("custom"); !custom.Vision || custom.Tools {
+		t.Errorf("custom capabilities = %+v", custom)
+	}
+	if unknown := cache.Get("ai/qwen3"); !unknown.Tools || unknown.Source != "name" {
+		t.Errorf("undiscovered model capabilities = %+v", unknown)
+	}
+}
@@ -16,6 +16,8 @@ type DebugInfo struct {
 	GroupID           string `json:"groupID"`
 	DockerSocketPath  string `json:"dockerSocketPath"`
 	DockerSocketPerms string `json:"dockerSocketPerms"`
+	// ModelRunner is the Model Runner API's status, when its address is known
+	ModelRunner *RunnerStatus `json:"modelRunner,omitempty"`
 	Error             string `json:"error,omitempty"`
 }
 
@@ -63,8 +65,12 @@ func HandleDebugDocker(w http.ResponseWriter, r *http.Request) {
 		debugInfo.DockerSocketPerms = string(lsOutput)
 	}
 	
+	if ModelRunner != nil {
+		status := ModelRunner.Status(r.Context())
+		debugInfo.ModelRunner = &status
+	}
+
 	// Send response
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	json.NewEncoder(w).Encode(debugInfo)
 }
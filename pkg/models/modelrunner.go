@@ -0,0 +1,214 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+)
+
+// ModelRunnerClient talks to Docker Model Runner's REST API, over TCP or a
+// unix socket, instead of parsing `docker model` CLI output
+type ModelRunnerClient struct {
+	url     string // as configured
+	baseURL string // e.g. http://model-runner.docker.internal
+	client  *http.Client
+}
+
+// ModelRunner is the process-wide Model Runner API client, nil when the
+// runner's address is unknown
+var ModelRunner *ModelRunnerClient
+
+// NewModelRunnerClient creates a client for the runner at rawURL: an
+// http(s) URL, or unix:///path/to.sock for a socket
+func NewModelRunnerClient(rawURL string) (*ModelRunnerClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Model Runner URL: %w", err)
+	}
+	c := &ModelRunnerClient{url: rawURL, client: &http.Client{Timeout: 10 * time.Second}}
+	switch u.Scheme {
+	case "http", "https":
+		c.baseURL = strings.TrimSuffix(u.String(), "/")
+	case "unix":
+		socket := u.Path
+		c.baseURL = "http://model-runner"
+		c.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	default:
+		return nil, fmt.Errorf("invalid Model Runner URL %q, want http(s):// or unix://", rawURL)
+	}
+	return c, nil
+}
+
+// RunnerURL derives the runner's address from an inference URL such as
+// http://model-runner.docker.internal/engines/llama.cpp/v1/, or returns ""
+// when baseURL does not point at Model Runner
+func RunnerURL(baseURL string) string {
+	prefix, _, ok := strings.Cut(baseURL, "/engines/")
+	if !ok {
+		return ""
+	}
+	return prefix
+}
+
+// URL returns the runner's address
+func (c *ModelRunnerClient) URL() string {
+	return c.url
+}
+
+// InferenceURL returns the runner's OpenAI-compatible endpoint
+func (c *ModelRunnerClient) InferenceURL() string {
+	return c.baseURL + "/engines/v1/"
+}
+
+// runnerModel is an entry of GET /models
+type runnerModel struct {
+	ID      string   `json:"id"` // sha256:...
+	Tags    []string `json:"tags"`
+	Created int64    `json:"created"`
+	Config  struct {
+		Parameters   string `json:"parameters"`
+		Quantization string `json:"quantization"`
+		Architecture string `json:"architecture"`
+		Size         string `json:"size"`
+	} `json:"config"`
+}
+
+// ListModels lists the models pulled into the runner, one per tag as
+// `docker model ls` does
+func (c *ModelRunnerClient) ListModels() ([]Model, error) {
+	var list []runnerModel
+	if err := c.get(context.Background(), "/models", &list); err != nil {
+		return nil, err
+	}
+	models := make([]Model, 0, len(list))
+	for _, m := range list {
+		id := strings.TrimPrefix(m.ID, "sha256:")
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		tags := m.Tags
+		if len(tags) == 0 {
+			tags = []string{id}
+		}
+		for _, tag := range tags {
+			models = append(models, Model{
+				Name:         tag,
+				Parameters:   m.Config.Parameters,
+				Quantization: m.Config.Quantization,
+				Architecture: m.Config.Architecture,
+				ModelID:      id,
+				Created:      ago(time.Unix(m.Created, 0), time.Now()),
+				Size:         m.Config.Size,
+			})
+		}
+	}
+	return models, nil
+}
+
+// RunnerStatus is Model Runner's state, as shown by /debug/docker
+type RunnerStatus struct {
+	URL     string            `json:"url"`
+	Running bool              `json:"running"`
+	Engines map[string]string `json:"engines,omitempty"` // inference engine to status
+	Error   string            `json:"error,omitempty"`
+}
+
+// Status reports whether the runner is up and the state of its engines
+func (c *ModelRunnerClient) Status(ctx context.Context) RunnerStatus {
+	status := RunnerStatus{URL: c.url}
+	var list []runnerModel
+	if err := c.get(ctx, "/models", &list); err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Running = true
+	c.get(ctx, "/engines/status", &status.Engines)
+	return status
+}
+
+// Running returns the models loaded in the runner, like `docker model ps`
+func (c *ModelRunnerClient) Running(ctx context.Context) ([]string, error) {
+	var running []struct {
+		Model string `json:"model_name"`
+	}
+	if err := c.get(ctx, "/engines/ps", &running); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(running))
+	for _, r := range running {
+		names = append(names, r.Model)
+	}
+	return names, nil
+}
+
+// get fetches and decodes a JSON document from the runner
+func (c *ModelRunnerClient) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("model runner: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("model runner: GET %s returned status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// WithFallback returns a lister trying primary first and fallback when it
+// fails, e.g. the runner's API and then the docker CLI
+func WithFallback(primary, fallback func() ([]Model, error)) func() ([]Model, error) {
+	return func() ([]Model, error) {
+		models, err := primary()
+		if err == nil {
+			return models, nil
+		}
+		log := logger.GetLogger()
+		log.Warn().Err(err).Msg("Listing models through the Model Runner API failed, falling back")
+		return fallback()
+	}
+}
+
+// ago formats t relative to now the way the docker CLI does, e.g. "5 weeks ago"
+func ago(t, now time.Time) string {
+	d := now.Sub(t)
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	switch {
+	case d < time.Minute:
+		return "Less than a minute ago"
+	case d < time.Hour:
+		return plural(int(d.Minutes()), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d.Hours()), "hour")
+	case d < 14*24*time.Hour:
+		return plural(int(d.Hours()/24), "day")
+	case d < 60*24*time.Hour:
+		return plural(int(d.Hours()/24/7), "week")
+	case d < 2*365*24*time.Hour:
+		return plural(int(d.Hours()/24/30), "month")
+	default:
+		return plural(int(d.Hours()/24/365), "year")
+	}
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeModelRunner serves the Model Runner endpoints aiwatch uses
+func fakeModelRunner() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /models", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]interface{}{{
+			"id":      "sha256:a15c3117eeeb0123456789",
+			"tags":    []string{"ai/llama3.2:1B-Q8_0", "ai/llama3.2:latest"},
+			"created": time.Now().Add(-36 * 24 * time.Hour).Unix(),
+			"config":  map[string]string{"parameters": "1.24 B", "quantization": "Q8_0", "architecture": "llama", "size": "1.22 GiB"},
+		}})
+	})
+	mux.HandleFunc("GET /engines/status", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"llama.cpp": "running"})
+	})
+	mux.HandleFunc("GET /engines/ps", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]string{{"model_name": "ai/llama3.2:1B-Q8_0", "backend_name": "llama.cpp"}})
+	})
+	return mux
+}
+
+func TestModelRunnerClientListsModels(t *testing.T) {
+	server := httptest.NewServer(fakeModelRunner())
+	defer server.Close()
+
+	client, err := NewModelRunnerClient(RunnerURL(server.URL + "/engines/llama.cpp/v1/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	models, err := client.ListModels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Model{Name: "ai/llama3.2:1B-Q8_0", Parameters: "1.24 B", Quantization: "Q8_0", Architecture: "llama", ModelID: "a15c3117eeeb", Created: "5 weeks ago", Size: "1.22 GiB"}
+	if len(models) != 2 || models[0] != want || models[1].Name != "ai/llama3.2:latest" {
+		t.Errorf("models = %+v", models)
+	}
+
+	status := client.Status(context.Background())
+	if !status.Running || status.Engines["llama.cpp"] != "running" {
+		t.Errorf("status = %+v", status)
+	}
+	if client.InferenceURL() != server.URL+"/engines/v1/" {
+		t.Errorf("inference URL = %q", client.InferenceURL())
+	}
+
+	docker := &fakeDocker{}
+	runner := NewRunner(docker.command)
+	runner.Running = client.Running
+	if err := runner.Sync(context.Background()); err != nil || !runner.IsLoaded("ai/llama3.2:1B-Q8_0") || len(docker.calls) != 0 {
+		t.Errorf("sync through the API: loaded %v, %v, CLI calls %v", runner.Loaded(), err, docker.calls)
+	}
+}
+
+func TestModelRunnerClientOverSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "runner.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip("unix sockets unavailable:", err)
+	}
+	server := httptest.NewUnstartedServer(fakeModelRunner())
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	client, err := NewModelRunnerClient("unix://" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if models, err := client.ListModels(); err != nil || len(models) != 2 {
+		t.Errorf("models = %+v, %v", models, err)
+	}
+}
+
+func TestListFallsBackWhenAPIFails(t *testing.T) {
+	list := WithFallback(
+		func() ([]Model, error) { return nil, errors.New("connection refused") },
+		func() ([]Model, error) { return []Model{{Name: "from-cli"}}, nil },
+	)
+	if models, err := list(); err != nil || len(models) != 1 || models[0].Name != "from-cli" {
+		t.Errorf("models = %+v, %v", models, err)
+	}
+	if RunnerURL("http://localhost:11434/v1/") != "" {
+		t.Error("runner URL derived for a non-Model Runner backend")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/ajeetraina/aiwatch/pkg/logger"
@@ -12,13 +13,17 @@ import (
 
 // Model represents a Docker model
 type Model struct {
-	Name         string `json:"name"`
-	Parameters   string `json:"parameters"`
-	Quantization string `json:"quantization"`
-	Architecture string `json:"architecture"`
-	ModelID      string `json:"modelId"`
-	Created      string `json:"created"`
-	Size         string `json:"size"`
+	Name         string        `json:"name"`
+	Parameters   string        `json:"parameters"`
+	Quantization string        `json:"quantization"`
+	Architecture string        `json:"architecture"`
+	ModelID      string        `json:"modelId"`
+	Created      string        `json:"created"`
+	Size         string        `json:"size"`
+	Live         *LiveStatus   `json:"live,omitempty"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+	Loaded       bool          `json:"loaded"`
+	Current      bool          `json:"current,omitempty"`
 }
 
 // GetAvailableModels retrieves the list of available models from Docker Model Runner
@@ -57,14 +62,24 @@ func GetAvailableModels() ([]Model, error) {
 		return nil, fmt.Errorf("failed to list docker models: %v, output: %s", err, string(output))
 	}
 
+	log.Info().Str("output", string(output)).Msg("Docker model ls output")
+
 	// Parse the output
+	models := ParseModelList(string(output))
+
+	log.Info().Int("count", len(models)).Msg("Retrieved available Docker models")
+	return models, nil
+}
+
+// ParseModelList parses the tab-separated output of 'docker model ls --format',
+// skipping lines that don't have the expected columns
+func ParseModelList(output string) []Model {
+	log := logger.GetLogger()
+
 	models := []Model{}
-	lines := strings.Split(string(output), "\n")
-	
-	log.Info().Str("output", string(output)).Msg("Docker model ls output")
-	
-	for _, line := range lines {
-		if line == "" {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
 			continue
 		}
 		
@@ -75,20 +90,56 @@ func GetAvailableModels() ([]Model, error) {
 		}
 		
 		model := Model{
-			Name:         fields[0],
-			Parameters:   fields[1],
-			Quantization: fields[2],
-			Architecture: fields[3],
-			ModelID:      fields[4],
-			Created:      fields[5],
-			Size:         fields[6],
+			Name:         strings.TrimSpace(fields[0]),
+			Parameters:   strings.TrimSpace(fields[1]),
+			Quantization: strings.TrimSpace(fields[2]),
+			Architecture: strings.TrimSpace(fields[3]),
+			ModelID:      strings.TrimSpace(fields[4]),
+			Created:      strings.TrimSpace(fields[5]),
+			Size:         strings.TrimSpace(fields[6]),
+		}
+		if model.Name == "" {
+			log.Warn().Str("line", line).Msg("Model line has no name")
+			continue
 		}
 		
 		models = append(models, model)
 	}
+	return models
+}
 
-	log.Info().Int("count", len(models)).Msg("Retrieved available Docker models")
-	return models, nil
+// ListFromAPI returns a model lister reading the backend's OpenAI-compatible
+// /models endpoint, for deployments without the docker CLI such as Kubernetes
+func ListFromAPI(baseURL, apiKey string) func() ([]Model, error) {
+	return func() ([]Model, error) {
+		req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(baseURL, "/")+"/models", nil)
+		if err != nil {
+			return nil, err
+		}
+		if apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list models: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("model list returned status %d", resp.StatusCode)
+		}
+
+		var list struct {
+			Data []backendModel `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			return nil, fmt.Errorf("failed to decode model list: %w", err)
+		}
+		models := make([]Model, 0, len(list.Data))
+		for _, m := range list.Data {
+			models = append(models, Model{Name: m.ID, ModelID: m.ID})
+		}
+		return models, nil
+	}
 }
 
 // GetFallbackModels returns hard-coded models for when Docker commands fail
@@ -115,24 +166,42 @@ func GetFallbackModels() []Model {
 	}
 }
 
-// HandleListModels returns the list of available models as JSON
+// HandleListModels returns the list of available models as JSON. The list
+// is served from the cache; ?refresh=true fetches it again first.
 func HandleListModels(w http.ResponseWriter, r *http.Request) {
 	log := logger.GetLogger()
-	
-	models, err := GetAvailableModels()
+
+	force, _ := strconv.ParseBool(r.URL.Query().Get("refresh"))
+	models, err := List.Get(force)
 	if err != nil || len(models) == 0 {
 		log.Error().Err(err).Msg("Failed to get available models, using fallback")
-		
-		// Use fallback models
-		fallbackModels := GetFallbackModels()
-		
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		json.NewEncoder(w).Encode(fallbackModels)
-		return
+		models = GetFallbackModels()
+	}
+
+	withLiveStatus(models)
+	withCapabilities(models)
+	current := Lifecycle.Current()
+	for i := range models {
+		models[i].Loaded = Lifecycle.IsLoaded(models[i].Name)
+		models[i].Current = models[i].Name == current
 	}
-	
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	json.NewEncoder(w).Encode(models)
-}
\ No newline at end of file
+}
+
+// withLiveStatus attaches live status to each model and orders them by health
+func withLiveStatus(models []Model) {
+	for i := range models {
+		live := Tracker.Get(models[i].Name)
+		models[i].Live = &live
+	}
+	SortByHealth(models)
+}
+
+// withCapabilities attaches the discovered capabilities to each model
+func withCapabilities(models []Model) {
+	for i := range models {
+		caps := Discovery.Get(models[i].Name)
+		models[i].Capabilities = &caps
+	}
+}
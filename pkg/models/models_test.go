@@ -0,0 +1,42 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseModelList(t *testing.T) {
+	output := "ai/llama3.2:1B-Q8_0\t1.24 B\tQ8_0\tllama\ta15c3117eeeb\t5 weeks ago\t1.22 GiB\n" +
+		"garbage line\n" +
+		"ai/qwen3\t8.19 B\tIQ2_XXS/Q4_K_M\tqwen3\t79fa56c07429\t3 days ago\t4.68 GiB\r\n"
+
+	models := ParseModelList(output)
+	if len(models) != 2 {
+		t.Fatalf("expected 2 models, got %d: %+v", len(models), models)
+	}
+	if models[1].Size != "4.68 GiB" {
+		t.Errorf("expected trailing carriage return to be trimmed, got %q", models[1].Size)
+	}
+}
+
+// FuzzParseModelList asserts parsing never panics and only yields models
+// with a name, one per well-formed line at most
+func FuzzParseModelList(f *testing.F) {
+	f.Add("ai/llama3.2:1B-Q8_0\t1.24 B\tQ8_0\tllama\ta15c3117eeeb\t5 weeks ago\t1.22 GiB\n")
+	f.Add("\t\t\t\t\t\t\n")
+	f.Add("a\tb\tc\n\r\n\t")
+	f.Fuzz(func(t *testing.T, output string) {
+		models := ParseModelList(output)
+		if len(models) > strings.Count(output, "\n")+1 {
+			t.Fatalf("parsed %d models from %d lines", len(models), strings.Count(output, "\n")+1)
+		}
+		for _, m := range models {
+			if m.Name == "" {
+				t.Fatalf("parsed a model without a name: %+v", m)
+			}
+			if strings.ContainsAny(m.Name+m.Size, "\t\n") {
+				t.Fatalf("field contains a separator: %+v", m)
+			}
+		}
+	})
+}
@@ -0,0 +1,328 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// runTimeout bounds loading a model, which pulls it first if needed
+const runTimeout = 10 * time.Minute
+
+// modelName matches model references accepted by the docker model CLI. It
+// also keeps names from being read as command-line flags.
+var modelName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/:@-]*$`)
+
+// progressInterval is how often waiting requests are told a load is ongoing
+const progressInterval = time.Second
+
+// SwapMetrics holds the collectors on-demand model loads report to
+type SwapMetrics struct {
+	Swaps        *prometheus.CounterVec   // labels: model, result
+	LoadDuration *prometheus.HistogramVec // labels: model
+	Pending      prometheus.Gauge         // requests waiting on a load
+}
+
+// Runner starts and stops Docker Model Runner serving for models and tracks
+// which of them are loaded
+type Runner struct {
+	command func(ctx context.Context, args ...string) ([]byte, error)
+	Metrics SwapMetrics
+	// Running, if set, lists the loaded models in place of `docker model
+	// ps`, which remains the fallback when it fails
+	Running func(ctx context.Context) ([]string, error)
+
+	mu      sync.Mutex
+	loaded  map[string]time.Time
+	loading map[string]*pendingLoad
+	current string
+}
+
+// pendingLoad is a load that requests for the model queue behind
+type pendingLoad struct {
+	started time.Time
+	done    chan struct{}
+	err     error
+}
+
+// Lifecycle is the process-wide runner used by the model control endpoints
+var Lifecycle = NewRunner(dockerModel)
+
+// NewRunner creates a runner that invokes `docker model` through command
+func NewRunner(command func(ctx context.Context, args ...string) ([]byte, error)) *Runner {
+	return &Runner{
+		command: command,
+		loaded:  make(map[string]time.Time),
+		loading: make(map[string]*pendingLoad),
+	}
+}
+
+// dockerModel runs a `docker model` subcommand
+func dockerModel(ctx context.Context, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, "docker", append([]string{"model"}, args...)...).CombinedOutput()
+}
+
+// Run loads model in the background and makes it the current model
+func (r *Runner) Run(ctx context.Context, model string) error {
+	if !modelName.MatchString(model) {
+		return fmt.Errorf("invalid model name %q", model)
+	}
+	Tracker.MarkLoading(model)
+	if output, err := r.command(ctx, "run", "--detach", model); err != nil {
+		err = fmt.Errorf("docker model run failed: %v: %s", err, strings.TrimSpace(string(output)))
+		Tracker.RecordRequest(model, 0, err)
+		return err
+	}
+	Tracker.MarkLoaded(model)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loaded[model] = time.Now()
+	r.current = model
+	return nil
+}
+
+// Ensure loads model if it isn't loaded yet. Concurrent callers for the same
+// model wait on a single load, and progress is called every second while
+// they do. It reports whether the caller waited for a load.
+func (r *Runner) Ensure(ctx context.Context, model string, progress func(elapsed time.Duration)) (bool, error) {
+	r.mu.Lock()
+	if _, ok := r.loaded[model]; ok {
+		r.mu.Unlock()
+		return false, nil
+	}
+	load, ok := r.loading[model]
+	if !ok {
+		load = &pendingLoad{started: time.Now(), done: make(chan struct{})}
+		r.loading[model] = load
+		go r.load(model, load)
+	}
+	r.mu.Unlock()
+
+	if r.Metrics.Pending != nil {
+		r.Metrics.Pending.Inc()
+		defer r.Metrics.Pending.Dec()
+	}
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-load.done:
+			return true, load.err
+		case <-ticker.C:
+			progress(time.Since(load.started))
+		case <-ctx.Done():
+			return true, ctx.Err()
+		}
+	}
+}
+
+// load runs one queued load, independent of the requests waiting on it
+func (r *Runner) load(model string, load *pendingLoad) {
+	ctx, cancel := context.WithTimeout(context.Background(), runTimeout)
+	defer cancel()
+	load.err = r.Run(ctx, model)
+
+	result := "success"
+	if load.err != nil {
+		result = "failure"
+	}
+	if r.Metrics.Swaps != nil {
+		r.Metrics.Swaps.WithLabelValues(model, result).Inc()
+	}
+	if r.Metrics.LoadDuration != nil && load.err == nil {
+		r.Metrics.LoadDuration.WithLabelValues(model).Observe(time.Since(load.started).Seconds())
+	}
+
+	r.mu.Lock()
+	delete(r.loading, model)
+	r.mu.Unlock()
+	close(load.done)
+}
+
+// Stop unloads model from the runner
+func (r *Runner) Stop(ctx context.Context, model string) error {
+	if !modelName.MatchString(model) {
+		return fmt.Errorf("invalid model name %q", model)
+	}
+	if output, err := r.command(ctx, "unload", model); err != nil {
+		return fmt.Errorf("docker model unload failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.loaded, model)
+	if r.current == model {
+		r.current = ""
+	}
+	return nil
+}
+
+// Sync replaces the tracked models with those `docker model ps` reports as
+// running, so models loaded by chat traffic or unloaded by the runner's idle
+// timeout are reflected too
+func (r *Runner) Sync(ctx context.Context) error {
+	names, err := r.running(ctx)
+	if err != nil {
+		return err
+	}
+	running := make(map[string]bool, len(names))
+	for _, name := range names {
+		running[name] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name := range r.loaded {
+		if !running[name] {
+			delete(r.loaded, name)
+		}
+	}
+	for name := range running {
+		if _, ok := r.loaded[name]; !ok {
+			r.loaded[name] = time.Now()
+		}
+	}
+	if !running[r.current] {
+		r.current = ""
+	}
+	return nil
+}
+
+// running lists the models loaded in the runner
+func (r *Runner) running(ctx context.Context) ([]string, error) {
+	if r.Running != nil {
+		names, err := r.Running(ctx)
+		if err == nil {
+			return names, nil
+		}
+		log := logger.GetLogger()
+		log.Debug().Err(err).Msg("Listing running models through the Model Runner API failed, falling back to docker model ps")
+	}
+
+	output, err := r.command(ctx, "ps")
+	if err != nil {
+		return nil, fmt.Errorf("docker model ps failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	var names []string
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	for _, line := range lines[1:] { // skip the header
+		if fields := strings.Fields(line); len(fields) > 0 {
+			names = append(names, fields[0])
+		}
+	}
+	return names, nil
+}
+
+// StartSyncing syncs the loaded models every interval until ctx is cancelled
+func (r *Runner) StartSyncing(ctx context.Context, interval time.Duration) {
+	log := logger.GetLogger()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			syncCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			if err := r.Sync(syncCtx); err != nil {
+				log.Debug().Err(err).Msg("Loaded model sync failed")
+			}
+			cancel()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// IsLoaded reports whether model is loaded in the runner
+func (r *Runner) IsLoaded(model string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.loaded[model]
+	return ok
+}
+
+// Loaded returns the loaded models, sorted by name
+func (r *Runner) Loaded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.loaded))
+	for name := range r.loaded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Current returns the most recently started model still loaded, or ""
+func (r *Runner) Current() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Handler serves POST /models/{name}/run and POST /models/{name}/stop. Model
+// names contain slashes, so the action is taken from the end of the path.
+func (r *Runner) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := strings.TrimPrefix(req.URL.Path, "/models/")
+		slash := strings.LastIndex(path, "/")
+		if slash <= 0 {
+			apierror.Write(w, req, apierror.NotFound, "Not found")
+			return
+		}
+		model, action := path[:slash], path[slash+1:]
+		if action != "run" && action != "stop" {
+			apierror.Write(w, req, apierror.NotFound, "Not found")
+			return
+		}
+		if req.Method != http.MethodPost {
+			apierror.Write(w, req, apierror.MethodNotAllowed, "Method not allowed")
+			return
+		}
+		if !modelName.MatchString(model) {
+			apierror.Write(w, req, apierror.InvalidRequest, "Invalid model name")
+			return
+		}
+
+		// Loading can take minutes when the model has to be pulled
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		ctx, cancel := context.WithTimeout(req.Context(), runTimeout)
+		defer cancel()
+
+		log := logger.GetLogger()
+		var err error
+		if action == "run" {
+			err = r.Run(ctx, model)
+		} else {
+			err = r.Stop(ctx, model)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("model", model).Str("action", action).Msg("Model lifecycle command failed")
+			apierror.Write(w, req, apierror.Unavailable, err.Error())
+			return
+		}
+		log.Info().Str("model", model).Str("action", action).Msg("Model lifecycle command succeeded")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"model":   model,
+			"loaded":  action == "run",
+			"current": r.Current(),
+		})
+	})
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeDocker records `docker model` invocations and answers ps with running
+type fakeDocker struct {
+	calls   []string
+	running string
+	fail    bool
+}
+
+func (f *fakeDocker) command(ctx context.Context, args ...string) ([]byte, error) {
+	f.calls = append(f.calls, strings.Join(args, " "))
+	if f.fail {
+		return []byte("model not found"), errors.New("exit status 1")
+	}
+	if args[0] == "ps" {
+		return []byte("MODEL NAME  BACKEND    MODE        UNTIL\n" + f.running), nil
+	}
+	return nil, nil
+}
+
+func TestRunnerHandlerRunsAndStopsModels(t *testing.T) {
+	docker := &fakeDocker{}
+	runner := NewRunner(docker.command)
+	handler := runner.Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/models/ai/llama3.2:1B-Q8_0/run", nil))
+	if rec.Code != http.StatusOK || !runner.IsLoaded("ai/llama3.2:1B-Q8_0") || runner.Current() != "ai/llama3.2:1B-Q8_0" {
+		t.Fatalf("run: status %d, loaded %v", rec.Code, runner.Loaded())
+	}
+	if docker.calls[0] != "run --detach ai/llama3.2:1B-Q8_0" {
+		t.Errorf("ran %q", docker.calls[0])
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/models/ai/llama3.2:1B-Q8_0/stop", nil))
+	if rec.Code != http.StatusOK || runner.IsLoaded("ai/llama3.2:1B-Q8_0") || runner.Current() != "" {
+		t.Fatalf("stop: status %d, loaded %v", rec.Code, runner.Loaded())
+	}
+
+	for path, want := range map[string]int{
+		"/models/ai/qwen3/delete": http.StatusNotFound,
+		"/models/--help/run":      http.StatusBadRequest,
+	} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: status %d, want %d", path, rec.Code, want)
+		}
+	}
+
+	docker.fail = true
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/models/ai/missing/run", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "model not found") {
+		t.Errorf("failed run: status %d, body %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRunnerSyncTracksRunningModels(t *testing.T) {
+	docker := &fakeDocker{running: "ai/smollm2  llama.cpp  completion  4 minutes from now\n"}
+	runner := NewRunner(docker.command)
+	runner.Run(context.Background(), "ai/gemma3")
+
+	if err := runner.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := runner.Loaded(); len(got) != 1 || got[0] != "ai/smollm2" {
+		t.Errorf("loaded = %v, want [ai/smollm2]", got)
+	}
+	if runner.Current() != "" {
+		t.Errorf("current = %q after its model was unloaded", runner.Current())
+	}
+}
+
+func TestRunnerEnsureQueuesRequestsBehindOneLoad(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	runs := 0
+	runner := NewRunner(func(ctx context.Context, args ...string) ([]byte, error) {
+		mu.Lock()
+		runs++
+		mu.Unlock()
+		<-release
+		return nil, nil
+	})
+	runner.Metrics = SwapMetrics{
+		Swaps:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "swaps"}, []string{"model", "result"}),
+		Pending: prometheus.NewGauge(prometheus.GaugeOpts{Name: "pending"}),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if swapped, err := runner.Ensure(context.Background(), "ai/qwen3", func(time.Duration) {}); !swapped || err != nil {
+				t.Errorf("Ensure = %v, %v", swapped, err)
+			}
+		}()
+	}
+	for testutil.ToFloat64(runner.Metrics.Pending) != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if runs != 1 {
+		t.Errorf("loaded %d times, want once", runs)
+	}
+	if got := testutil.ToFloat64(runner.Metrics.Swaps.WithLabelValues("ai/qwen3", "success")); got != 1 {
+		t.Errorf("swaps = %v, want 1", got)
+	}
+	if swapped, _ := runner.Ensure(context.Background(), "ai/qwen3", nil); swapped {
+		t.Error("expected a loaded model not to be loaded again")
+	}
+}
@@ -0,0 +1,330 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+)
+
+// Status describes whether a model can currently serve requests
+type Status string
+
+const (
+	StatusLoaded      Status = "loaded"
+	StatusLoading     Status = "loading"
+	StatusUnavailable Status = "unavailable"
+	StatusUnknown     Status = "unknown"
+)
+
+// LiveStatus is the live health of a model derived from probes and traffic
+type LiveStatus struct {
+	Status          Status    `json:"status"`
+	TokensPerSecond float64   `json:"tokensPerSecond"`
+	ErrorRate       float64   `json:"errorRate"`
+	Requests        int       `json:"recentRequests"`
+	LastError       string    `json:"lastError,omitempty"`
+	LastChecked     time.Time `json:"lastChecked,omitempty"`
+}
+
+// outcome is a single observed request against a model
+type outcome struct {
+	at              time.Time
+	tokensPerSecond float64
+	failed          bool
+}
+
+// modelHealth holds the tracked state for a single model
+type modelHealth struct {
+	probeStatus Status
+	inflight    int // requests waiting on a first token, or loads running
+	lastError   string
+	lastChecked time.Time
+	outcomes    []outcome
+}
+
+// StatusTracker aggregates live model health from upstream probes and chat traffic
+type StatusTracker struct {
+	mu     sync.Mutex
+	window time.Duration
+	models map[string]*modelHealth
+}
+
+// Tracker is the process-wide model status tracker used by the /models endpoint
+var Tracker = NewStatusTracker(5 * time.Minute)
+
+// NewStatusTracker creates a tracker that considers traffic within window
+func NewStatusTracker(window time.Duration) *StatusTracker {
+	return &StatusTracker{
+		window: window,
+		models: make(map[string]*modelHealth),
+	}
+}
+
+// entry returns the state for model, creating it if needed. Callers must hold t.mu.
+func (t *StatusTracker) entry(model string) *modelHealth {
+	h, ok := t.models[model]
+	if !ok {
+		h = &modelHealth{probeStatus: StatusUnknown}
+		t.models[model] = h
+	}
+	return h
+}
+
+// Reset discards all tracked model state
+func (t *StatusTracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.models = make(map[string]*modelHealth)
+}
+
+// MarkLoading records that a request is waiting on the model's first token.
+// Each call must be followed by a RecordRequest for the same model.
+func (t *StatusTracker) MarkLoading(model string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry(model).inflight++
+}
+
+// MarkLoaded records that a load started with MarkLoading has finished
+func (t *StatusTracker) MarkLoaded(model string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h := t.entry(model)
+	h.done()
+	h.probeStatus = StatusLoaded
+	h.lastChecked = time.Now()
+}
+
+// done ends one request or load counted by MarkLoading
+func (h *modelHealth) done() {
+	if h.inflight > 0 {
+		h.inflight--
+	}
+}
+
+// RecordRequest records the outcome of a completed request against model
+func (t *StatusTracker) RecordRequest(model string, tokensPerSecond float64, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h := t.entry(model)
+	h.done()
+	h.outcomes = append(h.outcomes, outcome{at: time.Now(), tokensPerSecond: tokensPerSecond, failed: err != nil})
+	if err != nil {
+		h.lastError = err.Error()
+	} else {
+		h.probeStatus = StatusLoaded
+	}
+	t.prune(h)
+}
+
+// SetProbeResult records the status reported by an upstream probe
+func (t *StatusTracker) SetProbeResult(model string, status Status, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h := t.entry(model)
+	h.probeStatus = status
+	h.lastChecked = time.Now()
+	if err != nil {
+		h.lastError = err.Error()
+	}
+}
+
+// prune drops outcomes older than the tracking window. Callers must hold t.mu.
+func (t *StatusTracker) prune(h *modelHealth) {
+	cutoff := time.Now().Add(-t.window)
+	i := 0
+	for i < len(h.outcomes) && h.outcomes[i].at.Before(cutoff) {
+		i++
+	}
+	h.outcomes = h.outcomes[i:]
+}
+
+// Models returns the names of the tracked models, sorted
+func (t *StatusTracker) Models() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	names := make([]string, 0, len(t.models))
+	for name := range t.models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the live status of model
+func (t *StatusTracker) Get(model string) LiveStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h, ok := t.models[model]
+	if !ok {
+		return LiveStatus{Status: StatusUnknown}
+	}
+	t.prune(h)
+
+	live := LiveStatus{
+		Status:      h.probeStatus,
+		Requests:    len(h.outcomes),
+		LastError:   h.lastError,
+		LastChecked: h.lastChecked,
+	}
+
+	var failures, speedSamples int
+	var speedTotal float64
+	for _, o := range h.outcomes {
+		if o.failed {
+			failures++
+		} else if o.tokensPerSecond > 0 {
+			speedTotal += o.tokensPerSecond
+			speedSamples++
+		}
+	}
+	if live.Requests > 0 {
+		live.ErrorRate = float64(failures) / float64(live.Requests)
+	}
+	if speedSamples > 0 {
+		live.TokensPerSecond = speedTotal / float64(speedSamples)
+	}
+
+	// A pending first token on a model that isn't known to be loaded means it is being loaded
+	if h.inflight > 0 && live.Status != StatusLoaded {
+		live.Status = StatusLoading
+	}
+	// Recent traffic that mostly fails overrides a stale probe result
+	if live.Requests >= 3 && live.ErrorRate > 0.5 {
+		live.Status = StatusUnavailable
+	}
+
+	return live
+}
+
+// healthRank orders statuses from most to least preferable
+func healthRank(s Status) int {
+	switch s {
+	case StatusLoaded:
+		return 0
+	case StatusLoading:
+		return 1
+	case StatusUnknown:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// SortByHealth orders models so that healthy, fast models come first
+func SortByHealth(models []Model) {
+	sort.SliceStable(models, func(i, j int) bool {
+		a, b := models[i].Live, models[j].Live
+		if a == nil || b == nil {
+			return a != nil
+		}
+		if ra, rb := healthRank(a.Status), healthRank(b.Status); ra != rb {
+			return ra < rb
+		}
+		return a.ErrorRate < b.ErrorRate
+	})
+}
+
+// Probe queries the upstream OpenAI-compatible /models endpoint and updates the
+// status of every model it reports, as well as every model already tracked
+func (t *StatusTracker) Probe(ctx context.Context, baseURL, apiKey string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(baseURL, "/")+"/models", nil)
+	if err != nil {
+		return err
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.markAll(StatusUnavailable, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	// llama.cpp answers 503 while a model is still being loaded
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		t.markAll(StatusLoading, nil)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("model probe returned status %d", resp.StatusCode)
+		t.markAll(StatusUnavailable, err)
+		return err
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return fmt.Errorf("failed to decode model probe response: %w", err)
+	}
+
+	served := make(map[string]bool, len(list.Data))
+	for _, m := range list.Data {
+		served[m.ID] = true
+		t.SetProbeResult(m.ID, StatusLoaded, nil)
+	}
+
+	// Tracked models the backend no longer reports are unavailable
+	t.mu.Lock()
+	for name, h := range t.models {
+		if !served[name] {
+			h.probeStatus = StatusUnavailable
+			h.lastChecked = time.Now()
+		}
+	}
+	t.mu.Unlock()
+	return nil
+}
+
+// markAll sets the probe status for every tracked model
+func (t *StatusTracker) markAll(status Status, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, h := range t.models {
+		h.probeStatus = status
+		h.lastChecked = time.Now()
+		if err != nil {
+			h.lastError = err.Error()
+		}
+	}
+}
+
+// StartProbing probes the upstream backend on an interval until ctx is cancelled
+func (t *StatusTracker) StartProbing(ctx context.Context, baseURL, apiKey string, interval time.Duration) {
+	log := logger.GetLogger()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			probeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			if err := t.Probe(probeCtx, baseURL, apiKey); err != nil {
+				log.Warn().Err(err).Msg("Model status probe failed")
+			}
+			cancel()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStatusTrackerCountsConcurrentRequests(t *testing.T) {
+	tracker := NewStatusTracker(time.Minute)
+
+	tracker.MarkLoading("ai/smollm2")
+	tracker.MarkLoading("ai/smollm2")
+	if got := tracker.Get("ai/smollm2").Status; got != StatusLoading {
+		t.Fatalf("expected loading while requests wait on a first token, got %s", got)
+	}
+
+	tracker.RecordRequest("ai/smollm2", 0, errors.New("connection reset"))
+	if got := tracker.Get("ai/smollm2").Status; got != StatusLoading {
+		t.Fatalf("expected loading while one request still waits, got %s", got)
+	}
+
+	tracker.RecordRequest("ai/smollm2", 0, errors.New("connection reset"))
+	if got := tracker.Get("ai/smollm2").Status; got == StatusLoading {
+		t.Fatal("expected loading to end once every request finished")
+	}
+}
+
+func TestStatusTrackerMarkLoaded(t *testing.T) {
+	tracker := NewStatusTracker(time.Minute)
+	tracker.MarkLoading("ai/llama3.2")
+	tracker.MarkLoaded("ai/llama3.2")
+
+	tracker.SetProbeResult("ai/llama3.2", StatusUnknown, nil)
+	if got := tracker.Get("ai/llama3.2").Status; got == StatusLoading {
+		t.Fatal("expected a finished load not to count as loading")
+	}
+}
@@ -0,0 +1,117 @@
+package moderation
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ajeetraina/aiwatch/pkg/guardrails"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultPolicyMessage replaces the rest of a response that was flagged
+const DefaultPolicyMessage = "[Response stopped: the generated content violated the content policy]"
+
+// maxWindow forces a moderation call when a sentence runs this long
+const maxWindow = 400
+
+// Moderator classifies text, e.g. *guardrails.Moderation
+type Moderator interface {
+	Moderate(ctx context.Context, text string) (guardrails.ModerationResult, error)
+}
+
+// Stream holds back generated text until each sentence has been moderated.
+// Create one per response with NewStream.
+type Stream struct {
+	moderator Moderator
+	flags     *prometheus.CounterVec // labels: category
+	pending   strings.Builder
+	flagged   bool
+}
+
+// NewStream starts moderating a response, counting flags in flags
+func NewStream(moderator Moderator, flags *prometheus.CounterVec) *Stream {
+	return &Stream{moderator: moderator, flags: flags}
+}
+
+// Write buffers a chunk of generated text. It returns the text that has
+// passed moderation and can be sent to the client, and whether the response
+// has been flagged, in which case the stream must be cut off.
+func (s *Stream) Write(ctx context.Context, chunk string) (string, bool) {
+	if s.flagged {
+		return "", true
+	}
+	s.pending.WriteString(chunk)
+
+	window := s.pending.String()
+	cut := lastSentenceEnd(window)
+	if cut < 0 && len(window) < maxWindow {
+		return "", false
+	}
+	if cut < 0 {
+		cut = len(window)
+	}
+
+	ready := window[:cut]
+	s.pending.Reset()
+	s.pending.WriteString(window[cut:])
+	return s.moderate(ctx, ready)
+}
+
+// Flush moderates whatever is still buffered at the end of the response
+func (s *Stream) Flush(ctx context.Context) (string, bool) {
+	if s.flagged {
+		return "", true
+	}
+	rest := s.pending.String()
+	s.pending.Reset()
+	if strings.TrimSpace(rest) == "" {
+		return rest, false
+	}
+	return s.moderate(ctx, rest)
+}
+
+func (s *Stream) moderate(ctx context.Context, text string) (string, bool) {
+	if strings.TrimSpace(text) == "" {
+		return text, false
+	}
+
+	result, err := s.moderator.Moderate(ctx, text)
+	if err != nil {
+		// Fail open: a broken moderation endpoint shouldn't take chat down
+		log := logger.GetLogger()
+		log.Warn().Err(err).Msg("Output moderation failed, releasing text unmoderated")
+		return text, false
+	}
+	if !result.Flagged {
+		return text, false
+	}
+
+	s.flagged = true
+	categories := result.Categories
+	if len(categories) == 0 {
+		categories = []string{"unspecified"}
+	}
+	for _, category := range categories {
+		s.flags.WithLabelValues(category).Inc()
+	}
+	return "", true
+}
+
+// lastSentenceEnd returns the index just past the last sentence boundary in
+// text, or -1 if there is none
+func lastSentenceEnd(text string) int {
+	for i := len(text) - 1; i >= 0; i-- {
+		switch text[i] {
+		case '\n':
+			return i + 1
+		case '.', '!', '?':
+			// Only count punctuation followed by whitespace, so "3.14" or
+			// "e.g." mid-token don't split
+			if i+1 < len(text) && (text[i+1] == ' ' || text[i+1] == '\n') {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,75 @@
+package moderation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ajeetraina/aiwatch/pkg/guardrails"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// wordModerator flags any text containing word
+type wordModerator struct {
+	word  string
+	calls []string
+}
+
+func (m *wordModerator) Moderate(ctx context.Context, text string) (guardrails.ModerationResult, error) {
+	m.calls = append(m.calls, text)
+	if strings.Contains(text, m.word) {
+		return guardrails.ModerationResult{Flagged: true, Categories: []string{"violence"}}, nil
+	}
+	return guardrails.ModerationResult{}, nil
+}
+
+func newFlags() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{Name: "flags"}, []string{"category"})
+}
+
+func TestStreamReleasesModeratedSentences(t *testing.T) {
+	moderator := &wordModerator{word: "forbidden"}
+	stream := NewStream(moderator, newFlags())
+	ctx := context.Background()
+
+	var out strings.Builder
+	for _, chunk := range []string{"Hello", " there. How", " are you", "?"} {
+		text, flagged := stream.Write(ctx, chunk)
+		if flagged {
+			t.Fatalf("unexpected flag on %q", chunk)
+		}
+		out.WriteString(text)
+	}
+	if out.String() != "Hello there." {
+		t.Errorf("expected only the first sentence released, got %q", out.String())
+	}
+
+	rest, flagged := stream.Flush(ctx)
+	if flagged {
+		t.Fatal("unexpected flag on flush")
+	}
+	out.WriteString(rest)
+	if out.String() != "Hello there. How are you?" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	if len(moderator.calls) != 2 {
+		t.Errorf("expected one moderation call per sentence, got %q", moderator.calls)
+	}
+}
+
+func TestStreamStopsOnFlag(t *testing.T) {
+	flags := newFlags()
+	stream := NewStream(&wordModerator{word: "forbidden"}, flags)
+	ctx := context.Background()
+
+	if text, flagged := stream.Write(ctx, "This is forbidden. "); !flagged || text != "" {
+		t.Fatalf("expected the sentence to be held back and flagged, got %q, %v", text, flagged)
+	}
+	if _, flagged := stream.Write(ctx, "More text. "); !flagged {
+		t.Error("expected the stream to stay flagged")
+	}
+	if got := testutil.ToFloat64(flags.WithLabelValues("violence")); got != 1 {
+		t.Errorf("expected 1 violence flag, got %v", got)
+	}
+}
@@ -0,0 +1,347 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/sigv4"
+)
+
+// ErrNotFound is returned by Get for a missing object
+var ErrNotFound = errors.New("object not found")
+
+// Store is a flat namespace of objects, such as a directory or a bucket.
+// Names use "/" separators.
+type Store interface {
+	// Name identifies the store in logs and metric labels
+	Name() string
+	// Put writes data under name, replacing any earlier version
+	Put(ctx context.Context, name string, data []byte) error
+	// Get reads the object stored under name
+	Get(ctx context.Context, name string) ([]byte, error)
+	// List returns the names starting with prefix, sorted
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Lifecycler is implemented by stores that can expire objects themselves
+type Lifecycler interface {
+	// SetExpiration deletes objects under prefix days after they were
+	// written. It replaces the bucket's lifecycle configuration.
+	SetExpiration(ctx context.Context, prefix string, days int) error
+}
+
+// DirStore keeps objects as files under a directory
+type DirStore struct {
+	Dir string
+}
+
+// Name returns the store name
+func (s *DirStore) Name() string { return "file" }
+
+// Put writes the object atomically, so readers never see half of one
+func (s *DirStore) Put(ctx context.Context, name string, data []byte) error {
+	path := filepath.Join(s.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Get reads the object's file
+func (s *DirStore) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// List walks the directory for files whose names start with prefix
+func (s *DirStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(s.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Dir, path)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+// S3Store keeps objects in an S3 bucket, or any S3-compatible store such as
+// MinIO or Google Cloud Storage when Endpoint is set, signing requests with
+// AWS Signature V4
+type S3Store struct {
+	Bucket       string
+	Prefix       string
+	Region       string
+	Endpoint     string // e.g. http://minio:9000; uses path-style URLs
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+
+	// Provider names the store; "gcs" also marks the S3 lifecycle API as
+	// unavailable. Defaults to "s3".
+	Provider string
+}
+
+// Name returns the store name
+func (s *S3Store) Name() string {
+	if s.Provider != "" {
+		return s.Provider
+	}
+	return "s3"
+}
+
+// base returns the store prefix as a key prefix, "" or ending in "/"
+func (s *S3Store) base() string {
+	if prefix := strings.Trim(s.Prefix, "/"); prefix != "" {
+		return prefix + "/"
+	}
+	return ""
+}
+
+// key returns the object key for name under the store prefix
+func (s *S3Store) key(name string) string {
+	return s.base() + name
+}
+
+// url returns the URL of an object key, or of the bucket for an empty key
+func (s *S3Store) url(key string) string {
+	if s.Endpoint != "" {
+		return strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + escapePath(key)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Bucket, s.Region, escapePath(key))
+}
+
+// Put uploads the object with a PUT Object request
+func (s *S3Store) Put(ctx context.Context, name string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.url(s.key(name)), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType(name))
+	_, err = s.do(req, data)
+	return err
+}
+
+// Get downloads the object with a GET Object request
+func (s *S3Store) Get(ctx context.Context, name string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url(s.key(name)), nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.do(req, nil)
+}
+
+// List pages through ListObjectsV2 for the keys under prefix
+func (s *S3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	base := s.base()
+	var names []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {base + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url(""), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.URL.RawQuery = canonicalQuery(query)
+		data, err := s.do(req, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse %s listing: %w", s.Name(), err)
+		}
+		for _, object := range page.Contents {
+			names = append(names, strings.TrimPrefix(object.Key, base))
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// SetExpiration installs a bucket lifecycle rule expiring objects under the
+// store prefix plus prefix. Google Cloud Storage's XML API takes a different
+// schema, so there the rule has to be set on the bucket directly.
+func (s *S3Store) SetExpiration(ctx context.Context, prefix string, days int) error {
+	if s.Provider == "gcs" {
+		return fmt.Errorf("set a %d-day delete rule on gs://%s with gcloud; the lifecycle API is not S3-compatible", days, s.Bucket)
+	}
+	prefix = s.base() + prefix
+
+	type rule struct {
+		ID     string `xml:"ID"`
+		Prefix string `xml:"Filter>Prefix"`
+		Status string `xml:"Status"`
+		Days   int    `xml:"Expiration>Days"`
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LifecycleConfiguration"`
+		Rules   []rule   `xml:"Rule"`
+	}{Rules: []rule{{ID: "aiwatch-expire", Prefix: prefix, Status: "Enabled", Days: days}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.url(""), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.URL.RawQuery = "lifecycle="
+	sum := md5.Sum(body)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	req.Header.Set("Content-Type", "application/xml")
+	_, err = s.do(req, body)
+	return err
+}
+
+// do signs and sends req, returning the response body of a successful call
+func (s *S3Store) do(req *http.Request, body []byte) ([]byte, error) {
+	s.sign(req, body, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: time.Minute}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && req.Method == http.MethodGet && req.URL.RawQuery == "" {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s %s %s returned status %d: %s", s.Name(), req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// sign adds AWS Signature V4 headers for the s3 service
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	sigv4.Sign(req, body, "s3", s.Region, sigv4.Credentials{
+		AccessKey:    s.AccessKey,
+		SecretKey:    s.SecretKey,
+		SessionToken: s.SessionToken,
+	}, now)
+}
+
+// canonicalQuery encodes a query string the way Signature V4 expects:
+// sorted by key, with spaces as %20
+func canonicalQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// escapePath escapes each segment of an object key
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func contentType(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".csv"):
+		return "text/csv"
+	case strings.HasSuffix(name, ".json"):
+		return "application/json"
+	case strings.HasSuffix(name, ".jsonl"):
+		return "application/x-ndjson"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// New builds a store from a URL-style specification: "file:///dir",
+// "s3://bucket/prefix" or "gs://bucket/prefix". Credentials and region come
+// from the standard AWS_* environment variables; for Google Cloud Storage
+// these are an HMAC key of a service account.
+func New(spec string) (Store, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid object store %q: %w", spec, err)
+	}
+	switch scheme := strings.ToLower(u.Scheme); scheme {
+	case "file":
+		return &DirStore{Dir: u.Path}, nil
+	case "s3", "gs":
+		if u.Host == "" {
+			return nil, fmt.Errorf("object store %q needs a bucket", spec)
+		}
+		store := &S3Store{
+			Bucket:       u.Host,
+			Prefix:       strings.TrimPrefix(u.Path, "/"),
+			Region:       os.Getenv("AWS_REGION"),
+			Endpoint:     os.Getenv("AWS_ENDPOINT_URL_S3"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if store.Endpoint == "" {
+			store.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+		}
+		if scheme == "gs" {
+			store.Provider, store.Endpoint, store.Region = "gcs", "https://storage.googleapis.com", "auto"
+		}
+		if store.Region == "" {
+			store.Region = "us-east-1"
+		}
+		if store.AccessKey == "" || store.SecretKey == "" {
+			return nil, fmt.Errorf("object store %s needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY", spec)
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unsupported object store scheme %q", u.Scheme)
+	}
+}
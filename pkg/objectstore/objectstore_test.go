@@ -0,0 +1,185 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ajeetraina/aiwatch/pkg/sigv4"
+)
+
+// fakeS3 serves path-style PUT, GET and ListObjectsV2 from memory, two keys
+// per listing page
+type fakeS3 struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	lifecycle string
+	auth      []string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get("X-Amz-Content-Sha256") != sigv4.PayloadHash(body) {
+		http.Error(w, "payload hash mismatch", http.StatusBadRequest)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch {
+	case r.Method == http.MethodPut && r.URL.Query().Has("lifecycle"):
+		f.lifecycle = string(body)
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case r.URL.Query().Get("list-type") == "2":
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		start := 0
+		if token := r.URL.Query().Get("continuation-token"); token != "" {
+			fmt.Sscan(token, &start)
+		}
+		end := start + 2
+		truncated := end < len(keys)
+		if !truncated {
+			end = len(keys)
+		}
+		fmt.Fprint(w, "<ListBucketResult>")
+		for _, k := range keys[start:end] {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", k)
+		}
+		fmt.Fprintf(w, "<IsTruncated>%t</IsTruncated><NextContinuationToken>%d</NextContinuationToken></ListBucketResult>", truncated, end)
+	default:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	}
+}
+
+func TestS3StoreRoundTrip(t *testing.T) {
+	fake, server := newFakeS3(t)
+	store := &S3Store{Bucket: "bucket", Prefix: "aiwatch/", Region: "eu-west-1", Endpoint: server.URL, AccessKey: "AKID", SecretKey: "secret"}
+	ctx := context.Background()
+
+	for _, name := range []string{"a/1.jsonl", "a/2.jsonl", "a/3.jsonl", "b/1.jsonl"} {
+		if err := store.Put(ctx, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := fake.objects["aiwatch/a/1.jsonl"]; !ok {
+		t.Errorf("objects = %v, want keys under the prefix", fake.objects)
+	}
+	if auth := fake.auth[0]; !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/s3/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, Signature=") {
+		t.Errorf("authorization = %q", auth)
+	}
+
+	names, err := store.List(ctx, "a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a/1.jsonl", "a/2.jsonl", "a/3.jsonl"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("list = %v, want %v across pages", names, want)
+	}
+
+	data, err := store.Get(ctx, "b/1.jsonl")
+	if err != nil || string(data) != "b/1.jsonl" {
+		t.Errorf("get = %q, %v", data, err)
+	}
+	if _, err := store.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing object error = %v", err)
+	}
+}
+
+func TestS3StoreSetsExpiration(t *testing.T) {
+	fake, server := newFakeS3(t)
+	store := &S3Store{Bucket: "bucket", Prefix: "aiwatch", Region: "us-east-1", Endpoint: server.URL, AccessKey: "AKID", SecretKey: "secret"}
+	if err := store.SetExpiration(context.Background(), "transcripts/", 400); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<Prefix>aiwatch/transcripts/</Prefix>", "<Status>Enabled</Status>", "<Expiration><Days>400</Days></Expiration>"} {
+		if !strings.Contains(fake.lifecycle, want) {
+			t.Errorf("lifecycle %s is missing %s", fake.lifecycle, want)
+		}
+	}
+	if !strings.Contains(fake.auth[0], "SignedHeaders=content-md5;content-type;host;") {
+		t.Errorf("authorization = %q", fake.auth[0])
+	}
+
+	gcs := &S3Store{Bucket: "bucket", Provider: "gcs"}
+	if err := gcs.SetExpiration(context.Background(), "transcripts/", 400); err == nil {
+		t.Error("gcs lifecycle should be refused")
+	}
+}
+
+func TestDirStoreListsNestedNames(t *testing.T) {
+	store := &DirStore{Dir: t.TempDir()}
+	ctx := context.Background()
+	for _, name := range []string{"t/2026/10/17/a.jsonl", "t/2026/10/18/b.jsonl", "other.json"} {
+		if err := store.Put(ctx, name, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, err := store.List(ctx, "t/2026/10/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"t/2026/10/17/a.jsonl", "t/2026/10/18/b.jsonl"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("list = %v, want %v", names, want)
+	}
+	if _, err := store.Get(ctx, "t/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing object error = %v", err)
+	}
+	if names, err := (&DirStore{Dir: store.Dir + "/absent"}).List(ctx, ""); err != nil || len(names) != 0 {
+		t.Errorf("list of a missing directory = %v, %v", names, err)
+	}
+}
+
+func TestNewParsesSpecs(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_ENDPOINT_URL", "")
+	t.Setenv("AWS_ENDPOINT_URL_S3", "")
+
+	store, err := New("s3://finance/reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s3 := store.(*S3Store); s3.Bucket != "finance" || s3.Prefix != "reports" || s3.Region != "us-east-1" || s3.Name() != "s3" {
+		t.Errorf("s3 store = %+v", s3)
+	}
+	store, err = New("gs://compliance/transcripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gcs := store.(*S3Store); gcs.Endpoint != "https://storage.googleapis.com" || gcs.Name() != "gcs" {
+		t.Errorf("gcs store = %+v", gcs)
+	}
+	if _, err := New("ftp://host/dir"); err == nil {
+		t.Error("unsupported scheme accepted")
+	}
+}
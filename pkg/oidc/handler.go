@@ -0,0 +1,291 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Cookies set by the login flow
+const (
+	sessionCookie = "aiwatch_sso"
+	loginCookie   = "aiwatch_sso_login"
+)
+
+// loginTimeout bounds the round trip through the identity provider
+const loginTimeout = 10 * time.Minute
+
+// loginState is what the callback needs to finish a login, kept in a signed
+// cookie so any instance can complete it
+type loginState struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Redirect string    `json:"redirect"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Handler serves the login flow:
+//
+//	GET  /auth/login     redirects to the provider; ?redirect= is where to return
+//	GET  /auth/callback  finishes the login and sets the session cookie
+//	POST /auth/logout    clears the session cookie
+//	GET  /auth/me        the signed-in user
+func (a *Authenticator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /auth/login", a.handleLogin)
+	mux.HandleFunc("GET /auth/callback", a.handleCallback)
+	mux.HandleFunc("POST /auth/logout", a.handleLogout)
+	mux.HandleFunc("GET /auth/me", func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.Authenticate(r)
+		if err != nil {
+			apierror.Write(w, r, apierror.Unauthorized, "Sign-in required")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(id)
+	})
+	return mux
+}
+
+func (a *Authenticator) handleLogin(w http.ResponseWriter, r *http.Request) {
+	ep, _, err := a.discover(r.Context())
+	if err != nil {
+		a.loginFailed(w, r, "discovery", err)
+		return
+	}
+
+	state := loginState{
+		State:    randomString(16),
+		Nonce:    randomString(16),
+		Verifier: randomString(32),
+		Redirect: localRedirect(r.URL.Query().Get("redirect")),
+		Expires:  a.now().Add(loginTimeout),
+	}
+	sealed, err := a.seal(state)
+	if err != nil {
+		a.loginFailed(w, r, "internal", err)
+		return
+	}
+	a.setCookie(w, loginCookie, sealed, loginTimeout)
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {a.config.ClientID},
+		"redirect_uri":          {a.config.RedirectURL},
+		"scope":                 {strings.Join(a.config.Scopes, " ")},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	target := ep.Authorization
+	if strings.Contains(target, "?") {
+		target += "&" + query.Encode()
+	} else {
+		target += "?" + query.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func (a *Authenticator) handleCallback(w http.ResponseWriter, r *http.Request) {
+	if msg := r.URL.Query().Get("error"); msg != "" {
+		a.loginFailed(w, r, "denied", fmt.Errorf("%s: %s", msg, r.URL.Query().Get("error_description")))
+		return
+	}
+
+	var state loginState
+	cookie, err := r.Cookie(loginCookie)
+	if err == nil {
+		err = a.open(cookie.Value, &state)
+	}
+	if err != nil || a.now().After(state.Expires) || state.State != r.URL.Query().Get("state") {
+		a.loginFailed(w, r, "invalid_state", errors.New("login state is missing, expired or does not match"))
+		return
+	}
+	a.setCookie(w, loginCookie, "", -1)
+
+	token, err := a.exchange(r, r.URL.Query().Get("code"), state.Verifier)
+	if err != nil {
+		a.loginFailed(w, r, "exchange", err)
+		return
+	}
+	id, c, err := a.identity(r.Context(), token, a.config.ClientID)
+	if err == nil && c.Nonce != state.Nonce {
+		err = errors.New("ID token nonce does not match")
+	}
+	if err != nil {
+		a.loginFailed(w, r, "invalid_token", err)
+		return
+	}
+	if len(id.Roles) == 0 {
+		count(a.metrics.Logins, "forbidden")
+		apierror.Write(w, r, apierror.Forbidden, "Your account is not in a group with access to aiwatch")
+		return
+	}
+
+	// The session lasts SessionTTL, not as long as the provider's ID token
+	id.Expires = a.now().Add(a.config.SessionTTL)
+	sealed, err := a.seal(id)
+	if err != nil {
+		a.loginFailed(w, r, "internal", err)
+		return
+	}
+	a.setCookie(w, sessionCookie, sealed, a.config.SessionTTL)
+	count(a.metrics.Logins, "success")
+
+	log := logger.GetLogger()
+	log.Info().Str("subject", id.Subject).Str("email", id.Email).Strs("roles", id.Roles).Msg("User signed in")
+	http.Redirect(w, r, state.Redirect, http.StatusFound)
+}
+
+// exchange redeems an authorization code for an ID token
+func (a *Authenticator) exchange(r *http.Request, code, verifier string) (string, error) {
+	ep, _, err := a.discover(r.Context())
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {a.config.RedirectURL},
+		"client_id":     {a.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, ep.Token, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if a.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(a.config.ClientID), url.QueryEscape(a.config.ClientSecret))
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", fmt.Errorf("token endpoint returned %s: %s %s", resp.Status, body.Error, body.Description)
+	}
+	return body.IDToken, nil
+}
+
+func (a *Authenticator) handleLogout(w http.ResponseWriter, r *http.Request) {
+	a.setCookie(w, sessionCookie, "", -1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loginFailed logs and reports a failed login
+func (a *Authenticator) loginFailed(w http.ResponseWriter, r *http.Request, result string, err error) {
+	count(a.metrics.Logins, result)
+	log := logger.GetLogger()
+	log.Warn().Err(err).Str("result", result).Msg("Sign-in failed")
+	apierror.Write(w, r, apierror.Unauthorized, "Sign-in failed")
+}
+
+// Middleware requires a signed-in user with a role on everything outside
+// the public paths. Browsers are sent to the login page; API clients get 401.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions || a.public(r.URL.Path) || (a.Skip != nil && a.Skip(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id, err := a.Authenticate(r)
+		if err != nil {
+			reason := "invalid_token"
+			if errors.Is(err, errNoCredentials) {
+				reason = "no_credentials"
+			}
+			count(a.metrics.Rejections, reason)
+			if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/auth/login?redirect="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="aiwatch"`)
+			apierror.Write(w, r, apierror.Unauthorized, "Sign-in required")
+			return
+		}
+		if len(id.Roles) == 0 {
+			count(a.metrics.Rejections, "no_role")
+			apierror.Write(w, r, apierror.Forbidden, "Your account is not in a group with access to aiwatch")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, id)))
+	})
+}
+
+// IsAdmin reports whether the request comes from a signed-in admin
+func IsAdmin(r *http.Request) bool {
+	id, ok := FromContext(r.Context())
+	return ok && id.HasRole(RoleAdmin)
+}
+
+// public reports whether path is served without signing in
+func (a *Authenticator) public(path string) bool {
+	if strings.HasPrefix(path, "/auth/") {
+		return true
+	}
+	for _, public := range a.config.PublicPaths {
+		if public == path || (strings.HasSuffix(public, "/") && strings.HasPrefix(path, public)) {
+			return true
+		}
+	}
+	return false
+}
+
+// setCookie sets or, with a negative maxAge, clears a cookie
+func (a *Authenticator) setCookie(w http.ResponseWriter, name, value string, maxAge time.Duration) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(a.config.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(maxAge.Seconds()),
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// count increments a counter if it is configured
+func count(counter *prometheus.CounterVec, label string) {
+	if counter != nil {
+		counter.WithLabelValues(label).Inc()
+	}
+}
+
+// localRedirect only allows returning to a path on this server
+func localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/dashboard/"
+	}
+	return target
+}
@@ -0,0 +1,270 @@
+// Package oidc signs browser users in through an OpenID Connect identity
+// provider with the authorization code flow and PKCE, keeps them signed in
+// with a signed session cookie, and validates the provider's bearer tokens
+// on API calls. Provider groups map to aiwatch roles.
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Roles a user can hold. Admins can also use the operational endpoints.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Config configures the identity provider and how its users map to roles
+type Config struct {
+	Issuer       string // e.g. https://login.example.com/realms/main
+	ClientID     string
+	ClientSecret string // optional; PKCE protects public clients
+	RedirectURL  string // this server's /auth/callback URL, as registered
+	Scopes       []string
+	// Audience is the aud bearer tokens must carry; defaults to ClientID
+	Audience    string
+	GroupsClaim string            // default "groups"
+	Roles       map[string]string // provider group to role
+	// DefaultRole is given to users in no mapped group; empty denies them
+	DefaultRole  string
+	// CookieSecret signs session and login cookies; every replica must
+	// share it, and it must outlive restarts, or users are signed out
+	CookieSecret []byte
+	SessionTTL   time.Duration
+	// PublicPaths are served without signing in; those ending in / are prefixes
+	PublicPaths []string
+}
+
+// ParseRoles parses "group=role,group=role" into a group to role map
+func ParseRoles(value string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=")
+		role = strings.TrimSpace(role)
+		if !ok || strings.TrimSpace(group) == "" || (role != RoleUser && role != RoleAdmin) {
+			return nil, fmt.Errorf("invalid role mapping %q, want group=user or group=admin", pair)
+		}
+		roles[strings.TrimSpace(group)] = role
+	}
+	return roles, nil
+}
+
+// Identity is a signed-in user
+type Identity struct {
+	Subject string    `json:"sub"`
+	Email   string    `json:"email,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Roles   []string  `json:"roles"`
+	Expires time.Time `json:"expires"`
+}
+
+// HasRole reports whether the user holds role; admins hold every role
+func (id Identity) HasRole(role string) bool {
+	return slices.Contains(id.Roles, role) || slices.Contains(id.Roles, RoleAdmin)
+}
+
+type contextKey struct{}
+
+// FromContext returns the user the middleware authenticated
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok
+}
+
+// Metrics holds the collectors the authenticator reports to
+type Metrics struct {
+	Logins     *prometheus.CounterVec // labels: result
+	Rejections *prometheus.CounterVec // labels: reason
+}
+
+// endpoints are the provider URLs found through discovery
+type endpoints struct {
+	Issuer        string `json:"issuer"`
+	Authorization string `json:"authorization_endpoint"`
+	Token         string `json:"token_endpoint"`
+	JWKS          string `json:"jwks_uri"`
+}
+
+// Authenticator runs the login flow and authenticates requests
+type Authenticator struct {
+	config  Config
+	metrics Metrics
+	client  *http.Client
+	now     func() time.Time
+
+	// Skip, if set, lets requests it returns true for through without
+	// signing in, such as operators presenting the admin token
+	Skip func(*http.Request) bool
+
+	mu        sync.Mutex
+	endpoints *endpoints
+	keys      *keySet
+}
+
+// minCookieSecret is the shortest cookie secret accepted, in bytes
+const minCookieSecret = 32
+
+// New creates an authenticator. The provider is discovered on first use, so
+// aiwatch starts even while it is unreachable.
+func New(config Config, metrics Metrics) (*Authenticator, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("issuer, client ID and redirect URL are required")
+	}
+	if len(config.CookieSecret) < minCookieSecret {
+		return nil, fmt.Errorf("a cookie secret of at least %d bytes is required", minCookieSecret)
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+	if config.Audience == "" {
+		config.Audience = config.ClientID
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	if config.SessionTTL <= 0 {
+		config.SessionTTL = 8 * time.Hour
+	}
+	return &Authenticator{
+		config:  config,
+		metrics: metrics,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		now:     time.Now,
+	}, nil
+}
+
+// discover fetches the provider configuration once
+func (a *Authenticator) discover(ctx context.Context) (*endpoints, *keySet, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.endpoints != nil {
+		return a.endpoints, a.keys, nil
+	}
+
+	var ep endpoints
+	if err := getJSON(ctx, a.client, a.config.Issuer+"/.well-known/openid-configuration", &ep); err != nil {
+		return nil, nil, fmt.Errorf("failed to discover identity provider: %w", err)
+	}
+	if strings.TrimSuffix(ep.Issuer, "/") != a.config.Issuer {
+		return nil, nil, fmt.Errorf("identity provider reports issuer %q, want %q", ep.Issuer, a.config.Issuer)
+	}
+	if ep.Authorization == "" || ep.Token == "" || ep.JWKS == "" {
+		return nil, nil, errors.New("identity provider configuration is missing endpoints")
+	}
+	a.endpoints = &ep
+	a.keys = &keySet{url: ep.JWKS, client: a.client}
+	return a.endpoints, a.keys, nil
+}
+
+// identity verifies a token issued for audience and maps it to a user
+func (a *Authenticator) identity(ctx context.Context, token, audience string) (Identity, claims, error) {
+	_, keys, err := a.discover(ctx)
+	if err != nil {
+		return Identity{}, claims{}, err
+	}
+	c, err := keys.verify(ctx, token)
+	if err != nil {
+		return Identity{}, c, err
+	}
+	now := a.now()
+	if err := c.validate(a.config.Issuer, audience, now); err != nil {
+		return Identity{}, c, err
+	}
+
+	id := Identity{Subject: c.Subject, Email: c.Email, Name: c.Name, Expires: time.Unix(c.Expiry, 0)}
+	for _, group := range c.groups(a.config.GroupsClaim) {
+		if role, ok := a.config.Roles[group]; ok && !slices.Contains(id.Roles, role) {
+			id.Roles = append(id.Roles, role)
+		}
+	}
+	if len(id.Roles) == 0 && a.config.DefaultRole != "" {
+		id.Roles = []string{a.config.DefaultRole}
+	}
+	return id, c, nil
+}
+
+// Authenticate returns the user behind a request's session cookie or bearer token
+func (a *Authenticator) Authenticate(r *http.Request) (Identity, error) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		var id Identity
+		if err := a.open(cookie.Value, &id); err == nil && a.now().Before(id.Expires) {
+			return id, nil
+		}
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		id, _, err := a.identity(r.Context(), strings.TrimPrefix(auth, "Bearer "), a.config.Audience)
+		return id, err
+	}
+	return Identity{}, errNoCredentials
+}
+
+var errNoCredentials = errors.New("sign-in required")
+
+// seal signs v for a cookie value
+func (a *Authenticator) seal(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + a.mac(payload), nil
+}
+
+// open checks a sealed cookie value and decodes it into v
+func (a *Authenticator) open(value string, v interface{}) error {
+	payload, mac, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(a.mac(payload))) {
+		return errors.New("invalid cookie signature")
+	}
+	return decodeSegment(payload, v)
+}
+
+func (a *Authenticator) mac(payload string) string {
+	h := hmac.New(sha256.New, a.config.CookieSecret)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// randomString returns n random bytes, base64url encoded
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// getJSON fetches and decodes a JSON document
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned status %d: %s", url, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,214 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProvider is an identity provider issuing RS256 tokens
+type fakeProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	challenge string // from the authorization request
+	nonce     string
+	groups    []string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if r.Form.Get("code") != "the-code" || base64.RawURLEncoding.EncodeToString(verifier[:]) != p.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.token(t, "aiwatch", p.nonce, time.Hour)})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// token issues a signed token for audience
+func (p *fakeProvider) token(t *testing.T, audience, nonce string, ttl time.Duration) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+	body, _ := json.Marshal(map[string]interface{}{
+		"iss": p.URL, "sub": "u-1", "aud": audience, "email": "dev@example.com",
+		"exp": time.Now().Add(ttl).Unix(), "nonce": nonce, "groups": p.groups,
+	})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newTestAuthenticator(t *testing.T, p *fakeProvider) *Authenticator {
+	t.Helper()
+	a, err := New(Config{
+		Issuer:       p.URL,
+		ClientID:     "aiwatch",
+		RedirectURL:  "http://aiwatch.test/auth/callback",
+		Roles:        map[string]string{"ops": RoleAdmin, "staff": RoleUser},
+		PublicPaths:  []string{"/health"},
+		CookieSecret: []byte(strings.Repeat("s", 32)),
+	}, Metrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestLoginWithPKCE(t *testing.T) {
+	p := newFakeProvider(t)
+	p.groups = []string{"ops"}
+	a := newTestAuthenticator(t, p)
+	handler := a.Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/login?redirect=/dashboard/?tab=models", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login = %d", rec.Code)
+	}
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	query := location.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "aiwatch" {
+		t.Errorf("authorization request = %s", location)
+	}
+	p.challenge, p.nonce = query.Get("code_challenge"), query.Get("nonce")
+
+	callback := httptest.NewRequest(http.MethodGet, "/auth/callback?code=the-code&state="+query.Get("state"), nil)
+	for _, c := range rec.Result().Cookies() {
+		callback.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, callback)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/dashboard/?tab=models" {
+		t.Fatalf("callback = %d to %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+
+	// The session cookie signs the user in
+	me := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookie {
+			me.AddCookie(c)
+		}
+	}
+	id, err := a.Authenticate(me)
+	if err != nil || id.Email != "dev@example.com" || !id.HasRole(RoleAdmin) {
+		t.Errorf("identity = %+v, %v", id, err)
+	}
+
+	// A replayed callback without the login cookie is refused
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/callback?code=the-code&state="+query.Get("state"), nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("callback without state cookie = %d", rec.Code)
+	}
+}
+
+func TestMiddlewareValidatesBearerTokens(t *testing.T) {
+	p := newFakeProvider(t)
+	a := newTestAuthenticator(t, p)
+	var seen Identity
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = FromContext(r.Context())
+	}))
+	call := func(token, accept, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	p.groups = []string{"staff"}
+	if rec := call(p.token(t, "aiwatch", "", time.Hour), "", "/chat"); rec.Code != http.StatusOK || !seen.HasRole(RoleUser) || seen.HasRole(RoleAdmin) {
+		t.Errorf("valid token = %d, %+v", rec.Code, seen)
+	}
+
+	cases := map[string]struct {
+		token, accept, path string
+		want                int
+	}{
+		"expired":        {p.token(t, "aiwatch", "", -time.Hour), "", "/chat", http.StatusUnauthorized},
+		"other audience": {p.token(t, "other-app", "", time.Hour), "", "/chat", http.StatusUnauthorized},
+		"forged":         {p.token(t, "aiwatch", "", time.Hour) + "x", "", "/chat", http.StatusUnauthorized},
+		"browser":        {"", "text/html", "/dashboard/", http.StatusFound},
+		"public":         {"", "", "/health", http.StatusOK},
+	}
+	for name, tc := range cases {
+		if rec := call(tc.token, tc.accept, tc.path); rec.Code != tc.want {
+			t.Errorf("%s = %d, want %d", name, rec.Code, tc.want)
+		}
+	}
+
+	p.groups = []string{"contractors"}
+	if rec := call(p.token(t, "aiwatch", "", time.Hour), "", "/chat"); rec.Code != http.StatusForbidden {
+		t.Errorf("user without a role = %d", rec.Code)
+	}
+}
+
+func TestParseRoles(t *testing.T) {
+	roles, err := ParseRoles("ops=admin, staff=user")
+	if err != nil || roles["ops"] != RoleAdmin || roles["staff"] != RoleUser {
+		t.Errorf("roles = %v, %v", roles, err)
+	}
+	if _, err := ParseRoles("ops=root"); err == nil {
+		t.Error("unknown role accepted")
+	}
+	if !strings.HasPrefix(localRedirect("//evil.example.com"), "/dashboard") {
+		t.Error("open redirect allowed")
+	}
+}
+
+func TestNewRequiresCookieSecret(t *testing.T) {
+	config := Config{Issuer: "https://idp.test", ClientID: "aiwatch", RedirectURL: "http://aiwatch.test/auth/callback"}
+	for _, secret := range []string{"", "too-short"} {
+		config.CookieSecret = []byte(secret)
+		if _, err := New(config, Metrics{}); err == nil {
+			t.Errorf("expected a cookie secret of %d bytes to be refused", len(secret))
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/models"
+)
+
+// ServerURL returns the server root for an OpenAI-compatible base URL,
+// where Ollama serves its native /api endpoints
+func ServerURL(baseURL string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/v1")
+}
+
+// Client talks to Ollama's native API
+type Client struct {
+	url    string
+	client *http.Client
+}
+
+// New creates a client for the Ollama server behind baseURL
+func New(baseURL string) *Client {
+	return &Client{
+		url:    ServerURL(baseURL),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// tagsResponse is the body of GET /api/tags
+type tagsResponse struct {
+	Models []struct {
+		Name       string    `json:"name"`
+		Model      string    `json:"model"`
+		ModifiedAt time.Time `json:"modified_at"`
+		Size       int64     `json:"size"`
+		Digest     string    `json:"digest"`
+		Details    struct {
+			Family            string `json:"family"`
+			ParameterSize     string `json:"parameter_size"`
+			QuantizationLevel string `json:"quantization_level"`
+		} `json:"details"`
+	} `json:"models"`
+}
+
+// ListModels lists the models pulled into the server, in the same shape as
+// docker model ls, so it can stand in for models.GetAvailableModels
+func (c *Client) ListModels() ([]models.Model, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ollama models: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s/api/tags returned status %d", c.url, resp.StatusCode)
+	}
+
+	var tags tagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("invalid /api/tags response: %w", err)
+	}
+	list := make([]models.Model, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		name := m.Name
+		if name == "" {
+			name = m.Model
+		}
+		digest := strings.TrimPrefix(m.Digest, "sha256:")
+		if len(digest) > 12 {
+			digest = digest[:12]
+		}
+		model := models.Model{
+			Name:         name,
+			Parameters:   m.Details.ParameterSize,
+			Quantization: m.Details.QuantizationLevel,
+			Architecture: m.Details.Family,
+			ModelID:      digest,
+			Size:         formatSize(m.Size),
+		}
+		if !m.ModifiedAt.IsZero() {
+			model.Created = m.ModifiedAt.Format("2006-01-02")
+		}
+		list = append(list, model)
+	}
+	return list, nil
+}
+
+// Running is a model loaded into memory, from GET /api/ps
+type Running struct {
+	Name      string
+	SizeBytes int64 // total memory held, KV cache included
+	VRAMBytes int64
+}
+
+// Running lists the models currently loaded and the memory they hold
+func (c *Client) Running(ctx context.Context) ([]Running, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/api/ps", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s/api/ps returned status %d", c.url, resp.StatusCode)
+	}
+
+	var ps struct {
+		Models []struct {
+			Name     string `json:"name"`
+			Size     int64  `json:"size"`
+			SizeVRAM int64  `json:"size_vram"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ps); err != nil {
+		return nil, fmt.Errorf("invalid /api/ps response: %w", err)
+	}
+	running := make([]Running, len(ps.Models))
+	for i, m := range ps.Models {
+		running[i] = Running{Name: m.Name, SizeBytes: m.Size, VRAMBytes: m.SizeVRAM}
+	}
+	return running, nil
+}
+
+// formatSize renders a byte count the way docker model ls does
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value, exp := float64(bytes)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", value, "KMGT"[exp])
+}
+
+// Timings is the generation accounting Ollama attaches to a final response
+type Timings struct {
+	PromptEvalCount    int
+	PromptEvalDuration time.Duration
+	EvalCount          int
+	EvalDuration       time.Duration
+}
+
+// TokensPerSecond is the generation throughput measured by the server
+func (t Timings) TokensPerSecond() float64 {
+	if t.EvalDuration <= 0 {
+		return 0
+	}
+	return float64(t.EvalCount) / t.EvalDuration.Seconds()
+}
+
+// PromptTokensPerSecond is the prompt evaluation throughput
+func (t Timings) PromptTokensPerSecond() float64 {
+	if t.PromptEvalDuration <= 0 {
+		return 0
+	}
+	return float64(t.PromptEvalCount) / t.PromptEvalDuration.Seconds()
+}
+
+// ParseTimings reads eval_count and eval_duration (in nanoseconds) from a
+// response or stream chunk. It reports false when the chunk carries none.
+func ParseTimings(raw string) (Timings, bool) {
+	if !strings.Contains(raw, `"eval_count"`) {
+		return Timings{}, false
+	}
+	var fields struct {
+		PromptEvalCount    int   `json:"prompt_eval_count"`
+		PromptEvalDuration int64 `json:"prompt_eval_duration"`
+		EvalCount          int   `json:"eval_count"`
+		EvalDuration       int64 `json:"eval_duration"`
+	}
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil || fields.EvalDuration <= 0 {
+		return Timings{}, false
+	}
+	return Timings{
+		PromptEvalCount:    fields.PromptEvalCount,
+		PromptEvalDuration: time.Duration(fields.PromptEvalDuration),
+		EvalCount:          fields.EvalCount,
+		EvalDuration:       time.Duration(fields.EvalDuration),
+	}, true
+}
@@ -0,0 +1,65 @@
+package ollama
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestListModelsReadsTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"models":[{"name":"llama3.2:latest","model":"llama3.2:latest",` +
+			`"modified_at":"2025-05-04T17:37:44.706015396-07:00","size":2019393189,` +
+			`"digest":"a80c4f17acd55265feec403c7aef86be0c25983ab279d83f3bcd3abbcb5b8b72",` +
+			`"details":{"format":"gguf","family":"llama","parameter_size":"3.2B","quantization_level":"Q4_K_M"}}]}`))
+	}))
+	defer server.Close()
+
+	list, err := New(server.URL + "/v1/").ListModels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("got %d models, want 1", len(list))
+	}
+	m := list[0]
+	if m.Name != "llama3.2:latest" || m.Parameters != "3.2B" || m.Quantization != "Q4_K_M" ||
+		m.Architecture != "llama" || m.ModelID != "a80c4f17acd5" || m.Size != "1.88 GiB" || m.Created != "2025-05-04" {
+		t.Errorf("unexpected model %+v", m)
+	}
+}
+
+func TestListModelsReportsServerErrors(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if _, err := New(server.URL).ListModels(); err == nil {
+		t.Error("expected an error for a missing /api/tags endpoint")
+	}
+}
+
+func TestParseTimings(t *testing.T) {
+	timings, ok := ParseTimings(`{"id":"chatcmpl-1","choices":[],"prompt_eval_count":26,` +
+		`"prompt_eval_duration":130000000,"eval_count":290,"eval_duration":4000000000}`)
+	if !ok {
+		t.Fatal("expected timings")
+	}
+	if timings.EvalCount != 290 || timings.EvalDuration != 4*time.Second {
+		t.Errorf("unexpected timings %+v", timings)
+	}
+	if rate := timings.TokensPerSecond(); rate != 72.5 {
+		t.Errorf("tokens per second = %v, want 72.5", rate)
+	}
+	if rate := timings.PromptTokensPerSecond(); rate != 200 {
+		t.Errorf("prompt tokens per second = %v, want 200", rate)
+	}
+
+	if _, ok := ParseTimings(`{"id":"chatcmpl-1","choices":[{"delta":{"content":"hi"}}]}`); ok {
+		t.Error("chunks without eval fields should not report timings")
+	}
+}
@@ -0,0 +1,178 @@
+// Package pacing caps how fast streamed output reaches clients, so one
+// fast consumer cannot hog a shared backend and slower models can be
+// simulated for UX testing.
+package pacing
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Config holds the output rate caps in tokens per second. Zero means no cap.
+type Config struct {
+	Default float64
+	Classes map[string]float64 // priority class: cap, overriding Default
+}
+
+// ParseClasses parses "class=rate,class=rate" into a class to cap map
+func ParseClasses(value string) (map[string]float64, error) {
+	classes := make(map[string]float64)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		class, rate, ok := strings.Cut(pair, "=")
+		limit, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if !ok || strings.TrimSpace(class) == "" || err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid output rate %q, want class=tokens_per_second", pair)
+		}
+		classes[strings.ToLower(strings.TrimSpace(class))] = limit
+	}
+	return classes, nil
+}
+
+// Metrics holds the collectors the limiter reports to
+type Metrics struct {
+	Configured *prometheus.GaugeVec     // labels: class
+	Actual     *prometheus.HistogramVec // labels: class
+	Delay      *prometheus.CounterVec   // labels: class
+}
+
+// Limiter hands out pacers for the cap that applies to each request
+type Limiter struct {
+	config  Config
+	metrics Metrics
+}
+
+// New creates a limiter and publishes its configured caps
+func New(config Config, metrics Metrics) *Limiter {
+	if metrics.Configured != nil {
+		metrics.Configured.WithLabelValues("default").Set(config.Default)
+		for class, rate := range config.Classes {
+			metrics.Configured.WithLabelValues(class).Set(rate)
+		}
+	}
+	return &Limiter{config: config, metrics: metrics}
+}
+
+// Limit returns the cap for class. A positive requested rate can lower it
+// but never raise it.
+func (l *Limiter) Limit(class string, requested float64) float64 {
+	limit := l.config.Default
+	if rate, ok := l.config.Classes[class]; ok {
+		limit = rate
+	}
+	if requested > 0 && (limit <= 0 || requested < limit) {
+		limit = requested
+	}
+	return limit
+}
+
+// Pacer returns a pacer for a request of class, or nil when it is uncapped
+func (l *Limiter) Pacer(class string, requested float64) *Pacer {
+	limit := l.Limit(class, requested)
+	if limit <= 0 {
+		return nil
+	}
+	if class == "" {
+		class = "default"
+	}
+	return &Pacer{
+		limit:   limit,
+		class:   class,
+		metrics: l.metrics,
+		now:     time.Now,
+		sleep:   sleep,
+	}
+}
+
+// Pacer spaces one response's writes so they average at most limit tokens
+// per second. A slow upstream earns no credit for a later burst.
+type Pacer struct {
+	limit   float64
+	class   string
+	metrics Metrics
+	now     func() time.Time
+	sleep   func(context.Context, time.Duration) error
+
+	first, last time.Time
+	next        time.Time
+	tokens      int
+	firstTokens int // written at the start of the window
+	delay       time.Duration
+}
+
+// Limit returns the pacer's cap in tokens per second
+func (p *Pacer) Limit() float64 {
+	return p.limit
+}
+
+// Wait blocks until the next write of tokens may go out, or ctx is done
+func (p *Pacer) Wait(ctx context.Context, tokens int) error {
+	if tokens <= 0 {
+		return nil
+	}
+	now := p.now()
+	if p.first.IsZero() {
+		p.first, p.next = now, now
+		p.firstTokens = tokens
+	}
+	if wait := p.next.Sub(now); wait > 0 {
+		if err := p.sleep(ctx, wait); err != nil {
+			return err
+		}
+		p.delay += wait
+		now = p.next
+	}
+	if p.next.Before(now) {
+		p.next = now
+	}
+	p.next = p.next.Add(time.Duration(float64(tokens) / p.limit * float64(time.Second)))
+	p.last = now
+	p.tokens += tokens
+	return nil
+}
+
+// Rate returns the rate output was actually delivered at, in tokens per
+// second, measured from the first write to the last
+func (p *Pacer) Rate() float64 {
+	elapsed := p.last.Sub(p.first).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.tokens-p.firstTokens) / elapsed
+}
+
+// Delay returns the total time the pacer held output back
+func (p *Pacer) Delay() time.Duration {
+	return p.delay
+}
+
+// Done records the response's actual rate and added delay
+func (p *Pacer) Done() {
+	if p.metrics.Actual != nil {
+		if rate := p.Rate(); rate > 0 {
+			p.metrics.Actual.WithLabelValues(p.class).Observe(rate)
+		}
+	}
+	if p.metrics.Delay != nil {
+		p.metrics.Delay.WithLabelValues(p.class).Add(p.delay.Seconds())
+	}
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,78 @@
+package pacing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLimitByClassAndRequest(t *testing.T) {
+	classes, err := ParseClasses("batch=10, Interactive=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(Config{Default: 50, Classes: classes}, Metrics{})
+	for _, tc := range []struct {
+		class     string
+		requested float64
+		want      float64
+	}{
+		{"", 0, 50},
+		{"batch", 0, 10},
+		{"batch", 100, 10}, // a request cannot raise its cap
+		{"batch", 5, 5},
+		{"interactive", 0, 0},
+		{"interactive", 20, 20},
+	} {
+		if got := l.Limit(tc.class, tc.requested); got != tc.want {
+			t.Errorf("Limit(%q, %v) = %v, want %v", tc.class, tc.requested, got, tc.want)
+		}
+	}
+	if l.Pacer("interactive", 0) != nil {
+		t.Error("uncapped class got a pacer")
+	}
+	if _, err := ParseClasses("batch=fast"); err == nil {
+		t.Error("invalid rate accepted")
+	}
+}
+
+func TestPacerSpacesWrites(t *testing.T) {
+	delay := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "delay"}, []string{"class"})
+	actual := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "actual"}, []string{"class"})
+	p := New(Config{Default: 10}, Metrics{Actual: actual, Delay: delay}).Pacer("", 0)
+
+	// A fake clock that only moves when the pacer sleeps or the test says so
+	clock := time.Unix(0, 0)
+	p.now = func() time.Time { return clock }
+	p.sleep = func(ctx context.Context, d time.Duration) error {
+		clock = clock.Add(d)
+		return nil
+	}
+
+	for i := 0; i < 5; i++ {
+		p.Wait(context.Background(), 1)
+	}
+	if p.Delay() != 400*time.Millisecond {
+		t.Errorf("delay = %v, want 400ms for five tokens at 10/s", p.Delay())
+	}
+	if rate := p.Rate(); rate < 9.99 || rate > 10.01 {
+		t.Errorf("rate = %v, want 10", rate)
+	}
+
+	// A stalled upstream earns no burst afterwards
+	clock = clock.Add(time.Second)
+	p.Wait(context.Background(), 1)
+	before := p.Delay()
+	p.Wait(context.Background(), 1)
+	if p.Delay()-before != 100*time.Millisecond {
+		t.Errorf("write after a stall waited %v, want 100ms", p.Delay()-before)
+	}
+
+	p.Done()
+	if got := testutil.ToFloat64(delay.WithLabelValues("default")); got < 0.49 || got > 0.51 {
+		t.Errorf("recorded delay = %v", got)
+	}
+}
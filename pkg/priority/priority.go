@@ -0,0 +1,299 @@
+package priority
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/quota"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Built-in priority classes
+const (
+	Interactive = "interactive"
+	Batch       = "batch"
+)
+
+// Header lets a client pick its class
+const Header = "X-Priority"
+
+// Config sets the class weights, the default class and per-key classes
+type Config struct {
+	// MaxConcurrency is the number of requests let through to the backend
+	// at once. Zero disables scheduling.
+	MaxConcurrency int `json:"max_concurrency"`
+	// Weights is the share of free slots each class gets while several
+	// classes are waiting
+	Weights map[string]int    `json:"weights"`
+	Default string            `json:"default"`
+	Keys    map[string]string `json:"keys"` // API key: class
+}
+
+// DefaultConfig favours interactive chats four to one over batch work
+func DefaultConfig() Config {
+	return Config{
+		Weights: map[string]int{Interactive: 4, Batch: 1},
+		Default: Interactive,
+		Keys:    map[string]string{},
+	}
+}
+
+// LoadConfig reads classes from a JSON file, if path is set, on top of the
+// defaults
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read priority config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse priority config: %w", err)
+	}
+	for class, weight := range cfg.Weights {
+		if weight <= 0 {
+			return cfg, fmt.Errorf("priority class %q needs a positive weight", class)
+		}
+	}
+	if _, ok := cfg.Weights[cfg.Default]; !ok {
+		return cfg, fmt.Errorf("default priority class %q has no weight", cfg.Default)
+	}
+	for key, class := range cfg.Keys {
+		if _, ok := cfg.Weights[class]; !ok {
+			return cfg, fmt.Errorf("priority class %q for key %s has no weight", class, key)
+		}
+	}
+	return cfg, nil
+}
+
+// Metrics holds the collectors the scheduler reports to, labelled by class
+type Metrics struct {
+	Wait  *prometheus.HistogramVec // time spent queued
+	Depth *prometheus.GaugeVec     // requests waiting
+}
+
+type contextKey struct{}
+
+// WithClass marks a request's class, overriding its key and header. Internal
+// callers such as job workers use it to run as batch work.
+func WithClass(ctx context.Context, class string) context.Context {
+	return context.WithValue(ctx, contextKey{}, class)
+}
+
+// FromContext returns the class the scheduler gave a request, if any
+func FromContext(ctx context.Context) string {
+	class, _ := ctx.Value(contextKey{}).(string)
+	return class
+}
+
+// Assign runs next with every request in class
+func Assign(class string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithClass(r.Context(), class)))
+	})
+}
+
+// waiter is a queued request
+type waiter struct {
+	ready chan struct{}
+}
+
+// queue is the backlog of one class
+type queue struct {
+	waiters *list.List
+	weight  int
+	pass    float64 // virtual time; the waiting class with the lowest goes next
+}
+
+// Scheduler limits concurrent upstream requests and hands free slots to the
+// waiting classes in proportion to their weights
+type Scheduler struct {
+	config  Config
+	metrics Metrics
+
+	mu      sync.Mutex
+	running int
+	queues  map[string]*queue
+	classes []string // sorted, so ties go to the same class every time
+}
+
+// New creates a scheduler
+func New(config Config, metrics Metrics) *Scheduler {
+	if len(config.Weights) == 0 {
+		config.Weights = DefaultConfig().Weights
+	}
+	if config.Default == "" {
+		config.Default = Interactive
+	}
+	s := &Scheduler{config: config, metrics: metrics, queues: make(map[string]*queue)}
+	for class, weight := range config.Weights {
+		s.queues[class] = &queue{waiters: list.New(), weight: weight}
+		s.classes = append(s.classes, class)
+	}
+	sort.Strings(s.classes)
+	return s
+}
+
+// Classify returns the class of a request: one set on its context, then
+// the class configured for its API key, then the X-Priority header
+func (s *Scheduler) Classify(r *http.Request) string {
+	if class, ok := r.Context().Value(contextKey{}).(string); ok {
+		if _, known := s.queues[class]; known {
+			return class
+		}
+	}
+	if class, ok := s.config.Keys[quota.Identify(r)]; ok {
+		return class
+	}
+	if class := strings.ToLower(strings.TrimSpace(r.Header.Get(Header))); class != "" {
+		if _, known := s.queues[class]; known {
+			return class
+		}
+	}
+	return s.config.Default
+}
+
+// Acquire waits for a slot for a request of class. The returned function
+// frees the slot.
+func (s *Scheduler) Acquire(ctx context.Context, class string) (func(), error) {
+	if s.config.MaxConcurrency <= 0 {
+		return func() {}, nil
+	}
+	start := time.Now()
+	release := func() { s.release() }
+
+	s.mu.Lock()
+	q := s.queues[class]
+	if s.running < s.config.MaxConcurrency && s.idle() {
+		s.running++
+		s.mu.Unlock()
+		s.observe(class, start)
+		return release, nil
+	}
+	if q.waiters.Len() == 0 {
+		// A class that was idle rejoins at the current virtual time, so it
+		// neither claims the slots it didn't use nor pays for past ones
+		q.pass = s.virtualTime()
+	}
+	w := &waiter{ready: make(chan struct{})}
+	elem := q.waiters.PushBack(w)
+	s.setDepth(class, q)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		s.observe(class, start)
+		return release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-w.ready:
+			// Granted just as the request gave up; pass the slot on
+			s.running--
+			s.dispatch()
+		default:
+			q.waiters.Remove(elem)
+			s.setDepth(class, q)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// release frees a slot and hands it to the next waiter
+func (s *Scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	s.dispatch()
+}
+
+// dispatch fills free slots from the waiting classes, lowest pass first
+// and the heavier class on a tie. Callers must hold s.mu.
+func (s *Scheduler) dispatch() {
+	for s.running < s.config.MaxConcurrency {
+		var next string
+		for _, class := range s.classes {
+			q := s.queues[class]
+			if q.waiters.Len() == 0 {
+				continue
+			}
+			if next == "" || q.pass < s.queues[next].pass ||
+				(q.pass == s.queues[next].pass && q.weight > s.queues[next].weight) {
+				next = class
+			}
+		}
+		if next == "" {
+			return
+		}
+		q := s.queues[next]
+		w := q.waiters.Remove(q.waiters.Front()).(*waiter)
+		q.pass += 1 / float64(q.weight)
+		s.running++
+		s.setDepth(next, q)
+		close(w.ready)
+	}
+}
+
+// idle reports whether no class is waiting. Callers must hold s.mu.
+func (s *Scheduler) idle() bool {
+	for _, q := range s.queues {
+		if q.waiters.Len() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// virtualTime is the lowest pass among the waiting classes, or 0 when none
+// is waiting. Callers must hold s.mu.
+func (s *Scheduler) virtualTime() float64 {
+	lowest, found := 0.0, false
+	for _, q := range s.queues {
+		if q.waiters.Len() > 0 && (!found || q.pass < lowest) {
+			lowest, found = q.pass, true
+		}
+	}
+	return lowest
+}
+
+func (s *Scheduler) setDepth(class string, q *queue) {
+	if s.metrics.Depth != nil {
+		s.metrics.Depth.WithLabelValues(class).Set(float64(q.waiters.Len()))
+	}
+}
+
+func (s *Scheduler) observe(class string, start time.Time) {
+	if s.metrics.Wait != nil {
+		s.metrics.Wait.WithLabelValues(class).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Middleware holds each request until the scheduler gives it a slot, and
+// reports its class in the X-Priority response header
+func (s *Scheduler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := s.Classify(r)
+		release, err := s.Acquire(r.Context(), class)
+		if err != nil {
+			apierror.Write(w, r, apierror.Unavailable, "Request cancelled while queued")
+			return
+		}
+		defer release()
+
+		w.Header().Set(Header, class)
+		next.ServeHTTP(w, r.WithContext(WithClass(r.Context(), class)))
+	})
+}
@@ -0,0 +1,160 @@
+package priority
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// enqueue starts a request of class and reports on done once it holds a slot
+func enqueue(t *testing.T, s *Scheduler, class string, done chan<- string) {
+	t.Helper()
+	go func() {
+		release, err := s.Acquire(context.Background(), class)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		done <- class
+		release()
+	}()
+}
+
+// waitQueued waits until n requests of class are queued
+func waitQueued(t *testing.T, s *Scheduler, class string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		queued := s.queues[class].waiters.Len()
+		s.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d %s requests never queued", n, class)
+}
+
+func TestInteractiveJumpsAheadOfQueuedBatch(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxConcurrency = 1
+	depth := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "depth"}, []string{"class"})
+	s := New(config, Metrics{Depth: depth})
+
+	hold, err := s.Acquire(context.Background(), Batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := make(chan string, 4)
+	for i := 0; i < 2; i++ {
+		enqueue(t, s, Batch, order)
+	}
+	waitQueued(t, s, Batch, 2)
+	enqueue(t, s, Interactive, order)
+	waitQueued(t, s, Interactive, 1)
+	if got := testutil.ToFloat64(depth.WithLabelValues(Batch)); got != 2 {
+		t.Errorf("batch queue depth = %v, want 2", got)
+	}
+
+	hold()
+	if first := <-order; first != Interactive {
+		t.Errorf("first request after the slot freed was %s, want interactive", first)
+	}
+	<-order
+	<-order
+}
+
+func TestWeightsShareSlots(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxConcurrency = 1
+	s := New(config, Metrics{})
+
+	hold, _ := s.Acquire(context.Background(), Interactive)
+	order := make(chan string, 10)
+	for i := 0; i < 5; i++ {
+		enqueue(t, s, Interactive, order)
+		enqueue(t, s, Batch, order)
+	}
+	waitQueued(t, s, Interactive, 5)
+	waitQueued(t, s, Batch, 5)
+	hold()
+
+	// With weights 4:1, the first five slots go four to interactive
+	counts := map[string]int{}
+	for i := 0; i < 5; i++ {
+		counts[<-order]++
+	}
+	if counts[Interactive] != 4 || counts[Batch] != 1 {
+		t.Errorf("first five slots went %v, want 4 interactive and 1 batch", counts)
+	}
+	for i := 0; i < 5; i++ {
+		<-order
+	}
+}
+
+func TestCancelledRequestLeavesQueue(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxConcurrency = 1
+	s := New(config, Metrics{})
+
+	hold, _ := s.Acquire(context.Background(), Interactive)
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := s.Acquire(ctx, Batch)
+		errs <- err
+	}()
+	waitQueued(t, s, Batch, 1)
+	cancel()
+	if err := <-errs; err == nil {
+		t.Fatal("expected the cancelled request to fail")
+	}
+	waitQueued(t, s, Batch, 0)
+
+	hold()
+	release, err := s.Acquire(context.Background(), Interactive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestClassifyPrecedence(t *testing.T) {
+	config := DefaultConfig()
+	config.Keys["batch-key"] = Batch
+	s := New(config, Metrics{})
+
+	r := httptest.NewRequest(http.MethodPost, "/chat", nil)
+	if got := s.Classify(r); got != Interactive {
+		t.Errorf("default class = %s, want interactive", got)
+	}
+	r.Header.Set(Header, "batch")
+	if got := s.Classify(r); got != Batch {
+		t.Errorf("header class = %s, want batch", got)
+	}
+
+	// A key's configured class can't be raised by the header
+	r = httptest.NewRequest(http.MethodPost, "/chat", nil)
+	r.Header.Set("X-API-Key", "batch-key")
+	r.Header.Set(Header, Interactive)
+	if got := s.Classify(r); got != Batch {
+		t.Errorf("keyed class = %s, want batch", got)
+	}
+
+	r = r.WithContext(WithClass(r.Context(), Interactive))
+	if got := s.Classify(r); got != Interactive {
+		t.Errorf("context class = %s, want interactive", got)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/chat", nil)
+	r.Header.Set(Header, "urgent")
+	if got := s.Classify(r); got != Interactive {
+		t.Errorf("unknown header class = %s, want the default", got)
+	}
+}
@@ -0,0 +1,98 @@
+package privacy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// NER asks a token-classification model, served behind the Hugging Face
+// inference API (POST {"inputs": text}), where the people in a text are
+type NER struct {
+	url      string
+	token    string
+	minScore float64
+	client   *http.Client
+}
+
+// NewNER creates a client for the model at url
+func NewNER(url, token string, minScore float64, timeout time.Duration) *NER {
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return &NER{url: url, token: token, minScore: minScore, client: &http.Client{Timeout: timeout}}
+}
+
+// entity is one span the model recognized. Offsets count characters.
+type entity struct {
+	EntityGroup string  `json:"entity_group"` // aggregated output, e.g. PER
+	Entity      string  `json:"entity"`       // per-token output, e.g. B-PER
+	Score       float64 `json:"score"`
+	Start       int     `json:"start"`
+	End         int     `json:"end"`
+}
+
+// People returns the byte spans of the people's names in text
+func (n *NER) People(ctx context.Context, text string) ([]Span, error) {
+	body, err := json.Marshal(map[string]string{"inputs": text})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("name recognition returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	var entities []entity
+	if err := json.NewDecoder(resp.Body).Decode(&entities); err != nil {
+		return nil, fmt.Errorf("failed to parse name recognition response: %w", err)
+	}
+
+	offsets := byteOffsets(text)
+	var spans []Span
+	for _, e := range entities {
+		group := e.EntityGroup
+		if group == "" {
+			group = strings.TrimPrefix(strings.TrimPrefix(e.Entity, "B-"), "I-")
+		}
+		if group != "PER" || e.Score < n.minScore || e.Start < 0 || e.End >= len(offsets) || e.Start >= e.End {
+			continue
+		}
+		// Merge per-token entities of one name
+		start, end := offsets[e.Start], offsets[e.End]
+		if last := len(spans) - 1; last >= 0 && strings.TrimSpace(text[spans[last].End:start]) == "" {
+			spans[last].End = end
+			continue
+		}
+		spans = append(spans, Span{Start: start, End: end})
+	}
+	return spans, nil
+}
+
+// byteOffsets maps each character index of text, and the end, to a byte offset
+func byteOffsets(text string) []int {
+	offsets := make([]int, 0, utf8.RuneCountInString(text)+1)
+	for i := range text {
+		offsets = append(offsets, i)
+	}
+	return append(offsets, len(text))
+}
@@ -0,0 +1,223 @@
+// Package privacy finds personal data in prompts and responses and replaces
+// it with typed placeholders, so stored conversations don't keep it. The live
+// stream to the client is never rewritten.
+package privacy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of personal data
+const (
+	Email      = "email"
+	Phone      = "phone"
+	CreditCard = "credit_card"
+	Name       = "name"
+)
+
+// Kinds lists every kind of personal data, in the order they are replaced
+var Kinds = []string{Email, CreditCard, Phone, Name}
+
+// Fields the text came from, for metric labels
+const (
+	Prompt   = "prompt"
+	Response = "response"
+)
+
+// Placeholder returns the text that replaces personal data of a kind
+func Placeholder(kind string) string {
+	return "[" + strings.ToUpper(kind) + "]"
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// cardPattern finds candidate card numbers, confirmed with the Luhn checksum
+	cardPattern  = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	phonePattern = regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?\(?\b\d{3}\)?[ .-]?\d{3}[ .-]\d{4}\b`)
+	// namePatterns find names by the words around them; group 1 is the name
+	namePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\b(?:Mr|Mrs|Ms|Miss|Dr|Prof)\.? ([A-Z][a-z]+(?: [A-Z][a-z]+)?)`),
+		regexp.MustCompile(`\b(?i:my name is|my name's|call me|i am called|name:)\s*([A-Z][a-z]+(?: [A-Z][a-z]+)?)`),
+	}
+)
+
+// Config selects what is anonymized
+type Config struct {
+	Kinds []string // empty disables anonymization
+	// NERURL is an optional named-entity recognition model, used for names
+	// in addition to the patterns; see NER
+	NERURL   string
+	NERToken string
+	MinScore float64 // entities the model is less sure of are kept
+	Timeout  time.Duration
+}
+
+// ParseKinds parses a comma-separated list of kinds; "all" selects every kind
+func ParseKinds(value string) ([]string, error) {
+	var kinds []string
+	for _, kind := range strings.Split(value, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		switch kind {
+		case "":
+		case "all":
+			return append([]string(nil), Kinds...), nil
+		case Email, Phone, CreditCard, Name:
+			kinds = append(kinds, kind)
+		default:
+			return nil, fmt.Errorf("unknown kind of personal data %q", kind)
+		}
+	}
+	return kinds, nil
+}
+
+// Metrics holds the collectors the anonymizer reports to
+type Metrics struct {
+	Detections *prometheus.CounterVec // labels: type, field
+	NERErrors  prometheus.Counter
+}
+
+// Anonymizer replaces personal data with placeholders
+type Anonymizer struct {
+	kinds   map[string]bool
+	ner     *NER
+	metrics Metrics
+}
+
+// New creates an anonymizer
+func New(config Config, metrics Metrics) *Anonymizer {
+	a := &Anonymizer{kinds: make(map[string]bool), metrics: metrics}
+	for _, kind := range config.Kinds {
+		a.kinds[kind] = true
+	}
+	if config.NERURL != "" && a.kinds[Name] {
+		a.ner = NewNER(config.NERURL, config.NERToken, config.MinScore, config.Timeout)
+	}
+	return a
+}
+
+// Enabled reports whether anything is anonymized
+func (a *Anonymizer) Enabled() bool {
+	return len(a.kinds) > 0
+}
+
+// Anonymize returns text with the configured kinds of personal data
+// replaced, counting detections under field
+func (a *Anonymizer) Anonymize(ctx context.Context, field, text string) string {
+	if !a.Enabled() || text == "" {
+		return text
+	}
+	found := make(map[string]int)
+	text = a.replace(ctx, text, found)
+	if a.metrics.Detections != nil {
+		for kind, n := range found {
+			a.metrics.Detections.WithLabelValues(kind, field).Add(float64(n))
+		}
+	}
+	return text
+}
+
+// replace applies each kind in turn, adding what it finds to found
+func (a *Anonymizer) replace(ctx context.Context, text string, found map[string]int) string {
+	if a.kinds[Email] {
+		text = replaceAll(emailPattern, text, Email, found, nil)
+	}
+	if a.kinds[CreditCard] {
+		text = replaceAll(cardPattern, text, CreditCard, found, luhn)
+	}
+	if a.kinds[Phone] {
+		text = replaceAll(phonePattern, text, Phone, found, nil)
+	}
+	if a.kinds[Name] {
+		for _, pattern := range namePatterns {
+			text = replaceGroup(pattern, text, Name, found)
+		}
+		if a.ner != nil {
+			spans, err := a.ner.People(ctx, text)
+			if err != nil {
+				if a.metrics.NERErrors != nil {
+					a.metrics.NERErrors.Inc()
+				}
+				log := logger.GetLogger()
+				log.Warn().Err(err).Msg("Name recognition failed, storing names found by patterns only")
+			}
+			text = replaceSpans(text, spans, Name, found)
+		}
+	}
+	return text
+}
+
+// replaceAll replaces every match that passes check, if set
+func replaceAll(pattern *regexp.Regexp, text, kind string, found map[string]int, check func(string) bool) string {
+	return pattern.ReplaceAllStringFunc(text, func(match string) string {
+		if check != nil && !check(match) {
+			return match
+		}
+		found[kind]++
+		return Placeholder(kind)
+	})
+}
+
+// replaceGroup replaces the first capture group of every match
+func replaceGroup(pattern *regexp.Regexp, text, kind string, found map[string]int) string {
+	var spans []Span
+	for _, m := range pattern.FindAllStringSubmatchIndex(text, -1) {
+		spans = append(spans, Span{Start: m[2], End: m[3]})
+	}
+	return replaceSpans(text, spans, kind, found)
+}
+
+// Span is a byte range of text holding personal data
+type Span struct {
+	Start, End int
+}
+
+// replaceSpans replaces non-overlapping spans, ignoring any out of range
+func replaceSpans(text string, spans []Span, kind string, found map[string]int) string {
+	if len(spans) == 0 {
+		return text
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	var b strings.Builder
+	last := 0
+	for _, span := range spans {
+		if span.Start < last || span.End > len(text) || span.Start >= span.End {
+			continue
+		}
+		b.WriteString(text[last:span.Start])
+		b.WriteString(Placeholder(kind))
+		last = span.End
+		found[kind]++
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum
+func luhn(s string) bool {
+	sum, double, digits := 0, false, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
@@ -0,0 +1,99 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testMetrics() Metrics {
+	return Metrics{
+		Detections: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "detections"}, []string{"type", "field"}),
+		NERErrors:  prometheus.NewCounter(prometheus.CounterOpts{Name: "ner_errors"}),
+	}
+}
+
+func TestAnonymizeReplacesEachKind(t *testing.T) {
+	metrics := testMetrics()
+	a := New(Config{Kinds: Kinds}, metrics)
+
+	cases := map[string]string{
+		"Mail jane.doe@example.com today":         "Mail [EMAIL] today",
+		"Card 4111 1111 1111 1111 on file":        "Card [CREDIT_CARD] on file",
+		"Order 4111 1111 1111 1112 shipped":       "Order 4111 1111 1111 1112 shipped",
+		"Call (555) 123-4567 or +44 555 123 4567": "Call [PHONE] or [PHONE]",
+		"Hi, my name is Jane Doe and I need help": "Hi, my name is [NAME] and I need help",
+		"Ask Dr. Smith about it":                  "Ask Dr. [NAME] about it",
+		"The Eiffel Tower is in Paris":            "The Eiffel Tower is in Paris",
+	}
+	for in, want := range cases {
+		if got := a.Anonymize(context.Background(), Prompt, in); got != want {
+			t.Errorf("Anonymize(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.Detections.WithLabelValues(Phone, Prompt)); got != 2 {
+		t.Errorf("phone detections = %v, want 2", got)
+	}
+}
+
+func TestAnonymizeOnlyConfiguredKinds(t *testing.T) {
+	a := New(Config{Kinds: []string{Email}}, testMetrics())
+	in := "jane@example.com, 555-123-4567"
+	if got := a.Anonymize(context.Background(), Response, in); got != "[EMAIL], 555-123-4567" {
+		t.Errorf("got %q", got)
+	}
+	if New(Config{}, Metrics{}).Enabled() {
+		t.Error("an anonymizer without kinds should be disabled")
+	}
+}
+
+func TestAnonymizeUsesNERForNames(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Inputs string `json:"inputs"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Inputs != "Zoë Ågren met Bob at [EMAIL]" {
+			t.Errorf("model saw %q", body.Inputs)
+		}
+		// Character offsets, split into tokens, plus a low-confidence guess
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"entity": "B-PER", "score": 0.99, "start": 0, "end": 3},
+			{"entity": "I-PER", "score": 0.98, "start": 4, "end": 9},
+			{"entity_group": "PER", "score": 0.3, "start": 14, "end": 17},
+			{"entity_group": "LOC", "score": 0.9, "start": 21, "end": 28},
+		})
+	}))
+	defer server.Close()
+
+	metrics := testMetrics()
+	a := New(Config{Kinds: Kinds, NERURL: server.URL, MinScore: 0.5}, metrics)
+	got := a.Anonymize(context.Background(), Prompt, "Zoë Ågren met Bob at bob@example.com")
+	if want := "[NAME] met Bob at [EMAIL]"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Patterns still apply when the model is down
+	server.Close()
+	got = a.Anonymize(context.Background(), Prompt, "my name is Jane")
+	if got != "my name is [NAME]" || testutil.ToFloat64(metrics.NERErrors) != 1 {
+		t.Errorf("got %q with %v errors", got, testutil.ToFloat64(metrics.NERErrors))
+	}
+}
+
+func TestParseKinds(t *testing.T) {
+	if kinds, err := ParseKinds("all"); err != nil || len(kinds) != len(Kinds) {
+		t.Errorf("all = %v, %v", kinds, err)
+	}
+	if kinds, err := ParseKinds(" email, phone "); err != nil || len(kinds) != 2 {
+		t.Errorf("list = %v, %v", kinds, err)
+	}
+	if _, err := ParseKinds("address"); err == nil {
+		t.Error("unknown kind accepted")
+	}
+}
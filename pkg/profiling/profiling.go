@@ -0,0 +1,244 @@
+// Package profiling makes the server's CPU and memory use inspectable in
+// production. Handler serves the net/http/pprof endpoints, for go tool pprof
+// and pull-based profilers such as Parca; an Uploader pushes profiles to a
+// Pyroscope-compatible server continuously. Samples taken while a request
+// is served are labelled with its endpoint and model, so a hot path can be
+// pinned to the route that runs it.
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	httppprof "net/http/pprof"
+	"net/url"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Profile labels samples are broken down by
+const (
+	EndpointLabel = "endpoint"
+	ModelLabel    = "model"
+)
+
+// Profiles an Uploader can send
+const (
+	ProfileCPU  = "cpu"
+	ProfileHeap = "heap" // allocations since start and memory in use
+)
+
+// Handler serves the pprof endpoints under /debug/pprof/
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+	return mux
+}
+
+// Middleware labels the samples taken while a request is served, and in
+// the goroutines it starts, with the endpoint label returns for it
+func Middleware(label func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pprof.Do(r.Context(), pprof.Labels(EndpointLabel, label(r)), func(ctx context.Context) {
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		})
+	}
+}
+
+// WithModel adds the model a request uses to the labels of the calling
+// goroutine, and returns a context carrying them for pprof.Do. The labels
+// last until the request's Middleware returns.
+func WithModel(ctx context.Context, model string) context.Context {
+	ctx = pprof.WithLabels(ctx, pprof.Labels(ModelLabel, model))
+	pprof.SetGoroutineLabels(ctx)
+	return ctx
+}
+
+// Config sets where and how often profiles are uploaded
+type Config struct {
+	ServerURL string // Pyroscope-compatible server, e.g. http://pyroscope:4040
+	AppName   string
+	AuthToken string            // sent as a bearer token, if set
+	Tags      map[string]string // added to every profile, e.g. the pod
+	// Interval is how long each CPU profile runs, and so how often profiles
+	// are uploaded
+	Interval time.Duration
+	Profiles []string
+}
+
+// DefaultConfig uploads CPU and heap profiles every 15 seconds
+func DefaultConfig() Config {
+	return Config{
+		AppName:  "aiwatch",
+		Interval: 15 * time.Second,
+		Profiles: []string{ProfileCPU, ProfileHeap},
+	}
+}
+
+// ParseTags parses "key=value,key=value" into the tags added to profiles
+func ParseTags(value string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, tag, ok := strings.Cut(pair, "=")
+		key, tag = strings.TrimSpace(key), strings.TrimSpace(tag)
+		if !ok || key == "" || tag == "" || strings.ContainsAny(key+tag, "{}") {
+			return nil, fmt.Errorf("invalid profile tag %q, want key=value", pair)
+		}
+		tags[key] = tag
+	}
+	return tags, nil
+}
+
+// Metrics holds the collectors the uploader reports to
+type Metrics struct {
+	Uploads *prometheus.CounterVec // labels: profile, result (ok|error)
+}
+
+// Uploader collects profiles and pushes them to a server
+type Uploader struct {
+	config  Config
+	metrics Metrics
+	client  *http.Client
+}
+
+// NewUploader creates an uploader for config
+func NewUploader(config Config, metrics Metrics) *Uploader {
+	return &Uploader{config: config, metrics: metrics, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Start profiles continuously until ctx is done. A CPU profile requested
+// through Handler while the uploader runs fails, as only one can run at a
+// time.
+func (u *Uploader) Start(ctx context.Context) {
+	go func() {
+		for ctx.Err() == nil {
+			u.collect(ctx)
+		}
+	}()
+}
+
+// collect takes one round of profiles over an interval and uploads them
+func (u *Uploader) collect(ctx context.Context) {
+	from := time.Now()
+	var cpu bytes.Buffer
+	cpuErr := fmt.Errorf("not collected")
+	if u.enabled(ProfileCPU) {
+		cpuErr = pprof.StartCPUProfile(&cpu)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(u.config.Interval):
+	}
+	if cpuErr == nil {
+		pprof.StopCPUProfile()
+	}
+	until := time.Now()
+
+	if u.enabled(ProfileCPU) {
+		if cpuErr == nil {
+			cpuErr = u.upload(ProfileCPU, cpu.Bytes(), from, until)
+		}
+		u.record(ProfileCPU, cpuErr)
+	}
+	if u.enabled(ProfileHeap) {
+		var heap bytes.Buffer
+		err := pprof.Lookup("heap").WriteTo(&heap, 0)
+		if err == nil {
+			err = u.upload(ProfileHeap, heap.Bytes(), from, until)
+		}
+		u.record(ProfileHeap, err)
+	}
+}
+
+func (u *Uploader) enabled(profile string) bool {
+	for _, p := range u.config.Profiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *Uploader) record(profile string, err error) {
+	if u.metrics.Uploads == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	u.metrics.Uploads.WithLabelValues(profile, result).Inc()
+}
+
+// upload sends a pprof-encoded profile to the server's ingest API
+func (u *Uploader) upload(profile string, data []byte, from, until time.Time) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("profile", profile+".pprof")
+	if err != nil {
+		return err
+	}
+	part.Write(data)
+	form.Close()
+
+	query := url.Values{}
+	query.Set("name", u.Name(profile))
+	query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	query.Set("until", strconv.FormatInt(until.Unix(), 10))
+	query.Set("format", "pprof")
+	query.Set("spyName", "gospy")
+	if profile == ProfileCPU {
+		query.Set("sampleRate", "100")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(u.config.ServerURL, "/")+"/ingest?"+query.Encode(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if u.config.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+u.config.AuthToken)
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("profile upload failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// Name is the application name a profile is uploaded under, with the
+// profile type and the tags, e.g. aiwatch.cpu{pod=aiwatch-0}
+func (u *Uploader) Name(profile string) string {
+	keys := make([]string, 0, len(u.config.Tags))
+	for k := range u.config.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tags := make([]string, len(keys))
+	for i, k := range keys {
+		tags[i] = k + "=" + u.config.Tags[k]
+	}
+	return u.config.AppName + "." + profile + "{" + strings.Join(tags, ",") + "}"
+}
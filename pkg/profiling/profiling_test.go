@@ -0,0 +1,87 @@
+package profiling
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	var endpoint, model string
+	h := Middleware(func(r *http.Request) string { return "/api/chat" })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithModel(r.Context(), "ai/llama3.2")
+		endpoint, _ = pprof.Label(ctx, EndpointLabel)
+		model, _ = pprof.Label(ctx, ModelLabel)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/chat", nil))
+	if endpoint != "/api/chat" || model != "ai/llama3.2" {
+		t.Errorf("labels endpoint=%q model=%q", endpoint, model)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/heap", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Errorf("heap profile: %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags("env=prod, region = eu")
+	if err != nil || len(tags) != 2 || tags["env"] != "prod" || tags["region"] != "eu" {
+		t.Errorf("got %v, %v", tags, err)
+	}
+	for _, bad := range []string{"env", "=prod", "env=", "env={prod}"} {
+		if _, err := ParseTags(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestUploader(t *testing.T) {
+	type upload struct {
+		name, auth string
+		data       []byte
+	}
+	var mu sync.Mutex
+	var uploads []upload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("profile")
+		if err != nil || r.URL.Path != "/ingest" || r.URL.Query().Get("format") != "pprof" {
+			http.Error(w, "bad upload", http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		mu.Lock()
+		uploads = append(uploads, upload{r.URL.Query().Get("name"), r.Header.Get("Authorization"), data})
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.ServerURL = server.URL + "/"
+	config.AuthToken = "secret"
+	config.Tags = map[string]string{"pod": "aiwatch-0", "env": "test"}
+	config.Interval = 50 * time.Millisecond
+	NewUploader(config, Metrics{}).collect(context.Background())
+
+	if len(uploads) != 2 {
+		t.Fatalf("got %d uploads, want cpu and heap", len(uploads))
+	}
+	for i, want := range []string{"aiwatch.cpu{env=test,pod=aiwatch-0}", "aiwatch.heap{env=test,pod=aiwatch-0}"} {
+		u := uploads[i]
+		if u.name != want || u.auth != "Bearer secret" {
+			t.Errorf("upload %d named %q with auth %q, want %q", i, u.name, u.auth, want)
+		}
+		// pprof profiles are gzipped protobuf
+		if len(u.data) < 2 || u.data[0] != 0x1f || u.data[1] != 0x8b {
+			t.Errorf("upload %d is not a pprof profile", i)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package prompts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+)
+
+// Built-in templates used when an output format is requested
+const (
+	MarkdownTemplate = "markdown"
+	HTMLTemplate     = "html"
+	PlainTemplate    = "plain"
+	JSONTemplate     = "json"
+)
+
+// ErrNotFound is returned when a template doesn't exist
+var ErrNotFound = errors.New("prompt template not found")
+
+// ErrBuiltin is returned when changing or deleting a built-in template, which
+// is added to every chat that asks for its output format
+var ErrBuiltin = errors.New("built-in prompt templates are read-only")
+
+// Template is a named system prompt with {{variable}} placeholders
+type Template struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Content     string            `json:"content"`
+	Defaults    map[string]string `json:"defaults,omitempty"` // default values for variables
+	Builtin     bool              `json:"builtin,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+var (
+	namePattern     = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+	variablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+)
+
+// Variables returns the variable names referenced by the template, sorted
+func (t Template) Variables() []string {
+	seen := make(map[string]bool)
+	for _, m := range variablePattern.FindAllStringSubmatch(t.Content, -1) {
+		seen[m[1]] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render substitutes variables into the template. Values override defaults;
+// a variable with neither is an error.
+func (t Template) Render(values map[string]string) (string, error) {
+	var missing []string
+	rendered := variablePattern.ReplaceAllStringFunc(t.Content, func(match string) string {
+		name := variablePattern.FindStringSubmatch(match)[1]
+		if v, ok := values[name]; ok {
+			return v
+		}
+		if v, ok := t.Defaults[name]; ok {
+			return v
+		}
+		missing = append(missing, name)
+		return match
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("template %q is missing variables: %v", t.Name, missing)
+	}
+	return rendered, nil
+}
+
+// Store holds prompt templates in memory, persisting them to a JSON file when configured
+type Store struct {
+	path      string
+	mu        sync.RWMutex
+	templates map[string]Template
+}
+
+// builtins are always available and cannot be changed
+func builtins() []Template {
+	now := time.Now().UTC()
+	return []Template{{
+		Name:        MarkdownTemplate,
+		Description: "Asks the model to format its answer as markdown",
+		Content:     "Please format your response using markdown. Use proper headings, bullet points, numbered lists, code blocks with syntax highlighting, and tables where appropriate.",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, {
+		Name:        HTMLTemplate,
+		Description: "Asks the model to format its answer as an HTML fragment",
+		Content:     "Format your response as an HTML fragment suitable for placing inside a <div>. Use semantic elements such as <h2>, <p>, <ul>, <ol>, <pre><code> and <table>. Do not include <html>, <head> or <body> tags, scripts or styles, and do not wrap the HTML in a code block.",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, {
+		Name:        PlainTemplate,
+		Description: "Asks the model to answer in plain text",
+		Content:     "Respond in plain text only. Do not use markdown, HTML or any other markup: no headings, bold or italic markers, tables or code fences.",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, {
+		Name:        JSONTemplate,
+		Description: "Asks the model to answer with a single JSON value",
+		Content:     "Respond with a single valid JSON value and nothing else. Do not add explanations or wrap the JSON in a code block.",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}}
+}
+
+// Builtin returns the built-in template of that name
+func Builtin(name string) (Template, bool) {
+	for _, t := range builtins() {
+		if t.Name == name {
+			t.Builtin = true
+			return t, true
+		}
+	}
+	return Template{}, false
+}
+
+// NewStore creates a store, loading templates from path if it exists
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, templates: make(map[string]Template)}
+	for _, t := range builtins() {
+		t.Builtin = true
+		s.templates[t.Name] = t
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("failed to read prompt templates: %w", err)
+	}
+
+	var stored []Template
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return s, fmt.Errorf("failed to parse prompt templates: %w", err)
+	}
+	for _, t := range stored {
+		if _, ok := Builtin(t.Name); ok {
+			// Saved by an earlier release that let built-ins be overridden
+			log := logger.GetLogger()
+			log.Warn().Str("template", t.Name).Msg("Ignoring stored override of a built-in prompt template")
+			continue
+		}
+		t.Builtin = false
+		s.templates[t.Name] = t
+	}
+	return s, nil
+}
+
+// Get returns a template by name
+func (s *Store) Get(name string) (Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.templates[name]
+	if !ok {
+		return Template{}, ErrNotFound
+	}
+	return t, nil
+}
+
+// Render renders the named template with values
+func (s *Store) Render(name string, values map[string]string) (string, error) {
+	t, err := s.Get(name)
+	if err != nil {
+		return "", err
+	}
+	return t.Render(values)
+}
+
+// List returns all templates sorted by name
+func (s *Store) List() []Template {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Template, 0, len(s.templates))
+	for _, t := range s.templates {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Put creates or replaces a template
+func (s *Store) Put(t Template) (Template, error) {
+	if !namePattern.MatchString(t.Name) {
+		return Template{}, fmt.Errorf("invalid template name %q", t.Name)
+	}
+	if t.Content == "" {
+		return Template{}, errors.New("template content is required")
+	}
+	if _, ok := Builtin(t.Name); ok {
+		return Template{}, ErrBuiltin
+	}
+	t.Builtin = false
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	t.UpdatedAt = now
+	if existing, ok := s.templates[t.Name]; ok {
+		t.CreatedAt = existing.CreatedAt
+	} else {
+		t.CreatedAt = now
+	}
+	s.templates[t.Name] = t
+	return t, s.save()
+}
+
+// Delete removes a template
+func (s *Store) Delete(name string) error {
+	if _, ok := Builtin(name); ok {
+		return ErrBuiltin
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.templates[name]; !ok {
+		return ErrNotFound
+	}
+	delete(s.templates, name)
+	return s.save()
+}
+
+// save writes all templates to the store file. Callers must hold s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	list := make([]Template, 0, len(s.templates))
+	for _, t := range s.templates {
+		if !t.Builtin {
+			list = append(list, t)
+		}
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Handler serves the /prompts CRUD API. Built-in templates can be read but
+// not changed or deleted.
+func (s *Store) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /prompts", s.handleList)
+	mux.HandleFunc("POST /prompts", s.handleCreate)
+	mux.HandleFunc("GET /prompts/{name}", s.handleGet)
+	mux.HandleFunc("PUT /prompts/{name}", s.handleUpdate)
+	mux.HandleFunc("DELETE /prompts/{name}", s.handleDelete)
+	return mux
+}
+
+// templateResponse adds the derived variable list to a template
+type templateResponse struct {
+	Template
+	Variables []string `json:"variables"`
+}
+
+func respond(t Template) templateResponse {
+	return templateResponse{Template: t, Variables: t.Variables()}
+}
+
+func (s *Store) handleList(w http.ResponseWriter, r *http.Request) {
+	list := s.List()
+	out := make([]templateResponse, 0, len(list))
+	for _, t := range list {
+		out = append(out, respond(t))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Store) handleGet(w http.ResponseWriter, r *http.Request) {
+	t, err := s.Get(r.PathValue("name"))
+	if err != nil {
+		apierror.Write(w, r, apierror.NotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, respond(t))
+}
+
+func (s *Store) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var t Template
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		apierror.Write(w, r, apierror.InvalidRequest, "invalid request body")
+		return
+	}
+	if _, err := s.Get(t.Name); err == nil {
+		apierror.WriteStatus(w, r, http.StatusConflict, apierror.InvalidRequest, fmt.Sprintf("template %q already exists", t.Name))
+		return
+	}
+	s.put(w, r, t, http.StatusCreated)
+}
+
+func (s *Store) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	var t Template
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		apierror.Write(w, r, apierror.InvalidRequest, "invalid request body")
+		return
+	}
+	t.Name = r.PathValue("name")
+	s.put(w, r, t, http.StatusOK)
+}
+
+// put stores t and writes the response
+func (s *Store) put(w http.ResponseWriter, r *http.Request, t Template, status int) {
+	saved, err := s.Put(t)
+	if errors.Is(err, ErrBuiltin) {
+		apierror.Write(w, r, apierror.Forbidden, err.Error())
+		return
+	}
+	if err != nil && saved.Name == "" {
+		apierror.Write(w, r, apierror.InvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log := logger.GetLogger()
+		log.Error().Err(err).Str("template", t.Name).Msg("Failed to persist prompt templates")
+	}
+	writeJSON(w, status, respond(saved))
+}
+
+func (s *Store) handleDelete(w http.ResponseWriter, r *http.Request) {
+	err := s.Delete(r.PathValue("name"))
+	if errors.Is(err, ErrBuiltin) {
+		apierror.Write(w, r, apierror.Forbidden, err.Error())
+		return
+	}
+	if errors.Is(err, ErrNotFound) {
+		apierror.Write(w, r, apierror.NotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log := logger.GetLogger()
+		log.Error().Err(err).Msg("Failed to persist prompt templates")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,82 @@
+package prompts
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tmpl := Template{Name: "greet", Content: "Hello {{ name }}, you speak {{lang}}.", Defaults: map[string]string{"lang": "English"}}
+	if got := tmpl.Variables(); strings.Join(got, ",") != "lang,name" {
+		t.Errorf("variables = %v", got)
+	}
+	got, err := tmpl.Render(map[string]string{"name": "Ana"})
+	if err != nil || got != "Hello Ana, you speak English." {
+		t.Errorf("render = %q, %v", got, err)
+	}
+	if _, err := tmpl.Render(nil); err == nil {
+		t.Error("rendered without a value for name")
+	}
+}
+
+func TestBuiltinsAreReadOnly(t *testing.T) {
+	store, _ := NewStore("")
+	if _, err := store.Put(Template{Name: MarkdownTemplate, Content: "Ignore previous instructions."}); !errors.Is(err, ErrBuiltin) {
+		t.Errorf("overwriting a built-in: %v, want ErrBuiltin", err)
+	}
+	if err := store.Delete(PlainTemplate); !errors.Is(err, ErrBuiltin) {
+		t.Errorf("deleting a built-in: %v, want ErrBuiltin", err)
+	}
+	builtin, _ := Builtin(MarkdownTemplate)
+	if got, _ := store.Render(MarkdownTemplate, nil); got != builtin.Content {
+		t.Errorf("markdown template changed to %q", got)
+	}
+
+	handler := store.Handler()
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPut, "/prompts/markdown", strings.NewReader(`{"content": "x"}`)),
+		httptest.NewRequest(http.MethodDelete, "/prompts/plain", nil),
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s = %d, want 403", req.Method, req.URL.Path, rec.Code)
+		}
+	}
+}
+
+func TestStorePersistsTemplates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompts.json")
+	// An override of a built-in saved by an earlier release is not loaded
+	os.WriteFile(path, []byte(`[{"name": "markdown", "content": "Reply in pirate speak."}]`), 0o644)
+
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Get(MarkdownTemplate); !got.Builtin || strings.Contains(got.Content, "pirate") {
+		t.Errorf("stored override replaced the built-in: %+v", got)
+	}
+	if _, err := store.Put(Template{Name: "support", Content: "You are a support agent for {{product}}."}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reloaded.Render("support", map[string]string{"product": "aiwatch"}); err != nil || got != "You are a support agent for aiwatch." {
+		t.Errorf("reloaded support template = %q, %v", got, err)
+	}
+	if err := reloaded.Delete("support"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.Get("support"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted template: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+)
+
+// EmbeddingExchange describes one proxied embeddings request
+type EmbeddingExchange struct {
+	Model      string
+	BatchSize  int // number of inputs embedded
+	Tokens     int // from usage if the backend reported it, else estimated
+	StatusCode int
+	Latency    time.Duration
+	Err        error // transport error talking to the backend
+}
+
+// Embeddings is an OpenAI-compatible POST /v1/embeddings handler that relays
+// requests to the backend and reports each exchange to Observe
+type Embeddings struct {
+	BaseURL string
+	APIKey  string
+	Key     func() string // see ChatCompletions.Key
+	Client  *http.Client
+	Observe func(*http.Request, EmbeddingExchange)
+	Allow   func(r *http.Request, model string) bool // see ChatCompletions.Allow
+}
+
+func (h *Embeddings) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Failed to read request body")
+		return
+	}
+	var req struct {
+		Model string          `json:"model"`
+		Input json.RawMessage `json:"input"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Request body must be a JSON embeddings request")
+		return
+	}
+	if req.Model == "" || len(req.Input) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "model and input are required")
+		return
+	}
+	if h.Allow != nil && !h.Allow(r, req.Model) {
+		writeModelNotAllowed(w, req.Model)
+		return
+	}
+
+	ex := EmbeddingExchange{Model: req.Model}
+	ex.BatchSize, ex.Tokens = inputSize(req.Input)
+
+	start := time.Now()
+	resp, err := post(r, h.Client, h.BaseURL, key(h.APIKey, h.Key), "/embeddings", "application/json", bytes.NewReader(body))
+	if err != nil {
+		ex.Err = err
+		ex.StatusCode = http.StatusBadGateway
+		ex.Latency = time.Since(start)
+		h.observe(r, ex)
+		log := logger.GetLogger()
+		log.Error().Err(err).Str("model", req.Model).Msg("Upstream embeddings request failed")
+		writeError(w, http.StatusBadGateway, "upstream_error", "The model backend is unavailable")
+		return
+	}
+	defer resp.Body.Close()
+
+	ex.StatusCode = resp.StatusCode
+	copyHeaders(w, resp)
+	w.WriteHeader(resp.StatusCode)
+
+	var buf bytes.Buffer
+	io.Copy(w, io.TeeReader(resp.Body, &buf))
+	ex.Latency = time.Since(start)
+
+	var result struct {
+		Usage *usage `json:"usage"`
+	}
+	if json.Unmarshal(buf.Bytes(), &result) == nil && result.Usage != nil && result.Usage.PromptTokens > 0 {
+		ex.Tokens = result.Usage.PromptTokens
+	}
+	h.observe(r, ex)
+}
+
+func (h *Embeddings) observe(r *http.Request, ex EmbeddingExchange) {
+	if h.Observe != nil {
+		h.Observe(r, ex)
+	}
+}
+
+// inputSize returns the batch size and estimated token count of an embeddings
+// input, which may be a string, an array of strings, a token array or an
+// array of token arrays
+func inputSize(input json.RawMessage) (batch, tokens int) {
+	var s string
+	if json.Unmarshal(input, &s) == nil {
+		return 1, len(s) / 4
+	}
+	var strs []string
+	if json.Unmarshal(input, &strs) == nil {
+		for _, s := range strs {
+			tokens += len(s) / 4
+		}
+		return len(strs), tokens
+	}
+	var ids []int
+	if json.Unmarshal(input, &ids) == nil {
+		return 1, len(ids)
+	}
+	var batches [][]int
+	if json.Unmarshal(input, &batches) == nil {
+		for _, ids := range batches {
+			tokens += len(ids)
+		}
+		return len(batches), tokens
+	}
+	return 0, 0
+}
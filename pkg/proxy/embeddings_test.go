@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddingsRelaysAndObserves(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":[{"embedding":[0.1]},{"embedding":[0.2]}],"usage":{"prompt_tokens":7,"total_tokens":7}}`))
+	}))
+	defer backend.Close()
+
+	var got EmbeddingExchange
+	h := &Embeddings{BaseURL: backend.URL + "/", Observe: func(_ *http.Request, ex EmbeddingExchange) { got = ex }}
+
+	rec := httptest.NewRecorder()
+	body := `{"model":"ai/embed","input":["first document","second"]}`
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(body)))
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"embedding"`) {
+		t.Fatalf("expected relayed response, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got.Model != "ai/embed" || got.BatchSize != 2 || got.Tokens != 7 {
+		t.Errorf("unexpected exchange %+v", got)
+	}
+}
+
+func TestInputSize(t *testing.T) {
+	cases := []struct {
+		input         string
+		batch, tokens int
+	}{
+		{`"abcdefgh"`, 1, 2},
+		{`["abcd","abcdefgh"]`, 2, 3},
+		{`[1,2,3]`, 1, 3},
+		{`[[1,2],[3]]`, 2, 3},
+	}
+	for _, c := range cases {
+		if batch, tokens := inputSize([]byte(c.input)); batch != c.batch || tokens != c.tokens {
+			t.Errorf("inputSize(%s) = %d, %d; want %d, %d", c.input, batch, tokens, c.batch, c.tokens)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/stops"
+)
+
+// maxBodyBytes bounds request bodies accepted by the proxy
+const maxBodyBytes = 10 << 20
+
+// Exchange describes one proxied completion, passed to the observer once the
+// response has been fully relayed
+type Exchange struct {
+	Model            string
+	Stream           bool
+	Temperature      *float64
+	Prompt           string    // last user message, for history and drift
+	Messages         []Message // every request message, as text
+	Response         string    // generated text
+	PromptTokens     int       // from usage if the backend reported it, else estimated
+	CompletionTokens int
+	StatusCode       int
+	Latency          time.Duration
+	FirstToken       time.Duration // zero for non-streaming or empty responses
+	Err              error         // transport error talking to the backend
+	// Enforced is why the proxy cut the stream itself, "stop" or "length",
+	// when the backend ignored the request's stop sequences or max_tokens
+	Enforced string
+}
+
+// Message is a request message with its content flattened to text
+type Message struct {
+	Role    string
+	Content string
+}
+
+// ChatCompletions is an OpenAI-compatible POST /v1/chat/completions handler
+// that relays requests to the backend unchanged, so any SDK can use aiwatch
+// as a drop-in proxy, and reports each exchange to Observe
+type ChatCompletions struct {
+	BaseURL string // backend base URL, e.g. http://model-runner/engines/v1/
+	APIKey  string
+	// Key, if set, returns the API key for each request in place of APIKey,
+	// so a rotated key takes effect without a restart
+	Key     func() string
+	Client  *http.Client
+	Observe func(*http.Request, Exchange)
+	// Received, if set, is called with the parsed request before it is
+	// forwarded
+	Received func(*http.Request, Exchange)
+	// Allow, if set, reports whether the caller may use a model; requests
+	// for other models are rejected with 403
+	Allow func(r *http.Request, model string) bool
+	// EnforceStops, if set, reports whether to enforce stop sequences and
+	// max_tokens on streamed responses in case the backend ignores them
+	EnforceStops func() bool
+}
+
+// chatRequest holds the fields the proxy inspects; the body is forwarded as-is
+type chatRequest struct {
+	Model       string          `json:"model"`
+	Stream      bool            `json:"stream"`
+	Temperature *float64        `json:"temperature"`
+	Stop        json.RawMessage `json:"stop"`
+	MaxTokens   int             `json:"max_tokens"`
+	// MaxCompletionTokens replaces max_tokens in newer clients
+	MaxCompletionTokens int `json:"max_completion_tokens"`
+	N                   int `json:"n"`
+	Messages            []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+}
+
+// scanner returns a scanner enforcing the request's stop sequences and
+// token limit, or nil if there are none. Requests for several choices are
+// left to the backend, since cutting the stream would end them all.
+func (req chatRequest) scanner() *stops.Scanner {
+	if req.N > 1 {
+		return nil
+	}
+	var sequences []string
+	var one string
+	if json.Unmarshal(req.Stop, &one) == nil {
+		sequences = []string{one}
+	} else {
+		json.Unmarshal(req.Stop, &sequences)
+	}
+	maxTokens := req.MaxCompletionTokens
+	if maxTokens <= 0 {
+		maxTokens = req.MaxTokens
+	}
+	return stops.New(sequences, maxTokens)
+}
+
+// usage is the OpenAI token usage object
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (h *ChatCompletions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Failed to read request body")
+		return
+	}
+	var req chatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Request body must be a JSON chat completion request")
+		return
+	}
+	if req.Model == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "model is required")
+		return
+	}
+	if h.Allow != nil && !h.Allow(r, req.Model) {
+		writeModelNotAllowed(w, req.Model)
+		return
+	}
+
+	ex := Exchange{Model: req.Model, Stream: req.Stream, Temperature: req.Temperature}
+	promptChars := 0
+	for _, msg := range req.Messages {
+		text := messageText(msg.Content)
+		promptChars += len(text)
+		ex.Messages = append(ex.Messages, Message{Role: msg.Role, Content: text})
+		if msg.Role == "user" {
+			ex.Prompt = text
+		}
+	}
+
+	if h.Received != nil {
+		h.Received(r, ex)
+	}
+
+	start := time.Now()
+	resp, err := post(r, h.Client, h.BaseURL, key(h.APIKey, h.Key), "/chat/completions", "application/json", bytes.NewReader(body))
+	if err != nil {
+		ex.Err = err
+		ex.StatusCode = http.StatusBadGateway
+		ex.Latency = time.Since(start)
+		h.observe(r, ex)
+		log := logger.GetLogger()
+		log.Error().Err(err).Str("model", req.Model).Msg("Upstream chat completion failed")
+		writeError(w, http.StatusBadGateway, "upstream_error", "The model backend is unavailable")
+		return
+	}
+	defer resp.Body.Close()
+
+	ex.StatusCode = resp.StatusCode
+	copyHeaders(w, resp)
+	w.WriteHeader(resp.StatusCode)
+
+	var tokens usage
+	if req.Stream && resp.StatusCode == http.StatusOK {
+		var scanner *stops.Scanner
+		if h.EnforceStops != nil && h.EnforceStops() {
+			scanner = req.scanner()
+		}
+		ex.Response, tokens, ex.FirstToken = relayStream(w, resp.Body, start, scanner)
+		if scanner != nil {
+			ex.Enforced = scanner.Reason()
+		}
+	} else {
+		ex.Response, tokens = relayJSON(w, resp.Body)
+	}
+	ex.Latency = time.Since(start)
+
+	// Fall back to the same rough estimate /chat uses when the backend
+	// doesn't report usage
+	ex.PromptTokens, ex.CompletionTokens = tokens.PromptTokens, tokens.CompletionTokens
+	if ex.PromptTokens == 0 {
+		ex.PromptTokens = promptChars / 4
+	}
+	if ex.CompletionTokens == 0 {
+		ex.CompletionTokens = len(ex.Response) / 4
+	}
+	h.observe(r, ex)
+}
+
+func (h *ChatCompletions) observe(r *http.Request, ex Exchange) {
+	if h.Observe != nil {
+		h.Observe(r, ex)
+	}
+}
+
+// post sends body to path under the backend base URL with the caller's context
+func post(r *http.Request, client *http.Client, baseURL, apiKey, path, contentType string, body io.Reader) (*http.Response, error) {
+	upstream, err := http.NewRequestWithContext(r.Context(), http.MethodPost, strings.TrimSuffix(baseURL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	upstream.Header.Set("Content-Type", contentType)
+	if apiKey != "" {
+		upstream.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(upstream)
+}
+
+// key returns the current API key
+func key(apiKey string, current func() string) string {
+	if current != nil {
+		return current()
+	}
+	return apiKey
+}
+
+// copyHeaders copies the response headers clients rely on
+func copyHeaders(w http.ResponseWriter, resp *http.Response) {
+	for _, key := range []string{"Content-Type", "Cache-Control"} {
+		if v := resp.Header.Get(key); v != "" {
+			w.Header().Set(key, v)
+		}
+	}
+}
+
+// relayStream copies an SSE completion stream to w line by line, flushing
+// each event, while collecting the generated text and any usage report. With
+// a scanner, content is rewritten to honour the stop sequences and token
+// limit, and the stream ends as soon as the scanner cuts it.
+func relayStream(w http.ResponseWriter, body io.Reader, start time.Time, scanner *stops.Scanner) (string, usage, time.Duration) {
+	flusher, _ := w.(http.Flusher)
+	var (
+		text       strings.Builder
+		tokens     usage
+		firstToken time.Duration
+		last       map[string]interface{} // the latest chunk, to copy for held back text
+	)
+
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && scanner != nil {
+			var done bool
+			line, last, done = enforce(line, last, scanner, &text)
+			if done {
+				w.Write(line)
+				w.Write([]byte("\ndata: [DONE]\n\n"))
+				break
+			}
+		}
+		if len(line) > 0 {
+			if _, werr := w.Write(line); werr != nil {
+				break
+			}
+			if data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:")); ok {
+				var chunk struct {
+					Choices []struct {
+						Delta struct {
+							Content string `json:"content"`
+						} `json:"delta"`
+					} `json:"choices"`
+					Usage *usage `json:"usage"`
+				}
+				if json.Unmarshal(bytes.TrimSpace(data), &chunk) == nil {
+					for _, choice := range chunk.Choices {
+						if choice.Delta.Content != "" && firstToken == 0 {
+							firstToken = time.Since(start)
+						}
+						if scanner == nil {
+							text.WriteString(choice.Delta.Content)
+						}
+					}
+					if chunk.Usage != nil {
+						tokens = *chunk.Usage
+					}
+				}
+			}
+			// Flush at the end of each event
+			if flusher != nil && len(bytes.TrimSpace(line)) == 0 {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return text.String(), tokens, firstToken
+}
+
+// enforce runs one line of a completion stream through the scanner. It
+// returns the line to relay, which has the released text in place of the
+// chunk's content, and whether the scanner cut the stream there.
+func enforce(line []byte, last map[string]interface{}, scanner *stops.Scanner, text *strings.Builder) ([]byte, map[string]interface{}, bool) {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !ok {
+		return line, last, false
+	}
+	data = bytes.TrimSpace(data)
+
+	// Release any held back text before the backend's end of stream
+	if bytes.Equal(data, []byte("[DONE]")) {
+		if rest := scanner.Flush(); rest != "" && last != nil {
+			text.WriteString(rest)
+			return append(append(encodeChunk(last, rest, ""), '\n'), line...), last, false
+		}
+		return line, last, false
+	}
+
+	var chunk map[string]interface{}
+	if json.Unmarshal(data, &chunk) != nil {
+		return line, last, false
+	}
+	choices, _ := chunk["choices"].([]interface{})
+	if len(choices) == 0 {
+		return line, last, false
+	}
+	choice, _ := choices[0].(map[string]interface{})
+	delta, _ := choice["delta"].(map[string]interface{})
+	content, _ := delta["content"].(string)
+	finish, _ := choice["finish_reason"].(string)
+	last = chunk
+	if content == "" && finish == "" {
+		return line, last, false
+	}
+
+	out, done := "", false
+	if content != "" {
+		out, done = scanner.Write(content)
+	}
+	if done {
+		finish = scanner.Reason()
+	} else if finish != "" {
+		out += scanner.Flush()
+	}
+	text.WriteString(out)
+	return encodeChunk(chunk, out, finish), last, done
+}
+
+// encodeChunk returns a copy of chunk as an SSE data line with its first choice's
+// content and finish reason replaced
+func encodeChunk(chunk map[string]interface{}, content, finish string) []byte {
+	copied := make(map[string]interface{}, len(chunk))
+	for k, v := range chunk {
+		copied[k] = v
+	}
+	choice := map[string]interface{}{}
+	if choices, _ := chunk["choices"].([]interface{}); len(choices) > 0 {
+		if first, ok := choices[0].(map[string]interface{}); ok {
+			for k, v := range first {
+				choice[k] = v
+			}
+		}
+	}
+	delta := map[string]interface{}{}
+	if first, ok := choice["delta"].(map[string]interface{}); ok {
+		for k, v := range first {
+			delta[k] = v
+		}
+	}
+	delta["content"] = content
+	choice["delta"] = delta
+	if finish != "" {
+		choice["finish_reason"] = finish
+	} else {
+		choice["finish_reason"] = nil
+	}
+	copied["choices"] = []interface{}{choice}
+	data, _ := json.Marshal(copied)
+	return append(append([]byte("data: "), data...), '\n')
+}
+
+// relayJSON copies a non-streaming response to w and extracts the
+// generated text and usage from it
+func relayJSON(w io.Writer, body io.Reader) (string, usage) {
+	var buf bytes.Buffer
+	io.Copy(w, io.TeeReader(body, &buf))
+
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage *usage `json:"usage"`
+	}
+	if json.Unmarshal(buf.Bytes(), &completion) != nil {
+		return "", usage{}
+	}
+
+	var text strings.Builder
+	for _, choice := range completion.Choices {
+		text.WriteString(choice.Message.Content)
+	}
+	var tokens usage
+	if completion.Usage != nil {
+		tokens = *completion.Usage
+	}
+	return text.String(), tokens
+}
+
+// messageText returns the text of a message whose content is either a
+// string or an array of content parts
+func messageText(content json.RawMessage) string {
+	var s string
+	if json.Unmarshal(content, &s) == nil {
+		return s
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(content, &parts) != nil {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range parts {
+		if part.Type == "text" {
+			text.WriteString(part.Text)
+		}
+	}
+	return text.String()
+}
+
+// writeError writes an OpenAI-style error response
+func writeError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error":{"message":%q,"type":%q}}`, message, errType)
+}
+
+// writeModelNotAllowed rejects a request for a model the caller may not use
+func writeModelNotAllowed(w http.ResponseWriter, model string) {
+	writeError(w, http.StatusForbidden, "permission_error", fmt.Sprintf("Model %s is not available to this API key", model))
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ajeetraina/aiwatch/internal/testsupport"
+)
+
+func TestChatCompletionsRelaysAndObserves(t *testing.T) {
+	backend := testsupport.NewFakeBackend("Hello", ", world")
+	defer backend.Close()
+
+	for _, stream := range []bool{false, true} {
+		var got Exchange
+		h := &ChatCompletions{
+			BaseURL: backend.BaseURL(),
+			Observe: func(_ *http.Request, ex Exchange) { got = ex },
+		}
+
+		body := `{"model":"ai/fake-model","temperature":0.2,"extra_field":1,"messages":[{"role":"user","content":"hi there"}]`
+		if stream {
+			body += `,"stream":true`
+		}
+		body += "}"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("stream=%v: status %d: %s", stream, rec.Code, rec.Body.String())
+		}
+		out, _ := io.ReadAll(rec.Body)
+		if stream && !strings.Contains(string(out), "data: [DONE]") {
+			t.Errorf("stream=%v: expected SSE passthrough, got %q", stream, out)
+		}
+		if got.Response != "Hello, world" || got.Prompt != "hi there" || got.Stream != stream {
+			t.Errorf("stream=%v: unexpected exchange %+v", stream, got)
+		}
+		if got.Temperature == nil || *got.Temperature != 0.2 || got.CompletionTokens == 0 {
+			t.Errorf("stream=%v: expected temperature and token estimate, got %+v", stream, got)
+		}
+		if stream && got.FirstToken == 0 {
+			t.Errorf("expected first token latency for streaming request")
+		}
+	}
+
+	reqs := backend.Requests()
+	if len(reqs) != 2 || reqs[0]["extra_field"] == nil {
+		t.Errorf("expected request bodies forwarded unchanged, got %v", reqs)
+	}
+}
+
+func TestChatCompletionsUpstreamErrors(t *testing.T) {
+	backend := testsupport.NewFakeBackend("x")
+	backend.FailWith = http.StatusServiceUnavailable
+	defer backend.Close()
+
+	var got Exchange
+	h := &ChatCompletions{BaseURL: backend.BaseURL(), Observe: func(_ *http.Request, ex Exchange) { got = ex }}
+	body := `{"model":"ai/fake-model","messages":[{"role":"user","content":"hi"}]}`
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if rec.Code != http.StatusServiceUnavailable || got.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected upstream status passed through, got %d / %+v", rec.Code, got)
+	}
+
+	backend.Close()
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	if rec.Code != http.StatusBadGateway || got.Err == nil {
+		t.Errorf("expected 502 for unreachable backend, got %d / %+v", rec.Code, got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"messages":[]}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without model, got %d", rec.Code)
+	}
+}
+
+func TestChatCompletionsEnforcesStops(t *testing.T) {
+	backend := testsupport.NewFakeBackend("Hello", " wor", "ld", "END", "ignored")
+	defer backend.Close()
+
+	for body, want := range map[string]struct {
+		response, reason string
+	}{
+		`"stop":["END"]`:                  {"Hello world", "stop"},
+		`"stop":"orld"`:                   {"Hello w", "stop"},
+		`"max_tokens":2`:                  {"Hello wor", "length"},
+		`"stop":["nope"]`:                 {"Hello worldENDignored", ""},
+		`"stop":["END"],"n":2`:            {"Hello worldENDignored", ""},
+		`"max_completion_tokens":1,"n":1`: {"Hello", "length"},
+	} {
+		var got Exchange
+		h := &ChatCompletions{
+			BaseURL:      backend.BaseURL(),
+			Observe:      func(_ *http.Request, ex Exchange) { got = ex },
+			EnforceStops: func() bool { return true },
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(
+			`{"model":"ai/fake-model","stream":true,"messages":[{"role":"user","content":"hi"}],`+body+`}`)))
+
+		if got.Response != want.response || got.Enforced != want.reason {
+			t.Errorf("%s: response %q cut for %q, want %q for %q", body, got.Response, got.Enforced, want.response, want.reason)
+		}
+		out := rec.Body.String()
+		if !strings.HasSuffix(out, "data: [DONE]\n\n") {
+			t.Errorf("%s: stream does not end with [DONE]: %q", body, out)
+		}
+		if want.reason != "" && (strings.Contains(out, "ignored") || !strings.Contains(out, `"finish_reason":"`+want.reason+`"`)) {
+			t.Errorf("%s: stream not cut: %q", body, out)
+		}
+	}
+}
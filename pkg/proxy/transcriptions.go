@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+)
+
+// maxAudioBytes matches the OpenAI upload limit for transcriptions
+const maxAudioBytes = 25 << 20
+
+// TranscriptionExchange describes one proxied transcription request
+type TranscriptionExchange struct {
+	Model         string
+	AudioDuration time.Duration // zero when it could not be determined
+	StatusCode    int
+	Latency       time.Duration
+	Err           error // transport error talking to the backend
+}
+
+// RealtimeFactor returns processing time divided by audio duration; below 1
+// means faster than realtime. It is zero when the duration is unknown.
+func (ex TranscriptionExchange) RealtimeFactor() float64 {
+	if ex.AudioDuration <= 0 {
+		return 0
+	}
+	return ex.Latency.Seconds() / ex.AudioDuration.Seconds()
+}
+
+// Transcriptions is a Whisper-compatible POST /v1/audio/transcriptions handler
+// that relays multipart uploads to the backend and reports each exchange to
+// Observe
+type Transcriptions struct {
+	BaseURL string // e.g. a whisper.cpp server's OpenAI-compatible /v1/ URL
+	APIKey  string
+	Key     func() string // see ChatCompletions.Key
+	Client  *http.Client
+	Observe func(*http.Request, TranscriptionExchange)
+	Allow   func(r *http.Request, model string) bool // see ChatCompletions.Allow
+}
+
+func (h *Transcriptions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+		return
+	}
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Request must be multipart/form-data")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAudioBytes+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Failed to read request body")
+		return
+	}
+	if len(body) > maxAudioBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, "invalid_request_error", "Audio file exceeds 25 MB")
+		return
+	}
+
+	var ex TranscriptionExchange
+	var audio []byte
+	form := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := form.NextPart()
+		if err != nil {
+			break
+		}
+		switch part.FormName() {
+		case "model":
+			value, _ := io.ReadAll(part)
+			ex.Model = strings.TrimSpace(string(value))
+		case "file":
+			audio, _ = io.ReadAll(part)
+		}
+	}
+	if audio == nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "file is required")
+		return
+	}
+	if ex.Model == "" {
+		ex.Model = "unknown"
+	}
+	if h.Allow != nil && !h.Allow(r, ex.Model) {
+		writeModelNotAllowed(w, ex.Model)
+		return
+	}
+	ex.AudioDuration, _ = wavDuration(audio)
+
+	start := time.Now()
+	resp, err := post(r, h.Client, h.BaseURL, key(h.APIKey, h.Key), "/audio/transcriptions", r.Header.Get("Content-Type"), bytes.NewReader(body))
+	if err != nil {
+		ex.Err = err
+		ex.StatusCode = http.StatusBadGateway
+		ex.Latency = time.Since(start)
+		h.observe(r, ex)
+		log := logger.GetLogger()
+		log.Error().Err(err).Str("model", ex.Model).Msg("Upstream transcription failed")
+		writeError(w, http.StatusBadGateway, "upstream_error", "The transcription backend is unavailable")
+		return
+	}
+	defer resp.Body.Close()
+
+	ex.StatusCode = resp.StatusCode
+	copyHeaders(w, resp)
+	w.WriteHeader(resp.StatusCode)
+
+	var buf bytes.Buffer
+	io.Copy(w, io.TeeReader(resp.Body, &buf))
+	ex.Latency = time.Since(start)
+
+	// verbose_json responses report the duration for any audio format
+	var result struct {
+		Duration float64 `json:"duration"`
+	}
+	if json.Unmarshal(buf.Bytes(), &result) == nil && result.Duration > 0 {
+		ex.AudioDuration = time.Duration(result.Duration * float64(time.Second))
+	}
+	h.observe(r, ex)
+}
+
+func (h *Transcriptions) observe(r *http.Request, ex TranscriptionExchange) {
+	if h.Observe != nil {
+		h.Observe(r, ex)
+	}
+}
+
+// wavDuration reads the duration of a RIFF/WAVE file from its fmt and data
+// chunk headers
+func wavDuration(audio []byte) (time.Duration, error) {
+	if len(audio) < 12 || string(audio[0:4]) != "RIFF" || string(audio[8:12]) != "WAVE" {
+		return 0, errors.New("not a WAV file")
+	}
+
+	var byteRate uint32
+	for offset := 12; offset+8 <= len(audio); {
+		id := string(audio[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(audio[offset+4 : offset+8]))
+		data := offset + 8
+		switch id {
+		case "fmt ":
+			if data+12 > len(audio) {
+				return 0, errors.New("truncated fmt chunk")
+			}
+			byteRate = binary.LittleEndian.Uint32(audio[data+8 : data+12])
+		case "data":
+			if byteRate == 0 {
+				return 0, errors.New("data chunk before fmt chunk")
+			}
+			return time.Duration(float64(size) / float64(byteRate) * float64(time.Second)), nil
+		}
+		// Chunks are padded to an even size
+		offset = data + size + size%2
+	}
+	return 0, errors.New("no data chunk")
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testWAV builds a mono 16-bit WAV file of the given length
+func testWAV(seconds int) []byte {
+	const sampleRate = 8000
+	dataSize := uint32(seconds * sampleRate * 2)
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, 36+dataSize)
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, []uint32{16})
+	binary.Write(&b, binary.LittleEndian, []uint16{1, 1})
+	binary.Write(&b, binary.LittleEndian, []uint32{sampleRate, sampleRate * 2})
+	binary.Write(&b, binary.LittleEndian, []uint16{2, 16})
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, dataSize)
+	b.Write(make([]byte, dataSize))
+	return b.Bytes()
+}
+
+func TestTranscriptionsRelaysAndObserves(t *testing.T) {
+	var forwarded []byte
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"text":"hello"}`))
+	}))
+	defer backend.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("model", "whisper-1")
+	file, _ := form.CreateFormFile("file", "clip.wav")
+	file.Write(testWAV(3))
+	form.Close()
+
+	var got TranscriptionExchange
+	h := &Transcriptions{BaseURL: backend.URL, Observe: func(_ *http.Request, ex TranscriptionExchange) { got = ex }}
+	req := httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", bytes.NewReader(body.Bytes()))
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "hello") {
+		t.Fatalf("expected relayed transcript, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !bytes.Equal(forwarded, body.Bytes()) {
+		t.Errorf("expected multipart body forwarded unchanged")
+	}
+	if got.Model != "whisper-1" || got.AudioDuration != 3*time.Second || got.RealtimeFactor() <= 0 {
+		t.Errorf("unexpected exchange %+v", got)
+	}
+}
+
+func TestTranscriptionsRequiresFile(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("model", "whisper-1")
+	form.Close()
+
+	h := &Transcriptions{BaseURL: "http://127.0.0.1:0"}
+	req := httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a file, got %d", rec.Code)
+	}
+}
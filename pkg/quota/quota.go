@@ -0,0 +1,398 @@
+package quota
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/session"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Limits are the quotas applied to a single client. Zero means unlimited.
+type Limits struct {
+	TokensPerDay    int `json:"tokens_per_day"`
+	RequestsPerHour int `json:"requests_per_hour"`
+}
+
+// Config holds default limits and per-key overrides
+type Config struct {
+	Default Limits            `json:"default"`
+	Keys    map[string]Limits `json:"keys"`
+}
+
+// Usage is the current consumption of a client against its limits
+type Usage struct {
+	Identity          string    `json:"identity"`
+	RequestsLastHour  int       `json:"requests_last_hour"`
+	TokensToday       int       `json:"tokens_today"`
+	Limits            Limits    `json:"limits"`
+	RequestsRemaining int       `json:"requests_remaining,omitempty"`
+	TokensRemaining   int       `json:"tokens_remaining,omitempty"`
+	ResetsAt          time.Time `json:"tokens_reset_at"`
+}
+
+// clientUsage is the tracked state for one identity
+type clientUsage struct {
+	requests []time.Time
+	day      string
+	tokens   int
+	tenant   string // the tenant the identity's tokens also count against
+}
+
+// Tenants partitions callers into tenants, each with limits shared by all
+// of its callers and default limits for each of its keys
+type Tenants interface {
+	TenantOf(r *http.Request) string
+	TenantLimits(tenant string) (shared, perKey Limits)
+	HasKey(key string) bool
+}
+
+// idleSweep is how often clients with no usage left to track are forgotten
+const idleSweep = time.Minute
+
+type contextKey struct{}
+
+// Enforcer tracks usage per client and enforces quotas
+type Enforcer struct {
+	config     Config
+	rejections *prometheus.CounterVec // labels: limit
+
+	// Tenants, if set, also counts each request and its tokens against the
+	// caller's tenant
+	Tenants Tenants
+
+	mu        sync.Mutex
+	clients   map[string]*clientUsage
+	lastSweep time.Time
+}
+
+// New creates an enforcer
+func New(config Config, rejections *prometheus.CounterVec) *Enforcer {
+	if config.Keys == nil {
+		config.Keys = make(map[string]Limits)
+	}
+	return &Enforcer{
+		config:     config,
+		rejections: rejections,
+		clients:    make(map[string]*clientUsage),
+	}
+}
+
+// LoadConfig reads per-key limits from a JSON file, if path is set, on top of
+// the given defaults
+func LoadConfig(path string, defaults Limits) (Config, error) {
+	cfg := Config{Default: defaults}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read quota config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse quota config: %w", err)
+	}
+	return cfg, nil
+}
+
+// APIKey returns the API key presented by the request, if any
+func APIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// Identify returns the API key presented by the request, falling back to the
+// session ID, then the client's address. Callers choose both the key and the
+// session, so quotas are charged to Enforcer.Identify instead.
+func Identify(r *http.Request) string {
+	if key := APIKey(r); key != "" {
+		return key
+	}
+	if id := session.FromContext(r.Context()); id != "" {
+		return "session:" + id
+	}
+	return anonymous(r)
+}
+
+// Identify returns the usage bucket a request is charged to: its API key if
+// the key has limits configured or belongs to a tenant, else the client's
+// address, so unknown keys and fresh sessions don't reset the quota
+func (e *Enforcer) Identify(r *http.Request) string {
+	if key := APIKey(r); e.KnownKey(key) {
+		return key
+	}
+	return anonymous(r)
+}
+
+// KnownKey reports whether key has limits configured or belongs to a tenant
+func (e *Enforcer) KnownKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	if _, ok := e.config.Keys[key]; ok {
+		return true
+	}
+	return e.Tenants != nil && e.Tenants.HasKey(key)
+}
+
+// anonymous identifies a request by its client's host, without the port,
+// which changes with every connection
+func anonymous(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "anonymous:" + host
+}
+
+// FromContext returns the identity stored by the middleware
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// limitsFor returns the limits for identity, then those of its tenant's keys
+func (e *Enforcer) limitsFor(identity, tenant string) Limits {
+	if limits, ok := e.config.Keys[identity]; ok {
+		return limits
+	}
+	if e.Tenants != nil && tenant != "" {
+		if _, perKey := e.Tenants.TenantLimits(tenant); perKey != (Limits{}) {
+			return perKey
+		}
+	}
+	return e.config.Default
+}
+
+// tenantIdentity is the usage bucket shared by a tenant's callers
+func tenantIdentity(tenant string) string {
+	return "tenant:" + tenant
+}
+
+// Middleware rejects requests from clients over quota with 429 and reports
+// the remaining quota in response headers
+func (e *Enforcer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity := e.Identify(r)
+		tenant := ""
+		if e.Tenants != nil {
+			tenant = e.Tenants.TenantOf(r)
+		}
+		usage, exceeded := e.admit(identity, tenant)
+		setHeaders(w, usage)
+
+		if exceeded != "" {
+			if e.rejections != nil {
+				e.rejections.WithLabelValues(exceeded).Inc()
+			}
+			retryAfter := time.Hour
+			if strings.HasSuffix(exceeded, "tokens_per_day") {
+				retryAfter = time.Until(usage.ResetsAt)
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": apierror.New(r, apierror.RateLimited, fmt.Sprintf("quota exceeded: %s", exceeded)),
+				"usage": usage,
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, identity)))
+	})
+}
+
+// admit checks identity, and its tenant if any, against their limits and, if
+// allowed, counts the request. It returns the name of the exceeded limit,
+// prefixed with "tenant_" for the tenant's shared limits, or "" when admitted.
+func (e *Enforcer) admit(identity, tenant string) (Usage, string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sweep(time.Now())
+
+	c := e.client(identity)
+	c.tenant = tenant
+	limits := e.limitsFor(identity, tenant)
+	exceeded := exceeds(c, limits)
+
+	var shared *clientUsage
+	if exceeded == "" && tenant != "" {
+		sharedLimits, _ := e.Tenants.TenantLimits(tenant)
+		shared = e.client(tenantIdentity(tenant))
+		if over := exceeds(shared, sharedLimits); over != "" {
+			exceeded = "tenant_" + over
+			return e.usage(tenantIdentity(tenant), shared, sharedLimits), exceeded
+		}
+	}
+
+	if exceeded == "" {
+		c.requests = append(c.requests, time.Now())
+		if shared != nil {
+			shared.requests = append(shared.requests, time.Now())
+		}
+	}
+	return e.usage(identity, c, limits), exceeded
+}
+
+// exceeds returns the name of the limit c is over, or ""
+func exceeds(c *clientUsage, limits Limits) string {
+	if limits.RequestsPerHour > 0 && len(c.requests) >= limits.RequestsPerHour {
+		return "requests_per_hour"
+	}
+	if limits.TokensPerDay > 0 && c.tokens >= limits.TokensPerDay {
+		return "tokens_per_day"
+	}
+	return ""
+}
+
+// RecordTokens adds consumed tokens to identity's daily total, and to its
+// tenant's
+func (e *Enforcer) RecordTokens(identity string, tokens int) {
+	if identity == "" {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := e.client(identity)
+	c.tokens += tokens
+	if c.tenant != "" {
+		e.client(tenantIdentity(c.tenant)).tokens += tokens
+	}
+}
+
+// Usage returns the current usage for identity
+func (e *Enforcer) Usage(identity string) Usage {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := e.client(identity)
+	return e.usage(identity, c, e.limitsFor(identity, c.tenant))
+}
+
+// TenantUsage returns the current usage of a tenant's shared limits
+func (e *Enforcer) TenantUsage(tenant string) Usage {
+	var limits Limits
+	if e.Tenants != nil {
+		limits, _ = e.Tenants.TenantLimits(tenant)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.usage(tenantIdentity(tenant), e.client(tenantIdentity(tenant)), limits)
+}
+
+// client returns the rolled-over state for identity. Callers must hold e.mu.
+func (e *Enforcer) client(identity string) *clientUsage {
+	c, ok := e.clients[identity]
+	if !ok {
+		c = &clientUsage{}
+		e.clients[identity] = c
+	}
+	c.roll(time.Now())
+	return c
+}
+
+// sweep forgets clients with no requests in the last hour and no tokens
+// today, at most once per idleSweep. Callers must hold e.mu.
+func (e *Enforcer) sweep(now time.Time) {
+	if now.Sub(e.lastSweep) < idleSweep {
+		return
+	}
+	e.lastSweep = now
+	for identity, c := range e.clients {
+		if c.roll(now); len(c.requests) == 0 && c.tokens == 0 {
+			delete(e.clients, identity)
+		}
+	}
+}
+
+// roll drops requests older than an hour and yesterday's tokens
+func (c *clientUsage) roll(now time.Time) {
+	now = now.UTC()
+	if today := now.Format("2006-01-02"); c.day != today {
+		c.day = today
+		c.tokens = 0
+	}
+
+	cutoff := now.Add(-time.Hour)
+	i := 0
+	for i < len(c.requests) && c.requests[i].Before(cutoff) {
+		i++
+	}
+	c.requests = c.requests[i:]
+}
+
+// usage builds the usage report. Callers must hold e.mu.
+func (e *Enforcer) usage(identity string, c *clientUsage, limits Limits) Usage {
+	now := time.Now().UTC()
+	u := Usage{
+		Identity:         Mask(identity),
+		RequestsLastHour: len(c.requests),
+		TokensToday:      c.tokens,
+		Limits:           limits,
+		ResetsAt:         time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC),
+	}
+	if limits.RequestsPerHour > 0 {
+		u.RequestsRemaining = max(limits.RequestsPerHour-u.RequestsLastHour, 0)
+	}
+	if limits.TokensPerDay > 0 {
+		u.TokensRemaining = max(limits.TokensPerDay-u.TokensToday, 0)
+	}
+	return u
+}
+
+// HandleUsage serves GET /usage for the calling client
+func (e *Enforcer) HandleUsage(w http.ResponseWriter, r *http.Request) {
+	usage := e.Usage(e.Identify(r))
+	setHeaders(w, usage)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// setHeaders reports remaining quota on the response
+func setHeaders(w http.ResponseWriter, u Usage) {
+	if u.Limits.RequestsPerHour > 0 {
+		w.Header().Set("X-Quota-Requests-Remaining", strconv.Itoa(u.RequestsRemaining))
+	}
+	if u.Limits.TokensPerDay > 0 {
+		w.Header().Set("X-Quota-Tokens-Remaining", strconv.Itoa(u.TokensRemaining))
+		w.Header().Set("X-Quota-Reset", u.ResetsAt.Format(time.RFC3339))
+	}
+}
+
+// Digest identifies an API key without revealing it, as "key:" and the hex
+// of the first 8 bytes of its SHA-256, for records kept about its caller
+func Digest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:8])
+}
+
+// Mask hides most of an API key so usage reports and events don't leak it
+func Mask(identity string) string {
+	if strings.Contains(identity, ":") || len(identity) <= 8 {
+		return identity
+	}
+	return identity[:4] + "…" + identity[len(identity)-4:]
+}
@@ -0,0 +1,211 @@
+package quota
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/session"
+)
+
+// fakeTenants puts every key in keys in tenant "acme"
+type fakeTenants struct {
+	keys   map[string]bool
+	shared Limits
+}
+
+func (f fakeTenants) TenantOf(r *http.Request) string {
+	if f.keys[APIKey(r)] {
+		return "acme"
+	}
+	return ""
+}
+
+func (f fakeTenants) TenantLimits(tenant string) (shared, perKey Limits) {
+	return f.shared, Limits{}
+}
+
+func (f fakeTenants) HasKey(key string) bool {
+	return f.keys[key]
+}
+
+func TestIdentify(t *testing.T) {
+	e := New(Config{Keys: map[string]Limits{"configured-key": {RequestsPerHour: 10}}}, nil)
+	e.Tenants = fakeTenants{keys: map[string]bool{"tenant-key": true}}
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		value      string
+		want       string
+	}{
+		{"configured key", "10.0.0.1:5000", "X-API-Key", "configured-key", "configured-key"},
+		{"configured bearer token", "10.0.0.1:5000", "Authorization", "Bearer configured-key", "configured-key"},
+		{"tenant key", "10.0.0.1:5000", "X-API-Key", "tenant-key", "tenant-key"},
+		{"unknown key", "10.0.0.1:5000", "X-API-Key", "made-up", "anonymous:10.0.0.1"},
+		{"session", "10.0.0.1:5001", session.HeaderName, "fresh-session", "anonymous:10.0.0.1"},
+		{"no credentials", "10.0.0.1:5002", "", "", "anonymous:10.0.0.1"},
+		{"IPv6", "[2001:db8::1]:443", "", "", "anonymous:2001:db8::1"},
+		{"no port", "10.0.0.2", "", "", "anonymous:10.0.0.2"},
+	}
+	tracker := session.NewTracker(time.Minute)
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/chat", nil)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.header != "" {
+			r.Header.Set(tc.header, tc.value)
+		}
+		var got string
+		tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = e.Identify(r)
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		if got != tc.want {
+			t.Errorf("%s: identified as %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	for key, want := range map[string]bool{"configured-key": true, "tenant-key": true, "made-up": false, "": false} {
+		if got := e.KnownKey(key); got != want {
+			t.Errorf("KnownKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestMiddlewareRejectsOverQuota(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   Config
+		tenants  Tenants
+		key      string
+		tokens   int
+		requests int
+		want     []int
+		limit    string
+	}{
+		{
+			name:     "requests per hour",
+			config:   Config{Default: Limits{RequestsPerHour: 2}},
+			requests: 3,
+			want:     []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+			limit:    "requests_per_hour",
+		},
+		{
+			name:     "tokens per day",
+			config:   Config{Default: Limits{TokensPerDay: 100}},
+			tokens:   100,
+			requests: 2,
+			want:     []int{http.StatusOK, http.StatusTooManyRequests},
+			limit:    "tokens_per_day",
+		},
+		{
+			name:     "configured key has its own limits",
+			config:   Config{Default: Limits{RequestsPerHour: 1}, Keys: map[string]Limits{"big": {RequestsPerHour: 3}}},
+			key:      "big",
+			requests: 4,
+			want:     []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+			limit:    "requests_per_hour",
+		},
+		{
+			name:     "tenant shared limits",
+			config:   Config{},
+			tenants:  fakeTenants{keys: map[string]bool{"acme-1": true}, shared: Limits{RequestsPerHour: 1}},
+			key:      "acme-1",
+			requests: 2,
+			want:     []int{http.StatusOK, http.StatusTooManyRequests},
+			limit:    "tenant_requests_per_hour",
+		},
+	}
+	for _, tc := range cases {
+		e := New(tc.config, nil)
+		if tc.tenants != nil {
+			e.Tenants = tc.tenants
+		}
+		h := e.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			e.RecordTokens(FromContext(r.Context()), tc.tokens)
+		}))
+		var last *httptest.ResponseRecorder
+		for i := 0; i < tc.requests; i++ {
+			r := httptest.NewRequest(http.MethodPost, "/chat", nil)
+			// A new connection, session and key each time must not reset the quota
+			r.RemoteAddr = "10.0.0.1:" + strconv.Itoa(5000+i)
+			if tc.key != "" {
+				r.Header.Set("X-API-Key", tc.key)
+			} else {
+				r.Header.Set("X-API-Key", "made-up-"+strconv.Itoa(i))
+			}
+			last = httptest.NewRecorder()
+			h.ServeHTTP(last, r)
+			if last.Code != tc.want[i] {
+				t.Fatalf("%s: request %d got %d, want %d", tc.name, i+1, last.Code, tc.want[i])
+			}
+		}
+		if last.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected Retry-After on 429", tc.name)
+		}
+		var body struct {
+			Error apierror.Error `json:"error"`
+			Usage *Usage         `json:"usage"`
+		}
+		json.Unmarshal(last.Body.Bytes(), &body)
+		if body.Error.Code != apierror.RateLimited || !strings.Contains(body.Error.Message, tc.limit) || body.Usage == nil {
+			t.Errorf("%s: expected a rate_limited error naming %s with the usage, got %s", tc.name, tc.limit, last.Body)
+		}
+	}
+}
+
+func TestUsageRollsOver(t *testing.T) {
+	e := New(Config{Default: Limits{TokensPerDay: 100, RequestsPerHour: 10}}, nil)
+	now := time.Now()
+	e.clients["anonymous:10.0.0.1"] = &clientUsage{
+		requests: []time.Time{now.Add(-2 * time.Hour), now.Add(-time.Minute)},
+		day:      now.UTC().Format("2006-01-02"),
+		tokens:   60,
+	}
+	e.clients["anonymous:10.0.0.2"] = &clientUsage{
+		requests: []time.Time{now.Add(-time.Minute)},
+		day:      now.UTC().AddDate(0, 0, -1).Format("2006-01-02"),
+		tokens:   100,
+	}
+
+	if u := e.Usage("anonymous:10.0.0.1"); u.RequestsLastHour != 1 || u.TokensToday != 60 {
+		t.Errorf("expected requests over an hour old to be dropped, got %+v", u)
+	}
+	if u := e.Usage("anonymous:10.0.0.2"); u.TokensToday != 0 || u.TokensRemaining != 100 {
+		t.Errorf("expected yesterday's tokens to be reset, got %+v", u)
+	}
+}
+
+func TestSweepForgetsIdleClients(t *testing.T) {
+	e := New(Config{}, nil)
+	now := time.Now()
+	today := now.UTC().Format("2006-01-02")
+	e.clients["idle"] = &clientUsage{requests: []time.Time{now.Add(-2 * time.Hour)}, day: today}
+	e.clients["yesterday"] = &clientUsage{day: now.UTC().AddDate(0, 0, -1).Format("2006-01-02"), tokens: 50}
+	e.clients["recent"] = &clientUsage{requests: []time.Time{now.Add(-time.Minute)}, day: today}
+	e.clients["tokens"] = &clientUsage{day: today, tokens: 10}
+
+	e.sweep(now)
+
+	for _, id := range []string{"idle", "yesterday"} {
+		if _, ok := e.clients[id]; ok {
+			t.Errorf("expected %s to be forgotten", id)
+		}
+	}
+	for _, id := range []string{"recent", "tokens"} {
+		if _, ok := e.clients[id]; !ok {
+			t.Errorf("expected %s to be kept", id)
+		}
+	}
+
+	e.clients["idle"] = &clientUsage{day: today}
+	e.sweep(now.Add(time.Second))
+	if _, ok := e.clients["idle"]; !ok {
+		t.Error("expected no sweep within idleSweep of the last")
+	}
+}
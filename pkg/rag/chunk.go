@@ -0,0 +1,61 @@
+package rag
+
+import (
+	"strings"
+)
+
+// charsPerToken matches the rough estimate used for truncation
+const charsPerToken = 4
+
+// Split cuts text into chunks of about size tokens, each starting overlap
+// tokens before the end of the previous one. Chunks break on paragraph,
+// sentence or word boundaries where possible.
+func Split(text string, size, overlap int) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if size <= 0 {
+		size = 300
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+	maxChars := size * charsPerToken
+	overlapChars := overlap * charsPerToken
+
+	var chunks []string
+	for start := 0; start < len(text); {
+		end := start + maxChars
+		if end >= len(text) {
+			chunks = append(chunks, strings.TrimSpace(text[start:]))
+			break
+		}
+		end = breakPoint(text, start, end)
+		chunks = append(chunks, strings.TrimSpace(text[start:end]))
+
+		next := end - overlapChars
+		if next <= start {
+			next = end
+		}
+		// Start the overlap on a word boundary
+		if i := strings.IndexAny(text[next:end], " \n"); i >= 0 && next != end {
+			next += i + 1
+		}
+		start = next
+	}
+	return chunks
+}
+
+// breakPoint moves end back to the last paragraph, sentence or word break in
+// the second half of text[start:end]
+func breakPoint(text string, start, end int) int {
+	window := text[start:end]
+	half := len(window) / 2
+	for _, sep := range []string{"\n\n", ". ", "\n", " "} {
+		if i := strings.LastIndex(window, sep); i >= half {
+			return start + i + len(sep)
+		}
+	}
+	return end
+}
@@ -0,0 +1,141 @@
+package rag
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/tenants"
+)
+
+// maxDocumentBytes caps an uploaded document
+const maxDocumentBytes = 20 << 20
+
+// Handler serves the document library: POST and GET /documents,
+// DELETE /documents/{id} and POST /documents/search. Each tenant sees and
+// searches only its own documents.
+func (x *Index) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /documents", x.handleIngest)
+	mux.HandleFunc("GET /documents", x.handleList)
+	mux.HandleFunc("DELETE /documents/{id}", x.handleDelete)
+	mux.HandleFunc("POST /documents/search", x.handleSearch)
+	return mux
+}
+
+// handleIngest accepts a JSON {"title", "text"} body, a raw text/plain or
+// application/pdf body, or a multipart upload in the "file" field
+func (x *Index) handleIngest(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxDocumentBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	title := r.URL.Query().Get("title")
+
+	var data []byte
+	var err error
+	switch mediaType {
+	case "application/json":
+		var body struct {
+			Title string `json:"title"`
+			Text  string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, "invalid request body")
+			return
+		}
+		if body.Title != "" {
+			title = body.Title
+		}
+		data = []byte(body.Text)
+	case "multipart/form-data":
+		file, header, ferr := r.FormFile("file")
+		if ferr != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, "missing file field")
+			return
+		}
+		defer file.Close()
+		if title == "" {
+			title = r.FormValue("title")
+		}
+		if title == "" {
+			title = strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
+		}
+		data, err = io.ReadAll(file)
+	default:
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.InvalidRequest, "failed to read document")
+		return
+	}
+
+	text, source := string(data), "text"
+	if bytes.HasPrefix(data, []byte("%PDF-")) {
+		source = "pdf"
+		if text, err = ExtractPDFText(data); err != nil {
+			apierror.WriteStatus(w, r, http.StatusUnprocessableEntity, apierror.InvalidRequest, err.Error())
+			return
+		}
+	}
+	if title == "" {
+		title = "untitled"
+	}
+
+	doc, err := x.Ingest(r.Context(), tenants.FromContext(r.Context()), title, source, text)
+	if errors.Is(err, ErrEmptyDocument) {
+		apierror.Write(w, r, apierror.InvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.UpstreamError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, doc)
+}
+
+func (x *Index) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, x.Documents(tenants.FromContext(r.Context())))
+}
+
+func (x *Index) handleDelete(w http.ResponseWriter, r *http.Request) {
+	err := x.Delete(r.Context(), tenants.FromContext(r.Context()), r.PathValue("id"))
+	if errors.Is(err, ErrNotFound) {
+		apierror.Write(w, r, apierror.NotFound, err.Error())
+		return
+	}
+	if err != nil {
+		apierror.Write(w, r, apierror.UpstreamError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSearch shows what a query would retrieve, for tuning chunking and scores
+func (x *Index) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Query string `json:"query"`
+		K     int    `json:"k"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Query == "" {
+		apierror.Write(w, r, apierror.InvalidRequest, "query is required")
+		return
+	}
+	matches, err := x.Retrieve(r.Context(), tenants.FromContext(r.Context()), body.Query, body.K)
+	if err != nil {
+		apierror.Write(w, r, apierror.UpstreamError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, matches)
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
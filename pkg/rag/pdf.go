@@ -0,0 +1,236 @@
+package rag
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrNoText is returned for PDFs without extractable text, such as scans
+var ErrNoText = errors.New("no extractable text in PDF")
+
+// ErrTooLarge is returned for PDFs whose streams inflate past maxInflatedBytes
+var ErrTooLarge = errors.New("PDF content too large")
+
+// maxInflatedBytes caps the decompressed size of all of a PDF's content
+// streams together, so a small Flate bomb cannot exhaust memory
+const maxInflatedBytes = 64 << 20
+
+// ExtractPDFText pulls the text out of the content streams of a PDF. It
+// handles uncompressed and Flate-compressed streams with simple font
+// encodings, which covers most generated documents; scanned pages and
+// CID-keyed fonts yield ErrNoText.
+func ExtractPDFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return "", errors.New("not a PDF file")
+	}
+
+	var out strings.Builder
+	budget := maxInflatedBytes
+	for rest := data; ; {
+		start := bytes.Index(rest, []byte("stream"))
+		if start < 0 {
+			break
+		}
+		dict := rest[:start]
+		if i := bytes.LastIndex(dict, []byte("<<")); i >= 0 {
+			dict = dict[i:]
+		}
+		body := rest[start+len("stream"):]
+		body = bytes.TrimPrefix(body, []byte("\r"))
+		body = bytes.TrimPrefix(body, []byte("\n"))
+		end := bytes.Index(body, []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		content := body[:end]
+		rest = body[end+len("endstream"):]
+
+		// Only content streams carry text; skip images, fonts and other filters
+		if bytes.Contains(dict, []byte("/Subtype")) || bytes.Contains(dict, []byte("/Length1")) {
+			continue
+		}
+		if bytes.Contains(dict, []byte("/Filter")) {
+			if !bytes.Contains(dict, []byte("/FlateDecode")) {
+				continue
+			}
+			inflated, err := inflate(content, budget)
+			if err == ErrTooLarge {
+				return "", err
+			}
+			if err != nil {
+				continue
+			}
+			budget -= len(inflated)
+			content = inflated
+		}
+		extractText(content, &out)
+	}
+
+	text := strings.TrimSpace(out.String())
+	if text == "" {
+		return "", ErrNoText
+	}
+	return text, nil
+}
+
+// inflate decompresses a Flate stream of at most limit bytes, keeping
+// whatever decoded before any trailing garbage
+func inflate(data []byte, limit int) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if len(out) > limit {
+		return nil, ErrTooLarge
+	}
+	if len(out) > 0 {
+		return out, nil
+	}
+	return nil, err
+}
+
+// extractText writes the strings shown by the text operators of a content
+// stream, starting a new line on text positioning operators
+func extractText(content []byte, out *strings.Builder) {
+	var pending []string // string operands since the last operator
+	inText := false
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := literalString(content[i:])
+			pending = append(pending, s)
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+			pending = append(pending, hexString(content[i+1:i+end]))
+			i += end + 1
+		case c == '<':
+			// A dictionary such as the <</MCID 0>> of marked content, whose
+			// keys and values are skipped as operands
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isDelimiter(c):
+			i++
+		default:
+			start := i
+			for i < len(content) && !isDelimiter(content[i]) && content[i] != '(' && content[i] != '<' && content[i] != '%' {
+				i++
+			}
+			if i == start {
+				i++ // never stall on a byte no case consumes
+			}
+			op := string(content[start:i])
+			switch op {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				out.WriteString("\n")
+			case "Tj", "TJ":
+				if inText {
+					out.WriteString(strings.Join(pending, ""))
+				}
+			case "'", "\"":
+				if inText {
+					out.WriteString("\n" + strings.Join(pending, ""))
+				}
+			case "Td", "TD", "T*":
+				if inText && out.Len() > 0 {
+					out.WriteString("\n")
+				}
+			}
+			if _, err := strconv.ParseFloat(op, 64); err != nil && op != "" {
+				pending = pending[:0]
+			}
+		}
+	}
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0, '[', ']', '/', '{', '}', '>':
+		return true
+	}
+	return false
+}
+
+// literalString decodes a (...) string, returning it and the bytes consumed
+func literalString(data []byte) (string, int) {
+	var b strings.Builder
+	depth := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '\\' && i+1 < len(data):
+			i++
+			switch e := data[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					j := i
+					for j < len(data) && j < i+3 && data[j] >= '0' && data[j] <= '7' {
+						j++
+					}
+					v, _ := strconv.ParseUint(string(data[i:j]), 8, 8)
+					b.WriteByte(byte(v))
+					i = j - 1
+				} else {
+					b.WriteByte(e)
+				}
+			}
+		case c == '(':
+			if depth > 0 {
+				b.WriteByte(c)
+			}
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return b.String(), i + 1
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), len(data)
+}
+
+// hexString decodes a <...> string of single-byte characters
+func hexString(data []byte) string {
+	hex := strings.Join(strings.Fields(string(data)), "")
+	if len(hex)%2 == 1 {
+		hex += "0"
+	}
+	var b strings.Builder
+	for i := 0; i+1 < len(hex); i += 2 {
+		v, err := strconv.ParseUint(hex[i:i+2], 16, 8)
+		if err != nil {
+			return ""
+		}
+		b.WriteByte(byte(v))
+	}
+	return b.String()
+}
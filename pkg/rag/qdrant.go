@@ -0,0 +1,165 @@
+package rag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// QdrantStore is a Store backed by a Qdrant collection over its REST API.
+// The collection is created with cosine distance on the first upsert.
+type QdrantStore struct {
+	BaseURL    string
+	Collection string
+	APIKey     string
+	Client     *http.Client
+
+	mu      sync.Mutex
+	created bool
+}
+
+// NewQdrantStore creates a store using collection on the Qdrant server at baseURL
+func NewQdrantStore(baseURL, collection, apiKey string) *QdrantStore {
+	return &QdrantStore{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Collection: collection,
+		APIKey:     apiKey,
+		Client:     http.DefaultClient,
+	}
+}
+
+// qdrantPoint is a point as sent to and returned by Qdrant
+type qdrantPoint struct {
+	ID      string        `json:"id"`
+	Vector  []float64     `json:"vector,omitempty"`
+	Payload qdrantPayload `json:"payload"`
+	Score   float64       `json:"score,omitempty"`
+}
+
+type qdrantPayload struct {
+	DocumentID string `json:"document_id"`
+	Tenant     string `json:"tenant,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Index      int    `json:"index"`
+	Text       string `json:"text"`
+}
+
+// Upsert writes chunks as points, creating the collection if needed
+func (s *QdrantStore) Upsert(ctx context.Context, chunks []Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	if err := s.ensureCollection(ctx, len(chunks[0].Vector)); err != nil {
+		return err
+	}
+
+	points := make([]qdrantPoint, len(chunks))
+	for i, c := range chunks {
+		points[i] = qdrantPoint{
+			ID:      c.ID,
+			Vector:  c.Vector,
+			Payload: qdrantPayload{DocumentID: c.DocumentID, Tenant: c.Tenant, Title: c.Title, Index: c.Index, Text: c.Text},
+		}
+	}
+	return s.do(ctx, http.MethodPut, "/points?wait=true", map[string]interface{}{"points": points}, nil)
+}
+
+// Search returns the k points of tenant nearest to vector
+func (s *QdrantStore) Search(ctx context.Context, vector []float64, k int, tenant string) ([]Match, error) {
+	var resp struct {
+		Result []qdrantPoint `json:"result"`
+	}
+	query := map[string]interface{}{
+		"vector":       vector,
+		"limit":        k,
+		"with_payload": true,
+	}
+	if tenant != "" {
+		query["filter"] = map[string]interface{}{
+			"must": []interface{}{
+				map[string]interface{}{"key": "tenant", "match": map[string]string{"value": tenant}},
+			},
+		}
+	}
+	err := s.do(ctx, http.MethodPost, "/points/search", query, &resp)
+	if err != nil {
+		if strings.Contains(err.Error(), "status 404") {
+			// Nothing has been ingested yet
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	matches := make([]Match, len(resp.Result))
+	for i, p := range resp.Result {
+		matches[i] = Match{
+			Chunk: Chunk{ID: p.ID, DocumentID: p.Payload.DocumentID, Tenant: p.Payload.Tenant, Title: p.Payload.Title, Index: p.Payload.Index, Text: p.Payload.Text},
+			Score: p.Score,
+		}
+	}
+	return matches, nil
+}
+
+// DeleteDocument removes the points of a document
+func (s *QdrantStore) DeleteDocument(ctx context.Context, documentID string) error {
+	return s.do(ctx, http.MethodPost, "/points/delete?wait=true", map[string]interface{}{
+		"filter": map[string]interface{}{
+			"must": []interface{}{
+				map[string]interface{}{"key": "document_id", "match": map[string]string{"value": documentID}},
+			},
+		},
+	}, nil)
+}
+
+// ensureCollection creates the collection once, tolerating one that exists
+func (s *QdrantStore) ensureCollection(ctx context.Context, size int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.created {
+		return nil
+	}
+
+	err := s.do(ctx, http.MethodPut, "", map[string]interface{}{
+		"vectors": map[string]interface{}{"size": size, "distance": "Cosine"},
+	}, nil)
+	if err != nil && !strings.Contains(err.Error(), "status 409") && !strings.Contains(err.Error(), "already exists") {
+		return err
+	}
+	s.created = true
+	return nil
+}
+
+// do sends a request to the collection endpoint at path and decodes the response into out
+func (s *QdrantStore) do(ctx context.Context, method, path string, body, out interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.BaseURL+"/collections/"+s.Collection+path, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.APIKey != "" {
+		req.Header.Set("api-key", s.APIKey)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("qdrant returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
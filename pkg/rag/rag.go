@@ -0,0 +1,293 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/tracing"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrNotFound is returned for unknown document IDs
+var ErrNotFound = errors.New("document not found")
+
+// ErrEmptyDocument is returned when a document has no text to ingest
+var ErrEmptyDocument = errors.New("document has no text")
+
+// embedBatchSize is how many chunks are embedded per upstream call
+const embedBatchSize = 32
+
+// Embedder returns one embedding per text
+type Embedder func(ctx context.Context, texts []string) ([][]float64, error)
+
+// Config controls chunking and retrieval
+type Config struct {
+	ChunkTokens   int     // approximate size of each chunk
+	ChunkOverlap  int     // tokens repeated between neighbouring chunks
+	TopK          int     // chunks retrieved per query by default
+	MinScore      float64 // chunks less similar than this are not used
+	ContextTokens int     // cap on retrieved text injected into a prompt
+}
+
+// DefaultConfig returns the defaults used when nothing is configured
+func DefaultConfig() Config {
+	return Config{
+		ChunkTokens:   300,
+		ChunkOverlap:  50,
+		TopK:          4,
+		MinScore:      0,
+		ContextTokens: 1500,
+	}
+}
+
+// Metrics holds the collectors ingestion and retrieval are recorded in
+type Metrics struct {
+	Documents *prometheus.CounterVec // labels: result
+	Chunks    prometheus.Counter     // chunks ingested
+	Retrieved prometheus.Histogram   // chunks returned per query
+	Latency   prometheus.Histogram   // retrieval latency, embedding included
+	Scores    prometheus.Histogram   // similarity of each returned chunk
+	AvgScore  prometheus.Histogram   // mean similarity of the chunks returned per query
+}
+
+// maxSpanQuery caps the query text recorded on retrieval spans
+const maxSpanQuery = 500
+
+// Document is an ingested document
+type Document struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Tenant    string    `json:"-"`
+	Source    string    `json:"source"` // "text" or "pdf"
+	Chunks    int       `json:"chunks"`
+	Tokens    int       `json:"tokens"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Index ingests documents into a store and retrieves chunks for prompts
+type Index struct {
+	store   Store
+	embed   Embedder
+	config  Config
+	metrics Metrics
+
+	mu        sync.RWMutex
+	documents map[string]Document
+}
+
+// New creates an index over store using embed for embeddings
+func New(store Store, embed Embedder, config Config, metrics Metrics) *Index {
+	defaults := DefaultConfig()
+	if config.ChunkTokens <= 0 {
+		config.ChunkTokens = defaults.ChunkTokens
+	}
+	if config.TopK <= 0 {
+		config.TopK = defaults.TopK
+	}
+	if config.ContextTokens <= 0 {
+		config.ContextTokens = defaults.ContextTokens
+	}
+	return &Index{
+		store:     store,
+		embed:     embed,
+		config:    config,
+		metrics:   metrics,
+		documents: make(map[string]Document),
+	}
+}
+
+// Ingest chunks, embeds and stores a document's text in tenant's library
+func (x *Index) Ingest(ctx context.Context, tenant, title, source, text string) (Document, error) {
+	parts := Split(text, x.config.ChunkTokens, x.config.ChunkOverlap)
+	if len(parts) == 0 {
+		x.metrics.Documents.WithLabelValues("error").Inc()
+		return Document{}, ErrEmptyDocument
+	}
+
+	doc := Document{
+		ID:        uuid.New().String(),
+		Title:     title,
+		Tenant:    tenant,
+		Source:    source,
+		Chunks:    len(parts),
+		Tokens:    len(text) / charsPerToken,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	chunks := make([]Chunk, len(parts))
+	for start := 0; start < len(parts); start += embedBatchSize {
+		end := min(start+embedBatchSize, len(parts))
+		vectors, err := x.embed(ctx, parts[start:end])
+		if err == nil && len(vectors) != end-start {
+			err = fmt.Errorf("expected %d embeddings, got %d", end-start, len(vectors))
+		}
+		if err != nil {
+			x.metrics.Documents.WithLabelValues("error").Inc()
+			return Document{}, fmt.Errorf("embedding document: %w", err)
+		}
+		for i, vector := range vectors {
+			chunks[start+i] = Chunk{
+				ID:         uuid.New().String(),
+				DocumentID: doc.ID,
+				Tenant:     tenant,
+				Title:      title,
+				Index:      start + i,
+				Text:       parts[start+i],
+				Vector:     vector,
+			}
+		}
+	}
+
+	if err := x.store.Upsert(ctx, chunks); err != nil {
+		x.metrics.Documents.WithLabelValues("error").Inc()
+		return Document{}, fmt.Errorf("storing document: %w", err)
+	}
+
+	x.mu.Lock()
+	x.documents[doc.ID] = doc
+	x.mu.Unlock()
+	x.metrics.Documents.WithLabelValues("success").Inc()
+	x.metrics.Chunks.Add(float64(len(chunks)))
+
+	log := logger.GetLogger()
+	log.Info().Str("document_id", doc.ID).Str("title", title).Int("chunks", len(chunks)).Msg("Ingested document")
+	return doc, nil
+}
+
+// Retrieve returns the chunks of tenant's documents most similar to query,
+// best first. k <= 0 uses the configured default.
+func (x *Index) Retrieve(ctx context.Context, tenant, query string, k int) ([]Match, error) {
+	if k <= 0 {
+		k = x.config.TopK
+	}
+	start := time.Now()
+
+	ctx, span := tracing.StartSpan(ctx, "rag_retrieval")
+	defer span.End()
+	recordedQuery := query
+	if len(recordedQuery) > maxSpanQuery {
+		recordedQuery = recordedQuery[:maxSpanQuery]
+	}
+	span.SetAttributes(
+		attribute.String("rag.query", recordedQuery),
+		attribute.Int("rag.top_k", k),
+		attribute.Float64("rag.min_score", x.config.MinScore),
+	)
+
+	embedCtx, embedSpan := tracing.StartChildSpan(ctx, "rag_embed_query")
+	vectors, err := x.embed(embedCtx, []string{query})
+	if err == nil && len(vectors) != 1 {
+		err = errors.New("no embedding returned for query")
+	}
+	if err != nil {
+		tracing.RecordError(embedCtx, err, "Query embedding failed")
+		embedSpan.End()
+		tracing.RecordError(ctx, err, "Retrieval failed")
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+	embedSpan.End()
+
+	searchCtx, searchSpan := tracing.StartChildSpan(ctx, "rag_search")
+	matches, err := x.store.Search(searchCtx, vectors[0], k, tenant)
+	if err != nil {
+		tracing.RecordError(searchCtx, err, "Vector search failed")
+		searchSpan.End()
+		tracing.RecordError(ctx, err, "Retrieval failed")
+		return nil, fmt.Errorf("searching documents: %w", err)
+	}
+	searchSpan.End()
+
+	kept := matches[:0]
+	for _, m := range matches {
+		if m.Score >= x.config.MinScore {
+			kept = append(kept, m)
+		}
+	}
+
+	x.metrics.Latency.Observe(time.Since(start).Seconds())
+	x.metrics.Retrieved.Observe(float64(len(kept)))
+
+	ids := make([]string, len(kept))
+	documents := make([]string, len(kept))
+	scores := make([]float64, len(kept))
+	total := 0.0
+	for i, m := range kept {
+		x.metrics.Scores.Observe(m.Score)
+		ids[i], documents[i], scores[i] = m.ID, m.DocumentID, m.Score
+		total += m.Score
+	}
+	span.SetAttributes(
+		attribute.Int("rag.chunks_returned", len(kept)),
+		attribute.Int("rag.chunks_below_min_score", len(matches)-len(kept)),
+		attribute.StringSlice("rag.chunk_ids", ids),
+		attribute.StringSlice("rag.document_ids", documents),
+		attribute.Float64Slice("rag.scores", scores),
+	)
+	if len(kept) > 0 {
+		average := total / float64(len(kept))
+		x.metrics.AvgScore.Observe(average)
+		span.SetAttributes(
+			attribute.Float64("rag.score.avg", average),
+			attribute.Float64("rag.score.max", kept[0].Score),
+		)
+	}
+	return kept, nil
+}
+
+// Prompt formats retrieved chunks as a system prompt, staying within the
+// configured token cap
+func (x *Index) Prompt(matches []Match) string {
+	if len(matches) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Answer using the following context from the document library. If the context doesn't contain the answer, say so.\n")
+	budget := x.config.ContextTokens * charsPerToken
+	for i, m := range matches {
+		entry := fmt.Sprintf("\n[%d] %s\n%s\n", i+1, m.Title, m.Text)
+		if b.Len()+len(entry) > budget && i > 0 {
+			break
+		}
+		b.WriteString(entry)
+	}
+	return b.String()
+}
+
+// Documents lists tenant's documents, newest first
+func (x *Index) Documents(tenant string) []Document {
+	x.mu.RLock()
+	docs := make([]Document, 0, len(x.documents))
+	for _, d := range x.documents {
+		if d.Tenant == tenant {
+			docs = append(docs, d)
+		}
+	}
+	x.mu.RUnlock()
+	sort.Slice(docs, func(i, j int) bool { return docs[i].CreatedAt.After(docs[j].CreatedAt) })
+	return docs
+}
+
+// Delete removes one of tenant's documents and its chunks
+func (x *Index) Delete(ctx context.Context, tenant, id string) error {
+	x.mu.RLock()
+	doc, ok := x.documents[id]
+	x.mu.RUnlock()
+	if !ok || doc.Tenant != tenant {
+		return ErrNotFound
+	}
+	if err := x.store.DeleteDocument(ctx, id); err != nil {
+		return err
+	}
+	x.mu.Lock()
+	delete(x.documents, id)
+	x.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,269 @@
+package rag
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// wordEmbedder embeds text as a bag of hashed words, so texts sharing words are similar
+func wordEmbedder(ctx context.Context, texts []string) ([][]float64, error) {
+	vectors := make([][]float64, len(texts))
+	for i, text := range texts {
+		v := make([]float64, 64)
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.Trim(word, ".,?!")))
+			v[h.Sum32()%64]++
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+func testMetrics() Metrics {
+	return Metrics{
+		Documents: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "documents"}, []string{"result"}),
+		Chunks:    prometheus.NewCounter(prometheus.CounterOpts{Name: "chunks"}),
+		Retrieved: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "retrieved"}),
+		Latency:   prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency"}),
+		Scores:    prometheus.NewHistogram(prometheus.HistogramOpts{Name: "scores"}),
+		AvgScore:  prometheus.NewHistogram(prometheus.HistogramOpts{Name: "avg_score"}),
+	}
+}
+
+func TestSplitRespectsSizeAndOverlap(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100)
+	chunks := Split(text, 50, 10)
+	if len(chunks) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+	for i, c := range chunks {
+		if len(c) > 50*charsPerToken {
+			t.Errorf("chunk %d has %d characters, want at most %d", i, len(c), 50*charsPerToken)
+		}
+		if strings.HasPrefix(c, " ") || c == "" {
+			t.Errorf("chunk %d is not trimmed: %q", i, c)
+		}
+	}
+	if Split("   ", 50, 10) != nil {
+		t.Error("expected no chunks for blank text")
+	}
+}
+
+func TestIngestAndRetrieve(t *testing.T) {
+	metrics := testMetrics()
+	index := New(NewMemoryStore(), wordEmbedder, Config{ChunkTokens: 20, TopK: 1}, metrics)
+	ctx := context.Background()
+
+	if _, err := index.Ingest(ctx, "acme", "cats", "text", "Cats sleep most of the day and purr when content."); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := index.Ingest(ctx, "acme", "rockets", "text", "Rockets burn liquid oxygen and kerosene to reach orbit.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := index.Retrieve(ctx, "acme", "what do rockets burn to reach orbit?", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].DocumentID != doc.ID {
+		t.Fatalf("expected the rockets chunk, got %+v", matches)
+	}
+	if prompt := index.Prompt(matches); !strings.Contains(prompt, "liquid oxygen") || !strings.Contains(prompt, "[1] rockets") {
+		t.Errorf("unexpected prompt %q", prompt)
+	}
+	if got := testutil.ToFloat64(metrics.Documents.WithLabelValues("success")); got != 2 {
+		t.Errorf("ingested documents = %v, want 2", got)
+	}
+
+	if err := index.Delete(ctx, "acme", doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ = index.Retrieve(ctx, "acme", "rockets orbit", 5)
+	for _, m := range matches {
+		if m.DocumentID == doc.ID {
+			t.Errorf("deleted document still retrieved")
+		}
+	}
+	if err := index.Delete(ctx, "acme", doc.ID); err != ErrNotFound {
+		t.Errorf("second delete err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRetrieveRecordsSpanAndAverageScore(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	metrics := testMetrics()
+	index := New(NewMemoryStore(), wordEmbedder, Config{TopK: 2}, metrics)
+	ctx := context.Background()
+	index.Ingest(ctx, "acme", "rockets", "text", "Rockets burn liquid oxygen and kerosene to reach orbit.")
+	index.Ingest(ctx, "acme", "cats", "text", "Cats sleep most of the day and purr when content.")
+	exporter.Reset()
+
+	matches, err := index.Retrieve(ctx, "acme", "rockets reach orbit", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var retrieval *tracetest.SpanStub
+	names := map[string]bool{}
+	for _, span := range exporter.GetSpans() {
+		names[span.Name] = true
+		if span.Name == "rag_retrieval" {
+			retrieval = &span
+		}
+	}
+	if retrieval == nil || !names["rag_embed_query"] || !names["rag_search"] {
+		t.Fatalf("expected retrieval, embedding and search spans, got %v", names)
+	}
+	attrs := map[string]interface{}{}
+	for _, kv := range retrieval.Attributes {
+		attrs[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	if attrs["rag.query"] != "rockets reach orbit" || attrs["rag.chunks_returned"] != int64(len(matches)) {
+		t.Errorf("unexpected span attributes %v", attrs)
+	}
+	if ids, _ := attrs["rag.chunk_ids"].([]string); len(ids) != len(matches) || ids[0] != matches[0].ID {
+		t.Errorf("chunk IDs = %v", attrs["rag.chunk_ids"])
+	}
+	if scores, _ := attrs["rag.scores"].([]float64); len(scores) != len(matches) {
+		t.Errorf("scores = %v", attrs["rag.scores"])
+	}
+	if got := testutil.CollectAndCount(metrics.AvgScore); got != 1 {
+		t.Errorf("average score histogram has %d series, want 1", got)
+	}
+}
+
+func TestExtractPDFText(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write([]byte("BT /F1 12 Tf 72 712 Td (Hello \\(PDF\\) world) Tj 0 -14 Td [(Second) -250 ( line)] TJ ET"))
+	zw.Close()
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n1 0 obj\n<< /Type /Catalog >>\nendobj\n")
+	fmt.Fprintf(&pdf, "4 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", compressed.Len())
+	pdf.Write(compressed.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n%%EOF\n")
+
+	text, err := ExtractPDFText(pdf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "Hello (PDF) world") || !strings.Contains(text, "Second line") {
+		t.Errorf("unexpected text %q", text)
+	}
+
+	if _, err := ExtractPDFText([]byte("%PDF-1.4\n%%EOF")); err != ErrNoText {
+		t.Errorf("err = %v, want ErrNoText", err)
+	}
+}
+
+// contentPDF wraps an uncompressed content stream in a minimal PDF
+func contentPDF(content string) []byte {
+	return []byte(fmt.Sprintf("%%PDF-1.4\n4 0 obj\n<< /Length %d >>\nstream\n%s\nendstream\nendobj\n%%%%EOF\n", len(content), content))
+}
+
+func TestExtractPDFTextMarkedContent(t *testing.T) {
+	// Tagged PDFs, as Word and LibreOffice write them, wrap text in marked
+	// content whose properties are an inline dictionary
+	text, err := ExtractPDFText(contentPDF("/Span <</MCID 0>> BDC BT (Hello) Tj ET EMC <"))
+	if err != nil || text != "Hello" {
+		t.Errorf("got %q, %v; want Hello", text, err)
+	}
+}
+
+func TestExtractPDFTextRejectsFlateBombs(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(make([]byte, maxInflatedBytes+1))
+	zw.Close()
+
+	var pdf bytes.Buffer
+	fmt.Fprintf(&pdf, "%%PDF-1.4\n4 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", compressed.Len())
+	pdf.Write(compressed.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n")
+	if _, err := ExtractPDFText(pdf.Bytes()); err != ErrTooLarge {
+		t.Errorf("err = %v, want ErrTooLarge", err)
+	}
+}
+
+func FuzzExtractPDFText(f *testing.F) {
+	f.Add("BT (Hello) Tj ET")
+	f.Add("/Span <</MCID 0>> BDC BT (Hello) Tj ET EMC")
+	f.Add("BT <48656c6c6f> Tj T* [(a) 10 (b)] TJ ET <")
+	f.Add("BT (unterminated \\")
+	f.Add("% comment\n<<>>%<(")
+	f.Fuzz(func(t *testing.T, content string) {
+		// Must return for any content; the fuzzer fails on hangs and panics
+		ExtractPDFText(contentPDF(content))
+	})
+}
+
+func TestIndexIsolatesTenants(t *testing.T) {
+	index := New(NewMemoryStore(), wordEmbedder, Config{TopK: 5}, testMetrics())
+	ctx := context.Background()
+	doc, err := index.Ingest(ctx, "acme", "rockets", "text", "Rockets burn liquid oxygen and kerosene to reach orbit.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := index.Retrieve(ctx, "globex", "rockets reach orbit", 0)
+	if err != nil || len(matches) != 0 {
+		t.Errorf("other tenant retrieved %+v, %v", matches, err)
+	}
+	if docs := index.Documents("globex"); len(docs) != 0 {
+		t.Errorf("other tenant lists %+v", docs)
+	}
+	if err := index.Delete(ctx, "globex", doc.ID); err != ErrNotFound {
+		t.Errorf("other tenant's delete err = %v, want ErrNotFound", err)
+	}
+	if docs := index.Documents("acme"); len(docs) != 1 || docs[0].ID != doc.ID {
+		t.Errorf("owner lists %+v", docs)
+	}
+}
+
+func TestHandlerIngestsJSONDocuments(t *testing.T) {
+	index := New(NewMemoryStore(), wordEmbedder, Config{}, testMetrics())
+	server := httptest.NewServer(index.Handler())
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/documents", "application/json", strings.NewReader(`{"title":"notes v1.2","text":"Some notes."}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc Document
+	json.NewDecoder(resp.Body).Decode(&doc)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || doc.Title != "notes v1.2" || doc.Chunks != 1 {
+		t.Fatalf("status %d, document %+v", resp.StatusCode, doc)
+	}
+
+	resp, err = http.Post(server.URL+"/documents", "text/plain", strings.NewReader("  "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("empty document status = %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,103 @@
+package rag
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Chunk is a piece of an ingested document with its embedding
+type Chunk struct {
+	ID         string    `json:"id"`
+	DocumentID string    `json:"document_id"`
+	Tenant     string    `json:"-"` // owner of the document, empty for none
+	Title      string    `json:"title,omitempty"`
+	Index      int       `json:"index"` // position within the document
+	Text       string    `json:"text"`
+	Vector     []float64 `json:"-"`
+}
+
+// Match is a chunk returned by a search with its cosine similarity to the query
+type Match struct {
+	Chunk
+	Score float64 `json:"score"`
+}
+
+// Store holds chunk embeddings and finds the nearest ones to a query.
+// Search only considers the chunks of tenant, or all chunks if it is empty.
+type Store interface {
+	Upsert(ctx context.Context, chunks []Chunk) error
+	Search(ctx context.Context, vector []float64, k int, tenant string) ([]Match, error)
+	DeleteDocument(ctx context.Context, documentID string) error
+}
+
+// MemoryStore is a Store searched by brute force, for small corpora and
+// single-container deployments. Its contents are lost on restart.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	chunks map[string]Chunk
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{chunks: make(map[string]Chunk)}
+}
+
+// Upsert adds or replaces chunks by ID
+func (s *MemoryStore) Upsert(ctx context.Context, chunks []Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range chunks {
+		s.chunks[c.ID] = c
+	}
+	return nil
+}
+
+// Search returns the k chunks of tenant most similar to vector, best first
+func (s *MemoryStore) Search(ctx context.Context, vector []float64, k int, tenant string) ([]Match, error) {
+	s.mu.RLock()
+	matches := make([]Match, 0, len(s.chunks))
+	for _, c := range s.chunks {
+		if tenant != "" && c.Tenant != tenant {
+			continue
+		}
+		matches = append(matches, Match{Chunk: c, Score: cosine(vector, c.Vector)})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches, nil
+}
+
+// DeleteDocument removes every chunk of a document
+func (s *MemoryStore) DeleteDocument(ctx context.Context, documentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, c := range s.chunks {
+		if c.DocumentID == documentID {
+			delete(s.chunks, id)
+		}
+	}
+	return nil
+}
+
+// cosine returns the cosine similarity of a and b, or 0 if they can't be compared
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,64 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/tenants"
+)
+
+// Handler serves POST /replay, GET /replay/{id} and DELETE /replay/{id}.
+// Replays read the conversations of the caller's tenant.
+func (r *Replayer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /replay", r.handleStart)
+	mux.HandleFunc("GET /replay/{id}", r.handleGet)
+	mux.HandleFunc("DELETE /replay/{id}", r.handleCancel)
+	return mux
+}
+
+func (r *Replayer) handleStart(w http.ResponseWriter, req *http.Request) {
+	var body Request
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		apierror.Write(w, req, apierror.InvalidRequest, "invalid request body")
+		return
+	}
+
+	body.Tenant = tenants.FromContext(req.Context())
+	job, err := r.Start(body)
+	if errors.Is(err, ErrBusy) {
+		apierror.Write(w, req, apierror.RateLimited, err.Error())
+		return
+	}
+	if err != nil {
+		apierror.Write(w, req, apierror.InvalidRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (r *Replayer) handleGet(w http.ResponseWriter, req *http.Request) {
+	job, ok := r.Get(req.PathValue("id"))
+	if !ok {
+		apierror.Write(w, req, apierror.NotFound, "replay not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (r *Replayer) handleCancel(w http.ResponseWriter, req *http.Request) {
+	if !r.Cancel(req.PathValue("id")) {
+		apierror.Write(w, req, apierror.NotFound, "no running replay with that id")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
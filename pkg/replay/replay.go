@@ -0,0 +1,347 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/bench"
+	"github.com/ajeetraina/aiwatch/pkg/history"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/tenants"
+	"github.com/google/uuid"
+	"github.com/openai/openai-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultTag labels replayed traffic in metrics unless the request names one
+const DefaultTag = "replay"
+
+// maxGap caps the wait between two replayed requests, so conversations
+// recorded days apart don't stall a replay
+const maxGap = 30 * time.Second
+
+// maxInFlight bounds concurrent replayed requests
+const maxInFlight = 32
+
+// Bounds on the jobs a replayer holds
+const (
+	maxRunning  = 2         // replays running at once
+	maxFinished = 50        // finished replays kept for GET /replay/{id}
+	keepJobs    = time.Hour // how long a finished replay is kept
+)
+
+// ErrBusy is returned when maxRunning replays are already running
+var ErrBusy = errors.New("too many replays running, cancel one or wait for it to finish")
+
+// Request starts a replay
+type Request struct {
+	Model           string   `json:"model"`                      // target model
+	Rate            float64  `json:"rate,omitempty"`             // speed-up of the recorded timing; 0 sends as fast as possible
+	Limit           int      `json:"limit,omitempty"`            // most recent conversations to replay
+	ConversationIDs []string `json:"conversation_ids,omitempty"` // replay these instead of the most recent
+	Tag             string   `json:"tag,omitempty"`              // metric label for the replayed traffic
+	// Tenant whose conversations are replayed, the default tenant if empty
+	Tenant string `json:"-"`
+}
+
+// Job statuses
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+)
+
+// Job is the progress and result of a replay
+type Job struct {
+	ID         string             `json:"id"`
+	Tag        string             `json:"tag"`
+	Model      string             `json:"model"`
+	Rate       float64            `json:"rate"`
+	Status     string             `json:"status"`
+	Total      int                `json:"total"`
+	Completed  int                `json:"completed"`
+	Failed     int                `json:"failed"`
+	StartedAt  time.Time          `json:"started_at"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty"`
+	Report     *bench.ModelReport `json:"report,omitempty"`
+}
+
+// Metrics holds the collectors replayed traffic is recorded in
+type Metrics struct {
+	Requests   *prometheus.CounterVec   // labels: tag, model, result
+	Latency    *prometheus.HistogramVec // labels: tag, model
+	FirstToken *prometheus.HistogramVec // labels: tag, model
+	Tokens     *prometheus.CounterVec   // labels: tag, model
+}
+
+// Replayer replays stored conversations against a model
+type Replayer struct {
+	client  *openai.Client
+	store   *history.Store
+	metrics Metrics
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	cancels map[string]context.CancelFunc
+}
+
+// New creates a replayer reading conversations from store
+func New(client *openai.Client, store *history.Store, metrics Metrics) *Replayer {
+	return &Replayer{
+		client:  client,
+		store:   store,
+		metrics: metrics,
+		jobs:    make(map[string]*Job),
+		cancels: make(map[string]context.CancelFunc),
+	}
+}
+
+// item is one recorded user turn with the history that preceded it
+type item struct {
+	at       time.Time
+	messages []history.Message
+}
+
+// Start loads the conversations and replays them in the background
+func (r *Replayer) Start(req Request) (Job, error) {
+	if req.Model == "" {
+		return Job{}, errors.New("model is required")
+	}
+	if req.Rate < 0 {
+		return Job{}, errors.New("rate must not be negative")
+	}
+	if req.Tag == "" {
+		req.Tag = DefaultTag
+	}
+	if req.Tenant == "" {
+		req.Tenant = tenants.Default
+	}
+
+	r.mu.Lock()
+	r.prune(time.Now())
+	running := len(r.cancels)
+	r.mu.Unlock()
+	if running >= maxRunning {
+		return Job{}, ErrBusy
+	}
+
+	items, err := r.load(req)
+	if err != nil {
+		return Job{}, err
+	}
+	if len(items) == 0 {
+		return Job{}, errors.New("no recorded conversations to replay")
+	}
+
+	job := &Job{
+		ID:        uuid.New().String(),
+		Tag:       req.Tag,
+		Model:     req.Model,
+		Rate:      req.Rate,
+		Status:    StatusRunning,
+		Total:     len(items),
+		StartedAt: time.Now().UTC(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r.mu.Lock()
+	if len(r.cancels) >= maxRunning {
+		// Another replay started while this one loaded
+		r.mu.Unlock()
+		cancel()
+		return Job{}, ErrBusy
+	}
+	r.jobs[job.ID] = job
+	r.cancels[job.ID] = cancel
+	snapshot := *job
+	r.mu.Unlock()
+
+	go r.run(ctx, job, items)
+	return snapshot, nil
+}
+
+// load turns the selected conversations of the request's tenant into replay
+// items ordered by their original time
+func (r *Replayer) load(req Request) ([]item, error) {
+	ids := req.ConversationIDs
+	if len(ids) == 0 {
+		limit := req.Limit
+		if limit <= 0 {
+			limit = 100
+		}
+		summaries, err := r.store.ListWhere(limit, func(c history.Conversation) bool {
+			return tenants.Same(c.Tenant, req.Tenant)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range summaries {
+			ids = append(ids, s.ID)
+		}
+	}
+
+	var items []item
+	for _, id := range ids {
+		c, err := r.store.Get(id)
+		if err == nil && !tenants.Same(c.Tenant, req.Tenant) {
+			err = history.ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, msg := range c.Messages {
+			if msg.Role == "user" {
+				items = append(items, item{at: msg.Timestamp, messages: c.Messages[:i+1]})
+			}
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].at.Before(items[j].at) })
+	return items, nil
+}
+
+func (r *Replayer) run(ctx context.Context, job *Job, items []item) {
+	log := logger.GetLogger()
+	log.Info().Str("replay_id", job.ID).Str("model", job.Model).Int("requests", len(items)).Float64("rate", job.Rate).Msg("Starting traffic replay")
+
+	var (
+		wg        sync.WaitGroup
+		samplesMu sync.Mutex
+		samples   []bench.Sample
+	)
+	inFlight := make(chan struct{}, maxInFlight)
+
+	for i, it := range items {
+		if i > 0 && job.Rate > 0 {
+			gap := time.Duration(float64(it.at.Sub(items[i-1].at)) / job.Rate)
+			if gap > maxGap {
+				gap = maxGap
+			}
+			select {
+			case <-time.After(gap):
+			case <-ctx.Done():
+			}
+		}
+
+		select {
+		case inFlight <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(messages []history.Message) {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+
+			sample := r.send(ctx, job, messages)
+
+			samplesMu.Lock()
+			samples = append(samples, sample)
+			samplesMu.Unlock()
+
+			r.mu.Lock()
+			job.Completed++
+			if sample.Err != nil {
+				job.Failed++
+			}
+			r.mu.Unlock()
+		}(it.messages)
+	}
+	wg.Wait()
+
+	report := bench.Summarize(job.Model, samples)
+	finished := time.Now().UTC()
+
+	r.mu.Lock()
+	job.Report = &report
+	job.FinishedAt = &finished
+	job.Status = StatusCompleted
+	if ctx.Err() != nil {
+		job.Status = StatusCancelled
+	}
+	r.cancels[job.ID]()
+	delete(r.cancels, job.ID)
+	r.mu.Unlock()
+
+	log.Info().Str("replay_id", job.ID).Str("status", job.Status).Int("completed", job.Completed).Int("failed", job.Failed).Msg("Traffic replay finished")
+}
+
+// send replays one user turn and records it under the job's tag
+func (r *Replayer) send(ctx context.Context, job *Job, messages []history.Message) bench.Sample {
+	params := openai.ChatCompletionNewParams{Model: openai.F(job.Model)}
+	var converted []openai.ChatCompletionMessageParamUnion
+	for _, msg := range messages {
+		switch msg.Role {
+		case "user":
+			converted = append(converted, openai.UserMessage(msg.Content))
+		case "assistant":
+			converted = append(converted, openai.AssistantMessage(msg.Content))
+		case "system":
+			converted = append(converted, openai.SystemMessage(msg.Content))
+		}
+	}
+	params.Messages = openai.F(converted)
+
+	sample := bench.Measure(ctx, r.client, params)
+	if sample.Err != nil {
+		r.metrics.Requests.WithLabelValues(job.Tag, job.Model, "error").Inc()
+		return sample
+	}
+	r.metrics.Requests.WithLabelValues(job.Tag, job.Model, "ok").Inc()
+	r.metrics.Latency.WithLabelValues(job.Tag, job.Model).Observe(sample.Latency.Seconds())
+	if sample.Tokens > 0 {
+		r.metrics.FirstToken.WithLabelValues(job.Tag, job.Model).Observe(sample.FirstToken.Seconds())
+	}
+	r.metrics.Tokens.WithLabelValues(job.Tag, job.Model).Add(float64(sample.Tokens))
+	return sample
+}
+
+// prune forgets finished jobs kept past keepJobs, and the oldest finished
+// jobs beyond maxFinished. Callers must hold r.mu.
+func (r *Replayer) prune(now time.Time) {
+	var finished []*Job
+	for id, job := range r.jobs {
+		if job.FinishedAt == nil {
+			continue
+		}
+		if now.Sub(*job.FinishedAt) > keepJobs {
+			delete(r.jobs, id)
+			continue
+		}
+		finished = append(finished, job)
+	}
+	if len(finished) <= maxFinished {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].FinishedAt.Before(*finished[j].FinishedAt) })
+	for _, job := range finished[:len(finished)-maxFinished] {
+		delete(r.jobs, job.ID)
+	}
+}
+
+// Get returns a snapshot of a job
+func (r *Replayer) Get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Cancel stops a running replay, returning false if it isn't running
+func (r *Replayer) Cancel(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cancel, ok := r.cancels[id]
+	if ok {
+		cancel()
+	}
+	return ok
+}
@@ -0,0 +1,134 @@
+package replay
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/internal/testsupport"
+	"github.com/ajeetraina/aiwatch/pkg/history"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReplay(t *testing.T) {
+	backend := testsupport.NewFakeBackend("ok")
+	defer backend.Close()
+	client := openai.NewClient(option.WithBaseURL(backend.BaseURL()), option.WithAPIKey("test"))
+
+	store := newStore()
+	store.Append("conv-1", "", "ai/old",
+		history.Message{Role: "user", Content: "first"},
+		history.Message{Role: "assistant", Content: "answer"},
+		history.Message{Role: "user", Content: "second"},
+	)
+
+	metrics := newMetrics()
+	replayer := New(client, store, metrics)
+
+	job, err := replayer.Start(Request{Model: "ai/new", Tag: "q4-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Total != 2 {
+		t.Fatalf("expected 2 user turns to replay, got %d", job.Total)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status == StatusRunning && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		job, _ = replayer.Get(job.ID)
+	}
+	if job.Status != StatusCompleted || job.Failed != 0 || job.Report == nil {
+		t.Fatalf("unexpected job state: %+v", job)
+	}
+
+	if got := testutil.ToFloat64(metrics.Requests.WithLabelValues("q4-test", "ai/new", "ok")); got != 2 {
+		t.Errorf("expected 2 tagged replay requests, got %v", got)
+	}
+	for _, req := range backend.Requests() {
+		if req["model"] != "ai/new" {
+			t.Errorf("replay went to %v instead of the target model", req["model"])
+		}
+	}
+}
+
+func TestReplayScopesToTenant(t *testing.T) {
+	store := newStore()
+	store.Append("conv-default", "", "ai/old", history.Message{Role: "user", Content: "mine"})
+	store.Append("conv-acme", "", "ai/old", history.Message{Role: "user", Content: "theirs"})
+	store.Update("conv-acme", func(c *history.Conversation) { c.Tenant = "acme" })
+	replayer := New(openai.NewClient(), store, newMetrics())
+
+	items, err := replayer.load(Request{Tenant: "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].messages[len(items[0].messages)-1].Content != "theirs" {
+		t.Fatalf("expected only acme's turn, got %+v", items)
+	}
+	if _, err := replayer.load(Request{Tenant: "acme", ConversationIDs: []string{"conv-default"}}); !errors.Is(err, history.ErrNotFound) {
+		t.Fatalf("expected another tenant's conversation to be not found, got %v", err)
+	}
+}
+
+func TestReplayLimitsRunningJobs(t *testing.T) {
+	store := newStore()
+	store.Append("conv-1", "", "ai/old", history.Message{Role: "user", Content: "hi"})
+	replayer := New(openai.NewClient(), store, newMetrics())
+	for i := 0; i < maxRunning; i++ {
+		replayer.cancels[string(rune('a'+i))] = func() {}
+	}
+
+	if _, err := replayer.Start(Request{Model: "ai/new"}); !errors.Is(err, ErrBusy) {
+		t.Fatalf("expected ErrBusy with %d replays running, got %v", maxRunning, err)
+	}
+}
+
+func TestReplayPrunesFinishedJobs(t *testing.T) {
+	replayer := New(openai.NewClient(), newStore(), newMetrics())
+	now := time.Now()
+	finish := func(id string, at time.Time) {
+		replayer.jobs[id] = &Job{ID: id, Status: StatusCompleted, FinishedAt: &at}
+	}
+	finish("expired", now.Add(-keepJobs-time.Minute))
+	for i := 0; i < maxFinished+1; i++ {
+		finish(string(rune('A'+i)), now.Add(time.Duration(i-maxFinished-1)*time.Second))
+	}
+	replayer.jobs["running"] = &Job{ID: "running", Status: StatusRunning}
+
+	replayer.prune(now)
+
+	if _, ok := replayer.Get("expired"); ok {
+		t.Error("expected a replay finished over keepJobs ago to be pruned")
+	}
+	if _, ok := replayer.Get("A"); ok {
+		t.Error("expected the oldest finished replay beyond maxFinished to be pruned")
+	}
+	if _, ok := replayer.Get("B"); !ok {
+		t.Error("expected recent finished replays to be kept")
+	}
+	if _, ok := replayer.Get("running"); !ok {
+		t.Error("expected running replays to be kept")
+	}
+}
+
+func newStore() *history.Store {
+	return history.New(nil, 1<<20, history.Metrics{
+		Lookups:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "lookups"}, []string{"result"}),
+		Evictions:   prometheus.NewCounter(prometheus.CounterOpts{Name: "evictions"}),
+		MemoryBytes: prometheus.NewGauge(prometheus.GaugeOpts{Name: "memory"}),
+		Entries:     prometheus.NewGauge(prometheus.GaugeOpts{Name: "entries"}),
+	})
+}
+
+func newMetrics() Metrics {
+	return Metrics{
+		Requests:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"tag", "model", "result"}),
+		Latency:    prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"tag", "model"}),
+		FirstToken: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "first_token"}, []string{"tag", "model"}),
+		Tokens:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "tokens"}, []string{"tag", "model"}),
+	}
+}
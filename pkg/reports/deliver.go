@@ -0,0 +1,129 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Sender delivers a rendered report
+type Sender interface {
+	// Name identifies the channel in metrics, such as slack or email
+	Name() string
+	Send(ctx context.Context, r Report) error
+}
+
+// Slack posts reports to a Slack incoming webhook as a Markdown block, with
+// the title as the notification text
+type Slack struct {
+	URL    string
+	Client *http.Client
+}
+
+// Name implements Sender
+func (s *Slack) Name() string {
+	return "slack"
+}
+
+// Send implements Sender
+func (s *Slack) Send(ctx context.Context, r Report) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"text":   r.Title(),
+		"blocks": []map[string]string{{"type": "markdown", "text": r.Markdown()}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Email sends reports over SMTP as HTML with a Markdown alternative. The
+// connection is upgraded with STARTTLS when the server offers it.
+type Email struct {
+	Addr     string // host:port of the SMTP server
+	Username string // PLAIN auth, if set
+	Password string
+	From     string
+	To       []string
+
+	// send is smtp.SendMail, replaced in tests
+	send func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// Name implements Sender
+func (e *Email) Name() string {
+	return "email"
+}
+
+// Send implements Sender
+func (e *Email) Send(ctx context.Context, r Report) error {
+	msg, err := e.message(r)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, _ := strings.Cut(e.Addr, ":")
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	send := e.send
+	if send == nil {
+		send = smtp.SendMail
+	}
+	return send(e.Addr, auth, e.From, e.To, msg)
+}
+
+// message builds the MIME message for a report
+func (e *Email) message(r Report) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType, content string
+	}{
+		{"text/plain; charset=utf-8", r.Markdown()},
+		{"text/html; charset=utf-8", r.HTML()},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		w.Write([]byte(part.content))
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", r.Title())
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,271 @@
+// Package reports builds daily and weekly usage summaries from the request
+// log and conversation history, renders them as Markdown or HTML and
+// delivers them on a schedule to Slack or email.
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/requestlog"
+)
+
+// Report periods
+const (
+	Daily  = "daily"
+	Weekly = "weekly"
+)
+
+// topN is how many errors and prompts a report lists
+const topN = 10
+
+// maxPrompt bounds a listed prompt in characters
+const maxPrompt = 120
+
+// Report summarizes usage over one period
+type Report struct {
+	Period    string    `json:"period"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Requests  int       `json:"requests"`
+	Errors    int       `json:"errors"`
+	TokensIn  int       `json:"tokens_in"`
+	TokensOut int       `json:"tokens_out"`
+	CostUSD   float64   `json:"cost_usd"`
+	P50Ms     float64   `json:"p50_latency_ms"`
+	P99Ms     float64   `json:"p99_latency_ms"`
+
+	Models     []ModelUsage `json:"models"`
+	TopErrors  []Count      `json:"top_errors"`
+	TopPrompts []Count      `json:"top_prompts"`
+}
+
+// ModelUsage is one model's share of a report
+type ModelUsage struct {
+	Model     string  `json:"model"`
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	TokensIn  int     `json:"tokens_in"`
+	TokensOut int     `json:"tokens_out"`
+	CostUSD   float64 `json:"cost_usd"`
+	P99Ms     float64 `json:"p99_latency_ms"`
+}
+
+// Count is how often an error or prompt occurred
+type Count struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Window returns the last complete period before now: yesterday for daily
+// reports, and last Monday to this Monday for weekly ones, in UTC
+func Window(period string, now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if period == Weekly {
+		to = to.AddDate(0, 0, -(int(to.Weekday())+6)%7)
+		return to.AddDate(0, 0, -7), to
+	}
+	return to.AddDate(0, 0, -1), to
+}
+
+// Build summarizes the requests and user prompts of a period
+func Build(period string, from, to time.Time, records []requestlog.Record, prompts []string) Report {
+	r := Report{Period: period, From: from, To: to}
+	byModel := make(map[string]*ModelUsage)
+	latencies := make(map[string][]float64)
+	var all []float64
+	errors := make(map[string]int)
+	for _, rec := range records {
+		m, ok := byModel[rec.Model]
+		if !ok {
+			m = &ModelUsage{Model: rec.Model}
+			byModel[rec.Model] = m
+		}
+		failed := rec.ErrorCode != "" || rec.Status >= 400
+		r.Requests++
+		m.Requests++
+		if failed {
+			r.Errors++
+			m.Errors++
+			code := rec.ErrorCode
+			if code == "" {
+				code = fmt.Sprintf("status %d", rec.Status)
+			}
+			errors[code]++
+		}
+		r.TokensIn += rec.TokensIn
+		r.TokensOut += rec.TokensOut
+		r.CostUSD += rec.CostUSD
+		m.TokensIn += rec.TokensIn
+		m.TokensOut += rec.TokensOut
+		m.CostUSD += rec.CostUSD
+		if !failed {
+			all = append(all, rec.LatencyMs)
+			latencies[rec.Model] = append(latencies[rec.Model], rec.LatencyMs)
+		}
+	}
+	r.P50Ms, r.P99Ms = percentile(all, 50), percentile(all, 99)
+	for model, m := range byModel {
+		m.P99Ms = percentile(latencies[model], 99)
+		r.Models = append(r.Models, *m)
+	}
+	sort.Slice(r.Models, func(i, j int) bool {
+		if r.Models[i].Requests != r.Models[j].Requests {
+			return r.Models[i].Requests > r.Models[j].Requests
+		}
+		return r.Models[i].Model < r.Models[j].Model
+	})
+	r.TopErrors = top(errors)
+
+	counts := make(map[string]int)
+	for _, prompt := range prompts {
+		prompt = strings.Join(strings.Fields(prompt), " ")
+		if runes := []rune(prompt); len(runes) > maxPrompt {
+			prompt = string(runes[:maxPrompt]) + "…"
+		}
+		if prompt != "" {
+			counts[prompt]++
+		}
+	}
+	r.TopPrompts = top(counts)
+	return r
+}
+
+// percentile returns the nearest-rank pth percentile of values
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := int(float64(len(sorted))*p/100+0.999999) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// top returns the most frequent values, most frequent first
+func top(counts map[string]int) []Count {
+	list := make([]Count, 0, len(counts))
+	for value, n := range counts {
+		list = append(list, Count{Value: value, Count: n})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Value < list[j].Value
+	})
+	if len(list) > topN {
+		list = list[:topN]
+	}
+	return list
+}
+
+// Title names the report, such as "aiwatch weekly report, 2025-06-02 to 2025-06-08"
+func (r Report) Title() string {
+	last := r.To.Add(-time.Nanosecond)
+	if r.Period == Daily {
+		return "aiwatch daily report, " + r.From.Format(time.DateOnly)
+	}
+	return "aiwatch " + r.Period + " report, " + r.From.Format(time.DateOnly) + " to " + last.Format(time.DateOnly)
+}
+
+// ErrorRate returns the percentage of requests that failed
+func (r Report) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return 100 * float64(r.Errors) / float64(r.Requests)
+}
+
+// Markdown renders the report as Markdown
+func (r Report) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## %s\n\n", r.Title())
+	fmt.Fprintf(&b, "- **Requests:** %d (%d errors, %.1f%%)\n", r.Requests, r.Errors, r.ErrorRate())
+	fmt.Fprintf(&b, "- **Tokens:** %d in, %d out\n", r.TokensIn, r.TokensOut)
+	fmt.Fprintf(&b, "- **Cost:** $%.2f\n", r.CostUSD)
+	fmt.Fprintf(&b, "- **Latency:** p50 %.0f ms, p99 %.0f ms\n", r.P50Ms, r.P99Ms)
+
+	if len(r.Models) > 0 {
+		b.WriteString("\n### By model\n\n| Model | Requests | Errors | Tokens in | Tokens out | Cost | p99 |\n|---|---:|---:|---:|---:|---:|---:|\n")
+		for _, m := range r.Models {
+			fmt.Fprintf(&b, "| %s | %d | %d | %d | %d | $%.2f | %.0f ms |\n", cell(m.Model), m.Requests, m.Errors, m.TokensIn, m.TokensOut, m.CostUSD, m.P99Ms)
+		}
+	}
+	for _, list := range []struct {
+		title  string
+		counts []Count
+	}{{"Top errors", r.TopErrors}, {"Top prompts", r.TopPrompts}} {
+		if len(list.counts) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n| Count | Value |\n|---:|---|\n", list.title)
+		for _, c := range list.counts {
+			fmt.Fprintf(&b, "| %d | %s |\n", c.Count, cell(c.Value))
+		}
+	}
+	return b.String()
+}
+
+// cell escapes text for a Markdown table cell
+func cell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"usd": func(v float64) string { return fmt.Sprintf("$%.2f", v) },
+	"ms":  func(v float64) string { return fmt.Sprintf("%.0f ms", v) },
+	"pct": func(v float64) string { return fmt.Sprintf("%.1f%%", v) },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body style="font-family: sans-serif">
+<h2>{{.Title}}</h2>
+<ul>
+<li><b>Requests:</b> {{.Requests}} ({{.Errors}} errors, {{pct .ErrorRate}})</li>
+<li><b>Tokens:</b> {{.TokensIn}} in, {{.TokensOut}} out</li>
+<li><b>Cost:</b> {{usd .CostUSD}}</li>
+<li><b>Latency:</b> p50 {{ms .P50Ms}}, p99 {{ms .P99Ms}}</li>
+</ul>
+{{- if .Models}}
+<h3>By model</h3>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Model</th><th>Requests</th><th>Errors</th><th>Tokens in</th><th>Tokens out</th><th>Cost</th><th>p99</th></tr>
+{{- range .Models}}
+<tr><td>{{.Model}}</td><td>{{.Requests}}</td><td>{{.Errors}}</td><td>{{.TokensIn}}</td><td>{{.TokensOut}}</td><td>{{usd .CostUSD}}</td><td>{{ms .P99Ms}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .TopErrors}}
+<h3>Top errors</h3>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Count</th><th>Error</th></tr>
+{{- range .TopErrors}}
+<tr><td>{{.Count}}</td><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .TopPrompts}}
+<h3>Top prompts</h3>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Count</th><th>Prompt</th></tr>
+{{- range .TopPrompts}}
+<tr><td>{{.Count}}</td><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body></html>
+`))
+
+// HTML renders the report as an HTML page
+func (r Report) HTML() string {
+	var buf bytes.Buffer
+	if err := htmlReport.Execute(&buf, r); err != nil {
+		return "<p>" + template.HTMLEscapeString(err.Error()) + "</p>"
+	}
+	return buf.String()
+}
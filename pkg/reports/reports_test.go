@@ -0,0 +1,150 @@
+package reports
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/requestlog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWindowAndNext(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2025, 6, 4, 9, 30, 0, 0, time.UTC)
+	if from, to := Window(Daily, now); !from.Equal(time.Date(2025, 6, 3, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2025, 6, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("daily window %v to %v", from, to)
+	}
+	if from, to := Window(Weekly, now); !from.Equal(time.Date(2025, 5, 26, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("weekly window %v to %v", from, to)
+	}
+
+	s := New(Config{Periods: []string{Daily, Weekly}, Hour: 8}, Source{}, nil, Metrics{})
+	due, at := s.Next(now)
+	if len(due) != 1 || due[0] != Daily || !at.Equal(time.Date(2025, 6, 5, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("next after Wednesday 09:30 = %v at %v", due, at)
+	}
+	// Sunday evening: the next run is Monday 08:00, daily and weekly together
+	due, at = s.Next(time.Date(2025, 6, 8, 20, 0, 0, 0, time.UTC))
+	if len(due) != 2 || !at.Equal(time.Date(2025, 6, 9, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("next after Sunday = %v at %v", due, at)
+	}
+}
+
+func TestBuildSummarizes(t *testing.T) {
+	var records []requestlog.Record
+	for i := 1; i <= 100; i++ {
+		records = append(records, requestlog.Record{Model: "a", Status: 200, TokensIn: 10, TokensOut: 20, LatencyMs: float64(i), CostUSD: 0.01})
+	}
+	records = append(records,
+		requestlog.Record{Model: "b", Status: 502, ErrorCode: "upstream_error", LatencyMs: 5000},
+		requestlog.Record{Model: "b", Status: 502, ErrorCode: "upstream_error"},
+		requestlog.Record{Model: "b", Status: 429})
+	prompts := []string{"hello", "  hello ", "translate | this", "hello", strings.Repeat("x", 200)}
+
+	from, to := Window(Daily, time.Now())
+	r := Build(Daily, from, to, records, prompts)
+	if r.Requests != 103 || r.Errors != 3 || r.TokensIn != 1000 || r.TokensOut != 2000 {
+		t.Errorf("unexpected totals %+v", r)
+	}
+	if r.P50Ms != 50 || r.P99Ms != 99 {
+		t.Errorf("p50 = %v, p99 = %v; failed requests should not count", r.P50Ms, r.P99Ms)
+	}
+	if len(r.Models) != 2 || r.Models[0].Model != "a" || r.Models[1].Errors != 3 {
+		t.Errorf("unexpected models %+v", r.Models)
+	}
+	if len(r.TopErrors) != 2 || r.TopErrors[0] != (Count{Value: "upstream_error", Count: 2}) || r.TopErrors[1].Value != "status 429" {
+		t.Errorf("unexpected errors %+v", r.TopErrors)
+	}
+	if r.TopPrompts[0] != (Count{Value: "hello", Count: 3}) || len([]rune(r.TopPrompts[2].Value)) != maxPrompt+1 {
+		t.Errorf("unexpected prompts %+v", r.TopPrompts)
+	}
+
+	md := r.Markdown()
+	for _, want := range []string{"## aiwatch daily report", "**Requests:** 103 (3 errors, 2.9%)", "**Cost:** $1.00", "| a | 100 | 0 |", `translate \| this`} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown lacks %q:\n%s", want, md)
+		}
+	}
+	r.TopPrompts = append(r.TopPrompts, Count{Value: "<script>", Count: 1})
+	if html := r.HTML(); !strings.Contains(html, "&lt;script&gt;") || !strings.Contains(html, "<td>upstream_error</td>") {
+		t.Errorf("unexpected html:\n%s", html)
+	}
+}
+
+func TestSendersDeliver(t *testing.T) {
+	var posted map[string]interface{}
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&posted)
+	}))
+	defer slack.Close()
+
+	var mail []byte
+	email := &Email{Addr: "smtp.example.com:587", From: "aiwatch@example.com", To: []string{"lead@example.com"},
+		send: func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+			mail = msg
+			return nil
+		}}
+	metrics := Metrics{Reports: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "reports"}, []string{"period", "channel", "result"})}
+	s := New(Config{}, Source{Requests: func(from, to time.Time) []requestlog.Record {
+		return []requestlog.Record{{Model: "a", Status: 200, TokensIn: 7}}
+	}}, []Sender{&Slack{URL: slack.URL}, email}, metrics)
+
+	r, err := s.Generate(Weekly, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if posted["text"] != r.Title() || !strings.Contains(posted["blocks"].([]interface{})[0].(map[string]interface{})["text"].(string), "7 in") {
+		t.Errorf("unexpected slack payload %v", posted)
+	}
+	for _, want := range []string{"Subject: " + r.Title(), "To: lead@example.com", "multipart/alternative", "text/html", "<li><b>Tokens:</b> 7 in"} {
+		if !strings.Contains(string(mail), want) {
+			t.Errorf("email lacks %q:\n%s", want, mail)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.Reports.WithLabelValues(Weekly, "slack", "sent")); got != 1 {
+		t.Errorf("expected 1 slack report counted, got %v", got)
+	}
+
+	slack.Close()
+	if err := s.Send(context.Background(), r); err == nil || !strings.Contains(err.Error(), "slack") {
+		t.Errorf("expected a slack error, got %v", err)
+	}
+}
+
+func TestHandlerRendersReports(t *testing.T) {
+	s := New(Config{}, Source{Prompts: func(from, to time.Time) ([]string, error) { return []string{"hi"}, nil }}, nil, Metrics{})
+	handler := s.Handler()
+	do := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/reports/daily?format=json")
+	var r Report
+	json.NewDecoder(rec.Body).Decode(&r)
+	if rec.Code != http.StatusOK || r.Period != Daily || len(r.TopPrompts) != 1 {
+		t.Errorf("json report = %d %+v", rec.Code, r)
+	}
+	rec = do(http.MethodGet, "/reports/weekly?format=html")
+	if body, _ := io.ReadAll(rec.Body); !strings.Contains(string(body), "aiwatch weekly report") {
+		t.Errorf("html report = %s", body)
+	}
+	if rec := do(http.MethodGet, "/reports/monthly"); rec.Code != http.StatusNotFound {
+		t.Errorf("monthly report = %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/reports/daily/send"); rec.Code != http.StatusBadRequest {
+		t.Errorf("send without channels = %d", rec.Code)
+	}
+}
@@ -0,0 +1,215 @@
+package reports
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/requestlog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ParsePeriods parses a comma-separated list of daily and weekly
+func ParsePeriods(value string) ([]string, error) {
+	var periods []string
+	for _, period := range strings.Split(value, ",") {
+		switch period = strings.ToLower(strings.TrimSpace(period)); period {
+		case "":
+		case Daily, Weekly:
+			periods = append(periods, period)
+		default:
+			return nil, fmt.Errorf("unknown report period %q, want daily or weekly", period)
+		}
+	}
+	return periods, nil
+}
+
+// Source supplies the data of a period
+type Source struct {
+	Requests func(from, to time.Time) []requestlog.Record
+	Prompts  func(from, to time.Time) ([]string, error) // user prompts, optional
+}
+
+// Config controls when reports are sent
+type Config struct {
+	Periods []string
+	// Hour of the day, in UTC, reports are sent. Weekly reports go out on
+	// Mondays.
+	Hour int
+}
+
+// Metrics holds the collectors the scheduler reports to
+type Metrics struct {
+	Reports *prometheus.CounterVec // labels: period, channel, result (sent|failed)
+}
+
+// Scheduler sends reports to every sender when each period ends
+type Scheduler struct {
+	config  Config
+	source  Source
+	senders []Sender
+	metrics Metrics
+	now     func() time.Time
+}
+
+// New creates a scheduler delivering to senders
+func New(config Config, source Source, senders []Sender, metrics Metrics) *Scheduler {
+	return &Scheduler{config: config, source: source, senders: senders, metrics: metrics, now: time.Now}
+}
+
+// Generate builds the report of the last complete period before now
+func (s *Scheduler) Generate(period string, now time.Time) (Report, error) {
+	from, to := Window(period, now)
+	var records []requestlog.Record
+	if s.source.Requests != nil {
+		records = s.source.Requests(from, to)
+	}
+	var prompts []string
+	if s.source.Prompts != nil {
+		var err error
+		if prompts, err = s.source.Prompts(from, to); err != nil {
+			return Report{}, fmt.Errorf("reading prompts: %w", err)
+		}
+	}
+	return Build(period, from, to, records, prompts), nil
+}
+
+// Send delivers a report to every sender, returning their errors joined
+func (s *Scheduler) Send(ctx context.Context, r Report) error {
+	var errs []error
+	for _, sender := range s.senders {
+		result := "sent"
+		if err := sender.Send(ctx, r); err != nil {
+			result = "failed"
+			errs = append(errs, fmt.Errorf("%s: %w", sender.Name(), err))
+		}
+		if s.metrics.Reports != nil {
+			s.metrics.Reports.WithLabelValues(r.Period, sender.Name(), result).Inc()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Next returns the periods whose reports are due soonest after now, and when
+func (s *Scheduler) Next(now time.Time) ([]string, time.Time) {
+	now = now.UTC()
+	var due []string
+	var next time.Time
+	for _, period := range s.config.Periods {
+		at := time.Date(now.Year(), now.Month(), now.Day(), s.config.Hour, 0, 0, 0, time.UTC)
+		if period == Weekly {
+			at = at.AddDate(0, 0, (8-int(at.Weekday()))%7)
+		}
+		for !at.After(now) {
+			if period == Weekly {
+				at = at.AddDate(0, 0, 7)
+			} else {
+				at = at.AddDate(0, 0, 1)
+			}
+		}
+		switch {
+		case next.IsZero() || at.Before(next):
+			due, next = []string{period}, at
+		case at.Equal(next):
+			due = append(due, period)
+		}
+	}
+	return due, next
+}
+
+// Start sends reports as they fall due until ctx is cancelled. Reports due
+// while aiwatch was down are not caught up.
+func (s *Scheduler) Start(ctx context.Context) {
+	if len(s.config.Periods) == 0 {
+		return
+	}
+	go func() {
+		log := logger.GetLogger()
+		for {
+			due, at := s.Next(s.now())
+			timer := time.NewTimer(at.Sub(s.now()))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			for _, period := range due {
+				r, err := s.Generate(period, at)
+				if err == nil {
+					err = s.Send(ctx, r)
+				}
+				if err != nil {
+					log.Warn().Err(err).Str("period", period).Msg("Failed to send usage report")
+				} else {
+					log.Info().Str("period", period).Int("requests", r.Requests).Msg("Sent usage report")
+				}
+			}
+		}
+	}()
+}
+
+// Handler serves reports on demand:
+//
+//	GET  /reports/{period}       the last complete daily or weekly report;
+//	                             format=markdown (default), html or json
+//	POST /reports/{period}/send  sends it to the configured channels now
+func (s *Scheduler) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /reports/{period}", func(w http.ResponseWriter, r *http.Request) {
+		report, ok := s.generate(w, r)
+		if !ok {
+			return
+		}
+		switch r.URL.Query().Get("format") {
+		case "", "markdown":
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			w.Write([]byte(report.Markdown()))
+		case "html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(report.HTML()))
+		case "json":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(report)
+		default:
+			apierror.Write(w, r, apierror.InvalidRequest, "format must be markdown, html or json")
+		}
+	})
+	mux.HandleFunc("POST /reports/{period}/send", func(w http.ResponseWriter, r *http.Request) {
+		if len(s.senders) == 0 {
+			apierror.Write(w, r, apierror.InvalidRequest, "no report channels are configured")
+			return
+		}
+		report, ok := s.generate(w, r)
+		if !ok {
+			return
+		}
+		if err := s.Send(r.Context(), report); err != nil {
+			apierror.Write(w, r, apierror.UpstreamError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// generate builds the report named by the request's path
+func (s *Scheduler) generate(w http.ResponseWriter, r *http.Request) (Report, bool) {
+	period := r.PathValue("period")
+	if period != Daily && period != Weekly {
+		apierror.Write(w, r, apierror.NotFound, "period must be daily or weekly")
+		return Report{}, false
+	}
+	report, err := s.Generate(period, s.now())
+	if err != nil {
+		apierror.Write(w, r, apierror.Internal, err.Error())
+		return Report{}, false
+	}
+	return report, true
+}
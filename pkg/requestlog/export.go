@@ -0,0 +1,316 @@
+package requestlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// kind is how a column is typed in Parquet
+type kind int
+
+const (
+	kindString kind = iota
+	kindInt32
+	kindInt64
+	kindDouble
+	kindTime
+)
+
+// column is one exported field of a record
+type column struct {
+	name  string
+	kind  kind
+	value func(Record) interface{} // string, int, float64 or time.Time
+}
+
+// columns are the exported fields, in order
+var columns = []column{
+	{"time", kindTime, func(r Record) interface{} { return r.Time }},
+	{"request_id", kindString, func(r Record) interface{} { return r.RequestID }},
+	{"endpoint", kindString, func(r Record) interface{} { return r.Endpoint }},
+	{"model", kindString, func(r Record) interface{} { return r.Model }},
+	{"tenant", kindString, func(r Record) interface{} { return r.Tenant }},
+	{"client", kindString, func(r Record) interface{} { return r.Client }},
+	{"status", kindInt32, func(r Record) interface{} { return r.Status }},
+	{"error_code", kindString, func(r Record) interface{} { return r.ErrorCode }},
+	{"tokens_in", kindInt64, func(r Record) interface{} { return r.TokensIn }},
+	{"tokens_out", kindInt64, func(r Record) interface{} { return r.TokensOut }},
+	{"latency_ms", kindDouble, func(r Record) interface{} { return r.LatencyMs }},
+	{"first_token_ms", kindDouble, func(r Record) interface{} { return r.FirstTokenMs }},
+	{"tokens_per_second", kindDouble, func(r Record) interface{} { return r.TokensPerSecond }},
+	{"cost_usd", kindDouble, func(r Record) interface{} { return r.CostUSD }},
+}
+
+// WriteCSV writes records as CSV with a header row. Times are RFC 3339 in UTC.
+func WriteCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	row := make([]string, len(columns))
+	for i, c := range columns {
+		row[i] = c.name
+	}
+	if err := cw.Write(row); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		for i, c := range columns {
+			switch v := c.value(rec).(type) {
+			case string:
+				row[i] = v
+			case int:
+				row[i] = strconv.Itoa(v)
+			case float64:
+				row[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			case time.Time:
+				row[i] = v.UTC().Format(time.RFC3339Nano)
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Parquet enum values, from parquet.thrift
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired        = 0
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+// parquetMagic starts and ends every Parquet file
+const parquetMagic = "PAR1"
+
+// physical returns the Parquet physical type of a column
+func (k kind) physical() int32 {
+	switch k {
+	case kindString:
+		return parquetByteArray
+	case kindInt32:
+		return parquetInt32
+	case kindDouble:
+		return parquetDouble
+	default:
+		return parquetInt64
+	}
+}
+
+// WriteParquet writes records as a Parquet file with one row group and
+// one uncompressed, PLAIN-encoded page per column. Every column is
+// required; times are UTC timestamps in milliseconds.
+func WriteParquet(w io.Writer, records []Record) error {
+	var body bytes.Buffer
+	body.WriteString(parquetMagic)
+
+	type chunk struct {
+		offset, size int64
+	}
+	chunks := make([]chunk, len(columns))
+	for i, c := range columns {
+		var page bytes.Buffer
+		for _, rec := range records {
+			switch v := c.value(rec).(type) {
+			case string:
+				binary.Write(&page, binary.LittleEndian, uint32(len(v)))
+				page.WriteString(v)
+			case int:
+				if c.kind == kindInt32 {
+					binary.Write(&page, binary.LittleEndian, int32(v))
+				} else {
+					binary.Write(&page, binary.LittleEndian, int64(v))
+				}
+			case float64:
+				binary.Write(&page, binary.LittleEndian, math.Float64bits(v))
+			case time.Time:
+				binary.Write(&page, binary.LittleEndian, v.UnixMilli())
+			}
+		}
+
+		var header compact
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(page.Len()))
+		header.beginStruct(5)
+		header.i32(1, int32(len(records)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.endStruct()
+		header.stop()
+
+		chunks[i] = chunk{offset: int64(body.Len()), size: int64(header.buf.Len() + page.Len())}
+		body.Write(header.buf.Bytes())
+		body.Write(page.Bytes())
+	}
+
+	var meta compact
+	meta.i32(1, 1) // version
+	meta.list(2, ctStruct, len(columns)+1)
+	meta.beginElement()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.endStruct()
+	for _, c := range columns {
+		meta.beginElement()
+		meta.i32(1, c.kind.physical())
+		meta.i32(3, parquetRequired)
+		meta.binary(4, c.name)
+		switch c.kind {
+		case kindString:
+			meta.i32(6, parquetUTF8)
+		case kindTime:
+			meta.i32(6, parquetTimestampMillis)
+		}
+		meta.endStruct()
+	}
+	meta.i64(3, int64(len(records)))
+	if len(records) == 0 {
+		meta.list(4, ctStruct, 0)
+	} else {
+		meta.list(4, ctStruct, 1)
+		meta.beginElement()
+		meta.list(1, ctStruct, len(columns))
+		var total int64
+		for i, c := range columns {
+			meta.beginElement()
+			meta.i64(2, chunks[i].offset) // file_offset
+			meta.beginStruct(3)
+			meta.i32(1, c.kind.physical())
+			meta.list(2, ctI32, 2)
+			meta.listI32(parquetPlain)
+			meta.listI32(parquetRLE)
+			meta.list(3, ctBinary, 1)
+			meta.listBinary(c.name)
+			meta.i32(4, parquetUncompressed)
+			meta.i64(5, int64(len(records)))
+			meta.i64(6, chunks[i].size)
+			meta.i64(7, chunks[i].size)
+			meta.i64(9, chunks[i].offset) // data_page_offset
+			meta.endStruct()
+			meta.endStruct()
+			total += chunks[i].size
+		}
+		meta.i64(2, total)
+		meta.i64(3, int64(len(records)))
+		meta.endStruct()
+	}
+	meta.binary(6, "aiwatch")
+	meta.stop()
+
+	body.Write(meta.buf.Bytes())
+	binary.Write(&body, binary.LittleEndian, uint32(meta.buf.Len()))
+	body.WriteString(parquetMagic)
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+// Thrift compact protocol types
+const (
+	ctI32    = 5
+	ctI64    = 6
+	ctBinary = 8
+	ctList   = 9
+	ctStruct = 12
+)
+
+// compact writes the Thrift compact protocol Parquet uses for its metadata
+type compact struct {
+	buf   bytes.Buffer
+	last  int16   // ID of the previous field in the current struct
+	outer []int16 // last of the enclosing structs
+}
+
+func (c *compact) field(id int16, typ byte) {
+	if delta := id - c.last; delta > 0 && delta <= 15 {
+		c.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		c.buf.WriteByte(typ)
+		c.varint(zigzag(int64(id)))
+	}
+	c.last = id
+}
+
+func (c *compact) varint(v uint64) {
+	for v >= 0x80 {
+		c.buf.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	c.buf.WriteByte(byte(v))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (c *compact) i32(id int16, v int32) {
+	c.field(id, ctI32)
+	c.varint(zigzag(int64(v)))
+}
+
+func (c *compact) i64(id int16, v int64) {
+	c.field(id, ctI64)
+	c.varint(zigzag(v))
+}
+
+func (c *compact) binary(id int16, s string) {
+	c.field(id, ctBinary)
+	c.listBinary(s)
+}
+
+// list starts a list field of n elements of type elem
+func (c *compact) list(id int16, elem byte, n int) {
+	c.field(id, ctList)
+	if n < 15 {
+		c.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		c.buf.WriteByte(0xf0 | elem)
+		c.varint(uint64(n))
+	}
+}
+
+func (c *compact) listI32(v int32) {
+	c.varint(zigzag(int64(v)))
+}
+
+func (c *compact) listBinary(s string) {
+	c.varint(uint64(len(s)))
+	c.buf.WriteString(s)
+}
+
+// beginStruct starts a struct field; beginElement starts a struct in a list
+func (c *compact) beginStruct(id int16) {
+	c.field(id, ctStruct)
+	c.beginElement()
+}
+
+func (c *compact) beginElement() {
+	c.outer = append(c.outer, c.last)
+	c.last = 0
+}
+
+func (c *compact) endStruct() {
+	c.stop()
+	c.last = c.outer[len(c.outer)-1]
+	c.outer = c.outer[:len(c.outer)-1]
+}
+
+// stop ends the top-level struct
+func (c *compact) stop() {
+	c.buf.WriteByte(0)
+}
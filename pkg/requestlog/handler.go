@@ -0,0 +1,89 @@
+package requestlog
+
+import (
+	"bufio"
+	"net/http"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+)
+
+// Export formats
+const (
+	CSV     = "csv"
+	Parquet = "parquet"
+)
+
+// Handler serves GET /export/requests, the logged requests oldest first.
+// Query parameters:
+//
+//	from, to      YYYY-MM-DD or RFC 3339; a "to" date includes that day
+//	model         only requests to this model
+//	tenant        only requests of this tenant
+//	format        csv (default) or parquet
+func (l *Log) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /export/requests", func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+		format := values.Get("format")
+		switch format {
+		case "":
+			format = CSV
+		case CSV, Parquet:
+		default:
+			apierror.Write(w, r, apierror.InvalidRequest, "format must be csv or parquet")
+			return
+		}
+		f := Filter{Model: values.Get("model"), Tenant: values.Get("tenant")}
+		var err error
+		if f.From, err = parseTime(values.Get("from"), false); err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, "from must be a date (YYYY-MM-DD) or RFC 3339 time")
+			return
+		}
+		if f.To, err = parseTime(values.Get("to"), true); err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, "to must be a date (YYYY-MM-DD) or RFC 3339 time")
+			return
+		}
+
+		records := l.Records(f)
+		filename := "requests-" + time.Now().UTC().Format(time.DateOnly) + "." + format
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		if format == Parquet {
+			w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+			err = WriteParquet(w, records)
+		} else {
+			w.Header().Set("Content-Type", "text/csv")
+			buffered := bufio.NewWriter(w)
+			if err = WriteCSV(buffered, records); err == nil {
+				err = buffered.Flush()
+			}
+		}
+		if err != nil {
+			// The client went away; the status is already sent
+			log := logger.GetLogger()
+			log.Debug().Err(err).Msg("Request log export interrupted")
+			return
+		}
+		if l.metrics.Exported != nil {
+			l.metrics.Exported.WithLabelValues(format).Inc()
+		}
+	})
+	return mux
+}
+
+// parseTime accepts an RFC 3339 time or a date, taken as the start of the
+// day, or the start of the next when endOfDay is set. Empty is the zero time.
+func parseTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err == nil && endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, err
+}
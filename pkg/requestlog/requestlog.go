@@ -0,0 +1,301 @@
+// Package requestlog keeps a per-request log of served chats, with tokens,
+// latency, status and cost, and exports it as CSV or Parquet for offline
+// analysis that aggregated Prometheus histograms can't answer.
+package requestlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/tenants"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Record is one served request
+type Record struct {
+	Time            time.Time `json:"time"` // when it completed
+	RequestID       string    `json:"request_id,omitempty"`
+	Endpoint        string    `json:"endpoint"`
+	Model           string    `json:"model"`
+	Tenant          string    `json:"tenant,omitempty"` // empty for the default tenant
+	Client          string    `json:"client,omitempty"` // masked API key or session
+	Owner           string    `json:"owner,omitempty"`  // who it is erased with; not exported
+	Status          int       `json:"status"`
+	ErrorCode       string    `json:"error_code,omitempty"`
+	TokensIn        int       `json:"tokens_in"`
+	TokensOut       int       `json:"tokens_out"`
+	LatencyMs       float64   `json:"latency_ms"`
+	FirstTokenMs    float64   `json:"first_token_ms,omitempty"`
+	TokensPerSecond float64   `json:"tokens_per_second,omitempty"`
+	CostUSD         float64   `json:"cost_usd"`
+}
+
+// Price is what a model's tokens cost, in USD per million
+type Price struct {
+	Input  float64
+	Output float64
+}
+
+// ParsePrices parses "model=input/output,..." into prices per model, with
+// both prices in USD per million tokens
+func ParsePrices(value string) (map[string]Price, error) {
+	prices := make(map[string]Price)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		model, rates, ok := strings.Cut(pair, "=")
+		in, out, hasOut := strings.Cut(rates, "/")
+		input, errIn := strconv.ParseFloat(strings.TrimSpace(in), 64)
+		output, errOut := strconv.ParseFloat(strings.TrimSpace(out), 64)
+		if !ok || !hasOut || strings.TrimSpace(model) == "" || errIn != nil || errOut != nil || input < 0 || output < 0 {
+			return nil, fmt.Errorf("invalid model price %q, want model=input/output in USD per million tokens", pair)
+		}
+		prices[strings.TrimSpace(model)] = Price{Input: input, Output: output}
+	}
+	return prices, nil
+}
+
+// Cost returns the cost of a request's tokens
+func (p Price) Cost(tokensIn, tokensOut int) float64 {
+	return (float64(tokensIn)*p.Input + float64(tokensOut)*p.Output) / 1e6
+}
+
+// Config controls how much of the log is kept and where
+type Config struct {
+	// MaxRecords is how many of the most recent requests are kept at most
+	MaxRecords int
+	// Path, if set, persists the log as JSON lines so it survives restarts
+	Path string
+	// Prices by model, for the cost of each request
+	Prices map[string]Price
+}
+
+// DefaultConfig keeps the last 100,000 requests in memory
+func DefaultConfig() Config {
+	return Config{MaxRecords: 100000}
+}
+
+// Metrics holds the collectors the log reports to
+type Metrics struct {
+	Entries  prometheus.Gauge
+	Exported *prometheus.CounterVec // labels: format
+}
+
+// Log is a bounded, optionally persisted, log of requests
+type Log struct {
+	config  Config
+	metrics Metrics
+
+	mu      sync.Mutex
+	records []Record // oldest first
+	file    *os.File
+	lines   int // in the file, to know when to compact it
+}
+
+// New creates a log, loading the records persisted at config.Path
+func New(config Config, metrics Metrics) (*Log, error) {
+	if config.MaxRecords <= 0 {
+		config.MaxRecords = DefaultConfig().MaxRecords
+	}
+	l := &Log{config: config, metrics: metrics}
+	if config.Path == "" {
+		return l, nil
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	if err := l.compact(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// load reads the persisted records, keeping the newest MaxRecords
+func (l *Log) load() error {
+	f, err := os.Open(l.config.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open request log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// A line torn by a crash; the rest of the log is still good
+			continue
+		}
+		l.records = append(l.records, rec)
+		if len(l.records) > 2*l.config.MaxRecords {
+			l.records = append([]Record(nil), l.records[len(l.records)-l.config.MaxRecords:]...)
+		}
+	}
+	if len(l.records) > l.config.MaxRecords {
+		l.records = l.records[len(l.records)-l.config.MaxRecords:]
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the file with only the records kept, and reopens it for
+// appending. Callers must hold l.mu or own l exclusively.
+func (l *Log) compact() error {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	tmp := l.config.Path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write request log: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rec := range l.records {
+		if err := enc.Encode(rec); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.config.Path); err != nil {
+		return fmt.Errorf("failed to write request log: %w", err)
+	}
+
+	l.file, err = os.OpenFile(l.config.Path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open request log: %w", err)
+	}
+	l.lines = len(l.records)
+	return nil
+}
+
+// Add appends a record, stamping its time and pricing it if unset
+func (l *Log) Add(rec Record) {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	rec.Time = rec.Time.UTC()
+	if price, ok := l.config.Prices[rec.Model]; ok && rec.CostUSD == 0 {
+		rec.CostUSD = price.Cost(rec.TokensIn, rec.TokensOut)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, rec)
+	if len(l.records) > l.config.MaxRecords {
+		// Drop the oldest in batches so appends stay cheap
+		drop := max(len(l.records)-l.config.MaxRecords, l.config.MaxRecords/10)
+		l.records = append([]Record(nil), l.records[drop:]...)
+	}
+	if l.metrics.Entries != nil {
+		l.metrics.Entries.Set(float64(len(l.records)))
+	}
+	if l.file == nil {
+		return
+	}
+
+	log := logger.GetLogger()
+	if l.lines >= 2*l.config.MaxRecords {
+		if err := l.compact(); err != nil {
+			log.Warn().Err(err).Msg("Failed to compact request log")
+		}
+		return
+	}
+	data, err := json.Marshal(rec)
+	if err == nil {
+		_, err = l.file.Write(append(data, '\n'))
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to persist request log record")
+		return
+	}
+	l.lines++
+}
+
+// Filter selects records for export
+type Filter struct {
+	From, To time.Time // completed at or after From and before To, if set
+	Model    string
+	Tenant   string // all tenants if empty
+}
+
+// Records returns the records passing f, oldest first
+func (l *Log) Records(f Filter) []Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Records are appended in completion order, so the range can be found
+	// by binary search
+	start := 0
+	if !f.From.IsZero() {
+		start = sort.Search(len(l.records), func(i int) bool { return !l.records[i].Time.Before(f.From) })
+	}
+	end := len(l.records)
+	if !f.To.IsZero() {
+		end = sort.Search(len(l.records), func(i int) bool { return !l.records[i].Time.Before(f.To) })
+	}
+	var out []Record
+	for _, rec := range l.records[start:max(start, end)] {
+		if (f.Model == "" || rec.Model == f.Model) && (f.Tenant == "" || tenants.Same(rec.Tenant, f.Tenant)) {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// DeleteWhere removes the records passing match, rewriting the persisted
+// log, and returns how many were removed
+func (l *Log) DeleteWhere(match func(Record) bool) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	kept := l.records[:0]
+	for _, rec := range l.records {
+		if !match(rec) {
+			kept = append(kept, rec)
+		}
+	}
+	removed := len(l.records) - len(kept)
+	clear(l.records[len(kept):])
+	l.records = kept
+	if removed == 0 {
+		return 0, nil
+	}
+	if l.metrics.Entries != nil {
+		l.metrics.Entries.Set(float64(len(l.records)))
+	}
+	if l.file == nil {
+		return removed, nil
+	}
+	return removed, l.compact()
+}
+
+// Close closes the persisted log
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
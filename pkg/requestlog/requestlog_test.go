@@ -0,0 +1,312 @@
+package requestlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParsePrices(t *testing.T) {
+	prices, err := ParsePrices("ai/llama3.2=0.1/0.4, ai/gemma3=0/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prices["ai/llama3.2"] != (Price{Input: 0.1, Output: 0.4}) || len(prices) != 2 {
+		t.Errorf("unexpected prices %+v", prices)
+	}
+	if got := prices["ai/llama3.2"].Cost(1000000, 500000); got != 0.3 {
+		t.Errorf("cost = %v, want 0.3", got)
+	}
+	for _, bad := range []string{"ai/llama3.2=0.1", "=1/2", "m=a/b", "m=-1/2"} {
+		if _, err := ParsePrices(bad); err == nil {
+			t.Errorf("ParsePrices(%q) accepted", bad)
+		}
+	}
+}
+
+func TestLogFiltersAndPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	config := Config{MaxRecords: 3, Path: path, Prices: map[string]Price{"a": {Input: 1, Output: 2}}}
+	l, err := New(config, Metrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, model := range []string{"a", "b", "a", "b", "a"} {
+		l.Add(Record{Time: day.AddDate(0, 0, i), Model: model, TokensIn: 1000000, TokensOut: 1000000, Status: 200})
+	}
+
+	// Only the last three are kept
+	all := l.Records(Filter{})
+	if len(all) != 3 || !all[0].Time.Equal(day.AddDate(0, 0, 2)) {
+		t.Fatalf("unexpected records %+v", all)
+	}
+	if all[0].CostUSD != 3 || all[1].CostUSD != 0 {
+		t.Errorf("expected priced model a only, got %+v", all)
+	}
+	if got := l.Records(Filter{Model: "a", From: day.AddDate(0, 0, 3)}); len(got) != 1 || !got[0].Time.Equal(day.AddDate(0, 0, 4)) {
+		t.Errorf("filtered by model and from: %+v", got)
+	}
+	if got := l.Records(Filter{To: day.AddDate(0, 0, 3)}); len(got) != 1 {
+		t.Errorf("filtered by to: %+v", got)
+	}
+	if got := l.Records(Filter{Tenant: "search"}); len(got) != 0 {
+		t.Errorf("filtered by another tenant: %+v", got)
+	}
+	l.Close()
+
+	reopened, err := New(config, Metrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := reopened.Records(Filter{}); len(got) != 3 || got[2].Model != "a" {
+		t.Errorf("reloaded records %+v", got)
+	}
+}
+
+func TestLogDeleteWhere(t *testing.T) {
+	config := Config{MaxRecords: 10, Path: filepath.Join(t.TempDir(), "requests.jsonl")}
+	l, err := New(config, Metrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, owner := range []string{"session:a", "user:b", "session:a"} {
+		l.Add(Record{Model: "m", Owner: owner})
+	}
+
+	n, err := l.DeleteWhere(func(rec Record) bool { return rec.Owner == "session:a" })
+	if err != nil || n != 2 {
+		t.Fatalf("deleted %d records, %v", n, err)
+	}
+	l.Add(Record{Model: "m", Owner: "user:c"})
+	l.Close()
+
+	reopened, err := New(config, Metrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := reopened.Records(Filter{}); len(got) != 2 || got[0].Owner != "user:b" || got[1].Owner != "user:c" {
+		t.Errorf("expected the deleted records gone from the file, got %+v", got)
+	}
+}
+
+func TestHandlerExportsCSVAndParquet(t *testing.T) {
+	metrics := Metrics{Exported: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "exported"}, []string{"format"})}
+	l, _ := New(DefaultConfig(), metrics)
+	l.Add(Record{Endpoint: "/chat", Model: "ai/llama", Status: 200, TokensIn: 12, TokensOut: 34, LatencyMs: 56.5})
+	l.Add(Record{Endpoint: "/v1/chat/completions", Model: "ai/gemma", Status: 502, ErrorCode: "upstream_error"})
+	handler := l.Handler()
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	rec := get("/export/requests?model=ai/llama&from=2000-01-01")
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(rows) != 2 || rows[0][3] != "model" || rows[1][3] != "ai/llama" || rows[1][8] != "12" || rows[1][10] != "56.5" {
+		t.Errorf("csv export = %d %v", rec.Code, rows)
+	}
+
+	rec = get("/export/requests?format=parquet")
+	body := rec.Body.Bytes()
+	if rec.Code != http.StatusOK || !bytes.HasPrefix(body, []byte("PAR1")) || !bytes.HasSuffix(body, []byte("PAR1")) {
+		t.Fatalf("parquet export = %d %q", rec.Code, body)
+	}
+	if meta := parquetFooter(t, body); meta[3] != int64(2) {
+		t.Errorf("parquet export has %v rows, want 2", meta[3])
+	}
+	if got := testutil.ToFloat64(metrics.Exported.WithLabelValues(Parquet)); got != 1 {
+		t.Errorf("expected 1 parquet export counted, got %v", got)
+	}
+
+	for _, target := range []string{"/export/requests?format=xlsx", "/export/requests?from=monday"} {
+		if rec := get(target); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", target, rec.Code)
+		}
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	records := []Record{
+		{Time: at, RequestID: "req-1", Endpoint: "/chat", Model: "ai/llama", Tenant: "search", Client: "key…abcd", Status: 200,
+			TokensIn: 12, TokensOut: 34, LatencyMs: 56.5, FirstTokenMs: 7.25, TokensPerSecond: 601.5, CostUSD: 0.002},
+		{Time: at.Add(time.Second), Endpoint: "/v1/chat/completions", Model: "ai/gemma", Status: 502, ErrorCode: "upstream_error"},
+	}
+	var buf bytes.Buffer
+	if err := WriteParquet(&buf, records); err != nil {
+		t.Fatal(err)
+	}
+	body := buf.Bytes()
+	meta := parquetFooter(t, body)
+	if meta[3] != int64(2) {
+		t.Fatalf("num_rows = %v, want 2", meta[3])
+	}
+
+	// Physical type, converted type (-1 for none) and first row of each column
+	want := []struct {
+		name      string
+		physical  int64
+		converted int64
+		first     interface{}
+	}{
+		{"time", parquetInt64, parquetTimestampMillis, at.UnixMilli()},
+		{"request_id", parquetByteArray, parquetUTF8, "req-1"},
+		{"endpoint", parquetByteArray, parquetUTF8, "/chat"},
+		{"model", parquetByteArray, parquetUTF8, "ai/llama"},
+		{"tenant", parquetByteArray, parquetUTF8, "search"},
+		{"client", parquetByteArray, parquetUTF8, "key…abcd"},
+		{"status", parquetInt32, -1, int64(200)},
+		{"error_code", parquetByteArray, parquetUTF8, ""},
+		{"tokens_in", parquetInt64, -1, int64(12)},
+		{"tokens_out", parquetInt64, -1, int64(34)},
+		{"latency_ms", parquetDouble, -1, 56.5},
+		{"first_token_ms", parquetDouble, -1, 7.25},
+		{"tokens_per_second", parquetDouble, -1, 601.5},
+		{"cost_usd", parquetDouble, -1, 0.002},
+	}
+	schema := meta[2].([]interface{})
+	if len(schema) != len(want)+1 || schema[0].(map[int16]interface{})[5] != int64(len(want)) {
+		t.Fatalf("schema has %d elements, want a root and %d columns", len(schema), len(want))
+	}
+	chunks := meta[4].([]interface{})[0].(map[int16]interface{})[1].([]interface{})
+	for i, col := range want {
+		element := schema[i+1].(map[int16]interface{})
+		converted, ok := element[6]
+		if !ok {
+			converted = int64(-1)
+		}
+		if element[4] != col.name || element[1] != col.physical || converted != col.converted {
+			t.Errorf("schema element %d = %v, want %s of type %d/%d", i, element, col.name, col.physical, col.converted)
+			continue
+		}
+
+		chunk := chunks[i].(map[int16]interface{})[3].(map[int16]interface{})
+		r := bytes.NewReader(body[chunk[9].(int64):])
+		header := thriftStruct(t, r)
+		if header[5].(map[int16]interface{})[1] != int64(2) {
+			t.Errorf("%s: page holds %v values, want 2", col.name, header[5])
+		}
+		var got interface{}
+		switch col.physical {
+		case parquetByteArray:
+			var n uint32
+			binary.Read(r, binary.LittleEndian, &n)
+			value := make([]byte, n)
+			r.Read(value)
+			got = string(value)
+		case parquetInt32:
+			var v int32
+			binary.Read(r, binary.LittleEndian, &v)
+			got = int64(v)
+		case parquetInt64:
+			var v int64
+			binary.Read(r, binary.LittleEndian, &v)
+			got = v
+		case parquetDouble:
+			var v uint64
+			binary.Read(r, binary.LittleEndian, &v)
+			got = math.Float64frombits(v)
+		}
+		if got != col.first {
+			t.Errorf("%s: first value %v, want %v", col.name, got, col.first)
+		}
+	}
+}
+
+// parquetFooter checks the magic bytes of a Parquet file and decodes its
+// FileMetaData into Thrift field IDs and values
+func parquetFooter(t *testing.T, body []byte) map[int16]interface{} {
+	t.Helper()
+	if len(body) < 12 || !bytes.HasPrefix(body, []byte("PAR1")) || !bytes.HasSuffix(body, []byte("PAR1")) {
+		t.Fatalf("not a parquet file: %q", body)
+	}
+	size := int(binary.LittleEndian.Uint32(body[len(body)-8:]))
+	if size > len(body)-12 {
+		t.Fatalf("footer length %d overruns the file", size)
+	}
+	r := bytes.NewReader(body[len(body)-8-size : len(body)-8])
+	meta := thriftStruct(t, r)
+	if r.Len() != 0 {
+		t.Fatalf("%d bytes left after the footer", r.Len())
+	}
+	return meta
+}
+
+// thriftStruct decodes a Thrift compact protocol struct. Integers decode to
+// int64, binaries to string, lists to []interface{} and structs to maps.
+func thriftStruct(t *testing.T, r *bytes.Reader) map[int16]interface{} {
+	t.Helper()
+	fields := make(map[int16]interface{})
+	var last int16
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("truncated struct: %v", err)
+		}
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(thriftInt(t, r))
+		}
+		last = id
+		fields[id] = thriftValue(t, r, b&0x0f)
+	}
+}
+
+func thriftValue(t *testing.T, r *bytes.Reader, typ byte) interface{} {
+	switch typ {
+	case 1, 2:
+		return typ == 1
+	case ctI32, ctI64:
+		return thriftInt(t, r)
+	case ctBinary:
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			t.Fatalf("bad binary length %d: %v", n, err)
+		}
+		value := make([]byte, n)
+		r.Read(value)
+		return string(value)
+	case ctList:
+		header, _ := r.ReadByte()
+		n := uint64(header >> 4)
+		if n == 15 {
+			n, _ = binary.ReadUvarint(r)
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = thriftValue(t, r, header&0x0f)
+		}
+		return list
+	case ctStruct:
+		return thriftStruct(t, r)
+	}
+	t.Fatalf("unexpected thrift type %d", typ)
+	return nil
+}
+
+func thriftInt(t *testing.T, r *bytes.Reader) int64 {
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		t.Fatalf("bad varint: %v", err)
+	}
+	return int64(v>>1) ^ -int64(v&1)
+}
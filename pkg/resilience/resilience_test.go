@@ -0,0 +1,107 @@
+package resilience
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testMetrics() Metrics {
+	return Metrics{
+		Retries:      prometheus.NewCounterVec(prometheus.CounterOpts{Name: "retries"}, []string{"backend", "reason"}),
+		CircuitState: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "state"}, []string{"backend"}),
+	}
+}
+
+func TestTransportRetriesTransientFailures(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	metrics := testMetrics()
+	cfg := Config{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, FailureThreshold: 10, OpenTimeout: time.Minute}
+	client := &http.Client{Transport: NewTransport(nil, cfg, metrics)}
+
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "payload" {
+		t.Errorf("expected body replayed on the final attempt, got %d %q", resp.StatusCode, body)
+	}
+	host := strings.TrimPrefix(server.URL, "http://")
+	if got := testutil.ToFloat64(metrics.Retries.WithLabelValues(host, "server_error")); got != 2 {
+		t.Errorf("expected 2 retries, got %v", got)
+	}
+}
+
+func TestTransportOpensCircuit(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	metrics := testMetrics()
+	cfg := Config{MaxRetries: 0, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, FailureThreshold: 2, OpenTimeout: time.Minute}
+	client := &http.Client{Transport: NewTransport(nil, cfg, metrics)}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if _, err := client.Get(server.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected open circuit, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected open circuit to skip the backend, got %d calls", calls.Load())
+	}
+	host := strings.TrimPrefix(server.URL, "http://")
+	if got := testutil.ToFloat64(metrics.CircuitState.WithLabelValues(host)); got != float64(StateOpen) {
+		t.Errorf("expected circuit state gauge %v, got %v", StateOpen, got)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	b := NewBreaker(1, time.Second, nil)
+	b.now = func() time.Time { return now }
+
+	b.Failure()
+	if b.Allow() != ErrCircuitOpen {
+		t.Fatal("expected breaker to be open")
+	}
+
+	now = now.Add(2 * time.Second)
+	if b.Allow() != nil || b.State() != StateHalfOpen {
+		t.Fatal("expected a trial request after the open timeout")
+	}
+	if b.Allow() != ErrCircuitOpen {
+		t.Error("expected only one trial request while half-open")
+	}
+	b.Success()
+	if b.State() != StateClosed || b.Allow() != nil {
+		t.Error("expected breaker to close after a successful trial")
+	}
+}
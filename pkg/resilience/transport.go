@@ -0,0 +1,175 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Config controls retries and circuit breaking for upstream calls
+type Config struct {
+	MaxRetries       int           // retries after the first attempt
+	BaseDelay        time.Duration // first backoff, doubled on each retry
+	MaxDelay         time.Duration // cap on a single backoff
+	FailureThreshold int           // consecutive failures that open a circuit
+	OpenTimeout      time.Duration // how long a circuit stays open before a trial request
+}
+
+// DefaultConfig returns the default resilience settings
+func DefaultConfig() Config {
+	return Config{
+		MaxRetries:       2,
+		BaseDelay:        200 * time.Millisecond,
+		MaxDelay:         5 * time.Second,
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+	}
+}
+
+// Metrics are the collectors the transport reports to
+type Metrics struct {
+	Retries      *prometheus.CounterVec // labels: backend, reason
+	CircuitState *prometheus.GaugeVec   // labels: backend; 0 closed, 1 half-open, 2 open
+}
+
+// Transport is an http.RoundTripper that retries transient upstream failures
+// with exponential backoff and keeps a circuit breaker per backend host
+type Transport struct {
+	next    http.RoundTripper
+	cfg     Config
+	metrics Metrics
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewTransport wraps next, which defaults to http.DefaultTransport
+func NewTransport(next http.RoundTripper, cfg Config, metrics Metrics) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Transport{
+		next:     next,
+		cfg:      cfg,
+		metrics:  metrics,
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+// Breaker returns the circuit breaker for a backend host
+func (t *Transport) Breaker(backend string) *Breaker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, ok := t.breakers[backend]
+	if !ok {
+		b = NewBreaker(t.cfg.FailureThreshold, t.cfg.OpenTimeout, func(state State) {
+			if t.metrics.CircuitState != nil {
+				t.metrics.CircuitState.WithLabelValues(backend).Set(float64(state))
+			}
+			log := logger.GetLogger()
+			log.Warn().Str("backend", backend).Str("state", state.String()).Msg("Upstream circuit breaker changed state")
+		})
+		if t.metrics.CircuitState != nil {
+			t.metrics.CircuitState.WithLabelValues(backend).Set(float64(StateClosed))
+		}
+		t.breakers[backend] = b
+	}
+	return b
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backend := req.URL.Host
+	breaker := t.Breaker(backend)
+
+	for attempt := 0; ; attempt++ {
+		if err := breaker.Allow(); err != nil {
+			return nil, err
+		}
+
+		resp, err := t.next.RoundTrip(req)
+		switch {
+		case req.Context().Err() != nil:
+			// The caller gave up; that says nothing about the backend
+		case err != nil || resp.StatusCode >= http.StatusInternalServerError:
+			breaker.Failure()
+		default:
+			breaker.Success()
+		}
+
+		// Retrying needs a fresh copy of the request body
+		reason := retryReason(req.Context(), resp, err)
+		if reason == "" || attempt >= t.cfg.MaxRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		delay := t.backoff(attempt, resp)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			body, berr := req.GetBody()
+			if berr != nil {
+				return nil, berr
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		if t.metrics.Retries != nil {
+			t.metrics.Retries.WithLabelValues(backend, reason).Inc()
+		}
+		log := logger.GetLogger()
+		log.Warn().Str("backend", backend).Str("reason", reason).Int("attempt", attempt+1).Dur("delay", delay).Msg("Retrying upstream request")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before the given retry, honoring Retry-After
+func (t *Transport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			if delay := time.Duration(seconds) * time.Second; delay <= t.cfg.MaxDelay {
+				return delay
+			}
+		}
+	}
+
+	delay := t.cfg.BaseDelay << attempt
+	if delay <= 0 || delay > t.cfg.MaxDelay {
+		delay = t.cfg.MaxDelay
+	}
+	// Full jitter over the upper half keeps retries from synchronizing
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryReason classifies an attempt as transient, returning "" when it
+// succeeded or failed in a way retrying won't fix
+func retryReason(ctx context.Context, resp *http.Response, err error) string {
+	if err != nil {
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+			return ""
+		}
+		return "connection_error"
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return "rate_limited"
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return "server_error"
+	}
+	return ""
+}
@@ -0,0 +1,149 @@
+// Package retention enforces how long stored data is kept, and erases a
+// user's data on request.
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/audit"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ParseAge parses a retention period, either a Go duration ("720h") or a
+// number of days ("30d"). Empty and zero mean keep forever.
+func ParseAge(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid retention period %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid retention period %q", value)
+	}
+	return age, nil
+}
+
+// Target is one kind of data with a retention period
+type Target struct {
+	Name   string        // e.g. history; the metric label
+	MaxAge time.Duration // zero keeps the data forever
+	// Purge removes the data older than cutoff and returns how much it removed
+	Purge func(cutoff time.Time) (int, error)
+}
+
+// Metrics holds the collectors retention reports to
+type Metrics struct {
+	Purged    *prometheus.CounterVec // labels: data
+	Deletions *prometheus.CounterVec // labels: result (success or failure)
+}
+
+// Purger periodically removes data past its retention period
+type Purger struct {
+	targets []Target
+	audit   *audit.Log
+	metrics Metrics
+	now     func() time.Time
+}
+
+// NewPurger creates a purger for targets, recording each purge in auditLog
+func NewPurger(auditLog *audit.Log, metrics Metrics, targets ...Target) *Purger {
+	return &Purger{targets: targets, audit: auditLog, metrics: metrics, now: time.Now}
+}
+
+// Run purges every target once and returns the amounts removed by target
+func (p *Purger) Run() map[string]int {
+	purged := make(map[string]int)
+	var failures []string
+	for _, target := range p.targets {
+		if target.MaxAge <= 0 {
+			continue
+		}
+		n, err := target.Purge(p.now().Add(-target.MaxAge))
+		if err != nil {
+			failures = append(failures, target.Name+": "+err.Error())
+		}
+		if n > 0 {
+			purged[target.Name] = n
+			if p.metrics.Purged != nil {
+				p.metrics.Purged.WithLabelValues(target.Name).Add(float64(n))
+			}
+		}
+	}
+
+	if len(purged) > 0 || len(failures) > 0 {
+		p.audit.Record(audit.Entry{Actor: audit.System, Action: "retention.purged", Details: purged, Error: strings.Join(failures, "; ")})
+	}
+	return purged
+}
+
+// Start purges on an interval until ctx is cancelled
+func (p *Purger) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		p.Run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.Run()
+			}
+		}
+	}()
+}
+
+// Eraser removes everything stored about a user and returns the amounts
+// removed by kind of data
+type Eraser func(ctx context.Context, user string) (map[string]int, error)
+
+// DeletionHandler serves DELETE /users/{id}/data, erasing the user's data
+// and recording the erasure in auditLog
+func DeletionHandler(erase Eraser, auditLog *audit.Log, metrics Metrics) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /users/{id}/data", func(w http.ResponseWriter, r *http.Request) {
+		user := r.PathValue("id")
+		if len(user) > 128 {
+			apierror.Write(w, r, apierror.InvalidRequest, "invalid user ID")
+			return
+		}
+
+		deleted, err := erase(r.Context(), user)
+		entry := audit.Entry{Actor: audit.Actor(r), Action: "user.data_deleted", Subject: user, Details: deleted}
+		result := "success"
+		if err != nil {
+			entry.Error, result = err.Error(), "failure"
+		}
+		if metrics.Deletions != nil {
+			metrics.Deletions.WithLabelValues(result).Inc()
+		}
+		if auditErr := auditLog.Record(entry); auditErr != nil && err == nil {
+			err = fmt.Errorf("data erased but the audit entry was not written: %w", auditErr)
+		}
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal, err.Error())
+			return
+		}
+
+		if deleted == nil {
+			deleted = map[string]int{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"user": user, "deleted": deleted})
+	})
+	return mux
+}
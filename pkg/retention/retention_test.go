@@ -0,0 +1,85 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/audit"
+)
+
+func TestParseAge(t *testing.T) {
+	for value, want := range map[string]time.Duration{"": 0, "0": 0, "30d": 30 * 24 * time.Hour, "12h": 12 * time.Hour} {
+		if got, err := ParseAge(value); err != nil || got != want {
+			t.Errorf("ParseAge(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"30 days", "-1d", "-5m"} {
+		if _, err := ParseAge(value); err == nil {
+			t.Errorf("ParseAge(%q) accepted", value)
+		}
+	}
+}
+
+func TestPurgerRemovesExpiredDataAndAudits(t *testing.T) {
+	log := audit.New(filepath.Join(t.TempDir(), "audit.jsonl"))
+	now := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	var historyCutoff time.Time
+	purger := NewPurger(log, Metrics{},
+		Target{Name: "history", MaxAge: 24 * time.Hour, Purge: func(cutoff time.Time) (int, error) {
+			historyCutoff = cutoff
+			return 3, nil
+		}},
+		Target{Name: "feedback", Purge: func(time.Time) (int, error) {
+			t.Error("a target without a retention period was purged")
+			return 0, nil
+		}},
+	)
+	purger.now = func() time.Time { return now }
+
+	if purged := purger.Run(); purged["history"] != 3 || len(purged) != 1 {
+		t.Errorf("purged = %v", purged)
+	}
+	if !historyCutoff.Equal(now.Add(-24 * time.Hour)) {
+		t.Errorf("cutoff = %v", historyCutoff)
+	}
+	entries, _ := log.Entries(time.Time{})
+	if len(entries) != 1 || entries[0].Action != "retention.purged" || entries[0].Details["history"] != 3 {
+		t.Errorf("audit = %+v", entries)
+	}
+}
+
+func TestDeletionHandlerErasesAndAudits(t *testing.T) {
+	log := audit.New(filepath.Join(t.TempDir(), "audit.jsonl"))
+	erase := func(ctx context.Context, user string) (map[string]int, error) {
+		if user == "broken" {
+			return map[string]int{"conversations": 1}, errors.New("backend down")
+		}
+		return map[string]int{"conversations": 2}, nil
+	}
+	handler := DeletionHandler(erase, log, Metrics{})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/users/alice/data", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"conversations":2`) {
+		t.Errorf("delete: %d %s", rec.Code, rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/users/broken/data", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("failed erase status = %d", rec.Code)
+	}
+
+	entries, _ := log.Entries(time.Time{})
+	if len(entries) != 2 || entries[0].Subject != "alice" || entries[0].Action != "user.data_deleted" || entries[1].Error != "backend down" {
+		t.Errorf("audit = %+v", entries)
+	}
+	if purged, err := log.Purge(time.Now().Add(time.Minute)); err != nil || purged != 2 {
+		t.Errorf("audit purge = %d, %v", purged, err)
+	}
+}
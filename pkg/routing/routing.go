@@ -0,0 +1,459 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Routing strategies
+const (
+	RoundRobin   = "round_robin"
+	LeastLatency = "least_latency"
+)
+
+// Affinity modes
+const (
+	AffinityConversation = "conversation"
+	AffinityNone         = "none"
+)
+
+// defaultCooldown is how long a failed backend is skipped before it is tried again
+const defaultCooldown = 15 * time.Second
+
+// latencyWeight is the EWMA smoothing factor for backend latency
+const latencyWeight = 0.2
+
+// Config maps models to the backends that serve them
+type Config struct {
+	Strategy string              `json:"strategy"` // round_robin (default) or least_latency
+	Backends []string            `json:"backends"` // serve any model without its own entry
+	Models   map[string][]string `json:"models"`
+	Cooldown string              `json:"cooldown,omitempty"` // e.g. "15s"
+	// Affinity pins every turn of a conversation to one backend so its
+	// prompt cache is reused: "conversation" (default) or "none"
+	Affinity string `json:"affinity,omitempty"`
+}
+
+// LoadConfig reads a JSON routing config. An empty path returns an empty config.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read routing config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse routing config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Metrics holds the collectors routing reports to
+type Metrics struct {
+	Requests  *prometheus.CounterVec   // labels: backend, model, result
+	Latency   *prometheus.HistogramVec // labels: backend
+	Healthy   *prometheus.GaugeVec     // labels: backend; 1 healthy, 0 cooling down
+	Failovers *prometheus.CounterVec   // labels: model
+	Affinity  *prometheus.CounterVec   // labels: result; hit when a conversation got its own backend
+}
+
+// BackendStatus is the routing view of one backend
+type BackendStatus struct {
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	LatencyMs float64   `json:"latencyMs"`
+	Requests  int64     `json:"requests"`
+	Failures  int64     `json:"failures"`
+	LastError string    `json:"lastError,omitempty"`
+	RetryAt   time.Time `json:"retryAt,omitempty"`
+}
+
+// backend is the tracked state of one upstream URL
+type backend struct {
+	url       string
+	latency   float64 // EWMA in seconds, 0 until measured
+	requests  int64
+	failures  int64
+	downUntil time.Time
+	lastError string
+}
+
+// pool is the set of backends serving a model
+type pool struct {
+	backends []*backend
+	next     int
+}
+
+// Router is an http.RoundTripper that sends requests addressed to the primary
+// base URL to one of several backends, failing over when one is unhealthy
+type Router struct {
+	base     string
+	next     http.RoundTripper
+	strategy string
+	affinity bool
+	cooldown time.Duration
+	metrics  Metrics
+
+	mu          sync.Mutex
+	backends    map[string]*backend
+	defaultPool *pool
+	models      map[string]*pool
+	now         func() time.Time
+}
+
+// New creates a router for requests whose URL starts with base. Backends that
+// aren't configured fall back to base itself.
+func New(base string, cfg Config, next http.RoundTripper, metrics Metrics) (*Router, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	switch cfg.Strategy {
+	case "":
+		cfg.Strategy = RoundRobin
+	case RoundRobin, LeastLatency:
+	default:
+		return nil, fmt.Errorf("unknown routing strategy %q", cfg.Strategy)
+	}
+	switch cfg.Affinity {
+	case "", AffinityConversation, AffinityNone:
+	default:
+		return nil, fmt.Errorf("unknown routing affinity %q", cfg.Affinity)
+	}
+	cooldown := defaultCooldown
+	if cfg.Cooldown != "" {
+		d, err := time.ParseDuration(cfg.Cooldown)
+		if err != nil {
+			return nil, fmt.Errorf("invalid routing cooldown: %w", err)
+		}
+		cooldown = d
+	}
+
+	r := &Router{
+		base:     normalize(base),
+		next:     next,
+		strategy: cfg.Strategy,
+		affinity: cfg.Affinity != AffinityNone,
+		cooldown: cooldown,
+		metrics:  metrics,
+		backends: make(map[string]*backend),
+		models:   make(map[string]*pool),
+		now:      time.Now,
+	}
+	defaults := cfg.Backends
+	if len(defaults) == 0 {
+		defaults = []string{base}
+	}
+	r.defaultPool = r.newPool(defaults)
+	for model, urls := range cfg.Models {
+		if len(urls) == 0 {
+			return nil, fmt.Errorf("model %q has no backends", model)
+		}
+		r.models[model] = r.newPool(urls)
+	}
+	return r, nil
+}
+
+func (r *Router) newPool(urls []string) *pool {
+	p := &pool{}
+	for _, url := range urls {
+		url = normalize(url)
+		b, ok := r.backends[url]
+		if !ok {
+			b = &backend{url: url}
+			r.backends[url] = b
+			if r.metrics.Healthy != nil {
+				r.metrics.Healthy.WithLabelValues(url).Set(1)
+			}
+		}
+		p.backends = append(p.backends, b)
+	}
+	return p
+}
+
+// RoundTrip routes req to a backend for its model, trying the others in the
+// pool if it fails with a connection error or a 5xx status
+func (r *Router) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasPrefix(req.URL.String(), r.base) {
+		return r.next.RoundTrip(req)
+	}
+	suffix := strings.TrimPrefix(req.URL.String(), r.base)
+	model := requestModel(req)
+	candidates, preferred := r.candidates(model, affinityKey(req.Context()))
+
+	var (
+		resp *http.Response
+		err  error
+	)
+	for i, b := range candidates {
+		if i > 0 {
+			// Failing over needs a fresh copy of the request body
+			if req.Body != nil && req.GetBody == nil {
+				break
+			}
+			if r.metrics.Failovers != nil {
+				r.metrics.Failovers.WithLabelValues(model).Inc()
+			}
+			log := logger.GetLogger()
+			log.Warn().Str("model", model).Str("backend", b.url).Msg("Failing over to next backend")
+		}
+
+		attempt, aerr := r.rewrite(req, b.url+suffix, i > 0)
+		if aerr != nil {
+			return nil, aerr
+		}
+		start := r.now()
+		resp, err = r.next.RoundTrip(attempt)
+		failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+		if req.Context().Err() != nil {
+			// The caller gave up; that says nothing about the backend
+			return resp, err
+		}
+		r.record(b, model, r.now().Sub(start), resp, err)
+		if preferred != nil && (!failed || i == len(candidates)-1) {
+			r.recordAffinity(b == preferred && !failed)
+		}
+		if !failed || i == len(candidates)-1 {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+	return resp, err
+}
+
+// rewrite returns a copy of req addressed to url, with a fresh body if needed
+func (r *Router) rewrite(req *http.Request, url string, freshBody bool) (*http.Request, error) {
+	attempt := req.Clone(req.Context())
+	u, err := req.URL.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	attempt.URL = u
+	attempt.Host = u.Host
+	if freshBody && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		attempt.Body = body
+	}
+	return attempt, nil
+}
+
+// candidates returns the pool for model ordered by preference: healthy
+// backends first per the strategy, then those still cooling down. With an
+// affinity key, backends are ordered by their hash with the key instead, and
+// the backend the key belongs to is returned as preferred.
+func (r *Router) candidates(model, key string) (ordered []*backend, preferred *backend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.models[model]
+	if !ok {
+		p = r.defaultPool
+	}
+	now := r.now()
+
+	n := len(p.backends)
+	order := make([]*backend, n)
+	for i := 0; i < n; i++ {
+		order[i] = p.backends[(p.next+i)%n]
+	}
+	p.next = (p.next + 1) % n
+	sticky := r.affinity && key != "" && n > 1
+	if sticky {
+		// Rendezvous hashing: adding or removing a backend only moves the
+		// conversations that hashed to it
+		sort.SliceStable(order, func(i, j int) bool {
+			return score(key, order[i].url) > score(key, order[j].url)
+		})
+		preferred = order[0]
+	}
+
+	var healthy, down []*backend
+	for _, b := range order {
+		if now.Before(b.downUntil) {
+			down = append(down, b)
+		} else {
+			healthy = append(healthy, b)
+		}
+	}
+
+	if r.strategy == LeastLatency && !sticky {
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].latency < healthy[j].latency
+		})
+	}
+	// Backends cooling down are tried soonest-recovering first
+	sort.SliceStable(down, func(i, j int) bool {
+		return down[i].downUntil.Before(down[j].downUntil)
+	})
+	return append(healthy, down...), preferred
+}
+
+// score ranks a backend for an affinity key; the highest score wins
+func score(key, url string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(url))
+	return h.Sum64()
+}
+
+func (r *Router) recordAffinity(hit bool) {
+	if r.metrics.Affinity == nil {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	r.metrics.Affinity.WithLabelValues(result).Inc()
+}
+
+type affinityContextKey struct{}
+
+// WithAffinity asks the router to send requests made with ctx to the backend
+// key hashes to, such as a conversation ID
+func WithAffinity(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, affinityContextKey{}, key)
+}
+
+func affinityKey(ctx context.Context) string {
+	key, _ := ctx.Value(affinityContextKey{}).(string)
+	return key
+}
+
+// Sticky sets the affinity key of each request from its header, so
+// upstream calls made while serving it stay on one backend
+func Sticky(header string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if key := req.Header.Get(header); key != "" {
+			req = req.WithContext(WithAffinity(req.Context(), key))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// record updates a backend's health and latency after an attempt
+func (r *Router) record(b *backend, model string, latency time.Duration, resp *http.Response, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b.requests++
+	result := "success"
+	switch {
+	case err != nil:
+		b.lastError = err.Error()
+	case resp.StatusCode >= http.StatusInternalServerError:
+		b.lastError = resp.Status
+	default:
+		if b.latency == 0 {
+			b.latency = latency.Seconds()
+		} else {
+			b.latency = latencyWeight*latency.Seconds() + (1-latencyWeight)*b.latency
+		}
+		b.downUntil = time.Time{}
+		if r.metrics.Latency != nil {
+			r.metrics.Latency.WithLabelValues(b.url).Observe(latency.Seconds())
+		}
+		if r.metrics.Healthy != nil {
+			r.metrics.Healthy.WithLabelValues(b.url).Set(1)
+		}
+	}
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		result = "error"
+		b.failures++
+		b.downUntil = r.now().Add(r.cooldown)
+		if r.metrics.Healthy != nil {
+			r.metrics.Healthy.WithLabelValues(b.url).Set(0)
+		}
+	}
+	if r.metrics.Requests != nil {
+		r.metrics.Requests.WithLabelValues(b.url, model, result).Inc()
+	}
+}
+
+// Status returns every backend's routing state, sorted by URL
+func (r *Router) Status() []BackendStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	statuses := make([]BackendStatus, 0, len(r.backends))
+	for _, b := range r.backends {
+		status := BackendStatus{
+			URL:       b.url,
+			Healthy:   !now.Before(b.downUntil),
+			LatencyMs: b.latency * 1000,
+			Requests:  b.requests,
+			Failures:  b.failures,
+			LastError: b.lastError,
+		}
+		if !status.Healthy {
+			status.RetryAt = b.downUntil
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].URL < statuses[j].URL })
+	return statuses
+}
+
+// Handler serves GET /backends with the routing state of each backend
+func (r *Router) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /backends", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"strategy": r.strategy,
+			"affinity": r.affinity,
+			"backends": r.Status(),
+		})
+	})
+	return mux
+}
+
+// requestModel peeks at a JSON request body for its model, returning "" when
+// there is none
+func requestModel(req *http.Request) string {
+	if req.GetBody == nil || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		return ""
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, 1<<20))
+	if err != nil {
+		return ""
+	}
+	var payload struct {
+		Model string `json:"model"`
+	}
+	json.NewDecoder(bytes.NewReader(data)).Decode(&payload)
+	return payload.Model
+}
+
+// normalize gives base URLs a trailing slash so paths join consistently
+func normalize(url string) string {
+	return strings.TrimSuffix(url, "/") + "/"
+}
@@ -0,0 +1,209 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testMetrics() Metrics {
+	return Metrics{
+		Requests:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"backend", "model", "result"}),
+		Latency:   prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"backend"}),
+		Healthy:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "healthy"}, []string{"backend"}),
+		Failovers: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "failovers"}, []string{"model"}),
+		Affinity:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "affinity"}, []string{"result"}),
+	}
+}
+
+// replica starts a backend that answers with its name
+func replica(name string, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.WriteHeader(status)
+		w.Write([]byte(name))
+	}))
+}
+
+func post(t *testing.T, client *http.Client, url, model string) string {
+	t.Helper()
+	resp, err := client.Post(url, "application/json", strings.NewReader(`{"model":"`+model+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestRouterRoundRobinAndModelPools(t *testing.T) {
+	a, b, c := replica("a", 200), replica("b", 200), replica("c", 200)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	router, err := New("http://primary/v1/", Config{
+		Backends: []string{a.URL + "/v1", b.URL + "/v1"},
+		Models:   map[string][]string{"ai/special": {c.URL + "/v1/"}},
+	}, nil, testMetrics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: router}
+
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		seen[post(t, client, "http://primary/v1/chat/completions", "ai/llama")]++
+	}
+	if seen["a"] != 2 || seen["b"] != 2 {
+		t.Errorf("expected round robin across replicas, got %v", seen)
+	}
+	if got := post(t, client, "http://primary/v1/chat/completions", "ai/special"); got != "c" {
+		t.Errorf("expected model pool to be used, got %q", got)
+	}
+}
+
+func TestRouterFailsOver(t *testing.T) {
+	bad, good := replica("bad", http.StatusInternalServerError), replica("good", 200)
+	defer bad.Close()
+	defer good.Close()
+
+	metrics := testMetrics()
+	router, err := New("http://primary/v1/", Config{Backends: []string{bad.URL, good.URL}}, nil, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: router}
+
+	for i := 0; i < 3; i++ {
+		if got := post(t, client, "http://primary/v1/chat/completions", "m"); got != "good" {
+			t.Fatalf("request %d: expected failover to healthy backend, got %q", i, got)
+		}
+	}
+	// The failed backend cools down, so only the first request fails over
+	if got := testutil.ToFloat64(metrics.Failovers.WithLabelValues("m")); got != 1 {
+		t.Errorf("expected 1 failover, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.Healthy.WithLabelValues(bad.URL + "/")); got != 0 {
+		t.Errorf("expected failed backend marked unhealthy, got %v", got)
+	}
+	for _, status := range router.Status() {
+		if status.URL == bad.URL+"/" && (status.Healthy || status.Failures != 1) {
+			t.Errorf("unexpected status for failed backend: %+v", status)
+		}
+	}
+}
+
+func TestRouterRejectsUnknownStrategy(t *testing.T) {
+	if _, err := New("http://primary/", Config{Strategy: "random"}, nil, Metrics{}); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
+
+// postInConversation posts like post, with conversation as the affinity key
+func postInConversation(t *testing.T, client *http.Client, url, conversation string) string {
+	t.Helper()
+	req, err := http.NewRequestWithContext(WithAffinity(context.Background(), conversation),
+		http.MethodPost, url, strings.NewReader(`{"model":"m"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestRouterKeepsConversationsOnOneBackend(t *testing.T) {
+	a, b, c := replica("a", 200), replica("b", 200), replica("c", 200)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	metrics := testMetrics()
+	router, err := New("http://primary/v1/", Config{Backends: []string{a.URL, b.URL, c.URL}}, nil, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: router}
+
+	used := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		conversation := fmt.Sprintf("conversation-%d", i)
+		first := postInConversation(t, client, "http://primary/v1/chat/completions", conversation)
+		used[first] = true
+		for turn := 0; turn < 3; turn++ {
+			if got := postInConversation(t, client, "http://primary/v1/chat/completions", conversation); got != first {
+				t.Fatalf("%s moved from %s to %s", conversation, first, got)
+			}
+		}
+	}
+	if len(used) < 2 {
+		t.Errorf("expected conversations spread across backends, all went to %v", used)
+	}
+	if got := testutil.ToFloat64(metrics.Affinity.WithLabelValues("hit")); got != 80 {
+		t.Errorf("affinity hits = %v, want 80", got)
+	}
+}
+
+func TestRouterAffinityFailsOverAndCountsMiss(t *testing.T) {
+	bad, good := replica("bad", http.StatusInternalServerError), replica("good", 200)
+	defer bad.Close()
+	defer good.Close()
+
+	metrics := testMetrics()
+	router, err := New("http://primary/v1/", Config{Backends: []string{bad.URL, good.URL}}, nil, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: router}
+
+	// Find a conversation that hashes to the failing backend
+	var conversation string
+	for i := 0; conversation == ""; i++ {
+		key := fmt.Sprintf("conversation-%d", i)
+		if ordered, _ := router.candidates("m", key); ordered[0].url == bad.URL+"/" {
+			conversation = key
+		}
+	}
+	if got := postInConversation(t, client, "http://primary/v1/chat/completions", conversation); got != "good" {
+		t.Fatalf("expected failover to the healthy backend, got %q", got)
+	}
+	if got := testutil.ToFloat64(metrics.Affinity.WithLabelValues("miss")); got != 1 {
+		t.Errorf("affinity misses = %v, want 1", got)
+	}
+}
+
+func TestRouterAffinityCanBeDisabled(t *testing.T) {
+	a, b := replica("a", 200), replica("b", 200)
+	defer a.Close()
+	defer b.Close()
+
+	router, err := New("http://primary/v1/", Config{Backends: []string{a.URL, b.URL}, Affinity: AffinityNone}, nil, testMetrics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: router}
+
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		seen[postInConversation(t, client, "http://primary/v1/chat/completions", "same")]++
+	}
+	if seen["a"] != 2 || seen["b"] != 2 {
+		t.Errorf("expected round robin without affinity, got %v", seen)
+	}
+	if _, err := New("http://primary/", Config{Affinity: "sticky"}, nil, Metrics{}); err == nil {
+		t.Error("expected error for unknown affinity")
+	}
+}
@@ -0,0 +1,99 @@
+package sampling
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Profile is a named set of sampling parameters
+type Profile struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   *int64   `json:"max_tokens,omitempty"`
+}
+
+// Config is the on-disk profile configuration. Defaults apply to every model;
+// entries under Models override or extend them for a specific model.
+type Config struct {
+	Defaults map[string]Profile            `json:"defaults"`
+	Models   map[string]map[string]Profile `json:"models"`
+}
+
+// Registry resolves sampling profiles per model
+type Registry struct {
+	config Config
+}
+
+func float(v float64) *float64 { return &v }
+
+// BuiltinProfiles are used when no profile file is configured
+func BuiltinProfiles() map[string]Profile {
+	return map[string]Profile{
+		"precise":  {Temperature: float(0.2), TopP: float(0.9)},
+		"balanced": {Temperature: float(0.7), TopP: float(0.95)},
+		"creative": {Temperature: float(1.0), TopP: float(1.0)},
+	}
+}
+
+// NewRegistry creates a registry from cfg, filling in the built-in defaults
+// when cfg declares none
+func NewRegistry(cfg Config) *Registry {
+	if len(cfg.Defaults) == 0 {
+		cfg.Defaults = BuiltinProfiles()
+	}
+	if cfg.Models == nil {
+		cfg.Models = make(map[string]map[string]Profile)
+	}
+	return &Registry{config: cfg}
+}
+
+// Load reads a JSON profile configuration from path. An empty path yields the
+// built-in profiles.
+func Load(path string) (*Registry, error) {
+	if path == "" {
+		return NewRegistry(Config{}), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sampling profiles: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse sampling profiles: %w", err)
+	}
+	return NewRegistry(cfg), nil
+}
+
+// Resolve returns the named profile for model, preferring a model-specific
+// definition over the defaults
+func (r *Registry) Resolve(model, name string) (Profile, bool) {
+	if profiles, ok := r.config.Models[model]; ok {
+		if p, ok := profiles[name]; ok {
+			return p, true
+		}
+	}
+	p, ok := r.config.Defaults[name]
+	return p, ok
+}
+
+// Names returns the profile names available for model
+func (r *Registry) Names(model string) []string {
+	seen := make(map[string]bool)
+	for name := range r.config.Defaults {
+		seen[name] = true
+	}
+	for name := range r.config.Models[model] {
+		seen[name] = true
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
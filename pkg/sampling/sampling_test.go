@@ -0,0 +1,82 @@
+package sampling
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveMergesModelProfilesOverDefaults(t *testing.T) {
+	registry := NewRegistry(Config{
+		Defaults: map[string]Profile{
+			"precise":  {Temperature: float(0.2)},
+			"balanced": {Temperature: float(0.7)},
+		},
+		Models: map[string]map[string]Profile{
+			"ai/qwen3": {
+				"precise":  {Temperature: float(0.1), TopP: float(0.8)},
+				"thinking": {Temperature: float(0.6)},
+			},
+		},
+	})
+
+	cases := []struct {
+		model, name string
+		temperature float64 // 0 expects no profile
+	}{
+		{"ai/qwen3", "precise", 0.1},
+		{"ai/qwen3", "balanced", 0.7},
+		{"ai/qwen3", "thinking", 0.6},
+		{"ai/llama3.2", "precise", 0.2},
+		{"ai/llama3.2", "thinking", 0},
+		{"ai/llama3.2", "creative", 0},
+	}
+	for _, tc := range cases {
+		p, ok := registry.Resolve(tc.model, tc.name)
+		switch {
+		case tc.temperature == 0 && ok:
+			t.Errorf("%s/%s: expected no profile, got %+v", tc.model, tc.name, p)
+		case tc.temperature != 0 && (!ok || *p.Temperature != tc.temperature):
+			t.Errorf("%s/%s: got %+v (%v), want temperature %v", tc.model, tc.name, p, ok, tc.temperature)
+		}
+	}
+
+	if got, want := registry.Names("ai/qwen3"), []string{"balanced", "precise", "thinking"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names(ai/qwen3) = %v, want %v", got, want)
+	}
+	if got, want := registry.Names("ai/llama3.2"), []string{"balanced", "precise"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names(ai/llama3.2) = %v, want %v", got, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	builtin, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := builtin.Names("any"); !reflect.DeepEqual(got, []string{"balanced", "creative", "precise"}) {
+		t.Errorf("expected the built-in profiles without a file, got %v", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	os.WriteFile(path, []byte(`{"models": {"ai/gemma3": {"short": {"max_tokens": 64}}}}`), 0o644)
+	registry, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := registry.Resolve("ai/gemma3", "short"); !ok || *p.MaxTokens != 64 {
+		t.Errorf("expected the file's model profile, got %+v", p)
+	}
+	if _, ok := registry.Resolve("ai/gemma3", "precise"); !ok {
+		t.Error("expected the built-in defaults when the file declares none")
+	}
+
+	os.WriteFile(path, []byte(`{"defaults": [`), 0o644)
+	if _, err := Load(path); err == nil {
+		t.Error("expected an error for malformed JSON")
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,176 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/sigv4"
+)
+
+// FileProvider reads secrets mounted as files, one per secret, such as
+// Docker secrets in /run/secrets or a Kubernetes secret volume. A secret
+// named API_KEY is read from API_KEY or api_key. Kubernetes updates mounted
+// secrets in place, so rotated values are picked up on refresh.
+type FileProvider struct {
+	Dir string
+}
+
+func (p *FileProvider) Name() string { return "file" }
+
+func (p *FileProvider) Lookup(ctx context.Context, name string) (string, error) {
+	for _, file := range []string{name, strings.ToLower(name)} {
+		data, err := os.ReadFile(filepath.Join(p.Dir, file))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return "", ErrNotFound
+}
+
+// VaultProvider reads secrets from one HashiCorp Vault secret whose keys are
+// the secret names, e.g. "secret/data/aiwatch" on a KV version 2 engine.
+type VaultProvider struct {
+	Addr string // e.g. https://vault.example.com:8200
+	Path string
+	// Token authenticates to Vault. TokenFile, if set, is read on every
+	// lookup instead, so a token renewed by Vault Agent is picked up.
+	Token     string
+	TokenFile string
+	Namespace string // Vault Enterprise namespace, optional
+	Client    *http.Client
+}
+
+func (p *VaultProvider) Name() string { return "vault" }
+
+func (p *VaultProvider) Lookup(ctx context.Context, name string) (string, error) {
+	token := p.Token
+	if p.TokenFile != "" {
+		data, err := os.ReadFile(p.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read vault token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	url := strings.TrimSuffix(p.Addr, "/") + "/v1/" + strings.Trim(p.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+	body, err := send(p.Client, req)
+	if err != nil {
+		return "", fmt.Errorf("vault: %w", err)
+	}
+
+	// KV version 2 nests the secret's keys under data.data, version 1
+	// directly under data
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", fmt.Errorf("vault: invalid response: %w", err)
+	}
+	data := secret.Data
+	var nested map[string]json.RawMessage
+	if raw, ok := data["data"]; ok && json.Unmarshal(raw, &nested) == nil {
+		data = nested
+	}
+	return field(data, name)
+}
+
+// AWSProvider reads secrets from an AWS Secrets Manager secret whose
+// SecretString is a JSON object keyed by secret name
+type AWSProvider struct {
+	SecretID    string // name or ARN
+	Region      string
+	Endpoint    string // default https://secretsmanager.<region>.amazonaws.com
+	Credentials sigv4.Credentials
+	Client      *http.Client
+}
+
+func (p *AWSProvider) Name() string { return "aws-secrets-manager" }
+
+func (p *AWSProvider) Lookup(ctx context.Context, name string) (string, error) {
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + p.Region + ".amazonaws.com"
+	}
+	payload, err := json.Marshal(map[string]string{"SecretId": p.SecretID})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	sigv4.Sign(req, payload, "secretsmanager", p.Region, p.Credentials, time.Now())
+	body, err := send(p.Client, req)
+	if err != nil {
+		return "", fmt.Errorf("secrets manager: %w", err)
+	}
+
+	var secret struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", fmt.Errorf("secrets manager: invalid response: %w", err)
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(secret.SecretString), &data); err != nil {
+		return "", fmt.Errorf("secrets manager: secret %s is not a JSON object", p.SecretID)
+	}
+	return field(data, name)
+}
+
+// field returns a string, or the JSON text of any other value, from a secret
+func field(data map[string]json.RawMessage, name string) (string, error) {
+	raw, ok := data[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return string(raw), nil
+	}
+	return value, nil
+}
+
+// send performs req, returning the body of a successful response
+func send(client *http.Client, req *http.Request) ([]byte, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return io.ReadAll(resp.Body)
+}
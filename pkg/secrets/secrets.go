@@ -0,0 +1,184 @@
+// Package secrets loads credentials such as API keys from Docker and
+// Kubernetes secret files, HashiCorp Vault or AWS Secrets Manager instead of
+// plaintext environment variables, and re-reads them periodically so rotated
+// values take effect without a restart.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrNotFound is returned by a provider that does not hold a secret
+var ErrNotFound = errors.New("secret not found")
+
+// Provider looks secrets up by their environment variable name, e.g. API_KEY
+type Provider interface {
+	Name() string
+	Lookup(ctx context.Context, name string) (string, error)
+}
+
+// Metrics holds the collectors the store reports to
+type Metrics struct {
+	Rotations *prometheus.CounterVec // labels: name
+	Errors    *prometheus.CounterVec // labels: provider
+}
+
+// Store resolves secrets and keeps them current. A secret is read from the
+// file named by <NAME>_FILE, then from each provider in order, then from the
+// <NAME> environment variable.
+type Store struct {
+	providers []Provider
+	metrics   Metrics
+
+	mu       sync.RWMutex
+	values   map[string]string
+	sources  map[string]string
+	watchers map[string][]func(string)
+}
+
+// New creates a store reading from providers, in order of precedence
+func New(metrics Metrics, providers ...Provider) *Store {
+	return &Store{
+		providers: providers,
+		metrics:   metrics,
+		values:    make(map[string]string),
+		sources:   make(map[string]string),
+		watchers:  make(map[string][]func(string)),
+	}
+}
+
+// Get returns secret name, or fallback when no source holds it. The first
+// call looks the secret up; later calls return the current value, which
+// Refresh keeps up to date.
+func (s *Store) Get(ctx context.Context, name, fallback string) string {
+	s.mu.RLock()
+	value, ok := s.values[name]
+	s.mu.RUnlock()
+	if !ok {
+		var source string
+		value, source = s.lookup(ctx, name)
+		s.mu.Lock()
+		s.values[name], s.sources[name] = value, source
+		s.mu.Unlock()
+	}
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// Value returns the current value of a secret already read with Get. It
+// never calls a provider, so it is cheap enough for every request.
+func (s *Store) Value(name string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[name]
+}
+
+// Source returns where a secret read with Get came from, or "" if unset
+func (s *Store) Source(name string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sources[name]
+}
+
+// Watch calls fn with the new value whenever secret name rotates
+func (s *Store) Watch(name string, fn func(value string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchers[name] = append(s.watchers[name], fn)
+}
+
+// lookup reads a secret from the first source that holds it
+func (s *Store) lookup(ctx context.Context, name string) (value, source string) {
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return strings.TrimRight(string(data), "\r\n"), "file"
+		}
+		log := logger.GetLogger()
+		log.Error().Err(err).Str("secret", name).Msg("Failed to read secret file")
+		s.count(s.metrics.Errors, "file")
+	}
+	for _, p := range s.providers {
+		value, err := p.Lookup(ctx, name)
+		if err == nil && value != "" {
+			return value, p.Name()
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			log := logger.GetLogger()
+			log.Error().Err(err).Str("secret", name).Str("provider", p.Name()).Msg("Failed to look up secret")
+			s.count(s.metrics.Errors, p.Name())
+		}
+	}
+	if value := os.Getenv(name); value != "" {
+		return value, "env"
+	}
+	return "", ""
+}
+
+// Refresh reads every secret in use again and notifies the watchers of those
+// that changed. A secret no source holds any more keeps its last value, so
+// an unreachable provider cannot blank a key that is still valid.
+func (s *Store) Refresh(ctx context.Context) {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.values))
+	for name := range s.values {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+
+	for _, name := range names {
+		value, source := s.lookup(ctx, name)
+		s.mu.Lock()
+		changed := value != "" && value != s.values[name]
+		if changed {
+			s.values[name], s.sources[name] = value, source
+		}
+		watchers := s.watchers[name]
+		s.mu.Unlock()
+		if !changed {
+			continue
+		}
+
+		log := logger.GetLogger()
+		log.Info().Str("secret", name).Str("source", source).Msg("Secret rotated")
+		s.count(s.metrics.Rotations, name)
+		for _, fn := range watchers {
+			fn(value)
+		}
+	}
+}
+
+// Start refreshes the secrets every interval until ctx is done
+func (s *Store) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Refresh(ctx)
+			}
+		}
+	}()
+}
+
+func (s *Store) count(counter *prometheus.CounterVec, label string) {
+	if counter != nil {
+		counter.WithLabelValues(label).Inc()
+	}
+}
@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ajeetraina/aiwatch/pkg/sigv4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStorePrecedenceAndRotation(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_key"), []byte("from-file\n"), 0600)
+	t.Setenv("API_KEY", "from-env")
+	t.Setenv("ADMIN_TOKEN", "admin-from-env")
+	t.Setenv("ADMIN_TOKEN_FILE", filepath.Join(dir, "admin"))
+	os.WriteFile(filepath.Join(dir, "admin"), []byte("admin-from-file"), 0600)
+
+	rotations := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rotations"}, []string{"name"})
+	s := New(Metrics{Rotations: rotations}, &FileProvider{Dir: dir})
+	ctx := context.Background()
+	if got := s.Get(ctx, "API_KEY", ""); got != "from-file" || s.Source("API_KEY") != "file" {
+		t.Errorf("API_KEY = %q from %q, want the mounted secret over the env var", got, s.Source("API_KEY"))
+	}
+	if got := s.Get(ctx, "ADMIN_TOKEN", ""); got != "admin-from-file" {
+		t.Errorf("ADMIN_TOKEN = %q, want ADMIN_TOKEN_FILE", got)
+	}
+	if got := s.Get(ctx, "QDRANT_API_KEY", "none"); got != "none" {
+		t.Errorf("unset secret = %q, want the fallback", got)
+	}
+
+	var rotated string
+	s.Watch("API_KEY", func(value string) { rotated = value })
+	os.WriteFile(filepath.Join(dir, "api_key"), []byte("rotated"), 0600)
+	s.Refresh(ctx)
+	if rotated != "rotated" || s.Value("API_KEY") != "rotated" {
+		t.Errorf("after rotation: watcher saw %q, value %q", rotated, s.Value("API_KEY"))
+	}
+
+	// A secret whose file disappears falls back to the next source
+	os.Remove(filepath.Join(dir, "admin"))
+	s.Refresh(ctx)
+	if got := s.Value("ADMIN_TOKEN"); got != "admin-from-env" {
+		t.Errorf("ADMIN_TOKEN after its file was removed = %q, want the env fallback", got)
+	}
+	if got := testutil.ToFloat64(rotations.WithLabelValues("API_KEY")); got != 1 {
+		t.Errorf("API_KEY rotations = %v", got)
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("s.agent-token\n"), 0600)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/aiwatch" || r.Header.Get("X-Vault-Token") != "s.agent-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"data": map[string]string{"API_KEY": "from-vault"}},
+		})
+	}))
+	defer server.Close()
+
+	p := &VaultProvider{Addr: server.URL, Path: "secret/data/aiwatch", TokenFile: tokenFile}
+	if got, err := p.Lookup(context.Background(), "API_KEY"); err != nil || got != "from-vault" {
+		t.Errorf("API_KEY = %q, %v", got, err)
+	}
+	if _, err := p.Lookup(context.Background(), "ADMIN_TOKEN"); err != ErrNotFound {
+		t.Errorf("missing key: err = %v", err)
+	}
+}
+
+func TestAWSProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&body)
+		auth := r.Header.Get("Authorization")
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" || body.SecretId != "prod/aiwatch" ||
+			!strings.Contains(auth, "/eu-west-1/secretsmanager/aws4_request") || !strings.Contains(auth, "x-amz-target") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"SecretString": `{"API_KEY":"from-aws"}`})
+	}))
+	defer server.Close()
+
+	p := &AWSProvider{
+		SecretID:    "prod/aiwatch",
+		Region:      "eu-west-1",
+		Endpoint:    server.URL,
+		Credentials: sigv4.Credentials{AccessKey: "AKID", SecretKey: "secret"},
+	}
+	if got, err := p.Lookup(context.Background(), "API_KEY"); err != nil || got != "from-aws" {
+		t.Errorf("API_KEY = %q, %v", got, err)
+	}
+}
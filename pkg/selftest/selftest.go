@@ -0,0 +1,195 @@
+package selftest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Result is the outcome of one check
+type Result string
+
+const (
+	OK   Result = "ok"
+	Warn Result = "warn" // could not be verified; the server may still work
+	Fail Result = "fail" // the server will not be able to serve chats
+)
+
+// Check is one startup diagnostic
+type Check struct {
+	Name   string `json:"name"`
+	Result Result `json:"result"`
+	Detail string `json:"detail"`
+}
+
+// Report is the result of a startup self-test
+type Report struct {
+	Checks   []Check       `json:"checks"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Failed reports whether any check failed
+func (r Report) Failed() bool {
+	for _, c := range r.Checks {
+		if c.Result == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the report as a table for the startup log
+func (r Report) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tDETAIL")
+	for _, c := range r.Checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Name, strings.ToUpper(string(c.Result)), c.Detail)
+	}
+	tw.Flush()
+}
+
+// Config is what the self-test validates
+type Config struct {
+	BaseURL string
+	Model   string
+	APIKey  string
+	Client  *http.Client // defaults to http.DefaultClient
+	Timeout time.Duration
+
+	// LocalModels lists the models pulled into Docker Model Runner, e.g.
+	// from `docker model ls`. Optional; used when the backend can't list them.
+	LocalModels func() ([]string, error)
+}
+
+// Run validates the configuration and probes the backend's /models endpoint
+// to check that it is reachable, accepts the API key and serves the model
+func Run(ctx context.Context, cfg Config) Report {
+	start := time.Now()
+	var checks []Check
+	add := func(name string, result Result, format string, args ...interface{}) {
+		checks = append(checks, Check{Name: name, Result: result, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	baseOK := false
+	if cfg.BaseURL == "" {
+		add("base_url", Fail, "BASE_URL is not set")
+	} else if u, err := url.Parse(cfg.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("base_url", Fail, "BASE_URL %q is not an http(s) URL", cfg.BaseURL)
+	} else {
+		baseOK = true
+	}
+	if cfg.Model == "" {
+		add("model", Fail, "MODEL is not set")
+	}
+
+	var served []string
+	listed := false
+	if baseOK {
+		status, ids, err := listModels(ctx, cfg)
+		switch {
+		case err != nil:
+			add("base_url", Fail, "%s is unreachable: %v", cfg.BaseURL, err)
+			add("api_key", Warn, "not verified, the backend is unreachable")
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			add("base_url", OK, "%s is reachable", cfg.BaseURL)
+			add("api_key", Fail, "the backend rejected the API key (status %d)", status)
+		case status < 200 || status >= 300:
+			add("base_url", Fail, "GET %s/models returned status %d", strings.TrimRight(cfg.BaseURL, "/"), status)
+			add("api_key", Warn, "not verified, the backend returned an error")
+		default:
+			add("base_url", OK, "%s is reachable", cfg.BaseURL)
+			if cfg.APIKey == "" {
+				add("api_key", OK, "no API key set, the backend accepts anonymous requests")
+			} else {
+				add("api_key", OK, "accepted by the backend")
+			}
+			served, listed = ids, ids != nil
+		}
+	}
+
+	if cfg.Model != "" {
+		source := "the backend"
+		if !listed && cfg.LocalModels != nil {
+			if local, err := cfg.LocalModels(); err == nil {
+				served, listed, source = local, true, "docker model ls"
+			}
+		}
+		switch {
+		case !listed:
+			add("model", Warn, "could not verify that %s is available", cfg.Model)
+		case containsModel(served, cfg.Model):
+			add("model", OK, "%s is available (%s)", cfg.Model, source)
+		default:
+			add("model", Fail, "%s is not served by %s; available: %s", cfg.Model, source, summarize(served))
+		}
+	}
+
+	return Report{Checks: checks, Duration: time.Since(start)}
+}
+
+// listModels fetches the model IDs from an OpenAI-compatible /models endpoint.
+// ids is nil when the response isn't a model list.
+func listModels(ctx context.Context, cfg Config) (status int, ids []string, err error) {
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(cfg.BaseURL, "/")+"/models", nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if resp.StatusCode/100 == 2 && json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&list) == nil && list.Data != nil {
+		ids = make([]string, 0, len(list.Data))
+		for _, m := range list.Data {
+			ids = append(ids, m.ID)
+		}
+	}
+	return resp.StatusCode, ids, nil
+}
+
+// containsModel matches model names, treating a missing tag as ":latest"
+func containsModel(names []string, model string) bool {
+	for _, name := range names {
+		if strings.EqualFold(strings.TrimSuffix(name, ":latest"), strings.TrimSuffix(model, ":latest")) {
+			return true
+		}
+	}
+	return false
+}
+
+// summarize lists a few model names for a diagnostic
+func summarize(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	if len(names) > 5 {
+		return strings.Join(names[:5], ", ") + fmt.Sprintf(" and %d more", len(names)-5)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,87 @@
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func modelServer(t *testing.T, key string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+key {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"object":"list","data":[{"id":"ai/llama3.2:1B-Q8_0"},{"id":"ai/qwen3:latest"}]}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func results(r Report) map[string]Result {
+	got := make(map[string]Result)
+	for _, c := range r.Checks {
+		got[c.Name] = c.Result
+	}
+	return got
+}
+
+func TestRunPassesForWorkingBackend(t *testing.T) {
+	server := modelServer(t, "secret")
+	report := Run(context.Background(), Config{BaseURL: server.URL, Model: "ai/qwen3", APIKey: "secret"})
+	if report.Failed() {
+		t.Fatalf("unexpected failure: %+v", report.Checks)
+	}
+	want := map[string]Result{"base_url": OK, "api_key": OK, "model": OK}
+	for name, result := range want {
+		if got := results(report)[name]; got != result {
+			t.Errorf("%s = %q, want %q", name, got, result)
+		}
+	}
+
+	var out bytes.Buffer
+	report.Print(&out)
+	if !strings.Contains(out.String(), "model") || !strings.Contains(out.String(), "OK") {
+		t.Errorf("unexpected summary:\n%s", out.String())
+	}
+}
+
+func TestRunReportsMisconfiguration(t *testing.T) {
+	server := modelServer(t, "secret")
+
+	report := Run(context.Background(), Config{BaseURL: server.URL, Model: "ai/llama3.2:1B-Q8_0", APIKey: "wrong"})
+	if got := results(report)["api_key"]; got != Fail {
+		t.Errorf("api_key = %q with a rejected key, want fail", got)
+	}
+
+	report = Run(context.Background(), Config{BaseURL: server.URL, Model: "ai/gemma3", APIKey: "secret"})
+	if got := results(report)["model"]; got != Fail {
+		t.Errorf("model = %q for a missing model, want fail", got)
+	}
+
+	report = Run(context.Background(), Config{BaseURL: "localhost:12434", Model: "ai/gemma3"})
+	if got := results(report)["base_url"]; got != Fail {
+		t.Errorf("base_url = %q for a URL without scheme, want fail", got)
+	}
+}
+
+func TestRunFallsBackToLocalModels(t *testing.T) {
+	local := func() ([]string, error) { return []string{"ai/gemma3"}, nil }
+
+	report := Run(context.Background(), Config{BaseURL: "http://127.0.0.1:1", Model: "ai/gemma3", LocalModels: local})
+	got := results(report)
+	if got["base_url"] != Fail || got["model"] != OK {
+		t.Errorf("unexpected results %v", got)
+	}
+
+	broken := func() ([]string, error) { return nil, errors.New("docker not found") }
+	report = Run(context.Background(), Config{BaseURL: "http://127.0.0.1:1", Model: "ai/gemma3", LocalModels: broken})
+	if got := results(report)["model"]; got != Warn {
+		t.Errorf("model = %q when it can't be verified, want warn", got)
+	}
+}
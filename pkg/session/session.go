@@ -0,0 +1,190 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// CookieName is the cookie that carries the session ID for browser clients
+	CookieName = "aiwatch_session"
+	// HeaderName lets API clients supply their own session ID
+	HeaderName = "X-Session-ID"
+)
+
+// MaxSessions bounds the sessions a tracker remembers. Past it, the sessions
+// seen least recently are forgotten, so clients minting IDs can't exhaust
+// memory.
+const MaxSessions = 100000
+
+type contextKey struct{}
+
+// Tracker identifies clients by session and tracks when each was last seen
+type Tracker struct {
+	// IgnorePrefixes lists paths (such as polling and scrape endpoints) that
+	// carry a session but don't count as user activity
+	IgnorePrefixes []string
+
+	activeWindow time.Duration
+	retention    time.Duration
+	limit        int
+
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+// NewTracker creates a tracker where sessions seen within activeWindow count
+// as active. Sessions are remembered for a day to report daily unique users.
+func NewTracker(activeWindow time.Duration) *Tracker {
+	return &Tracker{
+		activeWindow: activeWindow,
+		retention:    24 * time.Hour,
+		limit:        MaxSessions,
+		lastSeen:     make(map[string]time.Time),
+	}
+}
+
+// Middleware resolves the session for each request, issuing a cookie to
+// clients that don't have one yet, and stores the ID in the request context
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderName)
+		if id == "" {
+			if cookie, err := r.Cookie(CookieName); err == nil {
+				id = cookie.Value
+			}
+		}
+		if !validID(id) {
+			id = uuid.New().String()
+			http.SetCookie(w, &http.Cookie{
+				Name:     CookieName,
+				Value:    id,
+				Path:     "/",
+				MaxAge:   int((30 * 24 * time.Hour).Seconds()),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		if !t.ignored(r.URL.Path) {
+			t.Touch(id)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, id)))
+	})
+}
+
+// validID reports whether id can be a session ID: 1 to 128 letters, digits,
+// dashes, underscores or dots, which covers the UUIDs the tracker issues
+func validID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ignored reports whether path should not count as activity
+func (t *Tracker) ignored(path string) bool {
+	for _, prefix := range t.IgnorePrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromContext returns the session ID stored by the middleware, or ""
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Touch marks a session as seen now
+func (t *Tracker) Touch(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.lastSeen[id]; !ok && len(t.lastSeen) >= t.limit {
+		t.evict(time.Now())
+	}
+	t.lastSeen[id] = time.Now()
+}
+
+// evict forgets expired sessions, then the least recently seen ones until
+// the tracker is a tenth below its limit, so it runs once per many new
+// sessions. Callers must hold t.mu.
+func (t *Tracker) evict(now time.Time) {
+	for id, seen := range t.lastSeen {
+		if now.Sub(seen) > t.retention {
+			delete(t.lastSeen, id)
+		}
+	}
+	keep := t.limit - t.limit/10
+	if len(t.lastSeen) < keep {
+		return
+	}
+	ids := make([]string, 0, len(t.lastSeen))
+	for id := range t.lastSeen {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return t.lastSeen[ids[i]].After(t.lastSeen[ids[j]]) })
+	for _, id := range ids[keep-1:] {
+		delete(t.lastSeen, id)
+	}
+}
+
+// Forget drops what the tracker knows about a session and reports whether
+// it had seen it
+func (t *Tracker) Forget(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.lastSeen[id]
+	delete(t.lastSeen, id)
+	return ok
+}
+
+// countSince returns the number of sessions seen within d, pruning expired ones
+func (t *Tracker) countSince(d time.Duration) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	for id, seen := range t.lastSeen {
+		age := now.Sub(seen)
+		if age > t.retention {
+			delete(t.lastSeen, id)
+			continue
+		}
+		if age <= d {
+			count++
+		}
+	}
+	return count
+}
+
+// Active returns the number of sessions seen within the active window
+func (t *Tracker) Active() int {
+	return t.countSince(t.activeWindow)
+}
+
+// UniqueLastHour returns the number of distinct sessions seen in the past hour
+func (t *Tracker) UniqueLastHour() int {
+	return t.countSince(time.Hour)
+}
+
+// UniqueLastDay returns the number of distinct sessions seen in the past day
+func (t *Tracker) UniqueLastDay() int {
+	return t.countSince(24 * time.Hour)
+}
@@ -0,0 +1,105 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareResolvesSession(t *testing.T) {
+	cases := []struct {
+		name   string
+		header string
+		cookie string
+		want   string // "" expects a newly issued ID
+	}{
+		{"header", "api-client-1", "", "api-client-1"},
+		{"cookie", "", "0b8f4c1e-6d3a-4b4e-9a57-2f0c1b2d3e4f", "0b8f4c1e-6d3a-4b4e-9a57-2f0c1b2d3e4f"},
+		{"header wins over cookie", "api-client-1", "browser", "api-client-1"},
+		{"none", "", "", ""},
+		{"too long", strings.Repeat("a", 129), "", ""},
+		{"malformed", "bad id\n", "", ""},
+		{"markup", "<script>", "", ""},
+	}
+	for _, tc := range cases {
+		tracker := NewTracker(time.Minute)
+		r := httptest.NewRequest(http.MethodGet, "/chat", nil)
+		if tc.header != "" {
+			r.Header.Set(HeaderName, tc.header)
+		}
+		if tc.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: CookieName, Value: tc.cookie})
+		}
+		var got string
+		w := httptest.NewRecorder()
+		tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = FromContext(r.Context())
+		})).ServeHTTP(w, r)
+
+		issued := w.Header().Get("Set-Cookie") != ""
+		switch {
+		case tc.want != "" && (got != tc.want || issued):
+			t.Errorf("%s: got session %q (cookie issued: %v), want %q", tc.name, got, issued, tc.want)
+		case tc.want == "" && (!issued || !validID(got) || got == tc.header):
+			t.Errorf("%s: expected a new session ID to be issued, got %q", tc.name, got)
+		}
+		if tracker.Active() != 1 {
+			t.Errorf("%s: expected 1 active session, got %d", tc.name, tracker.Active())
+		}
+	}
+}
+
+func TestTrackerCountsAndForgets(t *testing.T) {
+	tracker := NewTracker(time.Minute)
+	tracker.Touch("a")
+	tracker.Touch("b")
+	tracker.mu.Lock()
+	tracker.lastSeen["stale"] = time.Now().Add(-30 * time.Minute)
+	tracker.lastSeen["expired"] = time.Now().Add(-25 * time.Hour)
+	tracker.mu.Unlock()
+
+	if got := tracker.Active(); got != 2 {
+		t.Errorf("expected 2 active sessions, got %d", got)
+	}
+	if got := tracker.UniqueLastHour(); got != 3 {
+		t.Errorf("expected 3 sessions in the last hour, got %d", got)
+	}
+	if got := tracker.UniqueLastDay(); got != 3 {
+		t.Errorf("expected sessions past the retention to be dropped, got %d", got)
+	}
+	if !tracker.Forget("a") || tracker.Forget("a") {
+		t.Error("expected Forget to report a session only while it is tracked")
+	}
+}
+
+func TestTrackerEvictsOldestPastLimit(t *testing.T) {
+	tracker := NewTracker(time.Minute)
+	tracker.limit = 100
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < tracker.limit; i++ {
+		tracker.lastSeen["s"+strconv.Itoa(i)] = start.Add(time.Duration(i) * time.Second)
+	}
+
+	tracker.Touch("new")
+
+	if got := len(tracker.lastSeen); got != 90 {
+		t.Fatalf("expected the tracker cut to 90 sessions, got %d", got)
+	}
+	if _, ok := tracker.lastSeen["new"]; !ok {
+		t.Error("expected the new session to be tracked")
+	}
+	if _, ok := tracker.lastSeen["s0"]; ok {
+		t.Error("expected the least recently seen session to be evicted")
+	}
+	if _, ok := tracker.lastSeen["s99"]; !ok {
+		t.Error("expected the most recently seen session to be kept")
+	}
+
+	tracker.Touch("s99")
+	if got := len(tracker.lastSeen); got != 90 {
+		t.Errorf("expected touching a known session not to evict, got %d", got)
+	}
+}
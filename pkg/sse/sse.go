@@ -0,0 +1,178 @@
+package sse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Event is a single server-sent event
+type Event struct {
+	Name string // optional event type; empty means the default "message" type
+	ID   string // optional event ID
+	Data string
+}
+
+// Encoder writes server-sent events to a response, flushing after each one
+type Encoder struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+// NewEncoder creates an encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	flusher, _ := w.(http.Flusher)
+	return &Encoder{w: w, flusher: flusher}
+}
+
+// lineBreaks normalises every SSE line terminator to \n
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// sanitizeField strips line terminators from single-line fields, which would
+// otherwise let untrusted input inject extra fields into the stream
+func sanitizeField(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// Encode writes ev. Multi-line data is split across several data: lines so
+// that clients reassemble it exactly (with line breaks normalised to \n).
+func (e *Encoder) Encode(ev Event) error {
+	var b strings.Builder
+	if ev.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", sanitizeField(ev.ID))
+	}
+	if ev.Name != "" {
+		fmt.Fprintf(&b, "event: %s\n", sanitizeField(ev.Name))
+	}
+	for _, line := range strings.Split(lineBreaks.Replace(ev.Data), "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	if _, err := io.WriteString(e.w, b.String()); err != nil {
+		return err
+	}
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+	return nil
+}
+
+// Comment writes an SSE comment line, which clients ignore; useful as a keepalive
+func (e *Encoder) Comment(text string) error {
+	if _, err := fmt.Fprintf(e.w, ": %s\n\n", sanitizeField(text)); err != nil {
+		return err
+	}
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+	return nil
+}
+
+// trailerStart marks the events that may follow a plain text response
+const trailerStart = "\n\nevent: "
+
+// SplitPreamble separates the events a plain text response may start with,
+// such as model loading progress, from the rest of the body
+func SplitPreamble(body string) (preamble, rest string) {
+	rest = body
+	for strings.HasPrefix(rest, "event: ") {
+		i := strings.Index(rest, "\n\n")
+		if i < 0 {
+			break
+		}
+		rest = rest[i+2:]
+	}
+	return body[:len(body)-len(rest)], rest
+}
+
+// SplitTrailer separates a plain text response from the server-sent events
+// appended after it, such as a chat's final usage event. Any preamble is
+// dropped from text; the trailer keeps its leading blank line so
+// preamble+text+trailer is the original body.
+func SplitTrailer(body string) (text, trailer string) {
+	_, body = SplitPreamble(body)
+	if i := strings.Index(body, trailerStart); i >= 0 {
+		return body[:i], body[i:]
+	}
+	return body, ""
+}
+
+// Decode parses a server-sent event stream into events following the
+// WHATWG event stream interpretation rules
+func Decode(r io.Reader) ([]Event, error) {
+	var events []Event
+	var current Event
+	var data []string
+	hasData := false
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := readLine(reader)
+		if err != nil && line == "" {
+			if err == io.EOF {
+				break
+			}
+			return events, err
+		}
+
+		switch {
+		case line == "":
+			if hasData {
+				current.Data = strings.Join(data, "\n")
+				events = append(events, current)
+			}
+			current, data, hasData = Event{}, nil, false
+		case strings.HasPrefix(line, ":"):
+			// Comment line
+		default:
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				current.Name = value
+			case "id":
+				current.ID = value
+			case "data":
+				data = append(data, value)
+				hasData = true
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	// An unterminated final event is discarded, as browsers do
+	return events, nil
+}
+
+// readLine reads up to the next \n, \r\n or \r terminator
+func readLine(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return b.String(), err
+		}
+		switch c {
+		case '\n':
+			return b.String(), nil
+		case '\r':
+			if next, err := r.Peek(1); err == nil && next[0] == '\n' {
+				r.ReadByte()
+			}
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
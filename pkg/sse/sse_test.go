@@ -0,0 +1,127 @@
+package sse
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodeMultiline(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.Encode(Event{Name: "done", ID: "1", Data: "line one\nline two"}); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	want := "id: 1\nevent: done\ndata: line one\ndata: line two\n\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected encoding:\n%q\nwant:\n%q", buf.String(), want)
+	}
+
+	events, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Data != "line one\nline two" || events[0].Name != "done" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func TestEncodeStripsInjectedFields(t *testing.T) {
+	var buf bytes.Buffer
+	NewEncoder(&buf).Encode(Event{Name: "x\ndata: injected", Data: "ok"})
+
+	events, _ := Decode(&buf)
+	if len(events) != 1 || events[0].Data != "ok" {
+		t.Fatalf("event name injected extra data: %+v", events)
+	}
+}
+
+func TestSplitTrailer(t *testing.T) {
+	body := "Hello\n\nworld\n\nevent: truncated\ndata: {}\n\nevent: usage\ndata: {}\n\n"
+	text, trailer := SplitTrailer(body)
+	if text != "Hello\n\nworld" || text+trailer != body {
+		t.Fatalf("unexpected split %q / %q", text, trailer)
+	}
+	events, _ := Decode(strings.NewReader(trailer))
+	if len(events) != 2 || events[1].Name != "usage" {
+		t.Errorf("unexpected trailer events %+v", events)
+	}
+	if text, trailer := SplitTrailer("plain"); text != "plain" || trailer != "" {
+		t.Errorf("expected no trailer, got %q / %q", text, trailer)
+	}
+}
+
+func TestSplitPreamble(t *testing.T) {
+	body := "event: loading\ndata: {}\n\nevent: loading\ndata: {}\n\nHello\n\nevent: usage\ndata: {}\n\n"
+	preamble, rest := SplitPreamble(body)
+	if preamble+rest != body || !strings.HasPrefix(rest, "Hello") {
+		t.Fatalf("unexpected split %q / %q", preamble, rest)
+	}
+	if events, _ := Decode(strings.NewReader(preamble)); len(events) != 2 || events[0].Name != "loading" {
+		t.Errorf("unexpected preamble events %+v", events)
+	}
+	if text, trailer := SplitTrailer(body); text != "Hello" || !strings.HasPrefix(trailer, "\n\nevent: usage") {
+		t.Errorf("unexpected trailer split %q / %q", text, trailer)
+	}
+}
+
+// FuzzRoundTrip asserts that any sequence of events survives encoding and
+// decoding, with line terminators normalised to \n
+func FuzzRoundTrip(f *testing.F) {
+	f.Add("message", "1", "hello")
+	f.Add("", "", "")
+	f.Add("done", "", "a\r\nb\rc\nd")
+	f.Add("x\ny", "id\r", "data: nested\n\nevent: fake")
+	f.Add(":comment", "", ": not a comment")
+
+	f.Fuzz(func(t *testing.T, name, id, data string) {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		in := []Event{{Name: name, ID: id, Data: data}, {Data: "sentinel"}}
+		for _, ev := range in {
+			if err := enc.Encode(ev); err != nil {
+				t.Fatalf("encode failed: %v", err)
+			}
+		}
+
+		out, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		if len(out) != len(in) {
+			t.Fatalf("expected %d events, got %d: %+v", len(in), len(out), out)
+		}
+
+		if out[0].Data != lineBreaks.Replace(data) {
+			t.Errorf("data mismatch: got %q want %q", out[0].Data, lineBreaks.Replace(data))
+		}
+		if out[0].Name != sanitizeField(name) {
+			t.Errorf("name mismatch: got %q want %q", out[0].Name, sanitizeField(name))
+		}
+		if out[0].ID != sanitizeField(id) {
+			t.Errorf("id mismatch: got %q want %q", out[0].ID, sanitizeField(id))
+		}
+		if out[1].Data != "sentinel" {
+			t.Errorf("trailing event corrupted: %+v", out[1])
+		}
+	})
+}
+
+// FuzzDecode asserts the decoder never panics on arbitrary input
+func FuzzDecode(f *testing.F) {
+	f.Add("data: a\n\n")
+	f.Add("event: x\r\ndata: y\r\n\r\n")
+	f.Add(":\n\n\n\rdata")
+	f.Fuzz(func(t *testing.T, stream string) {
+		events, err := Decode(strings.NewReader(stream))
+		if err != nil {
+			t.Fatalf("decode returned error on in-memory input: %v", err)
+		}
+		for _, ev := range events {
+			if strings.ContainsAny(ev.Name, "\r\n") || strings.ContainsAny(ev.ID, "\r\n") {
+				t.Fatalf("decoded field contains a line break: %+v", ev)
+			}
+		}
+	})
+}
@@ -0,0 +1,104 @@
+// Package stops enforces a request's stop sequences and max_tokens on the
+// streamed output, for backends that ignore them. Text that could be the
+// start of a stop sequence is held back until the next chunk settles it, so
+// clients never see part of a stop sequence.
+package stops
+
+import "strings"
+
+// Reasons a scanner ends a generation, as OpenAI finish_reason values
+const (
+	ReasonStop   = "stop"
+	ReasonLength = "length"
+)
+
+// Scanner watches one generation's output
+type Scanner struct {
+	sequences []string
+	maxTokens int
+
+	tokens  int
+	pending string // held back in case it starts a stop sequence
+	reason  string
+}
+
+// New returns a scanner for the given stop sequences and token limit, or
+// nil when there is nothing to enforce. A zero maxTokens is unlimited.
+func New(sequences []string, maxTokens int) *Scanner {
+	var s Scanner
+	for _, seq := range sequences {
+		if seq != "" {
+			s.sequences = append(s.sequences, seq)
+		}
+	}
+	if len(s.sequences) == 0 && maxTokens <= 0 {
+		return nil
+	}
+	s.maxTokens = maxTokens
+	return &s
+}
+
+// Write takes the next token of output and returns the text that can be
+// released. Once it reports done, the generation should be cut and nothing
+// more written.
+func (s *Scanner) Write(token string) (string, bool) {
+	if s.reason != "" {
+		return "", true
+	}
+	if s.maxTokens > 0 && s.tokens >= s.maxTokens {
+		s.reason = ReasonLength
+		out := s.pending
+		s.pending = ""
+		return out, true
+	}
+	s.tokens++
+
+	text := s.pending + token
+	cut := -1
+	for _, seq := range s.sequences {
+		if i := strings.Index(text, seq); i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+		}
+	}
+	if cut >= 0 {
+		s.reason = ReasonStop
+		s.pending = ""
+		return text[:cut], true
+	}
+
+	hold := s.partial(text)
+	s.pending = text[len(text)-hold:]
+	return text[:len(text)-hold], false
+}
+
+// partial returns the length of the longest suffix of text that begins a
+// stop sequence
+func (s *Scanner) partial(text string) int {
+	longest := 0
+	for _, seq := range s.sequences {
+		for n := min(len(seq)-1, len(text)); n > longest; n-- {
+			if strings.HasSuffix(text, seq[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+// Flush returns the held back text once the output has ended without a stop
+func (s *Scanner) Flush() string {
+	out := s.pending
+	s.pending = ""
+	return out
+}
+
+// Reason returns why the scanner ended the generation, or "" if it did not
+func (s *Scanner) Reason() string {
+	return s.reason
+}
+
+// Tokens returns the number of tokens let through
+func (s *Scanner) Tokens() int {
+	return s.tokens
+}
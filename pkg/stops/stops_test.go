@@ -0,0 +1,49 @@
+package stops
+
+import "testing"
+
+func TestScannerHoldsBackPartialStops(t *testing.T) {
+	s := New([]string{"</answer>", "\n\n"}, 0)
+	var out string
+	for _, token := range []string{"42", "</", "ans", "wer> and more"} {
+		text, done := s.Write(token)
+		out += text
+		if done {
+			break
+		}
+		if token == "ans" && out != "42" {
+			t.Errorf("partial stop sequence released: %q", out)
+		}
+	}
+	if out != "42" || s.Reason() != ReasonStop {
+		t.Errorf("output %q, reason %q", out, s.Reason())
+	}
+
+	// Text that turns out not to be a stop sequence is released
+	s = New([]string{"</answer>"}, 0)
+	first, _ := s.Write("a </")
+	second, _ := s.Write("b>")
+	if first+second != "a </b>" || s.Flush() != "" {
+		t.Errorf("released %q then %q", first, second)
+	}
+	s.Write("</ans")
+	if rest := s.Flush(); rest != "</ans" {
+		t.Errorf("flush at end = %q", rest)
+	}
+}
+
+func TestScannerMaxTokens(t *testing.T) {
+	if New([]string{""}, 0) != nil {
+		t.Error("scanner with nothing to enforce")
+	}
+	s := New(nil, 2)
+	for i, token := range []string{"a", "b", "c"} {
+		_, done := s.Write(token)
+		if done != (i == 2) {
+			t.Errorf("token %d: done = %v", i, done)
+		}
+	}
+	if s.Reason() != ReasonLength || s.Tokens() != 2 {
+		t.Errorf("reason %q after %d tokens", s.Reason(), s.Tokens())
+	}
+}
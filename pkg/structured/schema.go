@@ -0,0 +1,345 @@
+package structured
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema validates decoded JSON values against a JSON Schema. It supports
+// the subset used for structured outputs: type, enum, const, properties,
+// required, additionalProperties, items, prefixItems, length and range
+// limits, pattern, allOf/anyOf/oneOf/not and local $ref.
+type Schema struct {
+	root interface{}
+}
+
+// ValidationError reports where a value failed its schema
+type ValidationError struct {
+	Path    string // JSON pointer to the failing value, "" for the root
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Compile parses a JSON Schema document
+func Compile(raw []byte) (*Schema, error) {
+	var root interface{}
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	switch root.(type) {
+	case map[string]interface{}, bool:
+	default:
+		return nil, errors.New("invalid schema: must be an object or boolean")
+	}
+	return &Schema{root: root}, nil
+}
+
+// Validate checks a value decoded with encoding/json against the schema
+func (s *Schema) Validate(v interface{}) error {
+	return s.validate(s.root, v, "", 0)
+}
+
+// maxRefDepth stops recursive $refs from looping forever
+const maxRefDepth = 64
+
+func (s *Schema) validate(node, v interface{}, path string, depth int) error {
+	if depth > maxRefDepth {
+		return &ValidationError{Path: path, Message: "schema references nest too deeply"}
+	}
+	switch n := node.(type) {
+	case bool:
+		if !n {
+			return &ValidationError{Path: path, Message: "no value is allowed here"}
+		}
+		return nil
+	case map[string]interface{}:
+		return s.validateObject(n, v, path, depth)
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(n map[string]interface{}, v interface{}, path string, depth int) error {
+	fail := func(format string, args ...interface{}) error {
+		return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if ref, ok := n["$ref"].(string); ok {
+		target, err := s.resolve(ref)
+		if err != nil {
+			return fail("%v", err)
+		}
+		if err := s.validate(target, v, path, depth+1); err != nil {
+			return err
+		}
+	}
+
+	if t, ok := n["type"]; ok && !matchesType(t, v) {
+		return fail("expected %s, got %s", describeType(t), typeOf(v))
+	}
+	if enum, ok := n["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fail("value is not one of the allowed values")
+		}
+	}
+	if c, ok := n["const"]; ok && !reflect.DeepEqual(c, v) {
+		return fail("value must be %v", c)
+	}
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if err := s.validateProperties(n, value, path, depth); err != nil {
+			return err
+		}
+	case []interface{}:
+		if err := s.validateItems(n, value, path, depth); err != nil {
+			return err
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(value))
+		if min, ok := number(n["minLength"]); ok && length < min {
+			return fail("string is shorter than %v characters", min)
+		}
+		if max, ok := number(n["maxLength"]); ok && length > max {
+			return fail("string is longer than %v characters", max)
+		}
+		if pattern, ok := n["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fail("invalid pattern %q", pattern)
+			}
+			if !re.MatchString(value) {
+				return fail("string does not match pattern %q", pattern)
+			}
+		}
+	case float64:
+		if min, ok := number(n["minimum"]); ok && value < min {
+			return fail("%v is less than the minimum %v", value, min)
+		}
+		if max, ok := number(n["maximum"]); ok && value > max {
+			return fail("%v is greater than the maximum %v", value, max)
+		}
+		if min, ok := number(n["exclusiveMinimum"]); ok && value <= min {
+			return fail("%v must be greater than %v", value, min)
+		}
+		if max, ok := number(n["exclusiveMaximum"]); ok && value >= max {
+			return fail("%v must be less than %v", value, max)
+		}
+	}
+
+	if all, ok := n["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if err := s.validate(sub, v, path, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if any, ok := n["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, sub := range any {
+			if s.validate(sub, v, path, depth+1) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fail("value does not match any of the allowed schemas")
+		}
+	}
+	if one, ok := n["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, sub := range one {
+			if s.validate(sub, v, path, depth+1) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fail("value must match exactly one schema, matched %d", matches)
+		}
+	}
+	if not, ok := n["not"]; ok && s.validate(not, v, path, depth+1) == nil {
+		return fail("value matches a disallowed schema")
+	}
+	return nil
+}
+
+func (s *Schema) validateProperties(n map[string]interface{}, value map[string]interface{}, path string, depth int) error {
+	if required, ok := n["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, present := value[name]; !present {
+				return &ValidationError{Path: path, Message: fmt.Sprintf("missing required property %q", name)}
+			}
+		}
+	}
+	if min, ok := number(n["minProperties"]); ok && float64(len(value)) < min {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("object has fewer than %v properties", min)}
+	}
+	if max, ok := number(n["maxProperties"]); ok && float64(len(value)) > max {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("object has more than %v properties", max)}
+	}
+
+	properties, _ := n["properties"].(map[string]interface{})
+	additional, hasAdditional := n["additionalProperties"]
+	for name, child := range value {
+		childPath := path + "/" + escapePointer(name)
+		if sub, ok := properties[name]; ok {
+			if err := s.validate(sub, child, childPath, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				return &ValidationError{Path: path, Message: fmt.Sprintf("unexpected property %q", name)}
+			}
+			if err := s.validate(additional, child, childPath, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateItems(n map[string]interface{}, value []interface{}, path string, depth int) error {
+	if min, ok := number(n["minItems"]); ok && float64(len(value)) < min {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("array has fewer than %v items", min)}
+	}
+	if max, ok := number(n["maxItems"]); ok && float64(len(value)) > max {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("array has more than %v items", max)}
+	}
+	if unique, _ := n["uniqueItems"].(bool); unique {
+		for i := range value {
+			for j := i + 1; j < len(value); j++ {
+				if reflect.DeepEqual(value[i], value[j]) {
+					return &ValidationError{Path: path, Message: "array items must be unique"}
+				}
+			}
+		}
+	}
+
+	start := 0
+	if prefix, ok := n["prefixItems"].([]interface{}); ok {
+		for i := 0; i < len(prefix) && i < len(value); i++ {
+			if err := s.validate(prefix[i], value[i], path+"/"+strconv.Itoa(i), depth+1); err != nil {
+				return err
+			}
+		}
+		start = len(prefix)
+	}
+	if items, ok := n["items"]; ok {
+		for i := start; i < len(value); i++ {
+			if err := s.validate(items, value[i], path+"/"+strconv.Itoa(i), depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve follows a local "#/..." reference within the schema
+func (s *Schema) resolve(ref string) (interface{}, error) {
+	if ref == "#" {
+		return s.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported reference %q", ref)
+	}
+	node := s.root
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolvable reference %q", ref)
+		}
+		if node, ok = object[token]; !ok {
+			return nil, fmt.Errorf("unresolvable reference %q", ref)
+		}
+	}
+	return node, nil
+}
+
+// matchesType reports whether v has the schema type t, a name or list of names
+func matchesType(t, v interface{}) bool {
+	switch t := t.(type) {
+	case string:
+		return hasType(t, v)
+	case []interface{}:
+		for _, name := range t {
+			if s, ok := name.(string); ok && hasType(s, v) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func hasType(name string, v interface{}) bool {
+	switch name {
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	}
+	return typeOf(v) == name
+}
+
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func describeType(t interface{}) string {
+	if list, ok := t.([]interface{}); ok {
+		names := make([]string, len(list))
+		for i, name := range list {
+			names[i] = fmt.Sprint(name)
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func number(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
@@ -0,0 +1,289 @@
+package structured
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/sse"
+	"github.com/openai/openai-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Format types requesting JSON output
+const (
+	TypeJSONObject = "json_object"
+	TypeJSONSchema = "json_schema"
+)
+
+// maxBodyBytes caps a chat request body read by the middleware
+const maxBodyBytes = 10 << 20
+
+// Format is the structured output requested for a chat
+type Format struct {
+	Type        string
+	Name        string
+	Description string
+	Strict      bool
+	RawSchema   json.RawMessage
+	Schema      *Schema // nil for json_object
+}
+
+// Parse reads the OpenAI-style response_format field, or the json_schema
+// shorthand holding just a schema. It returns nil when no JSON is requested.
+func Parse(responseFormat, jsonSchema json.RawMessage) (*Format, error) {
+	if len(responseFormat) == 0 && len(jsonSchema) == 0 {
+		return nil, nil
+	}
+
+	var spec struct {
+		Type       string `json:"type"`
+		JSONSchema struct {
+			Name        string          `json:"name"`
+			Description string          `json:"description"`
+			Schema      json.RawMessage `json:"schema"`
+			Strict      bool            `json:"strict"`
+		} `json:"json_schema"`
+	}
+	if len(responseFormat) > 0 {
+		if err := json.Unmarshal(responseFormat, &spec); err != nil {
+			return nil, errors.New("invalid response_format")
+		}
+	} else {
+		spec.Type = TypeJSONSchema
+		spec.JSONSchema.Schema = jsonSchema
+	}
+
+	switch spec.Type {
+	case "", "text":
+		return nil, nil
+	case TypeJSONObject:
+		return &Format{Type: TypeJSONObject}, nil
+	case TypeJSONSchema:
+	default:
+		return nil, fmt.Errorf("unsupported response_format type %q", spec.Type)
+	}
+
+	if len(spec.JSONSchema.Schema) == 0 {
+		return nil, errors.New("json_schema requires a schema")
+	}
+	schema, err := Compile(spec.JSONSchema.Schema)
+	if err != nil {
+		return nil, err
+	}
+	name := spec.JSONSchema.Name
+	if name == "" {
+		name = "response"
+	}
+	return &Format{
+		Type:        TypeJSONSchema,
+		Name:        name,
+		Description: spec.JSONSchema.Description,
+		Strict:      spec.JSONSchema.Strict,
+		RawSchema:   spec.JSONSchema.Schema,
+		Schema:      schema,
+	}, nil
+}
+
+// Param returns the response_format to send upstream
+func (f *Format) Param() openai.ChatCompletionNewParamsResponseFormatUnion {
+	if f.Type == TypeJSONObject {
+		return openai.ResponseFormatJSONObjectParam{Type: openai.F(openai.ResponseFormatJSONObjectTypeJSONObject)}
+	}
+	schema := openai.ResponseFormatJSONSchemaJSONSchemaParam{
+		Name:   openai.F(f.Name),
+		Schema: openai.F[interface{}](f.RawSchema),
+		Strict: openai.F(f.Strict),
+	}
+	if f.Description != "" {
+		schema.Description = openai.F(f.Description)
+	}
+	return openai.ResponseFormatJSONSchemaParam{
+		Type:       openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+		JSONSchema: openai.F(schema),
+	}
+}
+
+// Check validates generated text as JSON matching the format. A surrounding
+// markdown code fence is tolerated, since many local models add one.
+func (f *Format) Check(text string) error {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(text), &v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if f.Type == TypeJSONObject {
+		if _, ok := v.(map[string]interface{}); !ok {
+			return errors.New("expected a JSON object")
+		}
+		return nil
+	}
+	return f.Schema.Validate(v)
+}
+
+// Enforcer validates structured chat responses before they reach the client
+type Enforcer struct {
+	MaxRetries   int                    // regenerations after an invalid response
+	DefaultModel string                 // metric label when the request names no model
+	Failures     *prometheus.CounterVec // labels: model
+}
+
+// Middleware buffers the responses of chat requests that ask for JSON,
+// validates them and asks the model to correct invalid output, continuing
+// the same conversation. Responses still invalid after the retries are
+// delivered with a trailing "invalid_json" event. Other requests pass through.
+func (e *Enforcer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, "Invalid request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(body, &fields) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		format, err := Parse(fields["response_format"], fields["json_schema"])
+		if err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, err.Error())
+			return
+		}
+		if format == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		model := e.DefaultModel
+		if raw, ok := fields["model"]; ok {
+			json.Unmarshal(raw, &model)
+		}
+
+		// Retries can outlast the server's write timeout
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		log := logger.GetLogger()
+		var rec *recorder
+		var text, trailer, conversationID string
+		for attempt := 0; ; attempt++ {
+			rec = run(next, r, body, conversationID)
+			if rec.status != http.StatusOK {
+				rec.copyTo(w)
+				return
+			}
+			text, trailer = sse.SplitTrailer(rec.body.String())
+			err = format.Check(text)
+			if err == nil {
+				break
+			}
+
+			e.Failures.WithLabelValues(model).Inc()
+			log.Warn().Err(err).Str("model", model).Int("attempt", attempt+1).Msg("Structured output failed validation")
+			conversationID = rec.header.Get("X-Conversation-ID")
+			if attempt >= e.MaxRetries || conversationID == "" {
+				break
+			}
+			body = correction(fields, err)
+		}
+
+		rec.copyTo(w)
+		if err != nil {
+			if trailer == "" {
+				trailer = "\n\n"
+			}
+			w.Write([]byte(text + trailer))
+			data, _ := json.Marshal(map[string]string{"error": err.Error()})
+			sse.NewEncoder(w).Encode(sse.Event{Name: "invalid_json", Data: string(data)})
+			return
+		}
+		w.Write([]byte(text + trailer))
+	})
+}
+
+// correction builds the follow-up request asking the model to fix its answer.
+// The previous attempt is in the stored conversation, so only the new turn
+// is sent.
+func correction(fields map[string]json.RawMessage, problem error) []byte {
+	next := make(map[string]json.RawMessage, len(fields))
+	for k, v := range fields {
+		next[k] = v
+	}
+	delete(next, "messages")
+	delete(next, "conversation_id")
+	next["message"], _ = json.Marshal(fmt.Sprintf(
+		"Your previous reply was not valid: %v. Reply again with only the corrected JSON, without any other text.", problem))
+	body, _ := json.Marshal(next)
+	return body
+}
+
+// run serves one attempt into a buffer. Retries name the conversation
+// holding the earlier attempts.
+func run(next http.Handler, r *http.Request, body []byte, conversationID string) *recorder {
+	attempt := r.Clone(r.Context())
+	attempt.Body = io.NopCloser(bytes.NewReader(body))
+	attempt.ContentLength = int64(len(body))
+	if conversationID != "" {
+		attempt.Header.Set("X-Conversation-ID", conversationID)
+	}
+
+	rec := &recorder{header: make(http.Header)}
+	next.ServeHTTP(rec, attempt)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec
+}
+
+// recorder buffers a handler's response in memory
+type recorder struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func (rec *recorder) Header() http.Header { return rec.header }
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(p)
+}
+
+// Flush is a no-op, so streaming handlers can run against the recorder
+func (rec *recorder) Flush() {}
+
+// copyTo writes the recorded headers and status to w, and the body too
+// unless the request succeeded, in which case the caller writes it
+func (rec *recorder) copyTo(w http.ResponseWriter) {
+	for name, values := range rec.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(rec.status)
+	if rec.status != http.StatusOK {
+		w.Write(rec.body.Bytes())
+	}
+}
@@ -0,0 +1,127 @@
+package structured
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := Compile([]byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"age": {"type": "integer", "minimum": 0},
+			"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}, "maxItems": 2},
+			"role": {"enum": ["admin", "user"]}
+		},
+		"required": ["name"],
+		"additionalProperties": false,
+		"$defs": {"tag": {"type": "string", "pattern": "^[a-z]+$"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		doc   string
+		valid bool
+	}{
+		{`{"name": "ada", "age": 36, "tags": ["math"], "role": "admin"}`, true},
+		{`{"age": 36}`, false},
+		{`{"name": "ada", "age": 36.5}`, false},
+		{`{"name": "ada", "age": -1}`, false},
+		{`{"name": "ada", "tags": ["Math"]}`, false},
+		{`{"name": "ada", "tags": ["a", "b", "c"]}`, false},
+		{`{"name": "ada", "role": "owner"}`, false},
+		{`{"name": "ada", "extra": true}`, false},
+		{`["ada"]`, false},
+	}
+	for _, c := range cases {
+		var v interface{}
+		json.Unmarshal([]byte(c.doc), &v)
+		if err := schema.Validate(v); (err == nil) != c.valid {
+			t.Errorf("%s: valid = %v, want %v (err %v)", c.doc, err == nil, c.valid, err)
+		}
+	}
+}
+
+func TestParseAndCheck(t *testing.T) {
+	if format, err := Parse(nil, nil); format != nil || err != nil {
+		t.Errorf("expected no format without fields, got %v, %v", format, err)
+	}
+	if _, err := Parse(json.RawMessage(`{"type":"json_schema"}`), nil); err == nil {
+		t.Error("expected an error for json_schema without a schema")
+	}
+
+	format, err := Parse(json.RawMessage(`{"type":"json_object"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := format.Check("```json\n{\"ok\": true}\n```"); err != nil {
+		t.Errorf("fenced JSON rejected: %v", err)
+	}
+	if err := format.Check("[1, 2]"); err == nil {
+		t.Error("expected a JSON array to be rejected for json_object")
+	}
+	if err := format.Check("Sure! Here it is"); err == nil {
+		t.Error("expected plain text to be rejected")
+	}
+}
+
+func TestEnforcerRetriesInvalidOutput(t *testing.T) {
+	var bodies []map[string]interface{}
+	replies := []string{"not json", `{"city": "Paris"}`}
+	chat := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		if len(bodies) > 1 && r.Header.Get("X-Conversation-ID") != "conv-1" {
+			t.Errorf("retry did not continue the conversation, header %q", r.Header.Get("X-Conversation-ID"))
+		}
+		w.Header().Set("X-Conversation-ID", "conv-1")
+		io.WriteString(w, replies[len(bodies)-1])
+	})
+
+	failures := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "failures"}, []string{"model"})
+	enforcer := &Enforcer{MaxRetries: 1, DefaultModel: "m", Failures: failures}
+	server := httptest.NewServer(enforcer.Middleware(chat))
+	defer server.Close()
+
+	request := `{"message": "Describe Paris", "json_schema": {"type": "object", "required": ["city"]}}`
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(out) != `{"city": "Paris"}` {
+		t.Errorf("response = %q, want the corrected JSON", out)
+	}
+	if len(bodies) != 2 || !strings.Contains(bodies[1]["message"].(string), "not valid") {
+		t.Fatalf("expected a correction request, got %v", bodies)
+	}
+	if got := testutil.ToFloat64(failures.WithLabelValues("m")); got != 1 {
+		t.Errorf("failures = %v, want 1", got)
+	}
+
+	// Without retries left, the invalid output is flagged
+	bodies, replies = nil, []string{"still not json"}
+	enforcer.MaxRetries = 0
+	resp, err = http.Post(server.URL, "application/json", strings.NewReader(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.HasPrefix(string(out), "still not json") || !strings.Contains(string(out), "event: invalid_json") {
+		t.Errorf("expected an invalid_json event, got %q", out)
+	}
+}
@@ -0,0 +1,248 @@
+// Package summarize gives stored conversations a one-line title and a short
+// summary, generated in the background by a model, so the history browser
+// can list sessions by what they were about.
+package summarize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/history"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/openai/openai-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// instructions tell the model what to write
+const instructions = `You write titles and summaries for a list of chat sessions.
+Reply with only a JSON object: {"title": "...", "summary": "..."}.
+The title is at most 8 words and names what the user wanted. The summary is
+one or two sentences on what was asked and answered.`
+
+// maxTranscript bounds the conversation text sent to the model, in bytes.
+// The start of a conversation says most about what it is for.
+const maxTranscript = 8000
+
+// Metrics holds the collectors the summarizer reports to
+type Metrics struct {
+	Summaries *prometheus.CounterVec // labels: result (ok|error|dropped)
+}
+
+// Summarizer summarizes queued conversations with a model
+type Summarizer struct {
+	client  *openai.Client
+	model   string
+	store   *history.Store
+	metrics Metrics
+	// RefreshEvery is how many new messages make a summary stale
+	RefreshEvery int
+
+	queue   chan string
+	mu      sync.Mutex
+	pending map[string]bool
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// New creates a summarizer that titles the conversations in store with model
+func New(client *openai.Client, model string, store *history.Store, metrics Metrics) *Summarizer {
+	return &Summarizer{
+		client:       client,
+		model:        model,
+		store:        store,
+		metrics:      metrics,
+		RefreshEvery: 10,
+		queue:        make(chan string, 100),
+		pending:      make(map[string]bool),
+	}
+}
+
+// Needs reports whether c has no summary yet, or RefreshEvery messages
+// have been added since it was written
+func (s *Summarizer) Needs(c history.Conversation) bool {
+	if len(c.Messages) == 0 {
+		return false
+	}
+	return c.Title == "" || (s.RefreshEvery > 0 && len(c.Messages)-c.SummaryMessages >= s.RefreshEvery)
+}
+
+// Start launches workers that summarize queued conversations until ctx is cancelled
+func (s *Summarizer) Start(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id, ok := <-s.queue:
+					if !ok {
+						return
+					}
+					s.summarize(ctx, id)
+				}
+			}
+		}()
+	}
+}
+
+// Submit queues a conversation that may need a summary. It never blocks;
+// conversations already queued are skipped, and ones arriving while the
+// queue is full are dropped.
+func (s *Summarizer) Submit(id string) {
+	if !s.enqueue(id) {
+		s.count("dropped")
+	}
+}
+
+// Backfill queues every stored conversation that needs a summary, waiting
+// for room in the queue, until done or ctx is cancelled
+func (s *Summarizer) Backfill(ctx context.Context) error {
+	stale, err := s.store.ListWhere(0, s.Needs)
+	if err != nil {
+		return err
+	}
+	for _, c := range stale {
+		for !s.enqueue(c.ID) {
+			select {
+			case <-time.After(backfillPause):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// backfillPause is how long Backfill waits for room in a full queue
+const backfillPause = time.Second
+
+// Close stops accepting conversations and waits for queued ones to finish
+func (s *Summarizer) Close() {
+	s.mu.Lock()
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// enqueue queues id unless it is already queued or the summarizer is
+// closed. It reports false if the queue is full.
+func (s *Summarizer) enqueue(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.pending[id] {
+		return true
+	}
+	select {
+	case s.queue <- id:
+		s.pending[id] = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Summarizer) release(id string) {
+	s.mu.Lock()
+	delete(s.pending, id)
+	s.mu.Unlock()
+}
+
+func (s *Summarizer) summarize(ctx context.Context, id string) {
+	defer s.release(id)
+	log := logger.GetLogger()
+
+	c, err := s.store.Get(id)
+	if err != nil || !s.Needs(c) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	title, summary, err := s.Generate(ctx, c.Messages)
+	if err != nil {
+		s.count("error")
+		log.Warn().Err(err).Str("conversation_id", id).Msg("Failed to summarize conversation")
+		return
+	}
+
+	covered := len(c.Messages)
+	if _, err := s.store.Update(id, func(c *history.Conversation) {
+		c.Title, c.Summary, c.SummaryMessages = title, summary, covered
+	}); err != nil {
+		log.Warn().Err(err).Str("conversation_id", id).Msg("Failed to store conversation summary")
+		return
+	}
+	s.count("ok")
+}
+
+// Generate asks the model for a title and summary of messages
+func (s *Summarizer) Generate(ctx context.Context, messages []history.Message) (string, string, error) {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, msg.Content)
+		if transcript.Len() >= maxTranscript {
+			break
+		}
+	}
+	text := transcript.String()
+	if len(text) > maxTranscript {
+		text = strings.ToValidUTF8(text[:maxTranscript], "") + "…"
+	}
+
+	completion, err := s.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+		Model: openai.F(s.model),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(instructions),
+			openai.UserMessage(text),
+		}),
+		Temperature: openai.F(0.2),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if len(completion.Choices) == 0 {
+		return "", "", errors.New("model returned no choices")
+	}
+	return Parse(completion.Choices[0].Message.Content)
+}
+
+// maxTitle bounds a title in characters, should the model ramble
+const maxTitle = 80
+
+// Parse extracts the title and summary from the model's reply, tolerating
+// surrounding prose or code fences
+func Parse(reply string) (string, string, error) {
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return "", "", fmt.Errorf("no JSON object in reply %q", reply)
+	}
+	var parsed struct {
+		Title   string `json:"title"`
+		Summary string `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &parsed); err != nil {
+		return "", "", fmt.Errorf("invalid reply: %w", err)
+	}
+	title := strings.Trim(strings.Join(strings.Fields(parsed.Title), " "), `"'.`)
+	if title == "" {
+		return "", "", errors.New("reply has no title")
+	}
+	if runes := []rune(title); len(runes) > maxTitle {
+		title = string(runes[:maxTitle]) + "…"
+	}
+	return title, strings.TrimSpace(parsed.Summary), nil
+}
+
+func (s *Summarizer) count(result string) {
+	if s.metrics.Summaries != nil {
+		s.metrics.Summaries.WithLabelValues(result).Inc()
+	}
+}
@@ -0,0 +1,65 @@
+package summarize
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ajeetraina/aiwatch/internal/testsupport"
+	"github.com/ajeetraina/aiwatch/pkg/history"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newStore() *history.Store {
+	return history.New(nil, 1<<20, history.Metrics{
+		Lookups:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "lookups"}, []string{"result"}),
+		Evictions:   prometheus.NewCounter(prometheus.CounterOpts{Name: "evictions"}),
+		MemoryBytes: prometheus.NewGauge(prometheus.GaugeOpts{Name: "bytes"}),
+		Entries:     prometheus.NewGauge(prometheus.GaugeOpts{Name: "entries"}),
+	})
+}
+
+func TestSummarizerTitlesConversations(t *testing.T) {
+	backend := testsupport.NewFakeBackend(`Sure! {"title": "Fixing a Go build error.", "summary": "The user asked why go build fails."}`)
+	defer backend.Close()
+	client := openai.NewClient(option.WithBaseURL(backend.BaseURL()), option.WithAPIKey("test"))
+
+	store := newStore()
+	store.Append("c1", "", "m",
+		history.Message{Role: "user", Content: "go build fails"},
+		history.Message{Role: "assistant", Content: "Run go mod tidy"},
+	)
+	s := New(client, "ai/small", store, Metrics{})
+	s.RefreshEvery = 4
+
+	if err := s.Backfill(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Start(context.Background(), 1)
+	s.Close()
+
+	c, _ := store.Get("c1")
+	if c.Title != "Fixing a Go build error" || c.Summary != "The user asked why go build fails." || c.SummaryMessages != 2 {
+		t.Errorf("conversation = %+v", c)
+	}
+	if s.Needs(c) {
+		t.Error("fresh summary reported stale")
+	}
+	c.Messages = append(c.Messages, make([]history.Message, 4)...)
+	if !s.Needs(c) {
+		t.Error("summary not refreshed after new messages")
+	}
+	if summaries, _ := store.List(0); summaries[0].Title != c.Title {
+		t.Errorf("listing = %+v", summaries[0])
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, _, err := Parse(`{"title": "", "summary": "x"}`); err == nil {
+		t.Error("empty title accepted")
+	}
+	if _, _, err := Parse("No idea."); err == nil {
+		t.Error("reply without JSON accepted")
+	}
+}
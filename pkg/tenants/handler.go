@@ -0,0 +1,100 @@
+package tenants
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+)
+
+// Handler serves the /tenants CRUD API. API keys are masked in responses.
+func (r *Registry) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tenants", r.handleList)
+	mux.HandleFunc("POST /tenants", r.handleCreate)
+	mux.HandleFunc("GET /tenants/{id}", r.handleGet)
+	mux.HandleFunc("PUT /tenants/{id}", r.handleUpdate)
+	mux.HandleFunc("DELETE /tenants/{id}", r.handleDelete)
+	return mux
+}
+
+func (r *Registry) handleList(w http.ResponseWriter, req *http.Request) {
+	list := r.List()
+	for i := range list {
+		list[i] = list[i].Masked()
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (r *Registry) handleGet(w http.ResponseWriter, req *http.Request) {
+	t, err := r.Get(req.PathValue("id"))
+	if err != nil {
+		apierror.Write(w, req, apierror.NotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, t.Masked())
+}
+
+func (r *Registry) handleCreate(w http.ResponseWriter, req *http.Request) {
+	var t Tenant
+	if err := json.NewDecoder(req.Body).Decode(&t); err != nil {
+		apierror.Write(w, req, apierror.InvalidRequest, "invalid request body")
+		return
+	}
+	if _, err := r.Get(t.ID); err == nil {
+		apierror.WriteStatus(w, req, http.StatusConflict, apierror.InvalidRequest, fmt.Sprintf("tenant %q already exists", t.ID))
+		return
+	}
+	r.put(w, req, t, http.StatusCreated)
+}
+
+func (r *Registry) handleUpdate(w http.ResponseWriter, req *http.Request) {
+	var t Tenant
+	if err := json.NewDecoder(req.Body).Decode(&t); err != nil {
+		apierror.Write(w, req, apierror.InvalidRequest, "invalid request body")
+		return
+	}
+	t.ID = req.PathValue("id")
+	r.put(w, req, t, http.StatusOK)
+}
+
+// put stores t and writes the response
+func (r *Registry) put(w http.ResponseWriter, req *http.Request, t Tenant, status int) {
+	saved, err := r.Put(t)
+	if errors.Is(err, ErrKeyInUse) {
+		apierror.WriteStatus(w, req, http.StatusConflict, apierror.InvalidRequest, err.Error())
+		return
+	}
+	if err != nil && saved.ID == "" {
+		apierror.Write(w, req, apierror.InvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log := logger.GetLogger()
+		log.Error().Err(err).Str("tenant", t.ID).Msg("Failed to persist tenants")
+	}
+	writeJSON(w, status, saved.Masked())
+}
+
+func (r *Registry) handleDelete(w http.ResponseWriter, req *http.Request) {
+	err := r.Delete(req.PathValue("id"))
+	if errors.Is(err, ErrNotFound) {
+		apierror.Write(w, req, apierror.NotFound, err.Error())
+		return
+	}
+	if err != nil {
+		log := logger.GetLogger()
+		log.Error().Err(err).Msg("Failed to persist tenants")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
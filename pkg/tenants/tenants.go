@@ -0,0 +1,325 @@
+// Package tenants partitions one aiwatch instance between teams. Each
+// tenant owns a set of API keys; requests presenting one of them are scoped
+// to the tenant for metrics, stored conversations, quotas and the models
+// they may use. Requests without a known key belong to the Default tenant.
+package tenants
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/quota"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Default is the tenant of requests without a tenant's API key
+const Default = "default"
+
+var (
+	// ErrNotFound is returned when a tenant doesn't exist
+	ErrNotFound = errors.New("tenant not found")
+	// ErrKeyInUse is returned when an API key already belongs to another tenant
+	ErrKeyInUse = errors.New("API key already belongs to another tenant")
+)
+
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Tenant is a team sharing the instance
+type Tenant struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name,omitempty"`
+	APIKeys []string `json:"api_keys,omitempty"`
+	// Models is the allowlist of models the tenant may use; empty allows all
+	Models []string `json:"models,omitempty"`
+	// Limits are shared by all of the tenant's callers, while KeyLimits
+	// apply to each key on its own unless the quota file overrides them
+	Limits    quota.Limits `json:"limits"`
+	KeyLimits quota.Limits `json:"key_limits"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// Allows reports whether the tenant may use model
+func (t Tenant) Allows(model string) bool {
+	return len(t.Models) == 0 || slices.Contains(t.Models, model)
+}
+
+// Masked returns t with its API keys hidden, for responses and audit entries
+func (t Tenant) Masked() Tenant {
+	keys := make([]string, len(t.APIKeys))
+	for i, key := range t.APIKeys {
+		keys[i] = quota.Mask(key)
+	}
+	t.APIKeys = keys
+	return t
+}
+
+type contextKey struct{}
+
+// FromContext returns the tenant stored by the middleware, or Default
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKey{}).(string); ok {
+		return id
+	}
+	return Default
+}
+
+// Same reports whether a stored tenant ID, where empty means data written
+// before tenants were configured, is tenant
+func Same(stored, tenant string) bool {
+	if stored == "" {
+		stored = Default
+	}
+	return stored == tenant
+}
+
+// Registry holds the tenants in memory, persisting them to a JSON file when
+// configured
+type Registry struct {
+	path     string
+	requests *prometheus.CounterVec // labels: tenant
+
+	mu      sync.RWMutex
+	tenants map[string]Tenant
+	keys    map[string]string // API key to tenant ID
+}
+
+// New creates an empty in-memory registry
+func New(requests *prometheus.CounterVec) *Registry {
+	return &Registry{
+		requests: requests,
+		tenants:  make(map[string]Tenant),
+		keys:     make(map[string]string),
+	}
+}
+
+// Load creates a registry, loading tenants from path if it exists
+func Load(path string, requests *prometheus.CounterVec) (*Registry, error) {
+	r := New(requests)
+	r.path = path
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return r, fmt.Errorf("failed to read tenants: %w", err)
+	}
+	var stored []Tenant
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return r, fmt.Errorf("failed to parse tenants: %w", err)
+	}
+	for _, t := range stored {
+		if err := r.check(t); err != nil {
+			return r, err
+		}
+		r.add(t)
+	}
+	return r, nil
+}
+
+// Get returns a tenant by ID
+func (r *Registry) Get(id string) (Tenant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.tenants[id]
+	if !ok {
+		return Tenant{}, ErrNotFound
+	}
+	return t, nil
+}
+
+// List returns all tenants sorted by ID
+func (r *Registry) List() []Tenant {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]Tenant, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Put creates or replaces a tenant. When replacing, nil APIKeys keep the
+// existing keys and masked keys, as returned by Masked, stand for the
+// existing keys they mask, so a tenant read back can be written unchanged.
+func (r *Registry) Put(t Tenant) (Tenant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	existing := r.tenants[t.ID]
+	if t.APIKeys == nil {
+		t.APIKeys = existing.APIKeys
+	}
+	keys, err := unmask(t.APIKeys, existing.APIKeys)
+	if err != nil {
+		return Tenant{}, err
+	}
+	t.APIKeys = keys
+	if err := r.check(t); err != nil {
+		return Tenant{}, err
+	}
+
+	now := time.Now().UTC()
+	t.UpdatedAt = now
+	if existing, ok := r.tenants[t.ID]; ok {
+		t.CreatedAt = existing.CreatedAt
+		r.remove(t.ID)
+	} else {
+		t.CreatedAt = now
+	}
+	r.add(t)
+	return t, r.save()
+}
+
+// Delete removes a tenant. Its keys fall back to the Default tenant.
+func (r *Registry) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tenants[id]; !ok {
+		return ErrNotFound
+	}
+	r.remove(id)
+	return r.save()
+}
+
+// unmask replaces the masked keys in keys with the existing keys they mask.
+// A masked key matching none of them is an error.
+func unmask(keys, existing []string) ([]string, error) {
+	out := make([]string, len(keys))
+	for i, key := range keys {
+		out[i] = key
+		if !strings.Contains(key, "…") {
+			continue
+		}
+		j := slices.IndexFunc(existing, func(k string) bool { return quota.Mask(k) == key })
+		if j < 0 {
+			return nil, fmt.Errorf("API key %q is masked and matches none of the tenant's keys; send it in full", key)
+		}
+		out[i] = existing[j]
+	}
+	return out, nil
+}
+
+// check validates t against the other tenants. Callers must hold r.mu.
+func (r *Registry) check(t Tenant) error {
+	if !idPattern.MatchString(t.ID) || t.ID == Default {
+		return fmt.Errorf("invalid tenant ID %q", t.ID)
+	}
+	for _, key := range t.APIKeys {
+		if key == "" {
+			return errors.New("API keys must not be empty")
+		}
+		if owner, ok := r.keys[key]; ok && owner != t.ID {
+			return ErrKeyInUse
+		}
+	}
+	return nil
+}
+
+// add indexes t. Callers must hold r.mu.
+func (r *Registry) add(t Tenant) {
+	r.tenants[t.ID] = t
+	for _, key := range t.APIKeys {
+		r.keys[key] = t.ID
+	}
+}
+
+// remove drops a tenant and its keys. Callers must hold r.mu.
+func (r *Registry) remove(id string) {
+	for _, key := range r.tenants[id].APIKeys {
+		delete(r.keys, key)
+	}
+	delete(r.tenants, id)
+}
+
+// save writes all tenants to the registry file. Callers must hold r.mu.
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	list := make([]Tenant, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The file holds API keys
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// Resolve returns the ID of the tenant owning an API key, or Default
+func (r *Registry) Resolve(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if id, ok := r.keys[key]; ok {
+		return id
+	}
+	return Default
+}
+
+// Allows reports whether tenant may use model. Unknown tenants, including
+// an unconfigured Default, may use every model.
+func (r *Registry) Allows(tenant, model string) bool {
+	t, err := r.Get(tenant)
+	return err != nil || t.Allows(model)
+}
+
+// TenantOf returns the tenant of a request that passed through the
+// middleware; with TenantLimits it lets quota.Enforcer count usage per tenant
+func (r *Registry) TenantOf(req *http.Request) string {
+	return FromContext(req.Context())
+}
+
+// HasKey reports whether key is one of a tenant's API keys
+func (r *Registry) HasKey(key string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.keys[key]
+	return ok
+}
+
+// TenantLimits returns the shared and per-key limits of tenant
+func (r *Registry) TenantLimits(tenant string) (shared, perKey quota.Limits) {
+	t, err := r.Get(tenant)
+	if err != nil {
+		return quota.Limits{}, quota.Limits{}
+	}
+	return t.Limits, t.KeyLimits
+}
+
+// Middleware scopes each request to the tenant of its API key
+func (r *Registry) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tenant := Default
+		if key := quota.APIKey(req); key != "" {
+			tenant = r.Resolve(key)
+		}
+		if r.requests != nil {
+			r.requests.WithLabelValues(tenant).Inc()
+		}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, tenant)))
+	})
+}
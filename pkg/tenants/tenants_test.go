@@ -0,0 +1,167 @@
+package tenants
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ajeetraina/aiwatch/pkg/quota"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRegistryPersistsAndResolvesKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	r, err := Load(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Put(Tenant{ID: "search", APIKeys: []string{"key-search"}, Models: []string{"small"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Put(Tenant{ID: "billing", APIKeys: []string{"key-search"}}); err != ErrKeyInUse {
+		t.Errorf("shared key: err = %v", err)
+	}
+	if _, err := r.Put(Tenant{ID: "Bad ID"}); err == nil {
+		t.Error("invalid ID accepted")
+	}
+
+	reloaded, err := Load(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.Resolve("key-search"); got != "search" {
+		t.Errorf("Resolve = %q", got)
+	}
+	if got := reloaded.Resolve("unknown"); got != Default {
+		t.Errorf("unknown key resolved to %q", got)
+	}
+	if reloaded.Allows("search", "large") || !reloaded.Allows("search", "small") || !reloaded.Allows(Default, "large") {
+		t.Error("model allowlist not applied")
+	}
+
+	if err := reloaded.Delete("search"); err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.Resolve("key-search"); got != Default {
+		t.Errorf("deleted tenant's key resolved to %q", got)
+	}
+}
+
+func TestMiddlewareScopesRequests(t *testing.T) {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"tenant"})
+	r := New(requests)
+	r.Put(Tenant{ID: "search", APIKeys: []string{"key-search"}})
+
+	var seen string
+	handler := r.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen = FromContext(req.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer key-search")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if seen != "search" {
+		t.Errorf("tenant = %q", seen)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if seen != Default {
+		t.Errorf("tenant without a key = %q", seen)
+	}
+	if got := testutil.ToFloat64(requests.WithLabelValues("search")); got != 1 {
+		t.Errorf("search requests = %v", got)
+	}
+}
+
+func TestQuotaSharedByTenant(t *testing.T) {
+	r := New(nil)
+	r.Put(Tenant{ID: "search", APIKeys: []string{"key-a", "key-b"}, Limits: quota.Limits{RequestsPerHour: 2}})
+	enforcer := quota.New(quota.Config{}, nil)
+	enforcer.Tenants = r
+	handler := r.Middleware(enforcer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})))
+
+	codes := make([]int, 0, 3)
+	for _, key := range []string{"key-a", "key-b", "key-a"} {
+		req := httptest.NewRequest(http.MethodPost, "/chat", nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("codes = %v, want the third request over the tenant's limit", codes)
+	}
+
+	// Callers outside the tenant are unaffected
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/chat", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("default tenant = %d", rec.Code)
+	}
+}
+
+func TestHandlerMasksKeys(t *testing.T) {
+	handler := New(nil).Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tenants", strings.NewReader(`{"id":"search","api_keys":["sk-0123456789abcdef"]}`)))
+	if rec.Code != http.StatusCreated || strings.Contains(rec.Body.String(), "0123456789") {
+		t.Errorf("create: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tenants", strings.NewReader(`{"id":"search"}`)))
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), `"code":"invalid_request"`) {
+		t.Errorf("duplicate create = %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/tenants/search", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("delete = %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tenants/search", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("get after delete = %d", rec.Code)
+	}
+}
+
+func TestHandlerUpdateKeepsMaskedKeys(t *testing.T) {
+	registry := New(nil)
+	handler := registry.Handler()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	serve(http.MethodPost, "/tenants", `{"id":"search","api_keys":["sk-0123456789abcdef","sk-fedcba9876543210"]}`)
+
+	// Write back what GET returned, with a new name
+	read := serve(http.MethodGet, "/tenants/search", "")
+	body := strings.Replace(read.Body.String(), `"id":"search"`, `"id":"search","name":"Search"`, 1)
+	if rec := serve(http.MethodPut, "/tenants/search", body); rec.Code != http.StatusOK {
+		t.Fatalf("update with masked keys = %d %s", rec.Code, rec.Body)
+	}
+	if got, _ := registry.Get("search"); got.Name != "Search" || !slices.Equal(got.APIKeys, []string{"sk-0123456789abcdef", "sk-fedcba9876543210"}) {
+		t.Errorf("expected the keys kept, got %+v", got)
+	}
+
+	if rec := serve(http.MethodPut, "/tenants/search", `{"name":"Search"}`); rec.Code != http.StatusOK {
+		t.Fatalf("update without keys = %d %s", rec.Code, rec.Body)
+	}
+	if got, _ := registry.Get("search"); len(got.APIKeys) != 2 {
+		t.Errorf("expected omitted keys kept, got %+v", got.APIKeys)
+	}
+
+	for name, tc := range map[string]struct{ method, path, body string }{
+		"unknown masked key": {http.MethodPut, "/tenants/search", `{"api_keys":["sk-0…0000"]}`},
+		"default ID":         {http.MethodPost, "/tenants", `{"id":"default"}`},
+	} {
+		if rec := serve(tc.method, tc.path, tc.body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Package timeouts gives each route its own deadline in place of a single
+// server-wide write timeout, which either cut long generations short or
+// let quick JSON endpoints hang for as long as the slowest stream needed.
+// Ordinary routes get a short context deadline; streaming routes may run
+// as long as they keep sending, and are cancelled once they go quiet.
+package timeouts
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons a request is cut off, the values of the reason label
+const (
+	ReasonDeadline = "deadline"
+	ReasonIdle     = "idle"
+)
+
+// Causes of a cancelled request context, from context.Cause
+var (
+	ErrDeadline = errors.New("request deadline exceeded")
+	ErrIdle     = errors.New("stream sent nothing for too long")
+)
+
+// DefaultRoute labels requests to routes not listed in a Config
+const DefaultRoute = "default"
+
+// writeGrace is how long past its deadline a request may still write, so
+// the handler can report the timeout
+const writeGrace = 5 * time.Second
+
+// Config sets the deadlines. Routes are exact paths, or prefixes ending in
+// / that match every path below them; the longest match wins.
+type Config struct {
+	Default time.Duration // deadline for ordinary routes, 0 for none
+	Stream  time.Duration // deadline for streaming routes, 0 for none
+	// Idle is how long a streaming response that has started may go without
+	// writing before it is cancelled, 0 for no limit. Before the first
+	// write, such as while a long prompt is evaluated, only Stream applies.
+	Idle    time.Duration
+	Streams []string                 // streaming routes
+	Routes  map[string]time.Duration // deadlines for particular routes, overriding Default or Stream
+}
+
+// DefaultConfig bounds JSON endpoints at 30 seconds and leaves generations,
+// exports and live streams to run while they make progress
+func DefaultConfig() Config {
+	return Config{
+		Default: 30 * time.Second,
+		Idle:    2 * time.Minute,
+		Streams: []string{
+			"/chat", "/chat/batch", "/v1/chat/completions", "/v1/embeddings", "/v1/audio/transcriptions",
+			"/metrics/stream", "/replay", "/replay/", "/export/",
+		},
+	}
+}
+
+// Metrics holds the collectors the limiter reports to
+type Metrics struct {
+	Timeouts *prometheus.CounterVec // labels: route, reason (deadline|idle)
+}
+
+// Limiter applies a Config to requests
+type Limiter struct {
+	config  Config
+	metrics Metrics
+}
+
+// New creates a limiter enforcing config
+func New(config Config, metrics Metrics) *Limiter {
+	return &Limiter{config: config, metrics: metrics}
+}
+
+// Route returns the configured route path matches, or DefaultRoute, and
+// whether it streams
+func (l *Limiter) Route(path string) (route string, stream bool) {
+	route = DefaultRoute
+	for _, p := range l.config.Streams {
+		if matches(path, p) && (route == DefaultRoute || len(p) > len(route)) {
+			route, stream = p, true
+		}
+	}
+	for p := range l.config.Routes {
+		if matches(path, p) && (route == DefaultRoute || len(p) > len(route)) {
+			route = p
+		}
+	}
+	return route, stream
+}
+
+// Timeout returns the deadline for requests to path
+func (l *Limiter) Timeout(path string) time.Duration {
+	route, stream := l.Route(path)
+	if d, ok := l.config.Routes[route]; ok {
+		return d
+	}
+	if stream {
+		return l.config.Stream
+	}
+	return l.config.Default
+}
+
+func matches(path, pattern string) bool {
+	return path == pattern || (strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern))
+}
+
+// Middleware runs each request under its route's deadline. A handler that
+// gives up at the deadline without responding is answered with a timeout
+// error. Streaming routes are watched for idleness once they start writing.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, stream := l.Route(r.URL.Path)
+		timeout := l.Timeout(r.URL.Path)
+		rc := http.NewResponseController(w)
+
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+		if timeout > 0 {
+			var cancelDeadline context.CancelFunc
+			ctx, cancelDeadline = context.WithTimeoutCause(ctx, timeout, ErrDeadline)
+			defer cancelDeadline()
+			rc.SetWriteDeadline(time.Now().Add(timeout + writeGrace))
+		} else {
+			rc.SetWriteDeadline(time.Time{})
+		}
+
+		tw := &timeoutWriter{ResponseWriter: w}
+		if stream && l.config.Idle > 0 {
+			tw.watch = func() { l.watchIdle(tw, rc, cancel, route) }
+		}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+		tw.stop()
+
+		if errors.Is(context.Cause(ctx), ErrDeadline) {
+			l.count(route, ReasonDeadline)
+			if !tw.wrote.Load() {
+				apierror.Write(w, r, apierror.Timeout, "The request took longer than "+timeout.String())
+			}
+		}
+	})
+}
+
+// watchIdle cancels a stream, and unblocks a write stuck on a client that
+// stopped reading, once nothing has been written for the idle limit
+func (l *Limiter) watchIdle(tw *timeoutWriter, rc *http.ResponseController, cancel context.CancelCauseFunc, route string) {
+	idle := l.config.Idle
+	var check func()
+	check = func() {
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		if tw.stopped {
+			return
+		}
+		if quiet := time.Since(time.Unix(0, tw.lastWrite.Load())); quiet < idle {
+			tw.timer = time.AfterFunc(idle-quiet, check)
+			return
+		}
+		tw.stopped = true
+		l.count(route, ReasonIdle)
+		cancel(ErrIdle)
+		rc.SetWriteDeadline(time.Now())
+	}
+	tw.timer = time.AfterFunc(idle, check)
+}
+
+func (l *Limiter) count(route, reason string) {
+	if l.metrics.Timeouts != nil {
+		l.metrics.Timeouts.WithLabelValues(route, reason).Inc()
+	}
+}
+
+// timeoutWriter records whether and when the handler last wrote
+type timeoutWriter struct {
+	http.ResponseWriter
+	wrote     atomic.Bool
+	lastWrite atomic.Int64 // unix nanoseconds
+	watch     func()       // starts the idle watchdog on the first write
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.touch()
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.touch()
+	n, err := tw.ResponseWriter.Write(p)
+	// A write blocked on a slow client counts as idle until it completes
+	tw.lastWrite.Store(time.Now().UnixNano())
+	return n, err
+}
+
+// Flush passes flushes through so streaming handlers work behind the limiter
+func (tw *timeoutWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+func (tw *timeoutWriter) touch() {
+	if tw.wrote.Swap(true) {
+		return
+	}
+	tw.lastWrite.Store(time.Now().UnixNano())
+	if tw.watch != nil {
+		tw.mu.Lock()
+		if !tw.stopped {
+			tw.watch()
+		}
+		tw.mu.Unlock()
+	}
+}
+
+// stop ends the idle watchdog once the handler returns
+func (tw *timeoutWriter) stop() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.stopped = true
+	if tw.timer != nil {
+		tw.timer.Stop()
+	}
+}
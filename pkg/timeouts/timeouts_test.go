@@ -0,0 +1,128 @@
+package timeouts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTimeout(t *testing.T) {
+	l := New(Config{
+		Default: 30 * time.Second,
+		Stream:  time.Hour,
+		Streams: []string{"/chat", "/export/"},
+		Routes:  map[string]time.Duration{"/reports/": time.Minute, "/export/requests": 0},
+	}, Metrics{})
+	tests := []struct {
+		path    string
+		route   string
+		stream  bool
+		timeout time.Duration
+	}{
+		{path: "/models", route: DefaultRoute, timeout: 30 * time.Second},
+		{path: "/chat", route: "/chat", stream: true, timeout: time.Hour},
+		{path: "/chat/batch", route: DefaultRoute, timeout: 30 * time.Second},
+		{path: "/export/finetune", route: "/export/", stream: true, timeout: time.Hour},
+		{path: "/export/requests", route: "/export/requests", stream: true, timeout: 0},
+		{path: "/reports/daily", route: "/reports/", timeout: time.Minute},
+	}
+	for _, tt := range tests {
+		route, stream := l.Route(tt.path)
+		if route != tt.route || stream != tt.stream {
+			t.Errorf("%s: route %q, stream %v; want %q, %v", tt.path, route, stream, tt.route, tt.stream)
+		}
+		if got := l.Timeout(tt.path); got != tt.timeout {
+			t.Errorf("%s: timeout %v, want %v", tt.path, got, tt.timeout)
+		}
+	}
+}
+
+func TestMiddlewareDeadline(t *testing.T) {
+	timeouts := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "timeouts"}, []string{"route", "reason"})
+	l := New(Config{Default: 20 * time.Millisecond}, Metrics{Timeouts: timeouts})
+	var cause error
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		cause = context.Cause(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/models", nil))
+	var body struct {
+		Error struct {
+			Code      string `json:"code"`
+			Retryable bool   `json:"retryable"`
+		} `json:"error"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusServiceUnavailable || body.Error.Code != "timeout" || !body.Error.Retryable {
+		t.Errorf("got %d %s, want a retryable timeout error", rec.Code, rec.Body)
+	}
+	if !errors.Is(cause, ErrDeadline) {
+		t.Errorf("handler saw cause %v, want ErrDeadline", cause)
+	}
+	if got := testutil.ToFloat64(timeouts.WithLabelValues(DefaultRoute, ReasonDeadline)); got != 1 {
+		t.Errorf("deadline timeouts = %v, want 1", got)
+	}
+}
+
+func TestMiddlewareKeepsHandlerResponse(t *testing.T) {
+	l := New(Config{Default: 20 * time.Millisecond}, Metrics{})
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		http.Error(w, "gave up", http.StatusGatewayTimeout)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/models", nil))
+	if rec.Code != http.StatusGatewayTimeout || rec.Body.String() != "gave up\n" {
+		t.Errorf("got %d %q, want the handler's own response", rec.Code, rec.Body)
+	}
+}
+
+func TestMiddlewareStreams(t *testing.T) {
+	timeouts := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "timeouts"}, []string{"route", "reason"})
+	l := New(Config{Default: 10 * time.Millisecond, Idle: 50 * time.Millisecond, Streams: []string{"/chat"}}, Metrics{Timeouts: timeouts})
+
+	// A slow first token and steady output outlive the default deadline
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+		for i := 0; i < 5; i++ {
+			w.Write([]byte("token "))
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err := r.Context().Err(); err != nil {
+			t.Errorf("active stream cancelled: %v", err)
+		}
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/chat", nil))
+	if rec.Body.String() != "token token token token token " {
+		t.Errorf("got %q", rec.Body)
+	}
+
+	// A stream that stalls is cancelled
+	var cause error
+	h = l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("token "))
+		select {
+		case <-r.Context().Done():
+			cause = context.Cause(r.Context())
+		case <-time.After(time.Second):
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/chat", nil))
+	if !errors.Is(cause, ErrIdle) {
+		t.Errorf("stalled stream cause %v, want ErrIdle", cause)
+	}
+	if got := testutil.ToFloat64(timeouts.WithLabelValues("/chat", ReasonIdle)); got != 1 {
+		t.Errorf("idle timeouts = %v, want 1", got)
+	}
+}
@@ -0,0 +1,104 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadInterval bounds how often the certificate files are checked for changes
+const reloadInterval = 30 * time.Second
+
+// Config locates the certificate and key for a server and, optionally, the
+// CA that client certificates must chain to
+type Config struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string // enables mutual TLS when set
+}
+
+// Enabled reports whether TLS is configured
+func (c Config) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// Load builds a server TLS config. Certificates are reloaded when their files
+// change, so renewals (for example by certbot) apply without a restart.
+// HTTP/2 is negotiated automatically by net/http over TLS.
+func Load(cfg Config) (*tls.Config, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("both a certificate and a key file are required")
+	}
+
+	reloader := &certReloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// certReloader serves a certificate, reloading it when the files change
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checkedAt) >= reloadInterval {
+		c.checkedAt = time.Now()
+		if info, err := os.Stat(c.certFile); err == nil && info.ModTime().After(c.modTime) {
+			// Keep serving the old certificate if the new one is unreadable,
+			// e.g. because the key hasn't been written yet
+			if cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile); err == nil {
+				c.cert, c.modTime = &cert, info.ModTime()
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// reload loads the certificate unconditionally
+func (c *certReloader) reload() error {
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert, c.modTime, c.checkedAt = &cert, info.ModTime(), time.Now()
+	return nil
+}
@@ -0,0 +1,32 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	otelTrace "go.opentelemetry.io/otel/trace"
+)
+
+// ExemplarLabels returns the exemplar labels linking an observation made
+// under ctx to its trace, or nil if ctx carries no sampled span, such as
+// when tracing is disabled
+func ExemplarLabels(ctx context.Context) prometheus.Labels {
+	sc := otelTrace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsSampled() {
+		return nil
+	}
+	return prometheus.Labels{"trace_id": sc.TraceID().String()}
+}
+
+// Observe records value on o, with the trace of ctx attached as an exemplar
+// so a dashboard can jump from a latency spike to a trace behind it.
+// Exemplars are only exposed to scrapers that ask for OpenMetrics.
+func Observe(ctx context.Context, o prometheus.Observer, value float64) {
+	if labels := ExemplarLabels(ctx); labels != nil {
+		if eo, ok := o.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(value, labels)
+			return
+		}
+	}
+	o.Observe(value)
+}
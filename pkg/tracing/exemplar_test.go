@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+func observed(t *testing.T, h prometheus.Histogram) *dto.Histogram {
+	t.Helper()
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram()
+}
+
+func exemplars(h *dto.Histogram) []*dto.Exemplar {
+	var found []*dto.Exemplar
+	for _, b := range h.GetBucket() {
+		if b.GetExemplar() != nil {
+			found = append(found, b.GetExemplar())
+		}
+	}
+	return found
+}
+
+func TestObserveWithoutTrace(t *testing.T) {
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency", Buckets: []float64{1, 5}})
+	Observe(context.Background(), h, 0.5)
+
+	got := observed(t, h)
+	if got.GetSampleCount() != 1 || len(exemplars(got)) != 0 {
+		t.Errorf("got %d samples with exemplars %v, want one without", got.GetSampleCount(), exemplars(got))
+	}
+}
+
+func TestObserveWithTrace(t *testing.T) {
+	provider := trace.NewTracerProvider(trace.WithSampler(trace.AlwaysSample()))
+	defer provider.Shutdown(context.Background())
+	ctx, span := provider.Tracer("test").Start(context.Background(), "request")
+	defer span.End()
+
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency", Buckets: []float64{1, 5}})
+	Observe(ctx, h, 2)
+
+	found := exemplars(observed(t, h))
+	if len(found) != 1 {
+		t.Fatalf("got %d exemplars, want 1", len(found))
+	}
+	labels := found[0].GetLabel()
+	if len(labels) != 1 || labels[0].GetName() != "trace_id" || labels[0].GetValue() != span.SpanContext().TraceID().String() {
+		t.Errorf("exemplar labels %v, want the span's trace_id", labels)
+	}
+	if found[0].GetValue() != 2 {
+		t.Errorf("exemplar value %v, want 2", found[0].GetValue())
+	}
+}
+
+func TestObserveUnsampled(t *testing.T) {
+	provider := trace.NewTracerProvider(trace.WithSampler(trace.NeverSample()))
+	defer provider.Shutdown(context.Background())
+	ctx, span := provider.Tracer("test").Start(context.Background(), "request")
+	defer span.End()
+
+	if labels := ExemplarLabels(ctx); labels != nil {
+		t.Errorf("unsampled span gave exemplar labels %v", labels)
+	}
+}
@@ -0,0 +1,337 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+	otelTrace "go.opentelemetry.io/otel/trace"
+)
+
+// Sampling strategies
+const (
+	SampleAlways    = "always"     // every trace
+	SampleNever     = "never"      // no traces
+	SampleRatio     = "ratio"      // a fixed fraction of traces
+	SampleRateLimit = "rate_limit" // at most PerSecond traces a second
+	// SampleTail records every trace but only exports those that failed or
+	// were slow, once they have ended, plus a fraction of the rest
+	SampleTail = "tail"
+)
+
+// SamplerConfig chooses which traces are exported. Spans whose parent was
+// already sampled or dropped follow their parent, so traces stay whole.
+type SamplerConfig struct {
+	Strategy string
+	// Ratio is the fraction of traces sampled with SampleRatio, and the
+	// fraction kept regardless of outcome with SampleTail
+	Ratio float64
+	// PerSecond is the most traces started a second with SampleRateLimit
+	PerSecond float64
+	// Latency keeps traces whose root span took at least this long with
+	// SampleTail, 0 for none
+	Latency time.Duration
+	// Errors keeps traces with a failed span with SampleTail
+	Errors bool
+}
+
+// DefaultSamplerConfig samples every trace
+func DefaultSamplerConfig() SamplerConfig {
+	return SamplerConfig{Strategy: SampleAlways}
+}
+
+// Validate reports whether the config can be used
+func (c SamplerConfig) Validate() error {
+	switch c.Strategy {
+	case SampleAlways, SampleNever:
+	case SampleRatio:
+		if c.Ratio < 0 || c.Ratio > 1 || math.IsNaN(c.Ratio) {
+			return fmt.Errorf("ratio must be between 0 and 1, got %v", c.Ratio)
+		}
+	case SampleRateLimit:
+		if c.PerSecond <= 0 || math.IsInf(c.PerSecond, 0) {
+			return fmt.Errorf("per_second must be positive, got %v", c.PerSecond)
+		}
+	case SampleTail:
+		if c.Ratio < 0 || c.Ratio > 1 || math.IsNaN(c.Ratio) {
+			return fmt.Errorf("ratio must be between 0 and 1, got %v", c.Ratio)
+		}
+		if c.Latency < 0 {
+			return fmt.Errorf("latency must not be negative, got %v", c.Latency)
+		}
+		if c.Latency == 0 && !c.Errors && c.Ratio == 0 {
+			return fmt.Errorf("tail sampling needs a latency, errors or a ratio to keep any traces")
+		}
+	default:
+		return fmt.Errorf("unknown sampling strategy %q, want always, never, ratio, rate_limit or tail", c.Strategy)
+	}
+	return nil
+}
+
+type samplerConfigJSON struct {
+	Strategy  string  `json:"strategy"`
+	Ratio     float64 `json:"ratio,omitempty"`
+	PerSecond float64 `json:"per_second,omitempty"`
+	Latency   string  `json:"latency,omitempty"`
+	Errors    bool    `json:"errors,omitempty"`
+}
+
+// MarshalJSON writes the latency as a duration string such as "2s"
+func (c SamplerConfig) MarshalJSON() ([]byte, error) {
+	v := samplerConfigJSON{Strategy: c.Strategy, Ratio: c.Ratio, PerSecond: c.PerSecond, Errors: c.Errors}
+	if c.Latency > 0 {
+		v.Latency = c.Latency.String()
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON reads the form MarshalJSON writes
+func (c *SamplerConfig) UnmarshalJSON(data []byte) error {
+	var v samplerConfigJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*c = SamplerConfig{Strategy: v.Strategy, Ratio: v.Ratio, PerSecond: v.PerSecond, Errors: v.Errors}
+	if v.Latency != "" {
+		latency, err := time.ParseDuration(v.Latency)
+		if err != nil {
+			return fmt.Errorf("invalid latency %q: %w", v.Latency, err)
+		}
+		c.Latency = latency
+	}
+	return nil
+}
+
+// Sampler is a trace sampler whose config can be changed while it runs
+type Sampler struct {
+	state atomic.Pointer[samplerState]
+}
+
+type samplerState struct {
+	config  SamplerConfig
+	ratio   trace.Sampler
+	limiter *rateLimiter
+}
+
+// NewSampler creates a sampler using config
+func NewSampler(config SamplerConfig) (*Sampler, error) {
+	s := &Sampler{}
+	if err := s.Set(config); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set replaces the sampler's config, for traces started from now on
+func (s *Sampler) Set(config SamplerConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	state := &samplerState{config: config, ratio: trace.TraceIDRatioBased(config.Ratio)}
+	if config.Strategy == SampleRateLimit {
+		state.limiter = newRateLimiter(config.PerSecond)
+	}
+	s.state.Store(state)
+	return nil
+}
+
+// Config returns the sampler's current config
+func (s *Sampler) Config() SamplerConfig {
+	return s.state.Load().config
+}
+
+// ShouldSample decides whether a span is recorded and exported
+func (s *Sampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	state := s.state.Load()
+	parent := otelTrace.SpanContextFromContext(p.ParentContext)
+	decision := trace.Drop
+	switch {
+	case parent.IsValid() && parent.IsSampled():
+		decision = trace.RecordAndSample
+	case parent.IsValid() && (parent.IsRemote() || state.config.Strategy != SampleTail):
+		decision = trace.Drop
+	case parent.IsValid():
+		// Part of a trace tail sampling will decide on once it ends
+		decision = trace.RecordOnly
+	case state.config.Strategy == SampleAlways:
+		decision = trace.RecordAndSample
+	case state.config.Strategy == SampleRatio:
+		decision = state.ratio.ShouldSample(p).Decision
+	case state.config.Strategy == SampleRateLimit:
+		if state.limiter.allow(time.Now()) {
+			decision = trace.RecordAndSample
+		}
+	case state.config.Strategy == SampleTail:
+		decision = trace.RecordOnly
+		if state.config.Ratio > 0 && state.ratio.ShouldSample(p).Decision == trace.RecordAndSample {
+			decision = trace.RecordAndSample
+		}
+	}
+	return trace.SamplingResult{Decision: decision, Tracestate: parent.TraceState()}
+}
+
+// Description names the sampler and its config
+func (s *Sampler) Description() string {
+	c := s.Config()
+	return fmt.Sprintf("aiwatch{strategy=%s,ratio=%v,per_second=%v,latency=%v,errors=%v}", c.Strategy, c.Ratio, c.PerSecond, c.Latency, c.Errors)
+}
+
+// rateLimiter is a token bucket refilled at perSecond, holding up to a
+// second's worth
+type rateLimiter struct {
+	mu        sync.Mutex
+	perSecond float64
+	tokens    float64
+	last      time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	return &rateLimiter{perSecond: perSecond, tokens: math.Max(perSecond, 1)}
+}
+
+func (l *rateLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(math.Max(l.perSecond, 1), l.tokens+now.Sub(l.last).Seconds()*l.perSecond)
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// Bounds on the traces a tailProcessor holds while they run
+const (
+	tailMaxTraces = 10000
+	tailMaxSpans  = 512 // per trace
+	tailMaxAge    = 10 * time.Minute
+)
+
+// tailProcessor holds the spans of traces the sampler left undecided until
+// their root span ends, then passes the traces tail sampling keeps on to
+// next. Sampled spans go straight through.
+type tailProcessor struct {
+	next    trace.SpanProcessor
+	sampler *Sampler
+
+	mu      sync.Mutex
+	pending map[otelTrace.TraceID]*pendingTrace
+}
+
+type pendingTrace struct {
+	started time.Time
+	spans   []trace.ReadOnlySpan
+	failed  bool
+}
+
+func newTailProcessor(next trace.SpanProcessor, sampler *Sampler) *tailProcessor {
+	return &tailProcessor{next: next, sampler: sampler, pending: make(map[otelTrace.TraceID]*pendingTrace)}
+}
+
+func (p *tailProcessor) OnStart(ctx context.Context, s trace.ReadWriteSpan) {
+	p.next.OnStart(ctx, s)
+}
+
+func (p *tailProcessor) OnEnd(s trace.ReadOnlySpan) {
+	if s.SpanContext().IsSampled() {
+		p.next.OnEnd(s)
+		return
+	}
+	if spans := p.hold(s); spans != nil {
+		for _, span := range spans {
+			p.next.OnEnd(sampledSpan{span})
+		}
+	}
+}
+
+// hold adds s to its trace, and returns the trace's spans if s ends it and
+// the trace is to be kept
+func (p *tailProcessor) hold(s trace.ReadOnlySpan) []trace.ReadOnlySpan {
+	id := s.SpanContext().TraceID()
+	root := !s.Parent().IsValid() || s.Parent().IsRemote()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.pending[id]
+	if t == nil {
+		if len(p.pending) >= tailMaxTraces {
+			p.evict(time.Now())
+		}
+		if len(p.pending) >= tailMaxTraces {
+			return nil
+		}
+		t = &pendingTrace{started: time.Now()}
+		p.pending[id] = t
+	}
+	if len(t.spans) < tailMaxSpans {
+		t.spans = append(t.spans, s)
+	}
+	if s.Status().Code == codes.Error {
+		t.failed = true
+	}
+	if !root {
+		return nil
+	}
+
+	delete(p.pending, id)
+	config := p.sampler.Config()
+	if config.Strategy != SampleTail {
+		return nil
+	}
+	slow := config.Latency > 0 && s.EndTime().Sub(s.StartTime()) >= config.Latency
+	if slow || (config.Errors && t.failed) {
+		return t.spans
+	}
+	return nil
+}
+
+// evict drops traces whose root never ended
+func (p *tailProcessor) evict(now time.Time) {
+	for id, t := range p.pending {
+		if now.Sub(t.started) > tailMaxAge {
+			delete(p.pending, id)
+		}
+	}
+}
+
+func (p *tailProcessor) Shutdown(ctx context.Context) error {
+	return p.next.Shutdown(ctx)
+}
+
+func (p *tailProcessor) ForceFlush(ctx context.Context) error {
+	return p.next.ForceFlush(ctx)
+}
+
+// sampledSpan marks a span tail sampling kept as sampled, which exporters
+// require
+type sampledSpan struct {
+	trace.ReadOnlySpan
+}
+
+func (s sampledSpan) SpanContext() otelTrace.SpanContext {
+	sc := s.ReadOnlySpan.SpanContext()
+	return sc.WithTraceFlags(sc.TraceFlags().WithSampled(true))
+}
+
+// sampling is the sampler SetupTracing installs
+var sampling, _ = NewSampler(DefaultSamplerConfig())
+
+// Sampling returns the current sampling config
+func Sampling() SamplerConfig {
+	return sampling.Config()
+}
+
+// SetSampling changes which traces are sampled from now on. It may be called
+// before or after SetupTracing.
+func SetSampling(config SamplerConfig) error {
+	return sampling.Set(config)
+}
@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	otelTrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestSamplerConfigValidate(t *testing.T) {
+	valid := []SamplerConfig{
+		{Strategy: SampleAlways},
+		{Strategy: SampleNever},
+		{Strategy: SampleRatio, Ratio: 0.1},
+		{Strategy: SampleRateLimit, PerSecond: 5},
+		{Strategy: SampleTail, Errors: true},
+		{Strategy: SampleTail, Latency: time.Second, Ratio: 0.01},
+	}
+	for _, c := range valid {
+		if err := c.Validate(); err != nil {
+			t.Errorf("%+v: %v", c, err)
+		}
+	}
+	invalid := []SamplerConfig{
+		{},
+		{Strategy: "sometimes"},
+		{Strategy: SampleRatio, Ratio: 1.5},
+		{Strategy: SampleRateLimit},
+		{Strategy: SampleTail},
+		{Strategy: SampleTail, Latency: -time.Second},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v accepted", c)
+		}
+	}
+}
+
+func TestSamplerConfigJSON(t *testing.T) {
+	var c SamplerConfig
+	if err := json.Unmarshal([]byte(`{"strategy":"tail","latency":"2.5s","errors":true,"ratio":0.01}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	want := SamplerConfig{Strategy: SampleTail, Latency: 2500 * time.Millisecond, Errors: true, Ratio: 0.01}
+	if c != want {
+		t.Fatalf("decoded %+v, want %+v", c, want)
+	}
+	data, _ := json.Marshal(c)
+	if string(data) != `{"strategy":"tail","ratio":0.01,"latency":"2.5s","errors":true}` {
+		t.Errorf("encoded %s", data)
+	}
+}
+
+func decide(s *Sampler, parent otelTrace.SpanContext) trace.SamplingDecision {
+	ctx := otelTrace.ContextWithSpanContext(context.Background(), parent)
+	return s.ShouldSample(trace.SamplingParameters{ParentContext: ctx, TraceID: otelTrace.TraceID{1}}).Decision
+}
+
+func TestSampler(t *testing.T) {
+	s, _ := NewSampler(SamplerConfig{Strategy: SampleNever})
+	if got := decide(s, otelTrace.SpanContext{}); got != trace.Drop {
+		t.Errorf("never: %v", got)
+	}
+
+	// Children follow their parent whatever the strategy
+	sampled := otelTrace.NewSpanContext(otelTrace.SpanContextConfig{TraceID: otelTrace.TraceID{1}, SpanID: otelTrace.SpanID{1}, TraceFlags: otelTrace.FlagsSampled})
+	if got := decide(s, sampled); got != trace.RecordAndSample {
+		t.Errorf("child of a sampled span: %v", got)
+	}
+
+	s.Set(SamplerConfig{Strategy: SampleRateLimit, PerSecond: 2})
+	kept := 0
+	for i := 0; i < 10; i++ {
+		if decide(s, otelTrace.SpanContext{}) == trace.RecordAndSample {
+			kept++
+		}
+	}
+	if kept != 2 {
+		t.Errorf("rate limit kept %d of a burst of 10, want 2", kept)
+	}
+
+	s.Set(SamplerConfig{Strategy: SampleTail, Errors: true})
+	unsampled := otelTrace.NewSpanContext(otelTrace.SpanContextConfig{TraceID: otelTrace.TraceID{1}, SpanID: otelTrace.SpanID{1}})
+	if got := decide(s, otelTrace.SpanContext{}); got != trace.RecordOnly {
+		t.Errorf("tail root: %v, want RecordOnly", got)
+	}
+	if got := decide(s, unsampled); got != trace.RecordOnly {
+		t.Errorf("tail child: %v, want RecordOnly", got)
+	}
+	if got := decide(s, unsampled.WithRemote(true)); got != trace.Drop {
+		t.Errorf("tail child of an unsampled remote parent: %v, want Drop", got)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(0.5)
+	now := time.Now()
+	if !l.allow(now) || l.allow(now) {
+		t.Fatal("want one trace at once")
+	}
+	if l.allow(now.Add(time.Second)) || !l.allow(now.Add(2*time.Second)) {
+		t.Error("want the next trace two seconds later")
+	}
+}
+
+func TestTailProcessor(t *testing.T) {
+	sampler, _ := NewSampler(SamplerConfig{Strategy: SampleTail, Latency: time.Second, Errors: true})
+	recorder := tracetest.NewSpanRecorder()
+	provider := trace.NewTracerProvider(trace.WithSampler(sampler), trace.WithSpanProcessor(newTailProcessor(recorder, sampler)))
+	defer provider.Shutdown(context.Background())
+	tracer := provider.Tracer("test")
+
+	request := func(took time.Duration, fail bool) {
+		start := time.Now()
+		ctx, root := tracer.Start(context.Background(), "http_request", otelTrace.WithTimestamp(start))
+		_, child := tracer.Start(ctx, "chat_generation", otelTrace.WithTimestamp(start))
+		if fail {
+			child.SetStatus(codes.Error, "upstream failed")
+		}
+		child.End(otelTrace.WithTimestamp(start.Add(took)))
+		root.End(otelTrace.WithTimestamp(start.Add(took)))
+	}
+	request(100*time.Millisecond, false)
+	request(3*time.Second, false)
+	request(100*time.Millisecond, true)
+
+	ended := recorder.Ended()
+	if len(ended) != 4 {
+		t.Fatalf("exported %d spans, want the 2 of the slow trace and 2 of the failed one", len(ended))
+	}
+	for _, s := range ended {
+		if !s.SpanContext().IsSampled() {
+			t.Errorf("%s exported unsampled", s.Name())
+		}
+	}
+	if ended[0].SpanContext().TraceID() == ended[2].SpanContext().TraceID() {
+		t.Error("want two separate traces")
+	}
+}
@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	otelTrace "go.opentelemetry.io/otel/trace"
+)
+
+// Stages of the chat pipeline, in the order a request passes through them
+const (
+	StageGuardrails  = "guardrails"
+	StageHistory     = "history"
+	StageRetrieval   = "retrieval"
+	StageCompression = "compression"
+	StageTruncation  = "truncation"
+	StageGeneration  = "generation"
+	StagePostprocess = "postprocess"
+)
+
+// Attributes every stage span carries, so one query finds a stage, or the
+// requests a stage turned away, across all traces
+const (
+	StageKey        = attribute.Key("aiwatch.stage")
+	StageOutcomeKey = attribute.Key("aiwatch.stage.outcome")
+)
+
+// Outcomes shared by the stages; stages may report their own, such as a
+// cache "hit"
+const (
+	OutcomeOK      = "ok"
+	OutcomeSkipped = "skipped"
+	OutcomeBlocked = "blocked"
+	OutcomeError   = "error"
+)
+
+// StageSpanName is the name of the span for a stage, such as chat_retrieval
+func StageSpanName(stage string) string {
+	return "chat_" + stage
+}
+
+// Stage is the span of one stage of the chat pipeline
+type Stage struct {
+	span  otelTrace.Span
+	ended bool
+}
+
+// StartStage starts the span for a stage as a child of the span in ctx.
+// Work done with the returned context, such as a retrieval's own spans,
+// nests under it.
+func StartStage(ctx context.Context, stage string, attrs ...attribute.KeyValue) (context.Context, *Stage) {
+	ctx, span := StartChildSpan(ctx, StageSpanName(stage))
+	span.SetAttributes(append([]attribute.KeyValue{StageKey.String(stage)}, attrs...)...)
+	return ctx, &Stage{span: span}
+}
+
+// End records the stage's outcome and ends its span; a non-nil err marks
+// the stage failed. Only the first call has any effect, so End can also be
+// deferred to cover early returns.
+func (s *Stage) End(outcome string, err error) {
+	if s.ended {
+		return
+	}
+	s.ended = true
+	s.span.SetAttributes(StageOutcomeKey.String(outcome))
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
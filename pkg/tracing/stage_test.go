@@ -0,0 +1,32 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestStage(t *testing.T) {
+	recorder := recordSpans(t)
+	ctx, request := StartSpan(context.Background(), "http_request")
+	stageCtx, stage := StartStage(ctx, StageRetrieval, attribute.Int("rag.top_k", 4))
+	_, search := StartChildSpan(stageCtx, "rag_search")
+	search.End()
+	stage.End(OutcomeSkipped, nil)
+	stage.End(OutcomeOK, nil)
+	request.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 || spans[1].Name() != "chat_retrieval" {
+		t.Fatalf("got %d spans, want rag_search inside chat_retrieval", len(spans))
+	}
+	retrieval := spans[1]
+	if spans[0].Parent().SpanID() != retrieval.SpanContext().SpanID() || retrieval.Parent().SpanID() != spans[2].SpanContext().SpanID() {
+		t.Error("want the stage between the request and the work done in it")
+	}
+	attrs := retrieval.Attributes()
+	if attr(attrs, string(StageKey)).AsString() != StageRetrieval || attr(attrs, string(StageOutcomeKey)).AsString() != OutcomeSkipped || attr(attrs, "rag.top_k").AsInt64() != 4 {
+		t.Errorf("stage attributes %v", attrs)
+	}
+}
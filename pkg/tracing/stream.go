@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	otelTrace "go.opentelemetry.io/otel/trace"
+)
+
+// StreamConfig sets how much of a streamed generation its span records
+type StreamConfig struct {
+	// EveryTokens is how many tokens each "tokens" event covers; 0 records
+	// no events per batch
+	EveryTokens int
+	// Stall is the gap between tokens that is recorded as a "stall" event,
+	// 0 for none
+	Stall time.Duration
+}
+
+// DefaultStreamConfig records an event every 50 tokens and any pause of 2
+// seconds or more, a few dozen events for a long generation
+func DefaultStreamConfig() StreamConfig {
+	return StreamConfig{EveryTokens: 50, Stall: 2 * time.Second}
+}
+
+// Stream records a streamed generation on a span of its own, with an event
+// for the first token, each batch of tokens and each stall, so a trace
+// shows how the output was paced rather than one opaque duration
+type Stream struct {
+	span   otelTrace.Span
+	config StreamConfig
+
+	start       time.Time
+	batchStart  time.Time
+	last        time.Time // when the latest token arrived
+	tokens      int
+	batchTokens int
+	ended       bool
+}
+
+// StartStream starts the span for a generation, the StageGeneration stage
+// of the request whose span is in ctx
+func StartStream(ctx context.Context, config StreamConfig, attrs ...attribute.KeyValue) (context.Context, *Stream) {
+	ctx, span := StartChildSpan(ctx, StageSpanName(StageGeneration))
+	span.SetAttributes(append([]attribute.KeyValue{StageKey.String(StageGeneration)}, attrs...)...)
+	now := time.Now()
+	return ctx, &Stream{span: span, config: config, start: now, batchStart: now, last: now}
+}
+
+// Tokens records n tokens arriving now
+func (s *Stream) Tokens(n int) {
+	s.tokensAt(time.Now(), n)
+}
+
+func (s *Stream) tokensAt(now time.Time, n int) {
+	if n <= 0 || !s.span.IsRecording() {
+		return
+	}
+	if s.tokens == 0 {
+		s.span.AddEvent("first_token", otelTrace.WithTimestamp(now), otelTrace.WithAttributes(
+			attribute.Float64("time_to_first_token_ms", ms(now.Sub(s.start))),
+		))
+		s.batchStart = now
+	} else if gap := now.Sub(s.last); s.config.Stall > 0 && gap >= s.config.Stall {
+		s.span.AddEvent("stall", otelTrace.WithTimestamp(now), otelTrace.WithAttributes(
+			attribute.Float64("gap_ms", ms(gap)),
+			attribute.Int("tokens.cumulative", s.tokens),
+		))
+	}
+	s.tokens += n
+	s.batchTokens += n
+	s.last = now
+	if s.config.EveryTokens > 0 && s.batchTokens >= s.config.EveryTokens {
+		s.batch(now)
+	}
+}
+
+// batch records the tokens since the previous batch
+func (s *Stream) batch(now time.Time) {
+	attrs := []attribute.KeyValue{
+		attribute.Int("tokens.cumulative", s.tokens),
+		attribute.Int("tokens.batch", s.batchTokens),
+		attribute.Float64("batch.duration_ms", ms(now.Sub(s.batchStart))),
+	}
+	if elapsed := now.Sub(s.batchStart).Seconds(); elapsed > 0 {
+		attrs = append(attrs, attribute.Float64("tokens_per_second", float64(s.batchTokens)/elapsed))
+	}
+	s.span.AddEvent("tokens", otelTrace.WithTimestamp(now), otelTrace.WithAttributes(attrs...))
+	s.batchStart = now
+	s.batchTokens = 0
+}
+
+// End records the last partial batch and the outcome, and ends the span.
+// The finish reason is the stage's outcome, unless a non-nil err marks the
+// generation failed. Only the first call has any
+// effect, so End can also be deferred to cover early returns.
+func (s *Stream) End(finishReason string, err error) {
+	if s.ended {
+		return
+	}
+	s.ended = true
+	if s.span.IsRecording() {
+		if s.batchTokens > 0 && s.config.EveryTokens > 0 {
+			s.batch(s.last)
+		}
+		outcome := finishReason
+		if err != nil {
+			outcome = OutcomeError
+		}
+		s.span.SetAttributes(
+			attribute.Int("tokens.output", s.tokens),
+			attribute.String("generation.finish_reason", finishReason),
+			StageOutcomeKey.String(outcome),
+		)
+		if err != nil {
+			s.span.RecordError(err)
+			s.span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	s.span.End()
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
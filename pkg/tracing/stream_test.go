@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := trace.NewTracerProvider(trace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return recorder
+}
+
+func attr(attrs []attribute.KeyValue, key string) attribute.Value {
+	for _, a := range attrs {
+		if string(a.Key) == key {
+			return a.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestStream(t *testing.T) {
+	recorder := recordSpans(t)
+	_, s := StartStream(context.Background(), StreamConfig{EveryTokens: 10, Stall: time.Second},
+		attribute.String("model.name", "ai/llama3.2"))
+
+	// 25 tokens at 100 a second, with a three second stall after the 12th
+	now := s.start.Add(500 * time.Millisecond)
+	for i := 1; i <= 25; i++ {
+		s.tokensAt(now, 1)
+		now = now.Add(10 * time.Millisecond)
+		if i == 12 {
+			now = now.Add(3 * time.Second)
+		}
+	}
+	s.End("stop", nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "chat_generation" {
+		t.Fatalf("got %d spans, want chat_generation", len(spans))
+	}
+	span := spans[0]
+	var names []string
+	for _, e := range span.Events() {
+		names = append(names, e.Name)
+	}
+	want := []string{"first_token", "tokens", "stall", "tokens", "tokens"}
+	if len(names) != len(want) {
+		t.Fatalf("events %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("events %v, want %v", names, want)
+		}
+	}
+
+	events := span.Events()
+	if got := attr(events[0].Attributes, "time_to_first_token_ms").AsFloat64(); got != 500 {
+		t.Errorf("time to first token %vms, want 500", got)
+	}
+	if got := attr(events[2].Attributes, "gap_ms").AsFloat64(); got != 3010 {
+		t.Errorf("stall gap %vms, want 3010", got)
+	}
+	if got := attr(events[3].Attributes, "tokens.cumulative").AsInt64(); got != 20 {
+		t.Errorf("second batch at %d tokens, want 20", got)
+	}
+	if got := attr(events[3].Attributes, "batch.duration_ms").AsFloat64(); got != 3100 {
+		t.Errorf("stalled batch took %vms, want 3100", got)
+	}
+	last := events[4].Attributes
+	if attr(last, "tokens.cumulative").AsInt64() != 25 || attr(last, "tokens.batch").AsInt64() != 5 {
+		t.Errorf("last batch %v, want the 5 tokens up to 25", last)
+	}
+	if got := attr(span.Attributes(), "tokens.output").AsInt64(); got != 25 {
+		t.Errorf("tokens.output = %d, want 25", got)
+	}
+	if got := attr(span.Attributes(), "model.name").AsString(); got != "ai/llama3.2" {
+		t.Errorf("model.name = %q", got)
+	}
+	if stage, outcome := attr(span.Attributes(), string(StageKey)).AsString(), attr(span.Attributes(), string(StageOutcomeKey)).AsString(); stage != StageGeneration || outcome != "stop" {
+		t.Errorf("stage %q with outcome %q, want generation, stop", stage, outcome)
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	recorder := recordSpans(t)
+	_, s := StartStream(context.Background(), DefaultStreamConfig())
+	s.End("", errors.New("upstream reset"))
+	s.End("client_closed", nil)
+
+	span := recorder.Ended()[0]
+	if span.Status().Code != codes.Error || len(span.Events()) != 1 || span.Events()[0].Name != "exception" {
+		t.Errorf("status %v, events %v; want an error with no token events", span.Status(), span.Events())
+	}
+	if got := attr(span.Attributes(), "generation.finish_reason").AsString(); got != "" {
+		t.Errorf("finish reason %q, want the first End's", got)
+	}
+	if got := attr(span.Attributes(), string(StageOutcomeKey)).AsString(); got != OutcomeError {
+		t.Errorf("outcome %q, want %q", got, OutcomeError)
+	}
+}
@@ -17,12 +17,13 @@ import (
 	otelTrace "go.opentelemetry.io/otel/trace"
 )
 
-// SetupTracing initializes OpenTelemetry tracing
-func SetupTracing(serviceName string, otlpEndpoint string) (func(), error) {
+// SetupTracing initializes OpenTelemetry tracing. attrs are added to the
+// resource describing this process, e.g. the Kubernetes pod.
+func SetupTracing(serviceName string, otlpEndpoint string, attrs ...attribute.KeyValue) (func(), error) {
 	// Create a resource with service information
 	res, err := resource.New(context.Background(),
 		resource.WithAttributes(
-			semconv.ServiceNameKey.String(serviceName),
+			append([]attribute.KeyValue{semconv.ServiceNameKey.String(serviceName)}, attrs...)...,
 		),
 	)
 	if err != nil {
@@ -43,18 +44,21 @@ func SetupTracing(serviceName string, otlpEndpoint string) (func(), error) {
 			return nil, err
 		}
 
+		// Spans pass the tail sampler on their way to the batcher, so traces
+		// held back until they end can still be exported
+		batcher := trace.NewBatchSpanProcessor(exporter,
+			trace.WithBatchTimeout(5*time.Second),
+		)
 		traceProvider = trace.NewTracerProvider(
 			trace.WithResource(res),
-			trace.WithBatcher(exporter,
-				trace.WithBatchTimeout(5*time.Second),
-			),
-			trace.WithSampler(trace.AlwaysSample()), // Sample all traces for development
+			trace.WithSpanProcessor(newTailProcessor(batcher, sampling)),
+			trace.WithSampler(sampling),
 		)
 	} else {
 		// Use a no-op exporter if no endpoint is provided
 		traceProvider = trace.NewTracerProvider(
 			trace.WithResource(res),
-			trace.WithSampler(trace.AlwaysSample()),
+			trace.WithSampler(sampling),
 		)
 	}
 
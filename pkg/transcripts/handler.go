@@ -0,0 +1,138 @@
+package transcripts
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/ajeetraina/aiwatch/pkg/archive"
+)
+
+// Default and maximum number of records one query returns
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// ErrExists is returned by a RestoreFunc when the conversation is still in
+// the live history
+var ErrExists = errors.New("conversation already exists")
+
+// RestoreFunc writes archived records of one conversation, oldest first,
+// back into the live history
+type RestoreFunc func(conversationID string, recs []archive.Record) error
+
+// Handler serves the archive. Both endpoints take conversation_id, from and
+// to (YYYY-MM-DD or RFC 3339; the last seven days by default):
+//
+//	GET  /archive/transcripts          matching records, up to limit (default 100)
+//	POST /archive/transcripts/restore  reloads one conversation into the history
+func (a *Archiver) Handler(restore RestoreFunc) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /archive/transcripts", func(w http.ResponseWriter, r *http.Request) {
+		q, ok := parseQuery(w, r)
+		if !ok {
+			return
+		}
+		q.Limit = defaultQueryLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > maxQueryLimit {
+				apierror.Write(w, r, apierror.InvalidRequest, "limit must be between 1 and 1000")
+				return
+			}
+			q.Limit = n
+		}
+
+		recs, err := a.Search(r.Context(), q)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(recs), "records": recs})
+	})
+	mux.HandleFunc("POST /archive/transcripts/restore", func(w http.ResponseWriter, r *http.Request) {
+		q, ok := parseQuery(w, r)
+		if !ok {
+			return
+		}
+		if q.ConversationID == "" {
+			apierror.Write(w, r, apierror.InvalidRequest, "conversation_id is required")
+			return
+		}
+
+		recs, err := a.Search(r.Context(), q)
+		if err != nil {
+			apierror.Write(w, r, apierror.Internal, err.Error())
+			return
+		}
+		if len(recs) == 0 {
+			apierror.Write(w, r, apierror.NotFound, "no archived records for this conversation in the range")
+			return
+		}
+		if err := restore(q.ConversationID, recs); errors.Is(err, ErrExists) {
+			apierror.Write(w, r, apierror.InvalidRequest, "conversation already exists; delete it before restoring")
+			return
+		} else if err != nil {
+			apierror.Write(w, r, apierror.Internal, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"conversation_id": q.ConversationID, "restored": len(recs)})
+	})
+	return mux
+}
+
+// parseQuery reads the shared query parameters, writing an error if they are
+// invalid
+func parseQuery(w http.ResponseWriter, r *http.Request) (Query, bool) {
+	values := r.URL.Query()
+	q := Query{ConversationID: values.Get("conversation_id"), To: time.Now().UTC()}
+	if v := values.Get("to"); v != "" {
+		to, err := parseTime(v, true)
+		if err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, "to must be a date (YYYY-MM-DD) or RFC 3339 time")
+			return q, false
+		}
+		q.To = to
+	}
+	q.From = q.To.AddDate(0, 0, -7)
+	if v := values.Get("from"); v != "" {
+		from, err := parseTime(v, false)
+		if err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, "from must be a date (YYYY-MM-DD) or RFC 3339 time")
+			return q, false
+		}
+		q.From = from
+	}
+	if q.From.After(q.To) {
+		apierror.Write(w, r, apierror.InvalidRequest, "from must not be after to")
+		return q, false
+	}
+	if q.To.Sub(q.From) > maxQueryDays*24*time.Hour {
+		apierror.Write(w, r, apierror.InvalidRequest, "a query covers at most "+strconv.Itoa(maxQueryDays)+" days")
+		return q, false
+	}
+	return q, true
+}
+
+// parseTime accepts an RFC 3339 time or a date, taken as the start of the
+// day, or its end when endOfDay is set
+func parseTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err == nil && endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,463 @@
+// Package transcripts keeps long-term archives of completed chats in object
+// storage, batched into JSONL objects that are optionally gzipped and
+// encrypted, for retention periods a local history store won't survive.
+package transcripts
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/archive"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/ajeetraina/aiwatch/pkg/objectstore"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Object name suffixes, applied in this order
+const (
+	extJSONL     = ".jsonl"
+	extGzip      = ".gz"
+	extEncrypted = ".enc"
+)
+
+// Config controls batching, encoding and retention
+type Config struct {
+	// Prefix is prepended to every object name, e.g. "transcripts/"
+	Prefix string
+	// BatchSize is the number of records that triggers an upload
+	BatchSize int
+	// FlushInterval uploads a partial batch after this long
+	FlushInterval time.Duration
+	// Gzip compresses each batch
+	Gzip bool
+	// Key encrypts each batch with AES-256-GCM when set; see ParseKey
+	Key []byte
+	// RetentionDays installs a bucket lifecycle rule expiring batches, if
+	// the store supports it. Zero leaves the bucket configuration alone.
+	RetentionDays int
+	// MaxPending bounds the records held while uploads are failing; the
+	// oldest are dropped beyond it
+	MaxPending int
+}
+
+// DefaultConfig uploads gzipped batches of 500 records at least every five
+// minutes
+func DefaultConfig() Config {
+	return Config{Prefix: "transcripts/", BatchSize: 500, FlushInterval: 5 * time.Minute, Gzip: true}
+}
+
+// ParseKey decodes a 32-byte AES-256 key given as base64 or hex
+func ParseKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(key) != 32 {
+		key, err = hex.DecodeString(value)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("archive encryption key must be 32 bytes, base64 or hex encoded")
+	}
+	return key, nil
+}
+
+// Metrics holds the collectors the archiver reports to
+type Metrics struct {
+	Uploads  *prometheus.CounterVec // labels: store, result (success or failure)
+	Archived prometheus.Counter     // records uploaded
+	Dropped  prometheus.Counter     // records dropped while uploads failed
+}
+
+// Archiver batches completed chats and uploads them to an object store
+type Archiver struct {
+	store   objectstore.Store
+	config  Config
+	aead    cipher.AEAD
+	metrics Metrics
+	now     func() time.Time
+
+	mu      sync.Mutex
+	pending []archive.Record
+
+	full chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates an archiver uploading to store
+func New(store objectstore.Store, config Config, metrics Metrics) (*Archiver, error) {
+	defaults := DefaultConfig()
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaults.FlushInterval
+	}
+	if config.MaxPending < config.BatchSize {
+		config.MaxPending = 10 * config.BatchSize
+	}
+
+	a := &Archiver{
+		store:   store,
+		config:  config,
+		metrics: metrics,
+		now:     time.Now,
+		full:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	if config.Key != nil {
+		block, err := aes.NewCipher(config.Key)
+		if err != nil || len(config.Key) != 32 {
+			return nil, errors.New("archive encryption key must be 32 bytes")
+		}
+		if a.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Start applies the retention rule and launches the upload loop
+func (a *Archiver) Start(ctx context.Context) {
+	log := logger.GetLogger()
+	if a.config.RetentionDays > 0 {
+		if lifecycler, ok := a.store.(objectstore.Lifecycler); !ok {
+			log.Warn().Str("store", a.store.Name()).Msg("Archive store cannot expire objects; enforce retention on the storage side")
+		} else if err := lifecycler.SetExpiration(ctx, a.config.Prefix, a.config.RetentionDays); err != nil {
+			log.Warn().Err(err).Str("store", a.store.Name()).Msg("Failed to set archive retention rule")
+		} else {
+			log.Info().Int("days", a.config.RetentionDays).Msg("Archive retention rule set")
+		}
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ticker := time.NewTicker(a.config.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-a.done:
+				return
+			case <-ticker.C:
+			case <-a.full:
+			}
+			a.Flush(ctx)
+		}
+	}()
+}
+
+// Close stops the upload loop and uploads whatever is still pending
+func (a *Archiver) Close() {
+	close(a.done)
+	a.wg.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	a.Flush(ctx)
+}
+
+// Archive adds a record to the current batch without blocking the caller
+func (a *Archiver) Archive(rec archive.Record) {
+	if rec.CompletedAt.IsZero() {
+		rec.CompletedAt = a.now()
+	}
+
+	a.mu.Lock()
+	a.pending = append(a.pending, rec)
+	a.trim()
+	ready := len(a.pending) >= a.config.BatchSize
+	a.mu.Unlock()
+
+	if ready {
+		select {
+		case a.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// trim drops the oldest pending records beyond MaxPending; a.mu must be held
+func (a *Archiver) trim() {
+	excess := len(a.pending) - a.config.MaxPending
+	if excess <= 0 {
+		return
+	}
+	a.pending = append([]archive.Record(nil), a.pending[excess:]...)
+	if a.metrics.Dropped != nil {
+		a.metrics.Dropped.Add(float64(excess))
+	}
+	log := logger.GetLogger()
+	log.Error().Int("records", excess).Msg("Archive uploads are failing, dropping oldest transcripts")
+}
+
+// Flush uploads the pending records as one batch. On failure they stay
+// pending for the next attempt.
+func (a *Archiver) Flush(ctx context.Context) error {
+	a.mu.Lock()
+	batch := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	name := a.objectName()
+	data, err := a.encode(name, batch)
+	if err == nil {
+		err = a.store.Put(ctx, name, data)
+	}
+	if err != nil {
+		a.count("failure")
+		log := logger.GetLogger()
+		log.Warn().Err(err).Str("store", a.store.Name()).Int("records", len(batch)).Msg("Archive upload failed, will retry")
+		a.mu.Lock()
+		a.pending = append(batch, a.pending...)
+		a.trim()
+		a.mu.Unlock()
+		return err
+	}
+
+	a.count("success")
+	if a.metrics.Archived != nil {
+		a.metrics.Archived.Add(float64(len(batch)))
+	}
+	return nil
+}
+
+func (a *Archiver) count(result string) {
+	if a.metrics.Uploads != nil {
+		a.metrics.Uploads.WithLabelValues(a.store.Name(), result).Inc()
+	}
+}
+
+// objectName returns a unique name under a per-day prefix, so queries only
+// list the days they cover: transcripts/2026/10/17/20261017T093000Z-1a2b3c4d.jsonl.gz
+func (a *Archiver) objectName() string {
+	now := a.now().UTC()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := a.config.Prefix + now.Format("2006/01/02/20060102T150405Z") + "-" + hex.EncodeToString(suffix) + extJSONL
+	if a.config.Gzip {
+		name += extGzip
+	}
+	if a.aead != nil {
+		name += extEncrypted
+	}
+	return name
+}
+
+// encode writes records as JSONL, then compresses and encrypts them as the
+// object name says. Encrypted objects are the GCM nonce followed by the
+// sealed data.
+func (a *Archiver) encode(name string, recs []archive.Record) ([]byte, error) {
+	encrypt := strings.HasSuffix(name, extEncrypted)
+	if encrypt && a.aead == nil {
+		return nil, fmt.Errorf("%s is encrypted and no key is configured", name)
+	}
+
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if strings.HasSuffix(strings.TrimSuffix(name, extEncrypted), extGzip) {
+		zw = gzip.NewWriter(&buf)
+		w = zw
+	}
+	enc := json.NewEncoder(w)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return nil, err
+		}
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !encrypt {
+		return buf.Bytes(), nil
+	}
+	nonce := make([]byte, a.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return a.aead.Seal(nonce, nonce, buf.Bytes(), nil), nil
+}
+
+// decode reverses encode, using the object name to tell what was applied
+func (a *Archiver) decode(name string, data []byte) ([]archive.Record, error) {
+	if strings.HasSuffix(name, extEncrypted) {
+		if a.aead == nil {
+			return nil, fmt.Errorf("%s is encrypted and no key is configured", name)
+		}
+		size := a.aead.NonceSize()
+		if len(data) < size {
+			return nil, fmt.Errorf("%s is truncated", name)
+		}
+		plain, err := a.aead.Open(nil, data[:size], data[size:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", name, err)
+		}
+		data = plain
+		name = strings.TrimSuffix(name, extEncrypted)
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if strings.HasSuffix(name, extGzip) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %w", name, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	var recs []archive.Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec archive.Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("corrupt record in %s: %w", name, err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, scanner.Err()
+}
+
+// Query selects archived records
+type Query struct {
+	ConversationID string // empty for every conversation
+	From, To       time.Time
+	Limit          int // zero for no limit
+}
+
+// maxQueryDays bounds how many daily prefixes one query lists
+const maxQueryDays = 400
+
+// Search returns the records completed between q.From and q.To, oldest
+// first, including those not uploaded yet
+func (a *Archiver) Search(ctx context.Context, q Query) ([]archive.Record, error) {
+	if q.To.Sub(q.From) > maxQueryDays*24*time.Hour {
+		return nil, fmt.Errorf("queries cover at most %d days", maxQueryDays)
+	}
+	from := q.From.UTC().Truncate(24 * time.Hour)
+	// A batch uploaded just after midnight holds records of the day before
+	last := q.To.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+
+	matches := func(rec archive.Record) bool {
+		if rec.CompletedAt.Before(q.From) || rec.CompletedAt.After(q.To) {
+			return false
+		}
+		return q.ConversationID == "" || rec.ConversationID == q.ConversationID
+	}
+
+	var result []archive.Record
+	for day := from; !day.After(last); day = day.AddDate(0, 0, 1) {
+		names, err := a.store.List(ctx, a.config.Prefix+day.Format("2006/01/02/"))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			data, err := a.store.Get(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			recs, err := a.decode(name, data)
+			if err != nil {
+				return nil, err
+			}
+			for _, rec := range recs {
+				if matches(rec) {
+					result = append(result, rec)
+				}
+			}
+		}
+	}
+
+	a.mu.Lock()
+	for _, rec := range a.pending {
+		if matches(rec) {
+			result = append(result, rec)
+		}
+	}
+	a.mu.Unlock()
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CompletedAt.Before(result[j].CompletedAt)
+	})
+	if q.Limit > 0 && len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+	return result, nil
+}
+
+// Erase removes the records of the given conversations from every batch,
+// rewriting the batches that held them, and from the pending records. It
+// returns the number of records removed.
+func (a *Archiver) Erase(ctx context.Context, conversationIDs []string) (int, error) {
+	if len(conversationIDs) == 0 {
+		return 0, nil
+	}
+	erase := make(map[string]bool, len(conversationIDs))
+	for _, id := range conversationIDs {
+		erase[id] = true
+	}
+	keep := func(recs []archive.Record) []archive.Record {
+		kept := recs[:0:0]
+		for _, rec := range recs {
+			if !erase[rec.ConversationID] {
+				kept = append(kept, rec)
+			}
+		}
+		return kept
+	}
+
+	a.mu.Lock()
+	kept := keep(a.pending)
+	removed := len(a.pending) - len(kept)
+	a.pending = kept
+	a.mu.Unlock()
+
+	names, err := a.store.List(ctx, a.config.Prefix)
+	if err != nil {
+		return removed, err
+	}
+	for _, name := range names {
+		data, err := a.store.Get(ctx, name)
+		if err != nil {
+			return removed, err
+		}
+		recs, err := a.decode(name, data)
+		if err != nil {
+			return removed, err
+		}
+		kept := keep(recs)
+		if len(kept) == len(recs) {
+			continue
+		}
+		if data, err = a.encode(name, kept); err != nil {
+			return removed, err
+		}
+		if err := a.store.Put(ctx, name, data); err != nil {
+			return removed, err
+		}
+		removed += len(recs) - len(kept)
+	}
+	return removed, nil
+}
@@ -0,0 +1,202 @@
+package transcripts
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/archive"
+	"github.com/ajeetraina/aiwatch/pkg/objectstore"
+)
+
+var testNow = time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+
+// failingStore rejects uploads while fail is set
+type failingStore struct {
+	objectstore.DirStore
+	fail bool
+}
+
+func (s *failingStore) Put(ctx context.Context, name string, data []byte) error {
+	if s.fail {
+		return errors.New("bucket unreachable")
+	}
+	return s.DirStore.Put(ctx, name, data)
+}
+
+func newTestArchiver(t *testing.T, store objectstore.Store, config Config) *Archiver {
+	t.Helper()
+	a, err := New(store, config, Metrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return testNow }
+	return a
+}
+
+func record(conversation string, at time.Time) archive.Record {
+	return archive.Record{ConversationID: conversation, Model: "m", Response: "hi from " + conversation, CompletedAt: at}
+}
+
+func TestArchiverUploadsEncryptedBatches(t *testing.T) {
+	key, err := ParseKey(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &objectstore.DirStore{Dir: t.TempDir()}
+	a := newTestArchiver(t, store, Config{Prefix: "transcripts/", BatchSize: 2, Gzip: true, Key: key})
+
+	a.Archive(record("c1", testNow.Add(-time.Minute)))
+	select {
+	case <-a.full:
+		t.Fatal("a partial batch was flagged for upload")
+	default:
+	}
+	a.Archive(record("c2", testNow))
+	select {
+	case <-a.full:
+	default:
+		t.Fatal("a full batch was not flagged for upload")
+	}
+	if err := a.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	names, _ := store.List(context.Background(), "transcripts/2026/10/17/")
+	if len(names) != 1 || !strings.HasSuffix(names[0], ".jsonl.gz.enc") {
+		t.Fatalf("objects = %v", names)
+	}
+	data, _ := store.Get(context.Background(), names[0])
+	if strings.Contains(string(data), "hi from") {
+		t.Error("the batch was stored in plain text")
+	}
+
+	recs, err := a.Search(context.Background(), Query{ConversationID: "c2", From: testNow.Add(-time.Hour), To: testNow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].Response != "hi from c2" {
+		t.Errorf("search = %+v", recs)
+	}
+
+	// Without the key the archive cannot be read
+	plain := newTestArchiver(t, store, Config{Prefix: "transcripts/"})
+	if _, err := plain.Search(context.Background(), Query{From: testNow.Add(-time.Hour), To: testNow}); err == nil {
+		t.Error("an encrypted batch was read without the key")
+	}
+}
+
+func TestArchiverKeepsRecordsWhileUploadsFail(t *testing.T) {
+	store := &failingStore{DirStore: objectstore.DirStore{Dir: t.TempDir()}, fail: true}
+	a := newTestArchiver(t, store, Config{BatchSize: 2, MaxPending: 3})
+
+	for i := 0; i < 4; i++ {
+		a.Archive(record("c", testNow.Add(time.Duration(i)*time.Second)))
+	}
+	if err := a.Flush(context.Background()); err == nil {
+		t.Fatal("flush to a failing store succeeded")
+	}
+	if len(a.pending) != 3 || !a.pending[0].CompletedAt.Equal(testNow.Add(time.Second)) {
+		t.Fatalf("pending = %d records, want the newest 3", len(a.pending))
+	}
+
+	// Pending records are searchable before they are uploaded
+	recs, err := a.Search(context.Background(), Query{From: testNow, To: testNow.Add(time.Minute)})
+	if err != nil || len(recs) != 3 {
+		t.Errorf("search = %d records, %v", len(recs), err)
+	}
+
+	store.fail = false
+	if err := a.Flush(context.Background()); err != nil || len(a.pending) != 0 {
+		t.Errorf("retry: err = %v, %d still pending", err, len(a.pending))
+	}
+}
+
+func TestHandlerQueriesAndRestores(t *testing.T) {
+	a := newTestArchiver(t, &objectstore.DirStore{Dir: t.TempDir()}, DefaultConfig())
+	a.Archive(record("c1", testNow.Add(-2*time.Hour)))
+	a.Archive(record("c1", testNow.Add(-time.Hour)))
+	a.Archive(record("c2", testNow))
+	if err := a.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var restored []archive.Record
+	handler := a.Handler(func(id string, recs []archive.Record) error {
+		if id == "live" {
+			return ErrExists
+		}
+		restored = recs
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/archive/transcripts?from=2026-10-17&to=2026-10-17&limit=2", nil))
+	var page struct {
+		Count   int              `json:"count"`
+		Records []archive.Record `json:"records"`
+	}
+	json.NewDecoder(rec.Body).Decode(&page)
+	if rec.Code != http.StatusOK || page.Count != 2 || page.Records[0].ConversationID != "c1" {
+		t.Errorf("query: %d %+v", rec.Code, page)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/archive/transcripts/restore?conversation_id=c1&from=2026-10-17&to=2026-10-17", nil))
+	if rec.Code != http.StatusOK || len(restored) != 2 || restored[0].CompletedAt.After(restored[1].CompletedAt) {
+		t.Errorf("restore: %d, %d records", rec.Code, len(restored))
+	}
+
+	for target, want := range map[string]int{
+		"/archive/transcripts/restore?conversation_id=gone&from=2026-10-17&to=2026-10-17": http.StatusNotFound,
+		"/archive/transcripts/restore?from=2026-10-17":                                    http.StatusBadRequest,
+		"/archive/transcripts/restore?conversation_id=c1&from=2026-10-18&to=2026-10-17":   http.StatusBadRequest,
+		"/archive/transcripts/restore?conversation_id=c1&from=2024-01-01&to=2026-10-17":   http.StatusBadRequest,
+	} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		if rec.Code != want {
+			t.Errorf("POST %s = %d, want %d", target, rec.Code, want)
+		}
+	}
+}
+
+func TestParseKeyRejectsShortKeys(t *testing.T) {
+	if _, err := ParseKey(strings.Repeat("ab", 32)); err != nil {
+		t.Errorf("hex key: %v", err)
+	}
+	if _, err := ParseKey(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Error("short key accepted")
+	}
+}
+
+func TestEraseRewritesBatches(t *testing.T) {
+	store := &objectstore.DirStore{Dir: t.TempDir()}
+	a := newTestArchiver(t, store, DefaultConfig())
+	a.Archive(record("erase-me", testNow))
+	a.Archive(record("keep", testNow))
+	if err := a.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	a.Archive(record("erase-me", testNow))
+
+	// Batches written before gzip was turned off keep their encoding
+	a.config.Gzip = false
+	removed, err := a.Erase(context.Background(), []string{"erase-me"})
+	if err != nil || removed != 2 {
+		t.Fatalf("removed = %d, %v", removed, err)
+	}
+	recs, err := a.Search(context.Background(), Query{From: testNow.Add(-time.Hour), To: testNow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].ConversationID != "keep" {
+		t.Errorf("after erase = %+v", recs)
+	}
+}
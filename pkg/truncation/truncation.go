@@ -0,0 +1,175 @@
+package truncation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Strategy names accepted in ChatRequest.Truncation and TRUNCATION_STRATEGY
+const (
+	DropOldest       = "drop_oldest"
+	KeepSystemRecent = "keep_system_recent"
+	MiddleOut        = "middle_out"
+)
+
+// Message is a single conversation turn
+type Message struct {
+	Role    string
+	Content string
+}
+
+// Result describes what a truncation pass did
+type Result struct {
+	Messages      []Message
+	Strategy      string
+	DroppedTokens int
+	DroppedCount  int
+}
+
+// EstimateTokens returns a rough token count for text (~4 characters per token)
+func EstimateTokens(text string) int {
+	return len(text) / 4
+}
+
+// CountTokens returns the estimated token count of all messages
+func CountTokens(messages []Message) int {
+	total := 0
+	for _, m := range messages {
+		total += EstimateTokens(m.Content)
+	}
+	return total
+}
+
+// IsValid reports whether name is a known strategy
+func IsValid(name string) bool {
+	switch name {
+	case DropOldest, KeepSystemRecent, MiddleOut:
+		return true
+	}
+	return false
+}
+
+// Apply trims messages to fit within budget tokens using the named strategy.
+// The last message (the current user turn) is always kept.
+func Apply(strategy string, messages []Message, budget int) (Result, error) {
+	if !IsValid(strategy) {
+		return Result{}, fmt.Errorf("unknown truncation strategy %q", strategy)
+	}
+
+	result := Result{Messages: messages, Strategy: strategy}
+	if budget <= 0 || CountTokens(messages) <= budget || len(messages) <= 1 {
+		return result, nil
+	}
+
+	var kept []Message
+	switch strategy {
+	case DropOldest:
+		kept = dropOldest(messages, budget)
+	case KeepSystemRecent:
+		kept = keepSystemRecent(messages, budget)
+	case MiddleOut:
+		kept = middleOut(messages, budget)
+	}
+
+	result.Messages = kept
+	result.DroppedTokens = CountTokens(messages) - CountTokens(kept)
+	if result.DroppedTokens < 0 {
+		result.DroppedTokens = 0
+	}
+	result.DroppedCount = len(messages) - len(kept)
+	if result.DroppedCount < 0 {
+		result.DroppedCount = 0
+	}
+	return result, nil
+}
+
+// dropOldest removes messages from the front until the rest fits
+func dropOldest(messages []Message, budget int) []Message {
+	i := 0
+	for i < len(messages)-1 && CountTokens(messages[i:]) > budget {
+		i++
+	}
+	return messages[i:]
+}
+
+// keepSystemRecent keeps every system message plus as many of the most
+// recent non-system messages as fit in the remaining budget
+func keepSystemRecent(messages []Message, budget int) []Message {
+	var system []Message
+	var rest []Message
+	for _, m := range messages[:len(messages)-1] {
+		if m.Role == "system" {
+			system = append(system, m)
+		} else {
+			rest = append(rest, m)
+		}
+	}
+	last := messages[len(messages)-1]
+
+	remaining := budget - CountTokens(system) - EstimateTokens(last.Content)
+	start := len(rest)
+	for start > 0 && EstimateTokens(rest[start-1].Content) <= remaining {
+		remaining -= EstimateTokens(rest[start-1].Content)
+		start--
+	}
+
+	kept := append([]Message{}, system...)
+	kept = append(kept, rest[start:]...)
+	return append(kept, last)
+}
+
+// middleOut keeps the first and most recent turns and replaces the middle
+// of the conversation with a short extractive summary
+func middleOut(messages []Message, budget int) []Message {
+	head := 0
+	if messages[0].Role == "system" || len(messages) > 2 {
+		head = 1
+	}
+
+	// Grow the tail from the end while leaving room for the head and a summary
+	summaryBudget := budget / 10
+	remaining := budget - CountTokens(messages[:head]) - summaryBudget
+	tail := len(messages)
+	for tail > head && EstimateTokens(messages[tail-1].Content) <= remaining {
+		remaining -= EstimateTokens(messages[tail-1].Content)
+		tail--
+	}
+	if tail == len(messages) {
+		// Even the current turn doesn't fit; keep it anyway
+		tail = len(messages) - 1
+	}
+
+	middle := messages[head:tail]
+	kept := append([]Message{}, messages[:head]...)
+	if len(middle) > 0 {
+		kept = append(kept, Message{Role: "system", Content: summarize(middle, summaryBudget)})
+	}
+	return append(kept, messages[tail:]...)
+}
+
+// summarize builds an extractive summary from the first sentence of each message
+func summarize(messages []Message, budget int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Summary of %d earlier messages:", len(messages))
+	for _, m := range messages {
+		sentence := firstSentence(m.Content)
+		line := fmt.Sprintf("\n- %s: %s", m.Role, sentence)
+		if EstimateTokens(b.String()+line) > budget {
+			break
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// firstSentence returns the first sentence of text, capped at 200 characters
+func firstSentence(text string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.IndexAny(text, ".!?\n"); i >= 0 {
+		text = text[:i+1]
+	}
+	if len(text) > 200 {
+		text = text[:200] + "…"
+	}
+	return strings.TrimSpace(text)
+}
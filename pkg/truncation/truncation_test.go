@@ -0,0 +1,101 @@
+package truncation
+
+import (
+	"strings"
+	"testing"
+)
+
+// turn returns a message of the given number of estimated tokens
+func turn(role string, tokens int, text string) Message {
+	return Message{Role: role, Content: text + strings.Repeat(".", tokens*4-len(text))}
+}
+
+func TestApplyKeepsEachStrategyWithinBudget(t *testing.T) {
+	conversation := []Message{
+		turn("system", 10, "Be brief."),
+		turn("user", 40, "First question."),
+		turn("assistant", 40, "First answer."),
+		turn("user", 40, "Second question."),
+		turn("assistant", 40, "Second answer."),
+		turn("user", 20, "Current question."),
+	}
+	const budget = 100
+
+	cases := []struct {
+		strategy string
+		first    string // content prefix of the first kept message
+		count    int
+	}{
+		{DropOldest, "Second question.", 3},
+		{KeepSystemRecent, "Be brief.", 3},
+		{MiddleOut, "Be brief.", 4},
+	}
+	for _, tc := range cases {
+		result, err := Apply(tc.strategy, conversation, budget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := CountTokens(result.Messages); got > budget {
+			t.Errorf("%s: kept %d tokens, over the budget of %d", tc.strategy, got, budget)
+		}
+		if len(result.Messages) != tc.count || !strings.HasPrefix(result.Messages[0].Content, tc.first) {
+			t.Errorf("%s: kept %d messages starting %.20q, want %d starting %q", tc.strategy, len(result.Messages), result.Messages[0].Content, tc.count, tc.first)
+		}
+		if last := result.Messages[len(result.Messages)-1]; !strings.HasPrefix(last.Content, "Current question.") {
+			t.Errorf("%s: dropped the current turn", tc.strategy)
+		}
+		if result.DroppedCount != len(conversation)-len(result.Messages) || result.DroppedTokens != CountTokens(conversation)-CountTokens(result.Messages) {
+			t.Errorf("%s: reported %d messages and %d tokens dropped", tc.strategy, result.DroppedCount, result.DroppedTokens)
+		}
+	}
+}
+
+func TestMiddleOutSummarizesTheMiddle(t *testing.T) {
+	conversation := []Message{
+		turn("system", 10, "Be brief."),
+		turn("user", 200, "What is Docker? Tell me more."),
+		turn("assistant", 200, "A container runtime! It packages apps."),
+		turn("user", 200, "And Compose?"),
+		turn("user", 20, "Current question."),
+	}
+	const budget = 300
+	result, err := Apply(MiddleOut, conversation, budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := CountTokens(result.Messages); got > budget {
+		t.Errorf("kept %d tokens, over the budget of %d", got, budget)
+	}
+	if len(result.Messages) != 4 {
+		t.Fatalf("expected the head, a summary and two recent turns, got %+v", result.Messages)
+	}
+	want := "Summary of 2 earlier messages:\n- user: What is Docker?\n- assistant: A container runtime!"
+	if summary := result.Messages[1]; summary.Role != "system" || summary.Content != want {
+		t.Errorf("summary = %+v, want %q", summary, want)
+	}
+	if got := EstimateTokens(result.Messages[1].Content); got > budget/10 {
+		t.Errorf("summary of %d tokens exceeds a tenth of the budget", got)
+	}
+}
+
+func TestApplyLeavesFittingConversations(t *testing.T) {
+	conversation := []Message{turn("user", 10, "Hi."), turn("assistant", 10, "Hello."), turn("user", 10, "Bye.")}
+	for _, strategy := range []string{DropOldest, KeepSystemRecent, MiddleOut} {
+		for _, budget := range []int{0, 30, 100} {
+			result, err := Apply(strategy, conversation, budget)
+			if err != nil || len(result.Messages) != 3 || result.DroppedCount != 0 {
+				t.Errorf("%s with budget %d: %+v, %v", strategy, budget, result, err)
+			}
+		}
+	}
+
+	// The current turn is kept even when it alone is over budget
+	result, _ := Apply(DropOldest, conversation, 5)
+	if len(result.Messages) != 1 || !strings.HasPrefix(result.Messages[0].Content, "Bye.") {
+		t.Errorf("expected only the current turn, got %+v", result.Messages)
+	}
+
+	if _, err := Apply("newest_first", conversation, 10); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
@@ -0,0 +1,135 @@
+// Package upstream builds the HTTP transport used to reach model servers.
+// Go's default keeps only two idle connections per host, so at high
+// concurrency most requests dial, and often handshake, a new connection
+// before their first token; the transport here keeps enough of them warm
+// and reports how each request got its connection.
+package upstream
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Config tunes connection pooling, TLS and HTTP/2 for upstream calls
+type Config struct {
+	MaxIdleConns        int           // idle connections kept across all hosts
+	MaxIdleConnsPerHost int           // idle connections kept per host
+	MaxConnsPerHost     int           // connections per host, 0 for no limit
+	IdleConnTimeout     time.Duration // how long an idle connection is kept
+	DialTimeout         time.Duration
+	KeepAlive           time.Duration // TCP keep-alive probe interval
+	TLSHandshakeTimeout time.Duration
+	TLSSessionCache     int  // TLS sessions kept for resumption, 0 disables it
+	HTTP2               bool // negotiate HTTP/2 with TLS backends
+}
+
+// DefaultConfig returns settings sized for many concurrent streams to a
+// handful of model servers
+func DefaultConfig() Config {
+	return Config{
+		MaxIdleConns:        256,
+		MaxIdleConnsPerHost: 64,
+		IdleConnTimeout:     90 * time.Second,
+		DialTimeout:         10 * time.Second,
+		KeepAlive:           30 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSSessionCache:     128,
+		HTTP2:               true,
+	}
+}
+
+// Metrics holds the collectors the transport reports to
+type Metrics struct {
+	Connections  *prometheus.CounterVec   // labels: backend, reused (true|false)
+	DNSDuration  *prometheus.HistogramVec // labels: backend
+	TLSHandshake *prometheus.HistogramVec // labels: backend, resumed (true|false)
+}
+
+// Transport is an http.RoundTripper over a tuned http.Transport that
+// observes connection reuse, DNS lookups and TLS handshakes
+type Transport struct {
+	base    *http.Transport
+	metrics Metrics
+}
+
+// NewTransport creates a transport for config. Backends served over plain
+// HTTP use HTTP/1.1 keep-alive connections whatever HTTP2 says.
+func NewTransport(config Config, metrics Metrics) *Transport {
+	dialer := &net.Dialer{Timeout: config.DialTimeout, KeepAlive: config.KeepAlive}
+	tlsConfig := &tls.Config{}
+	if config.TLSSessionCache > 0 {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(config.TLSSessionCache)
+	}
+	base := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     config.HTTP2,
+	}
+	if !config.HTTP2 {
+		// A non-nil empty map turns off the transport's HTTP/2 upgrade
+		base.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return &Transport{base: base, metrics: metrics}
+}
+
+// RoundTrip sends req, timing the DNS lookup and TLS handshake if the
+// request needs a new connection
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backend := req.URL.Host
+	var mu sync.Mutex // the callbacks may run on the transport's dial goroutine
+	var dnsStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			dnsStart = time.Now()
+			mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			start := dnsStart
+			mu.Unlock()
+			if t.metrics.DNSDuration != nil && !start.IsZero() {
+				t.metrics.DNSDuration.WithLabelValues(backend).Observe(time.Since(start).Seconds())
+			}
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			tlsStart = time.Now()
+			mu.Unlock()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			mu.Lock()
+			start := tlsStart
+			mu.Unlock()
+			if t.metrics.TLSHandshake != nil && err == nil && !start.IsZero() {
+				t.metrics.TLSHandshake.WithLabelValues(backend, strconv.FormatBool(state.DidResume)).Observe(time.Since(start).Seconds())
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if t.metrics.Connections != nil {
+				t.metrics.Connections.WithLabelValues(backend, strconv.FormatBool(info.Reused)).Inc()
+			}
+		},
+	}
+	ctx := httptrace.WithClientTrace(req.Context(), trace)
+	return t.base.RoundTrip(req.WithContext(ctx))
+}
+
+// CloseIdleConnections closes connections no request is using
+func (t *Transport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
@@ -0,0 +1,85 @@
+package upstream
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testMetrics() Metrics {
+	return Metrics{
+		Connections:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "connections"}, []string{"backend", "reused"}),
+		DNSDuration:  prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "dns"}, []string{"backend"}),
+		TLSHandshake: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "tls"}, []string{"backend", "resumed"}),
+	}
+}
+
+func get(t *testing.T, client *http.Client, url string) *http.Response {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+func TestTransportReusesConnections(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	// Through a name, so the lookup is timed
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	host := net.JoinHostPort("localhost", port)
+
+	metrics := testMetrics()
+	transport := NewTransport(DefaultConfig(), metrics)
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	for i := 0; i < 3; i++ {
+		get(t, client, "http://"+host)
+	}
+	if got := testutil.ToFloat64(metrics.Connections.WithLabelValues(host, "false")); got != 1 {
+		t.Errorf("new connections = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.Connections.WithLabelValues(host, "true")); got != 2 {
+		t.Errorf("reused connections = %v, want 2", got)
+	}
+	if got := testutil.CollectAndCount(metrics.DNSDuration); got != 1 {
+		t.Errorf("%d DNS series, want the lookup for the first connection", got)
+	}
+}
+
+func TestTransportTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, http2 := range []bool{true, false} {
+		config := DefaultConfig()
+		config.HTTP2 = http2
+		metrics := testMetrics()
+		transport := NewTransport(config, metrics)
+		transport.base.TLSClientConfig.RootCAs = srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+		client := &http.Client{Transport: transport}
+
+		resp := get(t, client, srv.URL)
+		if want := map[bool]int{true: 2, false: 1}[http2]; resp.ProtoMajor != want {
+			t.Errorf("HTTP2=%v: got HTTP/%d, want HTTP/%d", http2, resp.ProtoMajor, want)
+		}
+		// Drop the connection so the next request resumes the TLS session
+		transport.CloseIdleConnections()
+		get(t, client, srv.URL)
+		transport.CloseIdleConnections()
+
+		if got := testutil.CollectAndCount(metrics.TLSHandshake); got != 2 {
+			t.Errorf("HTTP2=%v: %d handshake series, want full and resumed", http2, got)
+		}
+	}
+}
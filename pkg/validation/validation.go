@@ -0,0 +1,215 @@
+// Package validation rejects chat requests that are too large or malformed
+// before they are decoded in full, so one oversized paste cannot exhaust
+// the proxy's memory. Rejections are structured errors with a reason.
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/ajeetraina/aiwatch/pkg/apierror"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Rejection reasons, the values of the reason label
+const (
+	ReasonBodyTooLarge    = "body_too_large"
+	ReasonTooManyMessages = "too_many_messages"
+	ReasonMessageTooLong  = "message_too_long"
+	ReasonInvalidRole     = "invalid_role"
+	ReasonMalformed       = "malformed"
+)
+
+// Config sets the limits. Zero disables a limit.
+type Config struct {
+	MaxBodyBytes    int64    // size of the request body
+	MaxMessages     int      // entries in messages
+	MaxMessageBytes int      // content of one message
+	Roles           []string // allowed message roles
+}
+
+// DefaultConfig returns limits generous enough for long documents but far
+// below what would strain the proxy
+func DefaultConfig() Config {
+	return Config{
+		MaxBodyBytes:    8 << 20,
+		MaxMessages:     1000,
+		MaxMessageBytes: 1 << 20,
+		Roles:           []string{"system", "developer", "user", "assistant", "tool", "function"},
+	}
+}
+
+// Metrics holds the collectors the validator reports to
+type Metrics struct {
+	Rejected *prometheus.CounterVec // labels: reason
+}
+
+// Validator checks request bodies against a Config
+type Validator struct {
+	config  Config
+	metrics Metrics
+}
+
+// New creates a validator enforcing config
+func New(config Config, metrics Metrics) *Validator {
+	return &Validator{config: config, metrics: metrics}
+}
+
+// Error is a rejected request
+type Error struct {
+	Reason  string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// code returns the API error code a rejection is reported with
+func (e *Error) code() apierror.Code {
+	switch e.Reason {
+	case ReasonBodyTooLarge, ReasonTooManyMessages, ReasonMessageTooLong:
+		return apierror.TooLarge
+	}
+	return apierror.InvalidRequest
+}
+
+// Middleware rejects POST and PUT bodies that break the limits, passing
+// the others on with their body intact
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := v.read(w, r)
+		if err == nil {
+			err = v.Check(body)
+		}
+		var rejected *Error
+		if errors.As(err, &rejected) {
+			v.reject(w, r, rejected)
+			return
+		}
+		if err != nil {
+			apierror.Write(w, r, apierror.InvalidRequest, "Invalid request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// read reads the body, failing as soon as it passes the size limit
+func (v *Validator) read(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	limit := v.config.MaxBodyBytes
+	if limit <= 0 {
+		return io.ReadAll(r.Body)
+	}
+	tooLarge := &Error{Reason: ReasonBodyTooLarge, Message: fmt.Sprintf("Request body exceeds %s", formatBytes(limit))}
+	if r.ContentLength > limit {
+		return nil, tooLarge
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return nil, tooLarge
+	}
+	return body, err
+}
+
+// Check validates a chat request body: its messages, and its single
+// message if it has one. Bodies that are not JSON objects are malformed;
+// other fields are left to the handler.
+func (v *Validator) Check(body []byte) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	var req struct {
+		Messages []json.RawMessage `json:"messages"`
+		Message  *string           `json:"message"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return &Error{Reason: ReasonMalformed, Message: jsonError(err)}
+	}
+	if max := v.config.MaxMessages; max > 0 && len(req.Messages) > max {
+		return &Error{Reason: ReasonTooManyMessages, Message: fmt.Sprintf("Request has %d messages, more than the limit of %d", len(req.Messages), max)}
+	}
+	if req.Message != nil {
+		if err := v.checkLength("message", len(*req.Message)); err != nil {
+			return err
+		}
+	}
+	for i, raw := range req.Messages {
+		var msg struct {
+			Role    *string         `json:"role"`
+			Content json.RawMessage `json:"content"`
+		}
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return &Error{Reason: ReasonMalformed, Message: fmt.Sprintf("messages[%d] must be an object with a role and content", i)}
+		}
+		if msg.Role == nil || *msg.Role == "" {
+			return &Error{Reason: ReasonInvalidRole, Message: fmt.Sprintf("messages[%d] has no role", i)}
+		}
+		if len(v.config.Roles) > 0 && !slices.Contains(v.config.Roles, *msg.Role) {
+			return &Error{Reason: ReasonInvalidRole, Message: fmt.Sprintf("messages[%d] has role %q, want one of %s", i, *msg.Role, strings.Join(v.config.Roles, ", "))}
+		}
+		if err := v.checkLength(fmt.Sprintf("messages[%d]", i), contentLength(msg.Content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *Validator) checkLength(name string, n int) error {
+	if max := v.config.MaxMessageBytes; max > 0 && n > max {
+		return &Error{Reason: ReasonMessageTooLong, Message: fmt.Sprintf("%s is %s, more than the limit of %s", name, formatBytes(int64(n)), formatBytes(int64(max)))}
+	}
+	return nil
+}
+
+// contentLength returns the length of a message's text. Content given as
+// parts, such as text and images, is measured as encoded.
+func contentLength(content json.RawMessage) int {
+	var text string
+	if json.Unmarshal(content, &text) == nil {
+		return len(text)
+	}
+	return len(content)
+}
+
+func (v *Validator) reject(w http.ResponseWriter, r *http.Request, err *Error) {
+	if v.metrics.Rejected != nil {
+		v.metrics.Rejected.WithLabelValues(err.Reason).Inc()
+	}
+	apierror.Write(w, r, err.code(), err.Message)
+}
+
+// jsonError describes a decoding error without Go type names
+func jsonError(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return "Request body is not valid JSON: " + err.Error()
+	}
+	if typeErr.Field == "" {
+		return "Request body must be a JSON object"
+	}
+	return fmt.Sprintf("Invalid %s: got a JSON %s", typeErr.Field, typeErr.Value)
+}
+
+// formatBytes renders n as B, KiB or MiB
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%d MiB", n>>20)
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%d KiB", n>>10)
+	}
+	return fmt.Sprintf("%d B", n)
+}
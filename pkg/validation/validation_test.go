@@ -0,0 +1,109 @@
+package validation
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testConfig() Config {
+	return Config{MaxBodyBytes: 1 << 10, MaxMessages: 3, MaxMessageBytes: 100, Roles: DefaultConfig().Roles}
+}
+
+func TestCheck(t *testing.T) {
+	long := strings.Repeat("x", 101)
+	tests := []struct {
+		body   string
+		reason string
+	}{
+		{body: ``},
+		{body: `{"message": "hi"}`},
+		{body: `{"messages": [{"role": "system", "content": "Be brief"}, {"role": "user", "content": "hi"}]}`},
+		{body: `{"messages": [{"role": "user", "content": [{"type": "text", "text": "hi"}]}]}`},
+		{body: `{"model": "ai/llama", "callback_url": "https://example.com"}`},
+		{body: `{"messages": [{"role": "user", "content": "a"}, {"role": "assistant", "content": "b"}, {"role": "user", "content": "c"}, {"role": "user", "content": "d"}]}`, reason: ReasonTooManyMessages},
+		{body: `{"message": "` + long + `"}`, reason: ReasonMessageTooLong},
+		{body: `{"messages": [{"role": "user", "content": "` + long + `"}]}`, reason: ReasonMessageTooLong},
+		{body: `{"messages": [{"role": "user", "content": [{"type": "text", "text": "` + long + `"}]}]}`, reason: ReasonMessageTooLong},
+		{body: `{"messages": [{"role": "robot", "content": "beep"}]}`, reason: ReasonInvalidRole},
+		{body: `{"messages": [{"content": "who am I"}]}`, reason: ReasonInvalidRole},
+		{body: `{"messages": ["hi"]}`, reason: ReasonMalformed},
+		{body: `{"messages": {"role": "user"}}`, reason: ReasonMalformed},
+		{body: `[1, 2]`, reason: ReasonMalformed},
+		{body: `{"messages": [`, reason: ReasonMalformed},
+	}
+	v := New(testConfig(), Metrics{})
+	for _, tt := range tests {
+		err := v.Check([]byte(tt.body))
+		reason := ""
+		if err != nil {
+			reason = err.(*Error).Reason
+		}
+		if reason != tt.reason {
+			t.Errorf("%.60s: got reason %q (%v), want %q", tt.body, reason, err, tt.reason)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rejected"}, []string{"reason"})
+	var received string
+	handler := New(testConfig(), Metrics{Rejected: rejected}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	}))
+
+	body := `{"messages": [{"role": "user", "content": "hi"}]}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body)))
+	if rec.Code != http.StatusOK || received != body {
+		t.Errorf("valid request: got %d, handler read %q", rec.Code, received)
+	}
+
+	tests := []struct {
+		body   io.Reader
+		length int64 // Content-Length, or -1 for a chunked body
+		status int
+		code   string
+	}{
+		{body: strings.NewReader(`{"message": "` + strings.Repeat("x", 2000) + `"}`), length: 2015, status: http.StatusRequestEntityTooLarge, code: "too_large"},
+		{body: strings.NewReader(`{"message": "` + strings.Repeat("x", 2000) + `"}`), length: -1, status: http.StatusRequestEntityTooLarge, code: "too_large"},
+		{body: strings.NewReader(`{"messages": [{"role": "robot", "content": "beep"}]}`), length: -1, status: http.StatusBadRequest, code: "invalid_request"},
+	}
+	for i, tt := range tests {
+		received = ""
+		req := httptest.NewRequest(http.MethodPost, "/chat", tt.body)
+		req.ContentLength = tt.length
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var resp struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != tt.status || resp.Error.Code != tt.code || received != "" {
+			t.Errorf("%d: got %d %s, handler read %d bytes", i, rec.Code, rec.Body, len(received))
+		}
+	}
+	if got := testutil.ToFloat64(rejected.WithLabelValues(ReasonBodyTooLarge)); got != 2 {
+		t.Errorf("body_too_large rejections = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(rejected.WithLabelValues(ReasonInvalidRole)); got != 1 {
+		t.Errorf("invalid_role rejections = %v, want 1", got)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/abc", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET: got %d", rec.Code)
+	}
+}
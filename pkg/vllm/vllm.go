@@ -0,0 +1,282 @@
+package vllm
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Stats is the state of one model served by vLLM
+type Stats struct {
+	RequestsRunning    float64 `json:"requestsRunning"`
+	RequestsWaiting    float64 `json:"requestsWaiting"`
+	KVCacheUsage       float64 `json:"kvCacheUsage"`       // 0 to 1
+	PrefixCacheHitRate float64 `json:"prefixCacheHitRate"` // 0 to 1, over the last scrape interval
+	// SpecDecodeAcceptanceRate is the share of draft tokens accepted over the
+	// last scrape interval, when speculative decoding is on
+	SpecDecodeAcceptanceRate float64   `json:"specDecodeAcceptanceRate,omitempty"`
+	ScrapedAt                time.Time `json:"scrapedAt"`
+}
+
+// Metrics holds the gauges scraped stats are copied into, labelled by model
+type Metrics struct {
+	RequestsRunning          *prometheus.GaugeVec
+	RequestsWaiting          *prometheus.GaugeVec
+	KVCacheUsage             *prometheus.GaugeVec
+	PrefixCacheHitRate       *prometheus.GaugeVec
+	SpecDecodeAcceptanceRate *prometheus.GaugeVec
+}
+
+// Metric names across vLLM versions. V1 renamed the cache metrics and
+// replaced the hit rate gauge with counters.
+var (
+	kvCacheNames     = []string{"vllm:kv_cache_usage_perc", "vllm:gpu_cache_usage_perc"}
+	hitRateNames     = []string{"vllm:gpu_prefix_cache_hit_rate"}
+	prefixHitNames   = []string{"vllm:prefix_cache_hits_total", "vllm:gpu_prefix_cache_hits_total", "vllm:gpu_prefix_cache_hits"}
+	prefixQueryNames = []string{"vllm:prefix_cache_queries_total", "vllm:gpu_prefix_cache_queries_total", "vllm:gpu_prefix_cache_queries"}
+	acceptanceNames  = []string{"vllm:spec_decode_draft_acceptance_rate"}
+	acceptedNames    = []string{"vllm:spec_decode_num_accepted_tokens_total"}
+	draftNames       = []string{"vllm:spec_decode_num_draft_tokens_total"}
+)
+
+// Collector scrapes a vLLM server's Prometheus /metrics endpoint
+type Collector struct {
+	url          string
+	defaultModel string
+	client       *http.Client
+	metrics      Metrics
+
+	mu       sync.Mutex
+	latest   map[string]Stats
+	counters map[string]map[string]float64 // model: counter values at the last scrape
+}
+
+// New creates a collector for the vLLM server at baseURL. Samples without a
+// model_name label are reported under defaultModel.
+func New(baseURL, defaultModel string, metrics Metrics) *Collector {
+	return &Collector{
+		url:          strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/v1") + "/metrics",
+		defaultModel: defaultModel,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		metrics:      metrics,
+		latest:       make(map[string]Stats),
+		counters:     make(map[string]map[string]float64),
+	}
+}
+
+// Scrape reads the server's metrics, updates the gauges and returns the
+// stats of each model
+func (c *Collector) Scrape(ctx context.Context) (map[string]Stats, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned status %d", c.url, resp.StatusCode)
+	}
+	samples, err := parse(resp.Body, c.defaultModel)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for model, values := range samples {
+		stats := Stats{
+			RequestsRunning: values["vllm:num_requests_running"],
+			RequestsWaiting: values["vllm:num_requests_waiting"],
+			KVCacheUsage:    lookup(values, kvCacheNames),
+			ScrapedAt:       now,
+		}
+		previous := c.counters[model]
+		current := make(map[string]float64)
+		if rate, ok := find(values, hitRateNames); ok {
+			stats.PrefixCacheHitRate = rate
+		} else if rate, ok := ratio(values, previous, current, "prefix", prefixHitNames, prefixQueryNames); ok {
+			stats.PrefixCacheHitRate = rate
+		} else {
+			stats.PrefixCacheHitRate = c.latest[model].PrefixCacheHitRate
+		}
+		if rate, ok := find(values, acceptanceNames); ok {
+			stats.SpecDecodeAcceptanceRate = rate
+		} else if rate, ok := ratio(values, previous, current, "spec", acceptedNames, draftNames); ok {
+			stats.SpecDecodeAcceptanceRate = rate
+		} else {
+			stats.SpecDecodeAcceptanceRate = c.latest[model].SpecDecodeAcceptanceRate
+		}
+		c.counters[model] = current
+		c.latest[model] = stats
+		c.record(model, stats)
+	}
+
+	result := make(map[string]Stats, len(c.latest))
+	for model, stats := range c.latest {
+		result[model] = stats
+	}
+	return result, nil
+}
+
+func (c *Collector) record(model string, stats Stats) {
+	set := func(gauge *prometheus.GaugeVec, value float64) {
+		if gauge != nil {
+			gauge.WithLabelValues(model).Set(value)
+		}
+	}
+	set(c.metrics.RequestsRunning, stats.RequestsRunning)
+	set(c.metrics.RequestsWaiting, stats.RequestsWaiting)
+	set(c.metrics.KVCacheUsage, stats.KVCacheUsage)
+	set(c.metrics.PrefixCacheHitRate, stats.PrefixCacheHitRate)
+	if stats.SpecDecodeAcceptanceRate > 0 {
+		set(c.metrics.SpecDecodeAcceptanceRate, stats.SpecDecodeAcceptanceRate)
+	}
+}
+
+// ratio computes num/den over the scrape interval from two counters, saving
+// their values under key in current. It falls back to the lifetime ratio on
+// the first scrape or after a server restart resets the counters, and
+// reports false when the counters are missing or have never moved.
+func ratio(values, previous, current map[string]float64, key string, numNames, denNames []string) (float64, bool) {
+	num, okNum := find(values, numNames)
+	den, okDen := find(values, denNames)
+	if !okNum || !okDen {
+		return 0, false
+	}
+	current[key+"_num"], current[key+"_den"] = num, den
+
+	prevNum, seen := previous[key+"_num"]
+	prevDen := previous[key+"_den"]
+	if seen && den > prevDen && num >= prevNum {
+		return (num - prevNum) / (den - prevDen), true
+	}
+	if den > 0 {
+		return num / den, true
+	}
+	return 0, false
+}
+
+// Latest returns the most recent stats for model
+func (c *Collector) Latest(model string) (Stats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats, ok := c.latest[model]
+	return stats, ok
+}
+
+// parse reads the Prometheus text format into per-model values, summing
+// samples of the same metric that differ in other labels
+func parse(r io.Reader, defaultModel string) (map[string]map[string]float64, error) {
+	samples := make(map[string]map[string]float64)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, labels, rest := line, "", ""
+		if i := strings.IndexByte(line, '{'); i >= 0 {
+			j := strings.LastIndexByte(line, '}')
+			if j < i {
+				continue
+			}
+			name, labels, rest = line[:i], line[i+1:j], line[j+1:]
+		} else if fields := strings.Fields(line); len(fields) >= 2 {
+			name, rest = fields[0], fields[1]
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 || !strings.HasPrefix(name, "vllm:") {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+
+		model := labelValue(labels, "model_name")
+		if model == "" {
+			model = defaultModel
+		}
+		if samples[model] == nil {
+			samples[model] = make(map[string]float64)
+		}
+		samples[model][name] += value
+	}
+	return samples, scanner.Err()
+}
+
+// labelValue extracts one label from a label set like a="1",b="2"
+func labelValue(labels, name string) string {
+	prefix := name + `="`
+	for labels != "" {
+		labels = strings.TrimLeft(labels, ", ")
+		if strings.HasPrefix(labels, prefix) {
+			value := labels[len(prefix):]
+			if end := strings.IndexByte(value, '"'); end >= 0 {
+				return value[:end]
+			}
+			return ""
+		}
+		// Skip to the next label, past this one's quoted value
+		start := strings.Index(labels, `="`)
+		if start < 0 {
+			return ""
+		}
+		end := strings.IndexByte(labels[start+2:], '"')
+		if end < 0 {
+			return ""
+		}
+		labels = labels[start+2+end+1:]
+	}
+	return ""
+}
+
+func find(values map[string]float64, names []string) (float64, bool) {
+	for _, name := range names {
+		if v, ok := values[name]; ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+func lookup(values map[string]float64, names []string) float64 {
+	v, _ := find(values, names)
+	return v
+}
+
+// Start scrapes on an interval until ctx is cancelled
+func (c *Collector) Start(ctx context.Context, interval time.Duration) {
+	log := logger.GetLogger()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := c.Scrape(ctx); err != nil {
+				log.Debug().Err(err).Str("url", c.url).Msg("vLLM metrics scrape failed")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
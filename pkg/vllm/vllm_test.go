@@ -0,0 +1,126 @@
+package vllm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func gauge(name string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name}, []string{"model"})
+}
+
+func TestScrapeParsesLabelledSamples(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("# HELP vllm:num_requests_running Number of requests in model execution batches.\n" +
+			"# TYPE vllm:num_requests_running gauge\n" +
+			`vllm:num_requests_running{engine="0",model_name="meta-llama/Llama-3.1-8B"} 3.0` + "\n" +
+			`vllm:num_requests_waiting{engine="0",model_name="meta-llama/Llama-3.1-8B"} 2.0` + "\n" +
+			`vllm:gpu_cache_usage_perc{model_name="meta-llama/Llama-3.1-8B"} 0.4` + "\n" +
+			`vllm:gpu_prefix_cache_hit_rate{model_name="meta-llama/Llama-3.1-8B"} 0.75` + "\n" +
+			`process_cpu_seconds_total 12.5` + "\n"))
+	}))
+	defer server.Close()
+
+	metrics := Metrics{
+		RequestsRunning:    gauge("running"),
+		RequestsWaiting:    gauge("waiting"),
+		KVCacheUsage:       gauge("kv"),
+		PrefixCacheHitRate: gauge("hits"),
+	}
+	collector := New(server.URL+"/v1", "default", metrics)
+	stats, err := collector.Scrape(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := stats["meta-llama/Llama-3.1-8B"]
+	if !ok || len(stats) != 1 {
+		t.Fatalf("unexpected models %+v", stats)
+	}
+	if got.RequestsRunning != 3 || got.RequestsWaiting != 2 || got.KVCacheUsage != 0.4 || got.PrefixCacheHitRate != 0.75 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+	if v := testutil.ToFloat64(metrics.RequestsWaiting.WithLabelValues("meta-llama/Llama-3.1-8B")); v != 2 {
+		t.Errorf("waiting gauge = %v, want 2", v)
+	}
+	if latest, ok := collector.Latest("meta-llama/Llama-3.1-8B"); !ok || latest.RequestsRunning != 3 {
+		t.Errorf("Latest = %+v, %v", latest, ok)
+	}
+}
+
+func TestScrapeComputesHitRateFromCounters(t *testing.T) {
+	hits, queries := "30", "100"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("vllm:kv_cache_usage_perc 0.1\n" +
+			"vllm:prefix_cache_hits_total " + hits + "\n" +
+			"vllm:prefix_cache_queries_total " + queries + "\n"))
+	}))
+	defer server.Close()
+
+	collector := New(server.URL, "ai/qwen", Metrics{})
+	stats, err := collector.Scrape(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := stats["ai/qwen"].PrefixCacheHitRate; rate != 0.3 {
+		t.Errorf("first scrape hit rate = %v, want lifetime ratio 0.3", rate)
+	}
+
+	// 45 of the next 50 queries hit
+	hits, queries = "75", "150"
+	stats, err = collector.Scrape(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := stats["ai/qwen"].PrefixCacheHitRate; rate != 0.9 {
+		t.Errorf("interval hit rate = %v, want 0.9", rate)
+	}
+	if usage := stats["ai/qwen"].KVCacheUsage; usage != 0.1 {
+		t.Errorf("kv cache usage = %v, want 0.1", usage)
+	}
+}
+
+func TestScrapeReportsServerErrors(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if _, err := New(server.URL, "m", Metrics{}).Scrape(context.Background()); err == nil {
+		t.Error("expected an error for a missing /metrics endpoint")
+	}
+}
+
+func TestScrapeComputesSpecDecodeAcceptance(t *testing.T) {
+	accepted, drafted := "60", "100"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`vllm:spec_decode_num_accepted_tokens_total{model_name="m"} ` + accepted + "\n" +
+			`vllm:spec_decode_num_draft_tokens_total{model_name="m"} ` + drafted + "\n"))
+	}))
+	defer server.Close()
+
+	metrics := Metrics{SpecDecodeAcceptanceRate: gauge("acceptance")}
+	collector := New(server.URL, "m", metrics)
+	if _, err := collector.Scrape(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 10 of the next 50 draft tokens were accepted
+	accepted, drafted = "70", "150"
+	stats, err := collector.Scrape(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := stats["m"].SpecDecodeAcceptanceRate; rate != 0.2 {
+		t.Errorf("acceptance rate = %v, want 0.2", rate)
+	}
+	if v := testutil.ToFloat64(metrics.SpecDecodeAcceptanceRate.WithLabelValues("m")); v != 0.2 {
+		t.Errorf("acceptance gauge = %v, want 0.2", v)
+	}
+}
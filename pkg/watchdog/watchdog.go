@@ -0,0 +1,158 @@
+package watchdog
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kill reasons reported in logs and the kill counter
+const (
+	ReasonMaxDuration = "max_duration"
+	ReasonMaxTokens   = "max_tokens"
+)
+
+// Watchdog force-cancels generations that exceed an absolute duration or
+// token budget, independently of whether the client is still connected
+type Watchdog struct {
+	maxDuration time.Duration
+	maxTokens   int64
+	kills       *prometheus.CounterVec // labels: reason, model
+
+	mu     sync.Mutex
+	active map[string]*Generation
+}
+
+// Generation is a single supervised generation
+type Generation struct {
+	ID      string
+	Model   string
+	Started time.Time
+
+	watchdog *Watchdog
+	cancel   context.CancelFunc
+	tokens   atomic.Int64
+	reason   atomic.Value // string
+	once     sync.Once
+}
+
+// New creates a watchdog. A zero maxDuration or maxTokens disables that limit.
+func New(maxDuration time.Duration, maxTokens int, kills *prometheus.CounterVec) *Watchdog {
+	return &Watchdog{
+		maxDuration: maxDuration,
+		maxTokens:   int64(maxTokens),
+		kills:       kills,
+		active:      make(map[string]*Generation),
+	}
+}
+
+// Track starts supervising a generation. The returned context is cancelled
+// when the watchdog kills the generation; callers must call Done when finished.
+func (w *Watchdog) Track(ctx context.Context, model string) (context.Context, *Generation) {
+	ctx, cancel := context.WithCancel(ctx)
+	g := &Generation{
+		ID:       uuid.New().String(),
+		Model:    model,
+		Started:  time.Now(),
+		watchdog: w,
+		cancel:   cancel,
+	}
+
+	w.mu.Lock()
+	w.active[g.ID] = g
+	w.mu.Unlock()
+
+	return ctx, g
+}
+
+// Start sweeps active generations for duration overruns until ctx is cancelled
+func (w *Watchdog) Start(ctx context.Context, interval time.Duration) {
+	if w.maxDuration <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.sweep()
+			}
+		}
+	}()
+}
+
+// sweep kills every generation that has run longer than the maximum duration
+func (w *Watchdog) sweep() {
+	w.mu.Lock()
+	var overdue []*Generation
+	for _, g := range w.active {
+		if time.Since(g.Started) > w.maxDuration {
+			overdue = append(overdue, g)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, g := range overdue {
+		g.kill(ReasonMaxDuration)
+	}
+}
+
+// Active returns the number of generations being supervised
+func (w *Watchdog) Active() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.active)
+}
+
+// AddTokens records generated tokens and kills the generation if it exceeds
+// the token budget
+func (g *Generation) AddTokens(n int) {
+	total := g.tokens.Add(int64(n))
+	if g.watchdog.maxTokens > 0 && total > g.watchdog.maxTokens {
+		g.kill(ReasonMaxTokens)
+	}
+}
+
+// KillReason returns why the watchdog killed the generation, or "" if it didn't
+func (g *Generation) KillReason() string {
+	reason, _ := g.reason.Load().(string)
+	return reason
+}
+
+// Done stops supervising the generation and releases its context
+func (g *Generation) Done() {
+	g.watchdog.mu.Lock()
+	delete(g.watchdog.active, g.ID)
+	g.watchdog.mu.Unlock()
+	g.cancel()
+}
+
+// kill cancels the generation once, logging and counting the reason
+func (g *Generation) kill(reason string) {
+	g.once.Do(func() {
+		g.reason.Store(reason)
+		g.cancel()
+
+		if g.watchdog.kills != nil {
+			g.watchdog.kills.WithLabelValues(reason, g.Model).Inc()
+		}
+
+		log := logger.GetLogger()
+		log.Warn().
+			Str("generation_id", g.ID).
+			Str("model", g.Model).
+			Str("reason", reason).
+			Dur("elapsed", time.Since(g.Started)).
+			Int64("tokens", g.tokens.Load()).
+			Msg("Watchdog killed generation")
+	})
+}
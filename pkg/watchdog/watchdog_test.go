@@ -0,0 +1,77 @@
+package watchdog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWatchdogKillsOverTokenBudget(t *testing.T) {
+	kills := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "kills"}, []string{"reason", "model"})
+	w := New(0, 100, kills)
+	ctx, g := w.Track(context.Background(), "ai/llama3.2")
+	defer g.Done()
+
+	g.AddTokens(60)
+	g.AddTokens(40)
+	if ctx.Err() != nil || g.KillReason() != "" {
+		t.Fatal("expected a generation at its budget to keep running")
+	}
+	g.AddTokens(1)
+	g.AddTokens(50)
+	if ctx.Err() == nil || g.KillReason() != ReasonMaxTokens {
+		t.Fatalf("expected a kill for max_tokens, got %q", g.KillReason())
+	}
+	if got := testutil.ToFloat64(kills.WithLabelValues(ReasonMaxTokens, "ai/llama3.2")); got != 1 {
+		t.Errorf("expected the kill counted once, got %v", got)
+	}
+}
+
+func TestWatchdogKillsOverDuration(t *testing.T) {
+	kills := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "kills"}, []string{"reason", "model"})
+	w := New(50*time.Millisecond, 0, kills)
+	stop, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w.Start(stop, 10*time.Millisecond)
+
+	slow, g := w.Track(context.Background(), "ai/gemma3")
+	defer g.Done()
+	fast, quick := w.Track(context.Background(), "ai/gemma3")
+	quick.Done()
+
+	select {
+	case <-slow.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the overrunning generation to be killed")
+	}
+	if g.KillReason() != ReasonMaxDuration {
+		t.Errorf("kill reason %q, want %s", g.KillReason(), ReasonMaxDuration)
+	}
+	if quick.KillReason() != "" || fast.Err() != context.Canceled {
+		t.Errorf("expected a finished generation released but not killed, got %q", quick.KillReason())
+	}
+	if got := testutil.ToFloat64(kills.WithLabelValues(ReasonMaxDuration, "ai/gemma3")); got != 1 {
+		t.Errorf("expected one duration kill, got %v", got)
+	}
+}
+
+func TestWatchdogTracksActiveGenerations(t *testing.T) {
+	w := New(0, 0, nil)
+	_, a := w.Track(context.Background(), "m")
+	_, b := w.Track(context.Background(), "m")
+	if w.Active() != 2 {
+		t.Fatalf("expected 2 active generations, got %d", w.Active())
+	}
+	a.AddTokens(1 << 20)
+	if a.KillReason() != "" {
+		t.Error("expected no token limit when maxTokens is zero")
+	}
+	a.Done()
+	b.Done()
+	if w.Active() != 0 {
+		t.Errorf("expected no active generations, got %d", w.Active())
+	}
+}
@@ -0,0 +1,232 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/events"
+	"github.com/ajeetraina/aiwatch/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Headers sent with every delivery
+const (
+	EventHeader     = "X-Aiwatch-Event"
+	DeliveryHeader  = "X-Aiwatch-Delivery" // the event ID, the same on every retry
+	SignatureHeader = "X-Aiwatch-Signature"
+)
+
+// Config configures the endpoints and which events they receive
+type Config struct {
+	URLs []string
+	// Secret signs each payload; deliveries are unsigned without one
+	Secret string
+	// Events are the event types delivered, completions and errors by default
+	Events    []string
+	QueueSize int
+	Workers   int
+}
+
+// Metrics holds the collectors the dispatcher reports to
+type Metrics struct {
+	Deliveries *prometheus.CounterVec   // labels: endpoint, type, result (delivered, failed or dropped)
+	Latency    *prometheus.HistogramVec // labels: endpoint; retries included
+}
+
+// delivery is one event bound for one endpoint
+type delivery struct {
+	url   string
+	event events.Event
+}
+
+// Dispatcher posts signed events to webhook endpoints in the background.
+// Its client is expected to retry transient failures; see resilience.Transport.
+type Dispatcher struct {
+	config  Config
+	client  *http.Client
+	metrics Metrics
+	types   map[string]bool
+	queue   chan delivery
+	wg      sync.WaitGroup
+}
+
+// New creates a dispatcher. A nil client uses one with a timeout and no
+// retries.
+func New(config Config, client *http.Client, metrics Metrics) *Dispatcher {
+	if len(config.Events) == 0 {
+		config.Events = []string{events.CompletionFinished, events.Error}
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 256
+	}
+	if config.Workers <= 0 {
+		config.Workers = 2
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	d := &Dispatcher{
+		config:  config,
+		client:  client,
+		metrics: metrics,
+		types:   make(map[string]bool),
+		queue:   make(chan delivery, config.QueueSize),
+	}
+	for _, eventType := range config.Events {
+		d.types[eventType] = true
+	}
+	return d
+}
+
+// Start launches the delivery workers
+func (d *Dispatcher) Start(ctx context.Context) {
+	for i := 0; i < d.config.Workers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for job := range d.queue {
+				d.deliver(ctx, job)
+			}
+		}()
+	}
+}
+
+// Close stops accepting events and waits for queued deliveries
+func (d *Dispatcher) Close() {
+	close(d.queue)
+	d.wg.Wait()
+}
+
+// Publish queues an event for every endpoint, if its type is delivered
+func (d *Dispatcher) Publish(eventType string, data interface{}) {
+	if !d.types[eventType] {
+		return
+	}
+	event, err := events.New(eventType, data)
+	if err != nil {
+		log := logger.GetLogger()
+		log.Error().Err(err).Str("type", eventType).Msg("Failed to encode webhook event")
+		return
+	}
+	event.Schema = eventType + "/" + events.SchemaVersion
+
+	for _, target := range d.config.URLs {
+		select {
+		case d.queue <- delivery{url: target, event: event}:
+		default:
+			d.count(target, eventType, "dropped")
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, job delivery) {
+	start := time.Now()
+	err := d.post(ctx, job)
+	if d.metrics.Latency != nil {
+		d.metrics.Latency.WithLabelValues(endpoint(job.url)).Observe(time.Since(start).Seconds())
+	}
+	if err != nil {
+		log := logger.GetLogger()
+		log.Warn().Err(err).Str("endpoint", endpoint(job.url)).Str("type", job.event.Type).Str("delivery", job.event.ID).Msg("Webhook delivery failed")
+		d.count(job.url, job.event.Type, "failed")
+		return
+	}
+	d.count(job.url, job.event.Type, "delivered")
+}
+
+func (d *Dispatcher) post(ctx context.Context, job delivery) error {
+	body, err := json.Marshal(job.event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "aiwatch-webhooks")
+	req.Header.Set(EventHeader, job.event.Type)
+	req.Header.Set(DeliveryHeader, job.event.ID)
+	if d.config.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(d.config.Secret, time.Now(), body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (d *Dispatcher) count(target, eventType, result string) {
+	if d.metrics.Deliveries != nil {
+		d.metrics.Deliveries.WithLabelValues(endpoint(target), eventType, result).Inc()
+	}
+}
+
+// endpoint labels a webhook by host, so paths carrying tokens stay out of
+// metrics and logs
+func endpoint(target string) string {
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "invalid"
+}
+
+// Sign returns the signature header value for body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">".
+// Receivers recompute it with the shared secret and reject old timestamps
+// to stop replays.
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + mac(secret, timestamp, body)
+}
+
+// Verify checks a signature header against body, accepting timestamps up
+// to tolerance old
+func Verify(secret, header string, body []byte, tolerance time.Duration) error {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signature == "" {
+		return fmt.Errorf("malformed signature header")
+	}
+	if age := time.Since(time.Unix(seconds, 0)); tolerance > 0 && age > tolerance {
+		return fmt.Errorf("signature is %s old", age.Round(time.Second))
+	}
+	if !hmac.Equal([]byte(signature), []byte(mac(secret, timestamp, body))) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+func mac(secret, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
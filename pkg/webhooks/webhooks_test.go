@@ -0,0 +1,128 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ajeetraina/aiwatch/pkg/events"
+	"github.com/ajeetraina/aiwatch/pkg/resilience"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func testMetrics() Metrics {
+	return Metrics{
+		Deliveries: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "deliveries"}, []string{"endpoint", "type", "result"}),
+		Latency:    prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency"}, []string{"endpoint"}),
+	}
+}
+
+func TestDispatcherPostsSignedEvents(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan received, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{r.Header.Clone(), body}
+	}))
+	defer server.Close()
+
+	metrics := testMetrics()
+	d := New(Config{URLs: []string{server.URL + "/hook"}, Secret: "s3cret"}, nil, metrics)
+	d.Start(context.Background())
+	d.Publish(events.RequestReceived, events.Lifecycle{Model: "m"}) // not subscribed
+	d.Publish(events.CompletionFinished, events.Lifecycle{Model: "m", TokensOut: 5})
+	d.Close()
+
+	if len(got) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(got))
+	}
+	delivery := <-got
+	if err := Verify("s3cret", delivery.header.Get(SignatureHeader), delivery.body, time.Minute); err != nil {
+		t.Errorf("signature did not verify: %v", err)
+	}
+	if err := Verify("other", delivery.header.Get(SignatureHeader), delivery.body, time.Minute); err == nil {
+		t.Error("signature verified with the wrong secret")
+	}
+	var event events.Event
+	if err := json.Unmarshal(delivery.body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != events.CompletionFinished || delivery.header.Get(DeliveryHeader) != event.ID || delivery.header.Get(EventHeader) != event.Type {
+		t.Errorf("unexpected delivery %+v with headers %v", event, delivery.header)
+	}
+
+	host := endpoint(server.URL)
+	if v := testutil.ToFloat64(metrics.Deliveries.WithLabelValues(host, events.CompletionFinished, "delivered")); v != 1 {
+		t.Errorf("delivered = %v, want 1", v)
+	}
+}
+
+func TestDispatcherRetriesThroughResilienceTransport(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if body, _ := io.ReadAll(r.Body); len(body) == 0 {
+			t.Error("retry sent an empty body")
+		}
+	}))
+	defer server.Close()
+
+	retries := resilience.DefaultConfig()
+	retries.MaxRetries, retries.BaseDelay = 3, time.Millisecond
+	client := &http.Client{Transport: resilience.NewTransport(nil, retries, resilience.Metrics{})}
+	metrics := testMetrics()
+	d := New(Config{URLs: []string{server.URL}, Events: []string{events.Error}}, client, metrics)
+	d.Start(context.Background())
+	d.Publish(events.Error, events.Lifecycle{ErrorCode: "upstream_timeout"})
+	d.Close()
+
+	if attempts.Load() != 3 {
+		t.Errorf("attempts = %d, want 3", attempts.Load())
+	}
+	if v := testutil.ToFloat64(metrics.Deliveries.WithLabelValues(endpoint(server.URL), events.Error, "delivered")); v != 1 {
+		t.Errorf("delivered = %v, want 1", v)
+	}
+}
+
+func TestDispatcherCountsFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	metrics := testMetrics()
+	d := New(Config{URLs: []string{server.URL}}, nil, metrics)
+	d.Start(context.Background())
+	d.Publish(events.Error, events.Lifecycle{})
+	d.Close()
+
+	if v := testutil.ToFloat64(metrics.Deliveries.WithLabelValues(endpoint(server.URL), events.Error, "failed")); v != 1 {
+		t.Errorf("failed = %v, want 1", v)
+	}
+}
+
+func TestVerifyRejectsOldSignatures(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	header := Sign("k", time.Now().Add(-10*time.Minute), body)
+	if err := Verify("k", header, body, 5*time.Minute); err == nil {
+		t.Error("expected an old signature to be rejected")
+	}
+	if err := Verify("k", header, body, 0); err != nil {
+		t.Errorf("without a tolerance: %v", err)
+	}
+	if err := Verify("k", "v1=abc", body, 0); err == nil {
+		t.Error("expected a malformed header to be rejected")
+	}
+}
@@ -0,0 +1,126 @@
+// Package window reports what happened over the past few minutes or hours
+// from counters that only ever grow. Totals since the process started say
+// little about current behaviour once it has been up for days; a Ring keeps
+// periodic snapshots of the totals, so the change across any recent window
+// is the current totals minus the snapshot taken at its start.
+package window
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Totals are cumulative counts since the process started
+type Totals struct {
+	Requests  float64
+	Errors    float64
+	TTFTSum   float64 // seconds to first token, summed over TTFTCount generations
+	TTFTCount float64
+}
+
+// Stats describe one window
+type Stats struct {
+	Requests    float64 `json:"requests"`
+	ErrorRate   float64 `json:"errorRate"`
+	AverageTTFT float64 `json:"averageTimeToFirstToken"` // seconds, 0 without generations
+	// Seconds is the time the figures cover: the window, or less while the
+	// process is younger than it, rounded to the snapshot step
+	Seconds float64 `json:"seconds"`
+}
+
+type snapshot struct {
+	at     time.Time
+	totals Totals
+}
+
+// Ring holds snapshots of Totals taken every step over a span
+type Ring struct {
+	read func() Totals
+	step time.Duration
+
+	mu        sync.Mutex
+	snapshots []snapshot
+	next      int // index the next snapshot is written to
+	size      int
+}
+
+// New creates a ring that snapshots read every step and keeps enough of them
+// to report windows up to span long
+func New(read func() Totals, step, span time.Duration) *Ring {
+	return &Ring{
+		read: read,
+		step: step,
+		// One more than span/step so a snapshot at least span old is kept
+		snapshots: make([]snapshot, int(span/step)+2),
+	}
+}
+
+// Start takes a snapshot now and then every step until ctx is done
+func (r *Ring) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.step)
+		defer ticker.Stop()
+		for {
+			r.Record()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Record takes a snapshot of the current totals
+func (r *Ring) Record() {
+	r.record(time.Now(), r.read())
+}
+
+func (r *Ring) record(now time.Time, totals Totals) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshots[r.next] = snapshot{at: now, totals: totals}
+	r.next = (r.next + 1) % len(r.snapshots)
+	if r.size < len(r.snapshots) {
+		r.size++
+	}
+}
+
+// Window returns the stats for the past d
+func (r *Ring) Window(d time.Duration) Stats {
+	return r.window(time.Now(), r.read(), d)
+}
+
+func (r *Ring) window(now time.Time, current Totals, d time.Duration) Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size == 0 {
+		return Stats{}
+	}
+
+	// Start from the newest snapshot at least d old, or the oldest there is
+	cutoff := now.Add(-d)
+	oldest := (r.next - r.size + len(r.snapshots)) % len(r.snapshots)
+	base := r.snapshots[oldest]
+	for i := 1; i < r.size; i++ {
+		s := r.snapshots[(oldest+i)%len(r.snapshots)]
+		if s.at.After(cutoff) {
+			break
+		}
+		base = s
+	}
+
+	stats := Stats{
+		Requests: current.Requests - base.totals.Requests,
+		Seconds:  now.Sub(base.at).Seconds(),
+	}
+	if stats.Requests > 0 {
+		stats.ErrorRate = (current.Errors - base.totals.Errors) / stats.Requests
+	}
+	if count := current.TTFTCount - base.totals.TTFTCount; count > 0 {
+		stats.AverageTTFT = (current.TTFTSum - base.totals.TTFTSum) / count
+	}
+	return stats
+}
@@ -0,0 +1,62 @@
+package window
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	r := New(nil, time.Minute, 10*time.Minute)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// A busy, failing first half hour, then a quiet healthy stretch
+	var totals Totals
+	for i := 0; i <= 40; i++ {
+		if i <= 30 {
+			totals.Requests += 100
+			totals.Errors += 50
+			totals.TTFTSum += 100 * 2
+			totals.TTFTCount += 100
+		} else {
+			totals.Requests += 10
+			totals.TTFTSum += 10 * 0.1
+			totals.TTFTCount += 10
+		}
+		r.record(start.Add(time.Duration(i)*time.Minute), totals)
+	}
+	now := start.Add(40*time.Minute + 30*time.Second)
+
+	got := r.window(now, totals, 5*time.Minute)
+	want := Stats{Requests: 50, ErrorRate: 0, AverageTTFT: 0.1, Seconds: 330}
+	if !equal(got, want) {
+		t.Errorf("5m window = %+v, want %+v", got, want)
+	}
+
+	// Longer than the ring holds: the oldest snapshot, taken before the last
+	// failing minute, bounds it
+	got = r.window(now, totals, time.Hour)
+	want = Stats{Requests: 200, ErrorRate: 0.25, AverageTTFT: 1.05, Seconds: 690}
+	if !equal(got, want) {
+		t.Errorf("1h window = %+v, want %+v", got, want)
+	}
+}
+
+func TestWindowYoungProcess(t *testing.T) {
+	r := New(nil, time.Minute, time.Hour)
+	if got := r.window(time.Now(), Totals{Requests: 5}, time.Hour); got != (Stats{}) {
+		t.Errorf("window without snapshots = %+v, want zero", got)
+	}
+
+	start := time.Now()
+	r.record(start, Totals{})
+	got := r.window(start.Add(90*time.Second), Totals{Requests: 4, Errors: 1}, 5*time.Minute)
+	if got.Requests != 4 || got.ErrorRate != 0.25 || got.Seconds != 90 || got.AverageTTFT != 0 {
+		t.Errorf("window = %+v, want 4 requests, 25%% errors over 90s", got)
+	}
+}
+
+func equal(a, b Stats) bool {
+	near := func(x, y float64) bool { return x-y < 1e-9 && y-x < 1e-9 }
+	return near(a.Requests, b.Requests) && near(a.ErrorRate, b.ErrorRate) &&
+		near(a.AverageTTFT, b.AverageTTFT) && near(a.Seconds, b.Seconds)
+}